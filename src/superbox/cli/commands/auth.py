@@ -14,6 +14,8 @@ from superbox.shared.config import Config, load_env
 AUTH_FILE = Path.home() / ".superbox" / "auth.json"
 IDENTITY_BASE_URL = "https://identitytoolkit.googleapis.com/v1"
 SECURE_TOKEN_URL = "https://securetoken.googleapis.com/v1/token"
+DEVICE_POLL_WAIT = 20
+DEVICE_CLIENT_ID = "superbox-cli"
 
 
 def _env_load() -> None:
@@ -52,6 +54,20 @@ def _identity_url(endpoint: str, api_key: str) -> str:
     return f"{IDENTITY_BASE_URL}/{endpoint}?key={api_key}"
 
 
+def _slow_down_interval(response: requests.Response, interval: int) -> int:
+    """Return the poll interval a slow_down response asks for"""
+    try:
+        details = response.json().get("error", {}).get("details", {})
+        if details.get("interval"):
+            return int(details["interval"])
+    except Exception:
+        pass
+    retry_after = response.headers.get("Retry-After", "")
+    if retry_after.isdigit():
+        return int(retry_after)
+    return interval + 5
+
+
 def _error_text(response: requests.Response) -> str:
     """Extract error message from HTTP response"""
     try:
@@ -104,7 +120,7 @@ def _login_email(cfg: Config, email: Optional[str], password: Optional[str]) ->
 
 
 def _device_login(cfg: Config, provider: str) -> None:
-    """Authenticate user via OAuth device code flow (Google/GitHub)"""
+    """Authenticate user via OAuth device code flow (Google/GitHub/GitLab/Microsoft)"""
     api_url = cfg.SUPERBOX_API_URL
     if not api_url:
         raise RuntimeError("SUPERBOX_API_URL is required for OAuth device login.")
@@ -113,7 +129,7 @@ def _device_login(cfg: Config, provider: str) -> None:
     try:
         response = requests.post(
             f"{base_url}/auth/device/start",
-            json={"provider": provider},
+            json={"provider": provider, "client_id": DEVICE_CLIENT_ID},
             timeout=30,
         )
     except requests.RequestException as exc:
@@ -157,17 +173,28 @@ def _device_login(cfg: Config, provider: str) -> None:
         if time.time() - start_time >= expires_in:
             raise RuntimeError("Device authorization timed out.")
 
+        # The server holds a pending poll for up to DEVICE_POLL_WAIT seconds and
+        # answers as soon as the browser step is done.
+        polled_at = time.time()
         try:
             poll_response = requests.post(
                 f"{base_url}/auth/device/poll",
-                json={"device_code": device_code},
+                json={"device_code": device_code, "wait": DEVICE_POLL_WAIT},
                 timeout=30,
             )
         except requests.RequestException as exc:
             raise RuntimeError(f"Device polling failed: {exc}") from exc
 
         if poll_response.status_code == 202:
-            time.sleep(interval)
+            interval = poll_response.json().get("interval", interval)
+            time.sleep(max(0.0, interval - (time.time() - polled_at)))
+            continue
+
+        if poll_response.status_code == 429:
+            # slow_down: the server raised the interval because polls came
+            # too fast; wait the new interval before polling again.
+            interval = _slow_down_interval(poll_response, interval)
+            time.sleep(max(0.0, interval - (time.time() - polled_at)))
             continue
 
         if poll_response.status_code == 200:
@@ -235,7 +262,7 @@ def register(email: str, password: str) -> None:
 @auth.command()
 @click.option(
     "--provider",
-    type=click.Choice(["email", "google", "github"], case_sensitive=False),
+    type=click.Choice(["email", "google", "github", "gitlab", "microsoft"], case_sensitive=False),
     default="email",
     show_default=True,
     help="Authentication provider to use",
@@ -258,6 +285,10 @@ def login(provider: str, email: Optional[str], password: Optional[str]) -> None:
             _device_login(cfg, "google")
         elif provider_name == "github":
             _device_login(cfg, "github")
+        elif provider_name == "gitlab":
+            _device_login(cfg, "gitlab")
+        elif provider_name == "microsoft":
+            _device_login(cfg, "microsoft")
         else:
             raise RuntimeError(f"Unsupported provider '{provider_name}'.")
     except Exception as exc:
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type authResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (a authResponse) tokens() Tokens {
+	tokens := Tokens{IDToken: a.IDToken, RefreshToken: a.RefreshToken}
+	if a.ExpiresIn > 0 {
+		tokens.ExpiresAt = time.Now().Add(time.Duration(a.ExpiresIn) * time.Second)
+	}
+	return tokens
+}
+
+// signIn stores the tokens from a login-style response on the client.
+func (c *Client) signIn(ctx context.Context, path string, body interface{}) (Tokens, error) {
+	var resp authResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: path, body: body}, &resp); err != nil {
+		return Tokens{}, err
+	}
+	tokens := resp.tokens()
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+func (c *Client) Register(ctx context.Context, email string, password string, displayName string) (Tokens, error) {
+	body := map[string]interface{}{"email": email, "password": password}
+	if displayName != "" {
+		body["display_name"] = displayName
+	}
+	return c.signIn(ctx, "/api/v1/auth/register", body)
+}
+
+func (c *Client) Login(ctx context.Context, email string, password string) (Tokens, error) {
+	return c.signIn(ctx, "/api/v1/auth/login", map[string]string{"email": email, "password": password})
+}
+
+// Refresh exchanges the refresh token for a new ID token. The client calls
+// it automatically; it is exported for callers that want to refresh eagerly.
+func (c *Client) Refresh(ctx context.Context) (Tokens, error) {
+	current := c.Tokens()
+	if current.RefreshToken == "" {
+		return Tokens{}, ErrNotAuthenticated
+	}
+
+	var resp authResponse
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/auth/refresh", body: map[string]string{"refresh_token": current.RefreshToken}}, &resp)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	tokens := resp.tokens()
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = current.RefreshToken
+	}
+	c.SetTokens(tokens)
+	if c.onRefresh != nil {
+		c.onRefresh(tokens)
+	}
+	return tokens, nil
+}
+
+func (c *Client) Me(ctx context.Context) (*Profile, error) {
+	var profile Profile
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/auth/me", auth: true}, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// defaultDeviceClientID is the official CLI, which device logins start as
+// unless WithDeviceClient names another registered client.
+const defaultDeviceClientID = "superbox-cli"
+
+// WithDeviceClient starts device logins as a client registered with the
+// marketplace, asking for scope, a space-separated subset of the client's
+// scopes (all of them when empty). Unless the client holds the "full" scope,
+// WaitForDeviceLogin returns a scoped token that lasts an hour and cannot be
+// refreshed.
+func WithDeviceClient(clientID string, scope string) Option {
+	return func(c *Client) {
+		c.deviceClientID = clientID
+		c.deviceScope = scope
+	}
+}
+
+// StartDeviceLogin begins a device login with "google", "github", "gitlab",
+// or "microsoft". Show the user VerificationURIComplete (or VerificationURI
+// and UserCode), then call WaitForDeviceLogin.
+func (c *Client) StartDeviceLogin(ctx context.Context, provider string) (*DeviceAuthorization, error) {
+	var device DeviceAuthorization
+	body := map[string]string{"provider": provider, "client_id": c.deviceClientID}
+	if c.deviceScope != "" {
+		body["scope"] = c.deviceScope
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/auth/device/start", body: body}, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// deviceLoginWait is how long each device poll asks the server to hold the
+// request, so the login completes as soon as the browser step does.
+const deviceLoginWait = 20
+
+// WaitForDeviceLogin polls until the user approves the device login, it
+// expires, or ctx is done, and signs the client in on success. Each poll
+// asks the server to hold it while the login is pending; servers that do not
+// hold polls are asked again after the advertised interval.
+func (c *Client) WaitForDeviceLogin(ctx context.Context, device *DeviceAuthorization) (Tokens, error) {
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	for {
+		var resp struct {
+			Status   string `json:"status"`
+			Interval int    `json:"interval"`
+			authResponse
+			// AccessToken is the scoped token a client without the full
+			// scope receives instead of ID and refresh tokens.
+			AccessToken string `json:"access_token"`
+		}
+		started := time.Now()
+		body := map[string]interface{}{"device_code": device.DeviceCode, "wait": deviceLoginWait}
+		err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/auth/device/poll", body: body}, &resp)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code == "slow_down" {
+			// The server raised the interval because polls came too fast.
+			if seconds, ok := apiErr.Details["interval"].(float64); ok && seconds > 0 {
+				interval = time.Duration(seconds) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+			resp.Status = "pending"
+		} else if err != nil {
+			return Tokens{}, err
+		}
+		if resp.Interval > 0 {
+			interval = time.Duration(resp.Interval) * time.Second
+		}
+		if resp.AccessToken != "" {
+			resp.IDToken, resp.RefreshToken = resp.AccessToken, ""
+		}
+		if resp.IDToken != "" {
+			tokens := resp.tokens()
+			c.SetTokens(tokens)
+			return tokens, nil
+		}
+		if resp.Status != "pending" {
+			return Tokens{}, errors.New("superbox: unexpected device poll response")
+		}
+
+		timer := time.NewTimer(interval - time.Since(started))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Tokens{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Package client is a Go client for the SuperBox registry API.
+//
+// A Client signs requests with the caller's tokens, refreshes the ID token
+// shortly before it expires (or once after a 401), and retries requests that
+// are safe to repeat when the server is unavailable or rate limiting. List
+// endpoints are exposed as iterators that follow the API's cursors.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://api.superbox.ai"
+
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 2
+	retryBaseDelay    = 200 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+	refreshMargin     = time.Minute
+)
+
+// Tokens are the credentials returned by login, registration, device
+// authorization, and refresh.
+type Tokens struct {
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tenant     string
+	userAgent  string
+	maxRetries int
+	onRefresh  func(Tokens)
+
+	// deviceClientID and deviceScope are what device logins start with.
+	deviceClientID string
+	deviceScope    string
+
+	// signingKeyID and signingSecret sign requests in place of tokens.
+	signingKeyID  string
+	signingSecret string
+	// apiKey is sent in place of tokens.
+	apiKey string
+
+	mutex  sync.Mutex
+	tokens Tokens
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client, for example to add a
+// proxy or change the timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTokens signs requests with previously saved tokens.
+func WithTokens(tokens Tokens) Option {
+	return func(c *Client) {
+		c.tokens = tokens
+	}
+}
+
+// WithAPIKey authenticates with an API key instead of ID tokens, for CI
+// pipelines that cannot complete a login. Create keys with
+// POST /api/v1/auth/api-keys; the key is only shown then, and reaches only
+// the routes and servers it was scoped to.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithTenant sends X-SuperBox-Tenant so a shared API host serves the named
+// tenant.
+func WithTenant(tenant string) Option {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithMaxRetries sets how many times a retryable request is repeated after
+// the first attempt. Zero disables retries.
+func WithMaxRetries(retries int) Option {
+	return func(c *Client) {
+		c.maxRetries = retries
+	}
+}
+
+// OnTokenRefresh is called with the new tokens whenever the client refreshes
+// them, so callers can persist them.
+func OnTokenRefresh(fn func(Tokens)) Option {
+	return func(c *Client) {
+		c.onRefresh = fn
+	}
+}
+
+// New returns a client for the API at baseURL (DefaultBaseURL when empty).
+func New(baseURL string, options ...Option) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		userAgent:  "superbox-go",
+		maxRetries: defaultMaxRetries,
+
+		deviceClientID: defaultDeviceClientID,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Tokens returns the client's current tokens.
+func (c *Client) Tokens() Tokens {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.tokens
+}
+
+// SetTokens replaces the client's tokens, for example after a login made
+// with another client.
+func (c *Client) SetTokens(tokens Tokens) {
+	c.mutex.Lock()
+	c.tokens = tokens
+	c.mutex.Unlock()
+}
+
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	auth   bool
+}
+
+// do sends req and decodes a successful JSON response into out. Requests
+// that need auth refresh the ID token first when it is about to expire, and
+// once more if the server still answers 401.
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	var payload []byte
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		payload = data
+	}
+
+	idempotencyKey := ""
+	if req.method == http.MethodPost || req.method == http.MethodPatch {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	token := ""
+	if req.auth && c.signingKeyID == "" && c.apiKey == "" {
+		var err error
+		if token, err = c.validToken(ctx); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.send(ctx, req, payload, token, idempotencyKey)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && req.auth && c.Tokens().RefreshToken != "" {
+		resp.Body.Close()
+		if _, err := c.Refresh(ctx); err != nil {
+			return err
+		}
+		if resp, err = c.send(ctx, req, payload, c.Tokens().IDToken, idempotencyKey); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+// send performs one logical request, retrying network errors, 429s, and 5xx
+// responses. GET, PUT, and DELETE are idempotent on the server; POST and
+// PATCH carry an Idempotency-Key, so their retries replay the first response
+// instead of repeating the side effect.
+func (c *Client) send(ctx context.Context, req request, payload []byte, token string, idempotencyKey string) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		httpReq.Header.Set("User-Agent", c.userAgent)
+		if token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+		if req.auth && c.signingKeyID != "" {
+			c.signRequest(httpReq, payload)
+		}
+		if req.auth && c.apiKey != "" {
+			httpReq.Header.Set("Authorization", "ApiKey "+c.apiKey)
+		}
+		if c.tenant != "" {
+			httpReq.Header.Set("X-SuperBox-Tenant", c.tenant)
+		}
+		if idempotencyKey != "" {
+			httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		retry := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		if !retry || attempt == c.maxRetries {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+
+		delay := retryBaseDelay << attempt
+		if resp != nil {
+			if after := retryAfter(resp.Header.Get("Retry-After")); after > 0 {
+				delay = after
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		lastErr = err
+
+		timer := time.NewTimer(min(delay, maxRetryDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil, lastErr
+}
+
+// validToken returns the ID token, refreshing it first when it expires
+// within refreshMargin.
+func (c *Client) validToken(ctx context.Context) (string, error) {
+	tokens := c.Tokens()
+	if tokens.IDToken == "" && tokens.RefreshToken == "" {
+		return "", ErrNotAuthenticated
+	}
+	if tokens.RefreshToken != "" && !tokens.ExpiresAt.IsZero() && time.Until(tokens.ExpiresAt) < refreshMargin {
+		refreshed, err := c.Refresh(ctx)
+		if err != nil {
+			return "", err
+		}
+		return refreshed.IDToken, nil
+	}
+	return tokens.IDToken, nil
+}
+
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
+
+func newIdempotencyKey() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAPI is an httptest server whose routes are plain handler funcs, with
+// every request it receives recorded by path.
+type testAPI struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests map[string][]*http.Request
+}
+
+func newTestAPI(t *testing.T, routes map[string]http.HandlerFunc) *testAPI {
+	t.Helper()
+	api := &testAPI{requests: make(map[string][]*http.Request)}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mutex.Lock()
+		api.requests[r.URL.Path] = append(api.requests[r.URL.Path], r)
+		api.mutex.Unlock()
+		route, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		route(w, r)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (a *testAPI) received(path string) []*http.Request {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.requests[path]
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]interface{}{"status": "error", "error": map[string]string{"code": code, "message": http.StatusText(status)}})
+}
+
+func TestClientRefreshesTokensBeforeExpiryAndAfterA401(t *testing.T) {
+	var mutex sync.Mutex
+	issued := 0
+	revoked := map[string]bool{}
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"POST /api/v1/auth/refresh": func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["refresh_token"] != "refresh-1" {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			mutex.Lock()
+			issued++
+			token := fmt.Sprintf("fresh-%d", issued)
+			mutex.Unlock()
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id_token": token, "expires_in": 3600})
+		},
+		"GET /api/v1/auth/me": func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !strings.HasPrefix(token, "fresh-") || revoked[token] {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"local_id": "uid-1"})
+		},
+	})
+
+	var refreshed []Tokens
+	c := New(api.URL,
+		WithTokens(Tokens{IDToken: "stale", RefreshToken: "refresh-1", ExpiresAt: time.Now().Add(10 * time.Second)}),
+		OnTokenRefresh(func(tokens Tokens) { refreshed = append(refreshed, tokens) }),
+	)
+	ctx := context.Background()
+
+	// The ID token expires within the refresh margin, so it is refreshed
+	// before the request is sent.
+	if profile, err := c.Me(ctx); err != nil || profile.LocalID != "uid-1" {
+		t.Fatalf("Me = %v, %v", profile, err)
+	}
+	if got := api.received("/api/v1/auth/me"); len(got) != 1 || got[0].Header.Get("Authorization") != "Bearer fresh-1" {
+		t.Fatalf("Me was sent %d times; want once with the refreshed token", len(got))
+	}
+	if len(refreshed) != 1 || refreshed[0].IDToken != "fresh-1" || refreshed[0].RefreshToken != "refresh-1" {
+		t.Errorf("OnTokenRefresh got %+v, want fresh-1 keeping the refresh token", refreshed)
+	}
+
+	// A token the server stops accepting is refreshed once and the request
+	// repeated.
+	mutex.Lock()
+	revoked["fresh-1"] = true
+	mutex.Unlock()
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatalf("Me after revocation: %v", err)
+	}
+	if c.Tokens().IDToken != "fresh-2" || len(refreshed) != 2 {
+		t.Errorf("tokens after a 401 = %+v, want fresh-2", c.Tokens())
+	}
+
+	// Without a refresh token the 401 is returned as it is.
+	bare := New(api.URL, WithTokens(Tokens{IDToken: "stale"}))
+	if _, err := bare.Me(ctx); !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Me without a refresh token = %v, want a 401 APIError", err)
+	}
+	if _, err := New(api.URL).Me(ctx); !errors.Is(err, ErrNotAuthenticated) {
+		t.Errorf("Me without tokens = %v, want ErrNotAuthenticated", err)
+	}
+}
+
+func TestClientRetriesUnavailableAndRateLimitedRequests(t *testing.T) {
+	var mutex sync.Mutex
+	failures := map[string]int{"/api/v1/auth/login": 2, "/api/v1/auth/me": 1}
+	fail := func(w http.ResponseWriter, r *http.Request, status int) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures[r.URL.Path] == 0 {
+			return false
+		}
+		failures[r.URL.Path]--
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		writeAPIError(w, status, "unavailable")
+		return true
+	}
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"POST /api/v1/auth/login": func(w http.ResponseWriter, r *http.Request) {
+			if !fail(w, r, http.StatusServiceUnavailable) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"id_token": "id-1", "refresh_token": "refresh-1", "expires_in": 3600})
+			}
+		},
+		"GET /api/v1/auth/me": func(w http.ResponseWriter, r *http.Request) {
+			if !fail(w, r, http.StatusTooManyRequests) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"local_id": "uid-1"})
+			}
+		},
+		"GET /api/v1/auth/missing": func(w http.ResponseWriter, r *http.Request) {
+			writeAPIError(w, http.StatusNotFound, "not_found")
+		},
+	})
+	c := New(api.URL)
+	ctx := context.Background()
+
+	// Every retry of a POST carries the first attempt's Idempotency-Key, so
+	// the server replays instead of repeating the login.
+	if tokens, err := c.Login(ctx, "dev@example.com", "secret"); err != nil || tokens.IDToken != "id-1" {
+		t.Fatalf("Login = %+v, %v", tokens, err)
+	}
+	logins := api.received("/api/v1/auth/login")
+	keys := []string{}
+	for _, login := range logins {
+		keys = append(keys, login.Header.Get("Idempotency-Key"))
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("login Idempotency-Keys = %v, want one key sent three times", keys)
+	}
+
+	// A 429 waits for Retry-After before the next attempt.
+	began := time.Now()
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatalf("Me after a 429: %v", err)
+	}
+	if waited := time.Since(began); waited < time.Second {
+		t.Errorf("retried after %s, want the 1s Retry-After", waited)
+	}
+
+	// Client errors are not retried, and retries can be turned off.
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/auth/missing"}, nil); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("missing route = %v, want a 404 APIError", err)
+	}
+	if got := len(api.received("/api/v1/auth/missing")); got != 1 {
+		t.Errorf("a 404 was sent %d times, want once", got)
+	}
+	mutex.Lock()
+	failures["/api/v1/auth/login"] = 1
+	mutex.Unlock()
+	once := New(api.URL, WithMaxRetries(0))
+	if _, err := once.Login(ctx, "dev@example.com", "secret"); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Errorf("Login without retries = %v, want the 503", err)
+	}
+}
+
+func TestServersIteratesEveryPage(t *testing.T) {
+	names := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+	failAfter := ""
+	api := newTestAPI(t, map[string]http.HandlerFunc{
+		"GET /api/v2/servers": func(w http.ResponseWriter, r *http.Request) {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			start := 0
+			if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+				if cursor == failAfter {
+					writeAPIError(w, http.StatusInternalServerError, "internal_error")
+					return
+				}
+				start = slices.Index(names, cursor) + 1
+			}
+			end := min(start+limit, len(names))
+			items := []map[string]string{}
+			for _, name := range names[start:end] {
+				items = append(items, map[string]string{"name": name})
+			}
+			page := map[string]interface{}{"limit": limit, "has_more": end < len(names)}
+			if end < len(names) {
+				page["next_cursor"] = names[end-1]
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"items": items, "page": page})
+		},
+	})
+	c := New(api.URL, WithMaxRetries(0))
+	ctx := context.Background()
+
+	listed := []string{}
+	for server, err := range c.Servers(ctx, &ListOptions{PageSize: 2}) {
+		if err != nil {
+			t.Fatalf("Servers: %v", err)
+		}
+		listed = append(listed, server.Name)
+	}
+	if !slices.Equal(listed, names) {
+		t.Errorf("listed %v, want %v", listed, names)
+	}
+	pages := api.received("/api/v2/servers")
+	if len(pages) != 3 || pages[0].URL.Query().Get("limit") != "2" || pages[2].URL.Query().Get("cursor") != "delta" {
+		t.Errorf("fetched %d pages, want 3 of 2 following the cursor", len(pages))
+	}
+
+	// Pages are fetched only as the loop reaches them.
+	for range c.Servers(ctx, &ListOptions{PageSize: 2}) {
+		break
+	}
+	if got := len(api.received("/api/v2/servers")); got != 4 {
+		t.Errorf("breaking after the first server fetched %d more pages, want 1", got-3)
+	}
+
+	// An error ends the iteration after the servers already yielded.
+	failAfter = "bravo"
+	listed = listed[:0]
+	var iterErr error
+	for server, err := range c.Servers(ctx, &ListOptions{PageSize: 2}) {
+		if err != nil {
+			iterErr = err
+			continue
+		}
+		listed = append(listed, server.Name)
+	}
+	if !slices.Equal(listed, names[:2]) || !IsStatus(iterErr, http.StatusInternalServerError) {
+		t.Errorf("listed %v then %v, want the first page then a 500", listed, iterErr)
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// SchemaVersion is the API schema this client was written against.
+const SchemaVersion = "2026-10-16"
+
+// Compatibility reports which schema and API versions the server speaks.
+// Callers such as the CLI can compare SchemaVersion with the client's own
+// and warn the user to upgrade.
+func (c *Client) Compatibility(ctx context.Context) (*Compatibility, error) {
+	var resp Compatibility
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v2/compatibility"}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Matches reports whether the server's schema is the one this client was
+// built for.
+func (c *Compatibility) Matches() bool {
+	return c.SchemaVersion == SchemaVersion
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNotAuthenticated is returned by calls that need a signed-in user when
+// the client has no tokens.
+var ErrNotAuthenticated = errors.New("superbox: not authenticated")
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is the error envelope the API returns for non-2xx responses.
+type APIError struct {
+	StatusCode int                    `json:"-"`
+	Code       string                 `json:"code"`
+	Message    string                 `json:"message"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	Fields     []FieldError           `json:"fields,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("superbox: %d %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("superbox: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsStatus reports whether err is an APIError with the given HTTP status,
+// e.g. IsStatus(err, http.StatusPaymentRequired) for servers that need a
+// purchase.
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var envelope struct {
+		Detail string    `json:"detail"`
+		Error  *APIError `json:"error"`
+	}
+	apiErr := &APIError{}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		apiErr = envelope.Error
+	} else if envelope.Detail != "" {
+		apiErr.Message = envelope.Detail
+	}
+
+	apiErr.StatusCode = resp.StatusCode
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return apiErr
+}
@@ -0,0 +1,3 @@
+module superbox/client
+
+go 1.25.3
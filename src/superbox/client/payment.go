@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+)
+
+// CreateOrder starts a purchase. The provider (Razorpay or Stripe) is chosen
+// by the server from the currency and the buyer's country.
+func (c *Client) CreateOrder(ctx context.Context, order CreateOrderRequest) (*CheckoutOrder, error) {
+	var resp struct {
+		Status   string        `json:"status"`
+		Order    CheckoutOrder `json:"order"`
+		KeyID    string        `json:"key_id"`
+		Provider string        `json:"provider"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/payment/create-order", body: order, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	resp.Order.Provider = resp.Provider
+	resp.Order.KeyID = resp.KeyID
+	resp.Order.Held = resp.Status == "held"
+	return &resp.Order, nil
+}
+
+// VerifyPayment confirms a completed checkout and returns the payment with
+// the entitlement it granted.
+func (c *Client) VerifyPayment(ctx context.Context, payment VerifyPaymentRequest) (*Payment, error) {
+	var resp struct {
+		Payment Payment `json:"payment"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/payment/verify-payment", body: payment, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Payment, nil
+}
+
+// CreatePaymentLink creates a shareable payment link for a server the
+// signed-in user publishes.
+func (c *Client) CreatePaymentLink(ctx context.Context, name string, link CreatePaymentLinkRequest) (*PaymentLink, error) {
+	var resp struct {
+		PaymentLink PaymentLink `json:"payment_link"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/servers/" + url.PathEscape(name) + "/payment-links", body: link, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.PaymentLink, nil
+}
+
+// PaymentLinks lists a server's payment links, newest first.
+func (c *Client) PaymentLinks(ctx context.Context, name string) ([]PaymentLink, error) {
+	var resp struct {
+		PaymentLinks []PaymentLink `json:"payment_links"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/servers/" + url.PathEscape(name) + "/payment-links", auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.PaymentLinks, nil
+}
+
+// DeactivatePaymentLink stops a payment link taking payments.
+func (c *Client) DeactivatePaymentLink(ctx context.Context, name string, linkID string) (*PaymentLink, error) {
+	var resp struct {
+		PaymentLink PaymentLink `json:"payment_link"`
+	}
+	path := "/api/v1/servers/" + url.PathEscape(name) + "/payment-links/" + url.PathEscape(linkID)
+	if err := c.do(ctx, request{method: http.MethodDelete, path: path, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.PaymentLink, nil
+}
+
+// VerifyPaymentLink settles a payment made through a payment link for the
+// signed-in user, and returns the payment with the entitlement it granted.
+func (c *Client) VerifyPaymentLink(ctx context.Context, linkID string, payment VerifyPaymentLinkRequest) (*Payment, error) {
+	var resp struct {
+		Payment Payment `json:"payment"`
+	}
+	path := "/api/v1/payment/payment-links/" + url.PathEscape(linkID) + "/verify"
+	if err := c.do(ctx, request{method: http.MethodPost, path: path, body: payment, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Payment, nil
+}
+
+// CheckEntitlements returns the signed-in user's access to each server, in
+// the order given, in one request. Up to 500 names can be checked at once.
+func (c *Client) CheckEntitlements(ctx context.Context, names []string) ([]EntitlementCheck, error) {
+	var resp struct {
+		Entitlements []EntitlementCheck `json:"entitlements"`
+	}
+	body := map[string][]string{"servers": names}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/payment/entitlements/batch", body: body, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entitlements, nil
+}
+
+// Entitlements iterates over the signed-in user's entitlements.
+func (c *Client) Entitlements(ctx context.Context, options *ListOptions) iter.Seq2[Entitlement, error] {
+	return paginate[Entitlement](c, ctx, "/api/v2/payment/entitlements", true, options)
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions controls paging for list iterators. PageSize is capped by the
+// server at 100; zero uses the server default.
+type ListOptions struct {
+	PageSize int
+}
+
+// paginate yields the items of a cursor-paginated v2 list endpoint, fetching
+// pages lazily. Iteration stops after the first error.
+func paginate[T any](c *Client, ctx context.Context, path string, auth bool, options *ListOptions) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := ""
+		for {
+			query := url.Values{}
+			if options != nil && options.PageSize > 0 {
+				query.Set("limit", strconv.Itoa(options.PageSize))
+			}
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+
+			var resp struct {
+				Items []T  `json:"items"`
+				Page  page `json:"page"`
+			}
+			if err := c.do(ctx, request{method: http.MethodGet, path: path, query: query, auth: auth}, &resp); err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range resp.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if !resp.Page.HasMore || resp.Page.NextCursor == "" {
+				return
+			}
+			cursor = resp.Page.NextCursor
+		}
+	}
+}
+
+// Servers iterates over every server in the registry in name order.
+//
+//	for server, err := range c.Servers(ctx, nil) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(server.Name)
+//	}
+func (c *Client) Servers(ctx context.Context, options *ListOptions) iter.Seq2[Server, error] {
+	return paginate[Server](c, ctx, "/api/v2/servers", false, options)
+}
+
+// ExportServers streams every server from the NDJSON export, decoding one at
+// a time as they arrive, so the registry is never held in memory at once.
+// Iteration stops after the first error, including an export the server
+// could not finish.
+func (c *Client) ExportServers(ctx context.Context) iter.Seq2[Server, error] {
+	return func(yield func(Server, error) bool) {
+		req := request{method: http.MethodGet, path: "/api/v1/servers/export.ndjson"}
+		resp, err := c.send(ctx, req, nil, "", "")
+		if err != nil {
+			yield(Server{}, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			yield(Server{}, decodeError(resp))
+			return
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var line struct {
+				Server
+				Error *APIError `json:"error"`
+			}
+			if err := decoder.Decode(&line); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(Server{}, fmt.Errorf("decode server export: %w", err))
+				return
+			}
+			if line.Error != nil {
+				line.Error.StatusCode = http.StatusInternalServerError
+				yield(Server{}, line.Error)
+				return
+			}
+			if !yield(line.Server, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) GetServer(ctx context.Context, name string) (*Server, error) {
+	var resp struct {
+		Data Server `json:"data"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v2/servers/" + url.PathEscape(name)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+func (c *Client) CreateServer(ctx context.Context, server CreateServerRequest) (*Server, error) {
+	var resp struct {
+		Server Server `json:"server"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/servers", body: server, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Server, nil
+}
+
+// LintServer checks a server payload the way CreateServer would, without
+// publishing it. A payload with problems is not an error; see LintResult.
+func (c *Client) LintServer(ctx context.Context, server CreateServerRequest) (*LintResult, error) {
+	var result LintResult
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/servers/lint", body: server}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) UpdateServer(ctx context.Context, name string, update UpdateServerRequest) (*Server, error) {
+	var resp struct {
+		Server Server `json:"server"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/api/v1/servers/" + url.PathEscape(name), body: update, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Server, nil
+}
+
+func (c *Client) DeleteServer(ctx context.Context, name string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/api/v1/servers/" + url.PathEscape(name), auth: true}, nil)
+}
+
+// Download returns where to fetch a server's source. Paid servers return an
+// APIError with status 402 until the user holds an entitlement; its
+// Details["purchase"] lists the plans on offer.
+func (c *Client) Download(ctx context.Context, name string) (*Download, error) {
+	var resp struct {
+		Download Download `json:"download"`
+	}
+	req := request{method: http.MethodGet, path: "/api/v1/servers/" + url.PathEscape(name) + "/download", auth: c.Tokens().IDToken != ""}
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Download, nil
+}
+
+// Advisories lists the vulnerability advisories filed against a server,
+// newest first. A non-empty version returns only those affecting it, which
+// is how a CLI warns about an installed version.
+func (c *Client) Advisories(ctx context.Context, name string, version string) ([]Advisory, error) {
+	var resp struct {
+		Advisories []Advisory `json:"advisories"`
+	}
+	query := url.Values{}
+	if version != "" {
+		query.Set("version", version)
+	}
+	req := request{method: http.MethodGet, path: "/api/v1/servers/" + url.PathEscape(name) + "/advisories", query: query, auth: c.Tokens().IDToken != ""}
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Advisories, nil
+}
+
+// Retention returns the artifact retention applied to a server's bundles.
+func (c *Client) Retention(ctx context.Context, name string) (*RetentionPolicy, error) {
+	return c.retention(ctx, http.MethodGet, name, nil)
+}
+
+// SetRetention replaces the registry's storage transitions for a server.
+// It needs a plan that allows retention overrides.
+func (c *Client) SetRetention(ctx context.Context, name string, transitions []StorageTransition) (*RetentionPolicy, error) {
+	return c.retention(ctx, http.MethodPut, name, map[string]interface{}{"transitions": transitions})
+}
+
+// ResetRetention goes back to the registry's storage transitions.
+func (c *Client) ResetRetention(ctx context.Context, name string) (*RetentionPolicy, error) {
+	return c.retention(ctx, http.MethodDelete, name, nil)
+}
+
+func (c *Client) retention(ctx context.Context, method string, name string, body interface{}) (*RetentionPolicy, error) {
+	var resp struct {
+		Retention RetentionPolicy `json:"retention"`
+	}
+	req := request{method: method, path: "/api/v1/servers/" + url.PathEscape(name) + "/retention", body: body, auth: true}
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Retention, nil
+}
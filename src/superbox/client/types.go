@@ -0,0 +1,316 @@
+package client
+
+import "time"
+
+type Repository struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type PricingPlan struct {
+	Name     string   `json:"name"`
+	Amount   float64  `json:"amount"`
+	Period   string   `json:"period"`
+	Features []string `json:"features,omitempty"`
+}
+
+type Pricing struct {
+	Currency      string        `json:"currency"`
+	Amount        float64       `json:"amount"`
+	Mode          string        `json:"mode,omitempty"`
+	MinimumAmount float64       `json:"minimum_amount,omitempty"`
+	Plans         []PricingPlan `json:"plans,omitempty"`
+}
+
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+type Server struct {
+	Name           string                 `json:"name"`
+	DisplayName    string                 `json:"display_name"`
+	PreviousNames  []string               `json:"previous_names,omitempty"`
+	Version        string                 `json:"version"`
+	Description    string                 `json:"description"`
+	Author         string                 `json:"author"`
+	Lang           string                 `json:"lang"`
+	License        string                 `json:"license"`
+	Entrypoint     string                 `json:"entrypoint"`
+	Repository     Repository             `json:"repository"`
+	Pricing        Pricing                `json:"pricing"`
+	Tools          []ToolDefinition       `json:"tools,omitempty"`
+	SecurityReport map[string]interface{} `json:"security_report,omitempty"`
+	CreatedAt      time.Time              `json:"created_at,omitzero"`
+	UpdatedAt      time.Time              `json:"updated_at,omitzero"`
+}
+
+type CreateServerRequest struct {
+	Name        string           `json:"name"`
+	DisplayName string           `json:"display_name,omitempty"`
+	Version     string           `json:"version"`
+	Description string           `json:"description"`
+	Author      string           `json:"author"`
+	Lang        string           `json:"lang"`
+	License     string           `json:"license"`
+	Entrypoint  string           `json:"entrypoint"`
+	Repository  Repository       `json:"repository"`
+	Pricing     Pricing          `json:"pricing"`
+	Tools       []ToolDefinition `json:"tools,omitempty"`
+	// BundleID is an uploaded bundle blob to publish the version with.
+	BundleID string `json:"bundle_id,omitempty"`
+}
+
+// UpdateServerRequest changes only the fields that are set.
+// LintIssue is one problem LintServer found. Rule names the failed check,
+// such as "semver" or "name_taken".
+type LintIssue struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// LintResult lists what publishing a payload would run into. Errors block a
+// publish; warnings do not.
+type LintResult struct {
+	Valid    bool        `json:"valid"`
+	Errors   []LintIssue `json:"errors"`
+	Warnings []LintIssue `json:"warnings"`
+}
+
+type UpdateServerRequest struct {
+	Name        *string           `json:"name,omitempty"`
+	DisplayName *string           `json:"display_name,omitempty"`
+	Version     *string           `json:"version,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Author      *string           `json:"author,omitempty"`
+	Lang        *string           `json:"lang,omitempty"`
+	License     *string           `json:"license,omitempty"`
+	Entrypoint  *string           `json:"entrypoint,omitempty"`
+	Repository  *Repository       `json:"repository,omitempty"`
+	Pricing     *Pricing          `json:"pricing,omitempty"`
+	Tools       *[]ToolDefinition `json:"tools,omitempty"`
+	// BundleID attaches a bundle to the version the update leaves current.
+	BundleID *string `json:"bundle_id,omitempty"`
+}
+
+// StorageTransition moves a bundle to StorageClass once its version has
+// been superseded for AfterDays.
+type StorageTransition struct {
+	AfterDays    int    `json:"after_days"`
+	StorageClass string `json:"storage_class"`
+}
+
+// RetentionPolicy is the artifact retention applied to a server's bundles.
+// Custom is false when the registry's own transitions apply.
+type RetentionPolicy struct {
+	ServerName         string              `json:"server_name"`
+	Custom             bool                `json:"custom"`
+	Transitions        []StorageTransition `json:"transitions"`
+	DraftRetentionDays int                 `json:"draft_retention_days"`
+	UpdatedAt          time.Time           `json:"updated_at,omitzero"`
+}
+
+type Download struct {
+	Name        string       `json:"name"`
+	Version     string       `json:"version"`
+	Lang        string       `json:"lang"`
+	Entrypoint  string       `json:"entrypoint"`
+	Repository  Repository   `json:"repository"`
+	ArchiveURL  string       `json:"archive_url"`
+	Entitlement *Entitlement `json:"entitlement,omitempty"`
+}
+
+// AdvisoryRange is a span of affected versions, from Introduced up to but
+// not including Fixed; either may be empty.
+type AdvisoryRange struct {
+	Introduced string `json:"introduced,omitempty"`
+	Fixed      string `json:"fixed,omitempty"`
+}
+
+type Advisory struct {
+	ID               string          `json:"id"`
+	ServerName       string          `json:"server_name"`
+	Summary          string          `json:"summary"`
+	Details          string          `json:"details,omitempty"`
+	Severity         string          `json:"severity"`
+	Aliases          []string        `json:"aliases,omitempty"`
+	References       []string        `json:"references,omitempty"`
+	Ranges           []AdvisoryRange `json:"ranges"`
+	AffectedRange    string          `json:"affected_range"`
+	AffectedVersions []string        `json:"affected_versions"`
+	PublishedAt      time.Time       `json:"published_at"`
+}
+
+type Entitlement struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	ServerName string    `json:"server_name"`
+	Plan       string    `json:"plan"`
+	Period     string    `json:"period"`
+	Amount     float64   `json:"amount"`
+	Currency   string    `json:"currency"`
+	Provider   string    `json:"provider"`
+	OrderID    string    `json:"order_id"`
+	PaymentID  string    `json:"payment_id"`
+	GrantedAt  time.Time `json:"granted_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	Status     string    `json:"status"`
+}
+
+// EntitlementCheck is the signed-in user's access to one server: "free",
+// "entitled", "grace" (a past-due subscription still in its grace period),
+// "purchase_required", or "not_found". MovedTo names the server's current
+// slug when it was asked for under an old one.
+type EntitlementCheck struct {
+	ServerName  string       `json:"server_name"`
+	MovedTo     string       `json:"moved_to,omitempty"`
+	Access      string       `json:"access"`
+	Entitlement *Entitlement `json:"entitlement,omitempty"`
+}
+
+type Profile struct {
+	Email         *string `json:"email,omitempty"`
+	LocalID       string  `json:"local_id"`
+	DisplayName   *string `json:"display_name,omitempty"`
+	EmailVerified bool    `json:"email_verified"`
+	Disabled      bool    `json:"disabled"`
+}
+
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	Interval                int    `json:"interval"`
+	ExpiresIn               int    `json:"expires_in"`
+}
+
+type CreateOrderRequest struct {
+	ServerName string  `json:"server_name"`
+	Plan       string  `json:"plan,omitempty"`
+	Amount     float64 `json:"amount,omitempty"`
+	Currency   string  `json:"currency,omitempty"`
+}
+
+// CheckoutOrder is the provider order to complete in Razorpay Checkout or,
+// when Provider is "stripe", with the PaymentIntent ClientSecret. Held is
+// set when the order was flagged for review and cannot be paid yet.
+type CheckoutOrder struct {
+	ID           string  `json:"id"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	Plan         string  `json:"plan"`
+	Period       string  `json:"period"`
+	Status       string  `json:"status,omitempty"`
+	ClientSecret string  `json:"client_secret,omitempty"`
+	Provider     string  `json:"-"`
+	KeyID        string  `json:"-"`
+	Held         bool    `json:"-"`
+}
+
+type VerifyPaymentRequest struct {
+	Provider          string `json:"provider,omitempty"`
+	RazorpayOrderID   string `json:"razorpay_order_id,omitempty"`
+	RazorpayPaymentID string `json:"razorpay_payment_id,omitempty"`
+	RazorpaySignature string `json:"razorpay_signature,omitempty"`
+	PaymentIntentID   string `json:"payment_intent_id,omitempty"`
+	ServerName        string `json:"server_name"`
+}
+
+type Payment struct {
+	ID          string       `json:"id"`
+	ServerName  string       `json:"server_name"`
+	Provider    string       `json:"provider,omitempty"`
+	Plan        string       `json:"plan,omitempty"`
+	Entitlement *Entitlement `json:"entitlement,omitempty"`
+}
+
+// PaymentLink is a provider-hosted checkout page a publisher shares to
+// invoice a customer. Each payment through it is recorded in OrderIDs.
+type PaymentLink struct {
+	ID             string    `json:"id"`
+	ServerName     string    `json:"server_name"`
+	Plan           string    `json:"plan"`
+	Period         string    `json:"period"`
+	Description    string    `json:"description,omitempty"`
+	Amount         float64   `json:"amount"`
+	CustomAmount   bool      `json:"custom_amount,omitempty"`
+	MinimumAmount  float64   `json:"minimum_amount,omitempty"`
+	Currency       string    `json:"currency"`
+	Provider       string    `json:"provider"`
+	ProviderLinkID string    `json:"provider_link_id"`
+	URL            string    `json:"url"`
+	CallbackURL    string    `json:"callback_url,omitempty"`
+	UsageLimit     int       `json:"usage_limit"`
+	UsageCount     int       `json:"usage_count"`
+	OrderIDs       []string  `json:"order_ids"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+	DeactivatedAt  time.Time `json:"deactivated_at,omitzero"`
+}
+
+type CreatePaymentLinkRequest struct {
+	Plan          string  `json:"plan,omitempty"`
+	Description   string  `json:"description,omitempty"`
+	Amount        float64 `json:"amount,omitempty"`
+	CustomAmount  bool    `json:"custom_amount,omitempty"`
+	MinimumAmount float64 `json:"minimum_amount,omitempty"`
+	Currency      string  `json:"currency,omitempty"`
+	UsageLimit    int     `json:"usage_limit,omitempty"`
+	ExpiresInDays int     `json:"expires_in_days,omitempty"`
+	CallbackURL   string  `json:"callback_url,omitempty"`
+}
+
+// VerifyPaymentLinkRequest holds the fields the provider appends to a
+// payment link's callback URL.
+type VerifyPaymentLinkRequest struct {
+	RazorpayPaymentID   string `json:"razorpay_payment_id,omitempty"`
+	RazorpayReferenceID string `json:"razorpay_payment_link_reference_id,omitempty"`
+	RazorpayLinkStatus  string `json:"razorpay_payment_link_status,omitempty"`
+	RazorpaySignature   string `json:"razorpay_signature,omitempty"`
+	CheckoutSessionID   string `json:"checkout_session_id,omitempty"`
+}
+
+type page struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+type SigningScheme struct {
+	Header               string `json:"header"`
+	Format               string `json:"format"`
+	Algorithm            string `json:"algorithm"`
+	SignedPayload        string `json:"signed_payload"`
+	ToleranceSeconds     int    `json:"tolerance_seconds"`
+	RotationGraceSeconds int    `json:"rotation_grace_seconds"`
+}
+
+// SigningKey is one key signing a webhook's deliveries. A "retiring" key was
+// replaced by a rotation and stops signing at ExpiresAt.
+type SigningKey struct {
+	ID        string    `json:"id"`
+	WebhookID string    `json:"webhook_id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+type SigningKeys struct {
+	Scheme SigningScheme `json:"scheme"`
+	Keys   []SigningKey  `json:"keys"`
+}
+
+type Compatibility struct {
+	SchemaVersion         string            `json:"schema_version"`
+	SchemaDigest          string            `json:"schema_digest"`
+	APIVersion            int               `json:"api_version"`
+	LatestAPIVersion      int               `json:"latest_api_version"`
+	SupportedAPIVersions  []int             `json:"supported_api_versions"`
+	DeprecatedAPIVersions []int             `json:"deprecated_api_versions"`
+	Sunset                map[string]string `json:"sunset,omitempty"`
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "t=<unix>,v1=<hex>[,v1=<hex>...]" on every
+	// webhook delivery.
+	SignatureHeader = "X-SuperBox-Signature"
+
+	// DefaultSignatureTolerance is how old a delivery's timestamp may be
+	// before VerifyWebhook rejects it as a possible replay.
+	DefaultSignatureTolerance = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSignature = errors.New("superbox: webhook signature does not match")
+	ErrSignatureExpired = errors.New("superbox: webhook timestamp is outside the tolerance")
+)
+
+// VerifyWebhook checks a delivery's body against its X-SuperBox-Signature
+// header. Pass every secret you currently accept: after a rotation the
+// server signs with both the new and the retiring secret for a grace period,
+// and any match is enough. A zero tolerance uses DefaultSignatureTolerance.
+//
+//	body, _ := io.ReadAll(r.Body)
+//	err := client.VerifyWebhook(body, r.Header.Get(client.SignatureHeader), 0, secret)
+func VerifyWebhook(payload []byte, header string, tolerance time.Duration, secrets ...string) error {
+	if tolerance <= 0 {
+		tolerance = DefaultSignatureTolerance
+	}
+
+	var timestamp int64
+	signatures := [][]byte{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = parsed
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	signed := strconv.FormatInt(timestamp, 10) + "." + string(payload)
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		expected := mac.Sum(nil)
+		for _, signature := range signatures {
+			if hmac.Equal(signature, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// SigningKeys describes how deliveries are signed and lists the keys that
+// currently sign the signed-in user's webhooks. Secrets are only returned
+// when a webhook is created or its secret is rotated.
+func (c *Client) SigningKeys(ctx context.Context) (*SigningKeys, error) {
+	var resp SigningKeys
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/webhooks/signing-keys", auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
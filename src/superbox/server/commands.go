@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/handlers"
+	"superbox/server/loadtest"
+	"superbox/server/seed"
+	"superbox/server/store"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "run the HTTP API (default)", serve},
+		{"migrate", "apply pending registry migrations", migrate},
+		{"seed", "load demo users, sample servers, and sandbox orders", seedRegistry},
+		{"config validate", "check configuration and dependency reachability", validateConfig},
+		{"index rebuild", "regenerate the registry index object from server records", rebuildIndex},
+		{"registry check", "check stored server records and repair legacy ones", checkRegistry},
+		{"loadtest", "drive a running server at a fixed rate and check latency budgets", loadTest},
+		{"help", "show this help", help},
+	}
+}
+
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serve(args)
+	}
+
+	if len(args) >= 2 {
+		if cmd, ok := findCommand(args[0] + " " + args[1]); ok {
+			return cmd.run(args[2:])
+		}
+	}
+	if cmd, ok := findCommand(args[0]); ok {
+		return cmd.run(args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(args, " "))
+	help(nil)
+	return 2
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func help(args []string) int {
+	fmt.Fprintln(os.Stderr, "Usage: server <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.summary)
+	}
+	return 0
+}
+
+func loadConfig() (*config.Config, bool) {
+	cfg, err := config.Load()
+	if err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			slog.Error("invalid configuration", "problems", validationErr.Problems)
+		} else {
+			slog.Error("failed to load configuration", "error", err)
+		}
+		return nil, false
+	}
+	return cfg, true
+}
+
+func bootstrap() (*config.Config, store.StateStore, bool) {
+	cfg, ok := loadConfig()
+	if !ok {
+		return nil, nil, false
+	}
+	stateStore, err := store.New(cfg.RedisURL)
+	if err != nil {
+		slog.Error("failed to configure state store", "error", err)
+		return nil, nil, false
+	}
+	handlers.Configure(cfg, stateStore)
+	return cfg, stateStore, true
+}
+
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	skipChecks := flags.Bool("skip-checks", false, "only validate settings, do not contact S3 or Redis")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	defer stateStore.Close()
+
+	if !*skipChecks {
+		if problems := handlers.CheckDependencies(context.Background()); len(problems) > 0 {
+			slog.Error("configured dependencies are unreachable", "problems", problems)
+			return 1
+		}
+	}
+	fmt.Printf("configuration is valid (port %s, bucket %s)\n", cfg.Port, cfg.S3BucketName)
+	return 0
+}
+
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	defer stateStore.Close()
+
+	applied, err := handlers.RunMigrations(context.Background(), *dryRun)
+	if err != nil {
+		slog.Error("migration failed", "applied", applied, "error", err)
+		return 1
+	}
+	if len(applied) == 0 {
+		fmt.Println("no pending migrations")
+		return 0
+	}
+	verb := "applied"
+	if *dryRun {
+		verb = "pending"
+	}
+	for _, name := range applied {
+		fmt.Printf("%s %s\n", verb, name)
+	}
+	return 0
+}
+
+func seedRegistry(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	file := flags.String("file", "", "JSON fixtures with users, servers, and orders, or an array of server records (defaults to the bundled fixtures)")
+	overwrite := flags.Bool("overwrite", false, "replace servers that already exist")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	data := seed.Default
+	if *file != "" {
+		content, err := os.ReadFile(*file)
+		if err != nil {
+			slog.Error("failed to read seed file", "file", *file, "error", err)
+			return 1
+		}
+		data = content
+	}
+	fixtures, err := seed.Parse(data)
+	if err != nil {
+		slog.Error("invalid seed fixtures", "error", err)
+		return 1
+	}
+
+	cfg, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	defer stateStore.Close()
+
+	report, err := handlers.SeedFixtures(context.Background(), fixtures, *overwrite)
+	if err != nil {
+		slog.Error("seeding failed", "error", err)
+		return 1
+	}
+	fmt.Printf("users: %d created, %d existing\n", report.UsersCreated, report.UsersExisting)
+	fmt.Printf("servers: seeded %d of %d\n", report.ServersSeeded, len(fixtures.Servers))
+	fmt.Printf("orders: seeded %d sandbox orders\n", report.OrdersSeeded)
+	if report.OrdersSeeded > 0 && cfg.RedisURL == "" {
+		fmt.Println("  REDIS_URL is not set, so the orders were not kept for the server to load; set it and seed again")
+	}
+	if len(report.AdminIDs) > 0 {
+		fmt.Printf("admins: add %s to ADMIN_UIDS\n", strings.Join(report.AdminIDs, ","))
+	}
+	return 0
+}
+
+func rebuildIndex(args []string) int {
+	flags := flag.NewFlagSet("index rebuild", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	defer stateStore.Close()
+
+	total, err := handlers.RebuildRegistryIndex(context.Background())
+	if err != nil {
+		slog.Error("index rebuild failed", "error", err)
+		return 1
+	}
+	fmt.Printf("indexed %d servers\n", total)
+	return 0
+}
+
+func checkRegistry(args []string) int {
+	flags := flag.NewFlagSet("registry check", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "rewrite records with fixable issues (default is a dry run)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	_, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	defer stateStore.Close()
+
+	report, err := handlers.CheckRegistry(context.Background(), *apply, func(int, string) {})
+	if err != nil {
+		slog.Error("registry check failed", "repaired", report.Repaired, "error", err)
+		return 1
+	}
+	for _, issue := range report.Issues {
+		verdict := "invalid"
+		if issue.Fixable {
+			verdict = "fixable"
+		}
+		field := issue.Field
+		if field == "" {
+			field = "-"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s: %s\n", issue.Server, issue.Region, verdict, issue.Rule, field, issue.Message)
+	}
+	fmt.Printf("scanned %d servers: %d with issues, %d repairable", report.Scanned, report.Invalid, report.Repairable)
+	if *apply {
+		fmt.Printf(", %d repaired\n", report.Repaired)
+	} else {
+		fmt.Println(" (dry run; pass -apply to repair)")
+	}
+	if report.Invalid > report.Repaired {
+		return 1
+	}
+	return 0
+}
+
+func loadTest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:8000", "base URL of the server under test")
+	rate := flags.Int("rate", 50, "requests per second for each scenario")
+	duration := flags.Duration("duration", 30*time.Second, "how long to run each scenario")
+	scenarios := flags.String("scenarios", "list,search,device-poll", "comma-separated scenarios: "+strings.Join(loadtest.Scenarios(), ", "))
+	serverName := flags.String("server", "", "existing server for get, or paid server for create-order")
+	plan := flags.String("plan", "", "plan to order in create-order")
+	budgetFile := flags.String("budget", "", "JSON latency budget per scenario (defaults to the bundled one)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	data := loadtest.DefaultBudget
+	if *budgetFile != "" {
+		content, err := os.ReadFile(*budgetFile)
+		if err != nil {
+			slog.Error("failed to read budget file", "file", *budgetFile, "error", err)
+			return 1
+		}
+		data = content
+	}
+	var budgets map[string]loadtest.Budget
+	if err := json.Unmarshal(data, &budgets); err != nil {
+		slog.Error("budget must map scenario names to budgets", "error", err)
+		return 1
+	}
+
+	opts := loadtest.Options{
+		BaseURL:    *target,
+		Rate:       *rate,
+		Duration:   *duration,
+		ServerName: *serverName,
+		Plan:       *plan,
+		Token:      os.Getenv("SUPERBOX_TOKEN"),
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	results, err := loadtest.Run(context.Background(), client, opts, strings.Split(*scenarios, ","))
+	if err != nil {
+		slog.Error("load test failed", "error", err)
+		return 1
+	}
+
+	failed := false
+	fmt.Printf("%-14s %8s %7s %9s %9s %9s %9s\n", "scenario", "requests", "errors", "p50", "p95", "p99", "max")
+	for _, result := range results {
+		fmt.Printf("%-14s %8d %6.2f%% %9s %9s %9s %9s\n", result.Scenario, result.Requests, result.ErrorRate()*100,
+			result.P50.Round(time.Microsecond), result.P95.Round(time.Microsecond), result.P99.Round(time.Microsecond), result.Max.Round(time.Microsecond))
+		budget, ok := budgets[result.Scenario]
+		if !ok {
+			continue
+		}
+		for _, problem := range result.Violations(budget) {
+			fmt.Printf("  over budget: %s\n", problem)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
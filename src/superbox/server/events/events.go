@@ -0,0 +1,70 @@
+// Package events carries what happened in the server (registry changes,
+// purchases, sign-ins, scan results) from the handler where it happened to
+// the parts that react to it, such as webhooks, caches, and notifications.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Event is one thing that happened. Data is the JSON payload for its Type,
+// so a subscriber decodes it the same way whichever backend carried it.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	TenantID  string          `json:"tenant_id,omitempty"`
+	Subject   string          `json:"subject,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Origin is the instance that published the event.
+	Origin string `json:"origin,omitempty"`
+}
+
+// Handler reacts to an event. An error is logged and does not stop other
+// subscribers from seeing the event.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus delivers each published event to every subscriber of its type, in the
+// order they subscribed.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registers handler under name for the listed types; "*"
+	// matches every type.
+	Subscribe(name string, types []string, handler Handler)
+	Close() error
+}
+
+// New returns the in-process bus, or with backend "redis" one that also
+// shares events with other replicas through a Redis stream.
+func New(backend string, redisURL string) (Bus, error) {
+	if backend == "redis" {
+		return NewRedisStreams(redisURL)
+	}
+	return NewMemory(), nil
+}
+
+// NewEvent builds an event of eventType with data encoded as its payload.
+func NewEvent(eventType string, tenantID string, subject string, data interface{}) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:        newID(),
+		Type:      eventType,
+		TenantID:  tenantID,
+		Subject:   subject,
+		Data:      payload,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+type subscription struct {
+	name    string
+	types   []string
+	handler Handler
+}
+
+// Memory delivers events in process, calling each subscriber before Publish
+// returns, so a request sees the effects of the events it published.
+type Memory struct {
+	subscriptions []subscription
+	mutex         sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Subscribe(name string, types []string, handler Handler) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subscriptions = append(m.subscriptions, subscription{name: name, types: types, handler: handler})
+}
+
+func (m *Memory) Publish(ctx context.Context, event Event) error {
+	m.dispatch(ctx, event)
+	return nil
+}
+
+func (m *Memory) dispatch(ctx context.Context, event Event) {
+	m.mutex.RLock()
+	subscriptions := slices.Clone(m.subscriptions)
+	m.mutex.RUnlock()
+
+	for _, sub := range subscriptions {
+		if !slices.Contains(sub.types, event.Type) && !slices.Contains(sub.types, "*") {
+			continue
+		}
+		if err := sub.handler(ctx, event); err != nil {
+			slog.Error("event subscriber failed", "subscriber", sub.name, "event", event.Type, "event_id", event.ID, "error", err)
+		}
+	}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamKey = "superbox:events"
+	// streamMaxLen bounds the stream; replicas read new entries as they
+	// arrive, so only a short backlog is ever needed.
+	streamMaxLen = 10000
+	readBlock    = 5 * time.Second
+	readRetry    = time.Second
+)
+
+// RedisStreams delivers events to this replica's subscribers as Memory does
+// and appends them to a Redis stream, from which every other replica
+// delivers them to its own subscribers. Each replica keeps its own state, so
+// every replica sees every event.
+type RedisStreams struct {
+	*Memory
+	client *redis.Client
+	origin string
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+func NewRedisStreams(redisURL string) (*RedisStreams, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := &RedisStreams{
+		Memory: NewMemory(),
+		client: redis.NewClient(options),
+		origin: hostname + "-" + strconv.Itoa(os.Getpid()) + "-" + newID(),
+		cancel: cancel,
+	}
+	bus.done.Add(1)
+	go bus.read(ctx)
+	return bus, nil
+}
+
+func (r *RedisStreams) Publish(ctx context.Context, event Event) error {
+	event.Origin = r.origin
+	r.dispatch(ctx, event)
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": encoded},
+	}).Err()
+}
+
+// read follows the stream from when the replica started, delivering events
+// published elsewhere.
+func (r *RedisStreams) read(ctx context.Context) {
+	defer r.done.Done()
+	// Stream IDs start with the time in milliseconds, so this skips what
+	// was published before the replica started without missing anything
+	// published between reads.
+	lastID := strconv.FormatInt(time.Now().UnixMilli(), 10) + "-0"
+	for ctx.Err() == nil {
+		streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{streamKey, lastID},
+			Block:   readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("reading the event stream failed", "error", err)
+				time.Sleep(readRetry)
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				lastID = message.ID
+				r.deliver(ctx, message)
+			}
+		}
+	}
+}
+
+func (r *RedisStreams) deliver(ctx context.Context, message redis.XMessage) {
+	encoded, _ := message.Values["event"].(string)
+	var event Event
+	if err := json.Unmarshal([]byte(encoded), &event); err != nil {
+		slog.Warn("skipping an unreadable event", "stream_id", message.ID, "error", err)
+		return
+	}
+	if event.Origin == r.origin {
+		return
+	}
+	r.dispatch(ctx, event)
+}
+
+func (r *RedisStreams) Close() error {
+	r.cancel()
+	r.done.Wait()
+	return r.client.Close()
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var adminUIDs = make(map[string]bool)
+
+func RegisterAdmin(api *gin.RouterGroup) {
+	admin := api.Group("/admin")
+	{
+		admin.GET("/reconciliation", getReconciliation)
+		admin.POST("/reconciliation/run", runReconciliationNow)
+		admin.POST("/reconciliation/tasks/:task_id/resolve", resolveRepairTask)
+
+		admin.GET("/orders/held", listHeldOrders)
+		admin.POST("/orders/:order_id/approve", approveHeldOrder)
+		admin.POST("/orders/:order_id/reject", rejectHeldOrder)
+
+		admin.GET("/commission", getCommission)
+		admin.PUT("/commission/tiers/:tier", setCommissionTier)
+		admin.PUT("/commission/publishers/:owner_id", setPublisherTier)
+		admin.GET("/pricing/changes", listPendingPriceChanges)
+		admin.POST("/pricing/changes/:change_id/approve", approvePriceChange)
+		admin.POST("/pricing/changes/:change_id/reject", rejectPriceChange)
+		admin.GET("/moderation", listModerationHolds)
+		admin.POST("/moderation/:hold_id/approve", approveModerationHold)
+		admin.POST("/moderation/:hold_id/reject", rejectModerationHold)
+
+		admin.GET("/users/:user_id", lookupUser)
+		admin.GET("/users/:user_id/billing-managers", listBillingManagers)
+		admin.PUT("/users/:user_id/billing-managers/:manager_id", grantBillingManager)
+		admin.DELETE("/users/:user_id/billing-managers/:manager_id", revokeBillingManager)
+		admin.PUT("/servers/:server_name/owner", setServerOwner)
+		admin.POST("/registry/check", startRegistryCheck)
+
+		admin.GET("/jobs", listJobs)
+		admin.GET("/audit", listAuditEntries)
+		admin.GET("/webhooks/deliveries", adminListWebhookDeliveries)
+		admin.POST("/webhooks/deliveries/:delivery_id/replay", adminReplayWebhookDelivery)
+
+		admin.GET("/upstreams", listUpstreams)
+		admin.GET("/device-funnel", getDeviceFunnel)
+		admin.GET("/device-clients", listDeviceClients)
+		admin.POST("/device-clients", registerDeviceClient)
+		admin.DELETE("/device-clients/:client_id", revokeDeviceClient)
+		admin.GET("/cdn/invalidations", listCDNInvalidations)
+
+		admin.GET("/log-level", getLogLevel)
+		admin.PUT("/log-level", setLogLevel)
+
+		admin.GET("/config", getRuntimeConfig)
+		admin.POST("/config/reload", reloadRuntimeConfig)
+
+		admin.GET("/policies", listPolicies)
+	}
+}
+
+func listJobs(c *gin.Context) {
+	jobs, err := pendingJobs(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Failed to list jobs", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"total":  len(jobs),
+		"jobs":   jobs,
+	})
+}
+
+func lookupUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("user_id")
+	orders, err := userOrders(ctx, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load orders", err))
+		return
+	}
+	entitlements, err := userEntitlements(ctx, "", userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load entitlements", err))
+		return
+	}
+	profile, err := getBillingProfileCopy(ctx, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load billing profile", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"user_id":         userID,
+		"admin":           isAdmin(userID),
+		"orders":          orders,
+		"entitlements":    entitlements,
+		"billing_profile": profile,
+	})
+}
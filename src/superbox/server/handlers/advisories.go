@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverAdvisories are listed per tenant.
+var serverAdvisories = recordSet[models.Advisory]{
+	kind:  "advisory",
+	group: func(advisory *models.Advisory) string { return advisory.TenantID },
+}
+
+// advisoryAliasPattern accepts CVE and GitHub Security Advisory IDs.
+var advisoryAliasPattern = regexp.MustCompile(`^(CVE-\d{4}-\d{4,}|GHSA(-[23456789cfghjmpqrvwx]{4}){3})$`)
+
+// advisorySeverities orders severities for the severity filter, which
+// returns advisories at or above the one given.
+var advisorySeverities = map[string]int{
+	"low":      1,
+	"moderate": 2,
+	"high":     3,
+	"critical": 4,
+}
+
+func RegisterAdvisories(api *gin.RouterGroup) {
+	api.GET("/advisories", listAdvisories)
+}
+
+func rangeAffects(affected models.AdvisoryRange, version string) bool {
+	return (affected.Introduced == "" || compareSemver(version, affected.Introduced) >= 0) &&
+		(affected.Fixed == "" || compareSemver(version, affected.Fixed) < 0)
+}
+
+func advisoryAffects(advisory models.Advisory, version string) bool {
+	for _, affected := range advisory.Ranges {
+		if rangeAffects(affected, version) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRanges writes ranges the way npm does, such as
+// ">=1.0.0 <1.2.3 || >=2.0.0".
+func describeRanges(ranges []models.AdvisoryRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, affected := range ranges {
+		bounds := []string{}
+		if affected.Introduced != "" {
+			bounds = append(bounds, ">="+affected.Introduced)
+		}
+		if affected.Fixed != "" {
+			bounds = append(bounds, "<"+affected.Fixed)
+		}
+		if len(bounds) == 0 {
+			bounds = append(bounds, "*")
+		}
+		parts = append(parts, strings.Join(bounds, " "))
+	}
+	return strings.Join(parts, " || ")
+}
+
+// withAffectedVersions fills in which of the server's published versions the
+// advisory covers, so versions published after it was filed are included.
+func withAffectedVersions(advisory models.Advisory, server models.Server) models.Advisory {
+	versions := []string{}
+	for _, version := range server.Versions {
+		versions = append(versions, version.Version)
+	}
+	if server.Version != "" && !slices.Contains(versions, server.Version) {
+		versions = append(versions, server.Version)
+	}
+	slices.SortFunc(versions, compareSemver)
+
+	advisory.AffectedVersions = []string{}
+	for _, version := range versions {
+		if advisoryAffects(advisory, version) {
+			advisory.AffectedVersions = append(advisory.AffectedVersions, version)
+		}
+	}
+	return advisory
+}
+
+// tenantAdvisories returns a tenant's advisories, newest first, optionally
+// for one server.
+func tenantAdvisories(ctx context.Context, tenantID string, serverName string) ([]models.Advisory, error) {
+	filed, err := serverAdvisories.listGroup(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	result := []models.Advisory{}
+	for _, advisory := range filed {
+		if serverName == "" || advisory.ServerName == serverName {
+			result = append(result, advisory)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PublishedAt != result[j].PublishedAt {
+			return result[i].PublishedAt.After(result[j].PublishedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// createAdvisory files an advisory against a server. Only its publisher, or
+// an admin, can file one; users with an affected version installed are
+// notified.
+func createAdvisory(c *gin.Context) {
+	serverName := c.Param("server_name")
+	userID, server, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+
+	var req models.CreateAdvisoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	for _, alias := range req.Aliases {
+		if !advisoryAliasPattern.MatchString(alias) {
+			apiErr := validationFailed()
+			apiErr.Fields = []FieldError{{Field: "aliases", Message: "'" + alias + "' is not a CVE or GHSA ID"}}
+			respondError(c, apiErr)
+			return
+		}
+	}
+	for _, affected := range req.Affected {
+		if affected.Introduced != "" && affected.Fixed != "" && compareSemver(affected.Introduced, affected.Fixed) >= 0 {
+			apiErr := validationFailed()
+			apiErr.Fields = []FieldError{{Field: "affected", Message: "introduced " + affected.Introduced + " must be lower than fixed " + affected.Fixed}}
+			respondError(c, apiErr)
+			return
+		}
+	}
+
+	advisory := &models.Advisory{
+		ID:            randomID("adv"),
+		TenantID:      requestTenant(c).ID,
+		ServerName:    serverName,
+		Summary:       req.Summary,
+		Details:       req.Details,
+		Severity:      req.Severity,
+		Aliases:       req.Aliases,
+		References:    req.References,
+		Ranges:        req.Affected,
+		AffectedRange: describeRanges(req.Affected),
+		ReportedBy:    userID,
+		PublishedAt:   models.Now(),
+	}
+
+	if err := serverAdvisories.put(c.Request.Context(), advisory.ID, advisory); err != nil {
+		respondError(c, internalError("Error saving advisory", err))
+		return
+	}
+
+	result := withAffectedVersions(*advisory, server)
+	if err := notifyAdvisory(c.Request.Context(), result); err != nil {
+		slog.Error("failed to notify users of advisory", "advisory_id", advisory.ID, "error", err)
+	}
+	auditChange(c, nil, result)
+	c.JSON(http.StatusCreated, gin.H{
+		"status":   "success",
+		"advisory": result,
+	})
+}
+
+// listServerAdvisories lists a server's advisories, or with ?version= only
+// those that affect that version.
+func listServerAdvisories(c *gin.Context) {
+	serverName := c.Param("server_name")
+	server, err := snapshotServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	version := c.Query("version")
+	if version != "" && !semverPattern.MatchString(version) {
+		respondError(c, newAPIError(http.StatusBadRequest, "version must be a semantic version"))
+		return
+	}
+
+	filed, err := tenantAdvisories(c.Request.Context(), requestTenant(c).ID, serverName)
+	if err != nil {
+		respondError(c, internalError("Error loading advisories", err))
+		return
+	}
+	result := []models.Advisory{}
+	for _, advisory := range filed {
+		if version == "" || advisoryAffects(advisory, version) {
+			result = append(result, withAffectedVersions(advisory, server))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"total":      len(result),
+		"advisories": result,
+	})
+}
+
+// listAdvisories lists the tenant's advisories, optionally for one server,
+// one version of it, and at or above a severity.
+func listAdvisories(c *gin.Context) {
+	serverName := c.Query("server")
+	version := c.Query("version")
+	if version != "" && (serverName == "" || !semverPattern.MatchString(version)) {
+		respondError(c, newAPIError(http.StatusBadRequest, "version must be a semantic version and needs server"))
+		return
+	}
+	minimum := 0
+	if severity := c.Query("severity"); severity != "" {
+		rank, known := advisorySeverities[severity]
+		if !known {
+			respondError(c, newAPIError(http.StatusBadRequest, "severity must be low, moderate, high, or critical"))
+			return
+		}
+		minimum = rank
+	}
+
+	servers, err := snapshotServers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error fetching servers", err))
+		return
+	}
+
+	filed, err := tenantAdvisories(c.Request.Context(), requestTenant(c).ID, serverName)
+	if err != nil {
+		respondError(c, internalError("Error loading advisories", err))
+		return
+	}
+	result := []models.Advisory{}
+	for _, advisory := range filed {
+		if advisorySeverities[advisory.Severity] < minimum || (version != "" && !advisoryAffects(advisory, version)) {
+			continue
+		}
+		result = append(result, withAffectedVersions(advisory, servers[advisory.ServerName]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"total":      len(result),
+		"advisories": result,
+	})
+}
+
+// notifyAdvisory tells users who have an affected version of the server
+// installed about a new advisory.
+func notifyAdvisory(ctx context.Context, advisory models.Advisory) error {
+	sets, err := installedServers.listGroup(ctx, advisory.TenantID)
+	if err != nil {
+		return err
+	}
+	recipients := map[string]string{}
+	for _, set := range sets {
+		if entry, exists := set.Servers[advisory.ServerName]; exists && advisoryAffects(advisory, entry.Version) {
+			recipients[set.UserID] = entry.Version
+		}
+	}
+
+	for userID, version := range recipients {
+		notify(ctx, userID, "server.advisory", "A "+advisory.Severity+" severity advisory affects "+advisory.ServerName+" "+version+", which you have installed: "+advisory.Summary, map[string]interface{}{
+			"advisory_id":       advisory.ID,
+			"server_name":       advisory.ServerName,
+			"installed_version": version,
+			"severity":          advisory.Severity,
+			"affected_range":    advisory.AffectedRange,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiKeyScheme is the Authorization scheme API keys are sent with.
+	apiKeyScheme = "ApiKey"
+	apiKeyPrefix = "sbak_"
+	maxAPIKeys   = 10
+)
+
+// apiKeyScopeActions are the policy actions each API key scope allows, in
+// the form of scopeActions. publish creates and updates servers but cannot
+// delete them, which takes admin; payments covers every purchase, payment,
+// and payment link route. Site administration, profiles, consents, and keys
+// themselves belong to no scope, so no key reaches them.
+var apiKeyScopeActions = map[string][]string{
+	"read":     {"servers.list", "servers.read", "servers.download", "servers.pricing_history", "servers.export", "servers.lint", "advisories.list", "questions.list", "reviews.list"},
+	"publish":  {"servers.create", "servers.update", "servers.import", "blobs.", "operations.read", "retention."},
+	"admin":    {"servers.delete", "servers.claim", "advisories.create", "questions.answer", "reviews.respond", "webhooks."},
+	"payments": {"orders.create", "payments.", "payment_links.", "entitlements.list", "invoices.", "subscriptions.", "billing_profile.", "reports."},
+	// Keys created before the scopes above still hold these.
+	"registry:read":  {"servers.list", "servers.read", "servers.download", "servers.pricing_history", "servers.export", "servers.lint"},
+	"registry:write": {"servers.create", "servers.update", "servers.delete", "servers.import", "blobs.", "operations.read"},
+}
+
+// defaultAPIKeyScopes read and publish; deleting and payments are opt-in.
+var defaultAPIKeyScopes = []string{"publish", "read"}
+
+var errTooManyAPIKeys = errors.New("too many API keys")
+
+// storedAPIKey is an API key as the state store holds it: under a hash of
+// the key, which is never stored itself.
+type storedAPIKey struct {
+	Key           models.APIKey `json:"key"`
+	UserID        string        `json:"user_id"`
+	TenantID      string        `json:"tenant_id"`
+	Email         string        `json:"email,omitempty"`
+	EmailVerified bool          `json:"email_verified,omitempty"`
+}
+
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyKey(hash string) string {
+	return "api_key:" + hash
+}
+
+// userAPIKeysKey lists the hashes of a user's keys.
+func userAPIKeysKey(tenantID string, userID string) string {
+	return "api_keys:" + tenantID + ":" + userID
+}
+
+func loadAPIKey(ctx context.Context, hash string) (*storedAPIKey, error) {
+	data, err := stateStore.Get(ctx, apiKeyKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	var key storedAPIKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func createAPIKey(c *gin.Context) {
+	account, ok := authenticatedAccount(c)
+	if !ok || !requireTokenCaller(c) {
+		return
+	}
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	// A key can be limited to servers that are not published yet, so CI can
+	// create them, but not to someone else's.
+	ctx := c.Request.Context()
+	for _, name := range req.Servers {
+		if !serverNamePattern.MatchString(name) {
+			respondError(c, invalidSlug("servers"))
+			return
+		}
+		server, err := fetchServer(ctx, name)
+		if err != nil && !errors.Is(err, errServerNotFound) {
+			respondError(c, internalError("Error fetching server", err))
+			return
+		}
+		if err == nil && !ownsResource(c, server.Meta.OwnerID) {
+			respondError(c, notPublisher(name))
+			return
+		}
+	}
+
+	userID := c.GetString("user_id")
+	tenantID := requestTenant(c).ID
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	secret := apiKeyPrefix + hex.EncodeToString(raw)
+	scopes := defaultAPIKeyScopes
+	if len(req.Scopes) > 0 {
+		scopes = slices.Compact(slices.Sorted(slices.Values(req.Scopes)))
+	}
+	now := time.Now()
+	key := models.APIKey{
+		ID:        randomID("ak"),
+		Name:      req.Name,
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		Scopes:    scopes,
+		CreatedAt: models.Timestamp(now),
+	}
+	if len(req.Servers) > 0 {
+		key.Servers = slices.Compact(slices.Sorted(slices.Values(req.Servers)))
+	}
+	if req.ExpiresInDays > 0 {
+		key.ExpiresAt = models.Timestamp(now.AddDate(0, 0, req.ExpiresInDays))
+	}
+	email, _ := account["email"].(string)
+	verified, _ := account["emailVerified"].(bool)
+	record, _ := json.Marshal(storedAPIKey{Key: key, UserID: userID, TenantID: tenantID, Email: email, EmailVerified: verified})
+
+	hash := apiKeyHash(secret)
+	err := updateKeyIndex(ctx, userAPIKeysKey(tenantID, userID), func(hashes []string) ([]string, error) {
+		if len(hashes) >= maxAPIKeys {
+			return nil, errTooManyAPIKeys
+		}
+		return append(hashes, hash), nil
+	})
+	if errors.Is(err, errTooManyAPIKeys) {
+		respondError(c, newAPIError(http.StatusConflict, "At most "+strconv.Itoa(maxAPIKeys)+" API keys are allowed; revoke one first").withCode("limit_exceeded"))
+		return
+	}
+	if err == nil {
+		err = stateStore.Set(ctx, apiKeyKey(hash), record, 0)
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to create API key", err))
+		return
+	}
+
+	auditChange(c, nil, key)
+	key.Key = secret
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"key":    key,
+	})
+}
+
+func listAPIKeys(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	hashes, err := keyIndex(ctx, userAPIKeysKey(requestTenant(c).ID, userID))
+	if err != nil {
+		respondError(c, internalError("Failed to load API keys", err))
+		return
+	}
+	keys := []models.APIKey{}
+	for _, hash := range hashes {
+		if stored, err := loadAPIKey(ctx, hash); err == nil {
+			keys = append(keys, stored.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"keys":   keys,
+	})
+}
+
+// revokeAPIKey deletes a key; requests that send it are refused at once.
+func revokeAPIKey(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok || !requireTokenCaller(c) {
+		return
+	}
+	keyID := c.Param("key_id")
+	indexKey := userAPIKeysKey(requestTenant(c).ID, userID)
+	ctx := c.Request.Context()
+	hashes, err := keyIndex(ctx, indexKey)
+	if err != nil {
+		respondError(c, internalError("Failed to load API keys", err))
+		return
+	}
+	var revoked *storedAPIKey
+	hash := ""
+	for _, candidate := range hashes {
+		if stored, err := loadAPIKey(ctx, candidate); err == nil && stored.Key.ID == keyID {
+			revoked, hash = stored, candidate
+			break
+		}
+	}
+	if revoked == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "API key '"+keyID+"' not found"))
+		return
+	}
+
+	err = stateStore.Delete(ctx, apiKeyKey(hash))
+	if err == nil {
+		err = updateKeyIndex(ctx, indexKey, func(hashes []string) ([]string, error) {
+			return slices.DeleteFunc(hashes, func(candidate string) bool { return candidate == hash }), nil
+		})
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to revoke API key", err))
+		return
+	}
+
+	auditChange(c, revoked.Key, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "API key '" + keyID + "' revoked",
+	})
+}
+
+func apiKeyRequest(c *gin.Context) bool {
+	scheme, _, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	return strings.EqualFold(scheme, apiKeyScheme)
+}
+
+// apiKeyAccount authenticates a request that sends an API key. The key acts
+// as its owner, but only on the routes its scopes cover and, when it is
+// limited to servers, only on routes that name one of them. Authorize calls
+// it before every handler, so this holds on public routes too.
+func apiKeyAccount(c *gin.Context) (map[string]interface{}, bool) {
+	_, secret, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	secret = strings.TrimSpace(secret)
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid API key"))
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	hash := apiKeyHash(secret)
+	stored, err := loadAPIKey(ctx, hash)
+	if err != nil || stored.TenantID != requestTenant(c).ID {
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid API key"))
+		return nil, false
+	}
+	now := models.Now()
+	if !stored.Key.ExpiresAt.IsZero() && !stored.Key.ExpiresAt.After(now) {
+		respondError(c, newAPIError(http.StatusUnauthorized, "API key has expired"))
+		return nil, false
+	}
+	if action := c.GetString("policy_action"); !actionsAllow(apiKeyScopeActions, stored.Key.Scopes, action) {
+		respondError(c, newAPIError(http.StatusForbidden, "API key scope does not allow '"+action+"'").withCode("insufficient_scope"))
+		return nil, false
+	}
+	if name := c.Param("server_name"); name != "" && !keyCoversServer(stored.Key.Servers, name) {
+		respondError(c, keyServerRefused(name))
+		return nil, false
+	}
+
+	err = stateStore.Update(ctx, apiKeyKey(hash), 0, func(current []byte) ([]byte, error) {
+		var record storedAPIKey
+		if err := json.Unmarshal(current, &record); err != nil {
+			return nil, err
+		}
+		record.Key.LastUsedAt = now
+		return json.Marshal(record)
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to record API key use", err))
+		return nil, false
+	}
+
+	account := map[string]interface{}{"localId": stored.UserID}
+	if stored.Email != "" {
+		account["email"] = stored.Email
+		account["emailVerified"] = stored.EmailVerified
+	}
+	c.Set("user_id", stored.UserID)
+	c.Set("api_key_id", stored.Key.ID)
+	c.Set("api_key_servers", stored.Key.Servers)
+	c.Set("account", account)
+	return account, true
+}
+
+func keyCoversServer(servers []string, name string) bool {
+	return len(servers) == 0 || slices.Contains(servers, name)
+}
+
+func keyServerRefused(name string) *APIError {
+	return newAPIError(http.StatusForbidden, "API key is not allowed to act on server '"+name+"'").withCode("insufficient_scope")
+}
+
+// requireKeyServer refuses a request whose API key is limited to other
+// servers. apiKeyAccount checks servers named in the path; handlers call
+// this for servers named in the body.
+func requireKeyServer(c *gin.Context, name string) bool {
+	if !keyCoversServer(c.GetStringSlice("api_key_servers"), name) {
+		respondError(c, keyServerRefused(name))
+		return false
+	}
+	return true
+}
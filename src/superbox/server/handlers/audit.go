@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var (
+	auditEntries []models.AuditEntry
+	auditFile    *os.File
+	auditMutex   sync.RWMutex
+)
+
+// OpenAuditLog replays an existing JSON-lines audit file into memory and
+// appends every new entry to it. Without a file the trail lives in memory.
+func OpenAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	entries := []models.AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return fmt.Errorf("audit log line %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+
+	auditMutex.Lock()
+	auditEntries = entries
+	auditFile = file
+	auditMutex.Unlock()
+	return nil
+}
+
+func CloseAuditLog() error {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile == nil {
+		return nil
+	}
+	err := auditFile.Close()
+	auditFile = nil
+	return err
+}
+
+func mutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Audit records every mutating request once the handler has run. Handlers
+// that change a resource describe the change with auditChange.
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !mutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			return
+		}
+		params := map[string]string{}
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		entry := models.AuditEntry{
+			ID:        randomID("aud"),
+			RequestID: c.GetString("request_id"),
+			TenantID:  c.GetString("tenant_id"),
+			ActorID:   c.GetString("user_id"),
+			PartnerID: c.GetString("partner_id"),
+			ClientIP:  c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     route,
+			Action:    c.GetString("policy_action"),
+			Resource:  auditResource(route, params),
+			Params:    params,
+			Status:    c.Writer.Status(),
+			CreatedAt: models.Now(),
+		}
+		if changes, ok := c.Get("audit_changes"); ok {
+			entry.Changes = changes.(map[string]models.AuditChange)
+		}
+		appendAudit(entry)
+	}
+}
+
+// auditResource names the most specific resource in the route, such as
+// "servers/weather" or "webhooks/wh_123".
+func auditResource(route string, params map[string]string) string {
+	segments := strings.Split(strings.Trim(route, "/"), "/")
+	for i := len(segments) - 1; i > 0; i-- {
+		if strings.HasPrefix(segments[i], ":") {
+			return segments[i-1] + "/" + params[strings.TrimPrefix(segments[i], ":")]
+		}
+	}
+	return strings.Join(segments[min(2, len(segments)-1):], "/")
+}
+
+func appendAudit(entry models.AuditEntry) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditEntries = append(auditEntries, entry)
+	if auditFile == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		slog.Error("audit log write failed", "audit_id", entry.ID, "error", err)
+	}
+}
+
+// auditChange attaches a before/after summary of the fields that differ to
+// the audit entry for this request.
+func auditChange(c *gin.Context, before interface{}, after interface{}) {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	changes := map[string]models.AuditChange{}
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			changes[key] = models.AuditChange{Before: value, After: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, seen := beforeFields[key]; !seen && value != nil {
+			changes[key] = models.AuditChange{After: value}
+		}
+	}
+	c.Set("audit_changes", changes)
+}
+
+func auditFields(value interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if value == nil {
+		return fields
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return map[string]interface{}{"value": value}
+	}
+	for key := range fields {
+		if sensitiveNamePattern.MatchString(key) {
+			fields[key] = redacted
+		}
+	}
+	return fields
+}
+
+func listAuditEntries(c *gin.Context) {
+	limit := defaultAuditLimit
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxAuditLimit {
+			respondError(c, newAPIError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit)))
+			return
+		}
+		limit = value
+	}
+	var since, until time.Time
+	for _, bound := range []struct {
+		name   string
+		target *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if raw := c.Query(bound.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				respondError(c, newAPIError(http.StatusBadRequest, bound.name+" must be an RFC 3339 timestamp"))
+				return
+			}
+			*bound.target = models.Timestamp(parsed)
+		}
+	}
+
+	actor := c.Query("actor")
+	tenant := c.Query("tenant")
+	resource := c.Query("resource")
+	method := strings.ToUpper(c.Query("method"))
+
+	auditMutex.RLock()
+	result := []models.AuditEntry{}
+	for i := len(auditEntries) - 1; i >= 0 && len(result) < limit; i-- {
+		entry := auditEntries[i]
+		if (actor != "" && entry.ActorID != actor) ||
+			(tenant != "" && entry.TenantID != tenant) ||
+			(resource != "" && !strings.HasPrefix(entry.Resource, resource)) ||
+			(method != "" && entry.Method != method) ||
+			(!since.IsZero() && entry.CreatedAt.Before(since)) ||
+			(!until.IsZero() && entry.CreatedAt.After(until)) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	auditMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"total":   len(result),
+		"entries": result,
+	})
+}
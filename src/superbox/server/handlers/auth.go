@@ -2,60 +2,44 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"superbox/server/models"
+	"superbox/server/store"
 
 	"github.com/gin-gonic/gin"
 )
 
-var (
-	deviceSessions = make(map[string]*models.DeviceSession)
-	stateIndex     = make(map[string]string)
-	userIndex      = make(map[string]string)
-	sessionMutex   sync.RWMutex
-)
-
 const (
-	deviceSessionTTL   = 600
-	devicePollInterval = 5
-	identityBaseURL    = "https://identitytoolkit.googleapis.com/v1"
-	secureTokenURL     = "https://securetoken.googleapis.com/v1/token"
-)
-
-var (
-	firebaseAPIKey     string
-	googleClientID     string
-	googleClientSecret string
-	githubClientID     string
-	githubClientSecret string
-	authTemplate       *template.Template
+	deviceSessionTTL       = 10 * time.Minute
+	deviceSessionRetention = deviceSessionTTL + 2*time.Minute
+	devicePollInterval     = 5
+	// devicePollBackoff is added to a session's poll interval each time its
+	// client polls too soon, up to devicePollMaxInterval.
+	devicePollBackoff     = 5
+	devicePollMaxInterval = 60
+	// devicePollGrace absorbs network jitter between a client's timer and
+	// when its poll arrives.
+	devicePollGrace   = time.Second
+	identityBaseURL   = "https://identitytoolkit.googleapis.com/v1"
+	secureTokenURL    = "https://securetoken.googleapis.com/v1/token"
+	gitlabProviderID  = "oidc.gitlab"
+	microsoftLoginURL = "https://login.microsoftonline.com"
 )
 
-func init() {
-	firebaseAPIKey = os.Getenv("FIREBASE_API_KEY")
-	googleClientID = os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-	githubClientID = os.Getenv("GITHUB_CLIENT_ID")
-	githubClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-
-	templatePath := filepath.Join("src", "superbox", "server", "templates", "auth.html")
-	tmpl, err := template.ParseFiles(templatePath)
-	if err == nil {
-		authTemplate = tmpl
-	}
-}
+var firebaseAPIKey string
 
 func RegisterAuth(api *gin.RouterGroup) {
 	auth := api.Group("/auth")
@@ -66,6 +50,8 @@ func RegisterAuth(api *gin.RouterGroup) {
 		auth.POST("/device", deviceSubmit)
 		auth.GET("/device/callback/google", callbackGoogle)
 		auth.GET("/device/callback/github", callbackGitHub)
+		auth.GET("/device/callback/gitlab", callbackGitLab)
+		auth.GET("/device/callback/microsoft", callbackMicrosoft)
 
 		auth.POST("/register", registerUser)
 		auth.POST("/login", loginUser)
@@ -74,6 +60,28 @@ func RegisterAuth(api *gin.RouterGroup) {
 		auth.GET("/me", getProfile)
 		auth.PATCH("/me", updateProfile)
 		auth.DELETE("/me", deleteProfile)
+
+		auth.POST("/token/exchange", exchangeToken)
+		auth.POST("/consents", grantConsent)
+		auth.GET("/consents", listConsents)
+		auth.DELETE("/consents/:client_id", revokeConsent)
+
+		auth.POST("/signing-keys", createSigningKey)
+		auth.GET("/signing-keys", listSigningKeys)
+		auth.DELETE("/signing-keys/:key_id", deleteSigningKey)
+
+		auth.POST("/api-keys", createAPIKey)
+		auth.GET("/api-keys", listAPIKeys)
+		auth.DELETE("/api-keys/:key_id", revokeAPIKey)
+
+		auth.POST("/webhooks", createPublisherWebhook)
+		auth.GET("/webhooks", listPublisherWebhooks)
+		auth.DELETE("/webhooks/:webhook_id", deletePublisherWebhook)
+		auth.POST("/webhooks/:webhook_id/rotate-secret", rotateWebhookSecret)
+		auth.POST("/webhooks/:webhook_id/signature-preview", previewWebhookSignature)
+		auth.DELETE("/webhooks/:webhook_id/signing-keys/:key_id", retireWebhookSigningKey)
+		auth.GET("/webhooks/:webhook_id/deliveries", listWebhookDeliveries)
+		auth.POST("/webhooks/:webhook_id/deliveries/:delivery_id/replay", replayWebhookDelivery)
 	}
 }
 
@@ -98,132 +106,150 @@ func normalizeCode(code string) string {
 	return strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
 }
 
-func sessionCleanup() {
-	now := float64(time.Now().Unix())
-	expiredCodes := []string{}
-
-	sessionMutex.Lock()
-	for deviceCode, session := range deviceSessions {
-		status := session.Status
-		expiresAt := session.ExpiresAt
-		completedAt := session.CompletedAt
-		if completedAt == 0 {
-			completedAt = expiresAt
-		}
+func deviceSessionKey(deviceCode string) string {
+	return "device:" + deviceCode
+}
 
-		if expiresAt <= now || (status == "complete" || status == "error" || status == "expired") && now-completedAt > 120 {
-			expiredCodes = append(expiredCodes, deviceCode)
-		}
-	}
-	sessionMutex.Unlock()
+func deviceUserKey(normalizedUserCode string) string {
+	return "device_user:" + normalizedUserCode
+}
 
-	for _, code := range expiredCodes {
-		removeSession(code)
-	}
+func deviceStateKey(state string) string {
+	return "device_state:" + state
 }
 
-func storeSession(session *models.DeviceSession) {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	deviceSessions[session.DeviceCode] = session
-	userIndex[session.NormalizedUserCode] = session.DeviceCode
-	stateIndex[session.State] = session.DeviceCode
+func storeSession(ctx context.Context, session *models.DeviceSession) error {
+	data, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+
+	if err := sessionStore.Set(ctx, deviceSessionKey(session.DeviceCode), data, deviceSessionRetention); err != nil {
+		return err
+	}
+	if err := sessionStore.Set(ctx, deviceUserKey(session.NormalizedUserCode), []byte(session.DeviceCode), deviceSessionRetention); err != nil {
+		return err
+	}
+	return sessionStore.Set(ctx, deviceStateKey(session.State), []byte(session.DeviceCode), deviceSessionRetention)
 }
 
-func removeSession(deviceCode string) {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	session, exists := deviceSessions[deviceCode]
-	if !exists {
+func removeSession(ctx context.Context, deviceCode string) {
+	session := getSessionCopy(ctx, deviceCode)
+	if session == nil {
 		return
 	}
 
-	delete(deviceSessions, deviceCode)
-	delete(userIndex, session.NormalizedUserCode)
-	delete(stateIndex, session.State)
+	err := sessionStore.Delete(ctx,
+		deviceSessionKey(deviceCode),
+		deviceUserKey(session.NormalizedUserCode),
+		deviceStateKey(session.State),
+	)
+	if err != nil {
+		slog.Error("failed to remove device session", "error", err)
+	}
 }
 
-func getSessionCopy(deviceCode string) *models.DeviceSession {
-	sessionMutex.RLock()
-	defer sessionMutex.RUnlock()
-	session, exists := deviceSessions[deviceCode]
-	if !exists {
+func getSessionCopy(ctx context.Context, deviceCode string) *models.DeviceSession {
+	if deviceCode == "" {
+		return nil
+	}
+	data, err := sessionStore.Get(ctx, deviceSessionKey(deviceCode))
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Error("failed to load device session", "error", err)
+		}
 		return nil
 	}
 
-	copy := *session
-	return &copy
+	session, err := decodeSession(data)
+	if err != nil {
+		slog.Error("failed to decode device session", "error", err)
+		return nil
+	}
+	return session
 }
 
-func markSession(deviceCode string, status string, message string) {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	session, exists := deviceSessions[deviceCode]
-	if !exists {
-		return
+func updateSession(ctx context.Context, deviceCode string, update func(*models.DeviceSession)) *models.DeviceSession {
+	var updated *models.DeviceSession
+	err := sessionStore.Update(ctx, deviceSessionKey(deviceCode), deviceSessionRetention, func(current []byte) ([]byte, error) {
+		session, err := decodeSession(current)
+		if err != nil {
+			return nil, err
+		}
+		update(session)
+		updated = session
+		return encodeSession(session)
+	})
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Error("failed to update device session", "error", err)
+		}
+		return nil
 	}
+	return updated
+}
 
-	session.Status = status
-	session.CompletedAt = float64(time.Now().Unix())
-	if message != "" {
-		session.Error = message
+// markSession records how a login ended. It runs detached from ctx, so a
+// login whose provider exchange timed out still reports that to its poll.
+func markSession(ctx context.Context, deviceCode string, status string, message string) {
+	ctx, cancel := detachedContext(ctx)
+	defer cancel()
+	session := updateSession(ctx, deviceCode, func(session *models.DeviceSession) {
+		session.Status = status
+		session.CompletedAt = models.Now()
+		if message != "" {
+			session.Error = message
+		}
+	})
+	if session != nil {
+		sessionStore.Delete(ctx, deviceStateKey(session.State))
+		notifySession(deviceCode)
 	}
-	delete(stateIndex, session.State)
 }
 
-func setSessionTokens(deviceCode string, tokens map[string]interface{}) {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	session, exists := deviceSessions[deviceCode]
-	if !exists {
-		return
+func setSessionTokens(ctx context.Context, deviceCode string, tokens map[string]interface{}) {
+	session := updateSession(ctx, deviceCode, func(session *models.DeviceSession) {
+		session.Status = "complete"
+		session.Tokens = tokens
+		session.CompletedAt = models.Now()
+	})
+	if session != nil {
+		sessionStore.Delete(ctx, deviceStateKey(session.State))
+		notifySession(deviceCode)
 	}
-
-	session.Status = "complete"
-	session.Tokens = tokens
-	session.CompletedAt = float64(time.Now().Unix())
-	delete(stateIndex, session.State)
 }
 
-func findState(state string) string {
-	sessionMutex.RLock()
-	defer sessionMutex.RUnlock()
-	return stateIndex[state]
+func findState(ctx context.Context, state string) string {
+	data, err := sessionStore.Get(ctx, deviceStateKey(state))
+	if err != nil {
+		return ""
+	}
+	return string(data)
 }
 
 func renderDevicePage(c *gin.Context, message string, code string, isError bool, showForm bool) {
-	if authTemplate == nil {
-		c.HTML(http.StatusOK, "auth.html", gin.H{
-			"message":   message,
-			"code":      code,
-			"error":     isError,
-			"show_form": showForm,
-		})
-		return
-	}
-
-	var buf bytes.Buffer
-	err := authTemplate.Execute(&buf, map[string]interface{}{
+	renderPage(c, http.StatusOK, "device", gin.H{
 		"message":   message,
 		"code":      code,
 		"error":     isError,
 		"show_form": showForm,
 	})
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Template error")
-		return
-	}
-
-	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
 }
 
-func checkProvider(provider string) error {
-	if provider == "google" && (googleClientID == "" || googleClientSecret == "") {
+func checkProvider(ctx context.Context, provider string) error {
+	tenant := tenantFrom(ctx)
+	if provider == "google" && (tenant.GoogleClientID == "" || tenant.GoogleClientSecret == "") {
 		return fmt.Errorf("google OAuth is not configured on the server")
 	}
-	if provider == "github" && (githubClientID == "" || githubClientSecret == "") {
+	if provider == "github" && (tenant.GithubClientID == "" || tenant.GithubClientSecret == "") {
 		return fmt.Errorf("github OAuth is not configured on the server")
 	}
+	if provider == "gitlab" && (tenant.GitlabClientID == "" || tenant.GitlabClientSecret == "") {
+		return fmt.Errorf("gitlab OAuth is not configured on the server")
+	}
+	if provider == "microsoft" && (tenant.MicrosoftClientID == "" || tenant.MicrosoftClientSecret == "") {
+		return fmt.Errorf("microsoft OAuth is not configured on the server")
+	}
 	return nil
 }
 
@@ -244,13 +270,21 @@ func parseFirebaseResponse(resp *http.Response) (map[string]interface{}, error)
 				errorMsg = msg
 			}
 		}
-		return nil, fmt.Errorf("%s", errorMsg)
+		return nil, &firebaseError{Code: errorMsg}
 	}
 
 	return data, nil
 }
 
-func firebaseExchange(postBody string) (map[string]interface{}, error) {
+type firebaseError struct {
+	Code string
+}
+
+func (e *firebaseError) Error() string {
+	return e.Code
+}
+
+func firebaseExchange(ctx context.Context, postBody string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/accounts:signInWithIdp?key=%s", identityBaseURL, firebaseAPIKey)
 	payload := map[string]interface{}{
 		"postBody":          postBody,
@@ -259,11 +293,10 @@ func firebaseExchange(postBody string) (map[string]interface{}, error) {
 	}
 
 	jsonData, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, _ := newOutboundRequest(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doUpstream("firebase", req)
 	if err != nil {
 		return nil, err
 	}
@@ -354,26 +387,39 @@ func extractToken(authHeader string) (string, error) {
 }
 
 func deviceStart(c *gin.Context) {
-	sessionCleanup()
-
 	var req models.AuthDeviceStartRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
 	provider := strings.ToLower(req.Provider)
-	if provider != "google" && provider != "github" {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Unsupported provider"})
+	if provider != "google" && provider != "github" && provider != "gitlab" && provider != "microsoft" {
+		trackDeviceLogin(c, nil, "", deviceStepStarted, "unsupported_provider")
+		respondError(c, newAPIError(http.StatusBadRequest, "Unsupported provider"))
 		return
 	}
 
-	if err := checkProvider(provider); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"detail": err.Error()})
+	client, err := loadDeviceClient(c.Request.Context(), req.ClientID)
+	if err != nil || !client.RevokedAt.IsZero() {
+		trackDeviceLogin(c, nil, provider, deviceStepStarted, "unknown_client")
+		respondError(c, newAPIError(http.StatusUnauthorized, "Unknown or revoked client_id '"+req.ClientID+"'").withCode("invalid_client"))
+		return
+	}
+	scopes, err := deviceScopes(client, req.Scope)
+	if err != nil {
+		trackDeviceLogin(c, nil, provider, deviceStepStarted, "invalid_scope")
+		respondError(c, newAPIError(http.StatusBadRequest, err.Error()).withCode("invalid_scope"))
 		return
 	}
 
-	now := float64(time.Now().Unix())
+	if err := checkProvider(c.Request.Context(), provider); err != nil {
+		trackDeviceLogin(c, nil, provider, deviceStepStarted, "provider_not_configured")
+		respondError(c, internalError("Provider login is not configured", err))
+		return
+	}
+
+	now := models.Now()
 	deviceCode := generateDeviceCode()
 	userCode := generateUserCode()
 	normalized := normalizeCode(userCode)
@@ -386,12 +432,20 @@ func deviceStart(c *gin.Context) {
 		UserCode:           userCode,
 		NormalizedUserCode: normalized,
 		Provider:           provider,
+		ClientID:           client.ClientID,
+		Scopes:             scopes,
+		TenantID:           requestTenant(c).ID,
 		State:              state,
 		Status:             "pending",
 		CreatedAt:          now,
-		ExpiresAt:          now + deviceSessionTTL,
+		ExpiresAt:          now.Add(deviceSessionTTL),
 	}
-	storeSession(session)
+	if err := storeSession(c.Request.Context(), session); err != nil {
+		trackDeviceLogin(c, session, "", deviceStepStarted, "state_store_unavailable")
+		respondError(c, newAPIError(http.StatusServiceUnavailable, "Device login is temporarily unavailable").withCode("state_store_unavailable"))
+		return
+	}
+	trackDeviceLogin(c, session, "", deviceStepStarted, "")
 
 	scheme := "http"
 	if c.GetHeader("X-Forwarded-Proto") == "https" {
@@ -411,42 +465,65 @@ func deviceStart(c *gin.Context) {
 		"verification_uri":          verificationURI,
 		"verification_uri_complete": verificationURIComplete,
 		"interval":                  devicePollInterval,
-		"expires_in":                deviceSessionTTL,
+		"expires_in":                int(deviceSessionTTL.Seconds()),
 	})
 }
 
 func devicePoll(c *gin.Context) {
-	sessionCleanup()
-
 	var req models.AuthDevicePollRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
-	session := getSessionCopy(req.DeviceCode)
+	session := getSessionCopy(c.Request.Context(), req.DeviceCode)
+	// Every poll of a pending login is paced from when the previous one
+	// arrived, held or not. A held poll that ran its full hold is already
+	// an interval behind the next; one answered early, such as while the
+	// server drains, is not, and the client must wait out the rest.
+	if session != nil && (session.Status == "pending" || session.Status == "authorizing") {
+		if interval, early := pacePoll(c.Request.Context(), req.DeviceCode); early {
+			trackDeviceLogin(c, session, "", deviceStepPoll, "slow_down")
+			c.Header("Retry-After", strconv.Itoa(interval))
+			respondError(c, newAPIError(http.StatusTooManyRequests, fmt.Sprintf("Polling too fast; wait %d seconds between polls", interval)).
+				withCode("slow_down").
+				withDetail("interval", interval))
+			return
+		}
+	}
+	if session != nil && req.Wait > 0 && !draining.Load() && (session.Status == "pending" || session.Status == "authorizing") {
+		session = waitForSession(c.Request.Context(), req.DeviceCode, time.Duration(req.Wait)*time.Second)
+	}
 	if session == nil {
-		c.JSON(http.StatusNotFound, gin.H{"detail": "Unknown device code"})
+		respondError(c, newAPIError(http.StatusNotFound, "Unknown device code"))
 		return
 	}
 
-	now := float64(time.Now().Unix())
-	if session.ExpiresAt <= now && session.Status == "pending" {
-		markSession(req.DeviceCode, "expired", "")
-		removeSession(req.DeviceCode)
-		c.JSON(http.StatusGone, gin.H{"detail": "Device authorization expired"})
+	now := time.Now()
+	if !session.ExpiresAt.After(now) && session.Status == "pending" {
+		trackDeviceLogin(c, session, "", deviceStepPoll, "expired")
+		markSession(c.Request.Context(), req.DeviceCode, "expired", "")
+		removeSession(c.Request.Context(), req.DeviceCode)
+		respondError(c, newAPIError(http.StatusGone, "Device authorization expired"))
 		return
 	}
 
 	status := session.Status
 	if status == "pending" || status == "authorizing" {
-		c.JSON(http.StatusAccepted, gin.H{"status": "pending"})
+		c.JSON(http.StatusAccepted, gin.H{"status": "pending", "interval": pollInterval(session)})
 		return
 	}
 
 	if status == "complete" {
-		tokens := session.Tokens
-		removeSession(req.DeviceCode)
+		tokens, err := deviceTokens(c.Request.Context(), session)
+		if err != nil {
+			trackDeviceLogin(c, session, "", deviceStepPoll, "client_revoked")
+			removeSession(c.Request.Context(), req.DeviceCode)
+			respondError(c, err)
+			return
+		}
+		trackDeviceLogin(c, session, "", deviceStepPoll, "")
+		removeSession(c.Request.Context(), req.DeviceCode)
 		c.JSON(http.StatusOK, tokens)
 		return
 	}
@@ -456,19 +533,104 @@ func devicePoll(c *gin.Context) {
 		if message == "" {
 			message = "Authorization failed"
 		}
-		removeSession(req.DeviceCode)
-		c.JSON(http.StatusBadRequest, gin.H{"detail": message})
+		removeSession(c.Request.Context(), req.DeviceCode)
+		respondError(c, newAPIError(http.StatusBadRequest, message))
 		return
 	}
 
 	if status == "expired" {
-		removeSession(req.DeviceCode)
-		c.JSON(http.StatusGone, gin.H{"detail": "Device authorization expired"})
+		removeSession(c.Request.Context(), req.DeviceCode)
+		respondError(c, newAPIError(http.StatusGone, "Device authorization expired"))
 		return
 	}
 
-	removeSession(req.DeviceCode)
-	c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid device session state"})
+	removeSession(c.Request.Context(), req.DeviceCode)
+	respondError(c, newAPIError(http.StatusBadRequest, "Invalid device session state"))
+}
+
+func pollInterval(session *models.DeviceSession) int {
+	if session.PollInterval > 0 {
+		return session.PollInterval
+	}
+	return devicePollInterval
+}
+
+// pacePoll records a poll of a pending login and reports whether it arrived
+// sooner than the session's interval after the previous one. Each early
+// poll raises the interval, as RFC 8628's slow_down does, so a client stuck
+// in a tight loop is slowed further every time; the new interval is
+// returned.
+func pacePoll(ctx context.Context, deviceCode string) (int, bool) {
+	now := models.Now()
+	early := false
+	session := updateSession(ctx, deviceCode, func(session *models.DeviceSession) {
+		interval := pollInterval(session)
+		early = !session.LastTouched.IsZero() && now.Sub(session.LastTouched) < time.Duration(interval)*time.Second-devicePollGrace
+		if early {
+			session.PollInterval = min(interval+devicePollBackoff, devicePollMaxInterval)
+		}
+		session.LastTouched = now
+	})
+	if session == nil {
+		return devicePollInterval, false
+	}
+	return pollInterval(session), early
+}
+
+// deviceTokens is what a finished login hands its client: the user's own
+// tokens for a client with the full scope, or otherwise a token limited to
+// the login's scopes. A client revoked since the login started gets nothing.
+func deviceTokens(ctx context.Context, session *models.DeviceSession) (map[string]interface{}, *APIError) {
+	// Sessions started before clients were registered belong to the CLI.
+	clientID := session.ClientID
+	if clientID == "" {
+		clientID, session.Scopes = officialClientID, []string{fullScope}
+	}
+	client, err := loadDeviceClient(ctx, clientID)
+	if err != nil || !client.RevokedAt.IsZero() {
+		return nil, newAPIError(http.StatusUnauthorized, "client_id '"+clientID+"' has been revoked").withCode("invalid_client")
+	}
+	if slices.Contains(session.Scopes, fullScope) {
+		return session.Tokens, nil
+	}
+
+	idToken, _ := session.Tokens["id_token"].(string)
+	account, err := lookupAccount(ctx, idToken)
+	userID, _ := account["localId"].(string)
+	if err != nil || userID == "" {
+		return nil, internalError("Failed to issue token", err)
+	}
+	token, err := issuePartnerToken(ctx, partnerToken{
+		UserID:       userID,
+		TenantID:     session.TenantID,
+		ClientID:     client.ClientID,
+		DeviceClient: true,
+		Scopes:       session.Scopes,
+		Account:      account,
+	})
+	if err != nil {
+		return nil, internalError("Failed to issue token", err)
+	}
+	tokens := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(partnerTokenTTL.Seconds()),
+		"scope":        strings.Join(session.Scopes, " "),
+		"provider":     session.Provider,
+	}
+	if email, ok := session.Tokens["email"]; ok {
+		tokens["email"] = email
+	}
+	return tokens, nil
+}
+
+// deviceClientName is how the device page refers to the tool that started
+// the login.
+func deviceClientName(ctx context.Context, session *models.DeviceSession) string {
+	if client, err := loadDeviceClient(ctx, session.ClientID); err == nil {
+		return client.Name
+	}
+	return "the CLI"
 }
 
 func deviceForm(c *gin.Context) {
@@ -481,45 +643,53 @@ func deviceForm(c *gin.Context) {
 func deviceSubmit(c *gin.Context) {
 	code := c.PostForm("code")
 	if code == "" {
+		trackDeviceLogin(c, nil, "", deviceStepCode, "missing_code")
 		renderDevicePage(c, "Device code is required", code, true, true)
 		return
 	}
 
 	normalized := normalizeCode(code)
-	now := float64(time.Now().Unix())
+	now := models.Now()
 
-	sessionMutex.Lock()
-	deviceCode := userIndex[normalized]
+	deviceCode := ""
+	if data, err := sessionStore.Get(c.Request.Context(), deviceUserKey(normalized)); err == nil {
+		deviceCode = string(data)
+	}
 	var session *models.DeviceSession
+	entered := false
 	if deviceCode != "" {
-		session = deviceSessions[deviceCode]
-		if session != nil {
-			if session.ExpiresAt <= now {
+		session = updateSession(c.Request.Context(), deviceCode, func(session *models.DeviceSession) {
+			if !session.ExpiresAt.After(now) {
 				session.Status = "expired"
 			}
-			session.LastTouched = now
-			if session.Status == "pending" {
+			entered = session.Status == "pending"
+			if entered {
 				session.Status = "authorizing"
 			}
-		}
+		})
 	}
-	sessionMutex.Unlock()
 
 	if session == nil || deviceCode == "" {
+		trackDeviceLogin(c, nil, "", deviceStepCode, "unknown_code")
 		renderDevicePage(c, "Invalid or expired device code. Please try again.", code, true, true)
 		return
 	}
 
 	if session.Status == "expired" {
-		removeSession(deviceCode)
+		trackDeviceLogin(c, session, "", deviceStepCode, "expired")
+		removeSession(c.Request.Context(), deviceCode)
 		renderDevicePage(c, "Device code has expired. Restart the login from the CLI.", code, true, true)
 		return
 	}
 
 	if session.Status == "complete" {
+		trackDeviceLogin(c, session, "", deviceStepCode, "already_used")
 		renderDevicePage(c, "This code has already been used. Return to the CLI.", code, true, true)
 		return
 	}
+	if entered {
+		trackDeviceLogin(c, session, "", deviceStepCode, "")
+	}
 
 	scheme := "http"
 	if c.GetHeader("X-Forwarded-Proto") == "https" {
@@ -530,16 +700,18 @@ func deviceSubmit(c *gin.Context) {
 		host = c.Request.Host
 	}
 
+	tenant := tenantByID(session.TenantID)
 	if session.Provider == "google" {
-		if googleClientID == "" || googleClientSecret == "" {
-			markSession(deviceCode, "error", "Google OAuth not configured")
+		if tenant.GoogleClientID == "" || tenant.GoogleClientSecret == "" {
+			markSession(c.Request.Context(), deviceCode, "error", "Google OAuth not configured")
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "provider_not_configured")
 			renderDevicePage(c, "Google login is not available. Contact support.", code, true, true)
 			return
 		}
 
 		callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/google", scheme, host)
 		params := url.Values{}
-		params.Set("client_id", googleClientID)
+		params.Set("client_id", tenant.GoogleClientID)
 		params.Set("redirect_uri", callbackURL)
 		params.Set("response_type", "code")
 		params.Set("scope", "openid email profile")
@@ -547,30 +719,86 @@ func deviceSubmit(c *gin.Context) {
 		params.Set("access_type", "offline")
 		params.Set("prompt", "consent")
 
+		if entered {
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "")
+		}
 		c.Redirect(http.StatusFound, "https://accounts.google.com/o/oauth2/v2/auth?"+params.Encode())
 		return
 	}
 
 	if session.Provider == "github" {
-		if githubClientID == "" || githubClientSecret == "" {
-			markSession(deviceCode, "error", "GitHub OAuth not configured")
+		if tenant.GithubClientID == "" || tenant.GithubClientSecret == "" {
+			markSession(c.Request.Context(), deviceCode, "error", "GitHub OAuth not configured")
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "provider_not_configured")
 			renderDevicePage(c, "GitHub login is not available. Contact support.", code, true, true)
 			return
 		}
 
 		callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/github", scheme, host)
 		params := url.Values{}
-		params.Set("client_id", githubClientID)
+		params.Set("client_id", tenant.GithubClientID)
 		params.Set("redirect_uri", callbackURL)
 		params.Set("scope", "read:user user:email")
 		params.Set("state", session.State)
 		params.Set("allow_signup", "false")
 
+		if entered {
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "")
+		}
 		c.Redirect(http.StatusFound, "https://github.com/login/oauth/authorize?"+params.Encode())
 		return
 	}
 
-	markSession(deviceCode, "error", "Unsupported provider")
+	if session.Provider == "gitlab" {
+		if tenant.GitlabClientID == "" || tenant.GitlabClientSecret == "" {
+			markSession(c.Request.Context(), deviceCode, "error", "GitLab OAuth not configured")
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "provider_not_configured")
+			renderDevicePage(c, "GitLab login is not available. Contact support.", code, true, true)
+			return
+		}
+
+		callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/gitlab", scheme, host)
+		params := url.Values{}
+		params.Set("client_id", tenant.GitlabClientID)
+		params.Set("redirect_uri", callbackURL)
+		params.Set("response_type", "code")
+		params.Set("scope", "openid email profile")
+		params.Set("state", session.State)
+
+		if entered {
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "")
+		}
+		c.Redirect(http.StatusFound, tenant.GitlabBaseURL+"/oauth/authorize?"+params.Encode())
+		return
+	}
+
+	if session.Provider == "microsoft" {
+		if tenant.MicrosoftClientID == "" || tenant.MicrosoftClientSecret == "" {
+			markSession(c.Request.Context(), deviceCode, "error", "Microsoft OAuth not configured")
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "provider_not_configured")
+			renderDevicePage(c, "Microsoft login is not available. Contact support.", code, true, true)
+			return
+		}
+
+		callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/microsoft", scheme, host)
+		params := url.Values{}
+		params.Set("client_id", tenant.MicrosoftClientID)
+		params.Set("redirect_uri", callbackURL)
+		params.Set("response_type", "code")
+		params.Set("response_mode", "query")
+		params.Set("scope", "openid email profile")
+		params.Set("state", session.State)
+		params.Set("prompt", "select_account")
+
+		if entered {
+			trackDeviceLogin(c, session, "", deviceStepRedirect, "")
+		}
+		c.Redirect(http.StatusFound, microsoftEndpoint(tenant.MicrosoftTenantID, "authorize")+"?"+params.Encode())
+		return
+	}
+
+	markSession(c.Request.Context(), deviceCode, "error", "Unsupported provider")
+	trackDeviceLogin(c, session, "", deviceStepRedirect, "unsupported_provider")
 	renderDevicePage(c, "Unsupported provider", code, true, true)
 }
 
@@ -580,34 +808,39 @@ func callbackGoogle(c *gin.Context) {
 	errorParam := c.Query("error")
 
 	if state == "" {
+		trackDeviceLogin(c, nil, "google", deviceStepCallback, "missing_state")
 		renderDevicePage(c, "Missing state parameter", "", true, false)
 		return
 	}
 
-	deviceCode := findState(state)
-	session := getSessionCopy(deviceCode)
+	deviceCode := findState(c.Request.Context(), state)
+	session := getSessionCopy(c.Request.Context(), deviceCode)
 	if deviceCode == "" || session == nil {
+		trackDeviceLogin(c, nil, "google", deviceStepCallback, "session_not_found")
 		renderDevicePage(c, "Session not found or expired. Return to the CLI and try again.", "", true, false)
 		return
 	}
 
-	now := float64(time.Now().Unix())
-	if session.ExpiresAt <= now {
-		markSession(deviceCode, "expired", "")
-		removeSession(deviceCode)
+	now := time.Now()
+	if !session.ExpiresAt.After(now) {
+		markSession(c.Request.Context(), deviceCode, "expired", "")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "expired")
+		removeSession(c.Request.Context(), deviceCode)
 		renderDevicePage(c, "Session has expired. Please restart the login from the CLI.", "", true, false)
 		return
 	}
 
 	if errorParam != "" {
 		message, _ := url.QueryUnescape(errorParam)
-		markSession(deviceCode, "error", message)
+		markSession(c.Request.Context(), deviceCode, "error", message)
+		trackDeviceLogin(c, session, "", deviceStepCallback, oauthFailureReason(errorParam))
 		renderDevicePage(c, "Authorization failed: "+message, "", true, false)
 		return
 	}
 
 	if code == "" {
-		markSession(deviceCode, "error", "Missing authorization code")
+		markSession(c.Request.Context(), deviceCode, "error", "Missing authorization code")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_code")
 		renderDevicePage(c, "Missing authorization code", "", true, false)
 		return
 	}
@@ -624,18 +857,19 @@ func callbackGoogle(c *gin.Context) {
 
 	tokenData := url.Values{}
 	tokenData.Set("code", code)
-	tokenData.Set("client_id", googleClientID)
-	tokenData.Set("client_secret", googleClientSecret)
+	tenant := tenantByID(session.TenantID)
+	tokenData.Set("client_id", tenant.GoogleClientID)
+	tokenData.Set("client_secret", tenant.GoogleClientSecret)
 	tokenData.Set("redirect_uri", callbackURL)
 	tokenData.Set("grant_type", "authorization_code")
 
-	req, _ := http.NewRequest("POST", "https://oauth2.googleapis.com/token", strings.NewReader(tokenData.Encode()))
+	req, _ := newOutboundRequest(c.Request.Context(), "POST", "https://oauth2.googleapis.com/token", strings.NewReader(tokenData.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doUpstream("google", req)
 	if err != nil {
-		markSession(deviceCode, "error", err.Error())
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "provider_unavailable")
 		renderDevicePage(c, "Failed to contact Google. Please try again.", "", true, false)
 		return
 	}
@@ -644,7 +878,8 @@ func callbackGoogle(c *gin.Context) {
 	if resp.StatusCode != http.StatusOK {
 		var errorData map[string]interface{}
 		json.NewDecoder(resp.Body).Decode(&errorData)
-		markSession(deviceCode, "error", "Google authorization failed")
+		markSession(c.Request.Context(), deviceCode, "error", "Google authorization failed")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "token_exchange_failed")
 		renderDevicePage(c, "Google authorization failed. Please try again.", "", true, false)
 		return
 	}
@@ -654,15 +889,17 @@ func callbackGoogle(c *gin.Context) {
 
 	idToken, ok := tokens["id_token"].(string)
 	if !ok || idToken == "" {
-		markSession(deviceCode, "error", "Missing Google ID token")
+		markSession(c.Request.Context(), deviceCode, "error", "Missing Google ID token")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_token")
 		renderDevicePage(c, "Google response did not include an ID token", "", true, false)
 		return
 	}
 
 	postBody := fmt.Sprintf("id_token=%s&providerId=google.com", url.QueryEscape(idToken))
-	firebaseData, err := firebaseExchange(postBody)
+	firebaseData, err := firebaseExchange(c.Request.Context(), postBody)
 	if err != nil {
-		markSession(deviceCode, "error", err.Error())
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "firebase_failed")
 		renderDevicePage(c, "Firebase authentication failed", "", true, false)
 		return
 	}
@@ -681,8 +918,9 @@ func callbackGoogle(c *gin.Context) {
 		authDict["local_id"] = *authResp.LocalID
 	}
 
-	setSessionTokens(deviceCode, authDict)
-	renderDevicePage(c, "Authentication complete. You may return to the CLI to finish logging in.", "", false, false)
+	setSessionTokens(c.Request.Context(), deviceCode, authDict)
+	trackDeviceLogin(c, session, "", deviceStepCallback, "")
+	renderDevicePage(c, "Authentication complete. You may return to "+deviceClientName(c.Request.Context(), session)+" to finish logging in.", "", false, false)
 }
 
 func callbackGitHub(c *gin.Context) {
@@ -691,34 +929,39 @@ func callbackGitHub(c *gin.Context) {
 	errorParam := c.Query("error")
 
 	if state == "" {
+		trackDeviceLogin(c, nil, "github", deviceStepCallback, "missing_state")
 		renderDevicePage(c, "Missing state parameter", "", true, false)
 		return
 	}
 
-	deviceCode := findState(state)
-	session := getSessionCopy(deviceCode)
+	deviceCode := findState(c.Request.Context(), state)
+	session := getSessionCopy(c.Request.Context(), deviceCode)
 	if deviceCode == "" || session == nil {
+		trackDeviceLogin(c, nil, "github", deviceStepCallback, "session_not_found")
 		renderDevicePage(c, "Session not found or expired. Return to the CLI and try again.", "", true, false)
 		return
 	}
 
-	now := float64(time.Now().Unix())
-	if session.ExpiresAt <= now {
-		markSession(deviceCode, "expired", "")
-		removeSession(deviceCode)
+	now := time.Now()
+	if !session.ExpiresAt.After(now) {
+		markSession(c.Request.Context(), deviceCode, "expired", "")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "expired")
+		removeSession(c.Request.Context(), deviceCode)
 		renderDevicePage(c, "Session has expired. Please restart the login from the CLI.", "", true, false)
 		return
 	}
 
 	if errorParam != "" {
 		message, _ := url.QueryUnescape(errorParam)
-		markSession(deviceCode, "error", message)
+		markSession(c.Request.Context(), deviceCode, "error", message)
+		trackDeviceLogin(c, session, "", deviceStepCallback, oauthFailureReason(errorParam))
 		renderDevicePage(c, "Authorization failed: "+message, "", true, false)
 		return
 	}
 
 	if code == "" {
-		markSession(deviceCode, "error", "Missing authorization code")
+		markSession(c.Request.Context(), deviceCode, "error", "Missing authorization code")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_code")
 		renderDevicePage(c, "Missing authorization code", "", true, false)
 		return
 	}
@@ -734,20 +977,21 @@ func callbackGitHub(c *gin.Context) {
 	callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/github", scheme, host)
 
 	tokenData := url.Values{}
-	tokenData.Set("client_id", githubClientID)
-	tokenData.Set("client_secret", githubClientSecret)
+	tenant := tenantByID(session.TenantID)
+	tokenData.Set("client_id", tenant.GithubClientID)
+	tokenData.Set("client_secret", tenant.GithubClientSecret)
 	tokenData.Set("code", code)
 	tokenData.Set("redirect_uri", callbackURL)
 	tokenData.Set("state", state)
 
-	req, _ := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(tokenData.Encode()))
+	req, _ := newOutboundRequest(c.Request.Context(), "POST", "https://github.com/login/oauth/access_token", strings.NewReader(tokenData.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doUpstream("github", req)
 	if err != nil {
-		markSession(deviceCode, "error", err.Error())
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "provider_unavailable")
 		renderDevicePage(c, "Failed to contact GitHub. Please try again.", "", true, false)
 		return
 	}
@@ -756,7 +1000,8 @@ func callbackGitHub(c *gin.Context) {
 	if resp.StatusCode != http.StatusOK {
 		var errorData map[string]interface{}
 		json.NewDecoder(resp.Body).Decode(&errorData)
-		markSession(deviceCode, "error", "GitHub authorization failed")
+		markSession(c.Request.Context(), deviceCode, "error", "GitHub authorization failed")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "token_exchange_failed")
 		renderDevicePage(c, "GitHub authorization failed. Please try again.", "", true, false)
 		return
 	}
@@ -766,15 +1011,17 @@ func callbackGitHub(c *gin.Context) {
 
 	accessToken, ok := tokens["access_token"].(string)
 	if !ok || accessToken == "" {
-		markSession(deviceCode, "error", "Missing GitHub access token")
+		markSession(c.Request.Context(), deviceCode, "error", "Missing GitHub access token")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_token")
 		renderDevicePage(c, "GitHub response did not include an access token", "", true, false)
 		return
 	}
 
 	postBody := fmt.Sprintf("access_token=%s&providerId=github.com", url.QueryEscape(accessToken))
-	firebaseData, err := firebaseExchange(postBody)
+	firebaseData, err := firebaseExchange(c.Request.Context(), postBody)
 	if err != nil {
-		markSession(deviceCode, "error", err.Error())
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "firebase_failed")
 		renderDevicePage(c, "Firebase authentication failed", "", true, false)
 		return
 	}
@@ -793,14 +1040,276 @@ func callbackGitHub(c *gin.Context) {
 		authDict["local_id"] = *authResp.LocalID
 	}
 
-	setSessionTokens(deviceCode, authDict)
-	renderDevicePage(c, "Authentication complete. You may return to the CLI to finish logging in.", "", false, false)
+	setSessionTokens(c.Request.Context(), deviceCode, authDict)
+	trackDeviceLogin(c, session, "", deviceStepCallback, "")
+	renderDevicePage(c, "Authentication complete. You may return to "+deviceClientName(c.Request.Context(), session)+" to finish logging in.", "", false, false)
+}
+
+// callbackGitLab finishes a GitLab login, on gitlab.com or the instance at
+// GITLAB_BASE_URL. GitLab is not a built-in Firebase provider, so its ID
+// token is exchanged through the OpenID Connect provider gitlabProviderID,
+// which must be set up in Identity Platform with the same client.
+func callbackGitLab(c *gin.Context) {
+	state := c.Query("state")
+	code := c.Query("code")
+	errorParam := c.Query("error")
+
+	if state == "" {
+		trackDeviceLogin(c, nil, "gitlab", deviceStepCallback, "missing_state")
+		renderDevicePage(c, "Missing state parameter", "", true, false)
+		return
+	}
+
+	deviceCode := findState(c.Request.Context(), state)
+	session := getSessionCopy(c.Request.Context(), deviceCode)
+	if deviceCode == "" || session == nil {
+		trackDeviceLogin(c, nil, "gitlab", deviceStepCallback, "session_not_found")
+		renderDevicePage(c, "Session not found or expired. Return to the CLI and try again.", "", true, false)
+		return
+	}
+
+	now := time.Now()
+	if !session.ExpiresAt.After(now) {
+		markSession(c.Request.Context(), deviceCode, "expired", "")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "expired")
+		removeSession(c.Request.Context(), deviceCode)
+		renderDevicePage(c, "Session has expired. Please restart the login from the CLI.", "", true, false)
+		return
+	}
+
+	if errorParam != "" {
+		message, _ := url.QueryUnescape(errorParam)
+		markSession(c.Request.Context(), deviceCode, "error", message)
+		trackDeviceLogin(c, session, "", deviceStepCallback, oauthFailureReason(errorParam))
+		renderDevicePage(c, "Authorization failed: "+message, "", true, false)
+		return
+	}
+
+	if code == "" {
+		markSession(c.Request.Context(), deviceCode, "error", "Missing authorization code")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_code")
+		renderDevicePage(c, "Missing authorization code", "", true, false)
+		return
+	}
+
+	scheme := "http"
+	if c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.GetHeader("Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/gitlab", scheme, host)
+
+	tokenData := url.Values{}
+	tokenData.Set("code", code)
+	tenant := tenantByID(session.TenantID)
+	tokenData.Set("client_id", tenant.GitlabClientID)
+	tokenData.Set("client_secret", tenant.GitlabClientSecret)
+	tokenData.Set("redirect_uri", callbackURL)
+	tokenData.Set("grant_type", "authorization_code")
+
+	req, _ := newOutboundRequest(c.Request.Context(), "POST", tenant.GitlabBaseURL+"/oauth/token", strings.NewReader(tokenData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := doUpstream("gitlab", req)
+	if err != nil {
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "provider_unavailable")
+		renderDevicePage(c, "Failed to contact GitLab. Please try again.", "", true, false)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorData map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&errorData)
+		markSession(c.Request.Context(), deviceCode, "error", "GitLab authorization failed")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "token_exchange_failed")
+		renderDevicePage(c, "GitLab authorization failed. Please try again.", "", true, false)
+		return
+	}
+
+	var tokens map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&tokens)
+
+	idToken, ok := tokens["id_token"].(string)
+	if !ok || idToken == "" {
+		markSession(c.Request.Context(), deviceCode, "error", "Missing GitLab ID token")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_token")
+		renderDevicePage(c, "GitLab response did not include an ID token", "", true, false)
+		return
+	}
+
+	postBody := fmt.Sprintf("id_token=%s&providerId=%s", url.QueryEscape(idToken), gitlabProviderID)
+	firebaseData, err := firebaseExchange(c.Request.Context(), postBody)
+	if err != nil {
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "firebase_failed")
+		renderDevicePage(c, "Firebase authentication failed", "", true, false)
+		return
+	}
+
+	authResp := parseAuthResponse(firebaseData)
+	authDict := map[string]interface{}{
+		"id_token":      authResp.IDToken,
+		"refresh_token": authResp.RefreshToken,
+		"expires_in":    authResp.ExpiresIn,
+		"provider":      "gitlab",
+	}
+	if authResp.Email != nil {
+		authDict["email"] = *authResp.Email
+	}
+	if authResp.LocalID != nil {
+		authDict["local_id"] = *authResp.LocalID
+	}
+
+	setSessionTokens(c.Request.Context(), deviceCode, authDict)
+	trackDeviceLogin(c, session, "", deviceStepCallback, "")
+	renderDevicePage(c, "Authentication complete. You may return to "+deviceClientName(c.Request.Context(), session)+" to finish logging in.", "", false, false)
+}
+
+// microsoftEndpoint returns a Microsoft identity platform v2.0 endpoint for
+// the Entra ID tenant ("common", "organizations", a directory ID, or a domain).
+func microsoftEndpoint(directory, endpoint string) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/%s", microsoftLoginURL, url.PathEscape(directory), endpoint)
+}
+
+// callbackMicrosoft finishes a Microsoft Entra ID login. The ID token is
+// exchanged with Firebase's built-in microsoft.com provider, which must be
+// enabled with the same app registration.
+func callbackMicrosoft(c *gin.Context) {
+	state := c.Query("state")
+	code := c.Query("code")
+	errorParam := c.Query("error")
+
+	if state == "" {
+		trackDeviceLogin(c, nil, "microsoft", deviceStepCallback, "missing_state")
+		renderDevicePage(c, "Missing state parameter", "", true, false)
+		return
+	}
+
+	deviceCode := findState(c.Request.Context(), state)
+	session := getSessionCopy(c.Request.Context(), deviceCode)
+	if deviceCode == "" || session == nil {
+		trackDeviceLogin(c, nil, "microsoft", deviceStepCallback, "session_not_found")
+		renderDevicePage(c, "Session not found or expired. Return to the CLI and try again.", "", true, false)
+		return
+	}
+
+	now := time.Now()
+	if !session.ExpiresAt.After(now) {
+		markSession(c.Request.Context(), deviceCode, "expired", "")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "expired")
+		removeSession(c.Request.Context(), deviceCode)
+		renderDevicePage(c, "Session has expired. Please restart the login from the CLI.", "", true, false)
+		return
+	}
+
+	if errorParam != "" {
+		// Entra ID puts the readable reason, prefixed with an AADSTS code,
+		// in error_description.
+		message := c.Query("error_description")
+		if message == "" {
+			message = errorParam
+		}
+		markSession(c.Request.Context(), deviceCode, "error", message)
+		trackDeviceLogin(c, session, "", deviceStepCallback, oauthFailureReason(errorParam))
+		renderDevicePage(c, "Authorization failed: "+message, "", true, false)
+		return
+	}
+
+	if code == "" {
+		markSession(c.Request.Context(), deviceCode, "error", "Missing authorization code")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_code")
+		renderDevicePage(c, "Missing authorization code", "", true, false)
+		return
+	}
+
+	scheme := "http"
+	if c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.GetHeader("Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	callbackURL := fmt.Sprintf("%s://%s/api/v1/auth/device/callback/microsoft", scheme, host)
+
+	tokenData := url.Values{}
+	tokenData.Set("code", code)
+	tenant := tenantByID(session.TenantID)
+	tokenData.Set("client_id", tenant.MicrosoftClientID)
+	tokenData.Set("client_secret", tenant.MicrosoftClientSecret)
+	tokenData.Set("redirect_uri", callbackURL)
+	tokenData.Set("grant_type", "authorization_code")
+	tokenData.Set("scope", "openid email profile")
+
+	req, _ := newOutboundRequest(c.Request.Context(), "POST", microsoftEndpoint(tenant.MicrosoftTenantID, "token"), strings.NewReader(tokenData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := doUpstream("microsoft", req)
+	if err != nil {
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "provider_unavailable")
+		renderDevicePage(c, "Failed to contact Microsoft. Please try again.", "", true, false)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorData map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&errorData)
+		markSession(c.Request.Context(), deviceCode, "error", "Microsoft authorization failed")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "token_exchange_failed")
+		renderDevicePage(c, "Microsoft authorization failed. Please try again.", "", true, false)
+		return
+	}
+
+	var tokens map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&tokens)
+
+	idToken, ok := tokens["id_token"].(string)
+	if !ok || idToken == "" {
+		markSession(c.Request.Context(), deviceCode, "error", "Missing Microsoft ID token")
+		trackDeviceLogin(c, session, "", deviceStepCallback, "missing_token")
+		renderDevicePage(c, "Microsoft response did not include an ID token", "", true, false)
+		return
+	}
+
+	postBody := fmt.Sprintf("id_token=%s&providerId=microsoft.com", url.QueryEscape(idToken))
+	firebaseData, err := firebaseExchange(c.Request.Context(), postBody)
+	if err != nil {
+		markSession(c.Request.Context(), deviceCode, "error", err.Error())
+		trackDeviceLogin(c, session, "", deviceStepCallback, "firebase_failed")
+		renderDevicePage(c, "Firebase authentication failed", "", true, false)
+		return
+	}
+
+	authResp := parseAuthResponse(firebaseData)
+	authDict := map[string]interface{}{
+		"id_token":      authResp.IDToken,
+		"refresh_token": authResp.RefreshToken,
+		"expires_in":    authResp.ExpiresIn,
+		"provider":      "microsoft",
+	}
+	if authResp.Email != nil {
+		authDict["email"] = *authResp.Email
+	}
+	if authResp.LocalID != nil {
+		authDict["local_id"] = *authResp.LocalID
+	}
+
+	setSessionTokens(c.Request.Context(), deviceCode, authDict)
+	trackDeviceLogin(c, session, "", deviceStepCallback, "")
+	renderDevicePage(c, "Authentication complete. You may return to "+deviceClientName(c.Request.Context(), session)+" to finish logging in.", "", false, false)
 }
 
 func registerUser(c *gin.Context) {
 	var req models.AuthRegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
@@ -814,20 +1323,19 @@ func registerUser(c *gin.Context) {
 	}
 
 	jsonData, _ := json.Marshal(payload)
-	reqHTTP, _ := http.NewRequest("POST", identityURL("accounts:signUp"), bytes.NewBuffer(jsonData))
+	reqHTTP, _ := newOutboundRequest(c.Request.Context(), "POST", identityURL("accounts:signUp"), bytes.NewBuffer(jsonData))
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, upstreamError("Identity service unavailable", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	data, err := parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
@@ -837,7 +1345,7 @@ func registerUser(c *gin.Context) {
 func loginUser(c *gin.Context) {
 	var req models.AuthLoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
@@ -848,30 +1356,30 @@ func loginUser(c *gin.Context) {
 	}
 
 	jsonData, _ := json.Marshal(payload)
-	reqHTTP, _ := http.NewRequest("POST", identityURL("accounts:signInWithPassword"), bytes.NewBuffer(jsonData))
+	reqHTTP, _ := newOutboundRequest(c.Request.Context(), "POST", identityURL("accounts:signInWithPassword"), bytes.NewBuffer(jsonData))
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, upstreamError("Identity service unavailable", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	data, err := parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
+	publishAuthAlert(c, "auth.login", data, map[string]interface{}{"method": "password"})
 	c.JSON(http.StatusOK, parseAuthResponse(data))
 }
 
 func loginProvider(c *gin.Context) {
 	var req models.AuthProviderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
@@ -886,7 +1394,7 @@ func loginProvider(c *gin.Context) {
 			token = *req.AccessToken
 		}
 		if token == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"detail": "Missing id_token or access_token for Google login"})
+			respondError(c, newAPIError(http.StatusBadRequest, "Missing id_token or access_token for Google login"))
 			return
 		}
 		field := "id_token"
@@ -896,28 +1404,53 @@ func loginProvider(c *gin.Context) {
 		postBody = fmt.Sprintf("%s=%s&providerId=google.com", field, url.QueryEscape(token))
 	} else if provider == "github" {
 		if req.AccessToken == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"detail": "Missing access_token for GitHub login"})
+			respondError(c, newAPIError(http.StatusBadRequest, "Missing access_token for GitHub login"))
 			return
 		}
 		postBody = fmt.Sprintf("access_token=%s&providerId=github.com", url.QueryEscape(*req.AccessToken))
+	} else if provider == "microsoft" {
+		if req.IDToken == nil {
+			respondError(c, newAPIError(http.StatusBadRequest, "Missing id_token for Microsoft login"))
+			return
+		}
+		postBody = fmt.Sprintf("id_token=%s&providerId=microsoft.com", url.QueryEscape(*req.IDToken))
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": fmt.Sprintf("Unsupported provider '%s'", req.Provider)})
+		respondError(c, newAPIError(http.StatusBadRequest, fmt.Sprintf("Unsupported provider '%s'", req.Provider)))
 		return
 	}
 
-	data, err := firebaseExchange(postBody)
+	data, err := firebaseExchange(c.Request.Context(), postBody)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
+	publishAuthAlert(c, "auth.login", data, map[string]interface{}{"method": provider})
 	c.JSON(http.StatusOK, parseAuthResponse(data))
 }
 
+// publishAuthAlert notifies the account's own webhooks about sign-ins and
+// credential changes so users can spot activity they did not initiate.
+func publishAuthAlert(c *gin.Context, event string, data map[string]interface{}, extra map[string]interface{}) {
+	userID, _ := data["localId"].(string)
+	if userID == "" {
+		return
+	}
+	alert := map[string]interface{}{
+		"user_id":    userID,
+		"ip":         c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+	}
+	for key, value := range extra {
+		alert[key] = value
+	}
+	emit(c.Request.Context(), event, requestTenant(c).ID, userID, alert)
+}
+
 func refreshToken(c *gin.Context) {
 	var req models.AuthRefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
@@ -926,68 +1459,150 @@ func refreshToken(c *gin.Context) {
 	payload.Set("refresh_token", req.RefreshToken)
 
 	url := fmt.Sprintf("%s?key=%s", secureTokenURL, firebaseAPIKey)
-	reqHTTP, _ := http.NewRequest("POST", url, strings.NewReader(payload.Encode()))
+	reqHTTP, _ := newOutboundRequest(c.Request.Context(), "POST", url, strings.NewReader(payload.Encode()))
 	reqHTTP.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, upstreamError("Identity service unavailable", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	data, err := parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, parseAuthResponse(data))
 }
 
-func getProfile(c *gin.Context) {
-	idToken := c.GetHeader("X-ID-Token")
-	authHeader := c.GetHeader("Authorization")
-
-	token := idToken
-	if token == "" {
-		var err error
-		token, err = extractToken(authHeader)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"detail": err.Error()})
-			return
-		}
+func requestToken(c *gin.Context) (string, error) {
+	if idToken := c.GetHeader("X-ID-Token"); idToken != "" {
+		return idToken, nil
 	}
+	return extractToken(c.GetHeader("Authorization"))
+}
 
+func lookupAccount(ctx context.Context, token string) (map[string]interface{}, error) {
 	payload := map[string]interface{}{"idToken": token}
 	jsonData, _ := json.Marshal(payload)
-	reqHTTP, _ := http.NewRequest("POST", identityURL("accounts:lookup"), bytes.NewBuffer(jsonData))
+	reqHTTP, _ := newOutboundRequest(ctx, "POST", identityURL("accounts:lookup"), bytes.NewBuffer(jsonData))
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	data, err := parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
-		return
+		return nil, err
 	}
 
 	users, ok := data["users"].([]interface{})
 	if !ok || len(users) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"detail": "User not found"})
-		return
+		return nil, nil
 	}
 
 	userData, ok := users[0].(map[string]interface{})
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"detail": "User not found"})
+		return nil, nil
+	}
+	return userData, nil
+}
+
+// authenticatedAccount looks up the caller's account, answering 401 when the
+// token is missing or invalid and 403 when the account is not a member of
+// the request's tenant. The account is kept on the request, so the handler
+// does not repeat the lookup Authorize already made.
+func authenticatedAccount(c *gin.Context) (map[string]interface{}, bool) {
+	account, ok := lookupRequestAccount(c)
+	if !ok {
+		return nil, false
+	}
+	if tenant := requestTenant(c); !tenantMember(tenant, account) {
+		respondError(c, notTenantMember(tenant))
+		return nil, false
+	}
+	return account, true
+}
+
+func lookupRequestAccount(c *gin.Context) (map[string]interface{}, bool) {
+	if account, ok := c.Get("account"); ok {
+		return account.(map[string]interface{}), true
+	}
+	if signedRequest(c) {
+		return signedAccount(c)
+	}
+	if apiKeyRequest(c) {
+		return apiKeyAccount(c)
+	}
+	token, err := requestToken(c)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusUnauthorized, err.Error()))
+		return nil, false
+	}
+	if strings.HasPrefix(token, partnerTokenPrefix) {
+		return partnerAccount(c, token)
+	}
+
+	userData, err := lookupAccount(c.Request.Context(), token)
+	if err != nil || userData == nil {
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid or expired token"))
+		return nil, false
+	}
+
+	localID, _ := userData["localId"].(string)
+	if localID == "" {
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid or expired token"))
+		return nil, false
+	}
+	c.Set("user_id", localID)
+	c.Set("account", userData)
+	return userData, true
+}
+
+func optionalUser(c *gin.Context) (string, bool) {
+	if c.GetHeader("X-ID-Token") == "" && c.GetHeader("Authorization") == "" && !signedRequest(c) {
+		return "", true
+	}
+	return authenticatedUser(c)
+}
+
+func authenticatedUser(c *gin.Context) (string, bool) {
+	userData, ok := authenticatedAccount(c)
+	if !ok {
+		return "", false
+	}
+	localID, _ := userData["localId"].(string)
+	return localID, true
+}
+
+func getProfile(c *gin.Context) {
+	token, err := requestToken(c)
+	if signedRequest(c) || (err == nil && strings.HasPrefix(token, partnerTokenPrefix)) {
+		// Signed requests and partner tokens carry no ID token to look up;
+		// the account Authorize found is all there is.
+		if account, ok := authenticatedAccount(c); ok {
+			c.JSON(http.StatusOK, parseProfileResponse(account))
+		}
+		return
+	}
+	if err != nil {
+		respondError(c, newAPIError(http.StatusUnauthorized, err.Error()))
+		return
+	}
+
+	userData, err := lookupAccount(c.Request.Context(), token)
+	if err != nil {
+		respondError(c, identityError(err))
+		return
+	}
+	if userData == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "User not found"))
 		return
 	}
 
@@ -998,13 +1613,13 @@ func updateProfile(c *gin.Context) {
 	authHeader := c.GetHeader("Authorization")
 	token, err := extractToken(authHeader)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"detail": err.Error()})
+		respondError(c, newAPIError(http.StatusUnauthorized, err.Error()))
 		return
 	}
 
 	var req models.AuthUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": "Invalid request"})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
@@ -1020,23 +1635,28 @@ func updateProfile(c *gin.Context) {
 	}
 
 	jsonData, _ := json.Marshal(payload)
-	reqHTTP, _ := http.NewRequest("POST", identityURL("accounts:update"), bytes.NewBuffer(jsonData))
+	reqHTTP, _ := newOutboundRequest(c.Request.Context(), "POST", identityURL("accounts:update"), bytes.NewBuffer(jsonData))
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, upstreamError("Identity service unavailable", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	data, err := parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
+	if req.Password != nil {
+		publishAuthAlert(c, "auth.password_changed", data, nil)
+	}
+	if req.DisplayName != nil {
+		publishAuthAlert(c, "auth.profile_updated", data, map[string]interface{}{"display_name": *req.DisplayName})
+	}
 	c.JSON(http.StatusOK, parseProfileResponse(data))
 }
 
@@ -1044,26 +1664,25 @@ func deleteProfile(c *gin.Context) {
 	authHeader := c.GetHeader("Authorization")
 	token, err := extractToken(authHeader)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"detail": err.Error()})
+		respondError(c, newAPIError(http.StatusUnauthorized, err.Error()))
 		return
 	}
 
 	payload := map[string]interface{}{"idToken": token}
 	jsonData, _ := json.Marshal(payload)
-	reqHTTP, _ := http.NewRequest("POST", identityURL("accounts:delete"), bytes.NewBuffer(jsonData))
+	reqHTTP, _ := newOutboundRequest(c.Request.Context(), "POST", identityURL("accounts:delete"), bytes.NewBuffer(jsonData))
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(reqHTTP)
+	resp, err := doUpstream("firebase", reqHTTP)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, upstreamError("Identity service unavailable", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	_, err = parseFirebaseResponse(resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"detail": err.Error()})
+		respondError(c, identityError(err))
 		return
 	}
 
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+)
+
+var checkBudget = flag.Bool("budget", false, "run the hot-path benchmarks against testdata/bench/budget.json")
+
+const (
+	budgetFile = "testdata/bench/budget.json"
+
+	// benchRegistrySize is how many servers the registry benchmarks load,
+	// roughly the size of the public registry.
+	benchRegistrySize = 500
+
+	// A benchmark may be this much slower than its recorded baseline, or
+	// allocate this much more, before the budget check fails. Time is noisy
+	// across machines, allocations much less so.
+	budgetTimeSlack   = 1.5
+	budgetAllocsSlack = 1.2
+)
+
+// seedBenchRegistry writes benchRegistrySize servers straight into the
+// storage fake, skipping the publish route.
+func seedBenchRegistry(b *testing.B, h *harness) {
+	b.Helper()
+	for i := range benchRegistrySize {
+		server := loadFixture(b, "server_free", map[string]interface{}{"name": fmt.Sprintf("bench-%04d", i)})
+		if _, err := h.storage.call(context.Background(), "upsert_server", map[string]interface{}{
+			"bucket_name": testBucket,
+			"server_name": server["name"],
+			"server_data": server,
+		}); err != nil {
+			b.Fatalf("seed registry: %v", err)
+		}
+	}
+}
+
+func BenchmarkListServers(b *testing.B) {
+	h := newHarness(b)
+	seedBenchRegistry(b, h)
+	h.do(http.MethodGet, "/api/v1/servers", "", nil).expect(b, http.StatusOK)
+
+	b.ResetTimer()
+	for range b.N {
+		h.do(http.MethodGet, "/api/v1/servers", "", nil).expect(b, http.StatusOK)
+	}
+}
+
+// BenchmarkSearchServers pages through the v2 listing the way the CLI's
+// search and the web catalog do.
+func BenchmarkSearchServers(b *testing.B) {
+	h := newHarness(b)
+	seedBenchRegistry(b, h)
+	h.do(http.MethodGet, "/api/v2/servers?limit=20", "", nil).expect(b, http.StatusOK)
+
+	b.ResetTimer()
+	for range b.N {
+		h.do(http.MethodGet, "/api/v2/servers?limit=20", "", nil).expect(b, http.StatusOK)
+	}
+}
+
+func BenchmarkGetServer(b *testing.B) {
+	h := newHarness(b)
+	seedBenchRegistry(b, h)
+	h.do(http.MethodGet, "/api/v1/servers", "", nil).expect(b, http.StatusOK)
+
+	b.ResetTimer()
+	for i := range b.N {
+		path := fmt.Sprintf("/api/v1/servers/bench-%04d", i%benchRegistrySize)
+		h.do(http.MethodGet, path, "", nil).expect(b, http.StatusOK)
+	}
+}
+
+func BenchmarkDevicePoll(b *testing.B) {
+	h := newHarness(b)
+	start := h.do(http.MethodPost, "/api/v1/auth/device/start", "", map[string]string{"provider": "google", "client_id": officialClientID}).expect(b, http.StatusOK)
+	poll := map[string]string{"device_code": start.str("device_code")}
+
+	b.ResetTimer()
+	for range b.N {
+		h.do(http.MethodPost, "/api/v1/auth/device/poll", "", poll).expect(b, http.StatusAccepted)
+	}
+}
+
+// BenchmarkCreateOrder includes the Firebase account lookup and the
+// Razorpay order call, both against local fakes. Each order comes from a new
+// buyer so velocity checks do not start holding them.
+func BenchmarkCreateOrder(b *testing.B) {
+	h := newHarness(b)
+	_, publisherToken := h.identity.addUser("bench-seller@example.com")
+	h.publish(publisherToken, "server_paid", map[string]interface{}{"name": "bench-invoices"})
+	order := map[string]interface{}{"server_name": "bench-invoices", "plan": "standard"}
+
+	b.ResetTimer()
+	for i := range b.N {
+		b.StopTimer()
+		_, buyerToken := h.identity.addUser(fmt.Sprintf("bench-buyer-%d@example.com", i))
+		b.StartTimer()
+		h.do(http.MethodPost, "/api/v1/payment/create-order", buyerToken, order).expect(b, http.StatusOK)
+	}
+}
+
+// benchBaseline is one entry of testdata/bench/budget.json.
+type benchBaseline struct {
+	NsPerOp     int64 `json:"ns_per_op"`
+	AllocsPerOp int64 `json:"allocs_per_op"`
+}
+
+var budgetedBenchmarks = map[string]func(*testing.B){
+	"BenchmarkListServers":   BenchmarkListServers,
+	"BenchmarkSearchServers": BenchmarkSearchServers,
+	"BenchmarkGetServer":     BenchmarkGetServer,
+	"BenchmarkDevicePoll":    BenchmarkDevicePoll,
+	"BenchmarkCreateOrder":   BenchmarkCreateOrder,
+}
+
+// TestPerformanceBudget runs the hot-path benchmarks and fails when one is
+// well over its recorded baseline. It only runs when asked, since timings
+// depend on the machine:
+//
+//	go test ./handlers -run TestPerformanceBudget -budget
+//
+// Add -update to record the current results as the new baselines.
+func TestPerformanceBudget(t *testing.T) {
+	if !*checkBudget {
+		t.Skip("run with -budget to check benchmarks against their baselines")
+	}
+
+	baselines := map[string]benchBaseline{}
+	data, err := os.ReadFile(budgetFile)
+	if err != nil && !*updateGolden {
+		t.Fatalf("read %s: %v", budgetFile, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &baselines); err != nil {
+			t.Fatalf("parse %s: %v", budgetFile, err)
+		}
+	}
+
+	names := make([]string, 0, len(budgetedBenchmarks))
+	for name := range budgetedBenchmarks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	measured := make(map[string]benchBaseline, len(names))
+	for _, name := range names {
+		result := testing.Benchmark(budgetedBenchmarks[name])
+		if result.N == 0 {
+			t.Fatalf("%s failed to run", name)
+		}
+		got := benchBaseline{NsPerOp: result.NsPerOp(), AllocsPerOp: result.AllocsPerOp()}
+		measured[name] = got
+		t.Logf("%s: %d ns/op, %d allocs/op", name, got.NsPerOp, got.AllocsPerOp)
+
+		if *updateGolden {
+			continue
+		}
+		want, ok := baselines[name]
+		if !ok {
+			t.Errorf("%s has no baseline; run with -update to record one", name)
+			continue
+		}
+		if float64(got.NsPerOp) > float64(want.NsPerOp)*budgetTimeSlack {
+			t.Errorf("%s: %d ns/op is over budget (baseline %d ns/op)", name, got.NsPerOp, want.NsPerOp)
+		}
+		if float64(got.AllocsPerOp) > float64(want.AllocsPerOp)*budgetAllocsSlack {
+			t.Errorf("%s: %d allocs/op is over budget (baseline %d allocs/op)", name, got.AllocsPerOp, want.AllocsPerOp)
+		}
+	}
+
+	if *updateGolden {
+		data, err := json.MarshalIndent(measured, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(budgetFile, append(data, '\n'), 0o644); err != nil {
+			t.Fatalf("write %s: %v", budgetFile, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type countryRules struct {
+	PostalCode    *regexp.Regexp
+	TaxID         *regexp.Regexp
+	TaxIDName     string
+	StateRequired bool
+}
+
+var billingCountryRules = map[string]countryRules{
+	"IN": {
+		PostalCode:    regexp.MustCompile(`^[1-9][0-9]{5}$`),
+		TaxID:         regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`),
+		TaxIDName:     "GSTIN",
+		StateRequired: true,
+	},
+	"US": {
+		PostalCode:    regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`),
+		TaxID:         regexp.MustCompile(`^[0-9]{2}-?[0-9]{7}$`),
+		TaxIDName:     "EIN",
+		StateRequired: true,
+	},
+	"CA": {
+		PostalCode:    regexp.MustCompile(`^[A-Z][0-9][A-Z] ?[0-9][A-Z][0-9]$`),
+		TaxID:         regexp.MustCompile(`^[0-9]{9}(RT[0-9]{4})?$`),
+		TaxIDName:     "business number",
+		StateRequired: true,
+	},
+	"GB": {
+		PostalCode: regexp.MustCompile(`^[A-Z]{1,2}[0-9][A-Z0-9]? ?[0-9][A-Z]{2}$`),
+		TaxID:      regexp.MustCompile(`^GB([0-9]{9}|[0-9]{12})$`),
+		TaxIDName:  "VAT number",
+	},
+	"DE": {
+		PostalCode: regexp.MustCompile(`^[0-9]{5}$`),
+		TaxID:      regexp.MustCompile(`^DE[0-9]{9}$`),
+		TaxIDName:  "VAT number",
+	},
+	"FR": {
+		PostalCode: regexp.MustCompile(`^[0-9]{5}$`),
+		TaxID:      regexp.MustCompile(`^FR[0-9A-Z]{2}[0-9]{9}$`),
+		TaxIDName:  "VAT number",
+	},
+	"AU": {
+		PostalCode:    regexp.MustCompile(`^[0-9]{4}$`),
+		TaxID:         regexp.MustCompile(`^[0-9]{11}$`),
+		TaxIDName:     "ABN",
+		StateRequired: true,
+	},
+}
+
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// billingProfiles are keyed by user ID.
+var billingProfiles = recordSet[models.BillingProfile]{kind: "billing_profile"}
+
+func validateBillingProfile(req *models.BillingProfileRequest) []FieldError {
+	problems := []FieldError{}
+
+	req.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	req.PostalCode = strings.ToUpper(strings.TrimSpace(req.PostalCode))
+	req.TaxID = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(req.TaxID), " ", ""))
+
+	if strings.TrimSpace(req.Name) == "" {
+		problems = append(problems, FieldError{Field: "name", Message: "is required"})
+	}
+	if strings.TrimSpace(req.AddressLine1) == "" {
+		problems = append(problems, FieldError{Field: "address_line1", Message: "is required"})
+	}
+	if strings.TrimSpace(req.City) == "" {
+		problems = append(problems, FieldError{Field: "city", Message: "is required"})
+	}
+	if !countryCodePattern.MatchString(req.Country) {
+		problems = append(problems, FieldError{Field: "country", Message: "must be an ISO 3166-1 alpha-2 code"})
+		return problems
+	}
+	if req.PostalCode == "" {
+		problems = append(problems, FieldError{Field: "postal_code", Message: "is required"})
+	}
+
+	rules, known := billingCountryRules[req.Country]
+	if !known {
+		return problems
+	}
+	if rules.StateRequired && strings.TrimSpace(req.State) == "" {
+		problems = append(problems, FieldError{Field: "state", Message: "is required for " + req.Country + " addresses"})
+	}
+	if req.PostalCode != "" && !rules.PostalCode.MatchString(req.PostalCode) {
+		problems = append(problems, FieldError{Field: "postal_code", Message: "is not valid for " + req.Country})
+	}
+	if req.TaxID != "" && !rules.TaxID.MatchString(req.TaxID) {
+		problems = append(problems, FieldError{Field: "tax_id", Message: fmt.Sprintf("must be a valid %s for %s", rules.TaxIDName, req.Country)})
+	}
+	return problems
+}
+
+// getBillingProfileCopy returns a user's billing profile, or nil when they
+// have none on file.
+func getBillingProfileCopy(ctx context.Context, userID string) (*models.BillingProfile, error) {
+	profile, err := billingProfiles.get(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return profile, err
+}
+
+func getBillingProfile(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	profile, err := getBillingProfileCopy(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load billing profile", err))
+		return
+	}
+	if profile == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "No billing profile on file"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"profile": profile,
+	})
+}
+
+func putBillingProfile(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	var req models.BillingProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	if problems := validateBillingProfile(&req); len(problems) > 0 {
+		apiErr := validationFailed()
+		apiErr.Fields = problems
+		respondError(c, apiErr)
+		return
+	}
+
+	profile := &models.BillingProfile{
+		UserID:       userID,
+		Name:         strings.TrimSpace(req.Name),
+		Company:      strings.TrimSpace(req.Company),
+		AddressLine1: strings.TrimSpace(req.AddressLine1),
+		AddressLine2: strings.TrimSpace(req.AddressLine2),
+		City:         strings.TrimSpace(req.City),
+		State:        strings.TrimSpace(req.State),
+		PostalCode:   req.PostalCode,
+		Country:      req.Country,
+		TaxID:        req.TaxID,
+		UpdatedAt:    models.Now(),
+	}
+
+	if err := billingProfiles.put(c.Request.Context(), userID, profile); err != nil {
+		respondError(c, internalError("Failed to save billing profile", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"profile": profile,
+	})
+}
+
+func deleteBillingProfile(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	if err := billingProfiles.delete(c.Request.Context(), userID); err != nil {
+		respondError(c, internalError("Failed to delete billing profile", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Billing profile deleted",
+	})
+}
+
+// listInvoices lists the account's paid orders, newest first.
+func listInvoices(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	placed, err := userOrders(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Error listing invoices", err))
+		return
+	}
+	invoices := []models.Invoice{}
+	for _, order := range placed {
+		if order.PaidAt.IsZero() || !orderInTenant(&order, requestTenant(c).ID) {
+			continue
+		}
+		invoices = append(invoices, models.Invoice{
+			OrderID:        order.ID,
+			Kind:           order.Kind,
+			ServerName:     order.ServerName,
+			Plan:           order.Plan,
+			Period:         order.Period,
+			Amount:         order.Amount,
+			RefundedAmount: order.RefundedAmount,
+			Currency:       order.Currency,
+			Provider:       order.Provider,
+			Status:         order.Status,
+			SubscriptionID: order.SubscriptionID,
+			Billing:        order.Billing,
+			PaidAt:         order.PaidAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"total":    len(invoices),
+		"invoices": invoices,
+	})
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// billingManagers are keyed by tenant, account, and manager, and listed per
+// account.
+var billingManagers = recordSet[models.BillingManager]{
+	kind:  "billing_manager",
+	group: func(grant *models.BillingManager) string { return grant.TenantID + "/" + grant.AccountID },
+}
+
+func billingManagerKey(tenantID string, accountID string, managerID string) string {
+	return tenantID + "/" + accountID + "/" + managerID
+}
+
+// managesBilling reports whether managerID was made a billing manager of
+// accountID.
+func managesBilling(ctx context.Context, tenantID string, accountID string, managerID string) (bool, error) {
+	_, err := billingManagers.get(ctx, billingManagerKey(tenantID, accountID, managerID))
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// billingAccount is the account whose billing a billing route acts on: the
+// caller's own, or the one named by ?account= once the policy check has let
+// the caller act for it.
+func billingAccount(c *gin.Context) (string, bool) {
+	if accountID := c.GetString("billing_account"); accountID != "" {
+		return accountID, true
+	}
+	return authenticatedUser(c)
+}
+
+// requireBillingAccess lets the caller act on the billing of the account
+// named by ?account=: their own, one that made them a billing manager, or
+// any account for an admin. It records the account for billingAccount.
+func requireBillingAccess(c *gin.Context, userID string) bool {
+	accountID := c.DefaultQuery("account", userID)
+	if !ownsResource(c, accountID) {
+		manages, err := managesBilling(c.Request.Context(), requestTenant(c).ID, accountID, userID)
+		if err != nil {
+			respondError(c, internalError("Error checking billing access", err))
+			return false
+		}
+		if !manages {
+			respondError(c, newAPIError(http.StatusForbidden, "You do not manage billing for this account").
+				withCode("billing_manager_required").
+				withDetail("account", accountID))
+			return false
+		}
+	}
+	c.Set("billing_account", accountID)
+	return true
+}
+
+// managedAccount is the account whose billing managers a route lists or
+// changes: the :user_id an admin route names, or the caller's own.
+func managedAccount(c *gin.Context) (string, bool) {
+	if accountID := c.Param("user_id"); accountID != "" {
+		return accountID, true
+	}
+	return authenticatedUser(c)
+}
+
+func listBillingManagers(c *gin.Context) {
+	accountID, ok := managedAccount(c)
+	if !ok {
+		return
+	}
+	grants, err := billingManagers.listGroup(c.Request.Context(), requestTenant(c).ID+"/"+accountID)
+	if err != nil {
+		respondError(c, internalError("Error loading billing managers", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "success",
+		"total":            len(grants),
+		"billing_managers": grants,
+	})
+}
+
+// grantBillingManager makes a user a billing manager of an account. Granting
+// an existing manager again changes nothing.
+func grantBillingManager(c *gin.Context) {
+	accountID, ok := managedAccount(c)
+	if !ok {
+		return
+	}
+	managerID := c.Param("manager_id")
+	if managerID == accountID {
+		respondError(c, newAPIError(http.StatusBadRequest, "An account already manages its own billing"))
+		return
+	}
+
+	tenantID := requestTenant(c).ID
+	grant := &models.BillingManager{
+		TenantID:  tenantID,
+		AccountID: accountID,
+		ManagerID: managerID,
+		GrantedBy: c.GetString("user_id"),
+		GrantedAt: models.Now(),
+	}
+	created, err := billingManagers.create(c.Request.Context(), billingManagerKey(tenantID, accountID, managerID), grant)
+	if err != nil {
+		respondError(c, internalError("Error saving billing manager", err))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		auditChange(c, nil, grant)
+	} else if grant, err = billingManagers.get(c.Request.Context(), billingManagerKey(tenantID, accountID, managerID)); err != nil {
+		respondError(c, internalError("Error loading billing manager", err))
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"status":          "success",
+		"billing_manager": grant,
+	})
+}
+
+func revokeBillingManager(c *gin.Context) {
+	accountID, ok := managedAccount(c)
+	if !ok {
+		return
+	}
+	key := billingManagerKey(requestTenant(c).ID, accountID, c.Param("manager_id"))
+	grant, err := billingManagers.get(c.Request.Context(), key)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Billing manager not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error loading billing manager", err))
+		return
+	}
+	if err := billingManagers.delete(c.Request.Context(), key); err != nil {
+		respondError(c, internalError("Error revoking billing manager", err))
+		return
+	}
+
+	auditChange(c, grant, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Billing manager revoked",
+	})
+}
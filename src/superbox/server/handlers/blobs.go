@@ -0,0 +1,771 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	blobUploadExpiry   = 15 * time.Minute
+	blobDownloadExpiry = 15 * time.Minute
+	pendingBlobTTL     = time.Hour
+	blobExpiryInterval = time.Hour
+
+	// Uploads above multipartThreshold are sent in parts, so a dropped
+	// connection costs one part instead of the whole file. They may take
+	// longer than a presigned POST, so they are kept for multipartUploadTTL.
+	multipartThreshold = 100 << 20
+	minPartSize        = 16 << 20
+	maxParts           = 10000
+	multipartUploadTTL = 24 * time.Hour
+)
+
+// blobClass describes one kind of stored asset. Retention of zero keeps the
+// object until it is deleted; uploadable classes can be sent by clients
+// through a presigned POST or, when large, a multipart upload; the rest are
+// only written by the server.
+type blobClass struct {
+	ContentTypes []string
+	MaxBytes     int64
+	Retention    time.Duration
+	Uploadable   bool
+	Bucket       func() string
+}
+
+func blobsBucket() string   { return appConfig.BlobsBucketName }
+func reportsBucket() string { return appConfig.ReportsBucketName }
+
+var blobClasses = map[string]blobClass{
+	"avatar": {
+		ContentTypes: []string{"image/png", "image/jpeg", "image/webp"},
+		MaxBytes:     2 << 20,
+		Uploadable:   true,
+		Bucket:       blobsBucket,
+	},
+	"logo": {
+		ContentTypes: []string{"image/png", "image/jpeg", "image/webp", "image/svg+xml"},
+		MaxBytes:     1 << 20,
+		Uploadable:   true,
+		Bucket:       blobsBucket,
+	},
+	"sbom": {
+		ContentTypes: []string{"application/json", "application/spdx+json", "application/vnd.cyclonedx+json"},
+		MaxBytes:     10 << 20,
+		Uploadable:   true,
+		Bucket:       blobsBucket,
+	},
+	"bundle": {
+		ContentTypes: []string{"application/gzip", "application/x-gzip", "application/zip", "application/x-tar", "application/octet-stream"},
+		MaxBytes:     5 << 30,
+		Uploadable:   true,
+		Bucket:       blobsBucket,
+	},
+	"invoice": {
+		ContentTypes: []string{"application/pdf", "text/html"},
+		MaxBytes:     5 << 20,
+		Bucket:       blobsBucket,
+	},
+	"export": {
+		ContentTypes: []string{"application/json", "text/csv", "application/zip"},
+		MaxBytes:     100 << 20,
+		Retention:    7 * 24 * time.Hour,
+		Bucket:       blobsBucket,
+	},
+	"report": {
+		ContentTypes: []string{"text/csv", "application/json"},
+		MaxBytes:     20 << 20,
+		Retention:    365 * 24 * time.Hour,
+		Bucket:       reportsBucket,
+	},
+}
+
+var blobs = recordSet[storedBlob]{
+	kind:  "blob",
+	group: func(stored *storedBlob) string { return stored.Blob.OwnerID },
+}
+
+// storedBlob is a blob as the record store holds it, with the object key
+// and multipart upload the API leaves out.
+type storedBlob struct {
+	Blob     models.Blob `json:"blob"`
+	Key      string      `json:"key"`
+	UploadID string      `json:"upload_id,omitempty"`
+}
+
+func storeBlob(blob *models.Blob) *storedBlob {
+	return &storedBlob{Blob: *blob, Key: blob.Key, UploadID: blob.UploadID}
+}
+
+func (s *storedBlob) restore() *models.Blob {
+	blob := s.Blob
+	blob.Key = s.Key
+	blob.UploadID = s.UploadID
+	return &blob
+}
+
+func saveBlob(ctx context.Context, blob *models.Blob) error {
+	return blobs.put(ctx, blob.ID, storeBlob(blob))
+}
+
+// getBlobCopy returns a blob, or nil when there is none with that ID.
+func getBlobCopy(ctx context.Context, blobID string) (*models.Blob, error) {
+	stored, err := blobs.get(ctx, blobID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.restore(), nil
+}
+
+// updateBlob changes a blob and returns it, or nil when it has been
+// deleted. update may run more than once.
+func updateBlob(ctx context.Context, blobID string, update func(*models.Blob)) (*models.Blob, error) {
+	stored, err := blobs.update(ctx, blobID, func(stored *storedBlob) error {
+		blob := stored.restore()
+		update(blob)
+		*stored = *storeBlob(blob)
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.restore(), nil
+}
+
+// ownerBlobs lists every blob an owner has, across tenants.
+func ownerBlobs(ctx context.Context, ownerID string) ([]models.Blob, error) {
+	stored, err := blobs.listGroup(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.Blob, 0, len(stored))
+	for _, entry := range stored {
+		result = append(result, *entry.restore())
+	}
+	return result, nil
+}
+
+// allBlobs lists every blob in every tenant.
+func allBlobs(ctx context.Context) ([]models.Blob, error) {
+	stored, err := blobs.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.Blob, 0, len(stored))
+	for _, entry := range stored {
+		result = append(result, *entry.restore())
+	}
+	return result, nil
+}
+
+func RegisterBlobs(api *gin.RouterGroup) {
+	blobRoutes := api.Group("/blobs")
+	{
+		blobRoutes.GET("", listBlobs)
+		blobRoutes.POST("/uploads", createBlobUpload)
+		blobRoutes.GET("/:blob_id/parts", listBlobParts)
+		blobRoutes.POST("/:blob_id/parts", presignBlobParts)
+		blobRoutes.POST("/:blob_id/complete", completeBlobUpload)
+		blobRoutes.GET("/:blob_id", getBlob)
+		blobRoutes.GET("/:blob_id/content", RateLimited("download"), downloadBlob)
+		blobRoutes.DELETE("/:blob_id", deleteBlob)
+	}
+}
+
+func validateBlob(className string, contentType string, size int64) (blobClass, error) {
+	class, exists := blobClasses[className]
+	if !exists {
+		return class, fmt.Errorf("unknown blob class '%s'", className)
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	allowed := false
+	for _, candidate := range class.ContentTypes {
+		allowed = allowed || candidate == mediaType
+	}
+	if !allowed {
+		return class, fmt.Errorf("%s blobs must be one of %s", className, strings.Join(class.ContentTypes, ", "))
+	}
+	if size < 1 || size > class.MaxBytes {
+		return class, fmt.Errorf("%s blobs must be between 1 and %d bytes", className, class.MaxBytes)
+	}
+	return class, nil
+}
+
+func newBlob(ctx context.Context, className string, class blobClass, ownerID string, contentType string, size int64, status string) (*models.Blob, error) {
+	region, err := userRegion(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tenant := tenantFrom(ctx)
+	blob := &models.Blob{
+		ID:          randomID("blob"),
+		TenantID:    tenant.ID,
+		Class:       className,
+		OwnerID:     ownerID,
+		ContentType: contentType,
+		Size:        size,
+		Status:      status,
+		CreatedAt:   models.Timestamp(now),
+	}
+	if region.Name != config.DefaultStorageRegion {
+		blob.Region = region.Name
+	}
+	blob.Key = fmt.Sprintf("%sblobs/%s/%s/%s", tenant.StoragePrefix, className, ownerID, blob.ID)
+	if class.Retention > 0 {
+		blob.ExpiresAt = models.Timestamp(now.Add(class.Retention))
+	}
+	return blob, nil
+}
+
+// putBlob validates and stores a server-generated asset.
+func putBlob(ctx context.Context, className string, ownerID string, body []byte, contentType string) (*models.Blob, error) {
+	class, err := validateBlob(className, contentType, int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := newBlob(ctx, className, class, ownerID, contentType, int64(len(body)), "ready")
+	if err != nil {
+		return nil, err
+	}
+	_, err = callBlobStorage(ctx, *blob, "put_object", map[string]interface{}{
+		"key":          blob.Key,
+		"body":         string(body),
+		"content_type": contentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := saveBlob(ctx, blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func blobDownloadURL(ctx context.Context, blob models.Blob) (string, error) {
+	result, err := callBlobStorage(ctx, blob, "presign_url", map[string]interface{}{
+		"key":        blob.Key,
+		"expires_in": int(blobDownloadExpiry.Seconds()),
+	})
+	if err != nil {
+		return "", err
+	}
+	url, _ := result["data"].(string)
+	return url, nil
+}
+
+// partSize splits size into as few parts of at least minPartSize as storage
+// allows, rounded up to whole mebibytes.
+func partSize(size int64) int64 {
+	part := int64(minPartSize)
+	if perPart := (size + maxParts - 1) / maxParts; perPart > part {
+		part = (perPart + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	return part
+}
+
+func presignParts(ctx context.Context, blob models.Blob, partNumbers []int) ([]models.BlobPart, error) {
+	parts := make([]models.BlobPart, 0, len(partNumbers))
+	for _, number := range partNumbers {
+		result, err := callBlobStorage(ctx, blob, "presign_upload_part", map[string]interface{}{
+			"key":         blob.Key,
+			"upload_id":   blob.UploadID,
+			"part_number": number,
+			"expires_in":  int(blobUploadExpiry.Seconds()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		url, _ := result["data"].(string)
+		parts = append(parts, models.BlobPart{PartNumber: number, URL: url})
+	}
+	return parts, nil
+}
+
+// uploadedParts returns the parts storage has received for a multipart
+// upload, in order.
+func uploadedParts(ctx context.Context, blob models.Blob) ([]models.BlobPart, error) {
+	result, err := callBlobStorage(ctx, blob, "list_parts", map[string]interface{}{
+		"key":       blob.Key,
+		"upload_id": blob.UploadID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items, _ := result["data"].([]interface{})
+	parts := make([]models.BlobPart, 0, len(items))
+	for _, item := range items {
+		part, _ := item.(map[string]interface{})
+		number, _ := part["part_number"].(float64)
+		size, _ := part["size"].(float64)
+		etag, _ := part["etag"].(string)
+		parts = append(parts, models.BlobPart{PartNumber: int(number), Size: int64(size), ETag: etag})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// missingParts lists the part numbers of a multipart upload not yet received.
+func missingParts(blob models.Blob, parts []models.BlobPart) []int {
+	received := make(map[int]bool, len(parts))
+	for _, part := range parts {
+		received[part.PartNumber] = true
+	}
+	missing := []int{}
+	for number := 1; number <= blob.PartCount; number++ {
+		if !received[number] {
+			missing = append(missing, number)
+		}
+	}
+	return missing
+}
+
+// removeBlob deletes a blob's object, or discards the parts of a multipart
+// upload that was never completed.
+func removeBlob(ctx context.Context, blob models.Blob) error {
+	var err error
+	if blob.Status == "pending" && blob.UploadID != "" {
+		_, err = callBlobStorage(ctx, blob, "abort_multipart_upload", map[string]interface{}{
+			"key":       blob.Key,
+			"upload_id": blob.UploadID,
+		})
+	} else {
+		_, err = callBlobStorage(ctx, blob, "delete_object", map[string]interface{}{
+			"key": blob.Key,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return blobs.delete(ctx, blob.ID)
+}
+
+// expireBlobs deletes objects past their class retention and uploads that
+// were presigned but never completed.
+func expireBlobs(ctx context.Context) error {
+	now := time.Now()
+	pendingCutoff := models.Timestamp(now.Add(-pendingBlobTTL))
+	multipartCutoff := models.Timestamp(now.Add(-multipartUploadTTL))
+
+	all, err := allBlobs(ctx)
+	if err != nil {
+		return err
+	}
+	expired := []models.Blob{}
+	for _, blob := range all {
+		cutoff := pendingCutoff
+		if blob.UploadID != "" {
+			cutoff = multipartCutoff
+		}
+		if (!blob.ExpiresAt.IsZero() && !blob.ExpiresAt.After(models.Timestamp(now))) || (blob.Status == "pending" && !blob.CreatedAt.After(cutoff)) {
+			expired = append(expired, blob)
+		}
+	}
+
+	failed := 0
+	for _, blob := range expired {
+		if err := removeBlob(ctx, blob); err != nil {
+			slog.Warn("failed to expire blob", "blob_id", blob.ID, "class", blob.Class, "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d expired blobs could not be deleted", failed, len(expired))
+	}
+	return nil
+}
+
+func ownedBlob(c *gin.Context) (models.Blob, bool) {
+	if _, ok := authenticatedUser(c); !ok {
+		return models.Blob{}, false
+	}
+
+	blobID := c.Param("blob_id")
+	blob, err := getBlobCopy(c.Request.Context(), blobID)
+	if err != nil {
+		respondError(c, internalError("Error loading blob", err))
+		return models.Blob{}, false
+	}
+	if blob == nil || blob.TenantID != requestTenant(c).ID || !ownsResource(c, blob.OwnerID) {
+		respondError(c, newAPIError(http.StatusNotFound, "Blob '"+blobID+"' not found"))
+		return models.Blob{}, false
+	}
+	return *blob, true
+}
+
+func createBlobUpload(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	class, err := validateBlob(req.Class, req.ContentType, req.Size)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if !class.Uploadable {
+		respondError(c, newAPIError(http.StatusBadRequest, req.Class+" blobs are generated by the server and cannot be uploaded"))
+		return
+	}
+	if !reserveStorage(c, userID, req.Size) {
+		return
+	}
+
+	blob, err := newBlob(c.Request.Context(), req.Class, class, userID, req.ContentType, req.Size, "pending")
+	if err != nil {
+		respondError(c, internalError("Error creating upload", err))
+		return
+	}
+	if req.Multipart || req.Size > multipartThreshold {
+		createMultipartUpload(c, blob)
+		return
+	}
+	result, err := callBlobStorage(c.Request.Context(), *blob, "presign_upload", map[string]interface{}{
+		"key":          blob.Key,
+		"content_type": req.ContentType,
+		"max_bytes":    req.Size,
+		"expires_in":   int(blobUploadExpiry.Seconds()),
+	})
+	if err != nil {
+		respondError(c, internalError("Error creating upload link", err))
+		return
+	}
+
+	upload := models.BlobUpload{Fields: map[string]string{}, ExpiresAt: models.Timestamp(time.Now().Add(blobUploadExpiry))}
+	if data, ok := result["data"].(map[string]interface{}); ok {
+		upload.URL, _ = data["url"].(string)
+		if fields, ok := data["fields"].(map[string]interface{}); ok {
+			for key, value := range fields {
+				upload.Fields[key], _ = value.(string)
+			}
+		}
+	}
+
+	if err := saveBlob(c.Request.Context(), blob); err != nil {
+		respondError(c, internalError("Error creating upload", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"blob":   blob,
+		"upload": upload,
+	})
+}
+
+// createMultipartUpload starts a multipart upload for a pending blob and
+// presigns its first parts; the rest are presigned through POST
+// /blobs/:blob_id/parts as the client gets to them.
+func createMultipartUpload(c *gin.Context, blob *models.Blob) {
+	result, err := callBlobStorage(c.Request.Context(), *blob, "create_multipart_upload", map[string]interface{}{
+		"key":          blob.Key,
+		"content_type": blob.ContentType,
+	})
+	if err != nil {
+		respondError(c, internalError("Error creating upload", err))
+		return
+	}
+	blob.UploadID, _ = result["data"].(string)
+	blob.PartSize = partSize(blob.Size)
+	blob.PartCount = int((blob.Size + blob.PartSize - 1) / blob.PartSize)
+
+	first := []int{}
+	for number := 1; number <= blob.PartCount && number <= 100; number++ {
+		first = append(first, number)
+	}
+	parts, err := presignParts(c.Request.Context(), *blob, first)
+	if err != nil {
+		respondError(c, internalError("Error creating upload link", err))
+		return
+	}
+
+	if err := saveBlob(c.Request.Context(), blob); err != nil {
+		respondError(c, internalError("Error creating upload", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"blob":   blob,
+		"upload": models.BlobUpload{Parts: parts, ExpiresAt: models.Timestamp(time.Now().Add(blobUploadExpiry))},
+	})
+}
+
+// pendingMultipartBlob loads the caller's blob for the part routes, which
+// only apply to multipart uploads that have not been completed.
+func pendingMultipartBlob(c *gin.Context) (models.Blob, bool) {
+	blob, ok := ownedBlob(c)
+	if !ok {
+		return blob, false
+	}
+	if blob.UploadID == "" {
+		respondError(c, newAPIError(http.StatusConflict, "Blob '"+blob.ID+"' was not uploaded in parts"))
+		return blob, false
+	}
+	if blob.Status != "pending" {
+		respondError(c, newAPIError(http.StatusConflict, "Blob '"+blob.ID+"' is already "+blob.Status))
+		return blob, false
+	}
+	return blob, true
+}
+
+// listBlobParts reports which parts have arrived, so an interrupted upload
+// can resume with the ones still missing.
+func listBlobParts(c *gin.Context) {
+	blob, ok := pendingMultipartBlob(c)
+	if !ok {
+		return
+	}
+
+	parts, err := uploadedParts(c.Request.Context(), blob)
+	if err != nil {
+		respondError(c, internalError("Error listing uploaded parts", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"blob":    blob,
+		"parts":   parts,
+		"missing": missingParts(blob, parts),
+	})
+}
+
+func presignBlobParts(c *gin.Context) {
+	blob, ok := pendingMultipartBlob(c)
+	if !ok {
+		return
+	}
+
+	var req models.PresignPartsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	for _, number := range req.PartNumbers {
+		if number > blob.PartCount {
+			err := validationFailed()
+			err.Fields = []FieldError{{Field: "part_numbers", Message: fmt.Sprintf("blob '%s' has %d parts", blob.ID, blob.PartCount)}}
+			respondError(c, err)
+			return
+		}
+	}
+
+	parts, err := presignParts(c.Request.Context(), blob, req.PartNumbers)
+	if err != nil {
+		respondError(c, internalError("Error creating upload link", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"upload": models.BlobUpload{Parts: parts, ExpiresAt: models.Timestamp(time.Now().Add(blobUploadExpiry))},
+	})
+}
+
+// finishMultipartUpload assembles a multipart upload once every part has
+// arrived. It reports false after responding when parts are missing.
+func finishMultipartUpload(c *gin.Context, blob models.Blob) bool {
+	parts, err := uploadedParts(c.Request.Context(), blob)
+	if err != nil {
+		respondError(c, internalError("Error listing uploaded parts", err))
+		return false
+	}
+	if missing := missingParts(blob, parts); len(missing) > 0 {
+		respondError(c, newAPIError(http.StatusConflict, fmt.Sprintf("Blob '%s' is missing %d of %d parts", blob.ID, len(missing), blob.PartCount)).withCode("parts_missing").withDetail("missing", missing))
+		return false
+	}
+
+	completed := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, map[string]interface{}{"part_number": part.PartNumber, "etag": part.ETag})
+	}
+	_, err = callBlobStorage(c.Request.Context(), blob, "complete_multipart_upload", map[string]interface{}{
+		"key":       blob.Key,
+		"upload_id": blob.UploadID,
+		"parts":     completed,
+	})
+	if err != nil {
+		respondError(c, internalError("Error assembling upload", err))
+		return false
+	}
+
+	_, err = updateBlob(c.Request.Context(), blob.ID, func(stored *models.Blob) {
+		stored.UploadID = ""
+	})
+	if err != nil {
+		respondError(c, internalError("Error saving upload", err))
+		return false
+	}
+	return true
+}
+
+func completeBlobUpload(c *gin.Context) {
+	blob, ok := ownedBlob(c)
+	if !ok {
+		return
+	}
+	if blob.Status != "pending" {
+		respondError(c, newAPIError(http.StatusConflict, "Blob '"+blob.ID+"' is already "+blob.Status))
+		return
+	}
+	if blob.UploadID != "" {
+		if !finishMultipartUpload(c, blob) {
+			return
+		}
+		blob.UploadID = ""
+	}
+
+	result, err := callBlobStorage(c.Request.Context(), blob, "head_object", map[string]interface{}{
+		"key": blob.Key,
+	})
+	if err != nil {
+		respondError(c, internalError("Error checking upload", err))
+		return
+	}
+	object, _ := result["data"].(map[string]interface{})
+	if object == nil {
+		respondError(c, newAPIError(http.StatusConflict, "Nothing has been uploaded for blob '"+blob.ID+"' yet"))
+		return
+	}
+	size, _ := object["size"].(float64)
+	contentType, _ := object["content_type"].(string)
+	if _, err := validateBlob(blob.Class, contentType, int64(size)); err != nil {
+		removeBlob(c.Request.Context(), blob)
+		respondError(c, newAPIError(http.StatusBadRequest, "Uploaded object was rejected: "+err.Error()))
+		return
+	}
+
+	status := "ready"
+	flags := moderateImage(c.Request.Context(), blob)
+	if len(flags) > 0 {
+		status = "held"
+	}
+
+	stored, err := updateBlob(c.Request.Context(), blob.ID, func(stored *models.Blob) {
+		stored.Status = status
+		stored.Size = int64(size)
+		stored.ContentType = contentType
+	})
+	if err != nil {
+		respondError(c, internalError("Error saving upload", err))
+		return
+	}
+	if stored != nil {
+		blob = *stored
+	}
+
+	response := gin.H{
+		"status": "success",
+		"blob":   blob,
+	}
+	if len(flags) > 0 {
+		hold, err := holdForModeration(c.Request.Context(), &models.ModerationHold{TenantID: blob.TenantID, Kind: "image", BlobID: blob.ID, OwnerID: blob.OwnerID, Flags: flags})
+		if err != nil {
+			respondError(c, internalError("Error holding upload for review", err))
+			return
+		}
+		response["moderation"] = hold
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func getBlob(c *gin.Context) {
+	blob, ok := ownedBlob(c)
+	if !ok {
+		return
+	}
+
+	if blob.Status == "ready" {
+		url, err := blobDownloadLink(c.Request.Context(), blob)
+		if err != nil {
+			respondError(c, internalError("Error creating download link", err))
+			return
+		}
+		blob.DownloadURL = url
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"blob":   blob,
+	})
+}
+
+func deleteBlob(c *gin.Context) {
+	blob, ok := ownedBlob(c)
+	if !ok {
+		return
+	}
+
+	if err := removeBlob(c.Request.Context(), blob); err != nil {
+		respondError(c, internalError("Error deleting blob", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Blob deleted",
+	})
+}
+
+func listBlobs(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	result, err := userBlobs(c.Request.Context(), requestTenant(c).ID, userID, c.Query("class"))
+	if err != nil {
+		respondError(c, internalError("Error listing blobs", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"total":  len(result),
+		"blobs":  result,
+	})
+}
+
+func userBlobs(ctx context.Context, tenantID string, userID string, className string) ([]models.Blob, error) {
+	owned, err := ownerBlobs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := []models.Blob{}
+	for _, blob := range owned {
+		if blob.TenantID == tenantID && (className == "" || blob.Class == className) {
+			result = append(result, blob)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
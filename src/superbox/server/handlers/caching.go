@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachePolicy is how long browsers (MaxAge) and shared caches such as a CDN
+// (SharedMaxAge) may reuse a successful response to a public read route.
+type cachePolicy struct {
+	MaxAge               time.Duration
+	SharedMaxAge         time.Duration
+	StaleWhileRevalidate time.Duration
+}
+
+var (
+	// catalogCache covers the registry listing and server pages, which
+	// change whenever a publisher ships a version.
+	catalogCache = cachePolicy{MaxAge: time.Minute, SharedMaxAge: 5 * time.Minute, StaleWhileRevalidate: time.Minute}
+	pricingCache = cachePolicy{MaxAge: 5 * time.Minute, SharedMaxAge: 15 * time.Minute, StaleWhileRevalidate: time.Minute}
+	tenantCache  = cachePolicy{MaxAge: 5 * time.Minute, SharedMaxAge: time.Hour, StaleWhileRevalidate: 5 * time.Minute}
+	docsCache    = cachePolicy{MaxAge: time.Hour, SharedMaxAge: 24 * time.Hour}
+)
+
+func (p cachePolicy) header(private bool) string {
+	if private {
+		return fmt.Sprintf("private, max-age=%d", int(p.MaxAge.Seconds()))
+	}
+	value := fmt.Sprintf("public, max-age=%d, s-maxage=%d", int(p.MaxAge.Seconds()), int(p.SharedMaxAge.Seconds()))
+	if p.StaleWhileRevalidate > 0 {
+		value += fmt.Sprintf(", stale-while-revalidate=%d", int(p.StaleWhileRevalidate.Seconds()))
+	}
+	return value
+}
+
+type bufferWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferWriter) WriteString(data string) (int, error) {
+	return w.body.WriteString(data)
+}
+
+// Cached adds Cache-Control, an ETag, and conditional GET handling to a
+// public read route. Unless the handler set its own ETag, the body is
+// buffered and hashed into a weak one, and a request whose
+// If-None-Match matches (or, without one, whose If-Modified-Since is not
+// older than the handler's Last-Modified) gets a 304. Requests that carry
+// credentials are marked private so a CDN never stores them. Errors are
+// left uncached.
+func Cached(policy cachePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &bufferWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.body.Len() == 0 && !writer.Written() {
+			return
+		}
+		header := c.Writer.Header()
+		if c.Writer.Status() != http.StatusOK {
+			c.Writer.Write(writer.body.Bytes())
+			return
+		}
+
+		etag := header.Get("ETag")
+		if etag == "" {
+			sum := sha256.Sum256(writer.body.Bytes())
+			etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		}
+		private := c.GetHeader("Authorization") != "" || c.GetHeader("X-ID-Token") != "" || signedRequest(c)
+		header.Set("ETag", etag)
+		header.Set("Cache-Control", policy.header(private))
+		header.Add("Vary", "API-Version, Accept, X-SuperBox-Tenant")
+
+		if notModified(c.Request, etag, header.Get("Last-Modified")) {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		c.Writer.Write(writer.body.Bytes())
+	}
+}
+
+func notModified(req *http.Request, etag string, lastModified string) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag, true)
+	}
+
+	since := req.Header.Get("If-Modified-Since")
+	if since == "" || lastModified == "" {
+		return false
+	}
+	sinceTime, err := http.ParseTime(since)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(sinceTime)
+}
+
+// setLastModified sets Last-Modified from an RFC 3339 timestamp such as a
+// server's updated_at. Lists do not set it: a deletion changes the list
+// without changing any remaining item's timestamp, so they rely on the ETag.
+func setLastModified(c *gin.Context, timestamp time.Time) {
+	if !timestamp.IsZero() {
+		c.Header("Last-Modified", timestamp.UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cdnStatusInterval = time.Minute
+	// cdnStatusTimeout is how long a submitted invalidation is polled before
+	// it is left in_progress; CloudFront usually finishes within minutes.
+	cdnStatusTimeout = 30 * time.Minute
+)
+
+var cdnInvalidations = recordSet[models.CDNInvalidation]{kind: "cdn_invalidation"}
+
+// openInvalidationKey holds the ID of the batch that later writes join
+// until its job picks it up, so a burst of writes costs one invalidation.
+const openInvalidationKey = "cdn:open"
+
+// errInvalidationClosed is returned when joining a batch its job has
+// already picked up.
+var errInvalidationClosed = errors.New("invalidation already submitted")
+
+// cdnPaths lists the cached pages that change when serverName is written:
+// both listings with any query string, its v1 and v2 pages, and the index.
+func cdnPaths(serverName string) []string {
+	escaped := url.PathEscape(serverName)
+	return []string{
+		"/api/v1/servers",
+		"/api/v1/servers?*",
+		"/api/v1/servers/" + escaped,
+		"/api/v1/servers/" + escaped + "/pricing/history",
+		"/api/v2/servers",
+		"/api/v2/servers?*",
+		"/api/v2/servers/" + escaped,
+		"/" + registryIndexKey,
+	}
+}
+
+// invalidateServerPages queues the CDN paths for a created, updated, or
+// deleted server. Nothing is queued unless CLOUDFRONT_DISTRIBUTION_ID is set.
+func invalidateServerPages(ctx context.Context, serverName string) error {
+	distributionID := appConfig.CloudFrontDistributionID
+	if distributionID == "" {
+		return nil
+	}
+
+	openID, err := recordStore.Get(ctx, openInvalidationKey)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		_, err := cdnInvalidations.update(ctx, string(openID), func(batch *models.CDNInvalidation) error {
+			if batch.Status != "pending" {
+				return errInvalidationClosed
+			}
+			addInvalidationPaths(batch, serverName)
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errInvalidationClosed) && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+
+	batch := &models.CDNInvalidation{
+		ID:             randomID("cdn"),
+		DistributionID: distributionID,
+		Paths:          []string{},
+		Servers:        []string{},
+		Status:         "pending",
+		CreatedAt:      models.Now(),
+	}
+	addInvalidationPaths(batch, serverName)
+	if err := cdnInvalidations.put(ctx, batch.ID, batch); err != nil {
+		return err
+	}
+	if err := recordStore.Set(ctx, openInvalidationKey, []byte(batch.ID), 0); err != nil {
+		return err
+	}
+	_, err = enqueueJob(ctx, "cdn_invalidation", map[string]string{"invalidation_id": batch.ID}, time.Now())
+	return err
+}
+
+func addInvalidationPaths(batch *models.CDNInvalidation, serverName string) {
+	for _, path := range cdnPaths(serverName) {
+		if !slices.Contains(batch.Paths, path) {
+			batch.Paths = append(batch.Paths, path)
+		}
+	}
+	if !slices.Contains(batch.Servers, serverName) {
+		batch.Servers = append(batch.Servers, serverName)
+	}
+}
+
+// runCDNInvalidationJob submits a batch, or checks on one already submitted.
+// The batch ID is the CloudFront caller reference, so a retry after a lost
+// response does not start a second invalidation.
+func runCDNInvalidationJob(job *models.Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Picking up a pending batch closes it, so writes from here on start
+	// the next one instead of joining paths this submission would miss.
+	snapshot, err := cdnInvalidations.update(ctx, job.Payload["invalidation_id"], func(batch *models.CDNInvalidation) error {
+		if batch.Status == "pending" {
+			batch.Status = "submitting"
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch snapshot.Status {
+	case "in_progress":
+		return checkCDNInvalidation(ctx, *snapshot)
+	case "completed", "failed":
+		return nil
+	}
+	result, err := callPythonS3Context(ctx, "create_invalidation", map[string]interface{}{
+		"distribution_id":  snapshot.DistributionID,
+		"paths":            snapshot.Paths,
+		"caller_reference": snapshot.ID,
+	})
+	return recordCDNInvalidation(ctx, snapshot.ID, result, err)
+}
+
+func recordCDNInvalidation(ctx context.Context, invalidationID string, result map[string]interface{}, submitErr error) error {
+	batch, err := cdnInvalidations.update(ctx, invalidationID, func(batch *models.CDNInvalidation) error {
+		batch.Attempts++
+		if submitErr != nil {
+			batch.LastError = submitErr.Error()
+			batch.Status = "failed"
+			if batch.Attempts < maxJobAttempts {
+				batch.Status = "retrying"
+			}
+			return nil
+		}
+
+		data, _ := result["data"].(map[string]interface{})
+		batch.CloudFrontID, _ = data["id"].(string)
+		batch.LastError = ""
+		batch.SubmittedAt = models.Now()
+		batch.Status = "in_progress"
+		if status, _ := data["status"].(string); status == "Completed" {
+			batch.Status = "completed"
+			batch.CompletedAt = batch.SubmittedAt
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if submitErr != nil {
+		return fmt.Errorf("failed to invalidate %s: %w", batch.DistributionID, submitErr)
+	}
+	if batch.Status == "in_progress" {
+		_, err = enqueueJob(ctx, "cdn_invalidation", map[string]string{"invalidation_id": batch.ID}, time.Now().Add(cdnStatusInterval))
+	}
+	return err
+}
+
+// checkCDNInvalidation marks a submitted batch completed once CloudFront
+// reports it, and otherwise checks again later.
+func checkCDNInvalidation(ctx context.Context, batch models.CDNInvalidation) error {
+	result, err := callPythonS3Context(ctx, "get_invalidation", map[string]interface{}{
+		"distribution_id": batch.DistributionID,
+		"invalidation_id": batch.CloudFrontID,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, _ := result["data"].(map[string]interface{})
+	if status, _ := data["status"].(string); status == "Completed" {
+		_, err := cdnInvalidations.update(ctx, batch.ID, func(current *models.CDNInvalidation) error {
+			current.Status = "completed"
+			current.CompletedAt = models.Now()
+			return nil
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if time.Since(batch.SubmittedAt) < cdnStatusTimeout {
+		_, err := enqueueJob(ctx, "cdn_invalidation", map[string]string{"invalidation_id": batch.ID}, time.Now().Add(cdnStatusInterval))
+		return err
+	}
+	slog.Warn("cdn invalidation still in progress; no longer checking", "invalidation_id", batch.ID, "cloudfront_id", batch.CloudFrontID)
+	return nil
+}
+
+func listCDNInvalidations(c *gin.Context) {
+	status := c.Query("status")
+
+	batches, err := cdnInvalidations.list(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Failed to list CDN invalidations", err))
+		return
+	}
+	result := []models.CDNInvalidation{}
+	for _, batch := range batches {
+		if status == "" || batch.Status == status {
+			result = append(result, batch)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"invalidations": result,
+	})
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	claimTTL = 7 * 24 * time.Hour
+	// claimFilePath is where the "file" method looks for the claim token,
+	// relative to the root of the repository's default branch.
+	claimFilePath = ".well-known/superbox-claim.txt"
+	// maxClaimFileBytes bounds how much of the claim file is read.
+	maxClaimFileBytes = 64 << 10
+)
+
+var serverClaims = recordSet[models.ServerClaim]{
+	kind:  "server_claim",
+	group: func(claim *models.ServerClaim) string { return claim.TenantID + "/" + claim.ServerName },
+}
+
+// errClaimNotVerified is a verification that ran but did not show control of
+// the repository; its message is returned to the claimant.
+var errClaimNotVerified = errors.New("claim not verified")
+
+// githubRepository returns the owner and name of a https://github.com
+// repository URL.
+func githubRepository(repositoryURL string) (string, string, bool) {
+	parsed, err := url.Parse(repositoryURL)
+	if err != nil || parsed.Scheme != "https" || !strings.EqualFold(parsed.Host, "github.com") {
+		return "", "", false
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) != 2 || segments[0] == "" || segments[1] == "" {
+		return "", "", false
+	}
+	return segments[0], strings.TrimSuffix(segments[1], ".git"), true
+}
+
+// createServerClaim starts a claim on a server that has no owner. The
+// repository linked from the record is what the claimant must prove control
+// of, so servers without a GitHub repository cannot be claimed this way.
+func createServerClaim(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateServerClaimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	serverName := c.Param("server_name")
+	server, err := fetchServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if server.Meta.OwnerID != "" {
+		respondError(c, newAPIError(http.StatusConflict, "Server '"+serverName+"' already has an owner").withCode("server_owned"))
+		return
+	}
+	if _, _, ok := githubRepository(server.Repository.URL); !ok {
+		respondError(c, newAPIError(http.StatusUnprocessableEntity, "Server '"+serverName+"' does not link a GitHub repository; ask an admin to assign it").withCode("claim_unsupported"))
+		return
+	}
+
+	now := time.Now()
+	claim := &models.ServerClaim{
+		ID:         randomID("claim"),
+		TenantID:   requestTenant(c).ID,
+		ServerName: serverName,
+		UserID:     userID,
+		Method:     req.Method,
+		Repository: server.Repository.URL,
+		Status:     "pending",
+		CreatedAt:  models.Timestamp(now),
+		ExpiresAt:  models.Timestamp(now.Add(claimTTL)),
+	}
+	if req.Method == "file" {
+		token := make([]byte, 16)
+		rand.Read(token)
+		claim.Token = "superbox-claim-" + hex.EncodeToString(token)
+		claim.Path = claimFilePath
+	}
+
+	if err := serverClaims.put(c.Request.Context(), claim.ID, claim); err != nil {
+		respondError(c, internalError("Error saving claim", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"claim":  claim,
+	})
+}
+
+// verifyServerClaim checks a pending claim and, when it holds, makes the
+// claimant the server's owner. A failed check leaves the claim pending so
+// it can be retried until it expires.
+func verifyServerClaim(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.VerifyServerClaimRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+	}
+
+	serverName := c.Param("server_name")
+	tenantID := requestTenant(c).ID
+	claimID := c.Param("claim_id")
+	stored, err := serverClaims.get(c.Request.Context(), claimID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error loading claim", err))
+		return
+	}
+	if stored == nil || stored.UserID != userID || stored.TenantID != tenantID || stored.ServerName != serverName {
+		respondError(c, newAPIError(http.StatusNotFound, "Claim not found"))
+		return
+	}
+	if stored.Status == "pending" && time.Now().After(stored.ExpiresAt) {
+		stored, err = serverClaims.update(c.Request.Context(), claimID, func(claim *models.ServerClaim) error {
+			if claim.Status == "pending" {
+				claim.Status = "expired"
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, internalError("Error loading claim", err))
+			return
+		}
+	}
+	claim := *stored
+
+	switch claim.Status {
+	case "verified":
+		respondError(c, newAPIError(http.StatusConflict, "Claim was already verified").withCode("claim_closed"))
+		return
+	case "expired", "superseded":
+		respondError(c, newAPIError(http.StatusConflict, "Claim is "+claim.Status+"; start a new one").withCode("claim_closed"))
+		return
+	}
+	if claim.Method == "github" && req.GithubToken == "" {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "github_token", Message: "is required for the github method"}}
+		respondError(c, apiErr)
+		return
+	}
+
+	server, err := fetchServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if server.Meta.OwnerID != "" {
+		closeServerClaims(c.Request.Context(), tenantID, serverName, "superseded")
+		respondError(c, newAPIError(http.StatusConflict, "Server '"+serverName+"' already has an owner").withCode("server_owned"))
+		return
+	}
+	if server.Repository.URL != claim.Repository {
+		recordClaimError(c.Request.Context(), claim.ID, "the server's repository changed after the claim was made")
+		respondError(c, newAPIError(http.StatusConflict, "The repository of '"+serverName+"' changed; start a new claim").withCode("claim_not_verified"))
+		return
+	}
+
+	if err := checkServerClaim(c.Request.Context(), claim, req.GithubToken); err != nil {
+		if !errors.Is(err, errClaimNotVerified) {
+			respondError(c, newAPIError(http.StatusBadGateway, "Could not reach GitHub to verify the claim"))
+			return
+		}
+		message := strings.TrimSuffix(err.Error(), ": "+errClaimNotVerified.Error())
+		recordClaimError(c.Request.Context(), claim.ID, message)
+		respondError(c, newAPIError(http.StatusUnprocessableEntity, "Claim not verified: "+message).withCode("claim_not_verified"))
+		return
+	}
+
+	updated, ok := assignServerOwner(c, server, userID)
+	if !ok {
+		return
+	}
+
+	stored, err = serverClaims.update(c.Request.Context(), claim.ID, func(stored *models.ServerClaim) error {
+		stored.Status = "verified"
+		stored.LastError = ""
+		stored.VerifiedAt = models.Now()
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Error saving claim", err))
+		return
+	}
+	claim = *stored
+	closeServerClaims(c.Request.Context(), tenantID, serverName, "superseded")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"claim":  claim,
+		"server": updated,
+	})
+}
+
+// checkServerClaim runs the claim's method against its repository.
+func checkServerClaim(ctx context.Context, claim models.ServerClaim, githubToken string) error {
+	owner, repo, _ := githubRepository(claim.Repository)
+	if claim.Method == "github" {
+		return checkGitHubAdmin(ctx, owner, repo, githubToken)
+	}
+	return checkClaimFile(ctx, owner, repo, claim.Token)
+}
+
+// checkClaimFile looks for the token in the claim file on the repository's
+// default branch.
+func checkClaimFile(ctx context.Context, owner string, repo string, token string) error {
+	fileURL := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/HEAD/%s", url.PathEscape(owner), url.PathEscape(repo), claimFilePath)
+	req, err := newOutboundRequest(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doUpstream("github", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s was not found on the default branch of %s/%s: %w", claimFilePath, owner, repo, errClaimNotVerified)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("fetching %s returned %d", claimFilePath, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxClaimFileBytes))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(body), token) {
+		return fmt.Errorf("%s does not contain the claim token: %w", claimFilePath, errClaimNotVerified)
+	}
+	return nil
+}
+
+// checkGitHubAdmin asks GitHub, as the claimant, for their permissions on
+// the repository. Admin access, directly or as an owner of the organization
+// holding it, counts as control.
+func checkGitHubAdmin(ctx context.Context, owner string, repo string, githubToken string) error {
+	repoURL := fmt.Sprintf("https://api.github.com/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+	req, err := newOutboundRequest(ctx, "GET", repoURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+githubToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := doUpstream("github", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return fmt.Errorf("GitHub rejected the access token: %w", errClaimNotVerified)
+	case http.StatusForbidden, http.StatusNotFound:
+		return fmt.Errorf("the GitHub account cannot see %s/%s: %w", owner, repo, errClaimNotVerified)
+	default:
+		return fmt.Errorf("GitHub returned %d for %s/%s", resp.StatusCode, owner, repo)
+	}
+
+	var repository struct {
+		Permissions struct {
+			Admin bool `json:"admin"`
+		} `json:"permissions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repository); err != nil {
+		return err
+	}
+	if !repository.Permissions.Admin {
+		return fmt.Errorf("the GitHub account is not an admin of %s/%s: %w", owner, repo, errClaimNotVerified)
+	}
+	return nil
+}
+
+// recordClaimError keeps why a claim's last check failed. It is only shown
+// to the claimant, so a failure to save it is logged.
+func recordClaimError(ctx context.Context, claimID string, message string) {
+	_, err := serverClaims.update(ctx, claimID, func(claim *models.ServerClaim) error {
+		claim.LastError = message
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Warn("failed to record claim error", "claim_id", claimID, "error", err)
+	}
+}
+
+// closeServerClaims ends the other pending claims on a server once it has
+// an owner. Claims it misses stay pending until they expire, and any check
+// of them fails on the server's owner.
+func closeServerClaims(ctx context.Context, tenantID string, serverName string, status string) {
+	claims, err := serverClaims.listGroup(ctx, tenantID+"/"+serverName)
+	if err != nil {
+		slog.Warn("failed to close server claims", "server_name", serverName, "error", err)
+		return
+	}
+	for _, claim := range claims {
+		if claim.Status != "pending" {
+			continue
+		}
+		_, err := serverClaims.update(ctx, claim.ID, func(claim *models.ServerClaim) error {
+			if claim.Status == "pending" {
+				claim.Status = status
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Warn("failed to close server claim", "claim_id", claim.ID, "error", err)
+		}
+	}
+}
+
+// assignServerOwner records ownerID on the server and audits the change.
+func assignServerOwner(c *gin.Context, server models.Server, ownerID string) (models.Server, bool) {
+	updated := server
+	updated.Meta.OwnerID = ownerID
+	updated.Meta.UpdatedAt = models.Now()
+	if err := saveServer(c.Request.Context(), updated); err != nil {
+		respondError(c, internalError("Error saving server", err))
+		return models.Server{}, false
+	}
+	auditChange(c, server.Meta, updated.Meta)
+	return updated, true
+}
+
+// setServerOwner lets an admin assign or reassign a server's owner, for
+// servers that cannot be claimed or were claimed by the wrong account.
+func setServerOwner(c *gin.Context) {
+	var req models.SetServerOwnerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	serverName := c.Param("server_name")
+	server, err := fetchServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	updated, ok := assignServerOwner(c, server, req.OwnerID)
+	if !ok {
+		return
+	}
+	closeServerClaims(c.Request.Context(), requestTenant(c).ID, serverName, "superseded")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"server": updated,
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schemaVersion names the current shape of every request and response. Bump
+// it whenever a field is added, removed, renamed, or changes type; the
+// contract tests in testdata/golden refuse shape changes recorded under an
+// unchanged version.
+const schemaVersion = "2026-10-25"
+
+var (
+	schemaDigest     string
+	schemaDigestOnce sync.Once
+)
+
+func RegisterCompatibility(api *gin.RouterGroup) {
+	api.GET("/compatibility", Cached(docsCache), getCompatibility)
+}
+
+// documentDigest hashes the OpenAPI document, so it changes with any model
+// change even when schemaVersion was not bumped.
+func documentDigest() string {
+	schemaDigestOnce.Do(func() {
+		data, _ := json.Marshal(apiDocument())
+		sum := sha256.Sum256(data)
+		schemaDigest = hex.EncodeToString(sum[:16])
+	})
+	return schemaDigest
+}
+
+func getCompatibility(c *gin.Context) {
+	supported := []int{}
+	for version := 1; version <= latestAPIVersion; version++ {
+		supported = append(supported, version)
+	}
+
+	response := gin.H{
+		"status":                  "success",
+		"schema_version":          schemaVersion,
+		"schema_digest":           documentDigest(),
+		"api_version":             c.GetInt("api_version"),
+		"latest_api_version":      latestAPIVersion,
+		"supported_api_versions":  supported,
+		"deprecated_api_versions": []int{1},
+	}
+	if !appConfig.APIV1Sunset.IsZero() {
+		response["sunset"] = map[string]string{"1": appConfig.APIV1Sunset.UTC().Format(time.RFC3339)}
+	}
+	c.JSON(http.StatusOK, response)
+}
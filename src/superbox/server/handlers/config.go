@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+
+	"superbox/server/config"
+	"superbox/server/store"
+)
+
+var (
+	appConfig                   = &config.Config{}
+	stateStore store.StateStore = store.NewMemory()
+	// sessionStore holds device login sessions. It is the state store unless
+	// SESSION_STORE_URL names a durable one.
+	sessionStore store.StateStore = stateStore
+	// recordStore holds orders, entitlements, notifications, and the other
+	// records handlers read back across requests, so every replica sees the
+	// same ones. It is the state store unless RECORD_STORE_URL names a
+	// durable one.
+	recordStore store.StateStore = stateStore
+)
+
+func Configure(cfg *config.Config, sharedStore store.StateStore) {
+	appConfig = cfg
+	stateStore = sharedStore
+	sessionStore = sharedStore
+	recordStore = sharedStore
+
+	firebaseAPIKey = cfg.FirebaseAPIKey
+	configureTenants(cfg)
+	configureSessionKeys(cfg.SessionKeys)
+	configureStorageRegions(cfg)
+
+	outboundClient.Timeout = cfg.HTTPClientTimeout
+	outboundMaxRetries = cfg.HTTPMaxRetries
+
+	adminUIDs = make(map[string]bool)
+	for _, uid := range cfg.AdminUIDs {
+		adminUIDs[uid] = true
+	}
+
+	applySettings(settingsFrom(cfg))
+}
+
+// SetSessionStore moves device login sessions to a store of their own.
+func SetSessionStore(s store.StateStore) {
+	sessionStore = s
+}
+
+// CloseSessionStore closes the session store when it is not the state
+// store, which is closed on its own.
+func CloseSessionStore() error {
+	if sessionStore == stateStore {
+		return nil
+	}
+	return sessionStore.Close()
+}
+
+// SetRecordStore moves records to a store of their own.
+func SetRecordStore(s store.StateStore) {
+	recordStore = s
+}
+
+// CloseRecordStore closes the record store when it is not shared with the
+// state or session store, which are closed on their own.
+func CloseRecordStore() error {
+	if recordStore == stateStore || recordStore == sessionStore {
+		return nil
+	}
+	return recordStore.Close()
+}
+
+// CallS3Helper runs one function of the AWS helper, for stores outside this
+// package that reach AWS through it.
+func CallS3Helper(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	return s3Backend(ctx, function, args)
+}
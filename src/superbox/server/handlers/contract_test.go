@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/golden from the current responses")
+
+const goldenDir = "testdata/golden"
+
+// contractCase is one request whose response shape is pinned by a golden
+// file. who picks the token: "" for anonymous, "owner" for the publisher of
+// the contract servers, "buyer" for a second account.
+type contractCase struct {
+	name   string
+	method string
+	path   string
+	who    string
+	body   interface{}
+	status int
+}
+
+// shapeOf reduces a decoded JSON value to its shape: objects keep their keys,
+// arrays collapse to the merged shape of their elements, and scalars become
+// their type name. Values such as IDs and timestamps drop out, so the golden
+// files change only when the contract does.
+func shapeOf(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		shape := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			shape[key] = shapeOf(item)
+		}
+		return shape
+	case []interface{}:
+		var merged interface{}
+		for _, item := range value {
+			merged = mergeShapes(merged, shapeOf(item))
+		}
+		if merged == nil {
+			return []interface{}{}
+		}
+		return []interface{}{merged}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+func mergeShapes(a interface{}, b interface{}) interface{} {
+	if a == nil || a == "null" {
+		return b
+	}
+	if b == nil || b == "null" {
+		return a
+	}
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if okA && okB {
+		merged := make(map[string]interface{}, len(objectA))
+		for key, value := range objectA {
+			merged[key] = value
+		}
+		for key, value := range objectB {
+			merged[key] = mergeShapes(merged[key], value)
+		}
+		return merged
+	}
+	listA, okA := a.([]interface{})
+	listB, okB := b.([]interface{})
+	if okA && okB {
+		if len(listA) == 0 {
+			return listB
+		}
+		if len(listB) == 0 {
+			return listA
+		}
+		return []interface{}{mergeShapes(listA[0], listB[0])}
+	}
+
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	if bytes.Equal(dataA, dataB) {
+		return a
+	}
+	types := []string{strings.Trim(string(dataA), `"`), strings.Trim(string(dataB), `"`)}
+	sort.Strings(types)
+	return strings.Join(types, "|")
+}
+
+// TestResponseContracts replays a request against every endpoint family and
+// compares the response shape with testdata/golden/<name>.json. After an
+// intended change, bump schemaVersion and run
+//
+//	go test ./handlers -run TestResponseContracts -update
+func TestResponseContracts(t *testing.T) {
+	h := newHarness(t)
+	_, owner := h.identity.addUser("contract-owner@example.com")
+	_, buyer := h.identity.addUser("contract-buyer@example.com")
+	h.publish(owner, "server_free", map[string]interface{}{"name": "contract-weather"})
+	h.publish(owner, "server_paid", map[string]interface{}{"name": "contract-invoices"})
+	tokens := map[string]string{"owner": owner, "buyer": buyer}
+
+	// The buyer owns one purchase up front so list shapes never depend on
+	// which tests ran first.
+	order := h.do(http.MethodPost, "/api/v1/payment/create-order", buyer, map[string]string{"server_name": "contract-invoices", "plan": "standard"}).expect(t, http.StatusOK)
+	paymentID, signature := h.razorpay.pay(order.str("order", "id"))
+	h.do(http.MethodPost, "/api/v1/payment/verify-payment", buyer, map[string]string{
+		"razorpay_order_id":   order.str("order", "id"),
+		"razorpay_payment_id": paymentID,
+		"razorpay_signature":  signature,
+		"server_name":         "contract-invoices",
+	}).expect(t, http.StatusOK)
+
+	cases := []contractCase{
+		{name: "compatibility_v1", method: http.MethodGet, path: "/api/v1/compatibility", status: http.StatusOK},
+		{name: "compatibility_v2", method: http.MethodGet, path: "/api/v2/compatibility", status: http.StatusOK},
+		{name: "health", method: http.MethodGet, path: "/health", status: http.StatusOK},
+		{name: "tenant", method: http.MethodGet, path: "/api/v1/tenant", status: http.StatusOK},
+
+		{name: "auth_register", method: http.MethodPost, path: "/api/v1/auth/register", body: map[string]string{"email": "contract-new@example.com", "password": "secret123"}, status: http.StatusOK},
+		{name: "auth_login", method: http.MethodPost, path: "/api/v1/auth/login", body: map[string]string{"email": "contract-new@example.com", "password": "secret123"}, status: http.StatusOK},
+		{name: "auth_me", method: http.MethodGet, path: "/api/v1/auth/me", who: "owner", status: http.StatusOK},
+
+		{name: "servers_list", method: http.MethodGet, path: "/api/v1/servers", status: http.StatusOK},
+		{name: "servers_get", method: http.MethodGet, path: "/api/v1/servers/contract-invoices", status: http.StatusOK},
+		{name: "servers_update", method: http.MethodPut, path: "/api/v1/servers/contract-weather", who: "owner", body: map[string]string{"version": "1.1.0"}, status: http.StatusOK},
+		{name: "servers_pricing_history", method: http.MethodGet, path: "/api/v1/servers/contract-invoices/pricing/history", status: http.StatusOK},
+		{name: "servers_download", method: http.MethodGet, path: "/api/v1/servers/contract-invoices/download", who: "buyer", status: http.StatusOK},
+		{name: "servers_download_unpaid", method: http.MethodGet, path: "/api/v1/servers/contract-invoices/download", who: "owner", status: http.StatusPaymentRequired},
+		{name: "servers_import", method: http.MethodPost, path: "/api/v1/servers/import", who: "owner", body: map[string]interface{}{
+			"servers": []interface{}{loadFixture(t, "server_free", map[string]interface{}{"name": "contract-imported"})},
+		}, status: http.StatusAccepted},
+		{name: "v2_servers_list", method: http.MethodGet, path: "/api/v2/servers?limit=1", status: http.StatusOK},
+		{name: "v2_servers_get", method: http.MethodGet, path: "/api/v2/servers/contract-invoices", status: http.StatusOK},
+
+		{name: "payment_create_order", method: http.MethodPost, path: "/api/v1/payment/create-order", who: "buyer", body: map[string]string{"server_name": "contract-invoices", "plan": "standard"}, status: http.StatusOK},
+		{name: "payment_status", method: http.MethodGet, path: "/api/v1/payment/payment-status/" + paymentID, who: "buyer", status: http.StatusOK},
+		{name: "payment_entitlements", method: http.MethodGet, path: "/api/v1/payment/entitlements", who: "buyer", status: http.StatusOK},
+		{name: "v2_payment_entitlements", method: http.MethodGet, path: "/api/v2/payment/entitlements", who: "buyer", status: http.StatusOK},
+		{name: "v2_payment_subscriptions", method: http.MethodGet, path: "/api/v2/payment/subscriptions", who: "buyer", status: http.StatusOK},
+		{name: "v2_blobs", method: http.MethodGet, path: "/api/v2/blobs", who: "owner", status: http.StatusOK},
+		{name: "v2_me_notifications", method: http.MethodGet, path: "/api/v2/me/notifications", who: "owner", status: http.StatusOK},
+		{name: "webhooks_signing_keys", method: http.MethodGet, path: "/api/v1/webhooks/signing-keys", who: "owner", status: http.StatusOK},
+
+		{name: "error_not_found", method: http.MethodGet, path: "/api/v1/servers/contract-missing", status: http.StatusNotFound},
+		{name: "error_unauthorized", method: http.MethodGet, path: "/api/v1/auth/me", status: http.StatusUnauthorized},
+		{name: "error_validation", method: http.MethodPost, path: "/api/v1/servers", who: "owner", body: map[string]string{"name": "contract-invalid"}, status: http.StatusUnprocessableEntity},
+		{name: "v2_error_not_found", method: http.MethodGet, path: "/api/v2/servers/contract-missing", status: http.StatusNotFound},
+	}
+
+	versionFile := filepath.Join(goldenDir, "schema_version")
+	data, _ := os.ReadFile(versionFile)
+	recorded := strings.TrimSpace(string(data))
+
+	updates := map[string][]byte{}
+	changed := []string{}
+	for _, tc := range cases {
+		resp := h.do(tc.method, tc.path, tokens[tc.who], tc.body).expect(t, tc.status)
+		got, err := json.MarshalIndent(shapeOf(resp.Body), "", "  ")
+		if err != nil {
+			t.Fatalf("%s: encode shape: %v", tc.name, err)
+		}
+		got = append(got, '\n')
+
+		path := filepath.Join(goldenDir, tc.name+".json")
+		want, err := os.ReadFile(path)
+		if bytes.Equal(got, want) {
+			continue
+		}
+		updates[path] = got
+		if err == nil {
+			changed = append(changed, tc.name)
+		}
+		if !*updateGolden {
+			t.Errorf("%s %s: response shape differs from %s\n got: %s\nwant: %s", tc.method, tc.path, path, got, want)
+		}
+	}
+
+	if !*updateGolden {
+		if recorded != schemaVersion {
+			t.Errorf("golden files were recorded for schema %q, not %q; rerun with -update", recorded, schemaVersion)
+		}
+		return
+	}
+	if len(changed) > 0 && recorded == schemaVersion {
+		t.Fatalf("response shapes changed (%s) without a new schemaVersion; bump it in compatibility.go and rerun", strings.Join(changed, ", "))
+	}
+	if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	updates[versionFile] = []byte(schemaVersion + "\n")
+	for path, data := range updates {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
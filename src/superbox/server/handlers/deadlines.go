@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// detachedTimeout bounds bookkeeping that runs after its request's own
+// deadline may have passed.
+const detachedTimeout = 5 * time.Second
+
+// routeTimeouts overrides REQUEST_TIMEOUT for routes that run longer by
+// design, keyed like routePolicies. Zero leaves a route without a deadline,
+// for responses streamed for as long as the client keeps reading; those
+// still stop when the client disconnects.
+var routeTimeouts = map[string]time.Duration{
+	"POST /auth/device/poll":      deviceMaxWait + 10*time.Second,
+	"POST /servers":               2 * time.Minute,
+	"PUT /servers/:server_name":   2 * time.Minute,
+	"PATCH /servers/:server_name": 2 * time.Minute,
+	"GET /servers/export.ndjson":  0,
+	"GET /blobs/:blob_id/content": 0,
+}
+
+// requestTimeout is how long a request to route may run.
+func requestTimeout(method string, route string) time.Duration {
+	if timeout, exists := routeTimeouts[method+" "+policyRoute(route)]; exists {
+		return timeout
+	}
+	return appConfig.RequestTimeout
+}
+
+// Deadline puts the route's timeout on the request context, which every
+// storage, identity, and payment call made for the request is given, so a
+// hung upstream is abandoned and a client that disconnects stops the work
+// done for it.
+func Deadline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := requestTimeout(c.Request.Method, c.FullPath())
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// timedOut reports whether the request ran out of time, as opposed to
+// failing on its own.
+func timedOut(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+func requestTimedOut(err error) *APIError {
+	apiErr := newAPIError(http.StatusGatewayTimeout, "The request did not finish in time; try again").withCode("request_timeout")
+	apiErr.Err = err
+	return apiErr
+}
+
+// detachedContext is for writes that must happen even when the request that
+// makes them was cancelled or ran out of time, such as recording that a
+// login failed. It keeps ctx's values but not its cancellation.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), detachedTimeout)
+}
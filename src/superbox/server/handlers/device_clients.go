@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// officialClientID is the SuperBox CLI and Go SDK. It is built in, holds
+	// the "full" scope, and cannot be revoked.
+	officialClientID = "superbox-cli"
+	// fullScope gives a device login the user's own ID and refresh tokens.
+	fullScope = "full"
+
+	deviceClientsKey = "device_clients"
+)
+
+var deviceClientIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var officialClient = models.DeviceClient{
+	ClientID: officialClientID,
+	Name:     "SuperBox CLI",
+	Scopes:   []string{fullScope},
+	Official: true,
+}
+
+var errDeviceClientRevoked = errors.New("device client already revoked")
+
+func deviceClientKey(clientID string) string {
+	return "device_client:" + clientID
+}
+
+// loadDeviceClient returns a registered client, revoked ones included. They
+// are kept in the state store so a revocation reaches every replica at once.
+func loadDeviceClient(ctx context.Context, clientID string) (*models.DeviceClient, error) {
+	if clientID == officialClientID {
+		client := officialClient
+		return &client, nil
+	}
+	data, err := stateStore.Get(ctx, deviceClientKey(clientID))
+	if err != nil {
+		return nil, err
+	}
+	var client models.DeviceClient
+	if err := json.Unmarshal(data, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// deviceScopes checks the scopes a device login asks for against its client.
+// No scopes means every scope the client holds.
+func deviceScopes(client *models.DeviceClient, requested string) ([]string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, errors.New("Scope '" + scope + "' is not allowed for " + client.Name)
+		}
+	}
+	return scopes, nil
+}
+
+func listDeviceClients(c *gin.Context) {
+	ctx := c.Request.Context()
+	ids := []string{}
+	data, err := stateStore.Get(ctx, deviceClientsKey)
+	if err == nil {
+		err = json.Unmarshal(data, &ids)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to load device clients", err))
+		return
+	}
+
+	clients := []models.DeviceClient{officialClient}
+	for _, id := range ids {
+		if client, err := loadDeviceClient(ctx, id); err == nil {
+			clients = append(clients, *client)
+		}
+	}
+	sort.SliceStable(clients[1:], func(i, j int) bool { return clients[i+1].ClientID < clients[j+1].ClientID })
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"clients": clients,
+	})
+}
+
+// registerDeviceClient lets a tool start device logins under its own client
+// ID. A revoked client's ID is not given out again.
+func registerDeviceClient(c *gin.Context) {
+	var req models.RegisterDeviceClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	if !deviceClientIDPattern.MatchString(req.ClientID) {
+		respondError(c, newAPIError(http.StatusBadRequest, "client_id must be lowercase letters, digits, '-' and '_', at most 64 characters"))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if _, known := scopeActions[scope]; !known && scope != fullScope {
+			respondError(c, newAPIError(http.StatusBadRequest, "Unknown scope '"+scope+"'").withCode("invalid_scope"))
+			return
+		}
+	}
+
+	client := models.DeviceClient{
+		ClientID:  req.ClientID,
+		Name:      req.Name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		CreatedAt: models.Now(),
+	}
+	conflict := newAPIError(http.StatusConflict, "Device client '"+req.ClientID+"' is already registered")
+	if client.ClientID == officialClientID {
+		respondError(c, conflict)
+		return
+	}
+	record, _ := json.Marshal(client)
+	ctx := c.Request.Context()
+	created, err := stateStore.SetNX(ctx, deviceClientKey(client.ClientID), record, 0)
+	if err == nil && !created {
+		respondError(c, conflict)
+		return
+	}
+	if err == nil {
+		_, err = stateStore.SetNX(ctx, deviceClientsKey, []byte("[]"), 0)
+	}
+	if err == nil {
+		err = stateStore.Update(ctx, deviceClientsKey, 0, func(current []byte) ([]byte, error) {
+			ids := []string{}
+			if err := json.Unmarshal(current, &ids); err != nil {
+				return nil, err
+			}
+			return json.Marshal(append(ids, client.ClientID))
+		})
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to register device client", err))
+		return
+	}
+
+	auditChange(c, nil, client)
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"client": client,
+	})
+}
+
+// revokeDeviceClient stops a client from starting or finishing device logins
+// and ends the scoped tokens it was given.
+func revokeDeviceClient(c *gin.Context) {
+	clientID := c.Param("client_id")
+	if clientID == officialClientID {
+		respondError(c, newAPIError(http.StatusConflict, "The official CLI cannot be revoked"))
+		return
+	}
+
+	var revoked models.DeviceClient
+	err := stateStore.Update(c.Request.Context(), deviceClientKey(clientID), 0, func(current []byte) ([]byte, error) {
+		if err := json.Unmarshal(current, &revoked); err != nil {
+			return nil, err
+		}
+		if !revoked.RevokedAt.IsZero() {
+			return nil, errDeviceClientRevoked
+		}
+		revoked.RevokedAt = models.Now()
+		return json.Marshal(revoked)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Device client '"+clientID+"' not found"))
+		return
+	}
+	if errors.Is(err, errDeviceClientRevoked) {
+		respondError(c, newAPIError(http.StatusConflict, "Device client '"+clientID+"' is already revoked"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to revoke device client", err))
+		return
+	}
+
+	auditChange(c, gin.H{"client_id": clientID, "revoked_at": 0}, gin.H{"client_id": clientID, "revoked_at": revoked.RevokedAt})
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"client": revoked,
+	})
+}
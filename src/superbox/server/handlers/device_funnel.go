@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Device login steps, in funnel order. A step is counted once per session
+// when it succeeds; a failure is counted against the step it stopped at.
+const (
+	deviceStepStarted  = "started"
+	deviceStepCode     = "code_entered"
+	deviceStepRedirect = "provider_redirect"
+	deviceStepCallback = "callback"
+	deviceStepPoll     = "poll_completed"
+)
+
+const (
+	// deviceFunnelRetention is how long daily funnel counts are kept.
+	deviceFunnelRetention = 35 * 24 * time.Hour
+	maxDeviceFunnelDays   = 30
+)
+
+var deviceSteps = []string{deviceStepStarted, deviceStepCode, deviceStepRedirect, deviceStepCallback, deviceStepPoll}
+
+var (
+	// deviceLoginCounts are this process's counters for /metrics, keyed by
+	// provider, step, and failure reason ("" for a success).
+	deviceLoginCounts = make(map[[3]string]int)
+	deviceLoginMutex  sync.Mutex
+)
+
+// deviceFunnelDay is one tenant's device login counts for one UTC day.
+// Steps is keyed "provider:step", Failures "provider:step:reason".
+type deviceFunnelDay struct {
+	Steps    map[string]int `json:"steps"`
+	Failures map[string]int `json:"failures"`
+}
+
+type deviceFunnelStep struct {
+	Step  string `json:"step"`
+	Count int    `json:"count"`
+	// Rate is Count as a share of the sessions started.
+	Rate float64 `json:"rate"`
+	// DropOff is how many fewer sessions reached this step than the one
+	// before it.
+	DropOff int `json:"drop_off"`
+}
+
+type deviceFunnelFailure struct {
+	Provider string `json:"provider"`
+	Step     string `json:"step"`
+	Reason   string `json:"reason"`
+	Count    int    `json:"count"`
+}
+
+func deviceFunnelKey(tenantID string, day time.Time) string {
+	return "device_funnel:" + tenantID + ":" + day.UTC().Format("2006-01-02")
+}
+
+// trackDeviceLogin counts a device login step, or a failure at that step
+// when reason is set. Reasons are fixed strings so the metrics keep a small
+// label set. Without a session, such as for an unknown user code, the
+// request's tenant and the given provider are used.
+func trackDeviceLogin(c *gin.Context, session *models.DeviceSession, provider string, step string, reason string) {
+	tenantID := requestTenant(c).ID
+	if session != nil {
+		tenantID, provider = session.TenantID, session.Provider
+	}
+	if provider == "" {
+		provider = "unknown"
+	}
+
+	deviceLoginMutex.Lock()
+	deviceLoginCounts[[3]string{provider, step, reason}]++
+	deviceLoginMutex.Unlock()
+
+	// The daily counts go to the state store, since the steps of one login
+	// often land on different replicas.
+	ctx, cancel := detachedContext(c.Request.Context())
+	defer cancel()
+	key := deviceFunnelKey(tenantID, time.Now())
+	_, err := stateStore.SetNX(ctx, key, []byte("{}"), deviceFunnelRetention)
+	if err == nil {
+		err = stateStore.Update(ctx, key, deviceFunnelRetention, func(current []byte) ([]byte, error) {
+			day := deviceFunnelDay{Steps: map[string]int{}, Failures: map[string]int{}}
+			if err := json.Unmarshal(current, &day); err != nil {
+				return nil, err
+			}
+			if reason == "" {
+				day.Steps[provider+":"+step]++
+			} else {
+				day.Failures[provider+":"+step+":"+reason]++
+			}
+			return json.Marshal(day)
+		})
+	}
+	if err != nil {
+		slog.Warn("failed to record device login step", "step", step, "reason", reason, "error", err)
+	}
+}
+
+// oauthFailureReason keeps the OAuth error codes a provider redirects back
+// with, and folds anything else into provider_error.
+func oauthFailureReason(errorParam string) string {
+	switch errorParam {
+	case "access_denied", "invalid_scope", "server_error", "temporarily_unavailable", "redirect_uri_mismatch":
+		return errorParam
+	}
+	return "provider_error"
+}
+
+// getDeviceFunnel reports the tenant's device login funnel over the last
+// days (default 7), optionally for one provider: how many sessions reached
+// each step, where they dropped off, and why they failed.
+func getDeviceFunnel(c *gin.Context) {
+	days := 7
+	if raw := c.Query("days"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxDeviceFunnelDays {
+			apiErr := newAPIError(http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxDeviceFunnelDays)).withCode("invalid_request")
+			apiErr.Fields = []FieldError{{Field: "days", Message: "must be between 1 and " + strconv.Itoa(maxDeviceFunnelDays)}}
+			respondError(c, apiErr)
+			return
+		}
+		days = value
+	}
+	provider := strings.ToLower(c.Query("provider"))
+
+	tenantID := requestTenant(c).ID
+	today := time.Now().UTC()
+	totals := map[string]int{}
+	failures := map[[3]string]int{}
+	daily := []gin.H{}
+	for offset := days - 1; offset >= 0; offset-- {
+		day := today.AddDate(0, 0, -offset)
+		counts := deviceFunnelDay{}
+		if data, err := stateStore.Get(c.Request.Context(), deviceFunnelKey(tenantID, day)); err == nil {
+			json.Unmarshal(data, &counts)
+		}
+
+		steps := map[string]int{}
+		for key, count := range counts.Steps {
+			parts := strings.SplitN(key, ":", 2)
+			if len(parts) != 2 || (provider != "" && parts[0] != provider) {
+				continue
+			}
+			steps[parts[1]] += count
+			totals[parts[1]] += count
+		}
+		for key, count := range counts.Failures {
+			parts := strings.SplitN(key, ":", 3)
+			if len(parts) != 3 || (provider != "" && parts[0] != provider) {
+				continue
+			}
+			failures[[3]string{parts[0], parts[1], parts[2]}] += count
+		}
+		daily = append(daily, gin.H{"date": day.Format("2006-01-02"), "steps": steps})
+	}
+
+	funnel := []deviceFunnelStep{}
+	previous := 0
+	for i, step := range deviceSteps {
+		entry := deviceFunnelStep{Step: step, Count: totals[step]}
+		if started := totals[deviceStepStarted]; started > 0 {
+			entry.Rate = float64(entry.Count) / float64(started)
+		}
+		if i > 0 {
+			entry.DropOff = max(previous-entry.Count, 0)
+		}
+		previous = entry.Count
+		funnel = append(funnel, entry)
+	}
+
+	failureList := []deviceFunnelFailure{}
+	for key, count := range failures {
+		failureList = append(failureList, deviceFunnelFailure{Provider: key[0], Step: key[1], Reason: key[2], Count: count})
+	}
+	sort.Slice(failureList, func(i, j int) bool {
+		if failureList[i].Count != failureList[j].Count {
+			return failureList[i].Count > failureList[j].Count
+		}
+		a, b := failureList[i], failureList[j]
+		return a.Provider+a.Step+a.Reason < b.Provider+b.Step+b.Reason
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"days":     days,
+		"since":    today.AddDate(0, 0, 1-days).Format("2006-01-02"),
+		"provider": provider,
+		"funnel":   funnel,
+		"failures": failureList,
+		"daily":    daily,
+	})
+}
+
+// writeDeviceLoginMetrics adds this process's device login counters to the
+// Prometheus text served at /metrics.
+func writeDeviceLoginMetrics(out *strings.Builder) {
+	deviceLoginMutex.Lock()
+	keys := make([][3]string, 0, len(deviceLoginCounts))
+	counts := make(map[[3]string]int, len(deviceLoginCounts))
+	for key, count := range deviceLoginCounts {
+		keys = append(keys, key)
+		counts[key] = count
+	}
+	deviceLoginMutex.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], ":") < strings.Join(keys[j][:], ":")
+	})
+
+	out.WriteString("# HELP superbox_device_login_steps_total Device login sessions that completed each step.\n")
+	out.WriteString("# TYPE superbox_device_login_steps_total counter\n")
+	for _, key := range keys {
+		if key[2] == "" {
+			fmt.Fprintf(out, "superbox_device_login_steps_total{provider=%q,step=%q} %d\n", key[0], key[1], counts[key])
+		}
+	}
+	out.WriteString("# HELP superbox_device_login_failures_total Device login sessions that failed at each step, by reason.\n")
+	out.WriteString("# TYPE superbox_device_login_failures_total counter\n")
+	for _, key := range keys {
+		if key[2] != "" {
+			fmt.Fprintf(out, "superbox_device_login_failures_total{provider=%q,step=%q,reason=%q} %d\n", key[0], key[1], key[2], counts[key])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+)
+
+const (
+	// deviceMaxWait caps how long a device poll is held, below the 30 second
+	// timeouts the CLI and most proxies use.
+	deviceMaxWait = 25 * time.Second
+
+	// deviceWaitRecheck is how often a held poll re-reads the session, since
+	// the browser callback may finish the login on another replica.
+	deviceWaitRecheck = time.Second
+)
+
+var (
+	// deviceWaiters wakes polls held on this replica when their session
+	// changes here.
+	deviceWaiters      = make(map[string]map[chan struct{}]struct{})
+	deviceWaitersMutex sync.Mutex
+)
+
+func watchSession(deviceCode string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	deviceWaitersMutex.Lock()
+	if deviceWaiters[deviceCode] == nil {
+		deviceWaiters[deviceCode] = make(map[chan struct{}]struct{})
+	}
+	deviceWaiters[deviceCode][ch] = struct{}{}
+	deviceWaitersMutex.Unlock()
+
+	return ch, func() {
+		deviceWaitersMutex.Lock()
+		defer deviceWaitersMutex.Unlock()
+		delete(deviceWaiters[deviceCode], ch)
+		if len(deviceWaiters[deviceCode]) == 0 {
+			delete(deviceWaiters, deviceCode)
+		}
+	}
+}
+
+func notifySession(deviceCode string) {
+	deviceWaitersMutex.Lock()
+	defer deviceWaitersMutex.Unlock()
+	for ch := range deviceWaiters[deviceCode] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitForSession holds a poll until the session leaves the pending states,
+// wait elapses, the session expires, or the server starts draining, and
+// returns the latest copy. It returns nil if the session disappears.
+func waitForSession(ctx context.Context, deviceCode string, wait time.Duration) *models.DeviceSession {
+	changed, stop := watchSession(deviceCode)
+	defer stop()
+
+	session := getSessionCopy(ctx, deviceCode)
+	if session == nil {
+		return nil
+	}
+	if untilExpiry := time.Until(session.ExpiresAt); untilExpiry < wait {
+		wait = untilExpiry
+	}
+	deadline := time.NewTimer(min(wait, deviceMaxWait))
+	defer deadline.Stop()
+	recheck := time.NewTicker(deviceWaitRecheck)
+	defer recheck.Stop()
+
+	for session != nil && (session.Status == "pending" || session.Status == "authorizing") {
+		select {
+		case <-ctx.Done():
+			return session
+		case <-drained:
+			return session
+		case <-deadline.C:
+			return getSessionCopy(ctx, deviceCode)
+		case <-changed:
+		case <-recheck.C:
+		}
+		session = getSessionCopy(ctx, deviceCode)
+	}
+	return session
+}
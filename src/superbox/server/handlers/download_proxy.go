@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyChunkSize is how much of a proxied download is written, and
+// throttled, at a time.
+const proxyChunkSize = 32 << 10
+
+// BandwidthUsage is what the caller has downloaded through the server today,
+// as reported by GET /me/limits. Reset is the Unix time the day ends, in
+// UTC. A limit of zero is unlimited.
+type BandwidthUsage struct {
+	LimitBytes     int64 `json:"limit_bytes"`
+	UsedBytes      int64 `json:"used_bytes"`
+	RemainingBytes int64 `json:"remaining_bytes"`
+	Reset          int64 `json:"reset"`
+}
+
+// downloadThrottle paces every proxied download of one user, so opening
+// more connections does not raise their rate.
+type downloadThrottle struct {
+	mutex sync.Mutex
+	// next is when the bytes granted so far will have been sent at the rate.
+	next    time.Time
+	streams int
+}
+
+var (
+	downloadThrottles     = make(map[string]*downloadThrottle)
+	downloadThrottleMutex sync.Mutex
+)
+
+func downloadMode(tenant *config.Tenant) string {
+	if tenant != nil && tenant.DownloadMode != "" {
+		return tenant.DownloadMode
+	}
+	if appConfig.DownloadMode == "" {
+		return "presigned"
+	}
+	return appConfig.DownloadMode
+}
+
+// blobDownloadLink is the download_url handed to users for a ready blob:
+// a presigned storage URL, or in proxy mode the API route that streams it.
+func blobDownloadLink(ctx context.Context, blob models.Blob) (string, error) {
+	if downloadMode(tenantFrom(ctx)) == "proxy" {
+		return "/api/v1/blobs/" + blob.ID + "/content", nil
+	}
+	return blobDownloadURL(ctx, blob)
+}
+
+func bandwidthKey(tenantID string, userID string, day time.Time) string {
+	return "bandwidth:" + tenantID + ":" + userID + ":" + day.Format(time.DateOnly)
+}
+
+func bandwidthUsage(c *gin.Context, userID string) BandwidthUsage {
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	usage := BandwidthUsage{
+		LimitBytes: int64(callerPlan(c).BandwidthGBPerDay) << 30,
+		Reset:      day.Add(24 * time.Hour).Unix(),
+	}
+	if data, err := stateStore.Get(c.Request.Context(), bandwidthKey(requestTenant(c).ID, userID, day)); err == nil {
+		usage.UsedBytes, _ = strconv.ParseInt(string(data), 10, 64)
+	}
+	if usage.LimitBytes > 0 {
+		usage.RemainingBytes = max(usage.LimitBytes-usage.UsedBytes, 0)
+	}
+	return usage
+}
+
+// recordBandwidth adds bytes to the user's count for today. The count is kept
+// for two days, so it outlives the day it covers.
+func recordBandwidth(ctx context.Context, tenantID string, userID string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	key := bandwidthKey(tenantID, userID, time.Now().UTC())
+	_, err := stateStore.SetNX(ctx, key, []byte("0"), 48*time.Hour)
+	if err == nil {
+		err = stateStore.Update(ctx, key, 48*time.Hour, func(current []byte) ([]byte, error) {
+			used, _ := strconv.ParseInt(string(current), 10, 64)
+			return []byte(strconv.FormatInt(used+bytes, 10)), nil
+		})
+	}
+	if err != nil {
+		slog.Warn("failed to record download bandwidth", "user_id", userID, "bytes", bytes, "error", err)
+	}
+}
+
+func acquireThrottle(key string) *downloadThrottle {
+	downloadThrottleMutex.Lock()
+	defer downloadThrottleMutex.Unlock()
+	throttle, exists := downloadThrottles[key]
+	if !exists {
+		throttle = &downloadThrottle{}
+		downloadThrottles[key] = throttle
+	}
+	throttle.streams++
+	return throttle
+}
+
+func releaseThrottle(key string) {
+	downloadThrottleMutex.Lock()
+	defer downloadThrottleMutex.Unlock()
+	if throttle := downloadThrottles[key]; throttle != nil {
+		if throttle.streams--; throttle.streams == 0 {
+			delete(downloadThrottles, key)
+		}
+	}
+}
+
+// wait blocks until n more bytes may be sent at rate bytes per second.
+func (t *downloadThrottle) wait(ctx context.Context, n int, rate int64) error {
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	t.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// downloadBlob streams a ready blob from storage through the server, for
+// deployments that must not expose storage URLs. Range requests are passed
+// on, so interrupted downloads resume. Every byte sent counts against the
+// caller's daily bandwidth, and a user's downloads share
+// DOWNLOAD_BYTES_PER_SECOND between them.
+func downloadBlob(c *gin.Context) {
+	blob, ok := ownedBlob(c)
+	if !ok {
+		return
+	}
+	if downloadMode(requestTenant(c)) != "proxy" {
+		respondError(c, newAPIError(http.StatusNotFound, "Downloads are served from download_url; this deployment does not proxy them").withCode("proxy_disabled"))
+		return
+	}
+	if blob.Status != "ready" {
+		respondError(c, newAPIError(http.StatusConflict, "Blob '"+blob.ID+"' is "+blob.Status+" and cannot be downloaded"))
+		return
+	}
+
+	userID := c.GetString("user_id")
+	tenantID := requestTenant(c).ID
+	usage := bandwidthUsage(c, userID)
+	if usage.LimitBytes > 0 && usage.UsedBytes >= usage.LimitBytes {
+		c.Header("Retry-After", strconv.FormatInt(usage.Reset-time.Now().Unix()+1, 10))
+		respondError(c, newAPIError(http.StatusTooManyRequests, "The "+callerPlan(c).Name+" plan's daily download bandwidth is spent; try again tomorrow or upgrade").
+			withDetail("quota", "bandwidth_gb_per_day").
+			withDetail("limit_bytes", usage.LimitBytes).
+			withDetail("reset", usage.Reset))
+		return
+	}
+
+	ctx := c.Request.Context()
+	url, err := blobDownloadURL(ctx, blob)
+	if err != nil {
+		respondError(c, internalError("Error reading blob", err))
+		return
+	}
+	req, err := newOutboundRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		respondError(c, internalError("Error reading blob", err))
+		return
+	}
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	// The outbound client's timeout covers reading the whole body, which a
+	// throttled download outlasts; the caller's request bounds it instead.
+	resp, err := (&http.Client{Transport: outboundClient.Transport}).Do(req)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadGateway, "Storage could not be reached"))
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		c.Header("Content-Range", resp.Header.Get("Content-Range"))
+		respondError(c, newAPIError(http.StatusRequestedRangeNotSatisfiable, "Requested range is outside the blob"))
+		return
+	default:
+		respondError(c, newAPIError(http.StatusBadGateway, fmt.Sprintf("Storage returned %d for the blob", resp.StatusCode)))
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", blob.ContentType)
+	header.Set("Content-Disposition", `attachment; filename="`+blob.ID+`"`)
+	header.Set("Cache-Control", "private, no-store")
+	header.Set("Accept-Ranges", "bytes")
+	for _, name := range []string{"Content-Length", "Content-Range", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+
+	throttleKey := tenantID + ":" + userID
+	throttle := acquireThrottle(throttleKey)
+	defer releaseThrottle(throttleKey)
+	rate := appConfig.DownloadBytesPerSecond
+
+	var sent int64
+	buffer := make([]byte, proxyChunkSize)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			if rate > 0 {
+				if err := throttle.wait(ctx, n, rate); err != nil {
+					break
+				}
+			}
+			written, err := c.Writer.Write(buffer[:n])
+			sent += int64(written)
+			if err != nil {
+				break
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				slog.Warn("proxied blob download ended early", "blob_id", blob.ID, "sent", sent, "error", readErr)
+			}
+			break
+		}
+	}
+	// Count what was sent even when the caller went away, against a context
+	// that outlives the request.
+	recordBandwidth(context.WithoutCancel(ctx), tenantID, userID, sent)
+}
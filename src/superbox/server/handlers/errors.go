@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type APIError struct {
+	Status    int                    `json:"-"`
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Fields    []FieldError           `json:"fields,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Err       error                  `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusPaymentRequired:       "payment_required",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+func newAPIError(status int, message string) *APIError {
+	code, exists := errorCodes[status]
+	if !exists {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func internalError(message string, err error) *APIError {
+	apiErr := newAPIError(http.StatusInternalServerError, message)
+	apiErr.Err = err
+	return apiErr
+}
+
+func upstreamError(message string, err error) *APIError {
+	apiErr := newAPIError(http.StatusBadGateway, message)
+	apiErr.Err = err
+	return apiErr
+}
+
+func payloadTooLarge(limit int64) *APIError {
+	return newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit", limit))
+}
+
+// invalidRequest maps a binding error to an APIError. Bodies that decode
+// but fail validation, or carry a value of the wrong type, return 422 with
+// one entry per field; malformed bodies return 400.
+func invalidRequest(err error) *APIError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return payloadTooLarge(maxBytesErr.Limit)
+	}
+
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		apiErr := validationFailed()
+		apiErr.Err = err
+		for _, fieldErr := range validationErrors {
+			apiErr.Fields = append(apiErr.Fields, FieldError{
+				Field:   fieldPath(fieldErr),
+				Message: validationMessage(fieldErr),
+			})
+		}
+		return apiErr
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		apiErr := validationFailed()
+		apiErr.Err = err
+		apiErr.Fields = []FieldError{{Field: typeErr.Field, Message: "must be " + jsonTypeName(typeErr.Type.Kind())}}
+		return apiErr
+	}
+
+	apiErr := newAPIError(http.StatusBadRequest, "Invalid request")
+	apiErr.Code = "invalid_request"
+	apiErr.Err = err
+	return apiErr
+}
+
+func validationFailed() *APIError {
+	return newAPIError(http.StatusUnprocessableEntity, "Request validation failed").withCode("validation_failed")
+}
+
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fieldErr.Kind() == reflect.String {
+			return "must be at least " + fieldErr.Param() + " characters"
+		}
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		if fieldErr.Kind() == reflect.String {
+			return "must be at most " + fieldErr.Param() + " characters"
+		}
+		return "must be at most " + fieldErr.Param()
+	case "gte":
+		return "must be at least " + fieldErr.Param()
+	case "lte":
+		return "must be at most " + fieldErr.Param()
+	case "oneof":
+		return "must be one of " + fieldErr.Param()
+	case "semver":
+		return "must be a semantic version such as 1.2.3"
+	case "password":
+		return fmt.Sprintf("must be at least %d characters and contain a letter and a digit", minPasswordLength)
+	case "currency":
+		return "must be an ISO 4217 currency code"
+	case "url", "http_url":
+		return "must be an absolute http(s) URL"
+	case "iso3166_1_alpha2":
+		return "must be an ISO 3166-1 alpha-2 code"
+	}
+	return "failed the " + fieldErr.Tag() + " check"
+}
+
+func (e *APIError) withCode(code string) *APIError {
+	e.Code = code
+	return e
+}
+
+func (e *APIError) withDetail(key string, value interface{}) *APIError {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+func respondError(c *gin.Context, apiErr *APIError) {
+	c.Error(apiErr)
+	c.Abort()
+}
+
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		var apiErr *APIError
+		if !errors.As(c.Errors.Last().Err, &apiErr) {
+			apiErr = internalError("Internal server error", c.Errors.Last().Err)
+		}
+		if apiErr.Status >= http.StatusInternalServerError && timedOut(c) {
+			apiErr = requestTimedOut(apiErr.Err)
+		}
+		apiErr.RequestID = c.GetString("request_id")
+		scrubAPIError(apiErr)
+		apiErr = localizeError(c, apiErr)
+
+		if c.GetInt("api_version") >= 2 {
+			c.JSON(apiErr.Status, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(apiErr.Status, gin.H{
+			"status": "error",
+			"detail": apiErr.Message,
+			"error":  apiErr,
+		})
+	}
+}
+
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		reportPanic(c, recovered)
+		respondError(c, internalError("Internal server error", fmt.Errorf("panic: %v", recovered)))
+	})
+}
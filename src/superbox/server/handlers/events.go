@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"superbox/server/events"
+	"superbox/server/models"
+)
+
+// registryChanged is published for every write to a stored server record,
+// whichever handler or job made it; the server.* events announce the
+// publisher-facing changes.
+const registryChanged = "registry.changed"
+
+var (
+	eventBus      events.Bus
+	eventBusMutex sync.RWMutex
+)
+
+func init() {
+	eventBus = subscribeAll(events.NewMemory())
+}
+
+// SetEventBus replaces the in-process bus, for example with one that shares
+// events across replicas, and subscribes the server's reactions to it.
+func SetEventBus(bus events.Bus) {
+	eventBusMutex.Lock()
+	defer eventBusMutex.Unlock()
+	eventBus = subscribeAll(bus)
+}
+
+func CloseEventBus() error {
+	eventBusMutex.RLock()
+	defer eventBusMutex.RUnlock()
+	return eventBus.Close()
+}
+
+// subscribeAll registers what reacts to events instead of being called from
+// the handlers that cause them: the snapshot cache, webhook deliveries, and
+// notifications.
+func subscribeAll(bus events.Bus) events.Bus {
+	bus.Subscribe("snapshots", []string{registryChanged}, refreshSnapshotOnChange)
+
+	webhookTypes := make([]string, 0, len(webhookEvents))
+	for event := range webhookEvents {
+		webhookTypes = append(webhookTypes, event)
+	}
+	bus.Subscribe("webhooks", webhookTypes, deliverToWebhooks)
+
+	bus.Subscribe("notifications", []string{"purchase.completed", "server.updated", "scan.completed"}, notifyOnEvent)
+	return bus
+}
+
+// emit publishes an event. Failing to publish does not fail the change that
+// caused it, which has already been made.
+func emit(ctx context.Context, eventType string, tenantID string, subject string, data interface{}) {
+	event, err := events.NewEvent(eventType, tenantID, subject, data)
+	if err == nil {
+		eventBusMutex.RLock()
+		bus := eventBus
+		eventBusMutex.RUnlock()
+		err = bus.Publish(ctx, event)
+	}
+	if err != nil {
+		slog.Error("failed to publish event", "event", eventType, "subject", subject, "error", err)
+	}
+}
+
+// eventContext carries the event's tenant, since events read from another
+// replica arrive without a request.
+func eventContext(ctx context.Context, event events.Event) context.Context {
+	return withTenant(ctx, tenantByID(event.TenantID))
+}
+
+func refreshSnapshotOnChange(ctx context.Context, event events.Event) error {
+	var data struct {
+		ServerName string `json:"server_name"`
+	}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return err
+	}
+	refreshSnapshotEntry(eventContext(ctx, event), data.ServerName)
+	return nil
+}
+
+// deliverToWebhooks queues the event for the endpoints subscribed to it,
+// with the event's data as the delivery payload.
+func deliverToWebhooks(ctx context.Context, event events.Event) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return err
+	}
+	return publishEvent(ctx, event.Type, event.Subject, data)
+}
+
+func notifyOnEvent(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case "purchase.completed":
+		var data struct {
+			UserID         string `json:"user_id"`
+			ServerName     string `json:"server_name"`
+			Plan           string `json:"plan"`
+			SubscriptionID string `json:"subscription_id"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		notify(ctx, data.UserID, "purchase.completed", "Your purchase of "+data.ServerName+" is complete.", map[string]interface{}{
+			"server_name":     data.ServerName,
+			"plan":            data.Plan,
+			"subscription_id": data.SubscriptionID,
+		})
+
+	case "server.updated":
+		var data struct {
+			Server models.Server `json:"server"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		return notifyInstalledServerUsers(ctx, event.TenantID, data.Server, false)
+
+	case "scan.completed":
+		var data struct {
+			Server models.Server          `json:"server"`
+			Passed bool                   `json:"passed"`
+			Report map[string]interface{} `json:"security_report"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		if data.Passed {
+			return nil
+		}
+		notify(ctx, data.Server.Meta.OwnerID, "scan.failed", "The security scan for "+data.Server.Name+" found issues.", map[string]interface{}{
+			"server_name": data.Server.Name,
+			"summary":     data.Report["summary"],
+		})
+		return notifyInstalledServerUsers(ctx, event.TenantID, data.Server, true)
+	}
+	return nil
+}
@@ -0,0 +1,814 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// routingTransport sends outbound requests for known upstream hosts to the
+// matching fake and fails the test on anything else, so a new upstream call
+// cannot silently reach the network.
+type routingTransport struct {
+	t     testing.TB
+	hosts map[string]*httptest.Server
+}
+
+func (rt *routingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fake, ok := rt.hosts[req.URL.Host]
+	if !ok {
+		rt.t.Errorf("unexpected upstream call: %s %s", req.Method, req.URL)
+		return nil, fmt.Errorf("no fake for host %s", req.URL.Host)
+	}
+	target, _ := url.Parse(fake.URL)
+	routed := req.Clone(req.Context())
+	routed.URL.Scheme = target.Scheme
+	routed.URL.Host = target.Host
+	routed.Host = target.Host
+	return http.DefaultTransport.RoundTrip(routed)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func firebaseFailure(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]interface{}{"code": 400, "message": message},
+	})
+}
+
+type fakeAccount struct {
+	LocalID       string
+	Email         string
+	Password      string
+	DisplayName   string
+	EmailVerified bool
+	// Claims is the account's custom claims as a JSON object.
+	Claims string
+}
+
+// fakeIdentity implements the Identity Toolkit endpoints the server calls.
+// Every sign-in issues a new opaque ID token that accounts:lookup resolves.
+type fakeIdentity struct {
+	mutex     sync.Mutex
+	apiKey    string
+	accounts  map[string]*fakeAccount
+	tokens    map[string]string
+	providers map[string]string
+	issued    int
+}
+
+func newFakeIdentity(apiKey string) *fakeIdentity {
+	return &fakeIdentity{
+		apiKey:    apiKey,
+		accounts:  make(map[string]*fakeAccount),
+		tokens:    make(map[string]string),
+		providers: make(map[string]string),
+	}
+}
+
+// addUser creates a verified account and returns its local ID and a valid ID token.
+func (f *fakeIdentity) addUser(email string) (string, string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.createLocked(email)
+	account.EmailVerified = true
+	return account.LocalID, f.issueLocked(account.LocalID)
+}
+
+// setClaims sets an account's custom claims, as the Admin SDK would.
+func (f *fakeIdentity) setClaims(localID string, claims string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.accounts[localID].Claims = claims
+}
+
+func (f *fakeIdentity) createLocked(email string) *fakeAccount {
+	account := &fakeAccount{LocalID: fmt.Sprintf("uid-%d", len(f.accounts)+1), Email: email}
+	f.accounts[account.LocalID] = account
+	return account
+}
+
+func (f *fakeIdentity) issueLocked(localID string) string {
+	f.issued++
+	token := fmt.Sprintf("id-token-%d", f.issued)
+	f.tokens[token] = localID
+	return token
+}
+
+func (f *fakeIdentity) session(account *fakeAccount) map[string]interface{} {
+	return map[string]interface{}{
+		"idToken":      f.issueLocked(account.LocalID),
+		"refreshToken": "refresh-" + account.LocalID,
+		"expiresIn":    "3600",
+		"localId":      account.LocalID,
+		"email":        account.Email,
+	}
+}
+
+func (f *fakeIdentity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("key") != f.apiKey {
+		firebaseFailure(w, "API key not valid. Please pass a valid API key.")
+		return
+	}
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	str := func(name string) string {
+		value, _ := body[name].(string)
+		return value
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch strings.TrimPrefix(r.URL.Path, "/v1/") {
+	case "accounts:lookup":
+		localID, ok := f.tokens[str("idToken")]
+		if !ok {
+			firebaseFailure(w, "INVALID_ID_TOKEN")
+			return
+		}
+		account := f.accounts[localID]
+		user := map[string]interface{}{
+			"localId":       account.LocalID,
+			"email":         account.Email,
+			"displayName":   account.DisplayName,
+			"emailVerified": account.EmailVerified,
+		}
+		if account.Claims != "" {
+			user["customAttributes"] = account.Claims
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"users": []interface{}{user}})
+	case "accounts:signUp":
+		for _, account := range f.accounts {
+			if account.Email == str("email") {
+				firebaseFailure(w, "EMAIL_EXISTS")
+				return
+			}
+		}
+		account := f.createLocked(str("email"))
+		account.Password = str("password")
+		writeJSON(w, http.StatusOK, f.session(account))
+	case "accounts:signInWithPassword":
+		for _, account := range f.accounts {
+			if account.Email == str("email") && account.Password != "" && account.Password == str("password") {
+				writeJSON(w, http.StatusOK, f.session(account))
+				return
+			}
+		}
+		firebaseFailure(w, "INVALID_LOGIN_CREDENTIALS")
+	case "accounts:signInWithIdp":
+		params, _ := url.ParseQuery(str("postBody"))
+		credential := params.Get("id_token") + params.Get("access_token")
+		subject := params.Get("providerId") + ":" + credential
+		if credential == "" {
+			firebaseFailure(w, "INVALID_IDP_RESPONSE")
+			return
+		}
+		localID, known := f.providers[subject]
+		if !known {
+			account := f.createLocked(strings.TrimPrefix(credential, "token-") + "@example.com")
+			account.EmailVerified = true
+			localID = account.LocalID
+			f.providers[subject] = localID
+		}
+		writeJSON(w, http.StatusOK, f.session(f.accounts[localID]))
+	default:
+		firebaseFailure(w, "UNSUPPORTED_FAKE_ENDPOINT "+r.URL.Path)
+	}
+}
+
+// fakeOAuth stands in for the Google, GitHub, GitLab, and Microsoft token
+// endpoints. Any
+// authorization code is accepted once and exchanged for a token derived
+// from it, so tests can predict which account a code signs in.
+type fakeOAuth struct {
+	mutex        sync.Mutex
+	clientID     string
+	clientSecret string
+	used         map[string]bool
+}
+
+func newFakeOAuth(clientID string, clientSecret string) *fakeOAuth {
+	return &fakeOAuth{clientID: clientID, clientSecret: clientSecret, used: make(map[string]bool)}
+}
+
+func (f *fakeOAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.PostForm.Get("client_id") != f.clientID || r.PostForm.Get("client_secret") != f.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	f.mutex.Lock()
+	reused := f.used[code]
+	f.used[code] = true
+	f.mutex.Unlock()
+	if code == "" || reused {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid_grant"})
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/token", path == "/oauth/token", strings.HasSuffix(path, "/oauth2/v2.0/token"):
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id_token": "token-" + code, "access_token": "google-" + code})
+	case path == "/login/oauth/access_token":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": "token-" + code, "token_type": "bearer"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeGitHub serves repository files from raw.githubusercontent.com and
+// repository permissions from api.github.com. Tests add files by
+// "owner/repo/path" and grant admin on "owner/repo" to an access token.
+type fakeGitHub struct {
+	mutex  sync.Mutex
+	files  map[string]string
+	admins map[string]map[string]bool
+}
+
+func newFakeGitHub() *fakeGitHub {
+	return &fakeGitHub{files: make(map[string]string), admins: make(map[string]map[string]bool)}
+}
+
+func (f *fakeGitHub) addFile(repo string, path string, content string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.files[repo+"/"+path] = content
+}
+
+func (f *fakeGitHub) grantAdmin(token string, repo string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.admins[token] == nil {
+		f.admins[token] = make(map[string]bool)
+	}
+	f.admins[token][repo] = true
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if repo, found := strings.CutPrefix(r.URL.Path, "/repos/"); found {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"message": "Bad credentials"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"full_name":   repo,
+			"permissions": map[string]interface{}{"admin": f.admins[token][repo], "push": true, "pull": true},
+		})
+		return
+	}
+
+	// Raw paths are /owner/repo/ref/path; the fake serves every ref alike.
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 4)
+	if len(segments) == 4 {
+		if content, exists := f.files[segments[0]+"/"+segments[1]+"/"+segments[3]]; exists {
+			w.Write([]byte(content))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// fakeModeration flags text or image URLs containing a term added with
+// flag, reporting the term's category.
+type fakeModeration struct {
+	mutex sync.Mutex
+	terms map[string]string
+}
+
+func newFakeModeration() *fakeModeration {
+	return &fakeModeration{terms: make(map[string]string)}
+}
+
+func (f *fakeModeration) flag(term string, category string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.terms[term] = category
+}
+
+func (f *fakeModeration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testModerationKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid api key"})
+		return
+	}
+	var input map[string]string
+	json.NewDecoder(r.Body).Decode(&input)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	categories := []string{}
+	for term, category := range f.terms {
+		if strings.Contains(input["text"]+input["image_url"], term) {
+			categories = append(categories, category)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flagged": len(categories) > 0, "categories": categories})
+}
+
+// fakeRazorpay keeps orders and payments in memory. Tests complete checkout
+// with pay, which returns the signature the Razorpay widget would hand back.
+type fakeRazorpay struct {
+	mutex     sync.Mutex
+	keyID     string
+	keySecret string
+	orders    map[string]map[string]interface{}
+	payments  map[string]map[string]interface{}
+	links     map[string]map[string]interface{}
+	// requestIDs holds the X-Request-ID of each authenticated call.
+	requestIDs []string
+}
+
+func newFakeRazorpay(keyID string, keySecret string) *fakeRazorpay {
+	return &fakeRazorpay{
+		keyID:     keyID,
+		keySecret: keySecret,
+		orders:    make(map[string]map[string]interface{}),
+		payments:  make(map[string]map[string]interface{}),
+		links:     make(map[string]map[string]interface{}),
+	}
+}
+
+func (f *fakeRazorpay) pay(orderID string) (string, string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	order := f.orders[orderID]
+	paymentID := fmt.Sprintf("pay_fake%d", len(f.payments)+1)
+	f.payments[paymentID] = map[string]interface{}{
+		"id":       paymentID,
+		"order_id": orderID,
+		"amount":   order["amount"],
+		"currency": order["currency"],
+		"status":   "captured",
+		"method":   "upi",
+	}
+	order["status"] = "paid"
+
+	mac := hmac.New(sha256.New, []byte(f.keySecret))
+	mac.Write([]byte(orderID + "|" + paymentID))
+	return paymentID, hex.EncodeToString(mac.Sum(nil))
+}
+
+// payLink pays a payment link in full and returns the payment and the
+// fields Razorpay appends to the link's callback URL.
+func (f *fakeRazorpay) payLink(linkID string) map[string]interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	link := f.links[linkID]
+	// Handler orders outlive the harness, so the link's order is named
+	// apart from those of earlier tests.
+	orderID := randomID("order_fakelink")
+	f.orders[orderID] = map[string]interface{}{"id": orderID, "amount": link["amount"], "currency": link["currency"], "status": "paid"}
+	paymentID := fmt.Sprintf("pay_fake%d", len(f.payments)+1)
+	f.payments[paymentID] = map[string]interface{}{
+		"id":       paymentID,
+		"order_id": orderID,
+		"amount":   link["amount"],
+		"currency": link["currency"],
+		"status":   "captured",
+		"method":   "upi",
+	}
+	link["status"] = "paid"
+
+	referenceID, _ := link["reference_id"].(string)
+	mac := hmac.New(sha256.New, []byte(f.keySecret))
+	mac.Write([]byte(linkID + "|" + referenceID + "|paid|" + paymentID))
+	return map[string]interface{}{
+		"razorpay_payment_id":                paymentID,
+		"razorpay_payment_link_reference_id": referenceID,
+		"razorpay_payment_link_status":       "paid",
+		"razorpay_signature":                 hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func (f *fakeRazorpay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if keyID, secret, ok := r.BasicAuth(); !ok || keyID != f.keyID || secret != f.keySecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": map[string]interface{}{"code": "BAD_REQUEST_ERROR", "description": "Authentication failed"}})
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requestIDs = append(f.requestIDs, r.Header.Get("X-Request-ID"))
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case r.Method == http.MethodPost && path == "orders":
+		var order map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&order)
+		order["id"] = fmt.Sprintf("order_fake%d", len(f.orders)+1)
+		order["status"] = "created"
+		f.orders[order["id"].(string)] = order
+		writeJSON(w, http.StatusOK, order)
+	case r.Method == http.MethodPost && path == "payment_links":
+		var link map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&link)
+		link["id"] = fmt.Sprintf("plink_fake%d", len(f.links)+1)
+		link["short_url"] = "https://rzp.io/i/fake" + strconv.Itoa(len(f.links)+1)
+		link["status"] = "created"
+		f.links[link["id"].(string)] = link
+		writeJSON(w, http.StatusOK, link)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "payment_links/") && strings.HasSuffix(path, "/cancel"):
+		link, ok := f.links[strings.TrimSuffix(strings.TrimPrefix(path, "payment_links/"), "/cancel")]
+		if !ok || link["status"] != "created" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": map[string]interface{}{"code": "BAD_REQUEST_ERROR", "description": "payment link cannot be cancelled"}})
+			return
+		}
+		link["status"] = "cancelled"
+		writeJSON(w, http.StatusOK, link)
+	case r.Method == http.MethodGet && path == "payments":
+		items := []map[string]interface{}{}
+		for _, payment := range f.payments {
+			items = append(items, payment)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i]["id"].(string) < items[j]["id"].(string) })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(items), "items": items})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "payments/"):
+		payment, ok := f.payments[strings.TrimPrefix(path, "payments/")]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": map[string]interface{}{"code": "BAD_REQUEST_ERROR", "description": "The id provided does not exist"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, payment)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "payments/") && strings.HasSuffix(path, "/refund"):
+		var refund map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&refund)
+		refund["id"] = fmt.Sprintf("rfnd_fake%d", len(f.payments))
+		refund["payment_id"] = strings.TrimSuffix(strings.TrimPrefix(path, "payments/"), "/refund")
+		writeJSON(w, http.StatusOK, refund)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeStripe serves the Stripe payment intent and refund endpoints. Intents
+// start as requires_payment_method until pay settles them; amounts are kept
+// in the smallest currency unit as Stripe sends them.
+type fakeStripe struct {
+	mutex     sync.Mutex
+	secretKey string
+	intents   map[string]map[string]interface{}
+	// decline, when set, fails the next request with this card error code.
+	decline string
+}
+
+func newFakeStripe(secretKey string) *fakeStripe {
+	return &fakeStripe{secretKey: secretKey, intents: make(map[string]map[string]interface{})}
+}
+
+func (f *fakeStripe) pay(intentID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.intents[intentID]["status"] = "succeeded"
+}
+
+func (f *fakeStripe) intent(intentID string) map[string]interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.intents[intentID]
+}
+
+func (f *fakeStripe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stripeError := func(status int, kind string, code string, message string) {
+		writeJSON(w, status, map[string]interface{}{"error": map[string]interface{}{"type": kind, "code": code, "message": message}})
+	}
+	if secret, _, ok := r.BasicAuth(); !ok || secret != f.secretKey {
+		stripeError(http.StatusUnauthorized, "invalid_request_error", "", "Invalid API Key provided")
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.decline != "" {
+		stripeError(http.StatusPaymentRequired, "card_error", f.decline, "Your card was declined.")
+		f.decline = ""
+		return
+	}
+	r.ParseForm()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case r.Method == http.MethodPost && path == "payment_intents":
+		amount, _ := strconv.Atoi(r.PostForm.Get("amount"))
+		id := fmt.Sprintf("pi_fake%d", len(f.intents)+1)
+		f.intents[id] = map[string]interface{}{
+			"id":                   id,
+			"object":               "payment_intent",
+			"amount":               amount,
+			"currency":             r.PostForm.Get("currency"),
+			"status":               "requires_payment_method",
+			"client_secret":        id + "_secret",
+			"payment_method_types": []string{"card"},
+			"created":              time.Now().Unix(),
+		}
+		writeJSON(w, http.StatusOK, f.intents[id])
+	case r.Method == http.MethodGet && path == "payment_intents":
+		items := []map[string]interface{}{}
+		for _, intent := range f.intents {
+			items = append(items, intent)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i]["id"].(string) < items[j]["id"].(string) })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": items, "has_more": false})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "payment_intents/"):
+		intent, ok := f.intents[strings.TrimPrefix(path, "payment_intents/")]
+		if !ok {
+			stripeError(http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent")
+			return
+		}
+		writeJSON(w, http.StatusOK, intent)
+	case r.Method == http.MethodPost && path == "refunds":
+		amount, _ := strconv.Atoi(r.PostForm.Get("amount"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "re_fake", "payment_intent": r.PostForm.Get("payment_intent"), "amount": amount, "status": "succeeded"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeStorage replaces the Python S3 helper with an in-memory bucket map.
+// Values go through JSON like the helper's output, so handlers see the
+// same float64 numbers and nested maps they get in production.
+type fakeStorage struct {
+	mutex   sync.Mutex
+	objects map[string]map[string][]byte
+	types   map[string]string
+	// invalidations holds the paths of each CDN invalidation, by caller
+	// reference.
+	invalidations map[string][]string
+	// multiparts holds the multipart uploads in progress, by upload id.
+	multiparts map[string]*fakeMultipart
+	// classes holds the storage class objects were moved to, by key.
+	classes map[string]string
+	// states holds DynamoDB state items, by table and then key.
+	states map[string]map[string]fakeStateItem
+}
+
+type fakeStateItem struct {
+	value     string
+	expiresAt int64
+	version   string
+}
+
+type fakeMultipart struct {
+	bucket      string
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{objects: make(map[string]map[string][]byte), types: make(map[string]string), invalidations: make(map[string][]string), multiparts: make(map[string]*fakeMultipart), classes: make(map[string]string), states: make(map[string]map[string]fakeStateItem)}
+}
+
+func (f *fakeStorage) bucket(name string) map[string][]byte {
+	if f.objects[name] == nil {
+		f.objects[name] = make(map[string][]byte)
+	}
+	return f.objects[name]
+}
+
+func (f *fakeStorage) call(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	str := func(name string) string {
+		value, _ := args[name].(string)
+		return value
+	}
+	decode := func(data []byte) interface{} {
+		var value interface{}
+		json.Unmarshal(data, &value)
+		return value
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	bucket := f.bucket(str("bucket_name"))
+	prefix := str("prefix")
+	serverKey := prefix + str("server_name") + ".json"
+
+	switch function {
+	case "get_server":
+		data, ok := bucket[serverKey]
+		if !ok {
+			return map[string]interface{}{"data": nil}, nil
+		}
+		return map[string]interface{}{"data": decode(data)}, nil
+	case "list_servers":
+		servers := map[string]interface{}{}
+		for key, data := range bucket {
+			name, ok := strings.CutPrefix(key, prefix)
+			if !ok || !strings.HasSuffix(name, ".json") || strings.Contains(name, "/") {
+				continue
+			}
+			servers[strings.TrimSuffix(name, ".json")] = decode(data)
+		}
+		return map[string]interface{}{"data": servers}, nil
+	case "list_server_names":
+		names := []interface{}{}
+		for key := range bucket {
+			name, ok := strings.CutPrefix(key, prefix)
+			if !ok || !strings.HasSuffix(name, ".json") || strings.Contains(name, "/") {
+				continue
+			}
+			names = append(names, strings.TrimSuffix(name, ".json"))
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i].(string) < names[j].(string) })
+		return map[string]interface{}{"data": names}, nil
+	case "upsert_server":
+		data, err := json.Marshal(args["server_data"])
+		if err != nil {
+			return nil, err
+		}
+		bucket[serverKey] = data
+		return map[string]interface{}{"success": true}, nil
+	case "delete_server":
+		delete(bucket, serverKey)
+		return map[string]interface{}{"success": true}, nil
+	case "head_bucket":
+		return map[string]interface{}{"success": true}, nil
+	case "put_object":
+		bucket[str("key")] = []byte(str("body"))
+		f.types[str("key")] = str("content_type")
+		return map[string]interface{}{"success": true}, nil
+	case "presign_url":
+		return map[string]interface{}{"data": "https://storage.test/" + str("bucket_name") + "/" + str("key")}, nil
+	case "presign_upload":
+		return map[string]interface{}{"data": map[string]interface{}{
+			"url":    "https://storage.test/" + str("bucket_name"),
+			"fields": map[string]interface{}{"key": str("key"), "Content-Type": str("content_type")},
+		}}, nil
+	case "create_multipart_upload":
+		uploadID := fmt.Sprintf("upload-%d", len(f.multiparts)+1)
+		f.multiparts[uploadID] = &fakeMultipart{bucket: str("bucket_name"), key: str("key"), contentType: str("content_type"), parts: make(map[int][]byte)}
+		return map[string]interface{}{"data": uploadID}, nil
+	case "presign_upload_part":
+		return map[string]interface{}{"data": fmt.Sprintf("https://storage.test/%s/%s?uploadId=%s&partNumber=%d", str("bucket_name"), str("key"), str("upload_id"), args["part_number"])}, nil
+	case "list_parts":
+		upload, ok := f.multiparts[str("upload_id")]
+		if !ok {
+			return nil, fmt.Errorf("NoSuchUpload: %s", str("upload_id"))
+		}
+		parts := []interface{}{}
+		for number, data := range upload.parts {
+			parts = append(parts, map[string]interface{}{"part_number": float64(number), "etag": fmt.Sprintf("\"etag-%d\"", number), "size": float64(len(data))})
+		}
+		return map[string]interface{}{"data": parts}, nil
+	case "complete_multipart_upload":
+		upload, ok := f.multiparts[str("upload_id")]
+		if !ok {
+			return nil, fmt.Errorf("NoSuchUpload: %s", str("upload_id"))
+		}
+		body := []byte{}
+		for _, part := range args["parts"].([]map[string]interface{}) {
+			body = append(body, upload.parts[part["part_number"].(int)]...)
+		}
+		bucket[upload.key] = body
+		f.types[upload.key] = upload.contentType
+		delete(f.multiparts, str("upload_id"))
+		return map[string]interface{}{"success": true}, nil
+	case "abort_multipart_upload":
+		delete(f.multiparts, str("upload_id"))
+		return map[string]interface{}{"success": true}, nil
+	case "head_object":
+		data, ok := bucket[str("key")]
+		if !ok {
+			return map[string]interface{}{"data": nil}, nil
+		}
+		return map[string]interface{}{"data": map[string]interface{}{"size": float64(len(data)), "content_type": f.types[str("key")]}}, nil
+	case "delete_object":
+		delete(bucket, str("key"))
+		return map[string]interface{}{"success": true}, nil
+	case "set_storage_class":
+		if _, ok := bucket[str("key")]; !ok {
+			return nil, fmt.Errorf("NoSuchKey: %s", str("key"))
+		}
+		f.classes[str("key")] = str("storage_class")
+		return map[string]interface{}{"success": true}, nil
+	case "describe_state_table":
+		return map[string]interface{}{"data": "ACTIVE"}, nil
+	case "get_state":
+		item, ok := f.states[str("table_name")][str("key")]
+		if !ok {
+			return map[string]interface{}{"data": nil}, nil
+		}
+		return map[string]interface{}{"data": map[string]interface{}{"value": item.value, "expires_at": float64(item.expiresAt), "version": item.version}}, nil
+	case "put_state":
+		table := f.states[str("table_name")]
+		if table == nil {
+			table = make(map[string]fakeStateItem)
+			f.states[str("table_name")] = table
+		}
+		current, exists := table[str("key")]
+		switch str("condition") {
+		case "absent":
+			if exists && (current.expiresAt == 0 || current.expiresAt > args["now"].(int64)) {
+				return map[string]interface{}{"success": false}, nil
+			}
+		case "version":
+			if !exists || current.version != str("expected") {
+				return map[string]interface{}{"success": false}, nil
+			}
+		}
+		table[str("key")] = fakeStateItem{value: str("value"), expiresAt: args["expires_at"].(int64), version: str("version")}
+		return map[string]interface{}{"success": true}, nil
+	case "delete_state":
+		for _, key := range args["keys"].([]string) {
+			delete(f.states[str("table_name")], key)
+		}
+		return map[string]interface{}{"success": true}, nil
+	case "create_invalidation":
+		f.invalidations[str("caller_reference")] = args["paths"].([]string)
+		return map[string]interface{}{"data": map[string]interface{}{"id": "I" + str("caller_reference"), "status": "InProgress"}}, nil
+	case "get_invalidation":
+		return map[string]interface{}{"data": map[string]interface{}{"id": str("invalidation_id"), "status": "Completed"}}, nil
+	}
+	return nil, fmt.Errorf("Unknown function: %s", function)
+}
+
+// ServeHTTP answers GETs of presigned download URLs, ranges included.
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	f.mutex.Lock()
+	data, ok := f.bucket(bucketName)[key]
+	f.mutex.Unlock()
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+}
+
+// putUpload stores an object as if a client had used its presigned POST.
+func (f *fakeStorage) putUpload(bucketName string, key string, contentType string, body []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bucket(bucketName)[key] = body
+	f.types[key] = contentType
+}
+
+// putPart stores one part of a multipart upload as if a client had used its
+// presigned PUT.
+func (f *fakeStorage) putPart(uploadID string, partNumber int, body []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.multiparts[uploadID].parts[partNumber] = body
+}
+
+// uploadIDs returns the multipart uploads still in progress.
+func (f *fakeStorage) uploadIDs() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ids := []string{}
+	for id := range f.multiparts {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (f *fakeStorage) server(bucketName string, key string) (map[string]interface{}, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, ok := f.bucket(bucketName)[key]
+	if !ok {
+		return nil, false
+	}
+	var server map[string]interface{}
+	json.Unmarshal(data, &server)
+	return server, true
+}
+
+// fakeMailer keeps the emails it is asked to send.
+type fakeMailer struct {
+	mutex sync.Mutex
+	sent  []sentMail
+}
+
+type sentMail struct {
+	to, subject, body string
+}
+
+func (f *fakeMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sent = append(f.sent, sentMail{to: to, subject: subject, body: body})
+	return nil
+}
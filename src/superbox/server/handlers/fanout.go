@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// lookupConcurrency bounds how many storage reads one request issues at
+	// once, so a large batch cannot take every Python worker.
+	lookupConcurrency = 8
+	lookupTimeout     = 10 * time.Second
+)
+
+var errServerNotFound = errors.New("not found")
+
+// fetchServers reads the named servers concurrently, each under
+// lookupTimeout. Servers that do not exist are left out of the result; any
+// other failure cancels the remaining reads and is returned.
+func fetchServers(ctx context.Context, names []string) (map[string]models.Server, error) {
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(lookupConcurrency)
+
+	var mutex sync.Mutex
+	servers := make(map[string]models.Server, len(names))
+	for _, name := range names {
+		group.Go(func() error {
+			lookupCtx, cancel := context.WithTimeout(groupCtx, lookupTimeout)
+			defer cancel()
+
+			server, err := fetchServer(lookupCtx, name)
+			if errors.Is(err, errServerNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mutex.Lock()
+			servers[name] = server
+			mutex.Unlock()
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testBucket         = "superbox-test"
+	testFirebaseKey    = "firebase-test-key"
+	testRazorpayKeyID  = "rzp_test_key"
+	testRazorpaySecret = "rzp_test_secret"
+	testStripeSecret   = "sk_test_secret"
+	testModerationKey  = "moderation-test-key"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// harness runs the full router against fakes for every upstream. Package
+// state such as orders and entitlements is shared between tests, so tests
+// should use their own server names and accounts.
+type harness struct {
+	t          testing.TB
+	server     *httptest.Server
+	client     *http.Client
+	identity   *fakeIdentity
+	google     *fakeOAuth
+	github     *fakeOAuth
+	gitlab     *fakeOAuth
+	microsoft  *fakeOAuth
+	repos      *fakeGitHub
+	razorpay   *fakeRazorpay
+	stripe     *fakeStripe
+	storage    *fakeStorage
+	moderation *fakeModeration
+}
+
+func newHarness(t testing.TB) *harness {
+	t.Helper()
+	h := &harness{
+		t:          t,
+		identity:   newFakeIdentity(testFirebaseKey),
+		google:     newFakeOAuth("google-client", "google-secret"),
+		github:     newFakeOAuth("github-client", "github-secret"),
+		gitlab:     newFakeOAuth("gitlab-client", "gitlab-secret"),
+		microsoft:  newFakeOAuth("microsoft-client", "microsoft-secret"),
+		repos:      newFakeGitHub(),
+		razorpay:   newFakeRazorpay(testRazorpayKeyID, testRazorpaySecret),
+		stripe:     newFakeStripe(testStripeSecret),
+		storage:    newFakeStorage(),
+		moderation: newFakeModeration(),
+	}
+
+	fakes := map[string]http.Handler{
+		"identitytoolkit.googleapis.com": h.identity,
+		"oauth2.googleapis.com":          h.google,
+		"github.com":                     h.github,
+		"gitlab.com":                     h.gitlab,
+		"gitlab.example.test":            h.gitlab,
+		"login.microsoftonline.com":      h.microsoft,
+		"api.github.com":                 h.repos,
+		"raw.githubusercontent.com":      h.repos,
+		"api.razorpay.com":               h.razorpay,
+		"api.stripe.com":                 h.stripe,
+		"moderation.test":                h.moderation,
+		"storage.test":                   h.storage,
+	}
+	transport := &routingTransport{t: t, hosts: make(map[string]*httptest.Server)}
+	for host, handler := range fakes {
+		fake := httptest.NewServer(handler)
+		t.Cleanup(fake.Close)
+		transport.hosts[host] = fake
+	}
+
+	previousTransport := outboundClient.Transport
+	previousBackend := s3Backend
+	outboundClient.Transport = transport
+	s3Backend = h.storage.call
+	resetSnapshots()
+	t.Cleanup(func() {
+		outboundClient.Transport = previousTransport
+		s3Backend = previousBackend
+		resetSnapshots()
+	})
+
+	Configure(testConfig(), store.NewMemory())
+
+	h.server = httptest.NewServer(NegotiateVersion(testRouter()))
+	t.Cleanup(h.server.Close)
+	h.client = &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return h
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Port:                  "8000",
+		S3BucketName:          testBucket,
+		ReportsBucketName:     testBucket,
+		BlobsBucketName:       testBucket,
+		FirebaseAPIKey:        testFirebaseKey,
+		GoogleClientID:        "google-client",
+		GoogleClientSecret:    "google-secret",
+		GithubClientID:        "github-client",
+		GithubClientSecret:    "github-secret",
+		GitlabClientID:        "gitlab-client",
+		GitlabClientSecret:    "gitlab-secret",
+		GitlabBaseURL:         "https://gitlab.com",
+		MicrosoftClientID:     "microsoft-client",
+		MicrosoftClientSecret: "microsoft-secret",
+		MicrosoftTenantID:     "common",
+		RazorpayKeyID:         testRazorpayKeyID,
+		RazorpayKeySecret:     testRazorpaySecret,
+		StripeSecretKey:       testStripeSecret,
+		StripePublishableKey:  "pk_test_key",
+		PriceReviewThreshold:  50,
+		LogLevel:              "error",
+		HTTPClientTimeout:     5 * time.Second,
+		MaxBodyBytes:          1 << 20,
+		MaxUploadBytes:        50 << 20,
+		CORSAllowedOrigins:    []string{"*"},
+		FeatureFlags:          map[string]bool{},
+		IdempotencyTTL:        time.Hour,
+	}
+}
+
+// testRouter mirrors the middleware and routes that serve registers in main.go.
+func testRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(RequestLogger(), Audit(), ErrorHandler(), Recovery(), HSTS(), BodyLimit(), Compression())
+	router.Use(Deadline(), CORS(), Tenancy(), Authorize(), Idempotency())
+
+	api := router.Group("/api/v1", APIVersion(1))
+	RegisterAuth(api)
+	RegisterServers(api)
+	RegisterPayment(api)
+	RegisterAdmin(api)
+	RegisterPublisher(api)
+	RegisterBlobs(api)
+	RegisterMe(api)
+	RegisterTenant(api)
+	RegisterWebhooks(api)
+	RegisterAdvisories(api)
+	RegisterOperations(api)
+	RegisterCompatibility(api)
+
+	v2 := router.Group("/api/v2", APIVersion(2))
+	RegisterV2(v2)
+	RegisterCompatibility(v2)
+	RegisterHealth(router)
+	return router
+}
+
+type response struct {
+	Status int
+	Header http.Header
+	Body   map[string]interface{}
+	Raw    []byte
+}
+
+// do sends a request to the server under test. A non-empty token is sent as
+// a bearer token; body is encoded as JSON unless it is already url.Values.
+func (h *harness) do(method string, path string, token string, body interface{}) response {
+	h.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch value := body.(type) {
+	case nil:
+	case interface{ Encode() string }:
+		reader = strings.NewReader(value.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			h.t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		h.t.Fatalf("build request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	result := response{Status: resp.StatusCode, Header: resp.Header}
+	result.Raw, _ = io.ReadAll(resp.Body)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		json.Unmarshal(result.Raw, &result.Body)
+	}
+	return result
+}
+
+// expect fails the test unless the response has the given status.
+func (r response) expect(t testing.TB, status int) response {
+	t.Helper()
+	if r.Status != status {
+		t.Fatalf("status = %d, want %d; body: %s", r.Status, status, r.Raw)
+	}
+	return r
+}
+
+// field walks nested objects in the response body, e.g. field("order", "id").
+func (r response) field(path ...string) interface{} {
+	var value interface{} = r.Body
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func (r response) str(path ...string) string {
+	value, _ := r.field(path...).(string)
+	return value
+}
+
+// loadFixture reads testdata/<name>.json and applies overrides to the top
+// level keys.
+func loadFixture(t testing.TB, name string, overrides map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		t.Fatalf("read fixture %s: %v", name, err)
+	}
+	var fixture map[string]interface{}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("parse fixture %s: %v", name, err)
+	}
+	for key, value := range overrides {
+		fixture[key] = value
+	}
+	return fixture
+}
+
+// publish creates a server from the named fixture as the given user.
+func (h *harness) publish(token string, fixture string, overrides map[string]interface{}) map[string]interface{} {
+	h.t.Helper()
+	server := loadFixture(h.t, fixture, overrides)
+	h.do(http.MethodPost, "/api/v1/servers", token, server).expect(h.t, http.StatusCreated)
+	return server
+}
+
+// order returns a stored order, or nil.
+func (h *harness) order(orderID string) *models.Order {
+	h.t.Helper()
+	order, err := getOrderCopy(context.Background(), orderID)
+	if err != nil {
+		h.t.Fatalf("load order %s: %v", orderID, err)
+	}
+	return order
+}
+
+// blob returns a stored blob, or nil.
+func (h *harness) blob(blobID string) *models.Blob {
+	h.t.Helper()
+	blob, err := getBlobCopy(context.Background(), blobID)
+	if err != nil {
+		h.t.Fatalf("load blob %s: %v", blobID, err)
+	}
+	return blob
+}
+
+// notifications returns a user's notifications, newest first.
+func (h *harness) notifications(userID string) []models.Notification {
+	h.t.Helper()
+	list, _, err := userNotifications(context.Background(), userID, false)
+	if err != nil {
+		h.t.Fatalf("list notifications: %v", err)
+	}
+	return list
+}
+
+// jobs returns the queued jobs.
+func (h *harness) jobs() []models.Job {
+	h.t.Helper()
+	jobs, err := pendingJobs(context.Background())
+	if err != nil {
+		h.t.Fatalf("list jobs: %v", err)
+	}
+	return jobs
+}
+
+// resetSnapshots drops registry snapshots loaded from a previous test's
+// storage fake.
+func resetSnapshots() {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	snapshots = make(map[string]map[string]models.Server)
+	snapshotWrites = make(map[string]uint64)
+}
+
+// backdatePoll moves a device session's last poll the given number of
+// seconds into the past, as if the client had waited that long.
+func backdatePoll(deviceCode string, seconds int) {
+	updateSession(context.Background(), deviceCode, func(session *models.DeviceSession) {
+		session.LastTouched = session.LastTouched.Add(-time.Duration(seconds) * time.Second)
+	})
+}
@@ -1,52 +1,86 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	draining atomic.Bool
+
+	// drained is closed by SetDraining so long-held requests can return
+	// instead of holding up shutdown.
+	drained   = make(chan struct{})
+	drainOnce sync.Once
+)
+
+const probeTimeout = 3 * time.Second
+
+type dependencyStatus struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	CheckedAt string  `json:"checked_at,omitempty"`
+}
+
 func RegisterHealth(router *gin.Engine) {
 	router.GET("/", rootHandler)
 	router.GET("/health", healthHandler)
+	router.GET("/healthz", livenessHandler)
+	router.GET("/readyz", readinessHandler)
+	router.GET("/metrics", metricsHandler)
+	router.NoRoute(notFoundHandler)
 }
 
 func rootHandler(c *gin.Context) {
-	templatePath := filepath.Join("src", "superbox", "server", "templates", "index.html")
-	content, err := os.ReadFile(templatePath)
+	content, err := readTemplate("index.html")
 	if err != nil {
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"title": "SuperBox API Server",
-		})
+		c.String(http.StatusInternalServerError, "Template error")
 		return
 	}
 	c.Data(http.StatusOK, "text/html; charset=utf-8", content)
 }
 
+// notFoundHandler shows browsers a branded page for unknown paths outside
+// the API. Everything else gets the plain 404 gin would send.
+func notFoundHandler(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/api/") && strings.Contains(c.GetHeader("Accept"), "text/html") {
+		renderErrorPage(c, http.StatusNotFound, "There is no page at "+c.Request.URL.Path+".")
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+func SetDraining() {
+	draining.Store(true)
+	drainOnce.Do(func() { close(drained) })
+}
+
 func healthHandler(c *gin.Context) {
+	if draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "draining",
+			"version": "1.0.0",
+		})
+		return
+	}
+
 	cfgOk := true
 	s3Ok := false
 	registryOk := false
 
-	requiredVars := []string{
-		"SUPERBOX_API_URL",
-		"AWS_REGION",
-		"AWS_ACCESS_KEY_ID",
-		"AWS_SECRET_ACCESS_KEY",
-		"S3_BUCKET_NAME",
-		"FIREBASE_API_KEY",
-		"FIREBASE_PROJECT_ID",
-		"RAZORPAY_KEY_ID",
-		"RAZORPAY_KEY_SECRET",
-	}
-
-	for _, v := range requiredVars {
-		if os.Getenv(v) == "" {
-			cfgOk = false
-			break
-		}
+	if len(appConfig.Unset()) > 0 {
+		cfgOk = false
 	}
 
 	if cfgOk {
@@ -67,3 +101,216 @@ func healthHandler(c *gin.Context) {
 		"registry_ok":  registryOk,
 	})
 }
+
+func livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+func readinessHandler(c *gin.Context) {
+	if draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "draining",
+			"ready":  false,
+		})
+		return
+	}
+
+	probes := map[string]func(context.Context) error{
+		"s3":       probeS3,
+		"firebase": probeFirebase,
+		"razorpay": probeRazorpay,
+	}
+	if appConfig.RedisURL != "" {
+		probes["state_store"] = stateStore.Ping
+	}
+	if appConfig.SessionStoreURL != "" {
+		probes["session_store"] = sessionStore.Ping
+	}
+	if appConfig.RecordStoreURL != "" {
+		probes["record_store"] = recordStore.Ping
+	}
+
+	results := make([]dependencyStatus, 0, len(probes))
+	var resultsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, probe := range probes {
+		wg.Add(1)
+		go func(name string, probe func(context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe(ctx)
+			result := dependencyStatus{
+				Name:      name,
+				OK:        err == nil,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			resultsMutex.Lock()
+			results = append(results, result)
+			resultsMutex.Unlock()
+		}(name, probe)
+	}
+	wg.Wait()
+
+	if status := helperStatus.Load(); status != nil {
+		results = append(results, *status)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	ready := true
+	for _, result := range results {
+		if !result.OK {
+			ready = false
+		}
+	}
+
+	code, status := http.StatusOK, "ready"
+	if !ready {
+		code, status = http.StatusServiceUnavailable, "not_ready"
+	}
+	// Overdue jobs are reported but do not fail readiness: the replica can
+	// still serve traffic, and restarting it would not rerun the job.
+	c.JSON(code, gin.H{
+		"status":       status,
+		"ready":        ready,
+		"dependencies": results,
+		"jobs":         jobHeartbeats(c.Request.Context()),
+	})
+}
+
+func CheckDependencies(ctx context.Context) []string {
+	checks := []struct {
+		name  string
+		probe func(context.Context) error
+	}{
+		{"S3_BUCKET_NAME", probeS3},
+	}
+	if appConfig.ReportsBucketName != appConfig.S3BucketName {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"REPORTS_BUCKET_NAME", func(ctx context.Context) error {
+			return probeBucket(ctx, appConfig.ReportsBucketName)
+		}})
+	}
+	if appConfig.BlobsBucketName != appConfig.S3BucketName && appConfig.BlobsBucketName != appConfig.ReportsBucketName {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"BLOBS_BUCKET_NAME", func(ctx context.Context) error {
+			return probeBucket(ctx, appConfig.BlobsBucketName)
+		}})
+	}
+	for _, region := range appConfig.StorageRegions {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"STORAGE_REGIONS " + region.Name, func(ctx context.Context) error {
+			_, err := callShard(ctx, region, "head_bucket", map[string]interface{}{})
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}})
+	}
+	if appConfig.RedisURL != "" {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"REDIS_URL", stateStore.Ping})
+	}
+	if appConfig.SessionStoreURL != "" {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"SESSION_STORE_URL", sessionStore.Ping})
+	}
+	if appConfig.RecordStoreURL != "" {
+		checks = append(checks, struct {
+			name  string
+			probe func(context.Context) error
+		}{"RECORD_STORE_URL", recordStore.Ping})
+	}
+
+	problems := []string{}
+	for _, check := range checks {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		err := check.probe(probeCtx)
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is set but not reachable: %v", check.name, err))
+		}
+	}
+	return problems
+}
+
+func probeS3(ctx context.Context) error {
+	if appConfig.S3BucketName == "" {
+		return fmt.Errorf("S3_BUCKET_NAME is not set")
+	}
+	return probeBucket(ctx, appConfig.S3BucketName)
+}
+
+func probeBucket(ctx context.Context, bucket string) error {
+	_, err := callPythonS3Context(ctx, "head_bucket", map[string]interface{}{
+		"bucket_name": bucket,
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func probeFirebase(ctx context.Context) error {
+	req, err := newOutboundRequest(ctx, "POST", identityURL("accounts:lookup"), strings.NewReader(`{"idToken":""}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	_, err = parseFirebaseResponse(resp)
+	var fbErr *firebaseError
+	if errors.As(err, &fbErr) && !strings.Contains(strings.ToUpper(fbErr.Code), "API_KEY") && !strings.Contains(fbErr.Code, "API key") {
+		return nil
+	}
+	return fmt.Errorf("unexpected response: %v", err)
+}
+
+func probeRazorpay(ctx context.Context) error {
+	if tenantFrom(ctx).RazorpayKeyID == "" {
+		return fmt.Errorf("RAZORPAY_KEY_ID is not set")
+	}
+	req, err := newOutboundRequest(ctx, "GET", "https://api.razorpay.com/v1/payments?count=1", nil)
+	if err != nil {
+		return err
+	}
+	setRazorpayAuth(ctx, req)
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
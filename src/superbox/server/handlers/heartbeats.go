@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	watchdogInterval = time.Minute
+	// overdueAfter is how many intervals may pass without a success before a
+	// job is overdue, so one missed or failed run does not alert.
+	overdueAfter = 2
+)
+
+var processStarted = time.Now()
+
+// jobHeartbeat is a scheduled job's last success and whether it is overdue.
+type jobHeartbeat struct {
+	Name            string    `json:"name"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+	LastSuccessAt   time.Time `json:"last_success_at,omitzero"`
+	Status          string    `json:"status"`
+}
+
+type monitoredJob struct {
+	name     string
+	interval time.Duration
+}
+
+// monitoredJobs are the scheduled jobs and how often each should succeed.
+// `index rebuild` runs from cron outside the server, so it is only
+// monitored when INDEX_REBUILD_INTERVAL says how often to expect it.
+func monitoredJobs() []monitoredJob {
+	jobs := []monitoredJob{
+		{"blob-expiry", blobExpiryInterval},
+		{"payment-reconciliation", reconciliationInterval},
+		{"renewal-scan", renewalScanInterval},
+		{"artifact-lifecycle", artifactLifecycleInterval},
+	}
+	if appConfig != nil && appConfig.IndexRebuildInterval > 0 {
+		jobs = append(jobs, monitoredJob{"index-rebuild", appConfig.IndexRebuildInterval})
+	}
+	return jobs
+}
+
+// recordJobSuccess stores when a job last succeeded. It goes to the state
+// store, since the replica holding a job's lease changes from run to run.
+func recordJobSuccess(ctx context.Context, name string) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := stateStore.Set(ctx, "heartbeat:"+name, []byte(now), 0); err != nil {
+		slog.Warn("failed to record job success", "job", name, "error", err)
+	}
+}
+
+// jobHeartbeats reports each monitored job as "ok", "overdue", or "pending"
+// for a job that has not succeeded yet but whose first deadline, counted
+// from when this process started, has not passed.
+func jobHeartbeats(ctx context.Context) []jobHeartbeat {
+	now := time.Now()
+	result := []jobHeartbeat{}
+	for _, job := range monitoredJobs() {
+		heartbeat := jobHeartbeat{Name: job.name, IntervalSeconds: job.interval.Seconds(), Status: "ok"}
+		since := processStarted
+		if raw, err := stateStore.Get(ctx, "heartbeat:"+job.name); err == nil {
+			if unix, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+				since = time.Unix(unix, 0)
+				heartbeat.LastSuccessAt = models.Timestamp(since)
+			}
+		}
+		if now.Sub(since) > overdueAfter*job.interval {
+			heartbeat.Status = "overdue"
+		} else if heartbeat.LastSuccessAt.IsZero() {
+			heartbeat.Status = "pending"
+		}
+		result = append(result, heartbeat)
+	}
+	return result
+}
+
+// checkOverdueJobs alerts through the error reporter when a job becomes
+// overdue, and logs when it recovers.
+func checkOverdueJobs(ctx context.Context) {
+	for _, heartbeat := range jobHeartbeats(ctx) {
+		alerted, err := swapOverdueAlert(ctx, heartbeat.Name, heartbeat.Status == "overdue")
+		if err != nil {
+			slog.Warn("failed to record overdue alert", "job", heartbeat.Name, "error", err)
+			continue
+		}
+
+		switch {
+		case heartbeat.Status == "overdue" && !alerted:
+			message := fmt.Sprintf("scheduled job %s has not succeeded within %s", heartbeat.Name, time.Duration(overdueAfter*heartbeat.IntervalSeconds)*time.Second)
+			slog.Error("scheduled job overdue", "job", heartbeat.Name, "last_success_at", heartbeat.LastSuccessAt)
+			reporterMutex.RLock()
+			reporter := errorReporter
+			reporterMutex.RUnlock()
+			reporter.Report(ctx, ErrorReport{Message: message, Timestamp: models.Now()})
+		case heartbeat.Status != "overdue" && alerted:
+			slog.Info("scheduled job recovered", "job", heartbeat.Name, "last_success_at", heartbeat.LastSuccessAt)
+		}
+	}
+}
+
+// swapOverdueAlert records whether a job is alerted on and reports whether
+// it already was, so an overdue job alerts once until it succeeds again
+// whichever replica holds the watchdog lease.
+func swapOverdueAlert(ctx context.Context, name string, overdue bool) (bool, error) {
+	key := overdueAlertKey(name)
+	if overdue {
+		created, err := stateStore.SetNX(ctx, key, []byte("1"), 0)
+		return !created, err
+	}
+	_, err := stateStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, stateStore.Delete(ctx, key)
+}
+
+func overdueAlertKey(name string) string {
+	return "watchdog:alerted:" + name
+}
+
+// metricsHandler serves the job heartbeats and device login counters in the
+// Prometheus text format.
+func metricsHandler(c *gin.Context) {
+	heartbeats := jobHeartbeats(c.Request.Context())
+	var out strings.Builder
+	out.WriteString("# HELP superbox_job_last_success_timestamp_seconds When the scheduled job last succeeded.\n")
+	out.WriteString("# TYPE superbox_job_last_success_timestamp_seconds gauge\n")
+	for _, heartbeat := range heartbeats {
+		var lastSuccess int64
+		if !heartbeat.LastSuccessAt.IsZero() {
+			lastSuccess = heartbeat.LastSuccessAt.Unix()
+		}
+		fmt.Fprintf(&out, "superbox_job_last_success_timestamp_seconds{job=%q} %d\n", heartbeat.Name, lastSuccess)
+	}
+	out.WriteString("# HELP superbox_job_interval_seconds How often the scheduled job is expected to succeed.\n")
+	out.WriteString("# TYPE superbox_job_interval_seconds gauge\n")
+	for _, heartbeat := range heartbeats {
+		fmt.Fprintf(&out, "superbox_job_interval_seconds{job=%q} %g\n", heartbeat.Name, heartbeat.IntervalSeconds)
+	}
+	out.WriteString("# HELP superbox_job_overdue Whether the scheduled job has missed its deadline.\n")
+	out.WriteString("# TYPE superbox_job_overdue gauge\n")
+	for _, heartbeat := range heartbeats {
+		overdue := 0
+		if heartbeat.Status == "overdue" {
+			overdue = 1
+		}
+		fmt.Fprintf(&out, "superbox_job_overdue{job=%q} %d\n", heartbeat.Name, overdue)
+	}
+	writeDeviceLoginMetrics(&out)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(out.String()))
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pythonBinary        = "python"
+	helperProbeInterval = time.Minute
+)
+
+var (
+	pythonHelperScript = filepath.Join("src", "superbox", "server", "helpers", "s3_helper.py")
+
+	// helperStatus is the latest result of probing the Python S3 helper. It
+	// is nil until the first probe, which StartRefreshers runs at startup.
+	helperStatus atomic.Pointer[dependencyStatus]
+)
+
+// probePythonHelper checks each step the S3 helper needs in turn, so a
+// broken install names the missing piece instead of failing the first
+// registry call with a 500.
+func probePythonHelper(ctx context.Context) error {
+	if _, err := exec.LookPath(pythonBinary); err != nil {
+		return fmt.Errorf("%s is not on PATH", pythonBinary)
+	}
+	if _, err := os.Stat(pythonHelperScript); err != nil {
+		return fmt.Errorf("helper script %s is missing", pythonHelperScript)
+	}
+	result, err := s3Backend(ctx, "ping", map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+	if result["data"] != "pong" {
+		return fmt.Errorf("unexpected ping response %v", result)
+	}
+	return nil
+}
+
+// checkPythonHelper probes the helper and records the result for /readyz,
+// logging only when the outcome changes.
+func checkPythonHelper(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := probePythonHelper(ctx)
+	status := &dependencyStatus{
+		Name:      "python_helper",
+		OK:        err == nil,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start.UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	previous := helperStatus.Swap(status)
+	switch {
+	case err != nil && (previous == nil || previous.OK):
+		slog.Error("python s3 helper is unavailable", "error", err)
+	case err == nil && previous != nil && !previous.OK:
+		slog.Info("python s3 helper recovered")
+	}
+}
+
+// startHelperProbe checks the helper once before returning and then every
+// helperProbeInterval. Every replica runs its own helper, so unlike periodic
+// jobs this takes no lease.
+func startHelperProbe(ctx context.Context) {
+	checkPythonHelper(ctx)
+
+	refreshers.run(func() {
+		ticker := time.NewTicker(helperProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkPythonHelper(ctx)
+			}
+		}
+	})
+}
+
+func lastLine(output []byte) string {
+	lines := bytes.Split(bytes.TrimSpace(output), []byte("\n"))
+	return string(lines[len(lines)-1])
+}
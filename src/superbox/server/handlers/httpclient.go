@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 30 * time.Second
+	retryBaseDelay          = 200 * time.Millisecond
+)
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type upstreamStats struct {
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Requests     int64   `json:"requests"`
+	Failures     int64   `json:"failures"`
+	Retries      int64   `json:"retries"`
+	Rejected     int64   `json:"rejected"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+}
+
+type upstream struct {
+	name                string
+	mutex               sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	requests            int64
+	failures            int64
+	retries             int64
+	rejected            int64
+	totalLatency        time.Duration
+}
+
+var (
+	outboundClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	outboundMaxRetries = 2
+	upstreams          = map[string]*upstream{
+		"firebase":   {name: "firebase", state: "closed"},
+		"google":     {name: "google", state: "closed"},
+		"github":     {name: "github", state: "closed"},
+		"gitlab":     {name: "gitlab", state: "closed"},
+		"microsoft":  {name: "microsoft", state: "closed"},
+		"razorpay":   {name: "razorpay", state: "closed"},
+		"stripe":     {name: "stripe", state: "closed"},
+		"moderation": {name: "moderation", state: "closed"},
+	}
+)
+
+func (u *upstream) allow() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.state == "open" {
+		if time.Since(u.openedAt) < breakerCooldown {
+			u.rejected++
+			return false
+		}
+		u.state = "half_open"
+	}
+	return true
+}
+
+func (u *upstream) record(latency time.Duration, failed bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.requests++
+	u.totalLatency += latency
+	if !failed {
+		u.consecutiveFailures = 0
+		u.state = "closed"
+		return
+	}
+
+	u.failures++
+	u.consecutiveFailures++
+	if u.state == "half_open" || u.consecutiveFailures >= breakerFailureThreshold {
+		u.state = "open"
+		u.openedAt = time.Now()
+	}
+}
+
+func (u *upstream) stats() upstreamStats {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	stats := upstreamStats{
+		Name:     u.name,
+		State:    u.state,
+		Requests: u.requests,
+		Failures: u.failures,
+		Retries:  u.retries,
+		Rejected: u.rejected,
+	}
+	if u.requests > 0 {
+		stats.AvgLatencyMS = float64(u.totalLatency.Milliseconds()) / float64(u.requests)
+	}
+	return stats
+}
+
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func doUpstream(name string, req *http.Request) (*http.Response, error) {
+	target := upstreams[name]
+	if target == nil {
+		return nil, fmt.Errorf("unknown upstream %q", name)
+	}
+
+	attempts := 1
+	if retryable(req) && (req.Body == nil || req.GetBody != nil) {
+		attempts += outboundMaxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			target.mutex.Lock()
+			target.retries++
+			target.mutex.Unlock()
+
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(retryBaseDelay << (attempt - 1)):
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
+
+		if !target.allow() {
+			return nil, fmt.Errorf("%s: %w", name, errCircuitOpen)
+		}
+
+		start := time.Now()
+		resp, err := outboundClient.Do(req)
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		target.record(time.Since(start), failed)
+
+		if !failed {
+			return resp, nil
+		}
+		if attempt == attempts-1 {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func listUpstreams(c *gin.Context) {
+	result := []upstreamStats{}
+	for _, target := range upstreams {
+		result = append(result, target.stats())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"upstreams": result,
+	})
+}
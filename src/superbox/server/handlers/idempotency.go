@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	idempotencyLockTTL   = 5 * time.Minute
+	maxReplayBodyBytes   = 1 << 20
+)
+
+type idempotentResponse struct {
+	Fingerprint string    `json:"fingerprint"`
+	Done        bool      `json:"done"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type captureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(data string) (int, error) {
+	w.capture([]byte(data))
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w *captureWriter) capture(data []byte) {
+	if w.overflow || w.body.Len()+len(data) > maxReplayBodyBytes {
+		w.overflow = true
+		return
+	}
+	w.body.Write(data)
+}
+
+// idempotencyScope keys records by tenant and caller credentials as well as
+// the client key, so two users cannot collide on (or read) each other's
+// responses.
+func idempotencyScope(c *gin.Context, key string) string {
+	hash := sha256.New()
+	for _, part := range []string{c.GetString("tenant_id"), c.GetHeader("Authorization"), c.GetHeader("X-ID-Token"), c.GetHeader(requestKeyHeader), c.Request.Method, c.FullPath(), key} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return "idempotency:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotency stores the first response to a mutating request that carries an
+// Idempotency-Key and replays it for retries with the same key and body within
+// appConfig.IdempotencyTTL. Errors the handler reports through respondError,
+// 5xx responses, and bodies too large to replay release the key instead, so a
+// corrected retry with the same key runs normally; any other response, a
+// redirect included, is stored.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" || !mutatingMethod(c.Request.Method) || c.FullPath() == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			respondError(c, newAPIError(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters").withCode("invalid_idempotency_key"))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(c, payloadTooLarge(tooLarge.Limit))
+				return
+			}
+			respondError(c, invalidRequest(err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := c.Request.Context()
+		scope := idempotencyScope(c, key)
+		pending, _ := json.Marshal(idempotentResponse{Fingerprint: fingerprint, CreatedAt: models.Now()})
+		acquired, err := stateStore.SetNX(ctx, scope, pending, idempotencyLockTTL)
+		if err != nil {
+			respondError(c, internalError("Error checking Idempotency-Key", err))
+			return
+		}
+		if !acquired {
+			replayIdempotent(c, scope, fingerprint)
+			return
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		status := writer.Status()
+		if !writer.Written() || status >= http.StatusInternalServerError || len(c.Errors) > 0 || writer.overflow {
+			if err := stateStore.Delete(ctx, scope); err != nil {
+				slog.Warn("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		record, _ := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      status,
+			ContentType: writer.Header().Get("Content-Type"),
+			Location:    writer.Header().Get("Location"),
+			Body:        writer.body.Bytes(),
+			CreatedAt:   models.Now(),
+		})
+		if err := stateStore.Set(ctx, scope, record, appConfig.IdempotencyTTL); err != nil {
+			slog.Warn("failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+func replayIdempotent(c *gin.Context, scope string, fingerprint string) {
+	data, err := stateStore.Get(c.Request.Context(), scope)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, newAPIError(http.StatusConflict, "A request with this Idempotency-Key just finished; retry it").withCode("idempotency_conflict"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error checking Idempotency-Key", err))
+		return
+	}
+
+	var record idempotentResponse
+	if err := json.Unmarshal(data, &record); err != nil {
+		respondError(c, internalError("Error checking Idempotency-Key", err))
+		return
+	}
+	if record.Fingerprint != fingerprint {
+		respondError(c, newAPIError(http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body").withCode("idempotency_key_reused"))
+		return
+	}
+	if !record.Done {
+		respondError(c, newAPIError(http.StatusConflict, "A request with this Idempotency-Key is still in progress").withCode("idempotency_conflict"))
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	if record.Location != "" {
+		c.Header("Location", record.Location)
+	}
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"sort"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// installedServers holds what each user's CLI last reported as installed,
+// one record per tenant and user, listed per tenant.
+var installedServers = recordSet[installedSet]{
+	kind:  "installed_servers",
+	group: func(set *installedSet) string { return set.TenantID },
+}
+
+// installedSet is one user's installed servers in one tenant, by name.
+type installedSet struct {
+	TenantID string                            `json:"tenant_id"`
+	UserID   string                            `json:"user_id"`
+	Servers  map[string]models.InstalledServer `json:"servers"`
+}
+
+func installedSetID(tenantID string, userID string) string {
+	return tenantID + "/" + userID
+}
+
+// userInstalledServers returns a user's installed servers in name order.
+func userInstalledServers(ctx context.Context, tenantID string, userID string) ([]models.InstalledServer, error) {
+	result := []models.InstalledServer{}
+	set, err := installedServers.get(ctx, installedSetID(tenantID, userID))
+	if errors.Is(err, store.ErrNotFound) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, installed := range set.Servers {
+		result = append(result, installed)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func listInstalledServers(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	installed, err := userInstalledServers(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Error loading installed servers", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"servers": installed,
+	})
+}
+
+// updateInstalledServers replaces the user's installed set. A server kept at
+// the same version keeps its install time and what was already notified, so
+// re-syncing does not repeat notifications.
+func updateInstalledServers(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateInstalledServersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	tenantID := requestTenant(c).ID
+	now := models.Now()
+
+	initial := &installedSet{TenantID: tenantID, UserID: userID, Servers: map[string]models.InstalledServer{}}
+	set, err := installedServers.upsert(c.Request.Context(), installedSetID(tenantID, userID), initial, func(set *installedSet) error {
+		current := make(map[string]models.InstalledServer, len(req.Servers))
+		for _, server := range req.Servers {
+			installed := models.InstalledServer{Name: server.Name, Version: server.Version, InstalledAt: now}
+			if before, exists := set.Servers[server.Name]; exists && before.Version == server.Version {
+				installed.InstalledAt = before.InstalledAt
+				installed.Notified = before.Notified
+			}
+			current[server.Name] = installed
+		}
+		set.Servers = current
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Error saving installed servers", err))
+		return
+	}
+
+	result := []models.InstalledServer{}
+	for _, installed := range set.Servers {
+		result = append(result, installed)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"servers": result,
+	})
+}
+
+// listServerUpdates compares the user's installed servers with the registry
+// and lists those with a newer version, a failing security report, or an
+// advisory affecting the installed version. Servers installed under a name
+// they were since renamed from are always listed, with moved_to set, so the
+// CLI can update its installed set; those since removed are skipped.
+func listServerUpdates(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	servers, err := snapshotServers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error fetching servers", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := requestTenant(c).ID
+	filed, err := tenantAdvisories(ctx, tenantID, "")
+	if err != nil {
+		respondError(c, internalError("Error loading advisories", err))
+		return
+	}
+	advisories := make(map[string][]models.Advisory)
+	for _, advisory := range filed {
+		advisories[advisory.ServerName] = append(advisories[advisory.ServerName], advisory)
+	}
+	installedList, err := userInstalledServers(ctx, tenantID, userID)
+	if err != nil {
+		respondError(c, internalError("Error loading installed servers", err))
+		return
+	}
+
+	updates := []models.ServerUpdate{}
+	for _, installed := range installedList {
+		server, exists := servers[installed.Name]
+		if !exists {
+			target, renamed, err := resolveServerRedirect(c.Request.Context(), installed.Name)
+			if err != nil {
+				respondError(c, internalError("Error resolving renamed servers", err))
+				return
+			}
+			if server, exists = servers[target]; !renamed || !exists {
+				continue
+			}
+		}
+		update := serverUpdate(installed, server, advisories[server.Name])
+		if update.MovedTo != "" || update.UpdateAvailable || update.SecurityAlert || len(update.Advisories) > 0 {
+			updates = append(updates, update)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"total":   len(updates),
+		"updates": updates,
+	})
+}
+
+func serverUpdate(installed models.InstalledServer, server models.Server, advisories []models.Advisory) models.ServerUpdate {
+	update := models.ServerUpdate{
+		Name:             installed.Name,
+		InstalledVersion: installed.Version,
+		LatestVersion:    server.Version,
+		UpdateAvailable:  compareSemver(server.Version, installed.Version) > 0,
+	}
+	if installed.Name != server.Name {
+		update.MovedTo = server.Name
+	}
+	if server.SecurityReport != nil && !scanPassed(server.SecurityReport) {
+		update.SecurityAlert = true
+		update.SecuritySummary, _ = server.SecurityReport["summary"].(map[string]interface{})
+	}
+	for _, advisory := range advisories {
+		if advisoryAffects(advisory, installed.Version) {
+			update.Advisories = append(update.Advisories, withAffectedVersions(advisory, server))
+		}
+	}
+	for _, version := range server.Versions {
+		if version.Version == server.Version {
+			update.PublishedAt = version.PublishedAt
+		}
+	}
+	return update
+}
+
+// notifyInstalledServerUsers tells users who have a server installed about a
+// newer version, once per version, and about a failing security report
+// every time one is published. Installs under a previous name count.
+func notifyInstalledServerUsers(ctx context.Context, tenantID string, server models.Server, securityAlert bool) error {
+	type recipient struct {
+		userID  string
+		version string
+		newer   bool
+	}
+	var recipients []recipient
+
+	sets, err := installedServers.listGroup(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	for _, listed := range sets {
+		if _, exists := installedEntry(&listed, server); !exists {
+			continue
+		}
+		// Marking the version notified in the same write that decides to
+		// notify keeps two events for one release from both notifying.
+		var to *recipient
+		_, err := installedServers.update(ctx, installedSetID(tenantID, listed.UserID), func(set *installedSet) error {
+			to = nil
+			name, exists := installedEntry(set, server)
+			if !exists {
+				return nil
+			}
+			entry := set.Servers[name]
+			newer := compareSemver(server.Version, entry.Version) > 0 &&
+				(entry.Notified == "" || compareSemver(server.Version, entry.Notified) > 0)
+			if newer {
+				entry.Notified = server.Version
+				set.Servers[name] = entry
+			}
+			if newer || securityAlert {
+				to = &recipient{userID: set.UserID, version: entry.Version, newer: newer}
+			}
+			return nil
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if to != nil {
+			recipients = append(recipients, *to)
+		}
+	}
+
+	for _, to := range recipients {
+		data := map[string]interface{}{
+			"server_name":       server.Name,
+			"installed_version": to.version,
+			"latest_version":    server.Version,
+		}
+		if to.newer {
+			notify(ctx, to.userID, "server.update_available", server.Name+" "+server.Version+" is available; you have "+to.version+" installed.", data)
+		}
+		if securityAlert {
+			data = maps.Clone(data)
+			data["summary"] = server.SecurityReport["summary"]
+			notify(ctx, to.userID, "server.security_alert", "A security scan of "+server.Name+", which you have installed, found issues.", data)
+		}
+	}
+	return nil
+}
+
+// installedEntry finds the server in a user's installed set, under its name
+// or a name it was renamed from, and returns the name it is installed as.
+func installedEntry(set *installedSet, server models.Server) (string, bool) {
+	if _, exists := set.Servers[server.Name]; exists {
+		return server.Name, true
+	}
+	for _, previous := range server.PreviousNames {
+		if _, exists := set.Servers[previous]; exists {
+			return previous, true
+		}
+	}
+	return "", false
+}
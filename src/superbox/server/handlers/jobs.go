@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+)
+
+const (
+	jobPollInterval = 30 * time.Second
+	maxJobAttempts  = 5
+	// jobClaimTimeout is how long a claimed job is left to its runner before
+	// it is due again, so a job whose replica died mid-run is not lost.
+	jobClaimTimeout = 10 * time.Minute
+)
+
+var (
+	// jobQueue holds the queued jobs in the record store, so they survive a
+	// restart and any replica can run them.
+	jobQueue    = recordSet[models.Job]{kind: "job"}
+	jobHandlers = make(map[string]func(*models.Job) error)
+	replicaID   = randomID("replica")
+
+	jobWorkers workerGroup
+	schedulers workerGroup
+	refreshers workerGroup
+)
+
+// workerGroup is a set of background loops that start and stop together.
+type workerGroup struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (g *workerGroup) start() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	return ctx
+}
+
+func (g *workerGroup) run(loop func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		loop()
+	}()
+}
+
+// stop cancels the group's loops and waits for them to return, or for ctx to
+// end.
+func (g *workerGroup) stop(ctx context.Context) error {
+	if g.cancel != nil {
+		g.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartJobs starts the worker that runs queued jobs, which include renewals,
+// webhook deliveries, notification emails, CDN invalidations, and payment
+// link expiries.
+func StartJobs() {
+	jobHandlers["renewal"] = runRenewalJob
+	jobHandlers["dunning_expire"] = runDunningExpireJob
+	jobHandlers["webhook_delivery"] = runWebhookDeliveryJob
+	jobHandlers["revenue_report"] = runRevenueReportJob
+	jobHandlers["notification_email"] = runNotificationEmailJob
+	jobHandlers["operation"] = runOperationJob
+	jobHandlers["cdn_invalidation"] = runCDNInvalidationJob
+	jobHandlers["payment_link_expire"] = runPaymentLinkExpireJob
+
+	ctx := jobWorkers.start()
+	runPeriodically(ctx, &jobWorkers, "job-queue", jobPollInterval, func() error {
+		return processDueJobs(ctx)
+	})
+}
+
+func StopJobs(ctx context.Context) error {
+	return jobWorkers.stop(ctx)
+}
+
+// StartSchedulers starts the periodic scans that reconcile payments, queue
+// renewals, expire blobs, and apply artifact retention, and the watchdog that alerts when one of them
+// stops succeeding. Each run takes a cluster-wide lease.
+func StartSchedulers() {
+	ctx := schedulers.start()
+	runPeriodically(ctx, &schedulers, "payment-reconciliation", reconciliationInterval, func() error {
+		return runReconciliation(ctx)
+	})
+	runPeriodically(ctx, &schedulers, "renewal-scan", renewalScanInterval, func() error {
+		return scheduleRenewals(ctx)
+	})
+	runPeriodically(ctx, &schedulers, "blob-expiry", blobExpiryInterval, func() error {
+		return expireBlobs(ctx)
+	})
+	runPeriodically(ctx, &schedulers, "artifact-lifecycle", artifactLifecycleInterval, func() error {
+		return runArtifactLifecycle(ctx)
+	})
+	runPeriodically(ctx, &schedulers, "job-watchdog", watchdogInterval, func() error {
+		checkOverdueJobs(ctx)
+		return nil
+	})
+}
+
+func StopSchedulers(ctx context.Context) error {
+	return schedulers.stop(ctx)
+}
+
+// StartRefreshers starts the per-replica loops that keep the registry
+// snapshot and the python helper status current.
+func StartRefreshers() {
+	ctx := refreshers.start()
+	startHelperProbe(ctx)
+	startSnapshotRefresh(ctx)
+}
+
+func StopRefreshers(ctx context.Context) error {
+	return refreshers.stop(ctx)
+}
+
+func runPeriodically(ctx context.Context, group *workerGroup, name string, interval time.Duration, job func() error) {
+	group.run(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !acquireJobLease(ctx, name, interval) {
+					continue
+				}
+				if err := job(); err != nil {
+					slog.Error("periodic job failed", "job", name, "error", err)
+					continue
+				}
+				recordJobSuccess(ctx, name)
+			}
+		}
+	})
+}
+
+func acquireJobLease(ctx context.Context, name string, interval time.Duration) bool {
+	acquired, err := stateStore.SetNX(ctx, "lease:"+name, []byte(replicaID), interval-time.Second)
+	if err != nil {
+		slog.Error("failed to acquire job lease", "job", name, "error", err)
+		return false
+	}
+	return acquired
+}
+
+func enqueueJob(ctx context.Context, kind string, payload map[string]string, runAt time.Time) (*models.Job, error) {
+	job := &models.Job{
+		ID:        randomID("job"),
+		Kind:      kind,
+		Payload:   payload,
+		RunAt:     models.Timestamp(runAt),
+		CreatedAt: models.Now(),
+	}
+	if err := jobQueue.put(ctx, job.ID, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// errJobNotDue is returned from a claim when another runner took the job.
+var errJobNotDue = errors.New("job is not due")
+
+func processDueJobs(ctx context.Context) error {
+	queued, err := jobQueue.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := models.Now()
+	due := []models.Job{}
+	for _, job := range queued {
+		if !job.RunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+
+	for _, candidate := range due {
+		// Claiming a job pushes it past the claim timeout, so no other run
+		// takes it while this one does.
+		job, err := jobQueue.update(ctx, candidate.ID, func(job *models.Job) error {
+			if job.RunAt.After(models.Now()) {
+				return errJobNotDue
+			}
+			job.RunAt = models.Timestamp(time.Now().Add(jobClaimTimeout))
+			return nil
+		})
+		if errors.Is(err, errJobNotDue) || errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		handler, exists := jobHandlers[job.Kind]
+		if !exists {
+			slog.Warn("no handler for job", "job_id", job.ID, "kind", job.Kind)
+			if err := jobQueue.delete(ctx, job.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		runErr := handler(job)
+		if runErr == nil {
+			if err := jobQueue.delete(ctx, job.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		job.Attempts++
+		job.LastError = runErr.Error()
+		if job.Attempts >= maxJobAttempts {
+			slog.Error("job dropped", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", runErr)
+			if err := jobQueue.delete(ctx, job.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		job.RunAt = models.Timestamp(time.Now().Add(jobRetryDelay(job.Attempts)))
+		if err := jobQueue.put(ctx, job.ID, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobRetryDelay backs off exponentially: 1, 2, 4, 8... minutes after each
+// failed attempt.
+func jobRetryDelay(attempts int) time.Duration {
+	return time.Minute << (attempts - 1)
+}
+
+func pendingJobs(ctx context.Context) ([]models.Job, error) {
+	result, err := jobQueue.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RunAt.Before(result[j].RunAt)
+	})
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadRoutes carry whole server records, tools and security reports
+// included, and may send up to MAX_UPLOAD_BYTES. They are keyed like
+// routePolicies, so a route's v1 and v2 forms share an entry.
+var uploadRoutes = map[string]bool{
+	"POST /servers":               true,
+	"POST /servers/import":        true,
+	"POST /servers/lint":          true,
+	"PUT /servers/:server_name":   true,
+	"PATCH /servers/:server_name": true,
+}
+
+// bodyLimitFor is MAX_UPLOAD_BYTES for uploadRoutes and MAX_BODY_BYTES for
+// every other request, unmatched routes included.
+func bodyLimitFor(c *gin.Context) int64 {
+	if uploadRoutes[c.Request.Method+" "+policyRoute(c.FullPath())] {
+		return appConfig.MaxUploadBytes
+	}
+	return appConfig.MaxBodyBytes
+}
+
+func BodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		limit := bodyLimitFor(c)
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			respondError(c, payloadTooLarge(limit))
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// serverNamePattern is the name policy: names that match it stay readable in
+// URLs and on the command line. Other names still publish, with a warning.
+var serverNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// knownLicenses are the SPDX identifiers lint accepts without a warning.
+var knownLicenses = []string{
+	"0BSD", "AGPL-3.0-only", "AGPL-3.0-or-later", "Apache-2.0", "BSD-2-Clause",
+	"BSD-3-Clause", "BSL-1.0", "CC0-1.0", "EPL-2.0", "GPL-2.0-only",
+	"GPL-2.0-or-later", "GPL-3.0-only", "GPL-3.0-or-later", "ISC",
+	"LGPL-2.1-only", "LGPL-2.1-or-later", "LGPL-3.0-only", "LGPL-3.0-or-later",
+	"MIT", "MPL-2.0", "Unlicense", "Proprietary",
+}
+
+type lintReport struct {
+	errors   []models.LintIssue
+	warnings []models.LintIssue
+}
+
+func (r *lintReport) fail(field, rule, message string) {
+	r.errors = append(r.errors, models.LintIssue{Field: field, Rule: rule, Message: message})
+}
+
+func (r *lintReport) warn(field, rule, message string) {
+	r.warnings = append(r.warnings, models.LintIssue{Field: field, Rule: rule, Message: message})
+}
+
+// lintServer runs the checks POST /servers would, plus advisory ones, on a
+// candidate payload. Nothing is written and no publish quota is used; only
+// a body that is not JSON at all is rejected.
+func lintServer(c *gin.Context) {
+	var req models.CreateServerRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	report := &lintReport{errors: []models.LintIssue{}, warnings: []models.LintIssue{}}
+	lintSchema(report, req)
+	if err := validatePricing(req.Pricing); err != nil {
+		report.fail("pricing", "pricing", err.Error())
+	}
+	if err := lintName(c, report, req.Name); err != nil {
+		respondError(c, internalError("Error checking the server name", err))
+		return
+	}
+	lintLicense(report, req.License)
+	lintTools(report, req.Tools)
+
+	c.JSON(http.StatusOK, models.LintResponse{
+		Status:   "success",
+		Valid:    len(report.errors) == 0,
+		Errors:   report.errors,
+		Warnings: report.warnings,
+	})
+}
+
+// lintSchema applies the binding rules on CreateServerRequest, reporting each
+// field the way a 422 from POST /servers would.
+func lintSchema(report *lintReport, req models.CreateServerRequest) {
+	err := binding.Validator.ValidateStruct(req)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return
+	}
+	for _, fieldErr := range validationErrors {
+		report.fail(fieldPath(fieldErr), fieldErr.Tag(), validationMessage(fieldErr))
+	}
+}
+
+func lintName(c *gin.Context, report *lintReport, name string) error {
+	if name == "" {
+		return nil
+	}
+	if !serverNamePattern.MatchString(name) {
+		report.fail("name", "name_policy", "must be lowercase letters, digits, '.', '_' or '-', starting and ending with a letter or digit; put other text in display_name")
+	}
+
+	_, err := fetchServer(c.Request.Context(), name)
+	switch {
+	case err == nil:
+		report.fail("name", "name_taken", "server '"+name+"' already exists; update it instead of publishing")
+		return nil
+	case !errors.Is(err, errServerNotFound):
+		return err
+	}
+	target, reserved, err := reservedSlug(c.Request.Context(), name)
+	if reserved {
+		report.fail("name", "name_reserved", "'"+name+"' is reserved: server '"+target+"' was renamed from it")
+	}
+	return err
+}
+
+func lintLicense(report *lintReport, license string) {
+	switch {
+	case license == "":
+		report.warn("license", "license", "no license is set, so users cannot tell how they may use the server")
+	case !slices.Contains(knownLicenses, license):
+		report.warn("license", "license", "'"+license+"' is not a recognised SPDX license identifier")
+	}
+}
+
+// lintTools checks tool definitions: every tool needs a unique name, and an
+// input schema, when given, must describe an object.
+func lintTools(report *lintReport, tools models.Tools) {
+	seen := make(map[string]bool)
+	for i, tool := range tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		switch {
+		case tool.Name == "":
+			report.fail(field+".name", "required", "is required")
+		case seen[tool.Name]:
+			report.fail(field+".name", "duplicate_tool", "tool '"+tool.Name+"' is defined more than once")
+		}
+		seen[tool.Name] = true
+
+		if tool.Description == "" {
+			report.warn(field+".description", "tool_description", "clients show the description when choosing a tool")
+		}
+		if tool.InputSchema == nil {
+			continue
+		}
+		if schemaType, _ := tool.InputSchema["type"].(string); schemaType != "object" {
+			report.fail(field+".input_schema.type", "tool_schema", "must be \"object\"")
+		}
+		if properties, ok := tool.InputSchema["properties"]; ok {
+			if _, isObject := properties.(map[string]interface{}); !isObject {
+				report.fail(field+".input_schema.properties", "tool_schema", "must be an object")
+			}
+		}
+		if required, ok := tool.InputSchema["required"]; ok {
+			if _, isList := required.([]interface{}); !isList {
+				report.fail(field+".input_schema.required", "tool_schema", "must be a list of property names")
+			}
+		}
+	}
+}
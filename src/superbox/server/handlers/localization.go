@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// errorLanguages are the languages auth and payment errors are available
+// in. English, which messages are written in, comes first so it is the
+// fallback for languages not listed.
+var errorLanguages = []language.Tag{language.English, language.Hindi}
+
+var errorLanguageMatcher = language.NewMatcher(errorLanguages)
+
+// localizedRoutePrefixes are the routes whose errors are translated: sign-in
+// and checkout, where end users rather than integrators read them.
+var localizedRoutePrefixes = []string{"/auth", "/payment"}
+
+// errorTranslations holds each language's messages, keyed by the English
+// message. A {} in the key stands for a name or number the message was
+// built with; {1}, {2}, and so on place them in the translation. Messages
+// without an entry stay in English.
+var errorTranslations = map[string]map[string]string{
+	"hi": {
+		"Internal server error":                                         "आंतरिक सर्वर त्रुटि",
+		"Invalid request":                                               "अमान्य अनुरोध",
+		"Request validation failed":                                     "अनुरोध का सत्यापन विफल रहा",
+		"Request body exceeds the {} byte limit":                        "अनुरोध का आकार {1} बाइट की सीमा से अधिक है",
+		"The request did not finish in time; try again":                 "अनुरोध समय पर पूरा नहीं हुआ; फिर से प्रयास करें",
+		"Access denied":                                                 "पहुँच अस्वीकृत",
+		"Admin access required":                                         "एडमिन पहुँच आवश्यक है",
+		"Rate limit exceeded for {}; try again after the window resets": "{1} की दर सीमा पार हो गई; अवधि रीसेट होने के बाद फिर से प्रयास करें",
+		"The {} plan's {} limit is spent; try again after the window resets or upgrade": "{1} प्लान की {2} सीमा समाप्त हो गई है; अवधि रीसेट होने के बाद फिर से प्रयास करें या अपग्रेड करें",
+		"Idempotency-Key must be at most 255 characters":                                "Idempotency-Key अधिकतम 255 अक्षरों की हो सकती है",
+		"A request with this Idempotency-Key just finished; retry it":                   "इस Idempotency-Key वाला अनुरोध अभी पूरा हुआ है; इसे फिर से भेजें",
+		"A request with this Idempotency-Key is still in progress":                      "इस Idempotency-Key वाला अनुरोध अभी चल रहा है",
+		"Idempotency-Key was already used with a different request body":                "यह Idempotency-Key किसी दूसरे अनुरोध के लिए पहले ही उपयोग की जा चुकी है",
+
+		"missing authorization header":                      "Authorization हेडर नहीं भेजा गया",
+		"invalid authorization header":                      "Authorization हेडर अमान्य है",
+		"invalid or expired token":                          "टोकन अमान्य है या उसकी अवधि समाप्त हो गई है",
+		"invalid API key":                                   "API कुंजी अमान्य है",
+		"API key has expired":                               "API कुंजी की अवधि समाप्त हो गई है",
+		"API key '{}' not found":                            "API कुंजी '{1}' नहीं मिली",
+		"API key scope does not allow '{}'":                 "API कुंजी के स्कोप में '{1}' की अनुमति नहीं है",
+		"API key is not allowed to act on server '{}'":      "API कुंजी को सर्वर '{1}' पर कार्य करने की अनुमति नहीं है",
+		"At most {} API keys are allowed; revoke one first": "अधिकतम {1} API कुंजियों की अनुमति है; पहले एक को रद्द करें",
+		"Unsupported provider":                              "यह प्रदाता समर्थित नहीं है",
+		"Unsupported provider '{}'":                         "प्रदाता '{1}' समर्थित नहीं है",
+		"Unknown or revoked client_id '{}'":                 "client_id '{1}' अज्ञात है या रद्द कर दिया गया है",
+		"client_id '{}' has been revoked":                   "client_id '{1}' रद्द कर दिया गया है",
+		"Provider login is not configured":                  "इस प्रदाता से लॉगिन कॉन्फ़िगर नहीं है",
+		"Device login is temporarily unavailable":           "डिवाइस लॉगिन अस्थायी रूप से उपलब्ध नहीं है",
+		"Unknown device code":                               "अज्ञात डिवाइस कोड",
+		"Device authorization expired":                      "डिवाइस प्राधिकरण की अवधि समाप्त हो गई",
+		"Authorization failed":                              "प्राधिकरण विफल रहा",
+		"Invalid device session state":                      "डिवाइस सत्र की स्थिति अमान्य है",
+		"Failed to issue token":                             "टोकन जारी नहीं किया जा सका",
+		"Identity service unavailable":                      "पहचान सेवा उपलब्ध नहीं है",
+		"Identity service rejected the request":             "पहचान सेवा ने अनुरोध अस्वीकार कर दिया",
+		"Missing id_token or access_token for Google login": "Google लॉगिन के लिए id_token या access_token आवश्यक है",
+		"Missing access_token for GitHub login":             "GitHub लॉगिन के लिए access_token आवश्यक है",
+		"Missing id_token for Microsoft login":              "Microsoft लॉगिन के लिए id_token आवश्यक है",
+		"User not found":                                    "उपयोगकर्ता नहीं मिला",
+		"An account with this email already exists":         "इस ईमेल से एक खाता पहले से मौजूद है",
+		"Email or password is incorrect":                    "ईमेल या पासवर्ड गलत है",
+		"This account has been disabled":                    "यह खाता अक्षम कर दिया गया है",
+		"Too many attempts; try again later":                "बहुत अधिक प्रयास हुए; कुछ देर बाद फिर से प्रयास करें",
+		"Password is too weak":                              "पासवर्ड बहुत कमज़ोर है",
+		"Email address is not valid":                        "ईमेल पता मान्य नहीं है",
+		"Password is required":                              "पासवर्ड आवश्यक है",
+		"Token is invalid or expired":                       "टोकन अमान्य है या उसकी अवधि समाप्त हो गई है",
+		"Session has expired; sign in again":                "सत्र की अवधि समाप्त हो गई है; फिर से साइन इन करें",
+		"refresh_token is required":                         "refresh_token आवश्यक है",
+		"Sign in again to make this change":                 "यह बदलाव करने के लिए फिर से साइन इन करें",
+		"The sign-in provider rejected the credentials":     "साइन-इन प्रदाता ने क्रेडेंशियल अस्वीकार कर दिए",
+		"This provider account is linked to another user":   "यह प्रदाता खाता किसी अन्य उपयोगकर्ता से जुड़ा है",
+		"This sign-in method is disabled":                   "यह साइन-इन तरीका अक्षम है",
+
+		"Server '{}' not found":                                                     "सर्वर '{1}' नहीं मिला",
+		"Error fetching servers":                                                    "सर्वर प्राप्त करने में त्रुटि",
+		"unknown plan '{}'":                                                         "अज्ञात प्लान '{1}'",
+		"a positive amount is required for donations":                               "दान के लिए शून्य से अधिक राशि आवश्यक है",
+		"amount must be at least {} {}":                                             "राशि कम से कम {1} {2} होनी चाहिए",
+		"amount must not exceed {}":                                                 "राशि {1} से अधिक नहीं हो सकती",
+		"Plan '{}' is free and does not require an order":                           "प्लान '{1}' मुफ़्त है और इसके लिए ऑर्डर की आवश्यकता नहीं है",
+		"Plan '{}' is not a recurring plan":                                         "प्लान '{1}' आवर्ती प्लान नहीं है",
+		"Order was declined by risk checks":                                         "जोखिम जाँच में ऑर्डर अस्वीकार कर दिया गया",
+		"Order '{}' not found":                                                      "ऑर्डर '{1}' नहीं मिला",
+		"Error creating order":                                                      "ऑर्डर बनाने में त्रुटि",
+		"Invalid payment signature":                                                 "भुगतान हस्ताक्षर अमान्य है",
+		"Payment has not succeeded":                                                 "भुगतान सफल नहीं हुआ है",
+		"Error verifying payment":                                                   "भुगतान सत्यापित करने में त्रुटि",
+		"Error fetching payment status":                                             "भुगतान की स्थिति प्राप्त करने में त्रुटि",
+		"payment_intent_id is required for Stripe payments":                         "Stripe भुगतानों के लिए payment_intent_id आवश्यक है",
+		"Payment link '{}' not found":                                               "भुगतान लिंक '{1}' नहीं मिला",
+		"checkout_session_id is required for Stripe payment links":                  "Stripe भुगतान लिंक के लिए checkout_session_id आवश्यक है",
+		"Checkout session was not paid through this payment link":                   "चेकआउट सत्र का भुगतान इस भुगतान लिंक से नहीं किया गया",
+		"Payments are temporarily unavailable":                                      "भुगतान अस्थायी रूप से उपलब्ध नहीं हैं",
+		"The payment gateway declined the request; try again or use another method": "भुगतान गेटवे ने अनुरोध अस्वीकार कर दिया; फिर से प्रयास करें या कोई दूसरा तरीका चुनें",
+		"Payment not found":                                                         "भुगतान नहीं मिला",
+		"The payment cannot be refunded by this amount":                             "इस राशि से भुगतान का रिफ़ंड नहीं किया जा सकता",
+		"The payment provider rejected the request":                                 "भुगतान प्रदाता ने अनुरोध अस्वीकार कर दिया",
+		"Subscription '{}' not found":                                               "सदस्यता '{1}' नहीं मिली",
+		"Subscription has expired; purchase a new plan instead":                     "सदस्यता की अवधि समाप्त हो गई है; इसके बजाय नया प्लान खरीदें",
+		"Subscription is already on plan '{}'":                                      "सदस्यता पहले से प्लान '{1}' पर है",
+		"Error creating proration order":                                            "आनुपातिक ऑर्डर बनाने में त्रुटि",
+		"Error issuing proration credit":                                            "आनुपातिक क्रेडिट जारी करने में त्रुटि",
+		"No billing profile on file":                                                "कोई बिलिंग प्रोफ़ाइल दर्ज नहीं है",
+
+		"is required":                                                     "आवश्यक है",
+		"is required for {} addresses":                                    "{1} के पतों के लिए आवश्यक है",
+		"is not valid for {}":                                             "{1} के लिए मान्य नहीं है",
+		"must be a valid {} for {}":                                       "{2} के लिए मान्य {1} होना चाहिए",
+		"must be a valid email address":                                   "मान्य ईमेल पता होना चाहिए",
+		"must be at least {} characters":                                  "कम से कम {1} अक्षरों का होना चाहिए",
+		"must be at most {} characters":                                   "अधिकतम {1} अक्षरों का हो सकता है",
+		"must be at least {}":                                             "कम से कम {1} होना चाहिए",
+		"must be at most {}":                                              "अधिकतम {1} हो सकता है",
+		"must be one of {}":                                               "इनमें से एक होना चाहिए: {1}",
+		"must be an ISO 4217 currency code":                               "ISO 4217 मुद्रा कोड होना चाहिए",
+		"must be an ISO 3166-1 alpha-2 code":                              "ISO 3166-1 alpha-2 कोड होना चाहिए",
+		"must be an absolute http(s) URL":                                 "पूर्ण http(s) URL होना चाहिए",
+		"must be at least {} characters and contain a letter and a digit": "कम से कम {1} अक्षरों का होना चाहिए और इसमें एक अक्षर और एक अंक होना चाहिए",
+	},
+}
+
+// messagePattern matches an English message with {} in it.
+type messagePattern struct {
+	pattern     *regexp.Regexp
+	translation string
+}
+
+var (
+	messagePatterns     map[string][]messagePattern
+	messagePatternsOnce sync.Once
+)
+
+// patternsFor compiles a language's templated messages, longest first so a
+// message matches its most specific template.
+func patternsFor(lang string) []messagePattern {
+	messagePatternsOnce.Do(func() {
+		messagePatterns = make(map[string][]messagePattern)
+		for code, messages := range errorTranslations {
+			keys := make([]string, 0, len(messages))
+			for english := range messages {
+				if strings.Contains(english, "{}") {
+					keys = append(keys, english)
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				if len(keys[i]) != len(keys[j]) {
+					return len(keys[i]) > len(keys[j])
+				}
+				return keys[i] < keys[j]
+			})
+			for _, english := range keys {
+				expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(english), `\{\}`, "(.+?)") + "$"
+				messagePatterns[code] = append(messagePatterns[code], messagePattern{regexp.MustCompile(expr), messages[english]})
+			}
+		}
+	})
+	return messagePatterns[lang]
+}
+
+// translateMessage returns message in lang, or unchanged when there is no
+// translation for it.
+func translateMessage(lang string, message string) string {
+	if translated, exists := errorTranslations[lang][message]; exists {
+		return translated
+	}
+	for _, candidate := range patternsFor(lang) {
+		values := candidate.pattern.FindStringSubmatch(message)
+		if values == nil {
+			continue
+		}
+		translated := candidate.translation
+		for i, value := range values[1:] {
+			translated = strings.ReplaceAll(translated, "{"+strconv.Itoa(i+1)+"}", value)
+		}
+		return translated
+	}
+	return message
+}
+
+// errorLanguage picks the language for the request's errors from
+// Accept-Language, as a base language code such as "hi".
+func errorLanguage(c *gin.Context) string {
+	tag, _ := language.MatchStrings(errorLanguageMatcher, c.GetHeader("Accept-Language"))
+	base, _ := tag.Base()
+	return base.String()
+}
+
+func localizedRoute(route string) bool {
+	route = policyRoute(route)
+	return slices.ContainsFunc(localizedRoutePrefixes, func(prefix string) bool {
+		return route == prefix || strings.HasPrefix(route, prefix+"/")
+	})
+}
+
+// localizeError translates an auth or payment error's message and field
+// messages into the caller's language. Codes are never translated, so
+// clients keep matching on them.
+func localizeError(c *gin.Context, apiErr *APIError) *APIError {
+	if !localizedRoute(c.FullPath()) {
+		return apiErr
+	}
+	lang := errorLanguage(c)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	c.Header("Content-Language", lang)
+	if lang == "en" {
+		return apiErr
+	}
+
+	localized := *apiErr
+	localized.Message = translateMessage(lang, apiErr.Message)
+	localized.Fields = slices.Clone(apiErr.Fields)
+	for i := range localized.Fields {
+		localized.Fields[i].Message = translateMessage(lang, localized.Fields[i].Message)
+	}
+	return &localized
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+var logLevel = new(slog.LevelVar)
+
+func NewLogger() *slog.Logger {
+	return slog.New(scrubbingHandler{next: slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})})
+}
+
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = randomID("req")
+		}
+		c.Set("request_id", requestID)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, requestID))
+		c.Header(requestIDHeader, requestID)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_id", c.GetString("user_id")),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+func getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"level":  strings.ToLower(logLevel.Level().String()),
+	})
+}
+
+func setLogLevel(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Level string `json:"level" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "level must be one of debug, info, warn, error"))
+		return
+	}
+	auditChange(c, gin.H{"level": strings.ToLower(logLevel.Level().String())}, gin.H{"level": strings.ToLower(level.String())})
+	logLevel.Set(level)
+	settingsMutex.Lock()
+	liveSettings.LogLevel = strings.ToLower(level.String())
+	settingsMutex.Unlock()
+	slog.Info("log level changed", "level", level.String(), "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"level":  strings.ToLower(level.String()),
+	})
+}
+
+type requestIDKey struct{}
+
+func requestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func newOutboundRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if requestID := requestIDFrom(ctx); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	return req, nil
+}
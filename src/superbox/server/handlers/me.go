@@ -0,0 +1,22 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+// RegisterMe groups routes scoped to the signed-in user.
+func RegisterMe(api *gin.RouterGroup) {
+	me := api.Group("/me")
+	{
+		me.POST("/export", exportAccount)
+		me.GET("/notifications", listNotifications)
+		me.POST("/notifications/read", markAllNotificationsRead)
+		me.POST("/notifications/:notification_id/read", markNotificationRead)
+		me.GET("/notification-preferences", getNotificationPreferences)
+		me.PUT("/notification-preferences", updateNotificationPreferences)
+		me.GET("/data-residency", getDataResidency)
+		me.PUT("/data-residency", updateDataResidency)
+		me.GET("/limits", getLimits)
+		me.GET("/installed", listInstalledServers)
+		me.PUT("/installed", updateInstalledServers)
+		me.GET("/updates", listServerUpdates)
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReadmeBytes bounds how much of a repository README is moderated.
+const maxReadmeBytes = 256 << 10
+
+// moderatedImageClasses are the uploadable blob classes shown to other
+// users, which are sent to the moderation service when it is configured.
+var moderatedImageClasses = map[string]bool{
+	"avatar": true,
+	"logo":   true,
+}
+
+// moderationHolds are listed per tenant.
+var moderationHolds = recordSet[storedModerationHold]{
+	kind:  "moderation_hold",
+	group: func(stored *storedModerationHold) string { return stored.Hold.TenantID },
+}
+
+// storedModerationHold is a hold as the record store holds it, with the
+// base version the API leaves out.
+type storedModerationHold struct {
+	Hold          models.ModerationHold `json:"hold"`
+	BaseUpdatedAt time.Time             `json:"base_updated_at,omitempty"`
+}
+
+func (s *storedModerationHold) restore() models.ModerationHold {
+	hold := s.Hold
+	hold.BaseUpdatedAt = s.BaseUpdatedAt
+	return hold
+}
+
+// tenantHolds lists a tenant's moderation holds.
+func tenantHolds(ctx context.Context, tenantID string) ([]models.ModerationHold, error) {
+	stored, err := moderationHolds.listGroup(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	holds := make([]models.ModerationHold, 0, len(stored))
+	for i := range stored {
+		holds = append(holds, stored[i].restore())
+	}
+	return holds, nil
+}
+
+func moderationKeywords() []string {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return liveSettings.ModerationKeywords
+}
+
+func moderationEnabled() bool {
+	return len(moderationKeywords()) > 0 || appConfig.ModerationAPIURL != ""
+}
+
+// keywordFlags returns a flag for each keyword found in text as a whole
+// word or phrase, ignoring case.
+func keywordFlags(field string, text string) []models.ModerationFlag {
+	flags := []models.ModerationFlag{}
+	for _, keyword := range moderationKeywords() {
+		pattern := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(keyword) + `\b`)
+		if pattern.MatchString(text) {
+			flags = append(flags, models.ModerationFlag{Field: field, Source: "keywords", Reason: "contains \"" + keyword + "\""})
+		}
+	}
+	return flags
+}
+
+// checkModerationAPI sends text or an image URL to the external moderation
+// service. It returns the categories the service flagged, none when the
+// content is acceptable.
+func checkModerationAPI(ctx context.Context, input map[string]string) ([]string, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newOutboundRequest(ctx, "POST", appConfig.ModerationAPIURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if appConfig.ModerationAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+appConfig.ModerationAPIKey)
+	}
+	resp, err := doUpstream("moderation", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation service returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Flagged    bool     `json:"flagged"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Flagged {
+		return nil, nil
+	}
+	if len(result.Categories) == 0 {
+		return []string{"flagged"}, nil
+	}
+	return result.Categories, nil
+}
+
+// apiFlags runs content through the moderation service when one is
+// configured. Content that cannot be checked is held rather than let
+// through.
+func apiFlags(ctx context.Context, field string, input map[string]string) []models.ModerationFlag {
+	if appConfig.ModerationAPIURL == "" {
+		return nil
+	}
+	categories, err := checkModerationAPI(ctx, input)
+	if err != nil {
+		slog.Warn("moderation check failed", "field", field, "error", err)
+		return []models.ModerationFlag{{Field: field, Source: "api", Reason: "moderation service unavailable"}}
+	}
+	if len(categories) == 0 {
+		return nil
+	}
+	return []models.ModerationFlag{{Field: field, Source: "api", Reason: strings.Join(categories, ", ")}}
+}
+
+// fetchReadme returns the README on the default branch of a GitHub
+// repository, or "" when there is none or the repository is elsewhere.
+func fetchReadme(ctx context.Context, repositoryURL string) (string, error) {
+	owner, repo, ok := githubRepository(repositoryURL)
+	if !ok {
+		return "", nil
+	}
+	readmeURL := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/HEAD/README.md", url.PathEscape(owner), url.PathEscape(repo))
+	req, err := newOutboundRequest(ctx, "GET", readmeURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doUpstream("github", req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("fetching README.md returned %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxReadmeBytes))
+	return string(body), err
+}
+
+// moderateListing checks a listing's description and README. A README
+// that cannot be fetched is skipped, since GitHub being down should not
+// hold every publish.
+func moderateListing(ctx context.Context, server models.Server) []models.ModerationFlag {
+	if !moderationEnabled() {
+		return nil
+	}
+
+	flags := keywordFlags("description", server.Description)
+	if server.Description != "" {
+		flags = append(flags, apiFlags(ctx, "description", map[string]string{"text": server.Description})...)
+	}
+
+	readme, err := fetchReadme(ctx, server.Repository.URL)
+	if err != nil {
+		slog.Warn("failed to fetch README for moderation", "server_name", server.Name, "error", err)
+	}
+	if readme != "" {
+		flags = append(flags, keywordFlags("readme", readme)...)
+		flags = append(flags, apiFlags(ctx, "readme", map[string]string{"text": readme})...)
+	}
+	return flags
+}
+
+// moderateImage sends an uploaded image to the moderation service.
+func moderateImage(ctx context.Context, blob models.Blob) []models.ModerationFlag {
+	if !moderatedImageClasses[blob.Class] || appConfig.ModerationAPIURL == "" {
+		return nil
+	}
+	imageURL, err := blobDownloadURL(ctx, blob)
+	if err != nil {
+		return []models.ModerationFlag{{Field: "image", Source: "api", Reason: "image could not be read"}}
+	}
+	return apiFlags(ctx, "image", map[string]string{"image_url": imageURL})
+}
+
+func holdForModeration(ctx context.Context, hold *models.ModerationHold) (*models.ModerationHold, error) {
+	hold.ID = randomID("mod")
+	hold.Status = "pending"
+	hold.RequestedAt = models.Now()
+
+	if err := moderationHolds.put(ctx, hold.ID, &storedModerationHold{Hold: *hold, BaseUpdatedAt: hold.BaseUpdatedAt}); err != nil {
+		return nil, err
+	}
+	copy := *hold
+
+	slog.Info("content held for moderation", "hold_id", copy.ID, "kind", copy.Kind, "server_name", copy.ServerName, "blob_id", copy.BlobID)
+	notify(ctx, copy.OwnerID, "moderation.held", "Your "+holdSubject(copy)+" is being reviewed before it goes live.", map[string]interface{}{
+		"hold_id": copy.ID,
+		"flags":   copy.Flags,
+	})
+	return &copy, nil
+}
+
+func holdSubject(hold models.ModerationHold) string {
+	switch hold.Kind {
+	case "image":
+		return "image upload"
+	case "update":
+		return "update to " + hold.ServerName
+	}
+	return "listing " + hold.ServerName
+}
+
+// publishHeld reports whether a new listing by this name is awaiting review,
+// so the name is not published twice.
+func publishHeld(ctx context.Context, tenantID string, serverName string) (bool, error) {
+	holds, err := tenantHolds(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+	for _, hold := range holds {
+		if hold.Kind == "publish" && hold.Status == "pending" && hold.ServerName == serverName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func listModerationHolds(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	tenantID := requestTenant(c).ID
+
+	all, err := tenantHolds(c.Request.Context(), tenantID)
+	if err != nil {
+		respondError(c, internalError("Error listing moderation holds", err))
+		return
+	}
+	holds := []models.ModerationHold{}
+	for _, hold := range all {
+		if hold.Status == status {
+			holds = append(holds, hold)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].RequestedAt.Before(holds[j].RequestedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"total":  len(holds),
+		"holds":  holds,
+	})
+}
+
+func pendingHold(c *gin.Context) (models.ModerationHold, bool) {
+	holdID := c.Param("hold_id")
+	stored, err := moderationHolds.get(c.Request.Context(), holdID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error loading moderation hold", err))
+		return models.ModerationHold{}, false
+	}
+	exists := err == nil
+	var pending models.ModerationHold
+	if exists {
+		pending = stored.restore()
+	}
+
+	if !exists || pending.Status != "pending" || pending.TenantID != requestTenant(c).ID {
+		respondError(c, newAPIError(http.StatusNotFound, "Pending moderation hold '"+holdID+"' not found"))
+		return pending, false
+	}
+	return pending, true
+}
+
+// errHoldDecided is returned when reviewing a hold already decided.
+var errHoldDecided = errors.New("moderation hold already decided")
+
+// reviewHold records the decision on a hold, unless another admin decided
+// it first.
+func reviewHold(c *gin.Context, holdID string, status string, reason string) (models.ModerationHold, bool) {
+	decided := ""
+	stored, err := moderationHolds.update(c.Request.Context(), holdID, func(stored *storedModerationHold) error {
+		hold := &stored.Hold
+		if hold.Status != "pending" {
+			decided = hold.Status
+			return errHoldDecided
+		}
+		hold.Status = status
+		hold.Reason = reason
+		hold.ReviewedAt = models.Now()
+		hold.ReviewedBy = c.GetString("user_id")
+		return nil
+	})
+	if errors.Is(err, errHoldDecided) {
+		respondError(c, newAPIError(http.StatusConflict, "Moderation hold '"+holdID+"' is already "+decided))
+		return models.ModerationHold{}, false
+	}
+	if err != nil {
+		respondError(c, internalError("Error reviewing moderation hold", err))
+		return models.ModerationHold{}, false
+	}
+	reviewed := stored.restore()
+	auditChange(c, gin.H{"status": "pending"}, gin.H{"status": status, "reason": reason})
+	return reviewed, true
+}
+
+// approveModerationHold publishes the held listing or update, or releases
+// the held image. An update is refused if the live listing changed after
+// it was held, since approving it would undo that change.
+func approveModerationHold(c *gin.Context) {
+	hold, ok := pendingHold(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	tenantID := requestTenant(c).ID
+
+	switch hold.Kind {
+	case "publish":
+		if _, err := fetchServer(ctx, hold.ServerName); err == nil {
+			respondError(c, newAPIError(http.StatusConflict, "Server '"+hold.ServerName+"' has been published since this listing was held"))
+			return
+		}
+		server := *hold.Server
+		if err := saveServer(ctx, server); err != nil {
+			respondError(c, internalError("Error publishing server", err))
+			return
+		}
+		recordAppliedPrice(ctx, tenantID, server.Name, server.Author, models.Pricing{}, server.Pricing)
+		emit(ctx, "server.published", tenantID, serverSubject(tenantID, server.Name), map[string]interface{}{"server": server})
+
+	case "update":
+		existing, err := fetchServer(ctx, hold.ServerName)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusConflict, "Server '"+hold.ServerName+"' no longer exists"))
+			return
+		}
+		if !existing.Meta.UpdatedAt.Equal(hold.BaseUpdatedAt) {
+			respondError(c, newAPIError(http.StatusConflict, "Server '"+hold.ServerName+"' has changed since this update was held; reject it and have the publisher resubmit"))
+			return
+		}
+		server := *hold.Server
+		server.Meta.UpdatedAt = models.Now()
+		if server.Name != hold.ServerName {
+			if _, err := fetchServer(ctx, server.Name); err == nil {
+				respondError(c, newAPIError(http.StatusConflict, "Server '"+server.Name+"' already exists"))
+				return
+			}
+			deleteServerRecord(ctx, hold.ServerName)
+		}
+		if err := saveServer(ctx, server); err != nil {
+			respondError(c, internalError("Error updating server", err))
+			return
+		}
+		if !reflect.DeepEqual(server.Pricing, existing.Pricing) {
+			recordAppliedPrice(ctx, tenantID, server.Name, server.Author, existing.Pricing, server.Pricing)
+		}
+		emit(ctx, "server.updated", tenantID, serverSubject(tenantID, hold.ServerName), map[string]interface{}{"server": server})
+
+	case "image":
+		_, err := updateBlob(ctx, hold.BlobID, func(blob *models.Blob) {
+			if blob.Status == "held" {
+				blob.Status = "ready"
+			}
+		})
+		if err != nil {
+			respondError(c, internalError("Error releasing image", err))
+			return
+		}
+	}
+
+	reviewed, ok := reviewHold(c, hold.ID, "approved", "")
+	if !ok {
+		return
+	}
+	notify(ctx, reviewed.OwnerID, "moderation.reviewed", "Your "+holdSubject(reviewed)+" was approved.", map[string]interface{}{
+		"hold_id": reviewed.ID,
+		"status":  reviewed.Status,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"hold":   reviewed,
+	})
+}
+
+// rejectModerationHold discards the held content; a held image is deleted.
+func rejectModerationHold(c *gin.Context) {
+	hold, ok := pendingHold(c)
+	if !ok {
+		return
+	}
+	var req models.RejectModerationRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+	}
+
+	if hold.Kind == "image" {
+		blob, err := getBlobCopy(c.Request.Context(), hold.BlobID)
+		if err != nil {
+			respondError(c, internalError("Error deleting image", err))
+			return
+		}
+		if blob != nil {
+			if err := removeBlob(c.Request.Context(), *blob); err != nil {
+				respondError(c, internalError("Error deleting image", err))
+				return
+			}
+		}
+	}
+
+	reviewed, ok := reviewHold(c, hold.ID, "rejected", req.Reason)
+	if !ok {
+		return
+	}
+	body := "Your " + holdSubject(reviewed) + " was rejected."
+	if reviewed.Reason != "" {
+		body += " " + reviewed.Reason
+	}
+	notify(c.Request.Context(), reviewed.OwnerID, "moderation.reviewed", body, map[string]interface{}{
+		"hold_id": reviewed.ID,
+		"status":  reviewed.Status,
+		"reason":  reviewed.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"hold":   reviewed,
+	})
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxNotificationsPerUser = 200
+
+// notificationKinds are the notifications users can receive. Every
+// notification is kept in-app; the preference channel adds a copy by email or
+// as a notification.created event to the user's account webhooks.
+var notificationKinds = map[string]string{
+	"purchase.completed":      "Purchase complete",
+	"scan.failed":             "Security scan failed",
+	"review.created":          "New review",
+	"review.responded":        "Review response",
+	"server.update_available": "Update available",
+	"server.security_alert":   "Security alert for an installed server",
+	"server.advisory":         "Advisory for an installed server",
+	"question.created":        "New question",
+	"question.answered":       "Question answered",
+	"moderation.held":         "Held for review",
+	"moderation.reviewed":     "Review complete",
+}
+
+var notificationChannels = map[string]bool{
+	"none":    true,
+	"email":   true,
+	"webhook": true,
+}
+
+var (
+	// notifications holds each user's notifications as one list, keyed by
+	// user ID, so adding one and trimming the oldest is a single write.
+	notifications           = recordSet[[]models.Notification]{kind: "notifications"}
+	notificationPreferences = recordSet[storedNotificationPreferences]{kind: "notification_preferences"}
+)
+
+// storedNotificationPreferences is a user's preferences as the record store
+// holds them, with the tenant the API leaves out.
+type storedNotificationPreferences struct {
+	Preferences models.NotificationPreferences `json:"preferences"`
+	TenantID    string                         `json:"tenant_id,omitempty"`
+}
+
+func notificationChannel(ctx context.Context, userID string, kind string) (string, string, string, error) {
+	stored, err := notificationPreferences.get(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return "none", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	prefs := stored.Preferences
+	channel, set := prefs.Channels[kind]
+	if !set {
+		return "none", prefs.Email, stored.TenantID, nil
+	}
+	return channel, prefs.Email, stored.TenantID, nil
+}
+
+// notify adds a notification for a user and sends it on their chosen
+// channel. A notification that cannot be stored is logged and dropped
+// rather than failing the change that caused it.
+func notify(ctx context.Context, userID string, kind string, body string, data map[string]interface{}) *models.Notification {
+	if userID == "" {
+		return nil
+	}
+	notification := &models.Notification{
+		ID:        randomID("ntf"),
+		UserID:    userID,
+		Kind:      kind,
+		Title:     notificationKinds[kind],
+		Body:      body,
+		Data:      data,
+		CreatedAt: models.Now(),
+	}
+
+	_, err := notifications.upsert(ctx, userID, &[]models.Notification{}, func(list *[]models.Notification) error {
+		*list = append(*list, *notification)
+		if len(*list) > maxNotificationsPerUser {
+			*list = (*list)[len(*list)-maxNotificationsPerUser:]
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to store notification", "user_id", userID, "kind", kind, "error", err)
+		return nil
+	}
+
+	channel, email, tenantID, err := notificationChannel(ctx, userID, kind)
+	if err != nil {
+		slog.Error("failed to load notification preferences", "user_id", userID, "error", err)
+		return notification
+	}
+	switch channel {
+	case "email":
+		if email != "" {
+			_, err := enqueueJob(ctx, "notification_email", map[string]string{
+				"tenant":  tenantID,
+				"to":      email,
+				"subject": notification.Title,
+				"body":    notification.Body,
+			}, time.Now())
+			if err != nil {
+				slog.Error("failed to queue notification email", "user_id", userID, "error", err)
+			}
+		}
+	case "webhook":
+		emit(context.WithoutCancel(ctx), "notification.created", "", userID, map[string]interface{}{"notification": notification})
+	}
+	return notification
+}
+
+func runNotificationEmailJob(job *models.Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	subject, body, err := renderEmail(tenantByID(job.Payload["tenant"]), "notification", map[string]any{
+		"title": job.Payload["subject"],
+		"body":  job.Payload["body"],
+	})
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, job.Payload["to"], subject, body)
+}
+
+func listNotifications(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	result, unread, err := userNotifications(c.Request.Context(), userID, c.Query("unread") == "true")
+	if err != nil {
+		respondError(c, internalError("Failed to load notifications", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"unread":        unread,
+		"notifications": result,
+	})
+}
+
+// userNotifications returns the user's notifications newest first, along
+// with how many of them are unread.
+func userNotifications(ctx context.Context, userID string, unreadOnly bool) ([]models.Notification, int, error) {
+	list, err := notifications.get(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		list, err = &[]models.Notification{}, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := []models.Notification{}
+	unread := 0
+	for _, notification := range *list {
+		if notification.ReadAt.IsZero() {
+			unread++
+		} else if unreadOnly {
+			continue
+		}
+		result = append(result, notification)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, unread, nil
+}
+
+func markNotificationRead(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	notificationID := c.Param("notification_id")
+	now := models.Now()
+	var marked *models.Notification
+
+	_, err := notifications.update(c.Request.Context(), userID, func(list *[]models.Notification) error {
+		marked = nil
+		for i := range *list {
+			notification := &(*list)[i]
+			if notification.ID == notificationID {
+				if notification.ReadAt.IsZero() {
+					notification.ReadAt = now
+				}
+				copy := *notification
+				marked = &copy
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to mark notification read", err))
+		return
+	}
+	if marked == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Notification '"+notificationID+"' not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"notification": marked,
+	})
+}
+
+func markAllNotificationsRead(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	now := models.Now()
+	marked := 0
+	_, err := notifications.update(c.Request.Context(), userID, func(list *[]models.Notification) error {
+		marked = 0
+		for i := range *list {
+			if (*list)[i].ReadAt.IsZero() {
+				(*list)[i].ReadAt = now
+				marked++
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to mark notifications read", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"marked": marked,
+	})
+}
+
+func getNotificationPreferences(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	prefs := models.NotificationPreferences{Channels: map[string]string{}}
+	stored, err := notificationPreferences.get(c.Request.Context(), userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to load notification preferences", err))
+		return
+	}
+	if err == nil {
+		prefs = stored.Preferences
+		if prefs.Channels == nil {
+			prefs.Channels = map[string]string{}
+		}
+	}
+	for kind := range notificationKinds {
+		if _, set := prefs.Channels[kind]; !set {
+			prefs.Channels[kind] = "none"
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"preferences": prefs,
+	})
+}
+
+func updateNotificationPreferences(c *gin.Context) {
+	account, ok := authenticatedAccount(c)
+	if !ok {
+		return
+	}
+	userID, _ := account["localId"].(string)
+
+	var req models.UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	email, _ := account["email"].(string)
+	for kind, channel := range req.Channels {
+		if _, known := notificationKinds[kind]; !known {
+			respondError(c, newAPIError(http.StatusBadRequest, "Unknown notification kind '"+kind+"'"))
+			return
+		}
+		if !notificationChannels[channel] {
+			respondError(c, newAPIError(http.StatusBadRequest, fmt.Sprintf("channel for '%s' must be email, webhook, or none", kind)))
+			return
+		}
+		if channel == "email" && email == "" {
+			respondError(c, newAPIError(http.StatusBadRequest, "Your account has no email address to send notifications to"))
+			return
+		}
+	}
+
+	var before map[string]string
+	initial := &storedNotificationPreferences{Preferences: models.NotificationPreferences{Channels: map[string]string{}}}
+	stored, err := notificationPreferences.upsert(c.Request.Context(), userID, initial, func(stored *storedNotificationPreferences) error {
+		prefs := &stored.Preferences
+		if prefs.Channels == nil {
+			prefs.Channels = map[string]string{}
+		}
+		before = maps.Clone(prefs.Channels)
+		for kind, channel := range req.Channels {
+			prefs.Channels[kind] = channel
+		}
+		prefs.Email = email
+		prefs.UpdatedAt = models.Now()
+		stored.TenantID = requestTenant(c).ID
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to save notification preferences", err))
+		return
+	}
+	updated := stored.Preferences
+	auditChange(c, gin.H{"channels": before}, gin.H{"channels": updated.Channels})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"preferences": updated,
+	})
+}
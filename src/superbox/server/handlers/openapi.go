@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiOperation struct {
+	Method   string
+	Path     string
+	Tag      string
+	Summary  string
+	Auth     bool
+	Request  interface{}
+	Response interface{}
+	Status   int
+}
+
+var (
+	pathParamPattern = regexp.MustCompile(`:(\w+)`)
+	openAPIDocument  map[string]interface{}
+	openAPIOnce      sync.Once
+)
+
+var apiOperations = []apiOperation{
+	{Method: "POST", Path: "/api/v1/auth/register", Tag: "Auth", Summary: "Register a new user account", Request: models.AuthRegisterRequest{}, Response: models.AuthResponse{}},
+	{Method: "POST", Path: "/api/v1/auth/login", Tag: "Auth", Summary: "Log in with email and password", Request: models.AuthLoginRequest{}, Response: models.AuthResponse{}},
+	{Method: "POST", Path: "/api/v1/auth/login/provider", Tag: "Auth", Summary: "Log in with a Google or GitHub token", Request: models.AuthProviderRequest{}, Response: models.AuthResponse{}},
+	{Method: "POST", Path: "/api/v1/auth/refresh", Tag: "Auth", Summary: "Exchange a refresh token for a new ID token", Request: models.AuthRefreshRequest{}, Response: models.AuthResponse{}},
+	{Method: "GET", Path: "/api/v1/auth/me", Tag: "Auth", Summary: "Get the current user profile", Auth: true, Response: models.AuthUserProfile{}},
+	{Method: "PATCH", Path: "/api/v1/auth/me", Tag: "Auth", Summary: "Update the current user profile", Auth: true, Request: models.AuthUpdateRequest{}, Response: models.AuthUserProfile{}},
+	{Method: "DELETE", Path: "/api/v1/auth/me", Tag: "Auth", Summary: "Delete the current user account", Auth: true},
+	{Method: "POST", Path: "/api/v1/auth/device/start", Tag: "Auth", Summary: "Start the OAuth device code flow", Request: models.AuthDeviceStartRequest{}},
+	{Method: "POST", Path: "/api/v1/auth/device/poll", Tag: "Auth", Summary: "Poll for device authorization", Request: models.AuthDevicePollRequest{}, Response: models.AuthResponse{}},
+	{Method: "POST", Path: "/api/v1/auth/token/exchange", Tag: "Auth", Summary: "Exchange a user's ID token for a scoped partner token (RFC 8693; JSON or form, client credentials in the body or HTTP Basic)", Request: models.TokenExchangeRequest{}, Response: models.TokenExchangeResponse{}},
+	{Method: "GET", Path: "/api/v1/auth/consents", Tag: "Auth", Summary: "List the partners the current user has authorized", Auth: true, Response: []models.PartnerConsent{}},
+	{Method: "POST", Path: "/api/v1/auth/consents", Tag: "Auth", Summary: "Authorize a partner to exchange tokens for the current user", Auth: true, Request: models.GrantConsentRequest{}, Response: models.PartnerConsent{}},
+	{Method: "DELETE", Path: "/api/v1/auth/consents/:client_id", Tag: "Auth", Summary: "Revoke a partner's consent; its exchanged tokens stop working", Auth: true},
+	{Method: "GET", Path: "/api/v1/auth/signing-keys", Tag: "Auth", Summary: "List request signing keys and the signing scheme", Auth: true, Response: []models.RequestSigningKey{}},
+	{Method: "POST", Path: "/api/v1/auth/signing-keys", Tag: "Auth", Summary: "Create a request signing key for machine callers; the secret is only returned here", Auth: true, Request: models.CreateSigningKeyRequest{}, Response: models.RequestSigningKey{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/api/v1/auth/signing-keys/:key_id", Tag: "Auth", Summary: "Delete a request signing key", Auth: true},
+	{Method: "GET", Path: "/api/v1/auth/api-keys", Tag: "Auth", Summary: "List API keys", Auth: true, Response: []models.APIKey{}},
+	{Method: "POST", Path: "/api/v1/auth/api-keys", Tag: "Auth", Summary: "Create an API key for CI; the key is only returned here", Auth: true, Request: models.CreateAPIKeyRequest{}, Response: models.APIKey{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/api/v1/auth/api-keys/:key_id", Tag: "Auth", Summary: "Revoke an API key", Auth: true},
+
+	{Method: "GET", Path: "/api/v1/servers", Tag: "Servers", Summary: "List all servers", Response: models.ServerResponse{}},
+	{Method: "GET", Path: "/api/v1/servers/export.ndjson", Tag: "Servers", Summary: "Stream every server as NDJSON, one summary per line (application/x-ndjson)"},
+	{Method: "POST", Path: "/api/v1/servers", Tag: "Servers", Summary: "Publish a server", Auth: true, Request: models.CreateServerRequest{}, Response: models.ServerResponse{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/api/v1/servers/lint", Tag: "Servers", Summary: "Check a server payload for publish errors and warnings without writing it", Request: models.CreateServerRequest{}, Response: models.LintResponse{}},
+	{Method: "GET", Path: "/api/v1/servers/:server_name", Tag: "Servers", Summary: "Get a server by name", Response: models.ServerResponse{}},
+	{Method: "PUT", Path: "/api/v1/servers/:server_name", Tag: "Servers", Summary: "Update a server (partial updates supported; honors If-Match and If-Unmodified-Since)", Auth: true, Request: models.UpdateServerRequest{}, Response: models.ServerResponse{}},
+	{Method: "PATCH", Path: "/api/v1/servers/:server_name", Tag: "Servers", Summary: "Update a server; same as PUT", Auth: true, Request: models.UpdateServerRequest{}, Response: models.ServerResponse{}},
+	{Method: "POST", Path: "/api/v1/servers/import", Tag: "Servers", Summary: "Publish up to 100 servers in the background; returns 202 with an operation", Auth: true, Request: models.ImportServersRequest{}, Response: models.OperationResponse{}},
+	{Method: "POST", Path: "/api/v1/me/export", Tag: "Me", Summary: "Export the current user's data in the background; returns 202 with an operation", Auth: true, Response: models.OperationResponse{}},
+	{Method: "GET", Path: "/api/v1/operations/:operation_id", Tag: "Operations", Summary: "Poll a background operation for progress and its result", Auth: true, Response: models.OperationResponse{}},
+	{Method: "DELETE", Path: "/api/v1/servers/:server_name", Tag: "Servers", Summary: "Remove a server from the registry (honors If-Match and If-Unmodified-Since)", Auth: true, Response: models.ServerResponse{}},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/download", Tag: "Servers", Summary: "Get download details; paid servers require an entitlement"},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/pricing/history", Tag: "Servers", Summary: "List pricing changes", Response: []models.PriceChange{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/claims", Tag: "Servers", Summary: "Start a claim on a server that has no owner", Auth: true, Request: models.CreateServerClaimRequest{}, Response: models.ServerClaim{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/claims/:claim_id/verify", Tag: "Servers", Summary: "Verify a claim and take ownership of the server", Auth: true, Request: models.VerifyServerClaimRequest{}, Response: models.ServerResponse{}},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/advisories", Tag: "Servers", Summary: "List a server's vulnerability advisories, or those affecting one version (version)", Response: []models.Advisory{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/advisories", Tag: "Servers", Summary: "File a vulnerability advisory against a range of the server's versions", Auth: true, Request: models.CreateAdvisoryRequest{}, Response: models.Advisory{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/questions", Tag: "Servers", Summary: "List questions about a server and the publisher's answers, most helpful first (unanswered)", Response: []models.ServerQuestion{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/questions", Tag: "Servers", Summary: "Ask the publisher a question about the server", Auth: true, Request: models.AskQuestionRequest{}, Response: models.ServerQuestion{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/v1/servers/:server_name/questions/:question_id/answer", Tag: "Servers", Summary: "Answer a question about your server, replacing any earlier answer", Auth: true, Request: models.AnswerQuestionRequest{}, Response: models.ServerQuestion{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/questions/:question_id/helpful", Tag: "Servers", Summary: "Mark a question and its answer helpful", Auth: true, Response: models.ServerQuestion{}},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/reviews", Tag: "Servers", Summary: "List reviews of a server and the publisher's responses, newest first, with the average rating", Response: []models.ServerReview{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/reviews", Tag: "Servers", Summary: "Rate and review a server, once per user", Auth: true, Request: models.CreateReviewRequest{}, Response: models.ServerReview{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/v1/servers/:server_name/reviews/:review_id/response", Tag: "Servers", Summary: "Respond to a review of your server, replacing any earlier response", Auth: true, Request: models.RespondToReviewRequest{}, Response: models.ServerReview{}},
+	{Method: "POST", Path: "/api/v1/servers/:server_name/payment-links", Tag: "Servers", Summary: "Create a shareable payment link for the server", Auth: true, Request: models.CreatePaymentLinkRequest{}, Response: models.PaymentLink{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/payment-links", Tag: "Servers", Summary: "List the server's payment links", Auth: true, Response: []models.PaymentLink{}},
+	{Method: "DELETE", Path: "/api/v1/servers/:server_name/payment-links/:link_id", Tag: "Servers", Summary: "Deactivate a payment link", Auth: true, Response: models.PaymentLink{}},
+	{Method: "GET", Path: "/api/v1/servers/:server_name/retention", Tag: "Servers", Summary: "Get the artifact retention applied to the server's bundles", Auth: true, Response: models.RetentionPolicy{}},
+	{Method: "PUT", Path: "/api/v1/servers/:server_name/retention", Tag: "Servers", Summary: "Set the server's own storage transitions (plans with retention overrides)", Auth: true, Request: models.UpdateRetentionRequest{}, Response: models.RetentionPolicy{}},
+	{Method: "DELETE", Path: "/api/v1/servers/:server_name/retention", Tag: "Servers", Summary: "Go back to the deployment's storage transitions", Auth: true, Response: models.RetentionPolicy{}},
+	{Method: "GET", Path: "/api/v1/advisories", Tag: "Servers", Summary: "List vulnerability advisories (server, version, severity)", Response: []models.Advisory{}},
+
+	{Method: "POST", Path: "/api/v1/payment/create-order", Tag: "Payment", Summary: "Create an order for a server plan", Auth: true, Request: models.CreateOrderRequest{}, Response: models.OrderResponse{}},
+	{Method: "POST", Path: "/api/v1/payment/verify-payment", Tag: "Payment", Summary: "Verify a Razorpay signature or Stripe payment intent", Request: models.VerifyPaymentRequest{}, Response: models.PaymentResponse{}},
+	{Method: "GET", Path: "/api/v1/payment/payment-status/:payment_id", Tag: "Payment", Summary: "Get payment status from Razorpay", Response: models.PaymentResponse{}},
+	{Method: "GET", Path: "/api/v1/payment/entitlements", Tag: "Payment", Summary: "List the current user's entitlements", Auth: true, Response: []models.Entitlement{}},
+	{Method: "POST", Path: "/api/v1/payment/entitlements/batch", Tag: "Payment", Summary: "Check the current user's access to up to 500 servers at once", Auth: true, Request: models.CheckEntitlementsRequest{}, Response: []models.EntitlementCheck{}},
+	{Method: "POST", Path: "/api/v1/payment/payment-links/:link_id/verify", Tag: "Payment", Summary: "Verify a payment made through a payment link and fulfill its order", Auth: true, Request: models.VerifyPaymentLinkRequest{}, Response: models.PaymentResponse{}},
+	{Method: "GET", Path: "/api/v1/payment/billing-profile", Tag: "Payment", Summary: "Get the billing profile (account)", Auth: true, Response: models.BillingProfile{}},
+	{Method: "PUT", Path: "/api/v1/payment/billing-profile", Tag: "Payment", Summary: "Create or replace the billing profile (account)", Auth: true, Request: models.BillingProfileRequest{}, Response: models.BillingProfile{}},
+	{Method: "DELETE", Path: "/api/v1/payment/billing-profile", Tag: "Payment", Summary: "Delete the billing profile (account)", Auth: true},
+	{Method: "GET", Path: "/api/v1/payment/invoices", Tag: "Payment", Summary: "List paid orders as invoices, newest first (account)", Auth: true, Response: []models.Invoice{}},
+	{Method: "GET", Path: "/api/v1/payment/billing-managers", Tag: "Payment", Summary: "List the users who manage the current user's billing", Auth: true, Response: []models.BillingManager{}},
+	{Method: "PUT", Path: "/api/v1/payment/billing-managers/:manager_id", Tag: "Payment", Summary: "Make a user a billing manager of the current user's account", Auth: true, Response: models.BillingManager{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/api/v1/payment/billing-managers/:manager_id", Tag: "Payment", Summary: "Revoke a billing manager of the current user's account", Auth: true},
+	{Method: "GET", Path: "/api/v1/payment/subscriptions", Tag: "Payment", Summary: "List recurring plans (account)", Auth: true, Response: []models.Entitlement{}},
+	{Method: "POST", Path: "/api/v1/payment/subscriptions/:subscription_id/change-plan", Tag: "Payment", Summary: "Switch plans mid-cycle with proration (account)", Auth: true, Request: models.ChangePlanRequest{}, Response: models.Proration{}},
+
+	{Method: "GET", Path: "/api/v2/servers", Tag: "Servers v2", Summary: "List servers with cursor pagination (limit, cursor)", Response: models.ServerListV2{}},
+	{Method: "GET", Path: "/api/v2/servers/:server_name", Tag: "Servers v2", Summary: "Get a server by name", Response: models.ServerV2Response{}},
+	{Method: "GET", Path: "/api/v2/payment/entitlements", Tag: "Payment v2", Summary: "List the current user's entitlements with cursor pagination", Auth: true, Response: models.EntitlementListV2{}},
+	{Method: "GET", Path: "/api/v2/payment/subscriptions", Tag: "Payment v2", Summary: "List the current user's subscriptions with cursor pagination (account)", Auth: true, Response: models.EntitlementListV2{}},
+	{Method: "GET", Path: "/api/v2/blobs", Tag: "Blobs v2", Summary: "List the current user's blobs with cursor pagination (class)", Auth: true, Response: models.BlobListV2{}},
+	{Method: "GET", Path: "/api/v2/me/notifications", Tag: "Me v2", Summary: "List the current user's notifications with cursor pagination (unread)", Auth: true, Response: models.NotificationListV2{}},
+
+	{Method: "GET", Path: "/api/v1/compatibility", Tag: "Meta", Summary: "Report the schema version and supported API versions"},
+	{Method: "GET", Path: "/api/v2/compatibility", Tag: "Meta", Summary: "Report the schema version and supported API versions"},
+	{Method: "GET", Path: "/schemas", Tag: "Meta", Summary: "List the JSON Schemas published for request and response payloads"},
+	{Method: "GET", Path: "/schemas/:name", Tag: "Meta", Summary: "Get a standalone JSON Schema (draft 2020-12) for one payload, such as CreateServerRequest.json"},
+
+	{Method: "GET", Path: "/health", Tag: "Health", Summary: "Configuration health"},
+	{Method: "GET", Path: "/healthz", Tag: "Health", Summary: "Liveness probe"},
+	{Method: "GET", Path: "/readyz", Tag: "Health", Summary: "Readiness probe with dependency checks and scheduled job heartbeats", Response: []dependencyStatus{}},
+	{Method: "GET", Path: "/metrics", Tag: "Health", Summary: "Scheduled job heartbeats in the Prometheus text format"},
+}
+
+func RegisterDocs(router *gin.Engine) {
+	router.GET("/openapi.json", Cached(docsCache), getOpenAPIDocument)
+	router.GET("/docs", getSwaggerUI)
+	router.GET("/schemas", Cached(docsCache), listJSONSchemas)
+	router.GET("/schemas/:name", Cached(docsCache), getJSONSchema)
+}
+
+func buildOpenAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": structSchema(reflect.TypeOf(APIError{}), nil),
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		path := pathParamPattern.ReplaceAllString(op.Path, "{$1}")
+		operation := map[string]interface{}{
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+		}
+		if strings.HasPrefix(op.Path, "/api/v1/") {
+			operation["deprecated"] = true
+		}
+
+		parameters := []interface{}{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemaFor(reflect.TypeOf(op.Request), schemas),
+					},
+				},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaFor(reflect.TypeOf(op.Response), schemas),
+				},
+			}
+		}
+		errorResponse := map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"status": map[string]interface{}{"type": "string"},
+							"detail": map[string]interface{}{"type": "string"},
+							"error":  map[string]interface{}{"$ref": "#/components/schemas/Error"},
+						},
+					},
+				},
+			},
+		}
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default":            errorResponse,
+		}
+		if op.Auth {
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "SuperBox API",
+			"version":     "1.0.0",
+			"description": "Registry, authentication, and payment API for SuperBox MCP servers.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "Firebase ID token",
+				},
+			},
+		},
+	}
+}
+
+func operationID(op apiOperation) string {
+	parts := []string{strings.ToLower(op.Method)}
+	for _, segment := range strings.Split(strings.TrimPrefix(op.Path, "/api/v1"), "/") {
+		segment = strings.TrimPrefix(segment, ":")
+		if segment == "" {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '_' }) {
+			parts = append(parts, strings.ToUpper(word[:1])+word[1:])
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	return (&schemaBuilder{defs: schemas, refPrefix: "#/components/schemas/"}).schema(t)
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	return (&schemaBuilder{defs: schemas, refPrefix: "#/components/schemas/"}).object(t)
+}
+
+// schemaBuilder turns Go types into JSON Schema. Named structs are added to
+// defs once and referenced through refPrefix. With request set, fields are
+// required and constrained by their binding tags, as the handlers validate
+// them, rather than by whether they are omitted when empty.
+type schemaBuilder struct {
+	defs      map[string]interface{}
+	refPrefix string
+	request   bool
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if b.defs == nil || t.Name() == "" {
+			return b.object(t)
+		}
+		if _, exists := b.defs[t.Name()]; !exists {
+			b.defs[t.Name()] = map[string]interface{}{}
+			b.defs[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": b.refPrefix + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+		property := b.schema(field.Type)
+		if b.request {
+			if applyBinding(property, field.Tag.Get("binding")) {
+				required = append(required, name)
+			}
+		} else if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+		properties[name] = property
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyBinding adds the limits in a binding tag to a field's schema and
+// reports whether the tag makes the field required. Rules after dive apply
+// to elements and are left out; a $ref schema is left as it is.
+func applyBinding(schema map[string]interface{}, binding string) bool {
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		if name == "dive" {
+			break
+		}
+		if _, isRef := schema["$ref"]; isRef && name != "required" {
+			continue
+		}
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			schema["enum"] = strings.Fields(arg)
+		case "email":
+			schema["format"] = "email"
+		case "url", "http_url":
+			schema["format"] = "uri"
+		case "min", "max", "gte", "lte":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				continue
+			}
+			schema[limitKeyword(schema, name == "min" || name == "gte")] = limit
+		}
+	}
+	return required
+}
+
+// limitKeyword is the JSON Schema keyword for a min or max rule, which
+// validator applies to a string's length, a collection's size, or a number.
+func limitKeyword(schema map[string]interface{}, lower bool) string {
+	prefix, bound := "max", "maximum"
+	if lower {
+		prefix, bound = "min", "minimum"
+	}
+	switch schema["type"] {
+	case "string":
+		return prefix + "Length"
+	case "array":
+		return prefix + "Items"
+	case "object":
+		return prefix + "Properties"
+	}
+	return bound
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool, bool) {
+	if !field.IsExported() {
+		return "", false, true
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range parts[1:] {
+		if option == "omitempty" || option == "omitzero" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+func apiDocument() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPIDocument = buildOpenAPIDocument()
+	})
+	return openAPIDocument
+}
+
+func getOpenAPIDocument(c *gin.Context) {
+	c.JSON(http.StatusOK, apiDocument())
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>SuperBox API Docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>`
+
+func getSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
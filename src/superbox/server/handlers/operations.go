@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// operationFunc does the work of one operation kind. It reports progress as
+// it goes and returns the result stored on the operation.
+type operationFunc func(ctx context.Context, op models.Operation, progress func(percent int, message string)) (map[string]interface{}, error)
+
+var (
+	operations       = recordSet[storedOperation]{kind: "operation"}
+	operationRunners = map[string]operationFunc{
+		"account_export": runAccountExport,
+		"server_import":  runServerImport,
+		"registry_check": runRegistryCheck,
+	}
+)
+
+// storedOperation is an operation as the record store holds it, with the
+// input the API leaves out.
+type storedOperation struct {
+	Operation models.Operation `json:"operation"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+}
+
+func (s *storedOperation) restore() models.Operation {
+	op := s.Operation
+	op.Input = s.Input
+	return op
+}
+
+func RegisterOperations(api *gin.RouterGroup) {
+	api.GET("/operations/:operation_id", getOperation)
+}
+
+// startOperation records an operation and queues it, then answers 202 with
+// a Location the client polls.
+func startOperation(c *gin.Context, kind string, ownerID string, input interface{}) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		respondError(c, internalError("Error starting operation", err))
+		return
+	}
+
+	now := models.Now()
+	op := &models.Operation{
+		ID:        randomID("op"),
+		TenantID:  requestTenant(c).ID,
+		OwnerID:   ownerID,
+		Kind:      kind,
+		Status:    "pending",
+		Input:     data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ctx := c.Request.Context()
+	if err := operations.put(ctx, op.ID, &storedOperation{Operation: *op, Input: data}); err != nil {
+		respondError(c, internalError("Error starting operation", err))
+		return
+	}
+	if _, err := enqueueJob(ctx, "operation", map[string]string{"operation_id": op.ID}, time.Now()); err != nil {
+		respondError(c, internalError("Error starting operation", err))
+		return
+	}
+
+	c.Header("Location", "/api/v1/operations/"+op.ID)
+	c.JSON(http.StatusAccepted, models.OperationResponse{Status: "success", Operation: *op})
+}
+
+func updateOperation(ctx context.Context, operationID string, fn func(op *models.Operation)) error {
+	_, err := operations.update(ctx, operationID, func(stored *storedOperation) error {
+		fn(&stored.Operation)
+		stored.Operation.UpdatedAt = models.Now()
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// errOperationStarted is returned when claiming an operation another run
+// has already started.
+var errOperationStarted = errors.New("operation already started")
+
+// runOperationJob runs a queued operation once. Failures are recorded on the
+// operation rather than retried, since a rerun could repeat side effects.
+func runOperationJob(job *models.Job) error {
+	operationID := job.Payload["operation_id"]
+
+	// Moving the operation to running claims it, so a job picked up twice
+	// does not run it twice.
+	stored, err := operations.update(context.Background(), operationID, func(stored *storedOperation) error {
+		if stored.Operation.Status != "pending" {
+			return errOperationStarted
+		}
+		stored.Operation.Status = "running"
+		stored.Operation.UpdatedAt = models.Now()
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, errOperationStarted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshot := stored.restore()
+
+	ctx := withTenant(context.Background(), tenantByID(snapshot.TenantID))
+	runner, exists := operationRunners[snapshot.Kind]
+	if !exists {
+		return updateOperation(ctx, operationID, func(op *models.Operation) {
+			op.Status = "failed"
+			op.Error = "unknown operation kind " + snapshot.Kind
+		})
+	}
+
+	result, err := runner(ctx, snapshot, func(percent int, message string) {
+		// Progress is advisory; a lost update is corrected by the next one.
+		updateOperation(ctx, operationID, func(op *models.Operation) {
+			op.Progress = min(max(percent, 0), 100)
+			op.Message = message
+		})
+	})
+
+	return updateOperation(ctx, operationID, func(op *models.Operation) {
+		op.CompletedAt = models.Now()
+		op.Result = result
+		if err != nil {
+			op.Status = "failed"
+			op.Error = err.Error()
+			return
+		}
+		op.Status = "succeeded"
+		op.Progress = 100
+	})
+}
+
+func getOperation(c *gin.Context) {
+	if _, ok := authenticatedUser(c); !ok {
+		return
+	}
+
+	operationID := c.Param("operation_id")
+	stored, err := operations.get(c.Request.Context(), operationID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error loading operation", err))
+		return
+	}
+	exists := err == nil
+	var snapshot models.Operation
+	if exists {
+		snapshot = stored.Operation
+		if snapshot.Result == nil {
+			snapshot.Result = map[string]interface{}{}
+		}
+	}
+
+	if !exists || snapshot.TenantID != requestTenant(c).ID || !ownsResource(c, snapshot.OwnerID) {
+		respondError(c, newAPIError(http.StatusNotFound, "Operation '"+operationID+"' not found"))
+		return
+	}
+
+	if blobID, _ := snapshot.Result["blob_id"].(string); blobID != "" {
+		blob, err := getBlobCopy(c.Request.Context(), blobID)
+		if err != nil {
+			respondError(c, internalError("Error loading export", err))
+			return
+		}
+		if blob != nil {
+			url, err := blobDownloadLink(c.Request.Context(), *blob)
+			if err != nil {
+				respondError(c, internalError("Error creating download link", err))
+				return
+			}
+			snapshot.Result["download_url"] = url
+		} else {
+			snapshot.Result["expired"] = true
+		}
+	}
+
+	if snapshot.Status == "pending" || snapshot.Status == "running" {
+		c.Header("Retry-After", "5")
+	}
+	c.JSON(http.StatusOK, models.OperationResponse{Status: "success", Operation: snapshot})
+}
+
+func exportAccount(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	startOperation(c, "account_export", userID, nil)
+}
+
+// runAccountExport gathers everything the platform holds about the user
+// into one JSON document stored as an export blob.
+func runAccountExport(ctx context.Context, op models.Operation, progress func(int, string)) (map[string]interface{}, error) {
+	progress(10, "Collecting purchases")
+	export := map[string]interface{}{
+		"user_id":     op.OwnerID,
+		"exported_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	collect := func(name string, records interface{}, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to collect %s: %w", name, err)
+		}
+		export[name] = records
+		return nil
+	}
+	notifications, _, err := userNotifications(ctx, op.OwnerID, false)
+	if err := collect("notifications", notifications, err); err != nil {
+		return nil, err
+	}
+	entitlements, err := userEntitlements(ctx, op.TenantID, op.OwnerID)
+	if err := collect("entitlements", entitlements, err); err != nil {
+		return nil, err
+	}
+	orders, err := userOrders(ctx, op.OwnerID)
+	if err := collect("orders", orders, err); err != nil {
+		return nil, err
+	}
+	blobs, err := userBlobs(ctx, op.TenantID, op.OwnerID, "")
+	if err := collect("blobs", blobs, err); err != nil {
+		return nil, err
+	}
+	profile, err := getBillingProfileCopy(ctx, op.OwnerID)
+	if err := collect("billing_profile", profile, err); err != nil {
+		return nil, err
+	}
+	installed, err := userInstalledServers(ctx, op.TenantID, op.OwnerID)
+	if err := collect("installed_servers", installed, err); err != nil {
+		return nil, err
+	}
+	webhooks, err := ownerWebhooks(ctx, op.OwnerID)
+	if err := collect("webhooks", webhooks, err); err != nil {
+		return nil, err
+	}
+
+	progress(40, "Collecting published servers")
+	servers, err := registryServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	owned := []models.Server{}
+	for _, server := range servers {
+		if server.Meta.OwnerID == op.OwnerID {
+			owned = append(owned, server)
+		}
+	}
+	export["servers"] = owned
+
+	progress(80, "Writing export")
+	body, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	blob, err := putBlob(ctx, "export", op.OwnerID, body, "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to store export: %w", err)
+	}
+	return map[string]interface{}{"blob_id": blob.ID, "size": blob.Size}, nil
+}
+
+func importServers(c *gin.Context) {
+	var req models.ImportServersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	for i, server := range req.Servers {
+		if !serverNamePattern.MatchString(server.Name) {
+			respondError(c, invalidSlug(fmt.Sprintf("servers[%d].name", i)))
+			return
+		}
+		if err := validatePricing(server.Pricing); err != nil {
+			apiErr := validationFailed()
+			apiErr.Fields = []FieldError{{Field: fmt.Sprintf("servers[%d].pricing", i), Message: err.Error()}}
+			respondError(c, apiErr)
+			return
+		}
+		if server.BundleID != "" {
+			apiErr := validationFailed()
+			apiErr.Fields = []FieldError{{Field: fmt.Sprintf("servers[%d].bundle_id", i), Message: "bundles are attached when publishing one server, not in an import"}}
+			respondError(c, apiErr)
+			return
+		}
+	}
+
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	for _, server := range req.Servers {
+		if !requireKeyServer(c, server.Name) {
+			return
+		}
+	}
+	if !consumeQuota(c, "publish") {
+		return
+	}
+	startOperation(c, "server_import", userID, req)
+}
+
+// runServerImport publishes each server in turn. One bad entry does not stop
+// the rest; the result lists what was imported, skipped, and failed.
+func runServerImport(ctx context.Context, op models.Operation, progress func(int, string)) (map[string]interface{}, error) {
+	var req models.ImportServersRequest
+	if err := json.Unmarshal(op.Input, &req); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(req.Servers))
+	for _, item := range req.Servers {
+		names = append(names, item.Name)
+	}
+	found, err := fetchServers(ctx, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up servers: %w", err)
+	}
+
+	imported := []string{}
+	skipped := []string{}
+	failed := []map[string]string{}
+	for i, item := range req.Servers {
+		progress(i*100/len(req.Servers), "Importing "+item.Name)
+
+		existing, exists := found[item.Name]
+		if exists && (!req.Overwrite || existing.Meta.OwnerID != op.OwnerID) {
+			skipped = append(skipped, item.Name)
+			continue
+		}
+		if !exists {
+			_, reserved, err := reservedSlug(ctx, item.Name)
+			if err == nil && !reserved {
+				err = dropServerRedirect(ctx, item.Name)
+			}
+			if err != nil {
+				failed = append(failed, map[string]string{"name": item.Name, "error": "could not be saved"})
+				continue
+			}
+			if reserved {
+				skipped = append(skipped, item.Name)
+				continue
+			}
+		}
+
+		server := newServerRecord(item, op.OwnerID, time.Now())
+		if exists {
+			server.Versions = append(existing.Versions, server.Versions...)
+			server.Meta.CreatedAt = existing.Meta.CreatedAt
+		}
+		if err := saveServer(ctx, server); err != nil {
+			failed = append(failed, map[string]string{"name": item.Name, "error": "could not be saved"})
+			continue
+		}
+
+		event := "server.published"
+		if exists {
+			event = "server.updated"
+		}
+		recordAppliedPrice(ctx, op.TenantID, item.Name, item.Author, existing.Pricing, item.Pricing)
+		emit(ctx, event, op.TenantID, serverSubject(op.TenantID, item.Name), map[string]interface{}{"server": server})
+		imported = append(imported, item.Name)
+	}
+
+	return map[string]interface{}{
+		"imported": imported,
+		"skipped":  skipped,
+		"failed":   failed,
+	}, nil
+}
@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+)
+
+var (
+	// orders are keyed by the provider's order ID and listed per buyer.
+	orders = recordSet[models.Order]{
+		kind:  "order",
+		group: func(order *models.Order) string { return order.UserID },
+	}
+	// entitlements are keyed by entitlementKey, so a user holds at most one
+	// per server, and listed per user.
+	entitlements = recordSet[storedEntitlement]{
+		kind:  "entitlement",
+		group: func(stored *storedEntitlement) string { return stored.Entitlement.UserID },
+	}
+)
+
+// storedEntitlement is an entitlement as the record store holds it, with
+// the saved payment method the API leaves out.
+type storedEntitlement struct {
+	Entitlement     models.Entitlement `json:"entitlement"`
+	CustomerID      string             `json:"customer_id,omitempty"`
+	PaymentMethodID string             `json:"payment_method_id,omitempty"`
+}
+
+func storeEntitlement(entitlement *models.Entitlement) storedEntitlement {
+	return storedEntitlement{
+		Entitlement:     *entitlement,
+		CustomerID:      entitlement.CustomerID,
+		PaymentMethodID: entitlement.PaymentMethodID,
+	}
+}
+
+func (s *storedEntitlement) restore() *models.Entitlement {
+	entitlement := s.Entitlement
+	entitlement.CustomerID = s.CustomerID
+	entitlement.PaymentMethodID = s.PaymentMethodID
+	return &entitlement
+}
+
+// subscriptionKey points a subscription ID at the entitlement record
+// holding it. The ID survives regrants, so the pointer is written once.
+func subscriptionKey(subscriptionID string) string {
+	return "subscription:" + subscriptionID
+}
+
+// orderTransitions is the order state machine. Provider orders move
+// created → paid → verified → fulfilled and may end failed or refunded.
+// Orders held by risk checks are approved or rejected instead, and an
+// approved hold is released by the buyer's next order.
+var orderTransitions = map[string][]string{
+	"created":   {"paid", "failed"},
+	"paid":      {"verified", "failed", "refunded"},
+	"verified":  {"fulfilled", "failed", "refunded"},
+	"fulfilled": {"refunded"},
+	"held":      {"approved", "rejected"},
+	"approved":  {"released"},
+}
+
+var (
+	errOrderNotFound = errors.New("order not found")
+	errOrderState    = errors.New("order cannot be settled")
+	errOrderReplayed = errors.New("order already fulfilled")
+)
+
+// transitionOrder moves an order to status and records the step.
+func transitionOrder(order *models.Order, status string, detail string) error {
+	if !slices.Contains(orderTransitions[order.Status], status) {
+		return fmt.Errorf("%w: order %s is %s and cannot become %s", errOrderState, order.ID, order.Status, status)
+	}
+	order.Transitions = append(order.Transitions, models.OrderTransition{
+		From:   order.Status,
+		To:     status,
+		Detail: detail,
+		At:     models.Now(),
+	})
+	order.Status = status
+	return nil
+}
+
+// recordRefund adds a refund to the order it was paid against, and marks
+// the order refunded once nothing of it is left.
+func recordRefund(ctx context.Context, orderID string, amount float64) error {
+	_, err := orders.update(ctx, orderID, func(order *models.Order) error {
+		order.RefundedAmount = roundAmount(order.RefundedAmount + amount)
+		if order.RefundedAmount < order.Amount || order.Status == "refunded" {
+			return nil
+		}
+		return transitionOrder(order, "refunded", fmt.Sprintf("refunded %.2f", order.RefundedAmount))
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func orderInTenant(order *models.Order, tenantID string) bool {
+	orderTenant := order.TenantID
+	if orderTenant == "" {
+		orderTenant = config.DefaultTenantID
+	}
+	return orderTenant == tenantID
+}
+
+func entitlementKey(tenantID string, userID string, serverName string) string {
+	if tenantID == "" {
+		tenantID = config.DefaultTenantID
+	}
+	return tenantID + "/" + userID + "/" + serverName
+}
+
+func entitlementTenant(entitlement *models.Entitlement) string {
+	if entitlement.TenantID == "" {
+		return config.DefaultTenantID
+	}
+	return entitlement.TenantID
+}
+
+func periodLength(period string) time.Duration {
+	switch period {
+	case "monthly":
+		return 30 * 24 * time.Hour
+	case "yearly":
+		return 365 * 24 * time.Hour
+	}
+	return 0
+}
+
+func storeOrder(ctx context.Context, order *models.Order) error {
+	return orders.put(ctx, order.ID, order)
+}
+
+// getOrderCopy returns an order, or nil when there is none with that ID.
+func getOrderCopy(ctx context.Context, orderID string) (*models.Order, error) {
+	order, err := orders.get(ctx, orderID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return order, err
+}
+
+// updateOrder changes an order in place, returning errOrderNotFound when
+// there is none with that ID.
+func updateOrder(ctx context.Context, orderID string, fn func(order *models.Order) error) (*models.Order, error) {
+	order, err := orders.update(ctx, orderID, fn)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errOrderNotFound
+	}
+	return order, err
+}
+
+// markOrderPaid records a payment the provider captured for an order that
+// has not been verified yet.
+func markOrderPaid(ctx context.Context, orderID string, paymentID string, detail string) error {
+	_, err := updateOrder(ctx, orderID, func(order *models.Order) error {
+		return payOrder(order, paymentID, detail)
+	})
+	return err
+}
+
+func payOrder(order *models.Order, paymentID string, detail string) error {
+	if order.PaymentID != "" && order.PaymentID != paymentID {
+		return fmt.Errorf("%w: order %s was paid by another payment", errOrderState, order.ID)
+	}
+	if order.Status != "created" {
+		return nil
+	}
+	if err := transitionOrder(order, "paid", detail); err != nil {
+		return err
+	}
+	order.PaymentID = paymentID
+	order.PaidAt = models.Now()
+	return nil
+}
+
+// failOrder marks an order that can no longer be paid as failed.
+func failOrder(ctx context.Context, orderID string, detail string) error {
+	_, err := updateOrder(ctx, orderID, func(order *models.Order) error {
+		if order.Status != "created" {
+			return errOrderState
+		}
+		return transitionOrder(order, "failed", detail)
+	})
+	if errors.Is(err, errOrderNotFound) || errors.Is(err, errOrderState) {
+		return nil
+	}
+	return err
+}
+
+// settleOrder takes an order with a verified payment through the rest of
+// the state machine and grants its entitlement. Only the verification that
+// moves the order to fulfilled grants it, so concurrent verifications grant
+// it once. Settling a fulfilled order again with the same payment is a
+// replay: it changes nothing, returns the current entitlement, and reports
+// replayed. An order left fulfilled without its entitlement by a crash
+// between the two writes is picked up by reconciliation.
+func settleOrder(ctx context.Context, orderID string, paymentID string, verifiedBy string) (entitlement *models.Entitlement, replayed bool, err error) {
+	var settled models.Order
+	order, err := updateOrder(ctx, orderID, func(order *models.Order) error {
+		if err := payOrder(order, paymentID, "payment "+paymentID); err != nil {
+			return err
+		}
+		settled = *order
+		if order.Status == "fulfilled" {
+			return errOrderReplayed
+		}
+		if order.Status == "paid" {
+			if err := transitionOrder(order, "verified", verifiedBy); err != nil {
+				return err
+			}
+		}
+		return transitionOrder(order, "fulfilled", "")
+	})
+	if errors.Is(err, errOrderReplayed) {
+		existing, err := entitlements.get(ctx, entitlementKey(settled.TenantID, settled.UserID, settled.ServerName))
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		return existing.restore(), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	entitlement, err = grantEntitlement(ctx, order, paymentID)
+	return entitlement, false, err
+}
+
+// grantEntitlement creates, renews, or changes the plan of the entitlement
+// an order pays for.
+func grantEntitlement(ctx context.Context, order *models.Order, paymentID string) (*models.Entitlement, error) {
+	now := models.Now()
+	key := entitlementKey(order.TenantID, order.UserID, order.ServerName)
+
+	for {
+		stored, err := entitlements.update(ctx, key, func(stored *storedEntitlement) error {
+			existing := stored.restore()
+			*stored = storeEntitlement(regrantEntitlement(existing, order, paymentID, now))
+			return nil
+		})
+		if err == nil {
+			return stored.restore(), nil
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+
+		entitlement := regrantEntitlement(nil, order, paymentID, now)
+		fresh := storeEntitlement(entitlement)
+		created, err := entitlements.create(ctx, key, &fresh)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			if err := recordStore.Set(ctx, subscriptionKey(entitlement.ID), []byte(key), 0); err != nil {
+				return nil, err
+			}
+			return entitlement, nil
+		}
+		// Another order granted the entitlement first; apply this one over it.
+	}
+}
+
+// regrantEntitlement applies an order to the entitlement it pays for, or
+// to none when existing is nil, and returns the result.
+func regrantEntitlement(existing *models.Entitlement, order *models.Order, paymentID string, now time.Time) *models.Entitlement {
+	if order.Kind == "renewal" && existing != nil {
+		start := existing.ExpiresAt
+		if start.Before(now) {
+			start = now
+		}
+		existing.ExpiresAt = start.Add(periodLength(existing.Period))
+		existing.Status = "active"
+		existing.Dunning = nil
+		return existing
+	}
+	if order.Kind == "plan_change" && existing != nil {
+		existing.Plan = order.Plan
+		existing.Period = order.Period
+		existing.Amount = order.PlanAmount
+		existing.OrderID = order.ID
+		if order.ResetCycle {
+			existing.GrantedAt = now
+			existing.ExpiresAt = now.Add(periodLength(order.Period))
+		}
+		return existing
+	}
+
+	entitlement := &models.Entitlement{
+		ID:         randomID("sub"),
+		TenantID:   order.TenantID,
+		UserID:     order.UserID,
+		ServerName: order.ServerName,
+		Plan:       order.Plan,
+		Period:     order.Period,
+		Amount:     order.Amount,
+		Currency:   order.Currency,
+		Provider:   order.Provider,
+		OrderID:    order.ID,
+		PaymentID:  paymentID,
+		GrantedAt:  now,
+		Status:     "active",
+	}
+	if existing != nil {
+		entitlement.ID = existing.ID
+		entitlement.CustomerID = existing.CustomerID
+		entitlement.PaymentMethodID = existing.PaymentMethodID
+	}
+	if length := periodLength(order.Period); length > 0 {
+		entitlement.ExpiresAt = now.Add(length)
+	}
+	return entitlement
+}
+
+// subscriptionByID returns the entitlement with a subscription ID, or nil
+// when there is none.
+func subscriptionByID(ctx context.Context, subscriptionID string) (*models.Entitlement, error) {
+	key, err := recordStore.Get(ctx, subscriptionKey(subscriptionID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored, err := entitlements.get(ctx, string(key))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.restore(), nil
+}
+
+// updateSubscription changes the entitlement with a subscription ID and
+// returns it, or nil when there is none. update may run more than once.
+func updateSubscription(ctx context.Context, subscriptionID string, update func(*models.Entitlement)) (*models.Entitlement, error) {
+	key, err := recordStore.Get(ctx, subscriptionKey(subscriptionID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored, err := entitlements.update(ctx, string(key), func(stored *storedEntitlement) error {
+		entitlement := stored.restore()
+		update(entitlement)
+		*stored = storeEntitlement(entitlement)
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.restore(), nil
+}
+
+func findSubscription(ctx context.Context, tenantID string, userID string, subscriptionID string) (*models.Entitlement, error) {
+	entitlement, err := subscriptionByID(ctx, subscriptionID)
+	if err != nil || entitlement == nil {
+		return nil, err
+	}
+	if entitlement.UserID != userID || entitlementTenant(entitlement) != tenantID {
+		return nil, nil
+	}
+	return entitlement, nil
+}
+
+func applyPlanChange(ctx context.Context, subscriptionID string, plan *models.PricingPlan, resetCycle bool) (*models.Entitlement, error) {
+	now := models.Now()
+	return updateSubscription(ctx, subscriptionID, func(entitlement *models.Entitlement) {
+		entitlement.Plan = plan.Name
+		entitlement.Period = plan.Period
+		entitlement.Amount = plan.Amount
+		if resetCycle {
+			entitlement.GrantedAt = now
+			entitlement.ExpiresAt = now.Add(periodLength(plan.Period))
+		}
+	})
+}
+
+// userEntitlements lists a user's entitlements in one tenant, or in every
+// tenant when tenantID is empty.
+func userEntitlements(ctx context.Context, tenantID string, userID string) ([]models.Entitlement, error) {
+	stored, err := entitlements.listGroup(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []models.Entitlement{}
+	for i := range stored {
+		entitlement := stored[i].restore()
+		if tenantID == "" || entitlementTenant(entitlement) == tenantID {
+			result = append(result, *entitlement)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GrantedAt.After(result[j].GrantedAt)
+	})
+	return result, nil
+}
+
+func recentOrderCount(ctx context.Context, userID string, since time.Time) (int, error) {
+	placed, err := orders.listGroup(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, order := range placed {
+		if !order.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func userOrders(ctx context.Context, userID string) ([]models.Order, error) {
+	result, err := orders.listGroup(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func ordersWithStatus(ctx context.Context, status string) ([]models.Order, error) {
+	all, err := orders.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []models.Order{}
+	for _, order := range all {
+		if order.Status == status {
+			result = append(result, order)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// reviewHeldOrder approves or rejects a held order and returns it, or nil
+// when there is no held order with that ID.
+func reviewHeldOrder(ctx context.Context, orderID string, status string) (*models.Order, error) {
+	order, err := updateOrder(ctx, orderID, func(order *models.Order) error {
+		if order.Status != "held" {
+			return errOrderState
+		}
+		return transitionOrder(order, status, "reviewed by an admin")
+	})
+	if errors.Is(err, errOrderNotFound) || errors.Is(err, errOrderState) {
+		return nil, nil
+	}
+	return order, err
+}
+
+func consumeApprovedHold(ctx context.Context, tenantID string, userID string, serverName string) (bool, error) {
+	placed, err := orders.listGroup(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range placed {
+		if candidate.TenantID != tenantID || candidate.ServerName != serverName || candidate.Status != "approved" {
+			continue
+		}
+		_, err := updateOrder(ctx, candidate.ID, func(order *models.Order) error {
+			if order.Status != "approved" {
+				return errOrderState
+			}
+			return transitionOrder(order, "released", "")
+		})
+		if err == nil {
+			return true, nil
+		}
+		// Another order released this hold first; look for another.
+		if !errors.Is(err, errOrderNotFound) && !errors.Is(err, errOrderState) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// serverEntitlement finds the user's active entitlement to a server under
+// its name or, for purchases made before a rename, a name it was renamed
+// from. Entitlements and their orders keep the name they were bought under.
+func serverEntitlement(ctx context.Context, tenantID string, userID string, server models.Server) (*models.Entitlement, error) {
+	for _, name := range append([]string{server.Name}, server.PreviousNames...) {
+		entitlement, err := activeEntitlement(ctx, tenantID, userID, name)
+		if entitlement != nil || err != nil {
+			return entitlement, err
+		}
+	}
+	return nil, nil
+}
+
+func activeEntitlement(ctx context.Context, tenantID string, userID string, serverName string) (*models.Entitlement, error) {
+	stored, err := entitlements.get(ctx, entitlementKey(tenantID, userID, serverName))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entitlement := stored.restore()
+	now := models.Now()
+	if entitlement.Status == "expired" {
+		return nil, nil
+	}
+	if entitlement.Status == "past_due" && entitlement.Dunning != nil && entitlement.Dunning.GraceUntil.After(now) {
+		return entitlement, nil
+	}
+	if !entitlement.ExpiresAt.IsZero() && !entitlement.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return entitlement, nil
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+)
+
+const (
+	serverScope  = "server"
+	accountScope = "account"
+)
+
+// webhookEvents lists every event the outbox can deliver and whether it is
+// published to endpoints registered on a server or on a user's account.
+var webhookEvents = map[string]string{
+	"purchase.completed":        serverScope,
+	"refund.created":            serverScope,
+	"subscription.plan_changed": serverScope,
+	"subscription.renewed":      serverScope,
+	"subscription.past_due":     serverScope,
+	"subscription.expired":      serverScope,
+	"server.published":          serverScope,
+	"server.updated":            serverScope,
+	"server.deleted":            serverScope,
+	"auth.login":                accountScope,
+	"auth.profile_updated":      accountScope,
+	"auth.password_changed":     accountScope,
+	"notification.created":      accountScope,
+}
+
+var (
+	// publisherWebhooks are listed by what they subscribe to: a server's
+	// subject or an account's owner.
+	publisherWebhooks = recordSet[storedWebhook]{
+		kind:  "webhook",
+		group: func(stored *storedWebhook) string { return webhookGroup(&stored.Webhook) },
+	}
+	webhookDeliveries = recordSet[models.WebhookDelivery]{
+		kind:  "webhook_delivery",
+		group: func(delivery *models.WebhookDelivery) string { return delivery.WebhookID },
+	}
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// storedWebhook is a webhook as the record store holds it, with its signing
+// secrets sealed under the session keys.
+type storedWebhook struct {
+	Webhook     models.PublisherWebhook `json:"webhook"`
+	SigningKeys []storedWebhookKey      `json:"signing_keys"`
+}
+
+type storedWebhookKey struct {
+	Key    models.WebhookSigningKey `json:"key"`
+	Secret string                   `json:"secret"`
+}
+
+func sealWebhook(webhook *models.PublisherWebhook) (*storedWebhook, error) {
+	stored := &storedWebhook{Webhook: redactedWebhook(webhook), SigningKeys: []storedWebhookKey{}}
+	for _, key := range webhook.SigningKeys {
+		secret, err := sealValue([]byte(key.Secret), []byte(webhook.ID+"/"+key.ID))
+		if err != nil {
+			return nil, err
+		}
+		stored.SigningKeys = append(stored.SigningKeys, storedWebhookKey{Key: key, Secret: secret})
+	}
+	return stored, nil
+}
+
+func (s *storedWebhook) open() (*models.PublisherWebhook, error) {
+	webhook := s.Webhook
+	webhook.SigningKeys = []models.WebhookSigningKey{}
+	for _, stored := range s.SigningKeys {
+		secret, err := openValue(stored.Secret, []byte(webhook.ID+"/"+stored.Key.ID))
+		if err != nil {
+			return nil, err
+		}
+		key := stored.Key
+		key.Secret = string(secret)
+		webhook.SigningKeys = append(webhook.SigningKeys, key)
+	}
+	if len(webhook.SigningKeys) > 0 {
+		webhook.Secret = webhook.SigningKeys[0].Secret
+	}
+	return &webhook, nil
+}
+
+func webhookGroup(webhook *models.PublisherWebhook) string {
+	if webhookScope(webhook) == serverScope {
+		return "server:" + serverSubject(webhook.TenantID, webhook.ServerName)
+	}
+	return "account:" + webhook.OwnerID
+}
+
+func saveWebhook(ctx context.Context, webhook *models.PublisherWebhook) error {
+	stored, err := sealWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	return publisherWebhooks.put(ctx, webhook.ID, stored)
+}
+
+// getWebhook returns a webhook with its secrets, or nil when there is none
+// with that ID.
+func getWebhook(ctx context.Context, webhookID string) (*models.PublisherWebhook, error) {
+	stored, err := publisherWebhooks.get(ctx, webhookID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored.open()
+}
+
+// updateWebhook changes a webhook in place and returns it, or nil when
+// there is none with that ID. fn may run more than once.
+func updateWebhook(ctx context.Context, webhookID string, fn func(webhook *models.PublisherWebhook) error) (*models.PublisherWebhook, error) {
+	var updated *models.PublisherWebhook
+	_, err := publisherWebhooks.update(ctx, webhookID, func(stored *storedWebhook) error {
+		webhook, err := stored.open()
+		if err != nil {
+			return err
+		}
+		if err := fn(webhook); err != nil {
+			return err
+		}
+		sealed, err := sealWebhook(webhook)
+		if err != nil {
+			return err
+		}
+		*stored = *sealed
+		updated = webhook
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// groupWebhooks lists the webhooks in one webhookGroup, with their secrets.
+func groupWebhooks(ctx context.Context, group string) ([]*models.PublisherWebhook, error) {
+	stored, err := publisherWebhooks.listGroup(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*models.PublisherWebhook, 0, len(stored))
+	for i := range stored {
+		webhook, err := stored[i].open()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	return result, nil
+}
+
+// ownerWebhooks lists every webhook a user registered, on their account or
+// their servers, with their secrets.
+func ownerWebhooks(ctx context.Context, ownerID string) ([]*models.PublisherWebhook, error) {
+	stored, err := publisherWebhooks.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []*models.PublisherWebhook{}
+	for i := range stored {
+		if stored[i].Webhook.OwnerID != ownerID {
+			continue
+		}
+		webhook, err := stored[i].open()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	return result, nil
+}
+
+// serverSubject names a server across tenants, since each tenant has its own
+// catalog and two tenants may publish servers with the same name.
+func serverSubject(tenantID string, serverName string) string {
+	if tenantID == "" {
+		tenantID = config.DefaultTenantID
+	}
+	return tenantID + "/" + serverName
+}
+
+func webhookScope(webhook *models.PublisherWebhook) string {
+	if webhook.ServerName == "" {
+		return accountScope
+	}
+	return serverScope
+}
+
+// publishEvent records one delivery per subscribed endpoint and queues it.
+// Server events go to the server's endpoints; account events go to the
+// account-level endpoints of subject. Server subjects come from serverSubject.
+func publishEvent(ctx context.Context, event string, subject string, data map[string]interface{}) error {
+	scope := webhookEvents[event]
+	if scope == "" {
+		return nil
+	}
+	webhooks, err := groupWebhooks(ctx, scope+":"+subject)
+	if err != nil {
+		return err
+	}
+	targets := []*models.PublisherWebhook{}
+	for _, webhook := range webhooks {
+		if subscribesTo(webhook, event) {
+			targets = append(targets, webhook)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	eventID := randomID("evt")
+	payload, _ := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"type":       event,
+		"created_at": models.Now(),
+		"data":       data,
+	})
+	for _, webhook := range targets {
+		if _, err := queueDelivery(ctx, webhook.ID, event, eventID, string(payload), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queueDelivery(ctx context.Context, webhookID string, event string, eventID string, payload string, replayOf string) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		ID:        randomID("whd"),
+		WebhookID: webhookID,
+		Event:     event,
+		EventID:   eventID,
+		Payload:   payload,
+		Status:    "pending",
+		ReplayOf:  replayOf,
+		CreatedAt: models.Now(),
+	}
+	if err := webhookDeliveries.put(ctx, delivery.ID, delivery); err != nil {
+		return nil, err
+	}
+	if _, err := enqueueJob(ctx, "webhook_delivery", map[string]string{"delivery_id": delivery.ID}, time.Now()); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// getDelivery returns a delivery, or nil when there is none with that ID.
+func getDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	delivery, err := webhookDeliveries.get(ctx, deliveryID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// deliveryGoneError is returned when replaying a delivery, or one whose
+// webhook, that no longer exists.
+type deliveryGoneError struct {
+	message string
+}
+
+func (e *deliveryGoneError) Error() string {
+	return e.message
+}
+
+// replayDelivery re-sends a past delivery's payload unchanged, so receivers
+// can deduplicate on the event id.
+func replayDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	original, err := getDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, &deliveryGoneError{fmt.Sprintf("delivery '%s' not found", deliveryID)}
+	}
+	webhook, err := getWebhook(ctx, original.WebhookID)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		return nil, &deliveryGoneError{fmt.Sprintf("webhook '%s' has been deleted", original.WebhookID)}
+	}
+	return queueDelivery(ctx, original.WebhookID, original.Event, original.EventID, original.Payload, original.ID)
+}
+
+func subscribesTo(webhook *models.PublisherWebhook, event string) bool {
+	for _, subscribed := range webhook.Events {
+		if subscribed == event || subscribed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func signWebhookPayload(secret string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingKeysAt returns the keys that sign a delivery sent at timestamp,
+// active key first. Webhooks created before signing keys existed sign with
+// their single secret.
+func signingKeysAt(webhook *models.PublisherWebhook, timestamp int64) []models.WebhookSigningKey {
+	if len(webhook.SigningKeys) == 0 {
+		return []models.WebhookSigningKey{{WebhookID: webhook.ID, Secret: webhook.Secret, Status: "active"}}
+	}
+	keys := []models.WebhookSigningKey{}
+	for _, key := range webhook.SigningKeys {
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(time.Unix(timestamp, 0)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// webhookSignature builds the X-SuperBox-Signature value: the timestamp and
+// one v1 signature per unexpired key, active key first, so receivers still
+// holding a rotated-out secret keep verifying during the grace period.
+func webhookSignature(webhook *models.PublisherWebhook, timestamp int64, payload string) string {
+	signature := fmt.Sprintf("t=%d", timestamp)
+	for _, key := range signingKeysAt(webhook, timestamp) {
+		signature += ",v1=" + signWebhookPayload(key.Secret, timestamp, payload)
+	}
+	return signature
+}
+
+func runWebhookDeliveryJob(job *models.Job) error {
+	ctx := context.Background()
+	deliveryID := job.Payload["delivery_id"]
+
+	delivery, err := getDelivery(ctx, deliveryID)
+	if err != nil || delivery == nil {
+		return err
+	}
+	webhook, err := getWebhook(ctx, delivery.WebhookID)
+	if err != nil || webhook == nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	signature := webhookSignature(webhook, timestamp, delivery.Payload)
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return recordDelivery(ctx, deliveryID, 0, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SuperBox-Event", delivery.Event)
+	req.Header.Set("X-SuperBox-Delivery", deliveryID)
+	req.Header.Set("X-SuperBox-Signature", signature)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return recordDelivery(ctx, deliveryID, 0, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return recordDelivery(ctx, deliveryID, resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode))
+	}
+	return recordDelivery(ctx, deliveryID, resp.StatusCode, nil)
+}
+
+func recordDelivery(ctx context.Context, deliveryID string, statusCode int, deliveryErr error) error {
+	_, err := webhookDeliveries.update(ctx, deliveryID, func(delivery *models.WebhookDelivery) error {
+		delivery.Attempts++
+		delivery.ResponseCode = statusCode
+		if deliveryErr != nil {
+			delivery.LastError = deliveryErr.Error()
+			delivery.Status = "failed"
+			if delivery.Attempts < maxJobAttempts {
+				delivery.Status = "retrying"
+				delivery.NextAttemptAt = models.Timestamp(time.Now().Add(jobRetryDelay(delivery.Attempts)))
+			}
+			return nil
+		}
+
+		delivery.Status = "delivered"
+		delivery.LastError = ""
+		delivery.NextAttemptAt = time.Time{}
+		delivery.DeliveredAt = models.Now()
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return deliveryErr
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 
 	"superbox/server/models"
@@ -15,12 +19,35 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-var razorpayKeyID string
-var razorpayKeySecret string
+const maxDonationAmount = 1000000
 
-func init() {
-	razorpayKeyID = os.Getenv("RAZORPAY_KEY_ID")
-	razorpayKeySecret = os.Getenv("RAZORPAY_KEY_SECRET")
+// currencyExponents lists the currencies whose smallest unit is not a
+// hundredth. Providers take amounts in that unit, so ¥500 is sent as 500.
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "JPY": 0, "KMF": 0, "KRW": 0, "MGA": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// minorUnits converts an amount to the currency's smallest unit.
+func minorUnits(amount float64, currency string) int {
+	return int(math.Round(amount * math.Pow10(currencyExponent(currency))))
+}
+
+func fromMinorUnits(amount float64, currency string) float64 {
+	return amount / math.Pow10(currencyExponent(currency))
+}
+
+// roundToCurrency rounds an amount to what the currency can charge.
+func roundToCurrency(amount float64, currency string) float64 {
+	return fromMinorUnits(float64(minorUnits(amount, currency)), currency)
 }
 
 func RegisterPayment(api *gin.RouterGroup) {
@@ -29,100 +56,457 @@ func RegisterPayment(api *gin.RouterGroup) {
 		payment.POST("/create-order", createOrder)
 		payment.POST("/verify-payment", verifyPayment)
 		payment.GET("/payment-status/:payment_id", getPaymentStatus)
+		payment.GET("/entitlements", listEntitlements)
+		payment.POST("/entitlements/batch", checkEntitlements)
+		payment.POST("/payment-links/:link_id/verify", verifyPaymentLink)
+		payment.GET("/billing-profile", getBillingProfile)
+		payment.PUT("/billing-profile", putBillingProfile)
+		payment.DELETE("/billing-profile", deleteBillingProfile)
+		payment.GET("/invoices", listInvoices)
+		payment.GET("/billing-managers", listBillingManagers)
+		payment.PUT("/billing-managers/:manager_id", grantBillingManager)
+		payment.DELETE("/billing-managers/:manager_id", revokeBillingManager)
+		payment.GET("/subscriptions", listSubscriptions)
+		payment.POST("/subscriptions/:subscription_id/change-plan", changePlan)
+	}
+}
+
+func resolvePlan(pricing *models.Pricing, planName string, amount float64) (*models.PricingPlan, error) {
+	if pricing.Mode == "donation" {
+		if math.IsNaN(amount) || math.IsInf(amount, 0) || amount <= 0 {
+			return nil, fmt.Errorf("a positive amount is required for donations")
+		}
+		if amount < pricing.MinimumAmount {
+			return nil, fmt.Errorf("amount must be at least %.2f %s", pricing.MinimumAmount, strings.ToUpper(pricing.Currency))
+		}
+		if amount > maxDonationAmount {
+			return nil, fmt.Errorf("amount must not exceed %.2f", float64(maxDonationAmount))
+		}
+		return &models.PricingPlan{Name: "donation", Amount: roundToCurrency(amount, pricing.Currency), Period: "one_time"}, nil
+	}
+
+	if len(pricing.Plans) == 0 {
+		if planName != "" && !strings.EqualFold(planName, "standard") {
+			return nil, fmt.Errorf("unknown plan '%s'", planName)
+		}
+		return &models.PricingPlan{Name: "standard", Amount: pricing.Amount, Period: "one_time"}, nil
+	}
+
+	if planName == "" {
+		return nil, fmt.Errorf("plan is required for this server")
+	}
+	for _, plan := range pricing.Plans {
+		if strings.EqualFold(plan.Name, planName) {
+			selected := plan
+			if selected.Period == "" {
+				selected.Period = "one_time"
+			}
+			return &selected, nil
+		}
 	}
+	return nil, fmt.Errorf("unknown plan '%s'", planName)
 }
 
 func createOrder(c *gin.Context) {
+	account, ok := authenticatedAccount(c)
+	if !ok {
+		return
+	}
+	userID, _ := account["localId"].(string)
+
 	var req models.CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.OrderResponse{
-			Status: "error",
-			Detail: "Invalid request: " + err.Error(),
-		})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
-	amountInSubunits := int(req.Amount * 100)
-	currencyUpper := strings.ToUpper(req.Currency)
+	if !requireKeyServer(c, req.ServerName) {
+		return
+	}
 
-	orderData := map[string]interface{}{
-		"amount":   amountInSubunits,
-		"currency": currencyUpper,
-		"receipt":  fmt.Sprintf("order_%s_%d", req.ServerName, amountInSubunits),
-		"notes": map[string]interface{}{
-			"server_name": req.ServerName,
-		},
+	tenantID := requestTenant(c).ID
+	server, err := fetchServer(c.Request.Context(), req.ServerName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+req.ServerName+"' not found"))
+		return
 	}
+	pricing := server.Pricing
+	publisher := server.Author
 
-	order, err := razorpayCreateOrder(orderData)
+	plan, err := resolvePlan(&pricing, req.Plan, req.Amount)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.OrderResponse{
-			Status: "error",
-			Detail: "Error creating order: " + err.Error(),
-		})
+		respondError(c, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if plan.Amount <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "Plan '"+plan.Name+"' is free and does not require an order"))
 		return
 	}
 
+	currencyUpper := pricingCurrency(pricing)
+	amountInSubunits := minorUnits(plan.Amount, currencyUpper)
+
+	country := buyerCountry(c, userID)
+	var risk *models.RiskAssessment
+	approved, err := consumeApprovedHold(c.Request.Context(), tenantID, userID, req.ServerName)
+	if err != nil {
+		respondError(c, internalError("Error checking held orders", err))
+		return
+	}
+	if !approved {
+		risk, err = scoreOrder(c.Request.Context(), userID, account, currencyUpper, country)
+		if err != nil {
+			respondError(c, internalError("Error checking order risk", err))
+			return
+		}
+		if risk.Decision == "block" {
+			respondError(c, newAPIError(http.StatusForbidden, "Order was declined by risk checks"))
+			return
+		}
+		if risk.Decision == "review" {
+			held := &models.Order{
+				ID:         randomID("hold"),
+				TenantID:   tenantID,
+				Kind:       "purchase",
+				UserID:     userID,
+				ServerName: req.ServerName,
+				Plan:       plan.Name,
+				Period:     plan.Period,
+				Amount:     plan.Amount,
+				Currency:   currencyUpper,
+				Status:     "held",
+				Risk:       risk,
+				CreatedAt:  models.Now(),
+			}
+			if err := storeOrder(c.Request.Context(), held); err != nil {
+				respondError(c, internalError("Error holding order", err))
+				return
+			}
+			c.JSON(http.StatusAccepted, models.OrderResponse{
+				Status: "held",
+				Order: map[string]interface{}{
+					"id":     held.ID,
+					"plan":   held.Plan,
+					"status": held.Status,
+				},
+				Detail: "Order requires additional verification and is pending review",
+			})
+			return
+		}
+	}
+
+	commission, err := commissionFor(c.Request.Context(), server.Meta.OwnerID)
+	if err != nil {
+		respondError(c, internalError("Error creating order", err))
+		return
+	}
+	billing, err := getBillingProfileCopy(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Error creating order", err))
+		return
+	}
+
+	provider, reason := routeProvider(c.Request.Context(), currencyUpper, country)
+	notes := map[string]string{
+		"server_name": req.ServerName,
+		"plan":        plan.Name,
+		"user_id":     userID,
+	}
+
+	orderID, orderInfo, keyID, err := createProviderOrder(c.Request.Context(), provider, amountInSubunits, currencyUpper, req.ServerName, notes)
+	if err != nil {
+		respondError(c, paymentError("Error creating order", err))
+		return
+	}
+
+	err = storeOrder(c.Request.Context(), &models.Order{
+		ID:            orderID,
+		TenantID:      tenantID,
+		Kind:          "purchase",
+		UserID:        userID,
+		ServerName:    req.ServerName,
+		Plan:          plan.Name,
+		Period:        plan.Period,
+		Amount:        plan.Amount,
+		Currency:      currencyUpper,
+		Provider:      provider,
+		RoutingReason: reason,
+		Publisher:     publisher,
+		OwnerID:       server.Meta.OwnerID,
+		CommissionPct: commission,
+		Status:        "created",
+		Risk:          risk,
+		Billing:       billing,
+		CreatedAt:     models.Now(),
+	})
+	if err != nil {
+		respondError(c, internalError("Error saving order", err))
+		return
+	}
+
+	orderInfo["plan"] = plan.Name
+	orderInfo["period"] = plan.Period
 	c.JSON(http.StatusOK, models.OrderResponse{
-		Status: "success",
-		Order: map[string]interface{}{
-			"id":       order["id"],
-			"amount":   order["amount"],
-			"currency": order["currency"],
-		},
-		KeyID: razorpayKeyID,
+		Status:   "success",
+		Order:    orderInfo,
+		KeyID:    keyID,
+		Provider: provider,
 	})
 }
 
+// buyerCountry is the country a purchase is risk-scored and routed by.
+// The CDN's country headers count only on requests that came through one
+// of TRUSTED_PROXIES, as anyone else can send them; otherwise it is the
+// country on the buyer's billing profile, if they have one.
+func buyerCountry(c *gin.Context, userID string) string {
+	if fromTrustedProxy(c) {
+		for _, header := range []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"} {
+			if country := strings.TrimSpace(c.GetHeader(header)); country != "" {
+				return strings.ToUpper(country)
+			}
+		}
+	}
+	billing, err := getBillingProfileCopy(c.Request.Context(), userID)
+	if err != nil {
+		slog.Warn("failed to load billing country", "user_id", userID, "error", err)
+		return ""
+	}
+	if billing == nil {
+		return ""
+	}
+	return strings.ToUpper(billing.Country)
+}
+
+// fromTrustedProxy reports whether the request's direct peer is one of
+// TRUSTED_PROXIES.
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range appConfig.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeProvider(ctx context.Context, currency string, country string) (string, string) {
+	if currency == "INR" {
+		return "razorpay", "currency INR is settled through Razorpay"
+	}
+	if tenantFrom(ctx).StripeSecretKey == "" {
+		return "razorpay", "stripe is not configured; falling back to Razorpay"
+	}
+	if country == "IN" {
+		return "stripe", "international currency " + currency + " requested from IN; routed to Stripe"
+	}
+	return "stripe", "currency " + currency + " is settled through Stripe"
+}
+
+func createProviderOrder(ctx context.Context, provider string, amountInSubunits int, currency string, serverName string, notes map[string]string) (string, map[string]interface{}, string, error) {
+	if provider == "stripe" {
+		intent, err := stripeCreatePaymentIntent(ctx, amountInSubunits, currency, notes)
+		if err != nil {
+			return "", nil, "", err
+		}
+		intentID, _ := intent["id"].(string)
+		return intentID, map[string]interface{}{
+			"id":            intent["id"],
+			"amount":        intent["amount"],
+			"currency":      strings.ToUpper(fmt.Sprint(intent["currency"])),
+			"client_secret": intent["client_secret"],
+		}, tenantFrom(ctx).StripePublishableKey, nil
+	}
+
+	noteData := map[string]interface{}{}
+	for key, value := range notes {
+		noteData[key] = value
+	}
+	order, err := razorpayCreateOrder(ctx, map[string]interface{}{
+		"amount":   amountInSubunits,
+		"currency": currency,
+		"receipt":  fmt.Sprintf("order_%s_%d", serverName, amountInSubunits),
+		"notes":    noteData,
+	})
+	if err != nil {
+		return "", nil, "", err
+	}
+	orderID, _ := order["id"].(string)
+	return orderID, map[string]interface{}{
+		"id":       order["id"],
+		"amount":   order["amount"],
+		"currency": order["currency"],
+	}, tenantFrom(ctx).RazorpayKeyID, nil
+}
+
 func verifyPayment(c *gin.Context) {
 	var req models.VerifyPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.PaymentResponse{
-			Status: "error",
-			Detail: "Invalid request: " + err.Error(),
-		})
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	if strings.EqualFold(req.Provider, "stripe") || req.PaymentIntentID != "" {
+		verifyStripePayment(c, req)
 		return
 	}
 
 	message := fmt.Sprintf("%s|%s", req.RazorpayOrderID, req.RazorpayPaymentID)
-	mac := hmac.New(sha256.New, []byte(razorpayKeySecret))
+	mac := hmac.New(sha256.New, []byte(requestTenant(c).RazorpayKeySecret))
 	mac.Write([]byte(message))
 	generatedSignature := hex.EncodeToString(mac.Sum(nil))
 
-	if generatedSignature == req.RazorpaySignature {
-		c.JSON(http.StatusOK, models.PaymentResponse{
-			Status:  "success",
-			Message: "Payment verified",
-			Payment: map[string]interface{}{
-				"id":          req.RazorpayPaymentID,
-				"server_name": req.ServerName,
-			},
-		})
+	if !hmac.Equal([]byte(generatedSignature), []byte(req.RazorpaySignature)) {
+		respondError(c, newAPIError(http.StatusBadRequest, "Invalid payment signature"))
+		return
+	}
+
+	payment := map[string]interface{}{
+		"id":          req.RazorpayPaymentID,
+		"server_name": req.ServerName,
+	}
+	if _, ok := settleVerifiedPayment(c, req.RazorpayOrderID, req.RazorpayPaymentID, "razorpay signature", payment); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.PaymentResponse{
+		Status:  "success",
+		Message: "Payment verified",
+		Payment: payment,
+	})
+}
+
+// settleVerifiedPayment fulfills the order a verified payment belongs to
+// and adds the order's state and entitlement to payment. Verifying the same
+// payment again succeeds without granting anything twice; a payment for an
+// unknown order, or for an order that has failed, been refunded, or been
+// paid by another payment, is refused.
+func settleVerifiedPayment(c *gin.Context, orderID string, paymentID string, verifiedBy string, payment map[string]interface{}) (*models.Entitlement, bool) {
+	order, err := getOrderCopy(c.Request.Context(), orderID)
+	if err != nil {
+		respondError(c, internalError("Error loading order", err))
+		return nil, false
+	}
+	if order == nil || !orderInTenant(order, requestTenant(c).ID) {
+		respondError(c, newAPIError(http.StatusNotFound, "Order '"+orderID+"' not found"))
+		return nil, false
+	}
+
+	entitlement, err := fulfillOrder(c.Request.Context(), orderID, paymentID, verifiedBy)
+	if errors.Is(err, errOrderNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Order '"+orderID+"' not found"))
+		return nil, false
+	}
+	if errors.Is(err, errOrderState) {
+		respondError(c, newAPIError(http.StatusConflict, err.Error()).withCode("order_not_payable"))
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, internalError("Error fulfilling order", err))
+		return nil, false
+	}
+
+	payment["order_id"] = orderID
+	payment["order_status"] = "fulfilled"
+	if entitlement != nil {
+		payment["plan"] = entitlement.Plan
+		payment["entitlement"] = entitlement
+	}
+	return entitlement, true
+}
+
+func verifyStripePayment(c *gin.Context, req models.VerifyPaymentRequest) {
+	if req.PaymentIntentID == "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "payment_intent_id is required for Stripe payments"))
+		return
+	}
+
+	intent, err := stripeGetPaymentIntent(c.Request.Context(), req.PaymentIntentID)
+	if err != nil {
+		respondError(c, paymentError("Error verifying payment", err))
+		return
+	}
+
+	if status, _ := intent["status"].(string); status != "succeeded" {
+		if status == "canceled" {
+			if err := failOrder(c.Request.Context(), req.PaymentIntentID, "stripe payment intent canceled"); err != nil {
+				slog.Warn("failed to mark order failed", "order_id", req.PaymentIntentID, "error", err)
+			}
+		}
+		respondError(c, newAPIError(http.StatusBadRequest, "Payment has not succeeded"))
+		return
+	}
+
+	payment := map[string]interface{}{
+		"id":          req.PaymentIntentID,
+		"server_name": req.ServerName,
+		"provider":    "stripe",
+	}
+	entitlement, ok := settleVerifiedPayment(c, req.PaymentIntentID, req.PaymentIntentID, "stripe payment intent", payment)
+	if !ok {
 		return
 	}
+	if entitlement != nil {
+		customerID, _ := intent["customer"].(string)
+		paymentMethodID, _ := intent["payment_method"].(string)
+		if err := rememberPaymentMethod(c.Request.Context(), entitlement.TenantID, entitlement.UserID, entitlement.ServerName, customerID, paymentMethodID); err != nil {
+			slog.Warn("failed to save payment method", "subscription_id", entitlement.ID, "error", err)
+		}
+	}
 
-	c.JSON(http.StatusBadRequest, models.PaymentResponse{
-		Status: "error",
-		Detail: "Invalid payment signature",
+	c.JSON(http.StatusOK, models.PaymentResponse{
+		Status:  "success",
+		Message: "Payment verified",
+		Payment: payment,
 	})
 }
 
+// getPaymentStatus reports a payment as its provider sees it. Stripe
+// payments are looked up by their payment intent ID (pi_...), or with
+// ?provider=stripe; anything else is a Razorpay payment ID.
 func getPaymentStatus(c *gin.Context) {
 	paymentID := c.Param("payment_id")
 
-	payment, err := razorpayGetPayment(paymentID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.PaymentResponse{
-			Status: "error",
-			Detail: "Error fetching payment status: " + err.Error(),
+	if strings.HasPrefix(paymentID, "pi_") || strings.EqualFold(c.Query("provider"), "stripe") {
+		intent, err := stripeGetPaymentIntent(c.Request.Context(), paymentID)
+		if err != nil {
+			respondError(c, paymentError("Error fetching payment status", err))
+			return
+		}
+		var method interface{}
+		if types, _ := intent["payment_method_types"].([]interface{}); len(types) > 0 {
+			method = types[0]
+		}
+		c.JSON(http.StatusOK, models.PaymentResponse{
+			Status: "success",
+			Payment: map[string]interface{}{
+				"id":       intent["id"],
+				"provider": "stripe",
+				"state":    intent["status"],
+				"amount":   intent["amount"],
+				"currency": strings.ToUpper(fmt.Sprint(intent["currency"])),
+				"method":   method,
+			},
 		})
 		return
 	}
 
+	payment, err := razorpayGetPayment(c.Request.Context(), paymentID)
+	if err != nil {
+		respondError(c, paymentError("Error fetching payment status", err))
+		return
+	}
+
 	c.JSON(http.StatusOK, models.PaymentResponse{
 		Status: "success",
 		Payment: map[string]interface{}{
 			"id":       payment["id"],
+			"provider": "razorpay",
 			"state":    payment["status"],
 			"amount":   payment["amount"],
 			"currency": payment["currency"],
@@ -133,7 +517,93 @@ func getPaymentStatus(c *gin.Context) {
 	})
 }
 
-func razorpayCreateOrder(orderData map[string]interface{}) (map[string]interface{}, error) {
+func listEntitlements(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	entitlements, err := userEntitlements(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Error listing purchases", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"entitlements": entitlements,
+	})
+}
+
+// checkEntitlements answers, in one call, the access the download endpoint
+// would give the caller to each named server. Results follow the order of
+// the request.
+func checkEntitlements(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req models.CheckEntitlementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	servers, err := snapshotServers(ctx)
+	if err != nil {
+		respondError(c, internalError("Error fetching servers", err))
+		return
+	}
+
+	tenantID := requestTenant(c).ID
+	checks := make([]models.EntitlementCheck, 0, len(req.Servers))
+	for _, name := range req.Servers {
+		check := models.EntitlementCheck{ServerName: name, Access: "not_found"}
+		server, exists := servers[name]
+		if !exists {
+			target, renamed, err := resolveServerRedirect(ctx, name)
+			if err != nil {
+				respondError(c, internalError("Error resolving renamed servers", err))
+				return
+			}
+			if server, exists = servers[target]; renamed && exists {
+				check.MovedTo = target
+			}
+		}
+		switch {
+		case !exists:
+		case !requiresPurchase(server.Pricing):
+			check.Access = "free"
+		default:
+			check.Entitlement, err = serverEntitlement(ctx, tenantID, userID, server)
+			if err != nil {
+				respondError(c, internalError("Error checking purchases", err))
+				return
+			}
+			switch {
+			case check.Entitlement == nil:
+				check.Access = "purchase_required"
+			case check.Entitlement.Status == "past_due":
+				check.Access = "grace"
+			default:
+				check.Access = "entitled"
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"entitlements": checks,
+	})
+}
+
+func setRazorpayAuth(ctx context.Context, req *http.Request) {
+	tenant := tenantFrom(ctx)
+	req.SetBasicAuth(tenant.RazorpayKeyID, tenant.RazorpayKeySecret)
+}
+
+func razorpayCreateOrder(ctx context.Context, orderData map[string]interface{}) (map[string]interface{}, error) {
 	url := "https://api.razorpay.com/v1/orders"
 
 	jsonData, err := json.Marshal(orderData)
@@ -141,25 +611,22 @@ func razorpayCreateOrder(orderData map[string]interface{}) (map[string]interface
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonData)))
+	req, err := newOutboundRequest(ctx, "POST", url, strings.NewReader(string(jsonData)))
 	if err != nil {
 		return nil, err
 	}
 
-	req.SetBasicAuth(razorpayKeyID, razorpayKeySecret)
+	setRazorpayAuth(ctx, req)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doUpstream("razorpay", req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		var errorResp map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&errorResp)
-		return nil, fmt.Errorf("razorpay API error: %v", errorResp)
+		return nil, parseRazorpayError(resp)
 	}
 
 	var order map[string]interface{}
@@ -170,27 +637,24 @@ func razorpayCreateOrder(orderData map[string]interface{}) (map[string]interface
 	return order, nil
 }
 
-func razorpayGetPayment(paymentID string) (map[string]interface{}, error) {
+func razorpayGetPayment(ctx context.Context, paymentID string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("https://api.razorpay.com/v1/payments/%s", paymentID)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := newOutboundRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.SetBasicAuth(razorpayKeyID, razorpayKeySecret)
+	setRazorpayAuth(ctx, req)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doUpstream("razorpay", req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		var errorResp map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&errorResp)
-		return nil, fmt.Errorf("razorpay API error: %v", errorResp)
+		return nil, parseRazorpayError(resp)
 	}
 
 	var payment map[string]interface{}
@@ -0,0 +1,583 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errPaymentLinkInactive = errors.New("payment link is not active")
+
+func paymentLinkKey(linkID string) string {
+	return "payment_link:" + linkID
+}
+
+// serverPaymentLinksKey lists the IDs of a server's payment links.
+func serverPaymentLinksKey(tenantID string, serverName string) string {
+	return "payment_links:" + tenantID + ":" + serverName
+}
+
+func loadPaymentLink(ctx context.Context, linkID string) (*models.PaymentLink, error) {
+	data, err := stateStore.Get(ctx, paymentLinkKey(linkID))
+	if err != nil {
+		return nil, err
+	}
+	var link models.PaymentLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// updatePaymentLink applies change to a stored link under the store's lock.
+func updatePaymentLink(ctx context.Context, linkID string, change func(*models.PaymentLink) error) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+	err := stateStore.Update(ctx, paymentLinkKey(linkID), 0, func(current []byte) ([]byte, error) {
+		link = models.PaymentLink{}
+		if err := json.Unmarshal(current, &link); err != nil {
+			return nil, err
+		}
+		if err := change(&link); err != nil {
+			return nil, err
+		}
+		return json.Marshal(link)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// withLinkStatus reports an active link past its expiry as expired, and one
+// that has taken its last payment as completed. The stored status only
+// changes when the publisher deactivates the link.
+func withLinkStatus(link models.PaymentLink, now time.Time) models.PaymentLink {
+	if link.Status != "active" {
+		return link
+	}
+	switch {
+	case link.UsageLimit > 0 && link.UsageCount >= link.UsageLimit:
+		link.Status = "completed"
+	case !link.ExpiresAt.IsZero() && !link.ExpiresAt.After(now):
+		link.Status = "expired"
+	}
+	return link
+}
+
+func paymentLinkNotFound(linkID string) *APIError {
+	return newAPIError(http.StatusNotFound, "Payment link '"+linkID+"' not found")
+}
+
+// createPaymentLink creates a provider-hosted payment link for a server the
+// caller publishes, for invoicing a customer outside the registry checkout.
+func createPaymentLink(c *gin.Context) {
+	serverName := c.Param("server_name")
+	userID, server, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+	var req models.CreatePaymentLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	pricing := server.Pricing
+	plan, err := resolvePlan(&pricing, req.Plan, req.Amount)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	currency := pricingCurrency(pricing)
+	amount := plan.Amount
+	if req.Amount > 0 {
+		amount = roundToCurrency(req.Amount, currency)
+	}
+	if amount <= 0 || amount > maxDonationAmount {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "amount", Message: fmt.Sprintf("must be positive and at most %.2f", float64(maxDonationAmount))}}
+		respondError(c, apiErr)
+		return
+	}
+	if req.CustomAmount && (req.MinimumAmount <= 0 || req.MinimumAmount >= amount) {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "minimum_amount", Message: "is required for a custom amount and must be below amount"}}
+		respondError(c, apiErr)
+		return
+	}
+
+	ctx := c.Request.Context()
+	provider, _ := routeProvider(ctx, currency, "")
+	usageLimit := req.UsageLimit
+	if usageLimit == 0 {
+		usageLimit = 1
+	}
+	if provider == "razorpay" && usageLimit > 1 {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "usage_limit", Message: "Razorpay links take a single payment; " + currency + " links must have a usage_limit of 1"}}
+		respondError(c, apiErr)
+		return
+	}
+
+	now := time.Now()
+	link := &models.PaymentLink{
+		ID:           randomID("plink"),
+		TenantID:     requestTenant(c).ID,
+		ServerName:   serverName,
+		Plan:         plan.Name,
+		Period:       plan.Period,
+		Description:  req.Description,
+		Amount:       amount,
+		CustomAmount: req.CustomAmount,
+		Currency:     currency,
+		Provider:     provider,
+		CallbackURL:  req.CallbackURL,
+		UsageLimit:   usageLimit,
+		OrderIDs:     []string{},
+		Status:       "active",
+		CreatedBy:    userID,
+		CreatedAt:    models.Timestamp(now),
+	}
+	if req.CustomAmount {
+		link.MinimumAmount = roundToCurrency(req.MinimumAmount, link.Currency)
+	}
+	if link.Description == "" {
+		link.Description = server.Name + " (" + plan.Name + ")"
+	}
+	if req.ExpiresInDays > 0 {
+		link.ExpiresAt = models.Timestamp(now.AddDate(0, 0, req.ExpiresInDays))
+	}
+
+	providerLinkID, linkURL, err := createProviderPaymentLink(ctx, link)
+	if err != nil {
+		respondError(c, paymentError("Error creating payment link", err))
+		return
+	}
+	link.ProviderLinkID = providerLinkID
+	link.URL = linkURL
+
+	record, _ := json.Marshal(link)
+	err = stateStore.Set(ctx, paymentLinkKey(link.ID), record, 0)
+	if err == nil {
+		err = updateKeyIndex(ctx, serverPaymentLinksKey(link.TenantID, serverName), func(ids []string) ([]string, error) {
+			return append(ids, link.ID), nil
+		})
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to save payment link", err))
+		return
+	}
+	// Razorpay stops taking payments at expire_by; Stripe links have no
+	// expiry of their own and are deactivated by a job.
+	if !link.ExpiresAt.IsZero() && provider == "stripe" {
+		if _, err := enqueueJob(ctx, "payment_link_expire", map[string]string{"tenant_id": link.TenantID, "link_id": link.ID}, link.ExpiresAt); err != nil {
+			respondError(c, internalError("Failed to schedule payment link expiry", err))
+			return
+		}
+	}
+
+	auditChange(c, nil, link)
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"payment_link": link,
+	})
+}
+
+func listPaymentLinks(c *gin.Context) {
+	serverName := c.Param("server_name")
+	if _, _, ok := requireServerOwner(c, serverName); !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	ids, err := keyIndex(ctx, serverPaymentLinksKey(requestTenant(c).ID, serverName))
+	if err != nil {
+		respondError(c, internalError("Failed to load payment links", err))
+		return
+	}
+	now := models.Now()
+	links := []models.PaymentLink{}
+	for _, id := range ids {
+		if link, err := loadPaymentLink(ctx, id); err == nil {
+			links = append(links, withLinkStatus(*link, now))
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"total":         len(links),
+		"payment_links": links,
+	})
+}
+
+// deactivatePaymentLink stops a link taking payments, at the provider first
+// so no payment lands after it is reported deactivated. Orders already paid
+// through it are kept.
+func deactivatePaymentLink(c *gin.Context) {
+	serverName := c.Param("server_name")
+	if _, _, ok := requireServerOwner(c, serverName); !ok {
+		return
+	}
+	linkID := c.Param("link_id")
+	ctx := c.Request.Context()
+	link, err := loadPaymentLink(ctx, linkID)
+	if err != nil || link.TenantID != requestTenant(c).ID || link.ServerName != serverName {
+		respondError(c, paymentLinkNotFound(linkID))
+		return
+	}
+	if status := withLinkStatus(*link, models.Now()).Status; status != "active" {
+		respondError(c, newAPIError(http.StatusConflict, "Payment link '"+linkID+"' is already "+status))
+		return
+	}
+
+	if err := deactivateProviderPaymentLink(ctx, link); err != nil {
+		respondError(c, paymentError("Error deactivating payment link", err))
+		return
+	}
+	updated, err := updatePaymentLink(ctx, linkID, func(current *models.PaymentLink) error {
+		if current.Status != "active" {
+			return errPaymentLinkInactive
+		}
+		current.Status = "deactivated"
+		current.DeactivatedAt = models.Now()
+		return nil
+	})
+	if errors.Is(err, errPaymentLinkInactive) {
+		respondError(c, newAPIError(http.StatusConflict, "Payment link '"+linkID+"' is already deactivated"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to deactivate payment link", err))
+		return
+	}
+
+	auditChange(c, link, updated)
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"payment_link": updated,
+	})
+}
+
+// verifyPaymentLink settles a payment made through a payment link for the
+// signed-in payer. Each payment becomes its own order, recorded on the link
+// and fulfilled like a registry checkout; verifying it again grants nothing
+// twice.
+func verifyPaymentLink(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req models.VerifyPaymentLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	linkID := c.Param("link_id")
+	ctx := c.Request.Context()
+	tenantID := requestTenant(c).ID
+	link, err := loadPaymentLink(ctx, linkID)
+	if err != nil || link.TenantID != tenantID {
+		respondError(c, paymentLinkNotFound(linkID))
+		return
+	}
+	if !requireKeyServer(c, link.ServerName) {
+		return
+	}
+
+	var (
+		orderID, paymentID, verifiedBy string
+		amountInSubunits               int
+	)
+	if link.Provider == "stripe" {
+		if req.CheckoutSessionID == "" {
+			respondError(c, newAPIError(http.StatusBadRequest, "checkout_session_id is required for Stripe payment links"))
+			return
+		}
+		session, err := stripeRequest(ctx, "GET", "/checkout/sessions/"+url.PathEscape(req.CheckoutSessionID), nil)
+		if err != nil {
+			respondError(c, paymentError("Error verifying payment", err))
+			return
+		}
+		if fmt.Sprint(session["payment_link"]) != link.ProviderLinkID {
+			respondError(c, newAPIError(http.StatusBadRequest, "Checkout session was not paid through this payment link"))
+			return
+		}
+		if status, _ := session["payment_status"].(string); status != "paid" {
+			respondError(c, newAPIError(http.StatusBadRequest, "Payment has not succeeded"))
+			return
+		}
+		paymentID, _ = session["payment_intent"].(string)
+		orderID = paymentID
+		total, _ := session["amount_total"].(float64)
+		amountInSubunits = int(total)
+		verifiedBy = "stripe checkout session"
+	} else {
+		message := strings.Join([]string{link.ProviderLinkID, req.RazorpayReferenceID, req.RazorpayLinkStatus, req.RazorpayPaymentID}, "|")
+		mac := hmac.New(sha256.New, []byte(requestTenant(c).RazorpayKeySecret))
+		mac.Write([]byte(message))
+		if req.RazorpayReferenceID != link.ID || !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(req.RazorpaySignature)) {
+			respondError(c, newAPIError(http.StatusBadRequest, "Invalid payment signature"))
+			return
+		}
+		if req.RazorpayLinkStatus != "paid" && req.RazorpayLinkStatus != "partially_paid" {
+			respondError(c, newAPIError(http.StatusBadRequest, "Payment has not succeeded"))
+			return
+		}
+		payment, err := razorpayGetPayment(ctx, req.RazorpayPaymentID)
+		if err != nil {
+			respondError(c, paymentError("Error verifying payment", err))
+			return
+		}
+		paymentID = req.RazorpayPaymentID
+		if orderID, _ = payment["order_id"].(string); orderID == "" {
+			orderID = paymentID
+		}
+		amount, _ := payment["amount"].(float64)
+		amountInSubunits = int(amount)
+		verifiedBy = "razorpay payment link signature"
+	}
+	if paymentID == "" || amountInSubunits <= 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "Payment has not succeeded"))
+		return
+	}
+
+	existing, err := getOrderCopy(ctx, orderID)
+	if err != nil {
+		respondError(c, internalError("Error loading order", err))
+		return
+	}
+	if existing == nil {
+		server, err := fetchRenamedServer(ctx, link.ServerName)
+		if err != nil {
+			respondError(c, newAPIError(http.StatusNotFound, "Server '"+link.ServerName+"' not found"))
+			return
+		}
+		commission, err := commissionFor(ctx, server.Meta.OwnerID)
+		if err != nil {
+			respondError(c, internalError("Error recording payment", err))
+			return
+		}
+		billing, err := getBillingProfileCopy(ctx, userID)
+		if err != nil {
+			respondError(c, internalError("Error recording payment", err))
+			return
+		}
+		_, err = updatePaymentLink(ctx, link.ID, func(current *models.PaymentLink) error {
+			if !slices.Contains(current.OrderIDs, orderID) {
+				current.OrderIDs = append(current.OrderIDs, orderID)
+				current.UsageCount++
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, internalError("Failed to record payment link use", err))
+			return
+		}
+		order := &models.Order{
+			ID:            orderID,
+			TenantID:      tenantID,
+			Kind:          "payment_link",
+			UserID:        userID,
+			ServerName:    link.ServerName,
+			Plan:          link.Plan,
+			Period:        link.Period,
+			Amount:        fromMinorUnits(float64(amountInSubunits), link.Currency),
+			Currency:      link.Currency,
+			Provider:      link.Provider,
+			RoutingReason: "paid through payment link " + link.ID,
+			Publisher:     server.Author,
+			OwnerID:       server.Meta.OwnerID,
+			CommissionPct: commission,
+			Status:        "created",
+			Billing:       billing,
+			PaymentLinkID: link.ID,
+			CreatedAt:     models.Now(),
+		}
+		// A concurrent verification of the same payment may store the
+		// order first; whoever loses checks the claim like any other.
+		created, err := orders.create(ctx, orderID, order)
+		if err != nil {
+			respondError(c, internalError("Error recording payment", err))
+			return
+		}
+		if !created {
+			if existing, err = getOrderCopy(ctx, orderID); err != nil {
+				respondError(c, internalError("Error loading order", err))
+				return
+			}
+		}
+	}
+	if existing != nil && (existing.PaymentLinkID != link.ID || existing.UserID != userID) {
+		respondError(c, newAPIError(http.StatusConflict, "Payment '"+paymentID+"' was already claimed").withCode("order_not_payable"))
+		return
+	}
+
+	payment := map[string]interface{}{
+		"id":              paymentID,
+		"server_name":     link.ServerName,
+		"provider":        link.Provider,
+		"payment_link_id": link.ID,
+		"amount":          fromMinorUnits(float64(amountInSubunits), link.Currency),
+		"currency":        link.Currency,
+	}
+	if _, ok := settleVerifiedPayment(c, orderID, paymentID, verifiedBy, payment); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.PaymentResponse{
+		Status:  "success",
+		Message: "Payment verified",
+		Payment: payment,
+	})
+}
+
+func runPaymentLinkExpireJob(job *models.Job) error {
+	ctx := withTenant(context.Background(), tenantByID(job.Payload["tenant_id"]))
+	link, err := loadPaymentLink(ctx, job.Payload["link_id"])
+	if err != nil || link.Status != "active" {
+		return nil
+	}
+	if err := deactivateProviderPaymentLink(ctx, link); err != nil {
+		return err
+	}
+	_, err = updatePaymentLink(ctx, link.ID, func(current *models.PaymentLink) error {
+		if current.Status == "active" {
+			current.Status = "deactivated"
+			current.DeactivatedAt = models.Now()
+		}
+		return nil
+	})
+	return err
+}
+
+// createProviderPaymentLink creates the hosted link and returns its provider
+// ID and URL. Razorpay takes the link's own ID as reference_id, which comes
+// back signed in the callback.
+func createProviderPaymentLink(ctx context.Context, link *models.PaymentLink) (string, string, error) {
+	amountInSubunits := minorUnits(link.Amount, link.Currency)
+	if link.Provider == "stripe" {
+		form := url.Values{}
+		form.Set("currency", strings.ToLower(link.Currency))
+		form.Set("product_data[name]", link.Description)
+		if link.CustomAmount {
+			form.Set("custom_unit_amount[enabled]", "true")
+			form.Set("custom_unit_amount[minimum]", strconv.Itoa(minorUnits(link.MinimumAmount, link.Currency)))
+			form.Set("custom_unit_amount[maximum]", strconv.Itoa(amountInSubunits))
+		} else {
+			form.Set("unit_amount", strconv.Itoa(amountInSubunits))
+		}
+		price, err := stripeRequest(ctx, "POST", "/prices", form)
+		if err != nil {
+			return "", "", err
+		}
+
+		form = url.Values{}
+		form.Set("line_items[0][price]", fmt.Sprint(price["id"]))
+		form.Set("line_items[0][quantity]", "1")
+		form.Set("restrictions[completed_sessions][limit]", strconv.Itoa(link.UsageLimit))
+		for key, value := range map[string]string{"payment_link_id": link.ID, "server_name": link.ServerName, "plan": link.Plan} {
+			form.Set("metadata["+key+"]", value)
+			form.Set("payment_intent_data[metadata]["+key+"]", value)
+		}
+		if link.CallbackURL != "" {
+			form.Set("after_completion[type]", "redirect")
+			form.Set("after_completion[redirect][url]", link.CallbackURL+callbackSeparator(link.CallbackURL)+"checkout_session_id={CHECKOUT_SESSION_ID}")
+		}
+		created, err := stripeRequest(ctx, "POST", "/payment_links", form)
+		if err != nil {
+			return "", "", err
+		}
+		linkID, _ := created["id"].(string)
+		linkURL, _ := created["url"].(string)
+		return linkID, linkURL, nil
+	}
+
+	body := map[string]interface{}{
+		"amount":       amountInSubunits,
+		"currency":     link.Currency,
+		"description":  link.Description,
+		"reference_id": link.ID,
+		"notes": map[string]interface{}{
+			"payment_link_id": link.ID,
+			"server_name":     link.ServerName,
+			"plan":            link.Plan,
+		},
+	}
+	if link.CustomAmount {
+		body["accept_partial"] = true
+		body["first_min_partial_amount"] = minorUnits(link.MinimumAmount, link.Currency)
+	}
+	if !link.ExpiresAt.IsZero() {
+		body["expire_by"] = link.ExpiresAt.Unix()
+	}
+	if link.CallbackURL != "" {
+		body["callback_url"] = link.CallbackURL
+		body["callback_method"] = "get"
+	}
+	created, err := razorpayPost(ctx, "https://api.razorpay.com/v1/payment_links", body)
+	if err != nil {
+		return "", "", err
+	}
+	linkID, _ := created["id"].(string)
+	linkURL, _ := created["short_url"].(string)
+	return linkID, linkURL, nil
+}
+
+func deactivateProviderPaymentLink(ctx context.Context, link *models.PaymentLink) error {
+	if link.Provider == "stripe" {
+		_, err := stripeRequest(ctx, "POST", "/payment_links/"+url.PathEscape(link.ProviderLinkID), url.Values{"active": {"false"}})
+		return err
+	}
+	_, err := razorpayPost(ctx, "https://api.razorpay.com/v1/payment_links/"+url.PathEscape(link.ProviderLinkID)+"/cancel", map[string]interface{}{})
+	return err
+}
+
+func callbackSeparator(callbackURL string) string {
+	if strings.Contains(callbackURL, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+func razorpayPost(ctx context.Context, url string, body map[string]interface{}) (map[string]interface{}, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newOutboundRequest(ctx, "POST", url, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	setRazorpayAuth(ctx, req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doUpstream("razorpay", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseRazorpayError(resp)
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"superbox/server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageUsage is the caller's artifact storage as reported by
+// GET /me/limits. A limit of zero is unlimited.
+type StorageUsage struct {
+	LimitBytes     int64 `json:"limit_bytes"`
+	UsedBytes      int64 `json:"used_bytes"`
+	RemainingBytes int64 `json:"remaining_bytes"`
+}
+
+// userPlanKey remembers the plan last seen in a user's ID token, for signed
+// requests, which carry no claims of their own.
+func userPlanKey(tenantID string, userID string) string {
+	return "user_plan:" + tenantID + ":" + userID
+}
+
+func findPlan(name string) (config.Plan, bool) {
+	for _, plan := range appConfig.Plans {
+		if plan.Name == name {
+			return plan, true
+		}
+	}
+	return config.Plan{}, false
+}
+
+// planClaim reads the "plan" custom claim of an account looked up with an
+// ID token; Firebase returns custom claims as a JSON string.
+func planClaim(account map[string]interface{}) string {
+	raw, _ := account["customAttributes"].(string)
+	var claims struct {
+		Plan string `json:"plan"`
+	}
+	if json.Unmarshal([]byte(raw), &claims) != nil {
+		return ""
+	}
+	return claims.Plan
+}
+
+// callerPlan returns the plan whose limits apply to the request: the one
+// the signed-in user's "plan" claim names, or DEFAULT_PLAN for anonymous
+// callers and unknown plans. With no plans configured every limit is zero,
+// which leaves the caller unlimited.
+func callerPlan(c *gin.Context) config.Plan {
+	if plan, ok := c.Get("plan"); ok {
+		return plan.(config.Plan)
+	}
+
+	name := appConfig.DefaultPlan
+	if userID := c.GetString("user_id"); userID != "" {
+		ctx := c.Request.Context()
+		key := userPlanKey(requestTenant(c).ID, userID)
+		remembered, _ := stateStore.Get(ctx, key)
+		if c.GetString("signing_key_id") != "" || c.GetString("api_key_id") != "" {
+			if len(remembered) > 0 {
+				name = string(remembered)
+			}
+		} else {
+			account, _ := c.Get("account")
+			claims, _ := account.(map[string]interface{})
+			if claimed := planClaim(claims); claimed != "" {
+				name = claimed
+			}
+			if string(remembered) != name {
+				stateStore.Set(ctx, key, []byte(name), 0)
+			}
+		}
+	}
+
+	plan, ok := findPlan(name)
+	if !ok {
+		plan, _ = findPlan(appConfig.DefaultPlan)
+	}
+	c.Set("plan", plan)
+	return plan
+}
+
+// userPlan returns the plan last seen in a user's token, for background work
+// that acts for them without a request.
+func userPlan(ctx context.Context, tenantID string, userID string) config.Plan {
+	name := appConfig.DefaultPlan
+	if remembered, _ := stateStore.Get(ctx, userPlanKey(tenantID, userID)); len(remembered) > 0 {
+		name = string(remembered)
+	}
+	plan, ok := findPlan(name)
+	if !ok {
+		plan, _ = findPlan(appConfig.DefaultPlan)
+	}
+	return plan
+}
+
+// storageUsage totals the blobs the owner has uploaded, pending uploads
+// included, against their plan's storage. Blobs the server generates, such
+// as invoices and exports, do not count.
+func storageUsage(ctx context.Context, tenantID string, ownerID string, plan config.Plan) (StorageUsage, error) {
+	usage := StorageUsage{LimitBytes: int64(plan.StorageGB) << 30}
+	owned, err := userBlobs(ctx, tenantID, ownerID, "")
+	if err != nil {
+		return usage, err
+	}
+	for _, blob := range owned {
+		if blobClasses[blob.Class].Uploadable {
+			usage.UsedBytes += blob.Size
+		}
+	}
+	if usage.LimitBytes > 0 {
+		usage.RemainingBytes = max(usage.LimitBytes-usage.UsedBytes, 0)
+	}
+	return usage, nil
+}
+
+// reserveStorage answers 409 when an upload of size bytes would take the
+// caller past their plan's storage.
+func reserveStorage(c *gin.Context, userID string, size int64) bool {
+	plan := callerPlan(c)
+	usage, err := storageUsage(c.Request.Context(), requestTenant(c).ID, userID, plan)
+	if err != nil {
+		respondError(c, internalError("Error checking your storage", err))
+		return false
+	}
+	if usage.LimitBytes == 0 || usage.UsedBytes+size <= usage.LimitBytes {
+		return true
+	}
+	respondError(c, newAPIError(http.StatusConflict, fmt.Sprintf("Uploading %d bytes would exceed the %d GB of storage on the %s plan; delete blobs or upgrade", size, plan.StorageGB, plan.Name)).
+		withCode("limit_exceeded").
+		withDetail("quota", "storage_gb").
+		withDetail("limit_bytes", usage.LimitBytes).
+		withDetail("used_bytes", usage.UsedBytes))
+	return false
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const serverWriteLockTTL = 30 * time.Second
+
+// serverETag is a strong validator for a stored server record. It is the
+// same for the v1 and v2 representations, so a client can read either and
+// send it back in If-Match.
+func serverETag(server models.Server) string {
+	data, _ := json.Marshal(server)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether any tag in an If-Match or If-None-Match list
+// matches etag. Strong comparison, as If-Match requires, never matches a
+// weak tag.
+func etagMatches(list string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func conditionalWrite(c *gin.Context) bool {
+	return c.GetHeader("If-Match") != "" || c.GetHeader("If-Unmodified-Since") != ""
+}
+
+// lockServerWrite serializes conditional writes to one server, so the
+// precondition check and the write happen as one compare-and-swap even
+// across instances sharing the state store. Unconditional writes are not
+// locked. The returned func releases the lock.
+func lockServerWrite(c *gin.Context, serverName string) (func(), bool) {
+	if !conditionalWrite(c) {
+		return func() {}, true
+	}
+	return lockServer(c, serverName)
+}
+
+// lockServer takes the write lock on a server name, so two publishes of the
+// same name cannot both find it free and both write. The returned func
+// releases the lock.
+func lockServer(c *gin.Context, serverName string) (func(), bool) {
+	key := "lock:server:" + serverSubject(requestTenant(c).ID, serverName)
+	acquired, err := stateStore.SetNX(c.Request.Context(), key, []byte(c.GetString("request_id")), serverWriteLockTTL)
+	if err != nil {
+		respondError(c, internalError("Error locking server", err))
+		return nil, false
+	}
+	if !acquired {
+		respondError(c, newAPIError(http.StatusConflict, "Another write to '"+serverName+"' is in progress").withCode("write_conflict"))
+		return nil, false
+	}
+	return func() {
+		ctx, cancel := detachedContext(c.Request.Context())
+		defer cancel()
+		stateStore.Delete(ctx, key)
+	}, true
+}
+
+// checkServerPreconditions answers 412 when If-Match does not name the
+// server's current ETag or, without If-Match, when the server changed after
+// If-Unmodified-Since.
+func checkServerPreconditions(c *gin.Context, server models.Server) bool {
+	etag := serverETag(server)
+	failed := false
+	if match := c.GetHeader("If-Match"); match != "" {
+		failed = !etagMatches(match, etag, false)
+	} else if since := c.GetHeader("If-Unmodified-Since"); since != "" {
+		sinceTime, err := http.ParseTime(since)
+		failed = err == nil && !server.Meta.UpdatedAt.IsZero() && server.Meta.UpdatedAt.After(sinceTime)
+	}
+	if !failed {
+		return true
+	}
+
+	c.Header("ETag", etag)
+	apiErr := newAPIError(http.StatusPreconditionFailed, "Server '"+server.Name+"' has changed since it was read")
+	apiErr.Details = map[string]interface{}{"etag": etag, "updated_at": server.Meta.UpdatedAt}
+	respondError(c, apiErr)
+	return false
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCommissionTier = "standard"
+
+// defaultCurrency prices servers stored before paid pricing had to name a
+// currency.
+const defaultCurrency = "INR"
+
+var errPriceChangeDecided = errors.New("price change already decided")
+
+// defaultCommissionTiers are the tiers every deployment starts with. Admins
+// can change their percentages and add tiers; those are kept in
+// commissionTiers.
+var defaultCommissionTiers = map[string]float64{
+	"standard": 20,
+	"verified": 15,
+	"partner":  10,
+}
+
+var (
+	commissionTiers = recordSet[commissionTier]{kind: "commission_tier"}
+	// publisherTiers holds the tier each publisher was moved to, keyed by
+	// the publisher's owner UID rather than the free-text author a listing
+	// claims. Publishers without one are on the default tier.
+	publisherTiers = recordSet[publisherTierRecord]{kind: "publisher_tier"}
+	priceChanges   = recordSet[models.PriceChange]{kind: "price_change"}
+
+	priceReviewThreshold = 50.0
+	pricingMutex         sync.RWMutex
+)
+
+type commissionTier struct {
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+}
+
+type publisherTierRecord struct {
+	OwnerID string `json:"owner_id"`
+	Tier    string `json:"tier"`
+}
+
+// allCommissionTiers returns every tier's percentage, admin changes applied.
+func allCommissionTiers(ctx context.Context) (map[string]float64, error) {
+	changed, err := commissionTiers.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tiers := maps.Clone(defaultCommissionTiers)
+	for _, tier := range changed {
+		tiers[tier.Name] = tier.Percent
+	}
+	return tiers, nil
+}
+
+// publisherTier returns the tier the publisher with ownerID is on.
+func publisherTier(ctx context.Context, ownerID string) (string, error) {
+	if ownerID == "" {
+		return defaultCommissionTier, nil
+	}
+	record, err := publisherTiers.get(ctx, ownerID)
+	if errors.Is(err, store.ErrNotFound) {
+		return defaultCommissionTier, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.Tier, nil
+}
+
+// commissionFor returns the commission charged on sales of servers owned by
+// ownerID.
+func commissionFor(ctx context.Context, ownerID string) (float64, error) {
+	tier, err := publisherTier(ctx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	tiers, err := allCommissionTiers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return tiers[tier], nil
+}
+
+// pricingCurrency is the currency a server's prices are charged in. It comes
+// from the server's pricing only, never from the buyer.
+func pricingCurrency(pricing models.Pricing) string {
+	if pricing.Currency == "" {
+		return defaultCurrency
+	}
+	return strings.ToUpper(pricing.Currency)
+}
+
+func highestPrice(pricing models.Pricing) float64 {
+	highest := pricing.Amount
+	if pricing.MinimumAmount > highest {
+		highest = pricing.MinimumAmount
+	}
+	for _, plan := range pricing.Plans {
+		if plan.Amount > highest {
+			highest = plan.Amount
+		}
+	}
+	return highest
+}
+
+func needsPriceReview(oldPricing models.Pricing, newPricing models.Pricing) bool {
+	oldPrice := highestPrice(oldPricing)
+	if oldPrice <= 0 {
+		return false
+	}
+	return highestPrice(newPricing) > oldPrice*(1+priceReviewThreshold/100)
+}
+
+func recordPriceChange(ctx context.Context, tenantID string, serverName string, publisher string, oldPricing models.Pricing, newPricing models.Pricing, status string) (*models.PriceChange, error) {
+	now := models.Now()
+	change := &models.PriceChange{
+		ID:          randomID("price"),
+		TenantID:    tenantID,
+		ServerName:  serverName,
+		Publisher:   publisher,
+		OldPricing:  oldPricing,
+		NewPricing:  newPricing,
+		Status:      status,
+		RequestedAt: now,
+	}
+	if status == "applied" {
+		change.EffectiveAt = now
+	}
+
+	if err := priceChanges.put(ctx, change.ID, change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// recordAppliedPrice adds a change that has already taken effect to the
+// server's pricing history. The server is saved by then, so a failure is
+// only logged.
+func recordAppliedPrice(ctx context.Context, tenantID string, serverName string, publisher string, oldPricing models.Pricing, newPricing models.Pricing) {
+	if _, err := recordPriceChange(ctx, tenantID, serverName, publisher, oldPricing, newPricing, "applied"); err != nil {
+		slog.Warn("failed to record price change", "server_name", serverName, "error", err)
+	}
+}
+
+func listPriceChanges(ctx context.Context, filter func(*models.PriceChange) bool) ([]models.PriceChange, error) {
+	all, err := priceChanges.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []models.PriceChange{}
+	for _, change := range all {
+		if filter(&change) {
+			result = append(result, change)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RequestedAt.After(result[j].RequestedAt)
+	})
+	return result, nil
+}
+
+func getPricingHistory(c *gin.Context) {
+	serverName := c.Param("server_name")
+	tenantID := requestTenant(c).ID
+	history, err := listPriceChanges(c.Request.Context(), func(change *models.PriceChange) bool {
+		return change.ServerName == serverName && change.TenantID == tenantID
+	})
+	if err != nil {
+		respondError(c, internalError("Error loading pricing history", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"total":   len(history),
+		"changes": history,
+	})
+}
+
+func getCommission(c *gin.Context) {
+	tiers, err := allCommissionTiers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading commission tiers", err))
+		return
+	}
+	assigned, err := publisherTiers.list(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading commission tiers", err))
+		return
+	}
+	publishers := make(map[string]string, len(assigned))
+	for _, record := range assigned {
+		publishers[record.OwnerID] = record.Tier
+	}
+	pricingMutex.RLock()
+	threshold := priceReviewThreshold
+	pricingMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":                 "success",
+		"default_tier":           defaultCommissionTier,
+		"tiers":                  tiers,
+		"publishers":             publishers,
+		"price_review_threshold": threshold,
+	})
+}
+
+func setCommissionTier(c *gin.Context) {
+	var req models.CommissionTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	tier := c.Param("tier")
+	tiers, err := allCommissionTiers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading commission tiers", err))
+		return
+	}
+	previous, existed := tiers[tier]
+	if err := commissionTiers.put(c.Request.Context(), tier, &commissionTier{Name: tier, Percent: req.Percent}); err != nil {
+		respondError(c, internalError("Error saving commission tier", err))
+		return
+	}
+
+	if existed {
+		auditChange(c, gin.H{"percent": previous}, gin.H{"percent": req.Percent})
+	} else {
+		auditChange(c, nil, gin.H{"percent": req.Percent})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"tier":    tier,
+		"percent": req.Percent,
+	})
+}
+
+func setPublisherTier(c *gin.Context) {
+	var req models.PublisherTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	ownerID := c.Param("owner_id")
+	tiers, err := allCommissionTiers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading commission tiers", err))
+		return
+	}
+	if _, known := tiers[req.Tier]; !known {
+		respondError(c, newAPIError(http.StatusBadRequest, "Unknown commission tier '"+req.Tier+"'"))
+		return
+	}
+	previous, err := publisherTier(c.Request.Context(), ownerID)
+	if err != nil {
+		respondError(c, internalError("Error loading commission tiers", err))
+		return
+	}
+	if err := publisherTiers.put(c.Request.Context(), ownerID, &publisherTierRecord{OwnerID: ownerID, Tier: req.Tier}); err != nil {
+		respondError(c, internalError("Error saving publisher tier", err))
+		return
+	}
+	auditChange(c, gin.H{"tier": previous}, gin.H{"tier": req.Tier})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"owner_id": ownerID,
+		"tier":     req.Tier,
+	})
+}
+
+func listPendingPriceChanges(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	changes, err := listPriceChanges(c.Request.Context(), func(change *models.PriceChange) bool {
+		return change.Status == status
+	})
+	if err != nil {
+		respondError(c, internalError("Error listing price changes", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"total":   len(changes),
+		"changes": changes,
+	})
+}
+
+func approvePriceChange(c *gin.Context) {
+	adminID := c.GetString("user_id")
+
+	changeID := c.Param("change_id")
+	pending, err := priceChanges.get(c.Request.Context(), changeID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error loading price change", err))
+		return
+	}
+	if pending == nil || pending.Status != "pending" {
+		respondError(c, newAPIError(http.StatusNotFound, "Pending price change '"+changeID+"' not found"))
+		return
+	}
+
+	ctx := withTenant(c.Request.Context(), tenantByID(pending.TenantID))
+	server, err := fetchServer(ctx, pending.ServerName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+pending.ServerName+"' not found"))
+		return
+	}
+
+	server.Pricing = normalizePricing(pending.NewPricing)
+	if err := saveServer(ctx, server); err != nil {
+		respondError(c, internalError("Error applying price change", err))
+		return
+	}
+
+	applied, err := priceChanges.update(c.Request.Context(), changeID, func(change *models.PriceChange) error {
+		if change.Status != "pending" {
+			return errPriceChangeDecided
+		}
+		change.Status = "applied"
+		change.EffectiveAt = models.Now()
+		change.ReviewedBy = adminID
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Error applying price change", err))
+		return
+	}
+	auditChange(c, gin.H{"pricing": pending.OldPricing}, gin.H{"pricing": applied.NewPricing})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"change": applied,
+	})
+}
+
+func rejectPriceChange(c *gin.Context) {
+	adminID := c.GetString("user_id")
+
+	changeID := c.Param("change_id")
+	rejected, err := priceChanges.update(c.Request.Context(), changeID, func(change *models.PriceChange) error {
+		if change.Status != "pending" {
+			return errPriceChangeDecided
+		}
+		change.Status = "rejected"
+		change.ReviewedBy = adminID
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, errPriceChangeDecided) {
+		respondError(c, newAPIError(http.StatusNotFound, "Pending price change '"+changeID+"' not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error rejecting price change", err))
+		return
+	}
+	auditChange(c, gin.H{"status": "pending"}, gin.H{"status": rejected.Status})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"change": rejected,
+	})
+}
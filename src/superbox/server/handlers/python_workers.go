@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pythonWorkerIdleCheck is how long a worker may sit idle before it is
+// pinged ahead of its next call, so a helper that died or wedged while
+// unused is replaced instead of failing a request.
+const pythonWorkerIdleCheck = 30 * time.Second
+
+// pythonWorker is one long-lived `s3_helper.py --serve` process speaking
+// newline-delimited JSON-RPC 2.0 on stdin and stdout. A worker serves one
+// call at a time; the pool hands it to a single caller.
+type pythonWorker struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	nextID   int64
+	lastUsed time.Time
+	broken   bool
+}
+
+type rpcRequest struct {
+	JSONRPC string                 `json:"jsonrpc"`
+	ID      int64                  `json:"id"`
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     int64                  `json:"id"`
+	Result map[string]interface{} `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+var (
+	// pythonWorkerSlots holds PYTHON_WORKERS slots. A nil slot has no process
+	// yet (or its process was retired) and is started on first use.
+	pythonWorkerSlots chan *pythonWorker
+	pythonWorkersOnce sync.Once
+)
+
+// helperLog forwards a worker's stderr, such as Python tracebacks, to the log.
+type helperLog struct{}
+
+func (helperLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		if line != "" {
+			slog.Warn("python worker output", "line", line)
+		}
+	}
+	return len(p), nil
+}
+
+func startPythonWorker() (*pythonWorker, error) {
+	cmd := exec.Command(pythonBinary, pythonHelperScript, "--serve")
+	cmd.Env = os.Environ()
+	cmd.Stderr = helperLog{}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start python worker: %w", err)
+	}
+	slog.Debug("python worker started", "pid", cmd.Process.Pid)
+	return &pythonWorker{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), lastUsed: time.Now()}, nil
+}
+
+func (w *pythonWorker) stop() {
+	if w.broken {
+		return
+	}
+	w.broken = true
+	w.stdin.Close()
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+}
+
+// call sends one request and waits for its answer. If the context ends
+// first the process is killed, since the helper cannot abandon a call
+// midway; any such transport failure marks the worker broken.
+func (w *pythonWorker) call(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	w.nextID++
+	line, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: w.nextID, Method: function, Params: args})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp rpcResponse
+	var ioErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, ioErr = w.stdin.Write(append(line, '\n')); ioErr != nil {
+			return
+		}
+		var data []byte
+		if data, ioErr = w.stdout.ReadBytes('\n'); ioErr != nil {
+			return
+		}
+		ioErr = json.Unmarshal(data, &resp)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		w.stop()
+		<-done
+		return nil, ctx.Err()
+	}
+
+	if ioErr == nil && resp.ID != w.nextID {
+		ioErr = fmt.Errorf("response id %d does not match request %d", resp.ID, w.nextID)
+	}
+	if ioErr != nil {
+		w.stop()
+		return nil, fmt.Errorf("python worker failed: %w", ioErr)
+	}
+	w.lastUsed = time.Now()
+
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s", resp.Error.Message)
+	}
+	if errMsg, ok := resp.Result["error"].(string); ok {
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	return resp.Result, nil
+}
+
+func workerSlots() chan *pythonWorker {
+	pythonWorkersOnce.Do(func() {
+		pythonWorkerSlots = make(chan *pythonWorker, appConfig.PythonWorkers)
+		for i := 0; i < appConfig.PythonWorkers; i++ {
+			pythonWorkerSlots <- nil
+		}
+	})
+	return pythonWorkerSlots
+}
+
+// nonIdempotentHelperFunctions are the helper functions whose repeat does
+// not leave the same result as the first call: another multipart upload is
+// created, and a completed or aborted upload no longer exists.
+var nonIdempotentHelperFunctions = map[string]bool{
+	"create_multipart_upload":   true,
+	"complete_multipart_upload": true,
+	"abort_multipart_upload":    true,
+}
+
+// idempotentHelperCall reports whether a helper call can be sent again after
+// its worker crashed. A conditional put_state is not: if the first write
+// landed, the repeat fails its own condition.
+func idempotentHelperCall(function string, args map[string]interface{}) bool {
+	if function == "put_state" {
+		condition, _ := args["condition"].(string)
+		return condition == ""
+	}
+	return !nonIdempotentHelperFunctions[function]
+}
+
+// callPythonWorker runs an S3 helper function on a pooled worker, waiting for
+// a free one if all are busy. Workers are started lazily, pinged when they
+// have been idle, and replaced whenever one crashes or times out.
+func callPythonWorker(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	slots := workerSlots()
+	var worker *pythonWorker
+	select {
+	case worker = <-slots:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		if worker != nil && worker.broken {
+			worker = nil
+		}
+		slots <- worker
+	}()
+
+	if worker != nil && function != "ping" && time.Since(worker.lastUsed) > pythonWorkerIdleCheck {
+		if _, err := worker.call(ctx, "ping", map[string]interface{}{}); err != nil {
+			slog.Warn("python worker failed health check; restarting", "error", err)
+			worker.stop()
+			worker = nil
+		}
+	}
+	// A call whose worker died under it may already have reached S3 or
+	// DynamoDB, so only calls that are safe to repeat are retried, once, on
+	// a fresh process.
+	for attempt := 0; ; attempt++ {
+		if worker == nil {
+			started, err := startPythonWorker()
+			if err != nil {
+				return nil, err
+			}
+			worker = started
+		}
+		result, err := worker.call(ctx, function, args)
+		if !worker.broken || ctx.Err() != nil || attempt > 0 || !idempotentHelperCall(function, args) {
+			return result, err
+		}
+		slog.Warn("python worker crashed; restarting", "function", function, "error", err)
+		worker = nil
+	}
+}
+
+// StopPythonWorkers ends the idle worker processes. Call it after in-flight
+// requests and jobs have finished, as workers still in use are not waited for.
+func StopPythonWorkers() {
+	if pythonWorkerSlots == nil {
+		return
+	}
+	for i := 0; i < cap(pythonWorkerSlots); i++ {
+		select {
+		case worker := <-pythonWorkerSlots:
+			if worker != nil {
+				worker.stop()
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxOpenQuestions is how many unanswered questions one user can have on a
+// server at a time, so the section cannot be flooded before the publisher
+// catches up.
+const maxOpenQuestions = 3
+
+var (
+	// serverQuestions are listed per tenant and server.
+	serverQuestions = recordSet[models.ServerQuestion]{
+		kind:  "server_question",
+		group: func(question *models.ServerQuestion) string { return question.TenantID + "/" + question.ServerName },
+	}
+	// questionVotes records who marked each question helpful, keyed by
+	// question and user, so each user counts once.
+	questionVotes = recordSet[questionVote]{kind: "question_vote"}
+)
+
+type questionVote struct {
+	QuestionID string    `json:"question_id"`
+	UserID     string    `json:"user_id"`
+	VotedAt    time.Time `json:"voted_at"`
+}
+
+// errQuestionNotFound is returned from a question update when the question
+// belongs to another tenant or server.
+var errQuestionNotFound = errors.New("question not found")
+
+// updateQuestion changes a question of the request's tenant and server.
+func updateQuestion(c *gin.Context, serverName string, fn func(question *models.ServerQuestion) error) (*models.ServerQuestion, bool) {
+	tenantID := requestTenant(c).ID
+	updated, err := serverQuestions.update(c.Request.Context(), c.Param("question_id"), func(question *models.ServerQuestion) error {
+		if question.TenantID != tenantID || question.ServerName != serverName {
+			return errQuestionNotFound
+		}
+		return fn(question)
+	})
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, errQuestionNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Question not found"))
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, internalError("Error saving question", err))
+		return nil, false
+	}
+	return updated, true
+}
+
+// askQuestion adds a question to a server's Q&A and tells its publisher.
+func askQuestion(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.AskQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	serverName := c.Param("server_name")
+	server, err := snapshotServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	question := &models.ServerQuestion{
+		ID:         randomID("qst"),
+		TenantID:   requestTenant(c).ID,
+		ServerName: serverName,
+		Question:   req.Question,
+		AskedBy:    userID,
+		AskedAt:    models.Now(),
+	}
+
+	asked, err := serverQuestions.listGroup(c.Request.Context(), question.TenantID+"/"+serverName)
+	if err != nil {
+		respondError(c, internalError("Error loading questions", err))
+		return
+	}
+	open := 0
+	for _, existing := range asked {
+		if existing.AskedBy == userID && existing.Answer == "" {
+			open++
+		}
+	}
+	if open >= maxOpenQuestions {
+		respondError(c, newAPIError(http.StatusConflict, "You already have unanswered questions about '"+serverName+"'; wait for the publisher to answer them").
+			withCode("questions_pending").
+			withDetail("limit", maxOpenQuestions))
+		return
+	}
+	if err := serverQuestions.put(c.Request.Context(), question.ID, question); err != nil {
+		respondError(c, internalError("Error saving question", err))
+		return
+	}
+	result := *question
+
+	if server.Meta.OwnerID != userID {
+		notify(c.Request.Context(), server.Meta.OwnerID, "question.created", "New question about "+serverName+": "+req.Question, map[string]interface{}{
+			"question_id": result.ID,
+			"server_name": serverName,
+		})
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status":   "success",
+		"question": result,
+	})
+}
+
+// answerQuestion sets the publisher's answer to a question, replacing any
+// earlier one. The asker is notified the first time it is answered.
+func answerQuestion(c *gin.Context) {
+	serverName := c.Param("server_name")
+	userID, _, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+
+	var req models.AnswerQuestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	var before models.ServerQuestion
+	stored, ok := updateQuestion(c, serverName, func(question *models.ServerQuestion) error {
+		before = *question
+		question.Answer = req.Answer
+		question.AnsweredBy = userID
+		question.AnsweredAt = models.Now()
+		return nil
+	})
+	if !ok {
+		return
+	}
+	result := *stored
+
+	if before.Answer == "" && result.AskedBy != userID {
+		notify(c.Request.Context(), result.AskedBy, "question.answered", "The publisher of "+serverName+" answered your question", map[string]interface{}{
+			"question_id": result.ID,
+			"server_name": serverName,
+		})
+	}
+	auditChange(c, before, result)
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"question": result,
+	})
+}
+
+// markQuestionHelpful counts the caller as finding a question helpful.
+// Marking the same question again changes nothing.
+func markQuestionHelpful(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	serverName := c.Param("server_name")
+	questionID := c.Param("question_id")
+	stored, err := serverQuestions.get(c.Request.Context(), questionID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (stored.TenantID != requestTenant(c).ID || stored.ServerName != serverName)) {
+		respondError(c, newAPIError(http.StatusNotFound, "Question not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error loading question", err))
+		return
+	}
+	vote := &questionVote{QuestionID: questionID, UserID: userID, VotedAt: models.Now()}
+	counted, err := questionVotes.create(c.Request.Context(), questionID+"/"+userID, vote)
+	if err != nil {
+		respondError(c, internalError("Error saving vote", err))
+		return
+	}
+	if counted {
+		if stored, ok = updateQuestion(c, serverName, func(question *models.ServerQuestion) error {
+			question.HelpfulCount++
+			return nil
+		}); !ok {
+			return
+		}
+	}
+	result := *stored
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"question": result,
+	})
+}
+
+// listServerQuestions lists a server's questions, those marked most helpful
+// first and then newest first, or with ?unanswered=true only those still
+// waiting for the publisher.
+func listServerQuestions(c *gin.Context) {
+	serverName := c.Param("server_name")
+	if _, err := snapshotServer(c.Request.Context(), serverName); err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	asked, err := serverQuestions.listGroup(c.Request.Context(), requestTenant(c).ID+"/"+serverName)
+	if err != nil {
+		respondError(c, internalError("Error loading questions", err))
+		return
+	}
+	unanswered := c.Query("unanswered") == "true"
+	result := []models.ServerQuestion{}
+	for _, question := range asked {
+		if !unanswered || question.Answer == "" {
+			result = append(result, question)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].HelpfulCount != result[j].HelpfulCount {
+			return result[i].HelpfulCount > result[j].HelpfulCount
+		}
+		if result[i].AskedAt != result[j].AskedAt {
+			return result[i].AskedAt.After(result[j].AskedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"total":     len(result),
+		"questions": result,
+	})
+}
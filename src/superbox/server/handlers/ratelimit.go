@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"superbox/server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateQuota is how one quota is counted. Quotas scoped to the user fall
+// back to the client IP for anonymous requests. A quota with a PlanLimit
+// takes its limit from the caller's plan instead of RATE_LIMITS.
+type rateQuota struct {
+	Window    time.Duration
+	Scope     string
+	PlanLimit func(config.Plan) int
+}
+
+var rateQuotas = map[string]rateQuota{
+	"publish":  {Window: time.Hour, Scope: "user"},
+	"search":   {Window: time.Minute, Scope: "ip"},
+	"download": {Window: time.Minute, Scope: "ip"},
+	"search_qps": {Window: time.Second, Scope: "user", PlanLimit: func(plan config.Plan) int {
+		return plan.SearchQPS
+	}},
+	"publishes_per_day": {Window: 24 * time.Hour, Scope: "user", PlanLimit: func(plan config.Plan) int {
+		return plan.PublishesPerDay
+	}},
+}
+
+// planQuotas are counted alongside the RATE_LIMITS quota they are keyed by.
+var planQuotas = map[string]string{
+	"search":  "search_qps",
+	"publish": "publishes_per_day",
+}
+
+// QuotaUsage is one quota as reported in X-RateLimit headers and by
+// GET /me/limits. Reset is the Unix time the current window ends.
+type QuotaUsage struct {
+	Limit         int    `json:"limit"`
+	Remaining     int    `json:"remaining"`
+	Reset         int64  `json:"reset"`
+	WindowSeconds int    `json:"window_seconds"`
+	Scope         string `json:"scope"`
+}
+
+func rateLimit(name string) int {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return liveSettings.RateLimits[name]
+}
+
+func quotaLimit(c *gin.Context, name string) int {
+	if planLimit := rateQuotas[name].PlanLimit; planLimit != nil {
+		return planLimit(callerPlan(c))
+	}
+	return rateLimit(name)
+}
+
+func quotaCaller(c *gin.Context, quota rateQuota) string {
+	if userID := c.GetString("user_id"); quota.Scope == "user" && userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// quotaWindow returns the state store key counting caller's requests in the
+// current fixed window, and when that window ends.
+func quotaWindow(c *gin.Context, name string, quota rateQuota) (string, time.Time) {
+	start := time.Now().Truncate(quota.Window)
+	key := "ratelimit:" + requestTenant(c).ID + ":" + name + ":" + quotaCaller(c, quota) + ":" + strconv.FormatInt(start.Unix(), 10)
+	return key, start.Add(quota.Window)
+}
+
+func quotaCount(data []byte) int {
+	count, _ := strconv.Atoi(string(data))
+	return count
+}
+
+// consumeQuota counts one request against a quota, and against the plan
+// quota counted alongside it, and sets the X-RateLimit headers of the last
+// one counted. Once a quota is spent it answers 429 with Retry-After and
+// returns false. An unlimited quota, or a state store error, lets the
+// request through without headers.
+func consumeQuota(c *gin.Context, name string) bool {
+	if !countQuota(c, name) {
+		return false
+	}
+	if planQuota, ok := planQuotas[name]; ok {
+		return countQuota(c, planQuota)
+	}
+	return true
+}
+
+func countQuota(c *gin.Context, name string) bool {
+	limit := quotaLimit(c, name)
+	if limit <= 0 {
+		return true
+	}
+	quota := rateQuotas[name]
+	key, reset := quotaWindow(c, name, quota)
+
+	count := 1
+	first, err := stateStore.SetNX(c.Request.Context(), key, []byte("1"), quota.Window)
+	if err == nil && !first {
+		err = stateStore.Update(c.Request.Context(), key, quota.Window, func(current []byte) ([]byte, error) {
+			count = quotaCount(current) + 1
+			return []byte(strconv.Itoa(count)), nil
+		})
+	}
+	if err != nil {
+		slog.Warn("rate limit check failed; allowing request", "quota", name, "error", err)
+		return true
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(max(limit-count, 0)))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if count > limit {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+		apiErr := newAPIError(http.StatusTooManyRequests, "Rate limit exceeded for "+name+"; try again after the window resets")
+		if quota.PlanLimit != nil {
+			plan := callerPlan(c).Name
+			apiErr = newAPIError(http.StatusTooManyRequests, "The "+plan+" plan's "+name+" limit is spent; try again after the window resets or upgrade").
+				withDetail("plan", plan)
+		}
+		respondError(c, apiErr.
+			withDetail("quota", name).
+			withDetail("limit", limit).
+			withDetail("reset", reset.Unix()))
+		return false
+	}
+	return true
+}
+
+// RateLimited counts requests to a route against a quota before it runs.
+// When a plan quota is counted too, a caller sending credentials is
+// identified first, so they get their plan rather than the default.
+func RateLimited(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, planned := planQuotas[name]; planned {
+			if _, ok := optionalUser(c); !ok {
+				c.Abort()
+				return
+			}
+		}
+		if !consumeQuota(c, name) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func quotaUsage(c *gin.Context, name string) (QuotaUsage, bool) {
+	limit := quotaLimit(c, name)
+	if limit <= 0 {
+		return QuotaUsage{}, false
+	}
+	quota := rateQuotas[name]
+	key, reset := quotaWindow(c, name, quota)
+	used := 0
+	if data, err := stateStore.Get(c.Request.Context(), key); err == nil {
+		used = quotaCount(data)
+	}
+	return QuotaUsage{
+		Limit:         limit,
+		Remaining:     max(limit-used, 0),
+		Reset:         reset.Unix(),
+		WindowSeconds: int(quota.Window.Seconds()),
+		Scope:         quota.Scope,
+	}, true
+}
+
+// getLimits reports the caller's plan and quotas without counting against
+// them. Unlimited quotas are left out.
+func getLimits(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	limits := make(map[string]QuotaUsage)
+	for _, name := range config.RateLimitQuotas {
+		if usage, ok := quotaUsage(c, name); ok {
+			limits[name] = usage
+		}
+		if planQuota, planned := planQuotas[name]; planned {
+			if usage, ok := quotaUsage(c, planQuota); ok {
+				limits[planQuota] = usage
+			}
+		}
+	}
+	plan := callerPlan(c)
+	storage, err := storageUsage(c.Request.Context(), requestTenant(c).ID, userID, plan)
+	if err != nil {
+		respondError(c, internalError("Error checking your storage", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"plan":      plan,
+		"limits":    limits,
+		"storage":   storage,
+		"bandwidth": bandwidthUsage(c, userID),
+	})
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reconciliationInterval = time.Hour
+	reconciliationLookback = 24 * time.Hour
+	razorpayPageSize       = 100
+	stripePageSize         = 100
+)
+
+// lastReconciliationKey holds the most recent reconciliation run.
+const lastReconciliationKey = "reconciliation:last"
+
+var (
+	repairTasks = recordSet[models.RepairTask]{kind: "repair_task"}
+
+	errRepairResolved = errors.New("repair task already resolved")
+)
+
+// repairPaymentKey records the repair task opened for a payment, so runs
+// on different replicas open one task per payment.
+func repairPaymentKey(paymentID string) string {
+	return "repair:payment:" + paymentID
+}
+
+func saveReconciliationRun(ctx context.Context, run *models.ReconciliationRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return recordStore.Set(ctx, lastReconciliationKey, data, 0)
+}
+
+func loadReconciliationRun(ctx context.Context) (*models.ReconciliationRun, error) {
+	data, err := recordStore.Get(ctx, lastReconciliationKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run models.ReconciliationRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func randomID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + "_" + hex.EncodeToString(b)
+}
+
+// capturedPayment is a payment a provider has taken, as reconciliation
+// matches it with the order it should have settled. Amount is in the
+// currency's smallest unit.
+type capturedPayment struct {
+	Provider string
+	ID       string
+	OrderID  string
+	Amount   float64
+	Currency string
+}
+
+// reconciliationPayments lists recent captured payments once per Razorpay
+// and Stripe account, so tenants that inherit the deployment keys are not
+// listed twice.
+func reconciliationPayments(ctx context.Context, from int64, to int64) ([]capturedPayment, error) {
+	payments := []capturedPayment{}
+	listed := make(map[string]bool)
+	for _, tenant := range allTenants() {
+		tenantCtx := withTenant(ctx, tenant)
+		if tenant.RazorpayKeyID != "" && !listed[tenant.RazorpayKeyID] {
+			listed[tenant.RazorpayKeyID] = true
+			page, err := razorpayListPayments(tenantCtx, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+			}
+			for _, payment := range page {
+				if status, _ := payment["status"].(string); status != "captured" {
+					continue
+				}
+				captured := capturedPayment{Provider: "razorpay"}
+				captured.ID, _ = payment["id"].(string)
+				captured.OrderID, _ = payment["order_id"].(string)
+				captured.Amount, _ = payment["amount"].(float64)
+				captured.Currency, _ = payment["currency"].(string)
+				payments = append(payments, captured)
+			}
+		}
+		if tenant.StripeSecretKey != "" && !listed[tenant.StripeSecretKey] {
+			listed[tenant.StripeSecretKey] = true
+			page, err := stripeListPaymentIntents(tenantCtx, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+			}
+			// Orders paid through Stripe are stored under their payment
+			// intent's ID.
+			for _, intent := range page {
+				if status, _ := intent["status"].(string); status != "succeeded" {
+					continue
+				}
+				captured := capturedPayment{Provider: "stripe"}
+				captured.ID, _ = intent["id"].(string)
+				captured.OrderID = captured.ID
+				captured.Amount, _ = intent["amount_received"].(float64)
+				if captured.Amount == 0 {
+					captured.Amount, _ = intent["amount"].(float64)
+				}
+				currency, _ := intent["currency"].(string)
+				captured.Currency = strings.ToUpper(currency)
+				payments = append(payments, captured)
+			}
+		}
+	}
+	return payments, nil
+}
+
+func runReconciliation(ctx context.Context) error {
+	run := &models.ReconciliationRun{StartedAt: models.Now()}
+	now := time.Now()
+
+	payments, err := reconciliationPayments(ctx, now.Add(-reconciliationLookback).Unix(), now.Unix())
+	if err != nil {
+		run.Error = err.Error()
+		run.FinishedAt = models.Now()
+		if saveErr := saveReconciliationRun(ctx, run); saveErr != nil {
+			slog.Warn("failed to save reconciliation run", "error", saveErr)
+		}
+		return err
+	}
+
+	for _, payment := range payments {
+		run.PaymentsChecked++
+
+		paymentID := payment.ID
+		orderID := payment.OrderID
+		if paymentID == "" {
+			continue
+		}
+
+		kind := ""
+		order, err := getOrderCopy(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if order == nil {
+			kind = "unknown_order"
+		} else if order.Status != "fulfilled" && order.Status != "refunded" {
+			kind = "missing_entitlement"
+			err := markOrderPaid(ctx, orderID, paymentID, "captured payment found by reconciliation")
+			if err != nil && !errors.Is(err, errOrderState) {
+				return err
+			}
+		}
+		if kind == "" {
+			continue
+		}
+
+		run.OrphansFound++
+
+		task := &models.RepairTask{
+			ID:        randomID("repair"),
+			Kind:      kind,
+			Provider:  payment.Provider,
+			PaymentID: paymentID,
+			OrderID:   orderID,
+			Amount:    fromMinorUnits(payment.Amount, payment.Currency),
+			Currency:  payment.Currency,
+			Status:    "open",
+			CreatedAt: models.Now(),
+		}
+		if order != nil {
+			task.ServerName = order.ServerName
+			task.UserID = order.UserID
+		}
+		opened, err := recordStore.SetNX(ctx, repairPaymentKey(paymentID), []byte(task.ID), 0)
+		if err != nil {
+			return err
+		}
+		if !opened {
+			continue
+		}
+		if err := repairTasks.put(ctx, task.ID, task); err != nil {
+			return err
+		}
+	}
+
+	run.FinishedAt = models.Now()
+	return saveReconciliationRun(ctx, run)
+}
+
+func getReconciliation(c *gin.Context) {
+	statusFilter := c.Query("status")
+
+	all, err := repairTasks.list(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error listing repair tasks", err))
+		return
+	}
+	tasks := []models.RepairTask{}
+	for _, task := range all {
+		if statusFilter == "" || task.Status == statusFilter {
+			tasks = append(tasks, task)
+		}
+	}
+	run, err := loadReconciliationRun(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading the last reconciliation", err))
+		return
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"last_run": run,
+		"total":    len(tasks),
+		"tasks":    tasks,
+	})
+}
+
+func runReconciliationNow(c *gin.Context) {
+	if err := runReconciliation(c.Request.Context()); err != nil {
+		respondError(c, upstreamError("Reconciliation failed", err))
+		return
+	}
+
+	run, err := loadReconciliationRun(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error loading the last reconciliation", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"last_run": run,
+	})
+}
+
+func resolveRepairTask(c *gin.Context) {
+	taskID := c.Param("task_id")
+
+	task, err := repairTasks.get(c.Request.Context(), taskID)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Repair task '"+taskID+"' not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error loading repair task", err))
+		return
+	}
+	if task.Status == "resolved" {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "task": task})
+		return
+	}
+
+	// fulfillOrder grants the entitlement once, so two admins resolving the
+	// same task at once do no harm.
+	if task.Kind == "missing_entitlement" {
+		_, err := fulfillOrder(c.Request.Context(), task.OrderID, task.PaymentID, "reconciliation")
+		if errors.Is(err, errOrderNotFound) || errors.Is(err, errOrderState) {
+			respondError(c, newAPIError(http.StatusConflict, "Order '"+task.OrderID+"' is no longer available").withDetail("reason", err.Error()))
+			return
+		}
+		if err != nil {
+			respondError(c, internalError("Error fulfilling order", err))
+			return
+		}
+	}
+
+	task, err = repairTasks.update(c.Request.Context(), taskID, func(task *models.RepairTask) error {
+		if task.Status == "resolved" {
+			return errRepairResolved
+		}
+		task.Status = "resolved"
+		task.ResolvedAt = models.Now()
+		return nil
+	})
+	if errors.Is(err, errRepairResolved) {
+		task, err = repairTasks.get(c.Request.Context(), taskID)
+	}
+	if err != nil {
+		respondError(c, internalError("Error resolving repair task", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "task": task})
+}
+
+func razorpayListPayments(ctx context.Context, from int64, to int64) ([]map[string]interface{}, error) {
+	payments := []map[string]interface{}{}
+	for skip := 0; ; skip += razorpayPageSize {
+		url := fmt.Sprintf("https://api.razorpay.com/v1/payments?from=%d&to=%d&count=%d&skip=%d", from, to, razorpayPageSize, skip)
+		req, err := newOutboundRequest(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		setRazorpayAuth(ctx, req)
+
+		resp, err := doUpstream("razorpay", req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Count int                      `json:"count"`
+			Items []map[string]interface{} `json:"items"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := parseRazorpayError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, page.Items...)
+		if len(page.Items) < razorpayPageSize {
+			return payments, nil
+		}
+	}
+}
+
+// stripeListPaymentIntents lists the payment intents created between from
+// and to, following Stripe's cursor pages.
+func stripeListPaymentIntents(ctx context.Context, from int64, to int64) ([]map[string]interface{}, error) {
+	intents := []map[string]interface{}{}
+	startingAfter := ""
+	for {
+		query := url.Values{}
+		query.Set("created[gte]", strconv.FormatInt(from, 10))
+		query.Set("created[lte]", strconv.FormatInt(to, 10))
+		query.Set("limit", strconv.Itoa(stripePageSize))
+		if startingAfter != "" {
+			query.Set("starting_after", startingAfter)
+		}
+		page, err := stripeRequest(ctx, "GET", "/payment_intents?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		data, _ := page["data"].([]interface{})
+		for _, item := range data {
+			if intent, ok := item.(map[string]interface{}); ok {
+				intents = append(intents, intent)
+				startingAfter, _ = intent["id"].(string)
+			}
+		}
+		if hasMore, _ := page["has_more"].(bool); !hasMore || len(data) == 0 {
+			return intents, nil
+		}
+	}
+}
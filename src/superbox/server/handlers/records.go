@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+
+	"superbox/server/store"
+)
+
+// recordIndexShards splits each collection's index over this many keys, so
+// adding records does not contend on one key and no index key grows past
+// what a DynamoDB item holds.
+const recordIndexShards = 16
+
+// recordSet is a collection of JSON records in the record store, such as
+// orders or notifications. Each record is its own key, so updates to
+// different records do not contend, and the collection's IDs are indexed
+// so it can be listed without a scan.
+type recordSet[T any] struct {
+	kind string
+	// group names a second index a record is listed in, such as its owner,
+	// so listing one user's records does not read the whole collection. It
+	// must not change over the record's life.
+	group func(record *T) string
+	// ttl expires records; zero keeps them until they are deleted. Expired
+	// records drop out of the indexes the next time those are listed.
+	ttl time.Duration
+}
+
+func (r recordSet[T]) key(id string) string {
+	return "record:" + r.kind + ":" + id
+}
+
+func (r recordSet[T]) shardKey(id string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return "index:" + r.kind + ":" + strconv.Itoa(int(hash.Sum32()%recordIndexShards))
+}
+
+func (r recordSet[T]) groupKey(group string) string {
+	return "index:" + r.kind + ":group:" + group
+}
+
+// indexKeys are the indexes record is listed in.
+func (r recordSet[T]) indexKeys(id string, record *T) []string {
+	keys := []string{r.shardKey(id)}
+	if r.group != nil {
+		if group := r.group(record); group != "" {
+			keys = append(keys, r.groupKey(group))
+		}
+	}
+	return keys
+}
+
+func readIndex(ctx context.Context, key string) ([]string, error) {
+	ids := []string{}
+	data, err := recordStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func changeIndex(ctx context.Context, key string, change func([]string) []string) error {
+	if _, err := recordStore.SetNX(ctx, key, []byte("[]"), 0); err != nil {
+		return err
+	}
+	return recordStore.Update(ctx, key, 0, func(current []byte) ([]byte, error) {
+		ids := []string{}
+		if err := json.Unmarshal(current, &ids); err != nil {
+			return nil, err
+		}
+		return json.Marshal(change(ids))
+	})
+}
+
+func (r recordSet[T]) index(ctx context.Context, id string, record *T) error {
+	for _, key := range r.indexKeys(id, record) {
+		err := changeIndex(ctx, key, func(ids []string) []string {
+			if slices.Contains(ids, id) {
+				return ids
+			}
+			return append(ids, id)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r recordSet[T]) get(ctx context.Context, id string) (*T, error) {
+	data, err := recordStore.Get(ctx, r.key(id))
+	if err != nil {
+		return nil, err
+	}
+	var record T
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// put writes a record, replacing any with the same ID.
+func (r recordSet[T]) put(ctx context.Context, id string, record *T) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := recordStore.Set(ctx, r.key(id), data, r.ttl); err != nil {
+		return err
+	}
+	return r.index(ctx, id, record)
+}
+
+// create writes a record unless one with the same ID exists, and reports
+// whether it did.
+func (r recordSet[T]) create(ctx context.Context, id string, record *T) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	created, err := recordStore.SetNX(ctx, r.key(id), data, r.ttl)
+	if err != nil || !created {
+		return false, err
+	}
+	return true, r.index(ctx, id, record)
+}
+
+// update changes a record in place and returns it as written. fn may run
+// more than once when the record changes underneath it, so it must only
+// change the record; an error from fn leaves the record as it was and is
+// returned as is.
+func (r recordSet[T]) update(ctx context.Context, id string, fn func(record *T) error) (*T, error) {
+	var updated T
+	err := recordStore.Update(ctx, r.key(id), r.ttl, func(current []byte) ([]byte, error) {
+		updated = *new(T)
+		if err := json.Unmarshal(current, &updated); err != nil {
+			return nil, err
+		}
+		if err := fn(&updated); err != nil {
+			return nil, err
+		}
+		return json.Marshal(updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// upsert is update for a record that starts as initial when there is none
+// with that ID yet.
+func (r recordSet[T]) upsert(ctx context.Context, id string, initial *T, fn func(record *T) error) (*T, error) {
+	if _, err := r.create(ctx, id, initial); err != nil {
+		return nil, err
+	}
+	return r.update(ctx, id, fn)
+}
+
+func (r recordSet[T]) delete(ctx context.Context, id string) error {
+	record, err := r.get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordStore.Delete(ctx, r.key(id)); err != nil {
+		return err
+	}
+	for _, key := range r.indexKeys(id, record) {
+		err := changeIndex(ctx, key, func(ids []string) []string {
+			return slices.DeleteFunc(ids, func(candidate string) bool { return candidate == id })
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the records an index lists, dropping IDs whose record has
+// expired or was deleted from it.
+func (r recordSet[T]) load(ctx context.Context, key string) ([]T, error) {
+	ids, err := readIndex(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]T, 0, len(ids))
+	missing := []string{}
+	for _, id := range ids {
+		record, err := r.get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *record)
+	}
+	if len(missing) > 0 {
+		err := changeIndex(ctx, key, func(ids []string) []string {
+			return slices.DeleteFunc(ids, func(candidate string) bool { return slices.Contains(missing, candidate) })
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// list reads the whole collection, in no particular order.
+func (r recordSet[T]) list(ctx context.Context) ([]T, error) {
+	result := []T{}
+	for shard := 0; shard < recordIndexShards; shard++ {
+		records, err := r.load(ctx, "index:"+r.kind+":"+strconv.Itoa(shard))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	return result, nil
+}
+
+// listGroup reads the records in one group, in the order they were added.
+func (r recordSet[T]) listGroup(ctx context.Context, group string) ([]T, error) {
+	return r.load(ctx, r.groupKey(group))
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+var sensitiveNamePattern = regexp.MustCompile(`(?i)(auth|cookie|token|secret|password|passwd|api[-_]?key|signature|session|code)`)
+
+// sensitiveLogKeyPattern is narrower than sensitiveNamePattern, which would
+// also hide log fields such as author or status_code.
+var sensitiveLogKeyPattern = regexp.MustCompile(`(?i)(token|secret|password|passwd|api[-_]?key|signature|authorization|cookie)`)
+
+var secretValuePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)bearer\s+[a-z0-9._~+/=-]+`),
+	regexp.MustCompile(`eyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+`),
+	regexp.MustCompile(`\b(sk|rk|pk|whsec)_(live|test)_[a-zA-Z0-9]+`),
+	regexp.MustCompile(`\brzp_(live|test)_[a-zA-Z0-9]+`),
+	// Publisher webhook secrets, from randomID("whsec").
+	regexp.MustCompile(`\bwhsec_[0-9a-f]{24}\b`),
+	// Request signing key secrets, API keys, and exchanged partner tokens.
+	regexp.MustCompile(`\bsbsk_[0-9a-f]{64}\b`),
+	regexp.MustCompile(`\bsbak_[0-9a-f]{64}\b`),
+	regexp.MustCompile(`\bsbx_[A-Za-z0-9_-]{43}\b`),
+	// Google API keys, Google OAuth access tokens, and Firebase refresh tokens.
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+	regexp.MustCompile(`\bya29\.[0-9A-Za-z_-]+`),
+	regexp.MustCompile(`\bAMf-[0-9A-Za-z_-]{20,}`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`),
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// HMAC-SHA256 signatures, such as Razorpay's and our webhook v1= values.
+	regexp.MustCompile(`\b[0-9a-f]{64}\b`),
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+}
+
+var secretAssignmentPattern = regexp.MustCompile(`(?i)((?:token|secret|password|api[-_]?key|signature)["']?\s*[:=]\s*["']?)[^\s"'&,]+`)
+
+func scrubHeader(name string, value string) string {
+	if sensitiveNamePattern.MatchString(name) {
+		return redacted
+	}
+	return scrubSecrets(value)
+}
+
+func scrubQuery(values url.Values) string {
+	for name, entries := range values {
+		if sensitiveNamePattern.MatchString(name) {
+			values[name] = []string{redacted}
+			continue
+		}
+		for i, value := range entries {
+			entries[i] = scrubSecrets(value)
+		}
+	}
+	return values.Encode()
+}
+
+// scrubSecrets redacts tokens, API keys, signatures, email addresses, and
+// the configured credentials from free text such as error messages.
+func scrubSecrets(text string) string {
+	for _, pattern := range secretValuePatterns {
+		text = pattern.ReplaceAllString(text, redacted)
+	}
+	text = secretAssignmentPattern.ReplaceAllString(text, "${1}"+redacted)
+	secrets := []string{firebaseAPIKey}
+	if appConfig != nil {
+		secrets = append(secrets, appConfig.AWSSecretAccessKey)
+		if parsed, err := url.Parse(appConfig.SMTPURL); err == nil {
+			if password, ok := parsed.User.Password(); ok {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+	for _, tenant := range allTenants() {
+		secrets = append(secrets, tenant.GoogleClientSecret, tenant.GithubClientSecret, tenant.GitlabClientSecret, tenant.MicrosoftClientSecret, tenant.RazorpayKeySecret, tenant.StripeSecretKey)
+	}
+	for _, secret := range secrets {
+		if len(secret) >= 8 {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	return text
+}
+
+// scrubbingHandler redacts secrets from every log record before passing it
+// on, so a handler that logs an upstream error or request value cannot leak
+// credentials into the log pipeline.
+type scrubbingHandler struct {
+	next slog.Handler
+}
+
+func (h scrubbingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h scrubbingHandler) Handle(ctx context.Context, record slog.Record) error {
+	scrubbed := slog.NewRecord(record.Time, record.Level, scrubSecrets(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		scrubbed.AddAttrs(scrubAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, scrubbed)
+}
+
+func (h scrubbingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		scrubbed = append(scrubbed, scrubAttr(attr))
+	}
+	return scrubbingHandler{next: h.next.WithAttrs(scrubbed)}
+}
+
+func (h scrubbingHandler) WithGroup(name string) slog.Handler {
+	return scrubbingHandler{next: h.next.WithGroup(name)}
+}
+
+func scrubAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	if sensitiveLogKeyPattern.MatchString(attr.Key) && value.Kind() != slog.KindGroup {
+		return slog.String(attr.Key, redacted)
+	}
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, scrubSecrets(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		scrubbed := make([]any, 0, len(group))
+		for _, member := range group {
+			scrubbed = append(scrubbed, scrubAttr(member))
+		}
+		return slog.Group(attr.Key, scrubbed...)
+	case slog.KindAny:
+		switch raw := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, scrubSecrets(raw.Error()))
+		case []string:
+			scrubbed := make([]string, len(raw))
+			for i, item := range raw {
+				scrubbed[i] = scrubSecrets(item)
+			}
+			return slog.Any(attr.Key, scrubbed)
+		case fmt.Stringer:
+			return slog.String(attr.Key, scrubSecrets(raw.String()))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// scrubAPIError redacts secrets from the parts of an error sent to clients.
+func scrubAPIError(apiErr *APIError) {
+	apiErr.Message = scrubSecrets(apiErr.Message)
+	for i := range apiErr.Fields {
+		apiErr.Fields[i].Message = scrubSecrets(apiErr.Fields[i].Message)
+	}
+	for key, value := range apiErr.Details {
+		if text, ok := value.(string); ok {
+			apiErr.Details[key] = scrubSecrets(text)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"superbox/server/models"
+)
+
+const registryIndexKey = "index/servers.json"
+
+type registryMigration struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// registryMigrations run in order against the registry bucket and the state
+// store. Each one must be safe to re-run: with the in-memory store the
+// applied markers do not survive a restart.
+var registryMigrations = []registryMigration{}
+
+// registryServers lists every server across the region shards and records
+// which shard holds each one. Records that do not decode are skipped so one
+// bad file cannot take the listing down. A name found in two shards keeps
+// the copy from the earlier region, the primary bucket first.
+func registryServers(ctx context.Context) (map[string]models.Server, error) {
+	servers := make(map[string]models.Server)
+	shards := make(map[string]string)
+	for _, region := range storageRegions() {
+		result, err := callShard(ctx, region, "list_servers", map[string]interface{}{})
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region.Name, err)
+		}
+
+		serversMap, _ := result["data"].(map[string]interface{})
+		for name, serverVal := range serversMap {
+			if shard, exists := shards[name]; exists {
+				slog.Warn("server is stored in two regions; ignoring the later copy", "server", name, "kept", shard, "ignored", region.Name)
+				continue
+			}
+			server, err := decodeServer(serverVal)
+			if err != nil {
+				slog.Warn("skipping unreadable server record", "server", name, "region", region.Name, "error", err)
+				continue
+			}
+			servers[name] = server
+			shards[name] = region.Name
+		}
+	}
+	replaceShards(ctx, shards)
+	return servers, nil
+}
+
+// registryServerNames lists server names across the shards without reading
+// their records, for callers that fetch servers one at a time.
+func registryServerNames(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, region := range storageRegions() {
+		result, err := callShard(ctx, region, "list_server_names", map[string]interface{}{})
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region.Name, err)
+		}
+
+		values, _ := result["data"].([]interface{})
+		for _, value := range values {
+			if name, ok := value.(string); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RebuildRegistryIndex writes the listing of every server to the index in
+// the primary bucket. It refuses while the default tenant's registry reads
+// are authenticated, since the index is served without a sign-in.
+func RebuildRegistryIndex(ctx context.Context) (int, error) {
+	if mode := registryReadMode(tenantFrom(ctx)); mode != "public" {
+		return 0, fmt.Errorf("registry reads are %s; the index is only published for public registries", mode)
+	}
+
+	servers, err := registryServers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list servers: %w", err)
+	}
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]models.ServerSummary, 0, len(names))
+	shards := make(map[string][]string)
+	for _, name := range names {
+		entries = append(entries, serverSummary(servers[name]))
+		if shard, ok := serverShard(ctx, name); ok {
+			shards[shard.Name] = append(shards[shard.Name], name)
+		}
+	}
+
+	// The index lives in the primary bucket and covers every shard; shards
+	// maps each region to the servers it holds.
+	body, err := json.Marshal(map[string]interface{}{
+		"generated_at": models.Now(),
+		"total":        len(entries),
+		"servers":      entries,
+		"shards":       shards,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = callPythonS3Context(ctx, "put_object", map[string]interface{}{
+		"bucket_name":  appConfig.S3BucketName,
+		"key":          registryIndexKey,
+		"body":         string(body),
+		"content_type": "application/json",
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", registryIndexKey, err)
+	}
+	recordJobSuccess(ctx, "index-rebuild")
+	return len(entries), nil
+}
+
+func SeedServers(ctx context.Context, seed []models.Server, overwrite bool) (int, error) {
+	existing, err := registryServers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list servers: %w", err)
+	}
+
+	seeded := 0
+	for _, server := range seed {
+		name := server.Name
+		if name == "" {
+			return seeded, fmt.Errorf("seed entry %d has no name", seeded)
+		}
+		if _, exists := existing[name]; exists && !overwrite {
+			slog.Info("skipping existing server", "server", name)
+			continue
+		}
+
+		if err := saveServer(ctx, server); err != nil {
+			return seeded, fmt.Errorf("failed to seed %s: %w", name, err)
+		}
+		seeded++
+	}
+	return seeded, nil
+}
+
+func RunMigrations(ctx context.Context, dryRun bool) ([]string, error) {
+	applied := []string{}
+	for _, migration := range registryMigrations {
+		key := "migration:" + migration.Name
+		if _, err := stateStore.Get(ctx, key); err == nil {
+			continue
+		}
+		if dryRun {
+			applied = append(applied, migration.Name)
+			continue
+		}
+
+		slog.Info("applying migration", "migration", migration.Name)
+		if err := migration.Run(ctx); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", migration.Name, err)
+		}
+		if err := stateStore.Set(ctx, key, []byte(time.Now().UTC().Format(time.RFC3339)), 0); err != nil {
+			return applied, fmt.Errorf("failed to record migration %s: %w", migration.Name, err)
+		}
+		applied = append(applied, migration.Name)
+	}
+	return applied, nil
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegistryCheckReport is what CheckRegistry found, and with apply set, what
+// it repaired.
+type RegistryCheckReport struct {
+	DryRun  bool `json:"dry_run"`
+	Scanned int  `json:"scanned"`
+	// Invalid counts records with at least one issue, Repairable those with
+	// an issue the check can fix.
+	Invalid    int                    `json:"invalid"`
+	Repairable int                    `json:"repairable"`
+	Repaired   int                    `json:"repaired"`
+	Issues     []models.RegistryIssue `json:"issues"`
+}
+
+// recordCheck collects the issues found in one server record.
+type recordCheck struct {
+	issues []models.RegistryIssue
+	failed bool
+}
+
+func (r *recordCheck) fix(field, rule, message string) {
+	r.issues = append(r.issues, models.RegistryIssue{Field: field, Rule: rule, Message: message, Fixable: true})
+}
+
+func (r *recordCheck) fail(field, rule, message string) {
+	r.issues = append(r.issues, models.RegistryIssue{Field: field, Rule: rule, Message: message})
+	r.failed = true
+}
+
+func (r *recordCheck) fixable() bool {
+	for _, issue := range r.issues {
+		if issue.Fixable {
+			return true
+		}
+	}
+	return false
+}
+
+// startRegistryCheck queues a scan of the tenant's stored server records.
+// It reports what it would repair unless the body sets apply.
+func startRegistryCheck(c *gin.Context) {
+	var req models.RegistryCheckRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+	}
+	startOperation(c, "registry_check", c.GetString("user_id"), req)
+}
+
+func runRegistryCheck(ctx context.Context, op models.Operation, progress func(int, string)) (map[string]interface{}, error) {
+	var req models.RegistryCheckRequest
+	if err := json.Unmarshal(op.Input, &req); err != nil {
+		return nil, err
+	}
+	report, err := CheckRegistry(ctx, req.Apply, progress)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"dry_run":    report.DryRun,
+		"scanned":    report.Scanned,
+		"invalid":    report.Invalid,
+		"repairable": report.Repairable,
+		"repaired":   report.Repaired,
+		"issues":     report.Issues,
+	}, nil
+}
+
+// CheckRegistry reads every stored server record, in every region shard,
+// as raw JSON and checks it against the current schema and the rules a
+// publish must pass. Older record shapes (a missing name or meta, Python
+// timestamps, bare or string pricing amounts, legacy tool lists) are fixable;
+// with apply set, each record with a fixable issue is rewritten in the shard
+// that holds it. Records that stay invalid are reported and left alone.
+func CheckRegistry(ctx context.Context, apply bool, progress func(percent int, message string)) (RegistryCheckReport, error) {
+	report := RegistryCheckReport{DryRun: !apply, Issues: []models.RegistryIssue{}}
+
+	type storedRecord struct {
+		name   string
+		region string
+		raw    interface{}
+	}
+	records := []storedRecord{}
+	regions := storageRegions()
+	for _, region := range regions {
+		result, err := callShard(ctx, region, "list_servers", map[string]interface{}{})
+		if err != nil {
+			return report, fmt.Errorf("region %s: %w", region.Name, err)
+		}
+		serversMap, _ := result["data"].(map[string]interface{})
+		for name, raw := range serversMap {
+			records = append(records, storedRecord{name: name, region: region.Name, raw: raw})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].name != records[j].name {
+			return records[i].name < records[j].name
+		}
+		return records[i].region < records[j].region
+	})
+
+	for i, record := range records {
+		progress(i*100/len(records), "Checking "+record.name)
+		report.Scanned++
+
+		server, check := checkServerRecord(record.name, record.raw)
+		if len(check.issues) == 0 {
+			continue
+		}
+		report.Invalid++
+		for _, issue := range check.issues {
+			issue.Server, issue.Region = record.name, record.region
+			report.Issues = append(report.Issues, issue)
+		}
+		if !check.fixable() || server == nil {
+			continue
+		}
+		report.Repairable++
+		if !apply {
+			continue
+		}
+		region, _ := storageRegion(record.region)
+		if err := writeServer(ctx, region, *server); err != nil {
+			return report, fmt.Errorf("failed to repair %s in region %s: %w", record.name, record.region, err)
+		}
+		report.Repaired++
+	}
+	return report, nil
+}
+
+// checkServerRecord repairs what it can of one raw record and checks the
+// result. The server is nil when the record cannot be read even after its
+// repairs.
+func checkServerRecord(name string, raw interface{}) (*models.Server, *recordCheck) {
+	check := &recordCheck{}
+	record, ok := raw.(map[string]interface{})
+	if !ok {
+		check.fail("", "unreadable", "record is not a JSON object")
+		return nil, check
+	}
+
+	switch recordName, _ := record["name"].(string); {
+	case recordName == "":
+		check.fix("name", "missing_name", "is missing; the name the record is stored under is used")
+		record["name"] = name
+	case recordName != name:
+		check.fix("name", "name_mismatch", fmt.Sprintf("is '%s' but the record is stored as '%s', the name lookups use", recordName, name))
+		record["name"] = name
+	}
+	repairMeta(check, record)
+	repairPricing(check, record)
+	if tools, present := record["tools"]; present && !currentToolsShape(tools) {
+		check.fix("tools", "legacy_tools", "uses an older shape; rewritten as a list of tool definitions")
+	}
+
+	server, err := decodeServer(record)
+	if err != nil {
+		if !check.failed {
+			check.fail("", "unreadable", err.Error())
+		}
+		return nil, check
+	}
+
+	// The rules a publish of this record would have to pass.
+	lint := &lintReport{}
+	lintSchema(lint, models.CreateServerRequest{
+		Name:        server.Name,
+		Version:     server.Version,
+		Description: server.Description,
+		Author:      server.Author,
+		Lang:        server.Lang,
+		License:     server.License,
+		Entrypoint:  server.Entrypoint,
+		Repository:  server.Repository,
+		Pricing:     server.Pricing,
+		Tools:       server.Tools,
+	})
+	if err := validatePricing(server.Pricing); err != nil {
+		lint.fail("pricing", "pricing", err.Error())
+	}
+	lintTools(lint, server.Tools)
+	for _, issue := range lint.errors {
+		check.fail(issue.Field, issue.Rule, issue.Message)
+	}
+	return &server, check
+}
+
+// repairMeta fills in a missing meta block or timestamps from the version
+// history, and flags timestamps that are not in UTC whole seconds.
+func repairMeta(check *recordCheck, record map[string]interface{}) {
+	first, last := versionTimes(record)
+	meta, isObject := record["meta"].(map[string]interface{})
+	if !isObject {
+		check.fix("meta", "missing_meta", "is missing; its timestamps are taken from the version history")
+		meta = map[string]interface{}{}
+		record["meta"] = meta
+	}
+	for _, field := range []string{"created_at", "updated_at"} {
+		fallback := first
+		if field == "updated_at" {
+			fallback = last
+		}
+		value, _ := meta[field].(string)
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		switch {
+		case err != nil:
+			if isObject {
+				check.fix("meta."+field, "missing_meta", "is missing or not a timestamp; taken from the version history")
+			}
+			meta[field] = fallback.Format(time.RFC3339)
+		case value != models.Timestamp(parsed).Format(time.RFC3339):
+			check.fix("meta."+field, "legacy_timestamp", "is not in UTC whole seconds")
+		}
+	}
+}
+
+// versionTimes returns the earliest and latest publish times in a record's
+// version history, or now when it has none.
+func versionTimes(record map[string]interface{}) (time.Time, time.Time) {
+	var first, last time.Time
+	versions, _ := record["versions"].([]interface{})
+	for _, entry := range versions {
+		version, _ := entry.(map[string]interface{})
+		value, _ := version["published_at"].(string)
+		published, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			continue
+		}
+		if first.IsZero() || published.Before(first) {
+			first = published
+		}
+		if published.After(last) {
+			last = published
+		}
+	}
+	if first.IsZero() {
+		now := models.Now()
+		return now, now
+	}
+	return models.Timestamp(first), models.Timestamp(last)
+}
+
+// repairPricing turns the pricing shapes older clients wrote (nothing, a
+// bare amount, "free", or amounts as strings) into a pricing object.
+func repairPricing(check *recordCheck, record map[string]interface{}) {
+	switch pricing := record["pricing"].(type) {
+	case map[string]interface{}:
+		for _, field := range []string{"amount", "minimum_amount"} {
+			repairAmount(check, pricing, field, "pricing."+field)
+		}
+		plans, _ := pricing["plans"].([]interface{})
+		for i, entry := range plans {
+			if plan, ok := entry.(map[string]interface{}); ok {
+				repairAmount(check, plan, "amount", fmt.Sprintf("pricing.plans[%d].amount", i))
+			}
+		}
+	case nil:
+		check.fix("pricing", "missing_pricing", "is missing; the server is listed as free")
+		record["pricing"] = map[string]interface{}{"amount": 0}
+	case float64:
+		check.fix("pricing", "untyped_pricing", "is a bare amount; moved into pricing.amount")
+		record["pricing"] = map[string]interface{}{"amount": pricing}
+	case string:
+		amount, ok := parseAmount(pricing)
+		if strings.EqualFold(strings.TrimSpace(pricing), "free") {
+			amount, ok = 0, true
+		}
+		if !ok {
+			check.fail("pricing", "untyped_pricing", fmt.Sprintf("'%s' is not an amount", pricing))
+			return
+		}
+		check.fix("pricing", "untyped_pricing", "is a string; moved into pricing.amount")
+		record["pricing"] = map[string]interface{}{"amount": amount}
+	default:
+		check.fail("pricing", "untyped_pricing", "must be an object")
+	}
+}
+
+func repairAmount(check *recordCheck, fields map[string]interface{}, key string, field string) {
+	value, isString := fields[key].(string)
+	if !isString {
+		return
+	}
+	amount, ok := parseAmount(value)
+	if !ok {
+		check.fail(field, "untyped_pricing", fmt.Sprintf("'%s' is not an amount", value))
+		return
+	}
+	check.fix(field, "untyped_pricing", "is a string; stored as a number")
+	fields[key] = amount
+}
+
+func parseAmount(value string) (float64, bool) {
+	amount, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+	return amount, true
+}
+
+// currentToolsShape reports whether tools is already written the way Tools
+// marshals: a list of definitions.
+func currentToolsShape(tools interface{}) bool {
+	if tools == nil {
+		return true
+	}
+	list, ok := tools.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, entry := range list {
+		if _, ok := entry.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"superbox/server/config"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+type reloadableSettings struct {
+	LogLevel             string          `json:"log_level"`
+	CORSAllowedOrigins   []string        `json:"cors_allowed_origins"`
+	FeatureFlags         map[string]bool `json:"feature_flags"`
+	PriceReviewThreshold float64         `json:"price_review_threshold"`
+	RateLimits           map[string]int  `json:"rate_limits"`
+	RegistryReads        string          `json:"registry_reads"`
+	ModerationKeywords   []string        `json:"moderation_keywords"`
+}
+
+var (
+	liveSettings  reloadableSettings
+	settingsMutex sync.RWMutex
+	reloadMutex   sync.Mutex
+	corsHandler   atomic.Pointer[gin.HandlerFunc]
+	configSource  = config.Load
+)
+
+func SetConfigSource(source func() (*config.Config, error)) {
+	configSource = source
+}
+
+func CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler := corsHandler.Load()
+		if handler == nil {
+			c.Next()
+			return
+		}
+		(*handler)(c)
+	}
+}
+
+func featureEnabled(name string) bool {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return liveSettings.FeatureFlags[name]
+}
+
+func settingsFrom(cfg *config.Config) reloadableSettings {
+	flags := make(map[string]bool, len(cfg.FeatureFlags))
+	for name, enabled := range cfg.FeatureFlags {
+		flags[name] = enabled
+	}
+	return reloadableSettings{
+		LogLevel:             strings.ToLower(cfg.LogLevel),
+		CORSAllowedOrigins:   append([]string(nil), cfg.CORSAllowedOrigins...),
+		FeatureFlags:         flags,
+		PriceReviewThreshold: cfg.PriceReviewThreshold,
+		RateLimits:           maps.Clone(cfg.RateLimits),
+		RegistryReads:        cfg.RegistryReads,
+		ModerationKeywords:   append([]string(nil), cfg.ModerationKeywords...),
+	}
+}
+
+func applySettings(settings reloadableSettings) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(settings.LogLevel)); err == nil {
+		logLevel.Set(level)
+	}
+
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = settings.CORSAllowedOrigins
+	corsConfig.AllowCredentials = true
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"*"}
+	handler := cors.New(corsConfig)
+	corsHandler.Store(&handler)
+
+	pricingMutex.Lock()
+	priceReviewThreshold = settings.PriceReviewThreshold
+	pricingMutex.Unlock()
+
+	settingsMutex.Lock()
+	liveSettings = settings
+	settingsMutex.Unlock()
+}
+
+// ReloadConfig re-reads the configuration and applies the settings that can
+// change at runtime. Everything else, including the state store, keeps its
+// startup value; changed keys that need a restart are returned so the caller
+// can report them.
+func ReloadConfig() (changed []string, restartRequired []string, err error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	cfg, err := configSource()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settingsMutex.RLock()
+	current := liveSettings
+	settingsMutex.RUnlock()
+	next := settingsFrom(cfg)
+
+	for _, setting := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"LOG_LEVEL", current.LogLevel, next.LogLevel},
+		{"CORS_ALLOWED_ORIGINS", current.CORSAllowedOrigins, next.CORSAllowedOrigins},
+		{"FEATURE_FLAGS", current.FeatureFlags, next.FeatureFlags},
+		{"PRICE_REVIEW_THRESHOLD", current.PriceReviewThreshold, next.PriceReviewThreshold},
+		{"RATE_LIMITS", current.RateLimits, next.RateLimits},
+		{"REGISTRY_READS", current.RegistryReads, next.RegistryReads},
+		{"MODERATION_KEYWORDS", current.ModerationKeywords, next.ModerationKeywords},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changed = append(changed, setting.name)
+		}
+	}
+
+	for _, setting := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"PORT", appConfig.Port, cfg.Port},
+		{"REDIS_URL", appConfig.RedisURL, cfg.RedisURL},
+		{"EVENT_BUS", appConfig.EventBus, cfg.EventBus},
+		{"SESSION_STORE_URL", appConfig.SessionStoreURL, cfg.SessionStoreURL},
+		{"RECORD_STORE_URL", appConfig.RecordStoreURL, cfg.RecordStoreURL},
+		{"INDEX_REBUILD_INTERVAL", appConfig.IndexRebuildInterval, cfg.IndexRebuildInterval},
+		{"MODERATION_API_URL", appConfig.ModerationAPIURL, cfg.ModerationAPIURL},
+		{"S3_BUCKET_NAME", appConfig.S3BucketName, cfg.S3BucketName},
+		{"REPORTS_BUCKET_NAME", appConfig.ReportsBucketName, cfg.ReportsBucketName},
+		{"STORAGE_REGIONS", appConfig.StorageRegions, cfg.StorageRegions},
+		{"CLOUDFRONT_DISTRIBUTION_ID", appConfig.CloudFrontDistributionID, cfg.CloudFrontDistributionID},
+		{"FIREBASE_API_KEY", appConfig.FirebaseAPIKey, cfg.FirebaseAPIKey},
+		{"RAZORPAY_KEY_ID", appConfig.RazorpayKeyID, cfg.RazorpayKeyID},
+		{"STRIPE_SECRET_KEY", appConfig.StripeSecretKey, cfg.StripeSecretKey},
+		{"SUPERBOX_ADMIN_UIDS", appConfig.AdminUIDs, cfg.AdminUIDs},
+		{"MAX_BODY_BYTES", appConfig.MaxBodyBytes, cfg.MaxBodyBytes},
+		{"MAX_UPLOAD_BYTES", appConfig.MaxUploadBytes, cfg.MaxUploadBytes},
+		{"HTTP_CLIENT_TIMEOUT", appConfig.HTTPClientTimeout, cfg.HTTPClientTimeout},
+		{"REQUEST_TIMEOUT", appConfig.RequestTimeout, cfg.RequestTimeout},
+		{"PYTHON_WORKERS", appConfig.PythonWorkers, cfg.PythonWorkers},
+		{"TLS_CERT_FILE", appConfig.TLSCertFile, cfg.TLSCertFile},
+		{"TLS_AUTOCERT_DOMAINS", appConfig.TLSAutocertDomains, cfg.TLSAutocertDomains},
+		{"TENANTS_FILE", appConfig.Tenants, cfg.Tenants},
+		{"PARTNERS_FILE", appConfig.Partners, cfg.Partners},
+		{"PLANS_FILE", appConfig.Plans, cfg.Plans},
+		{"DEFAULT_PLAN", appConfig.DefaultPlan, cfg.DefaultPlan},
+		{"DOWNLOAD_MODE", appConfig.DownloadMode, cfg.DownloadMode},
+		{"DOWNLOAD_BYTES_PER_SECOND", appConfig.DownloadBytesPerSecond, cfg.DownloadBytesPerSecond},
+		{"SESSION_ENCRYPTION_KEYS", appConfig.SessionKeys, cfg.SessionKeys},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			restartRequired = append(restartRequired, setting.name)
+		}
+	}
+	sort.Strings(restartRequired)
+
+	applySettings(next)
+	return changed, restartRequired, nil
+}
+
+func getRuntimeConfig(c *gin.Context) {
+	settingsMutex.RLock()
+	settings := liveSettings
+	settingsMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"settings": settings,
+	})
+}
+
+func reloadRuntimeConfig(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	changed, restartRequired, err := ReloadConfig()
+	if err != nil {
+		apiErr := newAPIError(http.StatusBadRequest, "Configuration is invalid; the running settings were kept").withCode("invalid_configuration")
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			apiErr = apiErr.withDetail("problems", validationErr.Problems)
+		}
+		apiErr.Err = err
+		respondError(c, apiErr)
+		return
+	}
+	slog.Info("configuration reloaded", "changed", changed, "restart_required", restartRequired, "user_id", userID)
+
+	settingsMutex.RLock()
+	settings := liveSettings
+	settingsMutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "success",
+		"changed":          nonNil(changed),
+		"restart_required": nonNil(restartRequired),
+		"settings":         settings,
+	})
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
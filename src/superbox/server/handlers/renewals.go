@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+)
+
+const (
+	renewalScanInterval = time.Hour
+	renewalLead         = time.Hour
+	dunningWindow       = 7 * 24 * time.Hour
+)
+
+var renewalRetrySchedule = []time.Duration{24 * time.Hour, 72 * time.Hour, 120 * time.Hour}
+
+// renewalScheduledKey marks a subscription whose renewal is queued, so the
+// hourly scan does not queue it again on any replica.
+func renewalScheduledKey(subscriptionID string) string {
+	return "renewal:" + subscriptionID
+}
+
+func rememberPaymentMethod(ctx context.Context, tenantID string, userID string, serverName string, customerID string, paymentMethodID string) error {
+	_, err := entitlements.update(ctx, entitlementKey(tenantID, userID, serverName), func(stored *storedEntitlement) error {
+		stored.CustomerID = customerID
+		stored.PaymentMethodID = paymentMethodID
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func notifyUser(userID string, message string) {
+	slog.Info("notify user", "user_id", userID, "message", message)
+}
+
+func scheduleRenewals(ctx context.Context) error {
+	deadline := models.Timestamp(time.Now().Add(renewalLead))
+
+	all, err := entitlements.list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, stored := range all {
+		entitlement := stored.Entitlement
+		if periodLength(entitlement.Period) == 0 || entitlement.Status != "active" || entitlement.ExpiresAt.After(deadline) {
+			continue
+		}
+		scheduled, err := recordStore.SetNX(ctx, renewalScheduledKey(entitlement.ID), []byte(replicaID), 0)
+		if err != nil {
+			return err
+		}
+		if !scheduled {
+			continue
+		}
+		if _, err := enqueueJob(ctx, "renewal", map[string]string{"subscription_id": entitlement.ID}, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearRenewal(ctx context.Context, subscriptionID string) error {
+	return recordStore.Delete(ctx, renewalScheduledKey(subscriptionID))
+}
+
+func runRenewalJob(job *models.Job) error {
+	ctx := context.Background()
+	subscriptionID := job.Payload["subscription_id"]
+	subscription, err := subscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if subscription == nil || subscription.Status == "expired" || subscription.Status == "downgraded" {
+		return clearRenewal(ctx, subscriptionID)
+	}
+	if subscription.ExpiresAt.After(time.Now().Add(renewalLead)) {
+		return clearRenewal(ctx, subscriptionID)
+	}
+
+	if err := chargeRenewal(subscription); err != nil {
+		return recordRenewalFailure(subscription, err)
+	}
+
+	if err := clearRenewal(ctx, subscriptionID); err != nil {
+		return err
+	}
+	notifyUser(subscription.UserID, fmt.Sprintf("Your %s plan for %s has been renewed.", subscription.Plan, subscription.ServerName))
+	return nil
+}
+
+func chargeRenewal(subscription *models.Entitlement) error {
+	if subscription.Provider != "stripe" || subscription.CustomerID == "" || subscription.PaymentMethodID == "" {
+		return fmt.Errorf("no saved payment method for automatic renewal")
+	}
+
+	form := url.Values{}
+	form.Set("amount", strconv.Itoa(minorUnits(subscription.Amount, subscription.Currency)))
+	form.Set("currency", strings.ToLower(subscription.Currency))
+	form.Set("customer", subscription.CustomerID)
+	form.Set("payment_method", subscription.PaymentMethodID)
+	form.Set("off_session", "true")
+	form.Set("confirm", "true")
+	form.Set("metadata[subscription_id]", subscription.ID)
+	ctx := withTenant(context.Background(), tenantByID(subscription.TenantID))
+	intent, err := stripeRequest(ctx, "POST", "/payment_intents", form)
+	if err != nil {
+		return err
+	}
+	if status, _ := intent["status"].(string); status != "succeeded" {
+		return fmt.Errorf("renewal payment %s", status)
+	}
+
+	intentID, _ := intent["id"].(string)
+	// The charge went through, so from here on retrying the job would charge
+	// again; reconciliation fulfills the order if this fails.
+	order, err := renewalOrder(ctx, subscription, intentID, "stripe", "")
+	if err == nil {
+		err = storeOrder(ctx, order)
+	}
+	if err == nil {
+		_, err = fulfillOrder(ctx, intentID, intentID, "stripe off-session charge")
+	}
+	if err != nil {
+		slog.Error("renewal charged but not fulfilled", "subscription_id", subscription.ID, "order_id", intentID, "error", err)
+	}
+	return nil
+}
+
+func renewalOrder(ctx context.Context, subscription *models.Entitlement, orderID string, provider string, reason string) (*models.Order, error) {
+	order := &models.Order{
+		ID:             orderID,
+		TenantID:       subscription.TenantID,
+		Kind:           "renewal",
+		UserID:         subscription.UserID,
+		ServerName:     subscription.ServerName,
+		Plan:           subscription.Plan,
+		Period:         subscription.Period,
+		Amount:         subscription.Amount,
+		Currency:       subscription.Currency,
+		Provider:       provider,
+		RoutingReason:  reason,
+		Status:         "created",
+		SubscriptionID: subscription.ID,
+		CreatedAt:      models.Now(),
+	}
+	original, err := getOrderCopy(ctx, subscription.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if original != nil {
+		order.Publisher = original.Publisher
+		order.OwnerID = original.OwnerID
+		order.CommissionPct = original.CommissionPct
+		order.Billing = original.Billing
+	}
+	return order, nil
+}
+
+func recordRenewalFailure(subscription *models.Entitlement, cause error) error {
+	now := time.Now()
+	ctx := withTenant(context.Background(), tenantByID(subscription.TenantID))
+	firstFailure := subscription.Dunning == nil
+
+	payOrderID := ""
+	if firstFailure {
+		amountInSubunits := minorUnits(subscription.Amount, subscription.Currency)
+		provider, reason := routeProvider(ctx, subscription.Currency, "")
+		orderID, _, _, err := createProviderOrder(ctx, provider, amountInSubunits, subscription.Currency, subscription.ServerName, map[string]string{
+			"server_name":     subscription.ServerName,
+			"plan":            subscription.Plan,
+			"user_id":         subscription.UserID,
+			"subscription_id": subscription.ID,
+		})
+		if err == nil {
+			order, err := renewalOrder(ctx, subscription, orderID, provider, reason)
+			if err != nil {
+				return err
+			}
+			if err := storeOrder(ctx, order); err != nil {
+				return err
+			}
+			payOrderID = orderID
+		}
+	}
+
+	updated, err := updateSubscription(ctx, subscription.ID, func(entitlement *models.Entitlement) {
+		if entitlement.Dunning == nil {
+			entitlement.Dunning = &models.DunningState{
+				GraceUntil: entitlement.ExpiresAt.Add(dunningWindow),
+				PayOrderID: payOrderID,
+			}
+		}
+		entitlement.Status = "past_due"
+		entitlement.Dunning.Attempts++
+		entitlement.Dunning.LastError = cause.Error()
+		entitlement.Dunning.NextRetryAt = time.Time{}
+
+		attempt := entitlement.Dunning.Attempts
+		if attempt <= len(renewalRetrySchedule) {
+			retryAt := now.Add(renewalRetrySchedule[attempt-1])
+			if retryAt.Before(entitlement.Dunning.GraceUntil) {
+				entitlement.Dunning.NextRetryAt = models.Timestamp(retryAt)
+			}
+		}
+	})
+	if err != nil || updated == nil {
+		return err
+	}
+
+	if !updated.Dunning.NextRetryAt.IsZero() {
+		if _, err := enqueueJob(ctx, "renewal", map[string]string{"subscription_id": updated.ID}, updated.Dunning.NextRetryAt); err != nil {
+			return err
+		}
+	}
+	if firstFailure {
+		if _, err := enqueueJob(ctx, "dunning_expire", map[string]string{"subscription_id": updated.ID}, updated.Dunning.GraceUntil); err != nil {
+			return err
+		}
+	}
+
+	if firstFailure {
+		publishPurchaseEvent("subscription.past_due", updated, map[string]interface{}{"reason": cause.Error()})
+	}
+	notifyUser(updated.UserID, fmt.Sprintf(
+		"Renewal of your %s plan for %s failed (%s). Access continues until %s; pay order %s to keep your subscription.",
+		updated.Plan, updated.ServerName, cause.Error(),
+		updated.Dunning.GraceUntil.UTC().Format(time.RFC3339), updated.Dunning.PayOrderID,
+	))
+	return nil
+}
+
+func runDunningExpireJob(job *models.Job) error {
+	subscriptionID := job.Payload["subscription_id"]
+	subscription, err := subscriptionByID(context.Background(), subscriptionID)
+	if err != nil {
+		return err
+	}
+	defer clearRenewal(context.Background(), subscriptionID)
+	if subscription == nil || subscription.Status != "past_due" {
+		return nil
+	}
+
+	ctx := withTenant(context.Background(), tenantByID(subscription.TenantID))
+	var freePlan *models.PricingPlan
+	if server, err := fetchServer(ctx, subscription.ServerName); err == nil {
+		pricing := server.Pricing
+		for _, plan := range pricing.Plans {
+			if plan.Amount <= 0 {
+				selected := plan
+				freePlan = &selected
+				break
+			}
+		}
+	}
+
+	updated, err := updateSubscription(ctx, subscriptionID, func(entitlement *models.Entitlement) {
+		if freePlan != nil {
+			entitlement.Status = "downgraded"
+			entitlement.Plan = freePlan.Name
+			entitlement.Period = "one_time"
+			entitlement.Amount = 0
+			entitlement.ExpiresAt = time.Time{}
+		} else {
+			entitlement.Status = "expired"
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if updated != nil {
+		publishPurchaseEvent("subscription.expired", updated, nil)
+		notifyUser(updated.UserID, fmt.Sprintf("Your subscription for %s is now %s after unsuccessful renewal.", updated.ServerName, updated.Status))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+)
+
+type ErrorReport struct {
+	Message   string
+	Frames    []runtime.Frame
+	RequestID string
+	UserID    string
+	Method    string
+	Path      string
+	Query     string
+	Headers   map[string]string
+	ClientIP  string
+	Timestamp time.Time
+}
+
+type ErrorReporter interface {
+	Report(ctx context.Context, report ErrorReport) error
+	Flush(ctx context.Context) error
+}
+
+var (
+	errorReporter   ErrorReporter = logReporter{}
+	errorSampleRate               = 1.0
+	reporterMutex   sync.RWMutex
+)
+
+func SetErrorReporter(reporter ErrorReporter, sampleRate float64) {
+	reporterMutex.Lock()
+	defer reporterMutex.Unlock()
+	errorReporter = reporter
+	errorSampleRate = sampleRate
+}
+
+func FlushErrorReports(ctx context.Context) error {
+	reporterMutex.RLock()
+	reporter := errorReporter
+	reporterMutex.RUnlock()
+	return reporter.Flush(ctx)
+}
+
+func NewSentryReporter(dsn string, environment string) (ErrorReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		Release:     "superbox-server@1.0.0",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sentry client: %w", err)
+	}
+	return &sentryReporter{client: client}, nil
+}
+
+type logReporter struct{}
+
+func (logReporter) Report(ctx context.Context, report ErrorReport) error {
+	stack := make([]string, 0, len(report.Frames))
+	for _, frame := range report.Frames {
+		stack = append(stack, fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line))
+	}
+	slog.ErrorContext(ctx, "panic recovered",
+		"request_id", report.RequestID,
+		"user_id", report.UserID,
+		"method", report.Method,
+		"path", report.Path,
+		"error", report.Message,
+		"stack", stack,
+	)
+	return nil
+}
+
+func (logReporter) Flush(ctx context.Context) error {
+	return nil
+}
+
+type sentryReporter struct {
+	client *sentry.Client
+}
+
+func (r *sentryReporter) Report(ctx context.Context, report ErrorReport) error {
+	frames := make([]sentry.Frame, 0, len(report.Frames))
+	for i := len(report.Frames) - 1; i >= 0; i-- {
+		frames = append(frames, sentry.NewFrame(report.Frames[i]))
+	}
+
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelFatal
+	event.Message = report.Message
+	event.Timestamp = report.Timestamp
+	event.Exception = []sentry.Exception{{
+		Type:       "panic",
+		Value:      report.Message,
+		Stacktrace: &sentry.Stacktrace{Frames: frames},
+	}}
+	event.Tags = map[string]string{"request_id": report.RequestID}
+	event.User = sentry.User{ID: report.UserID, IPAddress: report.ClientIP}
+	event.Request = &sentry.Request{
+		URL:         report.Path,
+		Method:      report.Method,
+		QueryString: report.Query,
+		Headers:     report.Headers,
+	}
+
+	if id := r.client.CaptureEvent(event, nil, nil); id == nil {
+		return fmt.Errorf("sentry dropped the event")
+	}
+	return nil
+}
+
+func (r *sentryReporter) Flush(ctx context.Context) error {
+	if !r.client.FlushWithContext(ctx) {
+		return fmt.Errorf("sentry events were not delivered before the deadline")
+	}
+	return nil
+}
+
+func reportPanic(c *gin.Context, recovered interface{}) {
+	reporterMutex.RLock()
+	reporter, sampleRate := errorReporter, errorSampleRate
+	reporterMutex.RUnlock()
+	if sampleRate < 1 && rand.Float64() >= sampleRate {
+		return
+	}
+
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	callers := runtime.CallersFrames(pcs[:n])
+	frames := []runtime.Frame{}
+	for {
+		frame, more := callers.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+
+	headers := make(map[string]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		headers[name] = scrubHeader(name, strings.Join(values, ", "))
+	}
+
+	report := ErrorReport{
+		Message:   scrubSecrets(fmt.Sprintf("panic: %v", recovered)),
+		Frames:    frames,
+		RequestID: c.GetString("request_id"),
+		UserID:    c.GetString("user_id"),
+		Method:    c.Request.Method,
+		Path:      scrubSecrets(c.Request.URL.Path),
+		Query:     scrubQuery(c.Request.URL.Query()),
+		Headers:   headers,
+		ClientIP:  c.ClientIP(),
+		Timestamp: models.Now(),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := reporter.Report(ctx, report); err != nil {
+			slog.Warn("failed to report panic", "request_id", report.RequestID, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	processorFeeRates = map[string]float64{
+		"razorpay": 2.0,
+		"stripe":   2.9,
+	}
+	taxRates = map[string]float64{
+		"IN": 18,
+		"GB": 20,
+		"DE": 19,
+		"FR": 20,
+		"AU": 10,
+	}
+	revenueReports = recordSet[models.RevenueReport]{kind: "revenue_report"}
+)
+
+func RegisterPublisher(api *gin.RouterGroup) {
+	publisher := api.Group("/publisher")
+	{
+		publisher.GET("/reports", requestRevenueReport)
+		publisher.GET("/reports/:report_id", getRevenueReport)
+	}
+}
+
+type revenueLine struct {
+	Order         models.Order
+	Gross         float64
+	PlatformFee   float64
+	ProcessorFee  float64
+	Tax           float64
+	Net           float64
+	TaxCountry    string
+	PaidTimestamp string
+}
+
+func revenueLines(ctx context.Context, tenantID string, ownerID string, period time.Time) ([]revenueLine, error) {
+	start := models.Timestamp(period)
+	end := models.Timestamp(period.AddDate(0, 1, 0))
+
+	all, err := orders.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lines := []revenueLine{}
+	for _, order := range all {
+		if order.TenantID != tenantID || order.OwnerID != ownerID || order.PaidAt.Before(start) || !order.PaidAt.Before(end) {
+			continue
+		}
+		// A refunded order earned nothing, and a partial refund comes off
+		// what was charged.
+		if order.Status == "refunded" || order.RefundedAmount >= order.Amount {
+			continue
+		}
+		lines = append(lines, revenueLine{Order: order})
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		line.Gross = roundAmount(line.Order.Amount - line.Order.RefundedAmount)
+		if line.Order.Billing != nil {
+			line.TaxCountry = line.Order.Billing.Country
+		}
+		if rate := taxRates[line.TaxCountry]; rate > 0 {
+			line.Tax = roundAmount(line.Gross - line.Gross/(1+rate/100))
+		}
+		line.ProcessorFee = roundAmount(line.Gross * processorFeeRates[line.Order.Provider] / 100)
+		line.PlatformFee = roundAmount((line.Gross - line.Tax) * line.Order.CommissionPct / 100)
+		line.Net = roundAmount(line.Gross - line.Tax - line.ProcessorFee - line.PlatformFee)
+		line.PaidTimestamp = line.Order.PaidAt.UTC().Format(time.RFC3339)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Order.PaidAt.Before(lines[j].Order.PaidAt)
+	})
+	return lines, nil
+}
+
+func revenueTotals(lines []revenueLine) map[string]models.RevenueTotals {
+	totals := make(map[string]models.RevenueTotals)
+	for _, line := range lines {
+		current := totals[line.Order.Currency]
+		current.Gross = roundAmount(current.Gross + line.Gross)
+		current.PlatformFees = roundAmount(current.PlatformFees + line.PlatformFee)
+		current.ProcessorFees = roundAmount(current.ProcessorFees + line.ProcessorFee)
+		current.Taxes = roundAmount(current.Taxes + line.Tax)
+		current.Net = roundAmount(current.Net + line.Net)
+		totals[line.Order.Currency] = current
+	}
+	return totals
+}
+
+func renderRevenueReport(format string, lines []revenueLine) ([]byte, string, error) {
+	if format == "json" {
+		rows := make([]map[string]interface{}, 0, len(lines))
+		for _, line := range lines {
+			rows = append(rows, map[string]interface{}{
+				"order_id":      line.Order.ID,
+				"payment_id":    line.Order.PaymentID,
+				"paid_at":       line.PaidTimestamp,
+				"server_name":   line.Order.ServerName,
+				"plan":          line.Order.Plan,
+				"kind":          line.Order.Kind,
+				"provider":      line.Order.Provider,
+				"currency":      line.Order.Currency,
+				"gross":         line.Gross,
+				"tax_country":   line.TaxCountry,
+				"tax":           line.Tax,
+				"processor_fee": line.ProcessorFee,
+				"platform_fee":  line.PlatformFee,
+				"net":           line.Net,
+			})
+		}
+		data, err := json.MarshalIndent(map[string]interface{}{
+			"transactions": rows,
+			"totals":       revenueTotals(lines),
+		}, "", "  ")
+		return data, "application/json", err
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{
+		"order_id", "payment_id", "paid_at", "server_name", "plan", "kind", "provider",
+		"currency", "gross", "tax_country", "tax", "processor_fee", "platform_fee", "net",
+	})
+	money := func(amount float64) string {
+		return strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+	for _, line := range lines {
+		writer.Write([]string{
+			line.Order.ID, line.Order.PaymentID, line.PaidTimestamp, line.Order.ServerName,
+			line.Order.Plan, line.Order.Kind, line.Order.Provider, line.Order.Currency,
+			money(line.Gross), line.TaxCountry, money(line.Tax), money(line.ProcessorFee),
+			money(line.PlatformFee), money(line.Net),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), "text/csv", writer.Error()
+}
+
+func runRevenueReportJob(job *models.Job) error {
+	reportID := job.Payload["report_id"]
+
+	pending, err := revenueReports.get(context.Background(), reportID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := withTenant(context.Background(), tenantByID(pending.TenantID))
+	period, _ := time.Parse("2006-01", pending.Period)
+	lines, err := revenueLines(ctx, pending.TenantID, pending.OwnerID, period)
+	if err != nil {
+		return err
+	}
+	body, contentType, buildErr := renderRevenueReport(pending.Format, lines)
+	var blob *models.Blob
+	if buildErr == nil {
+		blob, buildErr = putBlob(ctx, "report", pending.OwnerID, body, contentType)
+	}
+
+	_, err = revenueReports.update(ctx, reportID, func(report *models.RevenueReport) error {
+		report.CompletedAt = models.Now()
+		if buildErr != nil {
+			report.Status = "failed"
+			report.Error = buildErr.Error()
+			return nil
+		}
+		report.Status = "ready"
+		report.BlobID = blob.ID
+		report.Rows = len(lines)
+		report.Totals = revenueTotals(lines)
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func requestRevenueReport(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	ownerID := userID
+	if publisherID := c.Query("publisher_id"); publisherID != "" && isAdmin(userID) {
+		ownerID = publisherID
+	}
+
+	periodParam := c.DefaultQuery("period", time.Now().UTC().Format("2006-01"))
+	if _, err := time.Parse("2006-01", periodParam); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "period must use the YYYY-MM format"))
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		respondError(c, newAPIError(http.StatusBadRequest, "format must be csv or json"))
+		return
+	}
+
+	report := &models.RevenueReport{
+		ID:        randomID("rpt"),
+		TenantID:  requestTenant(c).ID,
+		OwnerID:   ownerID,
+		Period:    periodParam,
+		Format:    format,
+		Status:    "pending",
+		CreatedAt: models.Now(),
+	}
+
+	if err := revenueReports.put(c.Request.Context(), report.ID, report); err != nil {
+		respondError(c, internalError("Error requesting report", err))
+		return
+	}
+	if _, err := enqueueJob(c.Request.Context(), "revenue_report", map[string]string{"report_id": report.ID}, time.Now()); err != nil {
+		respondError(c, internalError("Error requesting report", err))
+		return
+	}
+
+	c.Header("Location", "/api/v1/publisher/reports/"+report.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+		"report": report,
+	})
+}
+
+func getRevenueReport(c *gin.Context) {
+	if _, ok := authenticatedUser(c); !ok {
+		return
+	}
+
+	reportID := c.Param("report_id")
+	snapshot, err := revenueReports.get(c.Request.Context(), reportID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error loading report", err))
+		return
+	}
+	if snapshot == nil || snapshot.TenantID != requestTenant(c).ID || !ownsResource(c, snapshot.OwnerID) {
+		respondError(c, newAPIError(http.StatusNotFound, "Report '"+reportID+"' not found"))
+		return
+	}
+
+	blob, err := getBlobCopy(c.Request.Context(), snapshot.BlobID)
+	if err != nil {
+		respondError(c, internalError("Error loading report", err))
+		return
+	}
+	if snapshot.Status == "ready" && blob != nil {
+		url, err := blobDownloadLink(c.Request.Context(), *blob)
+		if err != nil {
+			respondError(c, internalError("Error creating download link", err))
+			return
+		}
+		snapshot.DownloadURL = url
+	} else if snapshot.Status == "ready" {
+		snapshot.Status = "expired"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"report": snapshot,
+	})
+}
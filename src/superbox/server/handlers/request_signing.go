@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signed requests carry these headers instead of an ID token. The signature
+// header takes the same t=<unix>,v1=<hex> form as webhook deliveries.
+const (
+	requestKeyHeader       = "X-SuperBox-Key-Id"
+	requestNonceHeader     = "X-SuperBox-Nonce"
+	requestSignatureHeader = "X-SuperBox-Signature"
+)
+
+const (
+	// requestSignatureTolerance is how far a signed request's timestamp may
+	// be from the server's clock. Nonces are remembered for twice as long,
+	// so a request cannot be replayed while its timestamp is still accepted.
+	requestSignatureTolerance = 5 * time.Minute
+	maxSigningKeys            = 10
+)
+
+// storedSigningKey is a signing key as the state store holds it, with its
+// secret sealed under the session keys.
+type storedSigningKey struct {
+	Key           models.RequestSigningKey `json:"key"`
+	UserID        string                   `json:"user_id"`
+	TenantID      string                   `json:"tenant_id"`
+	Email         string                   `json:"email,omitempty"`
+	EmailVerified bool                     `json:"email_verified,omitempty"`
+	SealedSecret  string                   `json:"sealed_secret"`
+}
+
+func signingKeyKey(keyID string) string {
+	return "request_key:" + keyID
+}
+
+func userSigningKeysKey(tenantID string, userID string) string {
+	return "request_keys:" + tenantID + ":" + userID
+}
+
+// signedPayload is what a request signature covers after "<t>.": the
+// nonce, method, path with query, and raw body, joined by dots.
+func signedPayload(nonce string, method string, requestURI string, body []byte) string {
+	return nonce + "." + method + "." + requestURI + "." + string(body)
+}
+
+func loadSigningKey(ctx context.Context, keyID string) (*storedSigningKey, error) {
+	data, err := stateStore.Get(ctx, signingKeyKey(keyID))
+	if err != nil {
+		return nil, err
+	}
+	var key storedSigningKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func userSigningKeyIDs(ctx context.Context, tenantID string, userID string) ([]string, error) {
+	return keyIndex(ctx, userSigningKeysKey(tenantID, userID))
+}
+
+func updateUserSigningKeyIDs(ctx context.Context, tenantID string, userID string, change func([]string) ([]string, error)) error {
+	return updateKeyIndex(ctx, userSigningKeysKey(tenantID, userID), change)
+}
+
+// keyIndex reads a user's list of keys, kept under its own state store key
+// so keys can be listed without a scan.
+func keyIndex(ctx context.Context, key string) ([]string, error) {
+	ids := []string{}
+	data, err := stateStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func updateKeyIndex(ctx context.Context, key string, change func([]string) ([]string, error)) error {
+	if _, err := stateStore.SetNX(ctx, key, []byte("[]"), 0); err != nil {
+		return err
+	}
+	return stateStore.Update(ctx, key, 0, func(current []byte) ([]byte, error) {
+		ids := []string{}
+		if err := json.Unmarshal(current, &ids); err != nil {
+			return nil, err
+		}
+		ids, err := change(ids)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(ids)
+	})
+}
+
+// requireTokenCaller refuses requests authenticated by a signing key or an
+// API key, so a leaked key cannot mint or remove keys.
+func requireTokenCaller(c *gin.Context) bool {
+	if c.GetString("signing_key_id") != "" || c.GetString("api_key_id") != "" {
+		respondError(c, newAPIError(http.StatusForbidden, "Keys can only be managed with an ID token"))
+		return false
+	}
+	return true
+}
+
+var errTooManySigningKeys = errors.New("too many signing keys")
+
+func createSigningKey(c *gin.Context) {
+	account, ok := authenticatedAccount(c)
+	if !ok || !requireTokenCaller(c) {
+		return
+	}
+	var req models.CreateSigningKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	userID := c.GetString("user_id")
+	tenantID := requestTenant(c).ID
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	secret := "sbsk_" + hex.EncodeToString(raw)
+	key := models.RequestSigningKey{
+		ID:        randomID("rk"),
+		Name:      req.Name,
+		CreatedAt: models.Now(),
+	}
+	sealed, err := sealValue([]byte(secret), []byte(key.ID))
+	if err != nil {
+		respondError(c, internalError("Failed to create signing key", err))
+		return
+	}
+	email, _ := account["email"].(string)
+	verified, _ := account["emailVerified"].(bool)
+	record, _ := json.Marshal(storedSigningKey{Key: key, UserID: userID, TenantID: tenantID, Email: email, EmailVerified: verified, SealedSecret: sealed})
+
+	err = updateUserSigningKeyIDs(c.Request.Context(), tenantID, userID, func(ids []string) ([]string, error) {
+		if len(ids) >= maxSigningKeys {
+			return nil, errTooManySigningKeys
+		}
+		return append(ids, key.ID), nil
+	})
+	if errors.Is(err, errTooManySigningKeys) {
+		respondError(c, newAPIError(http.StatusConflict, "At most "+strconv.Itoa(maxSigningKeys)+" signing keys are allowed; delete one first").withCode("limit_exceeded"))
+		return
+	}
+	if err == nil {
+		err = stateStore.Set(c.Request.Context(), signingKeyKey(key.ID), record, 0)
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to create signing key", err))
+		return
+	}
+
+	key.Secret = secret
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"key":    key,
+	})
+}
+
+func listSigningKeys(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	ids, err := userSigningKeyIDs(ctx, requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load signing keys", err))
+		return
+	}
+	keys := []models.RequestSigningKey{}
+	for _, id := range ids {
+		if stored, err := loadSigningKey(ctx, id); err == nil {
+			keys = append(keys, stored.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"scheme": gin.H{
+			"headers":           []string{requestKeyHeader, requestNonceHeader, requestSignatureHeader},
+			"format":            "t=<unix>,v1=<hex>",
+			"algorithm":         "HMAC-SHA256",
+			"signed_payload":    "<t>.<nonce>.<METHOD>.<path?query>.<body>",
+			"tolerance_seconds": int(requestSignatureTolerance.Seconds()),
+		},
+		"keys": keys,
+	})
+}
+
+// deleteSigningKey revokes a key; requests it signs are refused at once.
+func deleteSigningKey(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok || !requireTokenCaller(c) {
+		return
+	}
+	keyID := c.Param("key_id")
+	tenantID := requestTenant(c).ID
+	ctx := c.Request.Context()
+	ids, err := userSigningKeyIDs(ctx, tenantID, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load signing keys", err))
+		return
+	}
+	if !slices.Contains(ids, keyID) {
+		respondError(c, newAPIError(http.StatusNotFound, "Signing key '"+keyID+"' not found"))
+		return
+	}
+	err = updateUserSigningKeyIDs(ctx, tenantID, userID, func(ids []string) ([]string, error) {
+		return slices.DeleteFunc(ids, func(id string) bool { return id == keyID }), nil
+	})
+	if err == nil {
+		err = stateStore.Delete(ctx, signingKeyKey(keyID))
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to delete signing key", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Signing key '" + keyID + "' deleted",
+	})
+}
+
+func signedRequest(c *gin.Context) bool {
+	return c.GetHeader(requestSignatureHeader) != ""
+}
+
+// signedAccount authenticates a request signed with a signing key. The
+// timestamp must be within requestSignatureTolerance, the signature must
+// cover this exact request, and the nonce must not have been seen before.
+func signedAccount(c *gin.Context) (map[string]interface{}, bool) {
+	unauthorized := func(message string, code string) (map[string]interface{}, bool) {
+		apiErr := newAPIError(http.StatusUnauthorized, message)
+		if code != "" {
+			apiErr = apiErr.withCode(code)
+		}
+		respondError(c, apiErr)
+		return nil, false
+	}
+
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(c.GetHeader(requestSignatureHeader), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	keyID := c.GetHeader(requestKeyHeader)
+	nonce := c.GetHeader(requestNonceHeader)
+	if keyID == "" || timestamp == 0 || signature == "" || len(nonce) < 16 || len(nonce) > 128 {
+		return unauthorized("signed requests need "+requestKeyHeader+", a "+requestNonceHeader+" of 16 to 128 characters, and "+requestSignatureHeader+": t=<unix>,v1=<hex>", "")
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > requestSignatureTolerance || skew < -requestSignatureTolerance {
+		return unauthorized("request timestamp is outside the allowed window", "stale_request")
+	}
+
+	ctx := c.Request.Context()
+	stored, err := loadSigningKey(ctx, keyID)
+	if err != nil || stored.TenantID != requestTenant(c).ID {
+		return unauthorized("unknown signing key", "")
+	}
+	secret, err := openValue(stored.SealedSecret, []byte(keyID))
+	if err != nil {
+		return unauthorized("signing key can no longer be read; create a new one", "")
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "request body could not be read"))
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	expected := signWebhookPayload(string(secret), timestamp, signedPayload(nonce, c.Request.Method, c.Request.URL.RequestURI(), body))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return unauthorized("invalid request signature", "")
+	}
+
+	fresh, err := stateStore.SetNX(ctx, "request_nonce:"+keyID+":"+nonce, []byte("1"), 2*requestSignatureTolerance)
+	if err != nil {
+		respondError(c, internalError("Failed to check request nonce", err))
+		return nil, false
+	}
+	if !fresh {
+		return unauthorized("request has already been used", "replayed_request")
+	}
+
+	// Record the use, and reseal the secret under the newest session key so
+	// keys in regular use survive a session key rotation.
+	now := models.Now()
+	err = stateStore.Update(ctx, signingKeyKey(keyID), 0, func(current []byte) ([]byte, error) {
+		var record storedSigningKey
+		if err := json.Unmarshal(current, &record); err != nil {
+			return nil, err
+		}
+		record.Key.LastUsedAt = now
+		if sealed, err := sealValue(secret, []byte(keyID)); err == nil {
+			record.SealedSecret = sealed
+		}
+		return json.Marshal(record)
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Failed to record signing key use", err))
+		return nil, false
+	}
+
+	account := map[string]interface{}{"localId": stored.UserID}
+	if stored.Email != "" {
+		account["email"] = stored.Email
+		account["emailVerified"] = stored.EmailVerified
+	}
+	c.Set("user_id", stored.UserID)
+	c.Set("signing_key_id", keyID)
+	c.Set("account", account)
+	return account, true
+}
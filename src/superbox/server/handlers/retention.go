@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const artifactLifecycleInterval = 24 * time.Hour
+
+// retentionKey holds the transitions a publisher set for one server.
+func retentionKey(tenantID string, serverName string) string {
+	return "retention:" + tenantID + ":" + serverName
+}
+
+// storedRetention returns a server's own transitions, or nil when it has
+// none.
+func storedRetention(ctx context.Context, tenantID string, serverName string) (*models.RetentionPolicy, error) {
+	data, err := stateStore.Get(ctx, retentionKey(tenantID, serverName))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var policy models.RetentionPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// effectiveRetention returns the policy applied to a server's bundles. A
+// server's own transitions only apply while its owner's plan allows them;
+// after a downgrade they are kept but the deployment's apply again.
+func effectiveRetention(ctx context.Context, tenantID string, server models.Server) (models.RetentionPolicy, error) {
+	policy := models.RetentionPolicy{
+		ServerName:         server.Name,
+		Transitions:        []models.StorageTransition{},
+		DraftRetentionDays: appConfig.DraftRetentionDays,
+	}
+	for _, transition := range appConfig.ArtifactTransitions {
+		policy.Transitions = append(policy.Transitions, models.StorageTransition(transition))
+	}
+
+	stored, err := storedRetention(ctx, tenantID, server.Name)
+	if err != nil || stored == nil {
+		return policy, err
+	}
+	if !userPlan(ctx, tenantID, server.Meta.OwnerID).RetentionOverrides {
+		return policy, nil
+	}
+	stored.DraftRetentionDays = policy.DraftRetentionDays
+	return *stored, nil
+}
+
+// storageClassRank orders storage classes by how far down the transition
+// list they are; standard storage comes first.
+func storageClassRank(class string) int {
+	return slices.Index(config.StorageClasses, class)
+}
+
+func getRetention(c *gin.Context) {
+	serverName := c.Param("server_name")
+	_, server, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+
+	policy, err := effectiveRetention(c.Request.Context(), requestTenant(c).ID, server)
+	if err != nil {
+		respondError(c, internalError("Error loading retention policy", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"retention": policy,
+	})
+}
+
+func updateRetention(c *gin.Context) {
+	serverName := c.Param("server_name")
+	userID, server, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+	if plan := callerPlan(c); !plan.RetentionOverrides {
+		respondError(c, newAPIError(http.StatusForbidden, "The "+plan.Name+" plan does not include custom artifact retention; upgrade to set it").
+			withCode("plan_required").
+			withDetail("plan", plan.Name))
+		return
+	}
+
+	var req models.UpdateRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	transitions := make([]config.StorageTransition, 0, len(req.Transitions))
+	for _, transition := range req.Transitions {
+		transitions = append(transitions, config.StorageTransition(transition))
+	}
+	if err := config.CheckTransitions(transitions); err != nil {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "transitions", Message: err.Error()}}
+		respondError(c, apiErr)
+		return
+	}
+
+	policy := models.RetentionPolicy{
+		ServerName:         server.Name,
+		Custom:             true,
+		Transitions:        req.Transitions,
+		DraftRetentionDays: appConfig.DraftRetentionDays,
+		UpdatedBy:          userID,
+		UpdatedAt:          models.Now(),
+	}
+	record, err := json.Marshal(policy)
+	if err != nil {
+		respondError(c, internalError("Error saving retention policy", err))
+		return
+	}
+	if err := stateStore.Set(c.Request.Context(), retentionKey(requestTenant(c).ID, server.Name), record, 0); err != nil {
+		respondError(c, internalError("Error saving retention policy", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"retention": policy,
+	})
+}
+
+// resetRetention drops a server's own transitions so the deployment's
+// apply. Bundles already moved stay where they are.
+func resetRetention(c *gin.Context) {
+	serverName := c.Param("server_name")
+	_, server, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := stateStore.Delete(ctx, retentionKey(requestTenant(c).ID, server.Name)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondError(c, internalError("Error resetting retention policy", err))
+		return
+	}
+	policy, err := effectiveRetention(ctx, requestTenant(c).ID, server)
+	if err != nil {
+		respondError(c, internalError("Error loading retention policy", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"retention": policy,
+	})
+}
+
+// moveRetention carries a renamed server's own transitions over to its new
+// name.
+func moveRetention(ctx context.Context, tenantID string, from string, to string) error {
+	stored, err := storedRetention(ctx, tenantID, from)
+	if err != nil || stored == nil {
+		return err
+	}
+	stored.ServerName = to
+	record, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := stateStore.Set(ctx, retentionKey(tenantID, to), record, 0); err != nil {
+		return err
+	}
+	return stateStore.Delete(ctx, retentionKey(tenantID, from))
+}
+
+// checkBundle validates a bundle named in a publish: it must be a finished
+// upload by the server's owner that no other version was published with.
+func checkBundle(c *gin.Context, bundleID string, ownerID string, serverName string, version string) bool {
+	blob, err := getBlobCopy(c.Request.Context(), bundleID)
+	if err != nil {
+		respondError(c, internalError("Error loading bundle", err))
+		return false
+	}
+	if blob == nil || blob.TenantID != requestTenant(c).ID || blob.Class != "bundle" || blob.OwnerID != ownerID {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "bundle_id", Message: "no bundle '" + bundleID + "' was uploaded by the publisher"}}
+		respondError(c, apiErr)
+		return false
+	}
+	if blob.Status != "ready" {
+		respondError(c, newAPIError(http.StatusConflict, "Bundle '"+bundleID+"' is "+blob.Status+", not ready"))
+		return false
+	}
+	if blob.ServerName != "" && (blob.ServerName != serverName || blob.Version != version) {
+		respondError(c, newAPIError(http.StatusConflict, fmt.Sprintf("Bundle '%s' is already published as %s %s", bundleID, blob.ServerName, blob.Version)))
+		return false
+	}
+	return true
+}
+
+// attachBundle records which published version a bundle belongs to, which
+// keeps it from being deleted as a draft.
+func attachBundle(ctx context.Context, bundleID string, serverName string, version string) error {
+	_, err := updateBlob(ctx, bundleID, func(stored *models.Blob) {
+		stored.ServerName = serverName
+		stored.Version = version
+		stored.DetachedAt = time.Time{}
+	})
+	return err
+}
+
+// publishedBundle is the version a bundle was published with. SupersededAt
+// is when the next version was published, zero while it is current.
+type publishedBundle struct {
+	Server       models.Server
+	Version      string
+	SupersededAt time.Time
+}
+
+func publishedBundles(servers map[string]models.Server) map[string]publishedBundle {
+	published := make(map[string]publishedBundle)
+	for _, server := range servers {
+		for i, version := range server.Versions {
+			if version.BundleID == "" {
+				continue
+			}
+			entry := publishedBundle{Server: server, Version: version.Version}
+			if i+1 < len(server.Versions) {
+				entry.SupersededAt = server.Versions[i+1].PublishedAt
+			}
+			published[version.BundleID] = entry
+		}
+	}
+	return published
+}
+
+// runArtifactLifecycle applies retention to every bundle. Which version a
+// bundle belongs to is read from the server records, so renames, deletions,
+// and publishes approved by moderation are all picked up. Bundles of
+// superseded versions move to the storage class of the last transition
+// they have waited for; bundles no published version uses are drafts and
+// are deleted once they have been one for DRAFT_RETENTION_DAYS.
+func runArtifactLifecycle(ctx context.Context) error {
+	now := time.Now()
+	bundles := make(map[string][]models.Blob)
+	all, err := allBlobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, blob := range all {
+		if blob.Class == "bundle" && blob.Status == "ready" {
+			bundles[blob.TenantID] = append(bundles[blob.TenantID], blob)
+		}
+	}
+
+	failed, total := 0, 0
+	for tenantID, tenantBundles := range bundles {
+		tenantCtx := withTenant(ctx, tenantByID(tenantID))
+		servers, err := registryServers(tenantCtx)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", tenantID, err)
+		}
+		published := publishedBundles(servers)
+		policies := make(map[string]models.RetentionPolicy)
+		for _, bundle := range tenantBundles {
+			total++
+			entry, exists := published[bundle.ID]
+			if !exists {
+				err = expireDraftBundle(tenantCtx, bundle, now)
+			} else {
+				policy, cached := policies[entry.Server.Name]
+				if !cached {
+					if policy, err = effectiveRetention(tenantCtx, tenantID, entry.Server); err == nil {
+						policies[entry.Server.Name] = policy
+					}
+				}
+				if err == nil {
+					err = transitionBundle(tenantCtx, bundle, entry, policy, now)
+				}
+			}
+			if err != nil {
+				slog.Warn("failed to apply artifact retention", "blob_id", bundle.ID, "error", err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("retention could not be applied to %d of %d bundles", failed, total)
+	}
+	return nil
+}
+
+// expireDraftBundle starts a bundle's draft clock when it stops belonging to
+// a published version, and deletes it once the clock runs out.
+func expireDraftBundle(ctx context.Context, bundle models.Blob, now time.Time) error {
+	if bundle.ServerName != "" {
+		_, err := updateBlob(ctx, bundle.ID, func(stored *models.Blob) {
+			stored.ServerName = ""
+			stored.Version = ""
+			stored.DetachedAt = models.Timestamp(now)
+		})
+		return err
+	}
+	if appConfig.DraftRetentionDays == 0 {
+		return nil
+	}
+	draftSince := bundle.CreatedAt
+	if bundle.DetachedAt.After(draftSince) {
+		draftSince = bundle.DetachedAt
+	}
+	if now.Sub(draftSince) < time.Duration(appConfig.DraftRetentionDays)*24*time.Hour {
+		return nil
+	}
+	slog.Info("deleting draft bundle", "blob_id", bundle.ID, "owner_id", bundle.OwnerID)
+	return removeBlob(ctx, bundle)
+}
+
+// transitionBundle moves a superseded version's bundle to the storage class
+// its policy calls for by now. Bundles are only ever moved further down
+// StorageClasses, never back.
+func transitionBundle(ctx context.Context, bundle models.Blob, entry publishedBundle, policy models.RetentionPolicy, now time.Time) error {
+	if bundle.ServerName != entry.Server.Name || bundle.Version != entry.Version {
+		if err := attachBundle(ctx, bundle.ID, entry.Server.Name, entry.Version); err != nil {
+			return err
+		}
+	}
+	if entry.SupersededAt.IsZero() {
+		return nil
+	}
+
+	target := ""
+	for _, transition := range policy.Transitions {
+		if now.Sub(entry.SupersededAt) >= time.Duration(transition.AfterDays)*24*time.Hour {
+			target = transition.StorageClass
+		}
+	}
+	if target == "" || storageClassRank(target) <= storageClassRank(bundle.StorageClass) {
+		return nil
+	}
+
+	_, err := callBlobStorage(ctx, bundle, "set_storage_class", map[string]interface{}{
+		"key":           bundle.Key,
+		"storage_class": target,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = updateBlob(ctx, bundle.ID, func(stored *models.Blob) {
+		stored.StorageClass = target
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("moved bundle to colder storage", "blob_id", bundle.ID, "server", entry.Server.Name, "version", entry.Version, "storage_class", target)
+	return nil
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverReviews are listed per tenant and server.
+var serverReviews = recordSet[models.ServerReview]{
+	kind:  "server_review",
+	group: func(review *models.ServerReview) string { return review.TenantID + "/" + review.ServerName },
+}
+
+// errReviewNotFound is returned from a review update when the review belongs
+// to another tenant or server.
+var errReviewNotFound = errors.New("review not found")
+
+// reviewID is the ID of a user's review of a server, the same each time so
+// a second review by the same user collides with the first.
+func reviewID(tenantID string, userID string, serverName string) string {
+	sum := sha256.Sum256([]byte(tenantID + "/" + userID + "/" + serverName))
+	return "rev_" + hex.EncodeToString(sum[:12])
+}
+
+// createReview adds the caller's review of a server and tells its publisher.
+// Publishers cannot review their own servers.
+func createReview(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	serverName := c.Param("server_name")
+	server, err := snapshotServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if server.Meta.OwnerID == userID {
+		respondError(c, newAPIError(http.StatusForbidden, "You cannot review your own server").withCode("own_server"))
+		return
+	}
+
+	tenantID := requestTenant(c).ID
+	review := &models.ServerReview{
+		ID:         reviewID(tenantID, userID, serverName),
+		TenantID:   tenantID,
+		ServerName: serverName,
+		Rating:     req.Rating,
+		Body:       req.Body,
+		ReviewedBy: userID,
+		ReviewedAt: models.Now(),
+	}
+	created, err := serverReviews.create(c.Request.Context(), review.ID, review)
+	if err != nil {
+		respondError(c, internalError("Error saving review", err))
+		return
+	}
+	if !created {
+		respondError(c, newAPIError(http.StatusConflict, "You have already reviewed '"+serverName+"'").
+			withCode("review_exists").
+			withDetail("review_id", review.ID))
+		return
+	}
+
+	if server.Meta.OwnerID != "" {
+		notify(c.Request.Context(), server.Meta.OwnerID, "review.created", "New review of "+serverName, map[string]interface{}{
+			"review_id":   review.ID,
+			"server_name": serverName,
+			"rating":      review.Rating,
+		})
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"review": review,
+	})
+}
+
+// respondToReview sets the publisher's official response to a review. Each
+// review has one response; responding again replaces it, and the reviewer
+// is notified the first time.
+func respondToReview(c *gin.Context) {
+	serverName := c.Param("server_name")
+	userID, _, ok := requireServerOwner(c, serverName)
+	if !ok {
+		return
+	}
+
+	var req models.RespondToReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	tenantID := requestTenant(c).ID
+	var before models.ServerReview
+	updated, err := serverReviews.update(c.Request.Context(), c.Param("review_id"), func(review *models.ServerReview) error {
+		if review.TenantID != tenantID || review.ServerName != serverName {
+			return errReviewNotFound
+		}
+		before = *review
+		review.Response = req.Response
+		review.RespondedBy = userID
+		review.RespondedAt = models.Now()
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, errReviewNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Review not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error saving response", err))
+		return
+	}
+
+	if before.Response == "" && updated.ReviewedBy != userID {
+		notify(c.Request.Context(), updated.ReviewedBy, "review.responded", "The publisher of "+serverName+" responded to your review", map[string]interface{}{
+			"review_id":   updated.ID,
+			"server_name": serverName,
+		})
+	}
+	auditChange(c, before, *updated)
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"review": updated,
+	})
+}
+
+// listServerReviews lists a server's reviews newest first, with their
+// average rating.
+func listServerReviews(c *gin.Context) {
+	serverName := c.Param("server_name")
+	if _, err := snapshotServer(c.Request.Context(), serverName); err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	result, err := serverReviews.listGroup(c.Request.Context(), requestTenant(c).ID+"/"+serverName)
+	if err != nil {
+		respondError(c, internalError("Error loading reviews", err))
+		return
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ReviewedAt != result[j].ReviewedAt {
+			return result[i].ReviewedAt.After(result[j].ReviewedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	average := 0.0
+	for _, review := range result {
+		average += float64(review.Rating)
+	}
+	if len(result) > 0 {
+		average /= float64(len(result))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"total":          len(result),
+		"average_rating": average,
+		"reviews":        result,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	riskReviewThreshold = 50
+	riskBlockThreshold  = 80
+	riskVelocityWindow  = time.Hour
+)
+
+var disposableEmailDomains = map[string]bool{
+	"10minutemail.com":  true,
+	"guerrillamail.com": true,
+	"mailinator.com":    true,
+	"sharklasers.com":   true,
+	"temp-mail.org":     true,
+	"tempmail.com":      true,
+	"throwawaymail.com": true,
+	"trashmail.com":     true,
+	"yopmail.com":       true,
+}
+
+func scoreOrder(ctx context.Context, userID string, account map[string]interface{}, currency string, country string) (*models.RiskAssessment, error) {
+	assessment := &models.RiskAssessment{Reasons: []string{}}
+
+	since := models.Timestamp(time.Now().Add(-riskVelocityWindow))
+	recent, err := recentOrderCount(ctx, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case recent >= 5:
+		assessment.Score += 60
+		assessment.Reasons = append(assessment.Reasons, "high_velocity")
+	case recent >= 3:
+		assessment.Score += 30
+		assessment.Reasons = append(assessment.Reasons, "elevated_velocity")
+	}
+
+	email, _ := account["email"].(string)
+	if at := strings.LastIndex(email, "@"); at >= 0 && disposableEmailDomains[strings.ToLower(email[at+1:])] {
+		assessment.Score += 40
+		assessment.Reasons = append(assessment.Reasons, "disposable_email")
+	}
+	if verified, _ := account["emailVerified"].(bool); !verified {
+		assessment.Score += 10
+		assessment.Reasons = append(assessment.Reasons, "unverified_email")
+	}
+
+	if currency == "INR" && country != "" && country != "IN" {
+		assessment.Score += 30
+		assessment.Reasons = append(assessment.Reasons, "geo_currency_mismatch")
+	}
+
+	switch {
+	case assessment.Score >= riskBlockThreshold:
+		assessment.Decision = "block"
+	case assessment.Score >= riskReviewThreshold:
+		assessment.Decision = "review"
+	default:
+		assessment.Decision = "allow"
+	}
+	return assessment, nil
+}
+
+func listHeldOrders(c *gin.Context) {
+	held, err := ordersWithStatus(c.Request.Context(), "held")
+	if err != nil {
+		respondError(c, internalError("Error listing held orders", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"total":  len(held),
+		"orders": held,
+	})
+}
+
+func approveHeldOrder(c *gin.Context) {
+	reviewOrder(c, "approved")
+}
+
+func rejectHeldOrder(c *gin.Context) {
+	reviewOrder(c, "rejected")
+}
+
+func reviewOrder(c *gin.Context, status string) {
+	orderID := c.Param("order_id")
+	order, err := reviewHeldOrder(c.Request.Context(), orderID, status)
+	if err != nil {
+		respondError(c, internalError("Error reviewing order", err))
+		return
+	}
+	if order == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Held order '"+orderID+"' not found"))
+		return
+	}
+	auditChange(c, gin.H{"status": "held"}, gin.H{"status": order.Status})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"order":  order,
+	})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonSchemaDialect is the JSON Schema draft the documents at /schemas use.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaModels are the request and response payloads published at
+// /schemas/<name>.json. Types they reach are carried in each document's
+// $defs, so every document validates on its own.
+var schemaModels = []interface{}{
+	models.AuthRegisterRequest{},
+	models.AuthLoginRequest{},
+	models.AuthProviderRequest{},
+	models.AuthRefreshRequest{},
+	models.AuthUpdateRequest{},
+	models.AuthDeviceStartRequest{},
+	models.AuthDevicePollRequest{},
+	models.AuthResponse{},
+	models.AuthUserProfile{},
+	models.TokenExchangeRequest{},
+	models.TokenExchangeResponse{},
+	models.GrantConsentRequest{},
+	models.PartnerConsent{},
+	models.CreateSigningKeyRequest{},
+	models.RequestSigningKey{},
+	models.CreateAPIKeyRequest{},
+	models.APIKey{},
+	models.RegisterDeviceClientRequest{},
+	models.DeviceClient{},
+
+	models.CreateServerRequest{},
+	models.UpdateServerRequest{},
+	models.ImportServersRequest{},
+	models.ServerResponse{},
+	models.ServerListV2{},
+	models.ServerV2Response{},
+	models.LintResponse{},
+	models.PriceChange{},
+	models.CreateServerClaimRequest{},
+	models.VerifyServerClaimRequest{},
+	models.ServerClaim{},
+	models.CreateAdvisoryRequest{},
+	models.Advisory{},
+	models.ServerQuestion{},
+	models.AskQuestionRequest{},
+	models.AnswerQuestionRequest{},
+	models.ServerReview{},
+	models.CreateReviewRequest{},
+	models.RespondToReviewRequest{},
+	models.ModerationHold{},
+	models.RegistryCheckRequest{},
+	models.RegistryIssue{},
+
+	models.CreateOrderRequest{},
+	models.VerifyPaymentRequest{},
+	models.OrderResponse{},
+	models.PaymentResponse{},
+	models.PaymentLink{},
+	models.CreatePaymentLinkRequest{},
+	models.VerifyPaymentLinkRequest{},
+	models.RetentionPolicy{},
+	models.UpdateRetentionRequest{},
+	models.Entitlement{},
+	models.CheckEntitlementsRequest{},
+	models.EntitlementCheck{},
+	models.EntitlementListV2{},
+	models.ChangePlanRequest{},
+	models.Proration{},
+	models.BillingProfileRequest{},
+	models.BillingProfile{},
+	models.BillingManager{},
+	models.Invoice{},
+	models.RevenueReport{},
+
+	models.CreateUploadRequest{},
+	models.PresignPartsRequest{},
+	models.BlobUpload{},
+	models.Blob{},
+	models.BlobListV2{},
+
+	models.CreateWebhookRequest{},
+	models.RotateWebhookSecretRequest{},
+	models.WebhookSignaturePreviewRequest{},
+	models.PublisherWebhook{},
+	models.WebhookSignature{},
+	models.WebhookDelivery{},
+
+	models.OperationResponse{},
+	models.Notification{},
+	models.NotificationListV2{},
+	models.UpdateNotificationPreferencesRequest{},
+	models.NotificationPreferences{},
+	models.UpdateDataResidencyRequest{},
+	models.DataResidency{},
+	models.UpdateInstalledServersRequest{},
+	models.ServerUpdate{},
+	models.AuditEntry{},
+}
+
+var (
+	jsonSchemas     map[string]map[string]interface{}
+	jsonSchemasOnce sync.Once
+)
+
+// buildJSONSchemas builds one standalone document per model, plus Error for
+// the error object every failed request carries. Models named *Request
+// describe what the handlers accept, so their required fields and limits
+// come from the binding tags.
+func buildJSONSchemas() map[string]map[string]interface{} {
+	documents := map[string]map[string]interface{}{
+		"Error": jsonSchemaDocument(reflect.TypeOf(APIError{}), false),
+	}
+	for _, model := range schemaModels {
+		t := reflect.TypeOf(model)
+		documents[t.Name()] = jsonSchemaDocument(t, strings.HasSuffix(t.Name(), "Request"))
+	}
+	return documents
+}
+
+func jsonSchemaDocument(t reflect.Type, request bool) map[string]interface{} {
+	builder := &schemaBuilder{defs: map[string]interface{}{}, refPrefix: "#/$defs/", request: request}
+	document := builder.object(t)
+	document["$schema"] = jsonSchemaDialect
+	document["$id"] = "/schemas/" + t.Name() + ".json"
+	document["title"] = t.Name()
+	document["x-schema-version"] = schemaVersion
+	if len(builder.defs) > 0 {
+		document["$defs"] = builder.defs
+	}
+	return document
+}
+
+func publishedSchemas() map[string]map[string]interface{} {
+	jsonSchemasOnce.Do(func() {
+		jsonSchemas = buildJSONSchemas()
+	})
+	return jsonSchemas
+}
+
+func listJSONSchemas(c *gin.Context) {
+	names := make([]string, 0, len(publishedSchemas()))
+	for name := range publishedSchemas() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schemas := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		schemas = append(schemas, gin.H{"name": name, "url": "/schemas/" + name + ".json"})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"schema_version": schemaVersion,
+		"dialect":        jsonSchemaDialect,
+		"schemas":        schemas,
+	})
+}
+
+func getJSONSchema(c *gin.Context) {
+	name := strings.TrimSuffix(c.Param("name"), ".json")
+	document, ok := publishedSchemas()[name]
+	if !ok {
+		respondError(c, newAPIError(http.StatusNotFound, "Schema '"+name+"' not found"))
+		return
+	}
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, document)
+}
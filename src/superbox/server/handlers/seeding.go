@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"superbox/server/models"
+	"superbox/server/seed"
+	"superbox/server/store"
+)
+
+// seededOrdersKey holds the sandbox orders `server seed` created. Orders
+// live in each serving process's memory, so the seed command leaves them in
+// the state store and serve loads them at startup.
+const seededOrdersKey = "seed:orders"
+
+// seededOrder is a sandbox order and whether seeding pays for it.
+type seededOrder struct {
+	Order models.Order `json:"order"`
+	Paid  bool         `json:"paid"`
+}
+
+// SeedReport counts what SeedFixtures wrote. Existing users are reused and
+// existing servers are skipped unless overwrite is set.
+type SeedReport struct {
+	UsersCreated  int
+	UsersExisting int
+	ServersSeeded int
+	OrdersSeeded  int
+	// AdminIDs are the IDs of fixture users marked admin, to add to
+	// ADMIN_UIDS.
+	AdminIDs []string
+}
+
+// SeedFixtures creates the fixture users in the identity provider, writes
+// the fixture servers with their owners, and records the sandbox orders,
+// all in the default tenant. Running it again converges on the same state.
+func SeedFixtures(ctx context.Context, fixtures *seed.Fixtures, overwrite bool) (SeedReport, error) {
+	report := SeedReport{}
+	userIDs := map[string]string{}
+	for _, user := range fixtures.Users {
+		localID, created, err := seedUser(ctx, user)
+		if err != nil {
+			return report, fmt.Errorf("failed to seed user %s: %w", user.Email, err)
+		}
+		userIDs[user.Email] = localID
+		if created {
+			report.UsersCreated++
+		} else {
+			report.UsersExisting++
+		}
+		if user.Admin {
+			report.AdminIDs = append(report.AdminIDs, localID)
+		}
+	}
+
+	servers := make([]models.Server, 0, len(fixtures.Servers))
+	for _, fixture := range fixtures.Servers {
+		server := fixture.Server
+		if fixture.Owner != "" {
+			server.Meta.OwnerID = userIDs[fixture.Owner]
+		}
+		if server.Meta.CreatedAt.IsZero() {
+			server.Meta.CreatedAt = models.Now()
+			server.Meta.UpdatedAt = server.Meta.CreatedAt
+		}
+		servers = append(servers, server)
+	}
+	seeded, err := SeedServers(ctx, servers, overwrite)
+	report.ServersSeeded = seeded
+	if err != nil {
+		return report, err
+	}
+
+	orders := make([]seededOrder, 0, len(fixtures.Orders))
+	for i, fixture := range fixtures.Orders {
+		order, err := sandboxOrder(ctx, userIDs[fixture.User], fixture)
+		if err != nil {
+			return report, fmt.Errorf("failed to seed order %d: %w", i, err)
+		}
+		orders = append(orders, seededOrder{Order: order, Paid: fixture.Status == "paid"})
+	}
+	if err := storeSeededOrders(ctx, orders); err != nil {
+		return report, fmt.Errorf("failed to store sandbox orders: %w", err)
+	}
+	report.OrdersSeeded = len(orders)
+	return report, nil
+}
+
+// seedUser signs a fixture user up, or signs them in when the email is
+// already registered, and returns their ID.
+func seedUser(ctx context.Context, user seed.User) (string, bool, error) {
+	payload := map[string]interface{}{
+		"email":             user.Email,
+		"password":          user.Password,
+		"returnSecureToken": true,
+	}
+	if user.DisplayName != "" {
+		payload["displayName"] = user.DisplayName
+	}
+	data, err := identityCall(ctx, "accounts:signUp", payload)
+	created := true
+	var firebaseErr *firebaseError
+	if errors.As(err, &firebaseErr) && firebaseErr.Code == "EMAIL_EXISTS" {
+		delete(payload, "displayName")
+		data, err = identityCall(ctx, "accounts:signInWithPassword", payload)
+		created = false
+	}
+	if err != nil {
+		return "", false, err
+	}
+	localID, _ := data["localId"].(string)
+	if localID == "" {
+		return "", false, fmt.Errorf("identity provider returned no user ID")
+	}
+	return localID, created, nil
+}
+
+func identityCall(ctx context.Context, endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+	body, _ := json.Marshal(payload)
+	req, err := newOutboundRequest(ctx, "POST", identityURL(endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doUpstream("firebase", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return parseFirebaseResponse(resp)
+}
+
+// sandboxOrder builds a fixture order for a plan of a seeded server. Its ID
+// is derived from the buyer, server, and plan, so seeding again replaces
+// the order instead of adding another.
+func sandboxOrder(ctx context.Context, userID string, fixture seed.Order) (models.Order, error) {
+	server, err := fetchServer(ctx, fixture.Server)
+	if err != nil {
+		return models.Order{}, err
+	}
+	plan, err := resolvePlan(&server.Pricing, fixture.Plan, 0)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if plan.Amount <= 0 {
+		return models.Order{}, fmt.Errorf("plan '%s' of %s is free and does not take an order", plan.Name, server.Name)
+	}
+
+	commission, err := commissionFor(ctx, server.Meta.OwnerID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	tenantID := tenantFrom(ctx).ID
+	sum := sha256.Sum256([]byte(tenantID + "/" + userID + "/" + server.Name + "/" + plan.Name))
+	currency := pricingCurrency(server.Pricing)
+	return models.Order{
+		ID:            "seed_" + hex.EncodeToString(sum[:8]),
+		TenantID:      tenantID,
+		Kind:          "purchase",
+		UserID:        userID,
+		ServerName:    server.Name,
+		Plan:          plan.Name,
+		Period:        plan.Period,
+		Amount:        plan.Amount,
+		Currency:      currency,
+		Provider:      "sandbox",
+		Publisher:     server.Author,
+		OwnerID:       server.Meta.OwnerID,
+		CommissionPct: commission,
+		Status:        "created",
+		CreatedAt:     models.Now(),
+	}, nil
+}
+
+// storeSeededOrders merges orders into the seeded set, replacing orders
+// with the same ID, and loads them into the order book.
+func storeSeededOrders(ctx context.Context, orders []seededOrder) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	if _, err := stateStore.SetNX(ctx, seededOrdersKey, []byte("[]"), 0); err != nil {
+		return err
+	}
+	err := stateStore.Update(ctx, seededOrdersKey, 0, func(current []byte) ([]byte, error) {
+		existing := []seededOrder{}
+		if err := json.Unmarshal(current, &existing); err != nil {
+			return nil, err
+		}
+		replaced := map[string]bool{}
+		for _, order := range orders {
+			replaced[order.Order.ID] = true
+		}
+		merged := append([]seededOrder{}, orders...)
+		for _, order := range existing {
+			if !replaced[order.Order.ID] {
+				merged = append(merged, order)
+			}
+		}
+		return json.Marshal(merged)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = LoadSeededOrders(ctx)
+	return err
+}
+
+// LoadSeededOrders adds the seeded sandbox orders to the order book, paying
+// for and granting those marked paid. Orders it already holds are left
+// alone, so later purchases and plan changes are not undone.
+func LoadSeededOrders(ctx context.Context) (int, error) {
+	data, err := stateStore.Get(ctx, seededOrdersKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var seeded []seededOrder
+	if err := json.Unmarshal(data, &seeded); err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for _, entry := range seeded {
+		order := entry.Order
+		created, err := orders.create(ctx, order.ID, &order)
+		if err != nil {
+			return loaded, err
+		}
+		if !created {
+			continue
+		}
+		if entry.Paid {
+			if _, _, err := settleOrder(ctx, order.ID, "pay_"+order.ID, "seed"); err != nil {
+				slog.Warn("failed to settle seeded order", "order_id", order.ID, "error", err)
+				continue
+			}
+		}
+		loaded++
+	}
+	return loaded, nil
+}
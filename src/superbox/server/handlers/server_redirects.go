@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRedirectHops bounds how far a redirect is followed. A rename points
+// every previous slug straight at the new one, so one hop is the norm.
+const maxRedirectHops = 8
+
+// serverRedirectKey names the redirect left at a server's old slug. Slugs
+// are per tenant, so the key carries the tenant.
+func serverRedirectKey(tenantID string, slug string) string {
+	return "server_redirect:" + tenantID + ":" + slug
+}
+
+// resolveServerRedirect returns the slug a renamed server now lives at,
+// following later renames. It reports false for slugs that were never
+// renamed.
+func resolveServerRedirect(ctx context.Context, slug string) (string, bool, error) {
+	tenantID := tenantFrom(ctx).ID
+	target := slug
+	for range maxRedirectHops {
+		next, err := stateStore.Get(ctx, serverRedirectKey(tenantID, target))
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return "", false, err
+		}
+		target = string(next)
+	}
+	return target, target != slug, nil
+}
+
+// recordServerRedirect points an old slug at the one its server was renamed
+// to. Redirects never expire: a published slug keeps resolving, and cannot
+// be taken by another server, for as long as its server exists.
+func recordServerRedirect(ctx context.Context, from string, to string) error {
+	return stateStore.Set(ctx, serverRedirectKey(tenantFrom(ctx).ID, from), []byte(to), 0)
+}
+
+func dropServerRedirect(ctx context.Context, slug string) error {
+	return stateStore.Delete(ctx, serverRedirectKey(tenantFrom(ctx).ID, slug))
+}
+
+// reservedSlug reports which live server an old slug redirects to. A slug
+// whose server has since been deleted is free again; publishing at it drops
+// the stale redirect.
+func reservedSlug(ctx context.Context, slug string) (string, bool, error) {
+	target, renamed, err := resolveServerRedirect(ctx, slug)
+	if err != nil || !renamed {
+		return "", false, err
+	}
+	if _, err := fetchServer(ctx, target); errors.Is(err, errServerNotFound) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return target, true, nil
+}
+
+// fetchRenamedServer loads a server by a name it has or had, for records
+// such as subscriptions that keep the name the server was bought under.
+func fetchRenamedServer(ctx context.Context, slug string) (models.Server, error) {
+	target, _, err := resolveServerRedirect(ctx, slug)
+	if err != nil {
+		return models.Server{}, err
+	}
+	return fetchServer(ctx, target)
+}
+
+func slugReserved(slug string, target string) *APIError {
+	return newAPIError(http.StatusConflict, "Server name '"+slug+"' is reserved: it redirects to '"+target+"'").withCode("slug_reserved")
+}
+
+// renameServerRecord finishes a rename once the server is saved under its
+// new slug: the new slug stops redirecting, every previous one points
+// straight at it, and the old record goes. The redirects are written before
+// the old record is deleted so the old slug never answers 404.
+func renameServerRecord(ctx context.Context, server models.Server, from string) error {
+	if err := dropServerRedirect(ctx, server.Name); err != nil {
+		return err
+	}
+	for _, previous := range server.PreviousNames {
+		if err := recordServerRedirect(ctx, previous, server.Name); err != nil {
+			return err
+		}
+	}
+	return deleteServerRecord(ctx, from)
+}
+
+// invalidSlug is the error for a name outside serverNamePattern.
+func invalidSlug(field string) *APIError {
+	apiErr := validationFailed()
+	apiErr.Fields = []FieldError{{Field: field, Message: "must be lowercase letters, digits, '.', '_' or '-', starting and ending with a letter or digit; put other text in display_name"}}
+	return apiErr
+}
+
+// ServerRedirects sends requests for a renamed server's old slug to the same
+// route under its current slug: 301 for reads, and 308 for writes so the
+// method and body are kept.
+func ServerRedirects() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := c.Param("server_name")
+		if slug == "" {
+			c.Next()
+			return
+		}
+		target, renamed, err := resolveServerRedirect(c.Request.Context(), slug)
+		if err != nil || !renamed {
+			c.Next()
+			return
+		}
+
+		prefix, rest, _ := strings.Cut(c.Request.URL.Path, "/servers/"+slug)
+		location := prefix + "/servers/" + target + rest
+		if c.Request.URL.RawQuery != "" {
+			location += "?" + c.Request.URL.RawQuery
+		}
+		status := http.StatusMovedPermanently
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		c.Header("Location", location)
+		c.AbortWithStatusJSON(status, gin.H{
+			"status":   "redirect",
+			"message":  "Server '" + slug + "' was renamed to '" + target + "'",
+			"moved_to": target,
+			"location": location,
+		})
+	}
+}
@@ -1,32 +1,86 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"superbox/server/config"
 	"superbox/server/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterServers(api *gin.RouterGroup) {
-	servers := api.Group("/servers")
+	servers := api.Group("/servers", ServerRedirects())
 	{
-		servers.GET("", listServers)
-		servers.GET("/:server_name", getServer)
+		servers.GET("", RateLimited("search"), Cached(catalogCache), listServers)
+		servers.GET("/export.ndjson", exportServers)
+		servers.GET("/:server_name", Cached(catalogCache), getServer)
+		servers.GET("/:server_name/download", RateLimited("download"), downloadServer)
+		servers.GET("/:server_name/pricing/history", Cached(pricingCache), getPricingHistory)
+		servers.POST("/:server_name/webhooks", createPublisherWebhook)
+		servers.GET("/:server_name/webhooks", listPublisherWebhooks)
+		servers.DELETE("/:server_name/webhooks/:webhook_id", deletePublisherWebhook)
+		servers.POST("/:server_name/webhooks/:webhook_id/rotate-secret", rotateWebhookSecret)
+		servers.POST("/:server_name/webhooks/:webhook_id/signature-preview", previewWebhookSignature)
+		servers.DELETE("/:server_name/webhooks/:webhook_id/signing-keys/:key_id", retireWebhookSigningKey)
+		servers.GET("/:server_name/webhooks/:webhook_id/deliveries", listWebhookDeliveries)
+		servers.POST("/:server_name/webhooks/:webhook_id/deliveries/:delivery_id/replay", replayWebhookDelivery)
+		servers.POST("/:server_name/claims", createServerClaim)
+		servers.GET("/:server_name/advisories", listServerAdvisories)
+		servers.POST("/:server_name/advisories", createAdvisory)
+		servers.GET("/:server_name/questions", listServerQuestions)
+		servers.POST("/:server_name/questions", askQuestion)
+		servers.PUT("/:server_name/questions/:question_id/answer", answerQuestion)
+		servers.POST("/:server_name/questions/:question_id/helpful", markQuestionHelpful)
+		servers.GET("/:server_name/reviews", listServerReviews)
+		servers.POST("/:server_name/reviews", createReview)
+		servers.PUT("/:server_name/reviews/:review_id/response", respondToReview)
+		servers.POST("/:server_name/payment-links", createPaymentLink)
+		servers.GET("/:server_name/payment-links", listPaymentLinks)
+		servers.DELETE("/:server_name/payment-links/:link_id", deactivatePaymentLink)
+		servers.GET("/:server_name/retention", getRetention)
+		servers.PUT("/:server_name/retention", updateRetention)
+		servers.DELETE("/:server_name/retention", resetRetention)
+		servers.POST("/:server_name/claims/:claim_id/verify", verifyServerClaim)
 		servers.POST("", createServer)
+		servers.POST("/import", importServers)
+		servers.POST("/lint", lintServer)
 		servers.PUT("/:server_name", updateServer)
+		servers.PATCH("/:server_name", updateServer)
 		servers.DELETE("/:server_name", deleteServer)
 	}
 }
 
-func callPythonS3(function string, args map[string]interface{}) (map[string]interface{}, error) {
-	scriptPath := filepath.Join("src", "superbox", "server", "helpers", "s3_helper.py")
+// s3Backend runs one S3 helper function. Tests swap in an in-memory fake so
+// handlers can be exercised without AWS.
+var s3Backend = runPythonS3
+
+// callPythonS3Context runs an S3 helper function. Server records are read and
+// written under the storage prefix of the tenant carried by ctx.
+func callPythonS3Context(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	args["prefix"] = tenantFrom(ctx).StoragePrefix
+	return s3Backend(ctx, function, args)
+}
+
+// runPythonS3 sends the call to the worker pool, or starts a fresh
+// interpreter for it when PYTHON_WORKERS is 0.
+func runPythonS3(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	if appConfig.PythonWorkers > 0 {
+		return callPythonWorker(ctx, function, args)
+	}
 
 	argsJSON, err := json.Marshal(map[string]interface{}{
 		"function": function,
@@ -36,10 +90,15 @@ func callPythonS3(function string, args map[string]interface{}) (map[string]inte
 		return nil, err
 	}
 
-	cmd := exec.Command("python", scriptPath, string(argsJSON))
+	cmd := exec.CommandContext(ctx, pythonBinary, pythonHelperScript)
 	cmd.Env = os.Environ()
+	cmd.Stdin = bytes.NewReader(argsJSON)
 	output, err := cmd.Output()
 	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("python s3 call failed: %v: %s", err, lastLine(exitErr.Stderr))
+		}
 		return nil, fmt.Errorf("python s3 call failed: %v", err)
 	}
 
@@ -55,332 +114,726 @@ func callPythonS3(function string, args map[string]interface{}) (map[string]inte
 	return result, nil
 }
 
-func getServer(c *gin.Context) {
-	serverName := c.Param("server_name")
-	bucketName := os.Getenv("S3_BUCKET_NAME")
+// fetchServer reads a server from the shard known to hold it, trying the
+// other regions if it is not there.
+func fetchServer(ctx context.Context, serverName string) (models.Server, error) {
+	for _, region := range shardCandidates(ctx, serverName) {
+		result, err := callShard(ctx, region, "get_server", map[string]interface{}{
+			"server_name": serverName,
+		})
+		if err != nil {
+			return models.Server{}, err
+		}
+		if result["data"] != nil {
+			recordShard(ctx, serverName, region.Name)
+			return decodeServer(result["data"])
+		}
+	}
+	forgetShard(ctx, serverName)
+	return models.Server{}, fmt.Errorf("server '%s' %w", serverName, errServerNotFound)
+}
 
-	result, err := callPythonS3("get_server", map[string]interface{}{
-		"bucket_name": bucketName,
+// decodeServer converts a record returned by the S3 helper into a Server.
+func decodeServer(data interface{}) (models.Server, error) {
+	var server models.Server
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return models.Server{}, err
+	}
+	if err := json.Unmarshal(raw, &server); err != nil {
+		return models.Server{}, fmt.Errorf("invalid server record: %w", err)
+	}
+	server.Canonicalize()
+	if server.DisplayName == "" {
+		server.DisplayName = server.Name
+	}
+	return server, nil
+}
+
+// saveServer writes a server to the shard already holding it, or for a new
+// server to its owner's region.
+func saveServer(ctx context.Context, server models.Server) error {
+	region, known := serverShard(ctx, server.Name)
+	if !known {
+		var err error
+		if region, err = userRegion(ctx, server.Meta.OwnerID); err != nil {
+			return err
+		}
+	}
+	return writeServer(ctx, region, server)
+}
+
+// writeServer writes a server to the given shard.
+func writeServer(ctx context.Context, region config.StorageRegion, server models.Server) error {
+	_, err := callShard(ctx, region, "upsert_server", map[string]interface{}{
+		"server_name": server.Name,
+		"server_data": server,
+	})
+	if err != nil {
+		return err
+	}
+	recordShard(ctx, server.Name, region.Name)
+	emitRegistryChanged(ctx, server.Name)
+	if err := invalidateServerPages(ctx, server.Name); err != nil {
+		slog.Warn("failed to queue cdn invalidation", "server_name", server.Name, "error", err)
+	}
+	return nil
+}
+
+// deleteServerRecord deletes a server from the shard known to hold it, or
+// from the primary bucket if it has not been looked up.
+func deleteServerRecord(ctx context.Context, serverName string) error {
+	region := shardCandidates(ctx, serverName)[0]
+	_, err := callShard(ctx, region, "delete_server", map[string]interface{}{
 		"server_name": serverName,
 	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "error",
-			"detail": "Server '" + serverName + "' not found",
-		})
-		return
+		return err
 	}
+	forgetShard(ctx, serverName)
+	emitRegistryChanged(ctx, serverName)
+	if err := invalidateServerPages(ctx, serverName); err != nil {
+		slog.Warn("failed to queue cdn invalidation", "server_name", serverName, "error", err)
+	}
+	return nil
+}
 
-	server, ok := result["data"].(map[string]interface{})
-	if !ok || server == nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "error",
-			"detail": "Server '" + serverName + "' not found",
-		})
+func emitRegistryChanged(ctx context.Context, serverName string) {
+	tenantID := tenantFrom(ctx).ID
+	emit(ctx, registryChanged, tenantID, serverSubject(tenantID, serverName), map[string]interface{}{"server_name": serverName})
+}
+
+// normalizePricing drops fields that do not apply to the pricing mode.
+func normalizePricing(pricing models.Pricing) models.Pricing {
+	if pricing.Mode != "donation" {
+		pricing.MinimumAmount = 0
+	}
+	return pricing
+}
+
+func validatePricing(pricing models.Pricing) error {
+	switch pricing.Mode {
+	case "", "fixed":
+	case "donation":
+		if pricing.MinimumAmount < 0 {
+			return fmt.Errorf("minimum_amount must not be negative")
+		}
+		if len(pricing.Plans) > 0 {
+			return fmt.Errorf("donation pricing cannot define plans")
+		}
+	default:
+		return fmt.Errorf("unsupported pricing mode '%s'", pricing.Mode)
+	}
+	if pricing.Currency == "" && (pricing.Mode == "donation" || highestPrice(pricing) > 0) {
+		return fmt.Errorf("currency is required for paid pricing")
+	}
+	return nil
+}
+
+// scanPassed reads the summary the CLI attaches to security reports.
+func scanPassed(report map[string]interface{}) bool {
+	summary, _ := report["summary"].(map[string]interface{})
+	passed, ok := summary["scan_passed"].(bool)
+	return !ok || passed
+}
+
+func requireServerOwner(c *gin.Context, serverName string) (string, models.Server, bool) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return "", models.Server{}, false
+	}
+
+	server, err := fetchServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return "", models.Server{}, false
+	}
+
+	if !ownsResource(c, server.Meta.OwnerID) {
+		respondError(c, notPublisher(serverName))
+		return "", models.Server{}, false
+	}
+	return userID, server, true
+}
+
+// notPublisher refuses a write to a server the caller does not own. Servers
+// published before owners were recorded have none, so only an admin can
+// change them until someone claims them.
+func notPublisher(serverName string) *APIError {
+	return newAPIError(http.StatusForbidden, "Only the publisher of '"+serverName+"' can do this")
+}
+
+func getServer(c *gin.Context) {
+	serverName := c.Param("server_name")
+
+	server, err := snapshotServer(c.Request.Context(), serverName)
+	if errors.Is(err, errServerNotFound) {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, internalError("Error fetching server", err))
 		return
 	}
 
+	setLastModified(c, server.Meta.UpdatedAt)
+	c.Header("ETag", serverETag(server))
 	c.JSON(http.StatusOK, models.ServerResponse{
 		Status: "success",
-		Server: server,
+		Server: &server,
 	})
 }
 
 func listServers(c *gin.Context) {
-	bucketName := os.Getenv("S3_BUCKET_NAME")
-
-	result, err := callPythonS3("list_servers", map[string]interface{}{
-		"bucket_name": bucketName,
-	})
+	servers, err := snapshotServers(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "error",
-			"detail": "Error fetching servers: " + err.Error(),
-		})
+		respondError(c, internalError("Error fetching servers", err))
 		return
 	}
 
-	serversMap, ok := result["data"].(map[string]interface{})
-	if !ok {
-		c.JSON(http.StatusOK, models.ServerResponse{
-			Status:  "success",
-			Total:   0,
-			Servers: []interface{}{},
-		})
+	serverList := make([]models.ServerSummary, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, serverSummary(server))
+	}
+	sort.Slice(serverList, func(i, j int) bool {
+		return serverList[i].Name < serverList[j].Name
+	})
+
+	c.JSON(http.StatusOK, models.ServerResponse{
+		Status:  "success",
+		Total:   len(serverList),
+		Servers: serverList,
+	})
+}
+
+// exportServers streams the registry as NDJSON, one server summary per line,
+// flushing as it goes. The server never holds the whole registry, and
+// indexers can consume it as it arrives. A
+// server deleted mid-export is skipped; any other failure ends the stream
+// with an error line, since the status has already been sent.
+func exportServers(c *gin.Context) {
+	ctx := c.Request.Context()
+	names, err := registryServerNames(ctx)
+	if err != nil {
+		respondError(c, internalError("Error fetching servers", err))
 		return
 	}
 
-	serverList := make([]interface{}, 0)
-	for _, serverVal := range serversMap {
-		server, ok := serverVal.(map[string]interface{})
-		if !ok {
-			continue
-		}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Total-Count", strconv.Itoa(len(names)))
+	c.Status(http.StatusOK)
 
-		serverInfo := map[string]interface{}{
-			"name":        server["name"],
-			"version":     server["version"],
-			"description": server["description"],
-			"author":      server["author"],
-			"lang":        server["lang"],
-			"license":     server["license"],
-			"entrypoint":  server["entrypoint"],
-			"repository":  server["repository"],
+	// Servers are read a batch at a time in parallel and written in name
+	// order, so at most one batch is held in memory.
+	encoder := json.NewEncoder(c.Writer)
+	for batch := range slices.Chunk(names, lookupConcurrency) {
+		servers, err := fetchServers(ctx, batch)
+		if ctx.Err() != nil {
+			return
 		}
-
-		if tools, ok := server["tools"].(map[string]interface{}); ok && tools != nil {
-			serverInfo["tools"] = tools
+		if err != nil {
+			slog.Error("server export interrupted", "error", err, "request_id", c.GetString("request_id"))
+			encoder.Encode(gin.H{"error": gin.H{
+				"code":       "export_interrupted",
+				"message":    "Export stopped before the last server",
+				"request_id": c.GetString("request_id"),
+			}})
+			return
 		}
-
-		if pricing, ok := server["pricing"].(map[string]interface{}); ok && pricing != nil {
-			serverInfo["pricing"] = pricing
-		} else {
-			serverInfo["pricing"] = map[string]interface{}{
-				"currency": "",
-				"amount":   0,
+		for _, name := range batch {
+			server, exists := servers[name]
+			if !exists {
+				continue
+			}
+			if err := encoder.Encode(serverSummary(server)); err != nil {
+				return
 			}
 		}
-
-		if securityReport, ok := server["security_report"].(map[string]interface{}); ok && securityReport != nil {
-			serverInfo["security_report"] = securityReport
-		}
-
-		serverList = append(serverList, serverInfo)
+		c.Writer.Flush()
 	}
+}
 
-	c.JSON(http.StatusOK, models.ServerResponse{
-		Status:  "success",
-		Total:   len(serverList),
-		Servers: serverList,
-	})
+func serverSummary(server models.Server) models.ServerSummary {
+	return models.ServerSummary{
+		Name:           server.Name,
+		DisplayName:    server.DisplayName,
+		Version:        server.Version,
+		Description:    server.Description,
+		Author:         server.Author,
+		Lang:           server.Lang,
+		License:        server.License,
+		Entrypoint:     server.Entrypoint,
+		Repository:     server.Repository,
+		Pricing:        server.Pricing,
+		Tools:          server.Tools,
+		SecurityReport: server.SecurityReport,
+	}
 }
 
 func createServer(c *gin.Context) {
 	var req models.CreateServerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": "error",
-			"detail": "Invalid request: " + err.Error(),
-		})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
-	bucketName := os.Getenv("S3_BUCKET_NAME")
+	if err := validatePricing(req.Pricing); err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "Invalid pricing: "+err.Error()))
+		return
+	}
+	if !serverNamePattern.MatchString(req.Name) {
+		respondError(c, invalidSlug("name"))
+		return
+	}
 
-	existing, err := callPythonS3("get_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": req.Name,
-	})
-	if err == nil && existing["data"] != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": "error",
-			"detail": "Server '" + req.Name + "' already exists",
-		})
+	ownerID, ok := authenticatedUser(c)
+	if !ok || !requireKeyServer(c, req.Name) {
+		return
+	}
+	if !consumeQuota(c, "publish") {
 		return
 	}
 
-	newServer := map[string]interface{}{
-		"name":        req.Name,
-		"version":     req.Version,
-		"description": req.Description,
-		"author":      req.Author,
-		"lang":        req.Lang,
-		"license":     req.License,
-		"entrypoint":  req.Entrypoint,
-		"repository": map[string]interface{}{
-			"type": req.Repository.Type,
-			"url":  req.Repository.URL,
-		},
-		"pricing": map[string]interface{}{
-			"currency": req.Pricing.Currency,
-			"amount":   req.Pricing.Amount,
-		},
-		"meta": map[string]interface{}{
-			"created_at": time.Now().UTC().Format(time.RFC3339),
-			"updated_at": time.Now().UTC().Format(time.RFC3339),
-		},
+	unlock, ok := lockServer(c, req.Name)
+	if !ok {
+		return
 	}
+	defer unlock()
 
-	if req.Tools != nil {
-		newServer["tools"] = *req.Tools
+	if _, err := fetchServer(c.Request.Context(), req.Name); err == nil {
+		respondError(c, newAPIError(http.StatusBadRequest, "Server '"+req.Name+"' already exists"))
+		return
+	} else if !errors.Is(err, errServerNotFound) {
+		respondError(c, internalError("Error checking server name", err))
+		return
+	}
+	if target, reserved, err := reservedSlug(c.Request.Context(), req.Name); err != nil {
+		respondError(c, internalError("Error checking server name", err))
+		return
+	} else if reserved {
+		respondError(c, slugReserved(req.Name, target))
+		return
+	}
+	if held, err := publishHeld(c.Request.Context(), requestTenant(c).ID, req.Name); err != nil {
+		respondError(c, internalError("Error checking moderation", err))
+		return
+	} else if held {
+		respondError(c, newAPIError(http.StatusConflict, "Server '"+req.Name+"' is awaiting moderation review").withCode("moderation_pending"))
+		return
+	}
+	if req.BundleID != "" && !checkBundle(c, req.BundleID, ownerID, req.Name, req.Version) {
+		return
 	}
 
-	_, err = callPythonS3("upsert_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": req.Name,
-		"server_data": newServer,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "error",
-			"detail": "Error creating server: " + err.Error(),
+	newServer := newServerRecord(req, ownerID, time.Now())
+	newServer.Versions[0].BundleID = req.BundleID
+	if flags := moderateListing(c.Request.Context(), newServer); len(flags) > 0 {
+		hold, err := holdForModeration(c.Request.Context(), &models.ModerationHold{TenantID: requestTenant(c).ID, Kind: "publish", ServerName: req.Name, OwnerID: ownerID, Server: &newServer, Flags: flags})
+		if err != nil {
+			respondError(c, internalError("Error holding server for review", err))
+			return
+		}
+		c.JSON(http.StatusAccepted, models.ServerResponse{
+			Status:     "success",
+			Message:    "Server '" + req.Name + "' is held for moderation review",
+			Moderation: hold,
 		})
 		return
 	}
+	if err := dropServerRedirect(c.Request.Context(), req.Name); err != nil {
+		respondError(c, internalError("Error creating server", err))
+		return
+	}
+	if err := saveServer(c.Request.Context(), newServer); err != nil {
+		respondError(c, internalError("Error creating server", err))
+		return
+	}
+
+	if req.BundleID != "" {
+		if err := attachBundle(c.Request.Context(), req.BundleID, req.Name, req.Version); err != nil {
+			slog.Warn("failed to attach bundle", "blob_id", req.BundleID, "server_name", req.Name, "error", err)
+		}
+	}
+
+	recordAppliedPrice(c.Request.Context(), requestTenant(c).ID, req.Name, req.Author, models.Pricing{}, req.Pricing)
+	emit(c.Request.Context(), "server.published", requestTenant(c).ID, serverSubject(requestTenant(c).ID, req.Name), map[string]interface{}{"server": newServer})
+	auditChange(c, nil, newServer)
 
 	c.JSON(http.StatusCreated, models.ServerResponse{
 		Status:  "success",
 		Message: "Server created",
-		Server:  newServer,
+		Server:  &newServer,
 	})
 }
 
+func newServerRecord(req models.CreateServerRequest, ownerID string, created time.Time) models.Server {
+	now := models.Timestamp(created)
+	displayName := req.DisplayName
+	if displayName == "" {
+		displayName = req.Name
+	}
+	return models.Server{
+		Name:        req.Name,
+		DisplayName: displayName,
+		Version:     req.Version,
+		Description: req.Description,
+		Author:      req.Author,
+		Lang:        req.Lang,
+		License:     req.License,
+		Entrypoint:  req.Entrypoint,
+		Repository:  req.Repository,
+		Pricing:     normalizePricing(req.Pricing),
+		Tools:       req.Tools,
+		Versions:    []models.Version{{Version: req.Version, PublishedAt: now}},
+		Meta: models.Meta{
+			OwnerID:   ownerID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+}
+
 func updateServer(c *gin.Context) {
 	serverName := c.Param("server_name")
-	bucketName := os.Getenv("S3_BUCKET_NAME")
 
 	var req models.UpdateServerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status": "error",
-			"detail": "Invalid request: " + err.Error(),
-		})
+		respondError(c, invalidRequest(err))
 		return
 	}
 
-	existingResult, err := callPythonS3("get_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": serverName,
-	})
-	if err != nil || existingResult["data"] == nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "error",
-			"detail": "Server '" + serverName + "' not found",
-		})
+	if req.Pricing != nil {
+		if err := validatePricing(*req.Pricing); err != nil {
+			respondError(c, newAPIError(http.StatusBadRequest, "Invalid pricing: "+err.Error()))
+			return
+		}
+	}
+
+	unlock, ok := lockServerWrite(c, serverName)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	existing := existingResult["data"].(map[string]interface{})
-	updatedData := make(map[string]interface{})
-	for k, v := range existing {
-		updatedData[k] = v
+	// A rename also needs the target name to be free; both reads go out at
+	// once.
+	lookups := []string{serverName}
+	renaming := req.Name != nil && *req.Name != serverName
+	if renaming {
+		lookups = append(lookups, *req.Name)
+	}
+	found, err := fetchServers(c.Request.Context(), lookups)
+	if err != nil {
+		respondError(c, internalError("Error fetching server", err))
+		return
+	}
+	existing, exists := found[serverName]
+	if !exists {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if !ownsResource(c, existing.Meta.OwnerID) {
+		respondError(c, notPublisher(serverName))
+		return
+	}
+	if !checkServerPreconditions(c, existing) {
+		return
 	}
+	updated := existing
+	now := models.Now()
 
-	newName := serverName
-	if req.Name != nil && *req.Name != serverName {
-		checkResult, _ := callPythonS3("get_server", map[string]interface{}{
-			"bucket_name": bucketName,
-			"server_name": *req.Name,
-		})
-		if checkResult["data"] != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status": "error",
-				"detail": "Server '" + *req.Name + "' already exists",
-			})
+	// The old slug keeps working: it redirects to the new one, and no other
+	// server can take it. Renaming back onto one of the server's own old
+	// slugs is allowed.
+	if renaming {
+		if !serverNamePattern.MatchString(*req.Name) {
+			respondError(c, invalidSlug("name"))
+			return
+		}
+		if !requireKeyServer(c, *req.Name) {
+			return
+		}
+		if _, taken := found[*req.Name]; taken {
+			respondError(c, newAPIError(http.StatusBadRequest, "Server '"+*req.Name+"' already exists"))
 			return
 		}
-		newName = *req.Name
-		updatedData["name"] = *req.Name
+		target, reserved, err := reservedSlug(c.Request.Context(), *req.Name)
+		if err != nil {
+			respondError(c, internalError("Error checking server name", err))
+			return
+		}
+		if reserved && target != serverName {
+			respondError(c, slugReserved(*req.Name, target))
+			return
+		}
+		updated.Name = *req.Name
+		updated.PreviousNames = append(slices.DeleteFunc(slices.Clone(existing.PreviousNames), func(name string) bool {
+			return name == updated.Name
+		}), serverName)
+	}
+	if req.DisplayName != nil {
+		updated.DisplayName = *req.DisplayName
 	}
 
-	if req.Version != nil {
-		updatedData["version"] = *req.Version
+	if req.Version != nil && *req.Version != existing.Version {
+		updated.Versions = slices.Clone(existing.Versions)
+		if len(updated.Versions) == 0 && existing.Version != "" {
+			updated.Versions = append(updated.Versions, models.Version{Version: existing.Version, PublishedAt: existing.Meta.CreatedAt})
+		}
+		updated.Versions = append(updated.Versions, models.Version{Version: *req.Version, PublishedAt: now})
+		updated.Version = *req.Version
+	}
+	// A bundle goes with the version the update leaves current, which can
+	// take one bundle.
+	if req.BundleID != nil {
+		if !checkBundle(c, *req.BundleID, existing.Meta.OwnerID, updated.Name, updated.Version) {
+			return
+		}
+		updated.Versions = slices.Clone(updated.Versions)
+		if len(updated.Versions) == 0 {
+			updated.Versions = append(updated.Versions, models.Version{Version: existing.Version, PublishedAt: existing.Meta.CreatedAt})
+		}
+		current := &updated.Versions[len(updated.Versions)-1]
+		if current.BundleID != "" && current.BundleID != *req.BundleID {
+			respondError(c, newAPIError(http.StatusConflict, "Version "+current.Version+" was published with bundle '"+current.BundleID+"'; publish a new version to attach another"))
+			return
+		}
+		current.BundleID = *req.BundleID
 	}
 	if req.Description != nil {
-		updatedData["description"] = *req.Description
+		updated.Description = *req.Description
 	}
 	if req.Author != nil {
-		updatedData["author"] = *req.Author
+		updated.Author = *req.Author
 	}
 	if req.Lang != nil {
-		updatedData["lang"] = *req.Lang
+		updated.Lang = *req.Lang
 	}
 	if req.License != nil {
-		updatedData["license"] = *req.License
+		updated.License = *req.License
 	}
 	if req.Entrypoint != nil {
-		updatedData["entrypoint"] = *req.Entrypoint
+		updated.Entrypoint = *req.Entrypoint
 	}
 	if req.Repository != nil {
-		updatedData["repository"] = map[string]interface{}{
-			"type": req.Repository.Type,
-			"url":  req.Repository.URL,
-		}
+		updated.Repository = *req.Repository
 	}
+	oldPricing := existing.Pricing
+	priceReview := false
 	if req.Pricing != nil {
-		updatedData["pricing"] = map[string]interface{}{
-			"currency": req.Pricing.Currency,
-			"amount":   req.Pricing.Amount,
+		if needsPriceReview(oldPricing, *req.Pricing) {
+			priceReview = true
+		} else {
+			updated.Pricing = normalizePricing(*req.Pricing)
 		}
 	}
 	if req.Tools != nil {
-		updatedData["tools"] = *req.Tools
+		updated.Tools = *req.Tools
 	}
 	if req.SecurityReport != nil {
-		updatedData["security_report"] = *req.SecurityReport
+		updated.SecurityReport = *req.SecurityReport
+	}
+	updated.Meta.UpdatedAt = now
+
+	// Listing text is moderated again when it changes. A flagged update is
+	// held whole and the live listing stays as it was; a price rise that
+	// needs review still goes to the pricing queue on its own.
+	if (req.Description != nil && *req.Description != existing.Description) || (req.Repository != nil && *req.Repository != existing.Repository) {
+		if flags := moderateListing(c.Request.Context(), updated); len(flags) > 0 {
+			hold, err := holdForModeration(c.Request.Context(), &models.ModerationHold{TenantID: requestTenant(c).ID, Kind: "update", ServerName: serverName, OwnerID: existing.Meta.OwnerID, Server: &updated, Flags: flags, BaseUpdatedAt: existing.Meta.UpdatedAt})
+			if err != nil {
+				respondError(c, internalError("Error holding update for review", err))
+				return
+			}
+			response := models.ServerResponse{
+				Status:     "success",
+				Message:    "Update to '" + serverName + "' is held for moderation review",
+				Moderation: hold,
+			}
+			if priceReview {
+				if response.PricingReview, err = recordPriceChange(c.Request.Context(), requestTenant(c).ID, serverName, existing.Author, oldPricing, *req.Pricing, "pending"); err != nil {
+					respondError(c, internalError("Error queueing price change", err))
+					return
+				}
+			}
+			c.JSON(http.StatusAccepted, response)
+			return
+		}
+	}
+
+	if err := saveServer(c.Request.Context(), updated); err != nil {
+		respondError(c, internalError("Error updating server", err))
+		return
+	}
+	if renaming {
+		if err := renameServerRecord(c.Request.Context(), updated, serverName); err != nil {
+			respondError(c, internalError("Error renaming server", err))
+			return
+		}
+		if err := moveRetention(c.Request.Context(), requestTenant(c).ID, serverName, updated.Name); err != nil {
+			slog.Warn("failed to move retention policy", "server_name", serverName, "error", err)
+		}
+	}
+	if req.BundleID != nil {
+		if err := attachBundle(c.Request.Context(), *req.BundleID, updated.Name, updated.Version); err != nil {
+			slog.Warn("failed to attach bundle", "blob_id", *req.BundleID, "server_name", updated.Name, "error", err)
+		}
 	}
 
-	if meta, ok := updatedData["meta"].(map[string]interface{}); ok {
-		if createdAt, exists := meta["created_at"]; exists {
-			updatedData["meta"] = map[string]interface{}{
-				"created_at": createdAt,
-				"updated_at": time.Now().UTC().Format(time.RFC3339),
+	response := models.ServerResponse{
+		Status:  "success",
+		Message: "Server '" + serverName + "' updated successfully",
+		Server:  &updated,
+	}
+	if renaming {
+		response.Message += "; '" + serverName + "' now redirects to '" + updated.Name + "'"
+	}
+	if req.Pricing != nil {
+		if priceReview {
+			change, err := recordPriceChange(c.Request.Context(), requestTenant(c).ID, updated.Name, updated.Author, oldPricing, *req.Pricing, "pending")
+			if err != nil {
+				respondError(c, internalError("Error queueing price change", err))
+				return
 			}
+			response.PricingReview = change
+			response.Message += "; pricing change is pending admin review"
 		} else {
-			updatedData["meta"] = map[string]interface{}{
-				"updated_at": time.Now().UTC().Format(time.RFC3339),
-			}
+			recordAppliedPrice(c.Request.Context(), requestTenant(c).ID, updated.Name, updated.Author, oldPricing, *req.Pricing)
 		}
-	} else {
-		updatedData["meta"] = map[string]interface{}{
-			"updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	subject := serverSubject(requestTenant(c).ID, serverName)
+	emit(c.Request.Context(), "server.updated", requestTenant(c).ID, subject, map[string]interface{}{"server": updated})
+	if req.SecurityReport != nil {
+		emit(c.Request.Context(), "scan.completed", requestTenant(c).ID, subject, map[string]interface{}{
+			"server":          updated,
+			"passed":          scanPassed(*req.SecurityReport),
+			"security_report": *req.SecurityReport,
+		})
+	}
+	auditChange(c, existing, updated)
+
+	c.Header("ETag", serverETag(updated))
+	c.JSON(http.StatusOK, response)
+}
+
+func requiresPurchase(pricing models.Pricing) bool {
+	if pricing.Mode == "donation" {
+		return false
+	}
+	if len(pricing.Plans) == 0 {
+		return pricing.Amount > 0
+	}
+	for _, plan := range pricing.Plans {
+		if plan.Amount <= 0 {
+			return false
 		}
 	}
+	return true
+}
 
-	if newName != serverName {
-		callPythonS3("delete_server", map[string]interface{}{
-			"bucket_name": bucketName,
-			"server_name": serverName,
-		})
+func archiveURL(repoURL string) string {
+	if !strings.Contains(repoURL, "github.com") {
+		return ""
 	}
+	return strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git") + "/archive/refs/heads/main.zip"
+}
 
-	_, err = callPythonS3("upsert_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": newName,
-		"server_data": updatedData,
-	})
+func downloadServer(c *gin.Context) {
+	serverName := c.Param("server_name")
+
+	server, err := fetchServer(c.Request.Context(), serverName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "error",
-			"detail": "Error updating server: " + err.Error(),
-		})
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
 		return
 	}
+	pricing := server.Pricing
 
-	c.JSON(http.StatusOK, models.ServerResponse{
-		Status:  "success",
-		Message: "Server '" + serverName + "' updated successfully",
-		Server:  updatedData,
+	var entitlement *models.Entitlement
+	if requiresPurchase(pricing) {
+		userID, ok := optionalUser(c)
+		if !ok {
+			return
+		}
+		if userID != "" {
+			if entitlement, err = serverEntitlement(c.Request.Context(), requestTenant(c).ID, userID, server); err != nil {
+				respondError(c, internalError("Error checking your purchase", err))
+				return
+			}
+		}
+
+		if entitlement == nil {
+			plans := pricing.Plans
+			if len(plans) == 0 {
+				plans = []models.PricingPlan{{Name: "standard", Amount: pricing.Amount, Period: "one_time"}}
+			}
+			respondError(c, newAPIError(http.StatusPaymentRequired, "Server '"+serverName+"' requires a purchase before download").withDetail("purchase", gin.H{
+				"server_name":  serverName,
+				"currency":     pricing.Currency,
+				"plans":        plans,
+				"create_order": "/api/v1/payment/create-order",
+				"instructions": "Sign in, create an order for one of the plans, complete payment, then retry the download.",
+			}))
+			return
+		}
+	}
+
+	download := gin.H{
+		"name":        server.Name,
+		"version":     server.Version,
+		"lang":        server.Lang,
+		"entrypoint":  server.Entrypoint,
+		"repository":  server.Repository,
+		"archive_url": archiveURL(server.Repository.URL),
+	}
+	if entitlement != nil {
+		download["entitlement"] = entitlement
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"download": download,
 	})
 }
 
 func deleteServer(c *gin.Context) {
 	serverName := c.Param("server_name")
-	bucketName := os.Getenv("S3_BUCKET_NAME")
 
-	existing, err := callPythonS3("get_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": serverName,
-	})
-	if err != nil || existing["data"] == nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status": "error",
-			"detail": "Server '" + serverName + "' not found",
-		})
+	unlock, ok := lockServerWrite(c, serverName)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	_, err = callPythonS3("delete_server", map[string]interface{}{
-		"bucket_name": bucketName,
-		"server_name": serverName,
-	})
+	existing, err := fetchServer(c.Request.Context(), serverName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "error",
-			"detail": "Failed to delete server '" + serverName + "'",
-		})
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+	if !ownsResource(c, existing.Meta.OwnerID) {
+		respondError(c, notPublisher(serverName))
 		return
 	}
+	if !checkServerPreconditions(c, existing) {
+		return
+	}
+
+	if err := deleteServerRecord(c.Request.Context(), serverName); err != nil {
+		respondError(c, newAPIError(http.StatusInternalServerError, "Failed to delete server '"+serverName+"'"))
+		return
+	}
+	// Its old slugs are free again; a failure here only leaves redirects
+	// that reservedSlug already treats as stale.
+	for _, previous := range existing.PreviousNames {
+		if err := dropServerRedirect(c.Request.Context(), previous); err != nil {
+			slog.Warn("failed to drop server redirect", "server_name", previous, "error", err)
+		}
+	}
+	// Its bundles become drafts, found by the next lifecycle run.
+	if err := stateStore.Delete(c.Request.Context(), retentionKey(requestTenant(c).ID, serverName)); err != nil {
+		slog.Warn("failed to drop retention policy", "server_name", serverName, "error", err)
+	}
+	emit(c.Request.Context(), "server.deleted", requestTenant(c).ID, serverSubject(requestTenant(c).ID, serverName), map[string]interface{}{"server_name": serverName})
+	auditChange(c, existing, nil)
 
 	c.JSON(http.StatusOK, models.ServerResponse{
 		Status:  "success",
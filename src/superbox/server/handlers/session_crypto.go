@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"superbox/server/config"
+	"superbox/server/models"
+)
+
+// sealedPrefix marks a value sealed by sealValue, followed by the key ID and
+// the base64 nonce and ciphertext.
+const sealedPrefix = "v1:"
+
+// sessionKeys encrypt secrets kept in the state store. The first key seals;
+// every key can open, so a rotated-out key keeps working until the sessions
+// it sealed have expired.
+var sessionKeys []config.SessionKey
+
+// configureSessionKeys installs the configured keys, or a random key when
+// none are set. That is only safe with the in-process store, which is why
+// SESSION_ENCRYPTION_KEYS is required alongside REDIS_URL or SESSION_STORE_URL.
+func configureSessionKeys(keys []config.SessionKey) {
+	if len(keys) == 0 {
+		key := make([]byte, 32)
+		rand.Read(key)
+		keys = []config.SessionKey{{ID: "ephemeral", Key: key}}
+	}
+	sessionKeys = keys
+}
+
+func sessionAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealValue encrypts plaintext with AES-GCM under the newest session key.
+// aad ties the result to the record holding it, so a sealed value copied
+// into another record does not open.
+func sealValue(plaintext []byte, aad []byte) (string, error) {
+	key := sessionKeys[0]
+	aead, err := sessionAEAD(key.Key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, aad)
+	return sealedPrefix + key.ID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func openValue(sealed string, aad []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return nil, fmt.Errorf("unsupported sealed value format")
+	}
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return nil, fmt.Errorf("sealed value has no key ID")
+	}
+	var key []byte
+	for _, candidate := range sessionKeys {
+		if candidate.ID == keyID {
+			key = candidate.Key
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("value was sealed with unknown key %q", keyID)
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed value: %w", err)
+	}
+	aead, err := sessionAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is truncated")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// encodeSession serializes a device session for the state store with its
+// tokens sealed, so a dump of Redis or of process memory holding the stored
+// bytes does not expose Firebase refresh tokens.
+func encodeSession(session *models.DeviceSession) ([]byte, error) {
+	stored := *session
+	if stored.Tokens != nil {
+		plaintext, err := json.Marshal(stored.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		if stored.SealedTokens, err = sealValue(plaintext, []byte(stored.DeviceCode)); err != nil {
+			return nil, err
+		}
+		stored.Tokens = nil
+	}
+	return json.Marshal(&stored)
+}
+
+func decodeSession(data []byte) (*models.DeviceSession, error) {
+	var session models.DeviceSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.SealedTokens != "" {
+		plaintext, err := openValue(session.SealedTokens, []byte(session.DeviceCode))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open device session tokens: %w", err)
+		}
+		if err := json.Unmarshal(plaintext, &session.Tokens); err != nil {
+			return nil, err
+		}
+		session.SealedTokens = ""
+	}
+	return &session, nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"superbox/server/models"
+)
+
+// snapshotRefreshInterval is how often each replica reloads its registry
+// snapshot to pick up writes made through other replicas. Writes through
+// this replica update the snapshot immediately.
+const snapshotRefreshInterval = time.Minute
+
+var (
+	// snapshots holds each tenant's registry in memory for the public read
+	// routes. A published map is never modified; updates swap in a copy, so
+	// readers can range over it without holding a lock.
+	snapshots     = make(map[string]map[string]models.Server)
+	snapshotMutex sync.RWMutex
+
+	// snapshotWrites counts writes per tenant. A full load only installs its
+	// result if no write happened while it ran, since it may have read
+	// storage before the write landed.
+	snapshotWrites = make(map[string]uint64)
+)
+
+func snapshotWriteCount(tenantID string) uint64 {
+	snapshotMutex.RLock()
+	defer snapshotMutex.RUnlock()
+	return snapshotWrites[tenantID]
+}
+
+func loadedSnapshot(tenantID string) map[string]models.Server {
+	snapshotMutex.RLock()
+	defer snapshotMutex.RUnlock()
+	return snapshots[tenantID]
+}
+
+// snapshotServers returns every server for the tenant in ctx, loading the
+// snapshot from storage the first time. The map must not be modified.
+func snapshotServers(ctx context.Context) (map[string]models.Server, error) {
+	tenantID := tenantFrom(ctx).ID
+	if snapshot := loadedSnapshot(tenantID); snapshot != nil {
+		return snapshot, nil
+	}
+
+	writes := snapshotWriteCount(tenantID)
+	servers, err := registryServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshotMutex.Lock()
+	if snapshotWrites[tenantID] == writes {
+		snapshots[tenantID] = servers
+	}
+	snapshotMutex.Unlock()
+	return servers, nil
+}
+
+// snapshotServer returns one server from the snapshot, reading storage on a
+// miss so a server published through another replica is found before the
+// next refresh.
+func snapshotServer(ctx context.Context, serverName string) (models.Server, error) {
+	tenantID := tenantFrom(ctx).ID
+	if server, exists := loadedSnapshot(tenantID)[serverName]; exists {
+		return server, nil
+	}
+
+	writes := snapshotWriteCount(tenantID)
+	server, err := fetchServer(ctx, serverName)
+	if err != nil {
+		return models.Server{}, err
+	}
+	updateSnapshot(tenantID, writes, func(servers map[string]models.Server) {
+		servers[serverName] = server
+	})
+	return server, nil
+}
+
+// updateSnapshot applies fn to a copy of the tenant's snapshot, unless there
+// is none or a write has happened since writes was read.
+func updateSnapshot(tenantID string, writes uint64, fn func(servers map[string]models.Server)) {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	snapshot := snapshots[tenantID]
+	if snapshot == nil || snapshotWrites[tenantID] != writes {
+		return
+	}
+	servers := maps.Clone(snapshot)
+	fn(servers)
+	snapshots[tenantID] = servers
+}
+
+// refreshSnapshotEntry re-reads one server after a write. The stored record
+// is used rather than the one written, since the helper stamps its
+// timestamps and the ETag must match storage. If the read fails the
+// tenant's snapshot is dropped and reloaded on the next request.
+func refreshSnapshotEntry(ctx context.Context, serverName string) {
+	tenantID := tenantFrom(ctx).ID
+	snapshotMutex.Lock()
+	snapshotWrites[tenantID]++
+	writes := snapshotWrites[tenantID]
+	loaded := snapshots[tenantID] != nil
+	snapshotMutex.Unlock()
+	if !loaded {
+		return
+	}
+
+	server, err := fetchServer(ctx, serverName)
+	switch {
+	case errors.Is(err, errServerNotFound):
+		updateSnapshot(tenantID, writes, func(servers map[string]models.Server) {
+			delete(servers, serverName)
+		})
+	case err != nil:
+		slog.Warn("dropping registry snapshot after a failed refresh", "tenant", tenantID, "server", serverName, "error", err)
+		snapshotMutex.Lock()
+		delete(snapshots, tenantID)
+		snapshotMutex.Unlock()
+	default:
+		updateSnapshot(tenantID, writes, func(servers map[string]models.Server) {
+			servers[serverName] = server
+		})
+	}
+}
+
+// refreshSnapshots reloads every loaded snapshot from storage and logs what
+// changed since the last load.
+func refreshSnapshots(ctx context.Context) {
+	snapshotMutex.RLock()
+	tenantIDs := make([]string, 0, len(snapshots))
+	for tenantID := range snapshots {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	snapshotMutex.RUnlock()
+
+	for _, tenantID := range tenantIDs {
+		writes := snapshotWriteCount(tenantID)
+		servers, err := registryServers(withTenant(ctx, tenantByID(tenantID)))
+		if err != nil {
+			slog.Error("failed to refresh registry snapshot", "tenant", tenantID, "error", err)
+			continue
+		}
+
+		snapshotMutex.Lock()
+		previous := snapshots[tenantID]
+		if snapshotWrites[tenantID] != writes {
+			// Picked up on the next tick.
+			snapshotMutex.Unlock()
+			continue
+		}
+		snapshots[tenantID] = servers
+		snapshotMutex.Unlock()
+
+		if previous != nil {
+			added, changed, removed := diffSnapshots(previous, servers)
+			if added+changed+removed > 0 {
+				slog.Info("registry snapshot refreshed", "tenant", tenantID, "added", added, "changed", changed, "removed", removed)
+			}
+		}
+	}
+}
+
+func diffSnapshots(before map[string]models.Server, after map[string]models.Server) (added int, changed int, removed int) {
+	for name, server := range after {
+		previous, exists := before[name]
+		switch {
+		case !exists:
+			added++
+		case serverETag(previous) != serverETag(server):
+			changed++
+		}
+	}
+	for name := range before {
+		if _, exists := after[name]; !exists {
+			removed++
+		}
+	}
+	return added, changed, removed
+}
+
+// startSnapshotRefresh reloads snapshots every snapshotRefreshInterval. Each
+// replica keeps its own snapshot, so this takes no lease.
+func startSnapshotRefresh(ctx context.Context) {
+	refreshers.run(func() {
+		ticker := time.NewTicker(snapshotRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshSnapshots(ctx)
+			}
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// regionalStorage lists the configured STORAGE_REGIONS. The primary
+	// bucket is not in it; storageRegions adds it in front.
+	regionalStorage []config.StorageRegion
+
+	// userRegions holds each user's chosen region, keyed by user ID. Users
+	// without one keep their data in the primary bucket.
+	userRegions = recordSet[models.DataResidency]{kind: "data_residency"}
+
+	// serverShards records which region holds each server, per tenant. It is
+	// rebuilt whenever the full registry is listed and filled in as single
+	// servers are found, so lookups go straight to the right bucket.
+	serverShards = make(map[string]map[string]string)
+
+	residencyMutex sync.RWMutex
+)
+
+func configureStorageRegions(cfg *config.Config) {
+	residencyMutex.Lock()
+	defer residencyMutex.Unlock()
+	regionalStorage = cfg.StorageRegions
+	serverShards = make(map[string]map[string]string)
+}
+
+// storageRegions returns every shard, primary bucket first.
+func storageRegions() []config.StorageRegion {
+	residencyMutex.RLock()
+	defer residencyMutex.RUnlock()
+	primary := config.StorageRegion{Name: config.DefaultStorageRegion, Bucket: appConfig.S3BucketName, AWSRegion: appConfig.AWSRegion}
+	return append([]config.StorageRegion{primary}, regionalStorage...)
+}
+
+func storageRegion(name string) (config.StorageRegion, bool) {
+	for _, region := range storageRegions() {
+		if region.Name == name {
+			return region, true
+		}
+	}
+	return config.StorageRegion{}, false
+}
+
+func storageRegionNames() []string {
+	regions := storageRegions()
+	names := make([]string, 0, len(regions))
+	for _, region := range regions {
+		names = append(names, region.Name)
+	}
+	return names
+}
+
+// userRegion returns the region a user's new servers and blobs are written
+// to. A region removed from STORAGE_REGIONS falls back to the primary bucket.
+func userRegion(ctx context.Context, userID string) (config.StorageRegion, error) {
+	residency, err := userResidency(ctx, userID)
+	if err != nil {
+		return config.StorageRegion{}, err
+	}
+	if region, ok := storageRegion(residency.Region); ok {
+		return region, nil
+	}
+	region, _ := storageRegion(config.DefaultStorageRegion)
+	return region, nil
+}
+
+// userResidency returns the region a user chose, empty when they have not.
+func userResidency(ctx context.Context, userID string) (models.DataResidency, error) {
+	residency, err := userRegions.get(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.DataResidency{}, nil
+	}
+	if err != nil {
+		return models.DataResidency{}, err
+	}
+	return *residency, nil
+}
+
+// callShard runs an S3 helper function against a region's bucket.
+func callShard(ctx context.Context, region config.StorageRegion, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	args["bucket_name"] = region.Bucket
+	if region.Name != config.DefaultStorageRegion {
+		args["region"] = region.AWSRegion
+	}
+	return callPythonS3Context(ctx, function, args)
+}
+
+func recordShard(ctx context.Context, serverName string, region string) {
+	tenantID := tenantFrom(ctx).ID
+	residencyMutex.Lock()
+	defer residencyMutex.Unlock()
+	if serverShards[tenantID] == nil {
+		serverShards[tenantID] = make(map[string]string)
+	}
+	serverShards[tenantID][serverName] = region
+}
+
+func forgetShard(ctx context.Context, serverName string) {
+	residencyMutex.Lock()
+	defer residencyMutex.Unlock()
+	delete(serverShards[tenantFrom(ctx).ID], serverName)
+}
+
+func replaceShards(ctx context.Context, shards map[string]string) {
+	residencyMutex.Lock()
+	defer residencyMutex.Unlock()
+	serverShards[tenantFrom(ctx).ID] = shards
+}
+
+// serverShard returns the region known to hold a server, if any.
+func serverShard(ctx context.Context, serverName string) (config.StorageRegion, bool) {
+	residencyMutex.RLock()
+	name, known := serverShards[tenantFrom(ctx).ID][serverName]
+	residencyMutex.RUnlock()
+	if !known {
+		return config.StorageRegion{}, false
+	}
+	return storageRegion(name)
+}
+
+// shardCandidates orders the regions to look for a server in: the one known
+// to hold it, then the rest.
+func shardCandidates(ctx context.Context, serverName string) []config.StorageRegion {
+	regions := storageRegions()
+	known, ok := serverShard(ctx, serverName)
+	if !ok {
+		return regions
+	}
+	candidates := []config.StorageRegion{known}
+	for _, region := range regions {
+		if region.Name != known.Name {
+			candidates = append(candidates, region)
+		}
+	}
+	return candidates
+}
+
+// callBlobStorage runs an S3 helper function against the bucket holding a
+// blob. Blobs in the primary region, including those written before regions
+// existed, live in their class's bucket.
+func callBlobStorage(ctx context.Context, blob models.Blob, function string, args map[string]interface{}) (map[string]interface{}, error) {
+	if blob.Region != "" && blob.Region != config.DefaultStorageRegion {
+		region, ok := storageRegion(blob.Region)
+		if !ok {
+			return nil, fmt.Errorf("blob %s is stored in region %q, which is no longer configured", blob.ID, blob.Region)
+		}
+		return callShard(ctx, region, function, args)
+	}
+	args["bucket_name"] = blobClasses[blob.Class].Bucket()
+	return callPythonS3Context(ctx, function, args)
+}
+
+func getDataResidency(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	residency, err := userResidency(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Error loading your storage region", err))
+		return
+	}
+	region, err := userRegion(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Error loading your storage region", err))
+		return
+	}
+	residency.Region = region.Name
+	residency.Available = storageRegionNames()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"residency": residency,
+	})
+}
+
+// updateDataResidency sets the region for the user's future writes. Data is
+// not moved between buckets, so the change is refused while the user still
+// owns servers or blobs in another region.
+func updateDataResidency(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateDataResidencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	if _, ok := storageRegion(req.Region); !ok {
+		respondError(c, newAPIError(http.StatusBadRequest, "Unknown storage region '"+req.Region+"'").
+			withDetail("available", storageRegionNames()))
+		return
+	}
+
+	region, err := userRegion(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Error loading your storage region", err))
+		return
+	}
+	previous := region.Name
+	if previous != req.Region {
+		servers, err := ownedServersOutside(c.Request.Context(), userID, req.Region)
+		if err != nil {
+			respondError(c, internalError("Error checking your servers", err))
+			return
+		}
+		blobCount, err := ownedBlobsOutside(c.Request.Context(), userID, req.Region)
+		if err != nil {
+			respondError(c, internalError("Error checking your blobs", err))
+			return
+		}
+		if len(servers) > 0 || blobCount > 0 {
+			respondError(c, newAPIError(http.StatusConflict, "Your data is stored in region '"+previous+"'; delete it before changing region").
+				withDetail("servers", servers).
+				withDetail("blobs", blobCount))
+			return
+		}
+	}
+
+	residency := models.DataResidency{Region: req.Region, UpdatedAt: models.Now()}
+	if err := userRegions.put(c.Request.Context(), userID, &residency); err != nil {
+		respondError(c, internalError("Error saving your storage region", err))
+		return
+	}
+	auditChange(c, gin.H{"region": previous}, gin.H{"region": req.Region})
+
+	residency.Available = storageRegionNames()
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"residency": residency,
+	})
+}
+
+// ownedServersOutside lists, across tenants, the servers the user owns that
+// are stored outside region.
+func ownedServersOutside(ctx context.Context, userID string, region string) ([]string, error) {
+	names := []string{}
+	for _, tenant := range allTenants() {
+		tenantCtx := withTenant(ctx, tenant)
+		servers, err := snapshotServers(tenantCtx)
+		if err != nil {
+			return nil, err
+		}
+		for name, server := range servers {
+			if server.Meta.OwnerID != userID {
+				continue
+			}
+			shard, known := serverShard(tenantCtx, name)
+			if !known {
+				// fetchServer records where it found the server.
+				if _, err := fetchServer(tenantCtx, name); err != nil {
+					return nil, err
+				}
+				shard, _ = serverShard(tenantCtx, name)
+			}
+			if shard.Name != region {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func ownedBlobsOutside(ctx context.Context, userID string, region string) (int, error) {
+	owned, err := ownerBlobs(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, blob := range owned {
+		blobRegion := blob.Region
+		if blobRegion == "" {
+			blobRegion = config.DefaultStorageRegion
+		}
+		if blobRegion != region {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const stripeBaseURL = "https://api.stripe.com/v1"
+
+func stripeRequest(ctx context.Context, method string, path string, form url.Values) (map[string]interface{}, error) {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req, err := newOutboundRequest(ctx, method, stripeBaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(tenantFrom(ctx).StripeSecretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := doUpstream("stripe", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseStripeError(resp)
+	}
+	var data map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func stripeCreatePaymentIntent(ctx context.Context, amountInSubunits int, currency string, metadata map[string]string) (map[string]interface{}, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.Itoa(amountInSubunits))
+	form.Set("currency", strings.ToLower(currency))
+	form.Set("automatic_payment_methods[enabled]", "true")
+	for key, value := range metadata {
+		form.Set("metadata["+key+"]", value)
+	}
+	return stripeRequest(ctx, "POST", "/payment_intents", form)
+}
+
+func stripeGetPaymentIntent(ctx context.Context, intentID string) (map[string]interface{}, error) {
+	return stripeRequest(ctx, "GET", "/payment_intents/"+url.PathEscape(intentID), nil)
+}
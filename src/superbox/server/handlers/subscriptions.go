@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func computeProration(subscription *models.Entitlement, plan *models.PricingPlan, now time.Time) models.Proration {
+	proration := models.Proration{
+		OldPlan:     subscription.Plan,
+		NewPlan:     plan.Name,
+		Currency:    subscription.Currency,
+		EffectiveAt: now,
+	}
+
+	oldPeriod := periodLength(subscription.Period)
+	remaining := max(0, subscription.ExpiresAt.Sub(now))
+	if oldPeriod > 0 {
+		proration.RemainingRatio = math.Min(1, remaining.Seconds()/oldPeriod.Seconds())
+	}
+	proration.Credit = roundToCurrency(subscription.Amount*proration.RemainingRatio, subscription.Currency)
+
+	if plan.Period == subscription.Period {
+		proration.Charge = roundToCurrency(plan.Amount*proration.RemainingRatio, subscription.Currency)
+		proration.NextRenewalAt = subscription.ExpiresAt
+	} else {
+		proration.Charge = roundToCurrency(plan.Amount, subscription.Currency)
+		proration.ResetCycle = true
+		proration.NextRenewalAt = now.Add(periodLength(plan.Period))
+	}
+	proration.Difference = roundToCurrency(proration.Charge-proration.Credit, subscription.Currency)
+	return proration
+}
+
+func listSubscriptions(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := userSubscriptions(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Error listing subscriptions", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"subscriptions": subscriptions,
+	})
+}
+
+func userSubscriptions(ctx context.Context, tenantID string, userID string) ([]models.Entitlement, error) {
+	owned, err := userEntitlements(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := []models.Entitlement{}
+	for _, entitlement := range owned {
+		if periodLength(entitlement.Period) > 0 {
+			subscriptions = append(subscriptions, entitlement)
+		}
+	}
+	return subscriptions, nil
+}
+
+func changePlan(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+
+	var req models.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+
+	subscriptionID := c.Param("subscription_id")
+	subscription, err := findSubscription(c.Request.Context(), requestTenant(c).ID, userID, subscriptionID)
+	if err != nil {
+		respondError(c, internalError("Error loading subscription", err))
+		return
+	}
+	now := models.Now()
+	if subscription == nil || periodLength(subscription.Period) == 0 {
+		respondError(c, newAPIError(http.StatusNotFound, "Subscription '"+subscriptionID+"' not found"))
+		return
+	}
+	if !subscription.ExpiresAt.After(now) {
+		respondError(c, newAPIError(http.StatusConflict, "Subscription has expired; purchase a new plan instead"))
+		return
+	}
+
+	server, err := fetchRenamedServer(c.Request.Context(), subscription.ServerName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+subscription.ServerName+"' not found"))
+		return
+	}
+	pricing := server.Pricing
+
+	plan, err := resolvePlan(&pricing, req.Plan, 0)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if strings.EqualFold(plan.Name, subscription.Plan) {
+		respondError(c, newAPIError(http.StatusBadRequest, "Subscription is already on plan '"+plan.Name+"'"))
+		return
+	}
+	if periodLength(plan.Period) == 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "Plan '"+plan.Name+"' is not a recurring plan"))
+		return
+	}
+
+	proration := computeProration(subscription, plan, now)
+
+	if proration.Difference > 0 {
+		amountInSubunits := minorUnits(proration.Difference, subscription.Currency)
+		provider, reason := routeProvider(c.Request.Context(), subscription.Currency, buyerCountry(c, userID))
+		publisher := server.Author
+		notes := map[string]string{
+			"server_name":     subscription.ServerName,
+			"plan":            plan.Name,
+			"user_id":         userID,
+			"subscription_id": subscription.ID,
+		}
+
+		commission, err := commissionFor(c.Request.Context(), server.Meta.OwnerID)
+		if err != nil {
+			respondError(c, internalError("Error creating proration order", err))
+			return
+		}
+		billing, err := getBillingProfileCopy(c.Request.Context(), userID)
+		if err != nil {
+			respondError(c, internalError("Error creating proration order", err))
+			return
+		}
+
+		orderID, orderInfo, keyID, err := createProviderOrder(c.Request.Context(), provider, amountInSubunits, subscription.Currency, subscription.ServerName, notes)
+		if err != nil {
+			respondError(c, paymentError("Error creating proration order", err))
+			return
+		}
+
+		err = storeOrder(c.Request.Context(), &models.Order{
+			ID:             orderID,
+			TenantID:       subscription.TenantID,
+			Kind:           "plan_change",
+			UserID:         userID,
+			ServerName:     subscription.ServerName,
+			Plan:           plan.Name,
+			Period:         plan.Period,
+			Amount:         proration.Difference,
+			PlanAmount:     plan.Amount,
+			Currency:       subscription.Currency,
+			Provider:       provider,
+			RoutingReason:  reason,
+			Publisher:      publisher,
+			OwnerID:        server.Meta.OwnerID,
+			CommissionPct:  commission,
+			Status:         "created",
+			Billing:        billing,
+			SubscriptionID: subscription.ID,
+			ResetCycle:     proration.ResetCycle,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			respondError(c, internalError("Error saving proration order", err))
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":    "payment_required",
+			"proration": proration,
+			"order":     orderInfo,
+			"key_id":    keyID,
+			"provider":  provider,
+		})
+		return
+	}
+
+	var refund map[string]interface{}
+	if credit := -proration.Difference; credit >= 0.01 {
+		refund, err = refundPayment(c.Request.Context(), subscription.Provider, subscription.PaymentID, minorUnits(credit, subscription.Currency))
+		if err != nil {
+			respondError(c, paymentError("Error issuing proration credit", err))
+			return
+		}
+		if err := recordRefund(c.Request.Context(), subscription.OrderID, credit); err != nil {
+			slog.Warn("failed to record proration credit", "order_id", subscription.OrderID, "error", err)
+		}
+	}
+
+	updated, err := applyPlanChange(c.Request.Context(), subscription.ID, plan, proration.ResetCycle)
+	if err != nil {
+		respondError(c, internalError("Error changing plan", err))
+		return
+	}
+	if updated != nil {
+		publishPurchaseEvent("subscription.plan_changed", updated, map[string]interface{}{"old_plan": subscription.Plan})
+		if refund != nil {
+			publishPurchaseEvent("refund.created", updated, map[string]interface{}{"refund_id": refund["id"], "refund_amount": -proration.Difference})
+		}
+	}
+	response := gin.H{
+		"status":       "success",
+		"proration":    proration,
+		"subscription": updated,
+	}
+	if refund != nil {
+		response["refund"] = gin.H{
+			"id":     refund["id"],
+			"amount": refund["amount"],
+			"status": refund["status"],
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func refundPayment(ctx context.Context, provider string, paymentID string, amountInSubunits int) (map[string]interface{}, error) {
+	if paymentID == "" {
+		return nil, fmt.Errorf("subscription has no payment to credit")
+	}
+	if provider == "stripe" {
+		form := url.Values{}
+		form.Set("payment_intent", paymentID)
+		form.Set("amount", strconv.Itoa(amountInSubunits))
+		return stripeRequest(ctx, "POST", "/refunds", form)
+	}
+	return razorpayRefund(ctx, paymentID, amountInSubunits)
+}
+
+func razorpayRefund(ctx context.Context, paymentID string, amountInSubunits int) (map[string]interface{}, error) {
+	url := fmt.Sprintf("https://api.razorpay.com/v1/payments/%s/refund", paymentID)
+
+	jsonData, err := json.Marshal(map[string]interface{}{"amount": amountInSubunits})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newOutboundRequest(ctx, "POST", url, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	setRazorpayAuth(ctx, req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doUpstream("razorpay", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseRazorpayError(resp)
+	}
+
+	var refund map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&refund); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
+
+	"superbox/server/config"
+	"superbox/server/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pages in pages/ define "title" and "content" (and optionally "style") and
+// are executed through the "layout" template in layouts/*.html, with every
+// partial in partials/. Emails in emails/ define "subject" and "body" and
+// are executed through the "email" template in layouts/*.txt. Both get the
+// tenant's branding as .brand.
+var (
+	parsedPages   = make(map[string]*htmltemplate.Template)
+	parsedEmails  = make(map[string]*texttemplate.Template)
+	templateMutex sync.Mutex
+)
+
+var templateFuncs = map[string]any{
+	"year": func() int { return time.Now().UTC().Year() },
+	// initial stands in for a missing logo.
+	"initial": func(name string) string {
+		letter, _ := utf8.DecodeRuneInString(name)
+		if letter == utf8.RuneError {
+			return ""
+		}
+		return strings.ToUpper(string(letter))
+	},
+}
+
+func templateFS() fs.FS {
+	if appConfig.TemplatesDir != "" {
+		return os.DirFS(appConfig.TemplatesDir)
+	}
+	return templates.FS
+}
+
+func readTemplate(name string) ([]byte, error) {
+	return fs.ReadFile(templateFS(), name)
+}
+
+// pageTemplate parses a page with the layouts and partials. Parsed pages are
+// cached unless TEMPLATES_DIR is set, so edits show up on the next request.
+func pageTemplate(name string) (*htmltemplate.Template, error) {
+	templateMutex.Lock()
+	defer templateMutex.Unlock()
+	if tmpl, exists := parsedPages[name]; exists && appConfig.TemplatesDir == "" {
+		return tmpl, nil
+	}
+	tmpl, err := htmltemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS(), "layouts/*.html", "partials/*.html", "pages/"+name+".html")
+	if err != nil {
+		return nil, err
+	}
+	parsedPages[name] = tmpl
+	return tmpl, nil
+}
+
+func emailTemplate(name string) (*texttemplate.Template, error) {
+	templateMutex.Lock()
+	defer templateMutex.Unlock()
+	if tmpl, exists := parsedEmails[name]; exists && appConfig.TemplatesDir == "" {
+		return tmpl, nil
+	}
+	tmpl, err := texttemplate.New(name).Funcs(templateFuncs).ParseFS(templateFS(), "layouts/*.txt", "emails/"+name+".txt")
+	if err != nil {
+		return nil, err
+	}
+	parsedEmails[name] = tmpl
+	return tmpl, nil
+}
+
+// renderPage writes pages/<name>.html with the request tenant's branding.
+func renderPage(c *gin.Context, status int, name string, data gin.H) {
+	tmpl, err := pageTemplate(name)
+	if err != nil {
+		slog.Error("failed to render page", "template", name, "error", err)
+		c.String(http.StatusInternalServerError, "Template error")
+		return
+	}
+
+	data["brand"] = requestTenant(c).Branding
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		slog.Error("failed to render page", "template", name, "error", err)
+		c.String(http.StatusInternalServerError, "Template error")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// renderErrorPage answers a browser with a branded error page.
+func renderErrorPage(c *gin.Context, status int, message string) {
+	renderPage(c, status, "error", gin.H{
+		"title":   http.StatusText(status),
+		"message": message,
+	})
+}
+
+// renderEmail returns the subject and body of emails/<name>.txt with the
+// tenant's branding.
+func renderEmail(tenant *config.Tenant, name string, data map[string]any) (string, string, error) {
+	tmpl, err := emailTemplate(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	data["brand"] = tenant.Branding
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "email", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
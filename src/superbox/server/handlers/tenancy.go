@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"superbox/server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tenantHeader = "X-SuperBox-Tenant"
+
+type tenantContextKey struct{}
+
+var (
+	defaultTenant = &config.Tenant{ID: config.DefaultTenantID, Branding: config.Branding{Name: "SuperBox"}}
+	tenants       = map[string]*config.Tenant{config.DefaultTenantID: defaultTenant}
+	tenantHosts   = make(map[string]*config.Tenant)
+	tenantMutex   sync.RWMutex
+)
+
+// configureTenants builds the default tenant from the environment and fills
+// in credentials that TENANTS_FILE entries leave unset.
+func configureTenants(cfg *config.Config) {
+	base := &config.Tenant{
+		ID:                    config.DefaultTenantID,
+		Branding:              config.Branding{Name: "SuperBox"},
+		GoogleClientID:        cfg.GoogleClientID,
+		GoogleClientSecret:    cfg.GoogleClientSecret,
+		GithubClientID:        cfg.GithubClientID,
+		GithubClientSecret:    cfg.GithubClientSecret,
+		GitlabClientID:        cfg.GitlabClientID,
+		GitlabClientSecret:    cfg.GitlabClientSecret,
+		GitlabBaseURL:         cfg.GitlabBaseURL,
+		MicrosoftClientID:     cfg.MicrosoftClientID,
+		MicrosoftClientSecret: cfg.MicrosoftClientSecret,
+		MicrosoftTenantID:     cfg.MicrosoftTenantID,
+		RazorpayKeyID:         cfg.RazorpayKeyID,
+		RazorpayKeySecret:     cfg.RazorpayKeySecret,
+		StripeSecretKey:       cfg.StripeSecretKey,
+		StripePublishableKey:  cfg.StripePublishableKey,
+	}
+
+	byID := map[string]*config.Tenant{base.ID: base}
+	byHost := make(map[string]*config.Tenant)
+	for _, configured := range cfg.Tenants {
+		tenant := configured
+		tenant.Hosts = append([]string(nil), configured.Hosts...)
+		tenant.Members = append([]string(nil), configured.Members...)
+		tenant.MemberDomains = append([]string(nil), configured.MemberDomains...)
+		if tenant.GoogleClientID == "" {
+			tenant.GoogleClientID, tenant.GoogleClientSecret = base.GoogleClientID, base.GoogleClientSecret
+		}
+		if tenant.GithubClientID == "" {
+			tenant.GithubClientID, tenant.GithubClientSecret = base.GithubClientID, base.GithubClientSecret
+		}
+		if tenant.GitlabClientID == "" {
+			tenant.GitlabClientID, tenant.GitlabClientSecret = base.GitlabClientID, base.GitlabClientSecret
+		}
+		if tenant.GitlabBaseURL == "" {
+			tenant.GitlabBaseURL = base.GitlabBaseURL
+		}
+		tenant.GitlabBaseURL = strings.TrimSuffix(tenant.GitlabBaseURL, "/")
+		if tenant.MicrosoftClientID == "" {
+			tenant.MicrosoftClientID, tenant.MicrosoftClientSecret = base.MicrosoftClientID, base.MicrosoftClientSecret
+		}
+		if tenant.MicrosoftTenantID == "" {
+			tenant.MicrosoftTenantID = base.MicrosoftTenantID
+		}
+		if tenant.RazorpayKeyID == "" {
+			tenant.RazorpayKeyID, tenant.RazorpayKeySecret = base.RazorpayKeyID, base.RazorpayKeySecret
+		}
+		if tenant.StripeSecretKey == "" {
+			tenant.StripeSecretKey, tenant.StripePublishableKey = base.StripeSecretKey, base.StripePublishableKey
+		}
+		byID[tenant.ID] = &tenant
+		for _, host := range tenant.Hosts {
+			byHost[host] = &tenant
+		}
+	}
+
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	defaultTenant = base
+	tenants = byID
+	tenantHosts = byHost
+}
+
+// tenantByID returns the named tenant, or the default tenant for records
+// created before tenancy was enabled.
+func tenantByID(id string) *config.Tenant {
+	tenantMutex.RLock()
+	defer tenantMutex.RUnlock()
+	if tenant, exists := tenants[id]; exists {
+		return tenant
+	}
+	return defaultTenant
+}
+
+func allTenants() []*config.Tenant {
+	tenantMutex.RLock()
+	result := make([]*config.Tenant, 0, len(tenants))
+	for _, tenant := range tenants {
+		result = append(result, tenant)
+	}
+	tenantMutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+func withTenant(ctx context.Context, tenant *config.Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFrom returns the tenant resolved for a request. Background work that
+// does not carry one acts on the default tenant.
+func tenantFrom(ctx context.Context) *config.Tenant {
+	if tenant, ok := ctx.Value(tenantContextKey{}).(*config.Tenant); ok {
+		return tenant
+	}
+	tenantMutex.RLock()
+	defer tenantMutex.RUnlock()
+	return defaultTenant
+}
+
+func requestTenant(c *gin.Context) *config.Tenant {
+	return tenantFrom(c.Request.Context())
+}
+
+// Tenancy resolves the tenant from the request host, falling back to the
+// X-SuperBox-Tenant header for clients such as the CLI that talk to a shared
+// API host. A header naming a different tenant than the host is rejected.
+func Tenancy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host := strings.ToLower(c.Request.Host)
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+
+		tenantMutex.RLock()
+		tenant, byHost := tenantHosts[host]
+		requested, known := tenants[c.GetHeader(tenantHeader)]
+		if !byHost {
+			tenant = defaultTenant
+		}
+		tenantMutex.RUnlock()
+
+		if header := c.GetHeader(tenantHeader); header != "" {
+			if !known {
+				respondError(c, newAPIError(http.StatusNotFound, "Unknown tenant '"+header+"'").withCode("unknown_tenant"))
+				return
+			}
+			if byHost && requested.ID != tenant.ID {
+				respondError(c, newAPIError(http.StatusBadRequest, "X-SuperBox-Tenant does not match the tenant for this host").withCode("tenant_mismatch"))
+				return
+			}
+			tenant = requested
+		}
+
+		c.Set("tenant_id", tenant.ID)
+		c.Request = c.Request.WithContext(withTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// tenantMember reports whether the account may act in the tenant. Admins
+// are members of every tenant.
+func tenantMember(tenant *config.Tenant, account map[string]interface{}) bool {
+	if len(tenant.Members) == 0 && len(tenant.MemberDomains) == 0 {
+		return true
+	}
+	userID, _ := account["localId"].(string)
+	if isAdmin(userID) || slices.Contains(tenant.Members, userID) {
+		return true
+	}
+	email, _ := account["email"].(string)
+	verified, _ := account["emailVerified"].(bool)
+	at := strings.LastIndex(email, "@")
+	return verified && at >= 0 && slices.Contains(tenant.MemberDomains, strings.ToLower(email[at+1:]))
+}
+
+func notTenantMember(tenant *config.Tenant) *APIError {
+	return newAPIError(http.StatusForbidden, "This account is not a member of "+tenant.Branding.Name).withCode("tenant_membership_required")
+}
+
+func RegisterTenant(api *gin.RouterGroup) {
+	api.GET("/tenant", Cached(tenantCache), getTenant)
+}
+
+func getTenant(c *gin.Context) {
+	tenant := requestTenant(c)
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"tenant":         tenant.ID,
+		"branding":       tenant.Branding,
+		"registry_reads": registryReadMode(tenant),
+		"providers": gin.H{
+			"google":    tenant.GoogleClientID != "",
+			"github":    tenant.GithubClientID != "",
+			"gitlab":    tenant.GitlabClientID != "",
+			"microsoft": tenant.MicrosoftClientID != "",
+			"razorpay":  tenant.RazorpayKeyID != "",
+			"stripe":    tenant.StripeSecretKey != "",
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func HSTS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil && appConfig.HSTSMaxAge > 0 {
+			c.Header("Strict-Transport-Security", "max-age="+strconv.Itoa(appConfig.HSTSMaxAge)+"; includeSubDomains")
+		}
+		c.Next()
+	}
+}
+
+func HTTPSRedirect() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if appConfig.Port != "443" {
+			host = net.JoinHostPort(host, appConfig.Port)
+		}
+
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"superbox/server/config"
+	"superbox/server/models"
+	"superbox/server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	idTokenType            = "urn:ietf:params:oauth:token-type:id_token"
+
+	// partnerTokenPrefix marks tokens issued by the exchange, so they are
+	// looked up here rather than sent to the identity provider.
+	partnerTokenPrefix = "sbx_"
+	partnerTokenTTL    = time.Hour
+)
+
+// scopeActions are the policy actions each token scope allows. An entry
+// ending in a dot allows every action under it. Admin actions, profile
+// changes, webhooks, consents, and payouts belong to no scope, so an
+// exchanged token can never reach them.
+var scopeActions = map[string][]string{
+	"registry:read":  {"servers.list", "servers.read", "servers.download", "servers.pricing_history", "servers.export", "servers.lint"},
+	"registry:write": {"servers.create", "servers.update", "servers.delete", "servers.import", "blobs.", "operations.read"},
+	"profile:read":   {"profile.read", "limits.read"},
+	"installs":       {"installs."},
+	"purchases":      {"entitlements.list", "subscriptions.list", "orders.create"},
+	"notifications":  {"notifications.", "notification_preferences.read"},
+}
+
+// partnerToken is what the state store holds for an exchanged token, or for
+// a scoped token issued to a device client. The account is the one looked up
+// at issue time, since the partner never holds the user's own ID token; it
+// holds the user's profile and claims, so it is stored sealed.
+type partnerToken struct {
+	UserID        string                 `json:"user_id"`
+	TenantID      string                 `json:"tenant_id"`
+	ClientID      string                 `json:"client_id"`
+	DeviceClient  bool                   `json:"device_client,omitempty"`
+	Scopes        []string               `json:"scopes"`
+	Account       map[string]interface{} `json:"-"`
+	SealedAccount string                 `json:"sealed_account"`
+	ExpiresAt     time.Time              `json:"expires_at"`
+}
+
+func partnerTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "exchanged_token:" + hex.EncodeToString(sum[:])
+}
+
+func consentsKey(tenantID string, userID string) string {
+	return "consents:" + tenantID + ":" + userID
+}
+
+func findPartner(clientID string) (config.Partner, bool) {
+	for _, partner := range appConfig.Partners {
+		if partner.ClientID == clientID {
+			return partner, true
+		}
+	}
+	return config.Partner{}, false
+}
+
+func scopeAllows(scopes []string, action string) bool {
+	return actionsAllow(scopeActions, scopes, action)
+}
+
+// actionsAllow reports whether any of scopes allows action, given the
+// actions each scope allows.
+func actionsAllow(actions map[string][]string, scopes []string, action string) bool {
+	for _, scope := range scopes {
+		for _, allowed := range actions[scope] {
+			if action == allowed || (strings.HasSuffix(allowed, ".") && strings.HasPrefix(action, allowed)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadConsents returns the user's consents keyed by client ID. They are kept
+// in the state store so every replica honours a revocation at once.
+func loadConsents(ctx context.Context, tenantID string, userID string) (map[string]models.PartnerConsent, error) {
+	consents := map[string]models.PartnerConsent{}
+	data, err := stateStore.Get(ctx, consentsKey(tenantID, userID))
+	if errors.Is(err, store.ErrNotFound) {
+		return consents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &consents); err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func updateConsents(ctx context.Context, tenantID string, userID string, change func(map[string]models.PartnerConsent)) error {
+	key := consentsKey(tenantID, userID)
+	if _, err := stateStore.SetNX(ctx, key, []byte("{}"), 0); err != nil {
+		return err
+	}
+	return stateStore.Update(ctx, key, 0, func(current []byte) ([]byte, error) {
+		consents := map[string]models.PartnerConsent{}
+		if err := json.Unmarshal(current, &consents); err != nil {
+			return nil, err
+		}
+		change(consents)
+		return json.Marshal(consents)
+	})
+}
+
+func grantConsent(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req models.GrantConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	partner, exists := findPartner(req.ClientID)
+	if !exists {
+		respondError(c, newAPIError(http.StatusNotFound, "Partner '"+req.ClientID+"' not found"))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(partner.Scopes, scope) {
+			apiErr := newAPIError(http.StatusBadRequest, partner.Name+" cannot be granted '"+scope+"'").withCode("invalid_request")
+			apiErr.Fields = []FieldError{{Field: "scopes", Message: "must be among " + strings.Join(partner.Scopes, ", ")}}
+			respondError(c, apiErr)
+			return
+		}
+	}
+
+	scopes := append([]string{}, req.Scopes...)
+	slices.Sort(scopes)
+	consent := models.PartnerConsent{
+		ClientID:    partner.ClientID,
+		PartnerName: partner.Name,
+		Scopes:      slices.Compact(scopes),
+		GrantedAt:   models.Now(),
+	}
+	err := updateConsents(c.Request.Context(), requestTenant(c).ID, userID, func(consents map[string]models.PartnerConsent) {
+		consents[partner.ClientID] = consent
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to save consent", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"consent": consent,
+	})
+}
+
+func listConsents(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	consents, err := loadConsents(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load consents", err))
+		return
+	}
+	list := make([]models.PartnerConsent, 0, len(consents))
+	for _, consent := range consents {
+		list = append(list, consent)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"consents": list,
+	})
+}
+
+// revokeConsent withdraws a partner's consent. Tokens the partner already
+// exchanged stop working on their next request.
+func revokeConsent(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	clientID := c.Param("client_id")
+	tenantID := requestTenant(c).ID
+	consents, err := loadConsents(c.Request.Context(), tenantID, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load consents", err))
+		return
+	}
+	if _, exists := consents[clientID]; !exists {
+		respondError(c, newAPIError(http.StatusNotFound, "No consent for partner '"+clientID+"'"))
+		return
+	}
+	err = updateConsents(c.Request.Context(), tenantID, userID, func(consents map[string]models.PartnerConsent) {
+		delete(consents, clientID)
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to revoke consent", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Consent for '" + clientID + "' revoked",
+	})
+}
+
+// exchangeToken lets a registered partner trade a user's ID token for a
+// SuperBox token that acts for that user within the scopes they consented
+// to. The partner authenticates with its client credentials, in the body or
+// with HTTP Basic.
+func exchangeToken(c *gin.Context) {
+	var req models.TokenExchangeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	if req.GrantType != tokenExchangeGrantType {
+		respondError(c, newAPIError(http.StatusBadRequest, "grant_type must be "+tokenExchangeGrantType).withCode("unsupported_grant_type"))
+		return
+	}
+	if req.SubjectTokenType != "" && req.SubjectTokenType != idTokenType && req.SubjectTokenType != accessTokenType {
+		respondError(c, newAPIError(http.StatusBadRequest, "subject_token_type must be "+idTokenType).withCode("invalid_request"))
+		return
+	}
+
+	clientID, clientSecret := req.ClientID, req.ClientSecret
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		clientID, clientSecret = username, password
+	}
+	partner, exists := findPartner(clientID)
+	secretHash := sha256.Sum256([]byte(clientSecret))
+	if !exists || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(secretHash[:])), []byte(partner.ClientSecretSHA256)) != 1 {
+		c.Header("WWW-Authenticate", `Basic realm="superbox"`)
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid client credentials").withCode("invalid_client"))
+		return
+	}
+
+	// Exchanged tokens cannot be exchanged again; the subject must be the
+	// user's own ID token.
+	var account map[string]interface{}
+	if !strings.HasPrefix(req.SubjectToken, partnerTokenPrefix) {
+		account, _ = lookupAccount(c.Request.Context(), req.SubjectToken)
+	}
+	userID, _ := account["localId"].(string)
+	if userID == "" {
+		respondError(c, newAPIError(http.StatusBadRequest, "subject_token is invalid or expired").withCode("invalid_grant"))
+		return
+	}
+	tenant := requestTenant(c)
+	if !tenantMember(tenant, account) {
+		respondError(c, notTenantMember(tenant))
+		return
+	}
+
+	tenantID := tenant.ID
+	consents, err := loadConsents(c.Request.Context(), tenantID, userID)
+	if err != nil {
+		respondError(c, internalError("Failed to load consents", err))
+		return
+	}
+	consent, exists := consents[partner.ClientID]
+	if !exists {
+		respondError(c, newAPIError(http.StatusForbidden, "The user has not authorized "+partner.Name).withCode("consent_required"))
+		return
+	}
+	granted := []string{}
+	for _, scope := range consent.Scopes {
+		if slices.Contains(partner.Scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			respondError(c, newAPIError(http.StatusBadRequest, "Scope '"+scope+"' has not been granted to "+partner.Name).withCode("invalid_scope"))
+			return
+		}
+	}
+	if len(scopes) == 0 {
+		respondError(c, newAPIError(http.StatusBadRequest, "No scopes have been granted to "+partner.Name).withCode("invalid_scope"))
+		return
+	}
+
+	token, err := issuePartnerToken(c.Request.Context(), partnerToken{
+		UserID:   userID,
+		TenantID: tenantID,
+		ClientID: partner.ClientID,
+		Scopes:   scopes,
+		Account:  account,
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to issue token", err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.TokenExchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: accessTokenType,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(partnerTokenTTL.Seconds()),
+		Scope:           strings.Join(scopes, " "),
+	})
+}
+
+// issuePartnerToken stores record under a new token that lives for
+// partnerTokenTTL. The account is sealed to the record's key.
+func issuePartnerToken(ctx context.Context, record partnerToken) (string, error) {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	token := partnerTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	key := partnerTokenKey(token)
+	account, err := json.Marshal(record.Account)
+	if err != nil {
+		return "", err
+	}
+	if record.SealedAccount, err = sealValue(account, []byte(key)); err != nil {
+		return "", err
+	}
+	record.ExpiresAt = models.Timestamp(time.Now().Add(partnerTokenTTL))
+	data, _ := json.Marshal(record)
+	if err := stateStore.Set(ctx, key, data, partnerTokenTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// partnerAccount authenticates a request made with an exchanged token. The
+// token must be live, issued in this tenant, still covered by the user's
+// consent (or, for a device client, by a client that is not revoked), and
+// scoped for the route's action.
+func partnerAccount(c *gin.Context, token string) (map[string]interface{}, bool) {
+	ctx := c.Request.Context()
+	var record partnerToken
+	key := partnerTokenKey(token)
+	data, err := stateStore.Get(ctx, key)
+	if err == nil {
+		err = json.Unmarshal(data, &record)
+	}
+	if err == nil {
+		var account []byte
+		if account, err = openValue(record.SealedAccount, []byte(key)); err == nil {
+			err = json.Unmarshal(account, &record.Account)
+		}
+	}
+	if err != nil || record.TenantID != requestTenant(c).ID {
+		respondError(c, newAPIError(http.StatusUnauthorized, "invalid or expired token"))
+		return nil, false
+	}
+
+	scopes := []string{}
+	if record.DeviceClient {
+		client, err := loadDeviceClient(ctx, record.ClientID)
+		if err != nil || !client.RevokedAt.IsZero() {
+			respondError(c, newAPIError(http.StatusUnauthorized, "the client this token was issued to has been revoked"))
+			return nil, false
+		}
+		for _, scope := range record.Scopes {
+			if slices.Contains(client.Scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	} else {
+		partner, exists := findPartner(record.ClientID)
+		consents, err := loadConsents(ctx, record.TenantID, record.UserID)
+		if err != nil {
+			respondError(c, internalError("Failed to load consents", err))
+			return nil, false
+		}
+		consent, consented := consents[record.ClientID]
+		if !exists || !consented {
+			respondError(c, newAPIError(http.StatusUnauthorized, "consent for this token has been revoked"))
+			return nil, false
+		}
+		for _, scope := range record.Scopes {
+			if slices.Contains(consent.Scopes, scope) && slices.Contains(partner.Scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	if action := c.GetString("policy_action"); !scopeAllows(scopes, action) {
+		respondError(c, newAPIError(http.StatusForbidden, "Token scope does not allow '"+action+"'").withCode("insufficient_scope"))
+		return nil, false
+	}
+
+	c.Set("user_id", record.UserID)
+	c.Set("partner_id", record.ClientID)
+	c.Set("account", record.Account)
+	return record.Account, true
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// upstreamCode is the stable error a client sees in place of a provider's
+// own error code, which is kept only in logs.
+type upstreamCode struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+var firebaseErrorCodes = map[string]upstreamCode{
+	"EMAIL_EXISTS":                     {http.StatusConflict, "email_exists", "An account with this email already exists"},
+	"EMAIL_NOT_FOUND":                  {http.StatusUnauthorized, "invalid_credentials", "Email or password is incorrect"},
+	"INVALID_PASSWORD":                 {http.StatusUnauthorized, "invalid_credentials", "Email or password is incorrect"},
+	"INVALID_LOGIN_CREDENTIALS":        {http.StatusUnauthorized, "invalid_credentials", "Email or password is incorrect"},
+	"USER_DISABLED":                    {http.StatusForbidden, "account_disabled", "This account has been disabled"},
+	"TOO_MANY_ATTEMPTS_TRY_LATER":      {http.StatusTooManyRequests, "too_many_attempts", "Too many attempts; try again later"},
+	"WEAK_PASSWORD":                    {http.StatusUnprocessableEntity, "weak_password", "Password is too weak"},
+	"INVALID_EMAIL":                    {http.StatusUnprocessableEntity, "invalid_email", "Email address is not valid"},
+	"MISSING_PASSWORD":                 {http.StatusUnprocessableEntity, "validation_failed", "Password is required"},
+	"INVALID_ID_TOKEN":                 {http.StatusUnauthorized, "invalid_token", "Token is invalid or expired"},
+	"USER_NOT_FOUND":                   {http.StatusUnauthorized, "invalid_token", "Token is invalid or expired"},
+	"TOKEN_EXPIRED":                    {http.StatusUnauthorized, "session_expired", "Session has expired; sign in again"},
+	"INVALID_REFRESH_TOKEN":            {http.StatusUnauthorized, "session_expired", "Session has expired; sign in again"},
+	"MISSING_REFRESH_TOKEN":            {http.StatusBadRequest, "invalid_request", "refresh_token is required"},
+	"CREDENTIAL_TOO_OLD_LOGIN_AGAIN":   {http.StatusUnauthorized, "reauthentication_required", "Sign in again to make this change"},
+	"INVALID_IDP_RESPONSE":             {http.StatusUnauthorized, "provider_rejected", "The sign-in provider rejected the credentials"},
+	"FEDERATED_USER_ID_ALREADY_LINKED": {http.StatusConflict, "account_already_linked", "This provider account is linked to another user"},
+	"OPERATION_NOT_ALLOWED":            {http.StatusForbidden, "sign_in_method_disabled", "This sign-in method is disabled"},
+}
+
+// identityError maps a Firebase error to a stable code. Firebase messages
+// look like "WEAK_PASSWORD : Password should be at least 6 characters", so
+// only the part before " : " is matched. Unknown codes, such as API key
+// problems, are the server's fault and surface as a 502.
+func identityError(err error) *APIError {
+	var fbErr *firebaseError
+	if !errors.As(err, &fbErr) {
+		return upstreamError("Identity service unavailable", err)
+	}
+
+	code, _, _ := strings.Cut(fbErr.Code, " : ")
+	mapped, known := firebaseErrorCodes[strings.TrimSpace(code)]
+	if !known {
+		return upstreamError("Identity service rejected the request", err)
+	}
+	apiErr := newAPIError(mapped.Status, mapped.Message).withCode(mapped.Code)
+	apiErr.Err = err
+	return apiErr
+}
+
+// razorpayError is a non-2xx Razorpay response. Code is Razorpay's error
+// class (BAD_REQUEST_ERROR, GATEWAY_ERROR, SERVER_ERROR).
+type razorpayError struct {
+	HTTPStatus  int
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Reason      string `json:"reason"`
+	Field       string `json:"field"`
+}
+
+func (e *razorpayError) Error() string {
+	return fmt.Sprintf("razorpay API error: %d %s: %s", e.HTTPStatus, e.Code, e.Description)
+}
+
+func parseRazorpayError(resp *http.Response) error {
+	var body struct {
+		Error razorpayError `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	body.Error.HTTPStatus = resp.StatusCode
+	return &body.Error
+}
+
+// stripeError is a non-2xx Stripe response. Type is Stripe's error class
+// (api_error, card_error, invalid_request_error) and Code the specific
+// reason, such as resource_missing or charge_already_refunded.
+type stripeError struct {
+	HTTPStatus  int
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code"`
+	Message     string `json:"message"`
+}
+
+func (e *stripeError) Error() string {
+	return fmt.Sprintf("stripe API error: %d %s %s: %s", e.HTTPStatus, e.Type, e.Code, e.Message)
+}
+
+func parseStripeError(resp *http.Response) error {
+	var body struct {
+		Error stripeError `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	body.Error.HTTPStatus = resp.StatusCode
+	return &body.Error
+}
+
+// paymentError maps a payment provider failure to a stable code. Requests
+// Razorpay or Stripe refuses become 4xx the caller can act on;
+// authentication and provider outages become 503 payments_unavailable.
+// Other errors keep the generic 502 with message.
+func paymentError(message string, err error) *APIError {
+	var (
+		status      int
+		unavailable bool
+		declined    bool
+		notFound    bool
+		refund      bool
+		rejected    bool
+	)
+	var rzpErr *razorpayError
+	var stripeErr *stripeError
+	switch {
+	case errors.As(err, &rzpErr):
+		description := strings.ToLower(rzpErr.Description)
+		status = rzpErr.HTTPStatus
+		unavailable = rzpErr.Code == "SERVER_ERROR"
+		declined = rzpErr.Code == "GATEWAY_ERROR"
+		notFound = strings.Contains(description, "does not exist")
+		refund = strings.Contains(description, "refund")
+		rejected = rzpErr.Code == "BAD_REQUEST_ERROR"
+	case errors.As(err, &stripeErr):
+		status = stripeErr.HTTPStatus
+		unavailable = stripeErr.Type == "api_error"
+		declined = stripeErr.Type == "card_error"
+		notFound = stripeErr.Code == "resource_missing"
+		refund = strings.Contains(stripeErr.Code, "refund")
+		rejected = stripeErr.Type == "invalid_request_error"
+	default:
+		return upstreamError(message, err)
+	}
+
+	var apiErr *APIError
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden,
+		status == http.StatusTooManyRequests || status >= http.StatusInternalServerError || unavailable:
+		apiErr = newAPIError(http.StatusServiceUnavailable, "Payments are temporarily unavailable").withCode("payments_unavailable")
+	case declined:
+		apiErr = newAPIError(http.StatusBadGateway, "The payment gateway declined the request; try again or use another method").withCode("payment_gateway_error")
+	case status == http.StatusNotFound || notFound:
+		apiErr = newAPIError(http.StatusNotFound, "Payment not found").withCode("payment_not_found")
+	case refund:
+		apiErr = newAPIError(http.StatusConflict, "The payment cannot be refunded by this amount").withCode("refund_not_allowed")
+	case rejected:
+		apiErr = newAPIError(http.StatusBadRequest, "The payment provider rejected the request").withCode("payment_rejected")
+	default:
+		return upstreamError(message, err)
+	}
+	apiErr.Err = err
+	return apiErr
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+func RegisterV2(api *gin.RouterGroup) {
+	servers := api.Group("/servers", ServerRedirects())
+	{
+		servers.GET("", RateLimited("search"), Cached(catalogCache), listServersV2)
+		servers.GET("/:server_name", Cached(catalogCache), getServerV2)
+	}
+
+	payment := api.Group("/payment")
+	{
+		payment.GET("/entitlements", listEntitlementsV2)
+		payment.GET("/subscriptions", listSubscriptionsV2)
+	}
+
+	api.GET("/blobs", listBlobsV2)
+	api.GET("/me/notifications", listNotificationsV2)
+}
+
+// pageCursor is a decoded list cursor: the key of the item the page starts
+// after, or, when before is set, the key of the item it ends before.
+type pageCursor struct {
+	key    string
+	before bool
+}
+
+const (
+	cursorAfter  = "after:"
+	cursorBefore = "before:"
+)
+
+func encodeCursor(prefix string, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(prefix + key))
+}
+
+func decodeCursor(raw string) (pageCursor, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return pageCursor{}, false
+	}
+	if key, found := strings.CutPrefix(string(decoded), cursorAfter); found && key != "" {
+		return pageCursor{key: key}, true
+	}
+	if key, found := strings.CutPrefix(string(decoded), cursorBefore); found && key != "" {
+		return pageCursor{key: key, before: true}, true
+	}
+	return pageCursor{}, false
+}
+
+func pageParams(c *gin.Context) (pageCursor, int, bool) {
+	limit := defaultPageLimit
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxPageLimit {
+			apiErr := newAPIError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit)).withCode("invalid_request")
+			apiErr.Fields = []FieldError{{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxPageLimit)}}
+			respondError(c, apiErr)
+			return pageCursor{}, 0, false
+		}
+		limit = value
+	}
+
+	cursor := pageCursor{}
+	if raw := c.Query("cursor"); raw != "" {
+		decoded, ok := decodeCursor(raw)
+		if !ok {
+			respondError(c, invalidCursor())
+			return pageCursor{}, 0, false
+		}
+		cursor = decoded
+	}
+	return cursor, limit, true
+}
+
+func invalidCursor() *APIError {
+	return newAPIError(http.StatusBadRequest, "cursor is not valid").withCode("invalid_cursor")
+}
+
+// paginate returns the page of items next to the item keyed by cursor: the
+// one after it, or the one before it for a prev cursor. Items must already be
+// in a stable order; cursors hold item keys rather than offsets, so inserts
+// do not shift later pages.
+func paginate[T any](items []T, key func(T) string, cursor pageCursor, limit int) ([]T, models.PageV2, bool) {
+	start, end := 0, min(limit, len(items))
+	if cursor.key != "" {
+		index := slices.IndexFunc(items, func(item T) bool { return key(item) == cursor.key })
+		if index < 0 {
+			return nil, models.PageV2{}, false
+		}
+		if cursor.before {
+			start, end = max(index-limit, 0), index
+		} else {
+			start, end = index+1, min(index+1+limit, len(items))
+		}
+	}
+
+	page := models.PageV2{Limit: limit, HasMore: end < len(items)}
+	if page.HasMore && end > 0 {
+		page.NextCursor = encodeCursor(cursorAfter, key(items[end-1]))
+	}
+	if start > 0 && start < len(items) {
+		page.PrevCursor = encodeCursor(cursorBefore, key(items[start]))
+	}
+	return items[start:end], page, true
+}
+
+// pageLinks builds the self, next, and prev links for a page from the
+// request URL, keeping its other query parameters.
+func pageLinks(c *gin.Context, page models.PageV2) models.LinksV2 {
+	link := func(cursor string) string {
+		target := *c.Request.URL
+		query := target.Query()
+		query.Set("cursor", cursor)
+		target.RawQuery = query.Encode()
+		return target.RequestURI()
+	}
+
+	links := models.LinksV2{Self: c.Request.URL.RequestURI()}
+	if page.NextCursor != "" {
+		links.Next = link(page.NextCursor)
+	}
+	if page.PrevCursor != "" {
+		links.Prev = link(page.PrevCursor)
+	}
+	return links
+}
+
+// listPage paginates items for the request and responds with an error when
+// the cursor does not match any of them.
+func listPage[T any](c *gin.Context, items []T, key func(T) string, cursor pageCursor, limit int) ([]T, models.PageV2, models.LinksV2, bool) {
+	data, page, ok := paginate(items, key, cursor, limit)
+	if !ok {
+		respondError(c, invalidCursor())
+		return nil, models.PageV2{}, models.LinksV2{}, false
+	}
+	return data, page, pageLinks(c, page), true
+}
+
+func serverV2(server models.Server) models.ServerV2 {
+	return models.ServerV2{
+		Name:           server.Name,
+		Version:        server.Version,
+		Description:    server.Description,
+		Author:         server.Author,
+		Lang:           server.Lang,
+		License:        server.License,
+		Entrypoint:     server.Entrypoint,
+		Repository:     server.Repository,
+		Pricing:        server.Pricing,
+		Tools:          server.Tools,
+		SecurityReport: server.SecurityReport,
+		CreatedAt:      server.Meta.CreatedAt,
+		UpdatedAt:      server.Meta.UpdatedAt,
+	}
+}
+
+func listServersV2(c *gin.Context) {
+	cursor, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	servers, err := snapshotServers(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Error fetching servers", err))
+		return
+	}
+
+	typed := make([]models.ServerV2, 0, len(servers))
+	for _, server := range servers {
+		typed = append(typed, serverV2(server))
+	}
+	sort.Slice(typed, func(i, j int) bool {
+		return typed[i].Name < typed[j].Name
+	})
+
+	items, page, links, ok := listPage(c, typed, func(server models.ServerV2) string { return server.Name }, cursor, limit)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ServerListV2{Items: items, Total: len(typed), Page: page, Links: links})
+}
+
+func getServerV2(c *gin.Context) {
+	serverName := c.Param("server_name")
+
+	server, err := snapshotServer(c.Request.Context(), serverName)
+	if err != nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Server '"+serverName+"' not found"))
+		return
+	}
+
+	setLastModified(c, server.Meta.UpdatedAt)
+	c.Header("ETag", serverETag(server))
+	c.JSON(http.StatusOK, models.ServerV2Response{Data: serverV2(server)})
+}
+
+func listEntitlementsV2(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	all, err := userEntitlements(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Error listing purchases", err))
+		return
+	}
+	items, page, links, ok := listPage(c, all, entitlementID, cursor, limit)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.EntitlementListV2{Items: items, Total: len(all), Page: page, Links: links})
+}
+
+func listSubscriptionsV2(c *gin.Context) {
+	userID, ok := billingAccount(c)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	all, err := userSubscriptions(c.Request.Context(), requestTenant(c).ID, userID)
+	if err != nil {
+		respondError(c, internalError("Error listing subscriptions", err))
+		return
+	}
+	items, page, links, ok := listPage(c, all, entitlementID, cursor, limit)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.EntitlementListV2{Items: items, Total: len(all), Page: page, Links: links})
+}
+
+func listBlobsV2(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	all, err := userBlobs(c.Request.Context(), requestTenant(c).ID, userID, c.Query("class"))
+	if err != nil {
+		respondError(c, internalError("Error listing blobs", err))
+		return
+	}
+	items, page, links, ok := listPage(c, all, func(blob models.Blob) string { return blob.ID }, cursor, limit)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.BlobListV2{Items: items, Total: len(all), Page: page, Links: links})
+}
+
+func listNotificationsV2(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	all, unread, err := userNotifications(c.Request.Context(), userID, c.Query("unread") == "true")
+	if err != nil {
+		respondError(c, internalError("Error listing notifications", err))
+		return
+	}
+	items, page, links, ok := listPage(c, all, func(notification models.Notification) string { return notification.ID }, cursor, limit)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.NotificationListV2{Items: items, Total: len(all), Unread: unread, Page: page, Links: links})
+}
+
+func entitlementID(entitlement models.Entitlement) string {
+	return entitlement.ID
+}
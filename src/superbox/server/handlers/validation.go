@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"cmp"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const minPasswordLength = 8
+
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// Report fields by their JSON names so clients can map errors to inputs.
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	engine.RegisterValidation("semver", func(fl validator.FieldLevel) bool {
+		return semverPattern.MatchString(fl.Field().String())
+	})
+	engine.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return strongPassword(fl.Field().String())
+	})
+	engine.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		return engine.Var(strings.ToUpper(fl.Field().String()), "iso4217") == nil
+	})
+}
+
+// strongPassword requires minPasswordLength characters with at least one
+// letter and one digit.
+func strongPassword(password string) bool {
+	if len(password) < minPasswordLength {
+		return false
+	}
+	hasLetter, hasDigit := false, false
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	return hasLetter && hasDigit
+}
+
+// fieldPath drops the request struct name from a validator namespace, e.g.
+// "CreateServerRequest.pricing.plans[0].name" becomes "pricing.plans[0].name".
+func fieldPath(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if _, rest, found := strings.Cut(namespace, "."); found {
+		return rest
+	}
+	return namespace
+}
+
+// compareSemver orders two versions accepted by the semver rule, returning
+// -1, 0, or 1. Build metadata is ignored and a pre-release sorts before its
+// release; pre-release tags are compared as plain strings.
+func compareSemver(a string, b string) int {
+	partsA, partsB := semverPattern.FindStringSubmatch(a), semverPattern.FindStringSubmatch(b)
+	if partsA == nil || partsB == nil {
+		return strings.Compare(a, b)
+	}
+	for i := 1; i <= 3; i++ {
+		numberA, _ := strconv.Atoi(partsA[i])
+		numberB, _ := strconv.Atoi(partsB[i])
+		if numberA != numberB {
+			return cmp.Compare(numberA, numberB)
+		}
+	}
+	preA, preB := partsA[4], partsB[4]
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return strings.Compare(preA, preB)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiVersionHeader = "API-Version"
+	latestAPIVersion = 2
+)
+
+var (
+	versionedAPIPath = regexp.MustCompile(`^/api/v\d+(/|$)`)
+	vendorMediaType  = regexp.MustCompile(`application/vnd\.superbox\.v(\d+)\+json`)
+	v1DeprecatedAt   = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+)
+
+func APIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("api_version", version)
+		c.Header(apiVersionHeader, strconv.Itoa(version))
+		if version < latestAPIVersion {
+			c.Header("Deprecation", "@"+strconv.FormatInt(v1DeprecatedAt.Unix(), 10))
+			if !appConfig.APIV1Sunset.IsZero() {
+				c.Header("Sunset", appConfig.APIV1Sunset.UTC().Format(http.TimeFormat))
+			}
+			c.Header("Link", `</api/v`+strconv.Itoa(latestAPIVersion)+`>; rel="successor-version", </docs>; rel="deprecation"`)
+		}
+		c.Next()
+	}
+}
+
+func requestedAPIVersion(r *http.Request) (int, bool) {
+	if raw := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(apiVersionHeader)), "v"); raw != "" {
+		version, err := strconv.Atoi(raw)
+		return version, err == nil && version >= 1 && version <= latestAPIVersion
+	}
+	if match := vendorMediaType.FindStringSubmatch(r.Header.Get("Accept")); match != nil {
+		version, err := strconv.Atoi(match[1])
+		return version, err == nil && version >= 1 && version <= latestAPIVersion
+	}
+	return latestAPIVersion, true
+}
+
+// NegotiateVersion routes unversioned /api/... requests to the version named
+// in the API-Version header or an application/vnd.superbox.vN+json Accept
+// type, defaulting to the latest version. Versioned paths pass through.
+func NegotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || versionedAPIPath.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version, ok := requestedAPIVersion(r)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"unsupported_api_version","message":"Supported API versions are 1 through ` + strconv.Itoa(latestAPIVersion) + `"}}`))
+			return
+		}
+
+		r.URL.Path = "/api/v" + strconv.Itoa(version) + strings.TrimPrefix(r.URL.Path, "/api")
+		r.URL.RawPath = ""
+		next.ServeHTTP(w, r)
+	})
+}
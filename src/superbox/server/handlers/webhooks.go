@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"superbox/server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookSignatureTolerance is how far a delivery's timestamp may be
+	// from the receiver's clock before it should be rejected as a replay.
+	webhookSignatureTolerance = 5 * time.Minute
+	webhookKeyGracePeriod     = 24 * time.Hour
+)
+
+func RegisterWebhooks(api *gin.RouterGroup) {
+	api.GET("/webhooks/signing-keys", listWebhookSigningKeys)
+}
+
+// fulfillOrder settles an order and announces the purchase. Settling the
+// same payment again returns the entitlement without announcing it twice.
+func fulfillOrder(ctx context.Context, orderID string, paymentID string, verifiedBy string) (*models.Entitlement, error) {
+	entitlement, replayed, err := settleOrder(ctx, orderID, paymentID, verifiedBy)
+	if err != nil || replayed {
+		return entitlement, err
+	}
+
+	event := "purchase.completed"
+	order, err := getOrderCopy(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order != nil {
+		switch order.Kind {
+		case "plan_change":
+			event = "subscription.plan_changed"
+		case "renewal":
+			event = "subscription.renewed"
+		}
+	}
+	publishPurchaseEvent(event, entitlement, map[string]interface{}{"order_id": orderID, "payment_id": paymentID})
+	return entitlement, nil
+}
+
+func publishPurchaseEvent(event string, entitlement *models.Entitlement, extra map[string]interface{}) {
+	data := map[string]interface{}{
+		"subscription_id": entitlement.ID,
+		"user_id":         entitlement.UserID,
+		"server_name":     entitlement.ServerName,
+		"plan":            entitlement.Plan,
+		"period":          entitlement.Period,
+		"amount":          entitlement.Amount,
+		"currency":        entitlement.Currency,
+		"status":          entitlement.Status,
+		"expires_at":      entitlement.ExpiresAt,
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	emit(context.Background(), event, entitlement.TenantID, serverSubject(entitlement.TenantID, entitlement.ServerName), data)
+}
+
+func validWebhookURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
+
+func scopeEvents(scope string) []string {
+	events := []string{}
+	for event, eventScope := range webhookEvents {
+		if eventScope == scope {
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// webhookOwner resolves who is managing webhooks on this route: the publisher
+// of :server_name for server routes, or the signed-in user for account routes.
+func webhookOwner(c *gin.Context) (string, string, bool) {
+	serverName := c.Param("server_name")
+	if serverName == "" {
+		userID, ok := authenticatedUser(c)
+		return userID, "", ok
+	}
+	ownerID, _, ok := requireServerOwner(c, serverName)
+	return ownerID, serverName, ok
+}
+
+func ownsWebhook(webhook *models.PublisherWebhook, tenantID string, ownerID string, serverName string) bool {
+	if serverName == "" {
+		return webhook.ServerName == "" && webhook.OwnerID == ownerID
+	}
+	return serverSubject(webhook.TenantID, webhook.ServerName) == serverSubject(tenantID, serverName)
+}
+
+func createPublisherWebhook(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, invalidRequest(err))
+		return
+	}
+	if !validWebhookURL(req.URL) {
+		apiErr := validationFailed()
+		apiErr.Fields = []FieldError{{Field: "url", Message: "must be an absolute https URL"}}
+		respondError(c, apiErr)
+		return
+	}
+
+	scope := serverScope
+	if serverName == "" {
+		scope = accountScope
+	}
+	events := req.Events
+	if len(events) == 0 {
+		events = scopeEvents(scope)
+	}
+	for _, event := range events {
+		if event != "*" && webhookEvents[event] != scope {
+			respondError(c, newAPIError(http.StatusBadRequest, "Unknown event '"+event+"'"))
+			return
+		}
+	}
+
+	webhook := &models.PublisherWebhook{
+		ID:         randomID("wh"),
+		TenantID:   requestTenant(c).ID,
+		ServerName: serverName,
+		OwnerID:    ownerID,
+		URL:        req.URL,
+		Events:     events,
+		CreatedAt:  models.Now(),
+	}
+	addSigningKey(webhook, webhook.CreatedAt, webhookKeyGracePeriod)
+
+	if err := saveWebhook(c.Request.Context(), webhook); err != nil {
+		respondError(c, internalError("Failed to save webhook", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"webhook": webhook,
+	})
+}
+
+func listPublisherWebhooks(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	group := webhookGroup(&models.PublisherWebhook{TenantID: requestTenant(c).ID, ServerName: serverName, OwnerID: ownerID})
+	webhooks, err := groupWebhooks(c.Request.Context(), group)
+	if err != nil {
+		respondError(c, internalError("Failed to list webhooks", err))
+		return
+	}
+	result := []models.PublisherWebhook{}
+	for _, webhook := range webhooks {
+		if ownsWebhook(webhook, requestTenant(c).ID, ownerID, serverName) {
+			result = append(result, redactedWebhook(webhook))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"webhooks": result,
+	})
+}
+
+func deletePublisherWebhook(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	webhookID := c.Param("webhook_id")
+	webhook, ok := ownedWebhook(c, webhookID, ownerID, serverName)
+	if !ok {
+		return
+	}
+	if err := publisherWebhooks.delete(c.Request.Context(), webhook.ID); err != nil {
+		respondError(c, internalError("Failed to delete webhook", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Webhook deleted",
+	})
+}
+
+// addSigningKey makes a new secret the webhook's active key. The previous
+// key keeps signing deliveries for the grace period so receivers can switch
+// secrets without rejecting anything in flight.
+func addSigningKey(webhook *models.PublisherWebhook, now time.Time, grace time.Duration) {
+	keys := []models.WebhookSigningKey{{
+		ID:        randomID("whkey"),
+		WebhookID: webhook.ID,
+		Secret:    randomID("whsec"),
+		Status:    "active",
+		CreatedAt: now,
+	}}
+	for _, key := range webhook.SigningKeys {
+		if key.Status == "active" {
+			key.Status = "retiring"
+			key.ExpiresAt = now.Add(grace)
+		}
+		if key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	webhook.SigningKeys = keys
+	webhook.Secret = keys[0].Secret
+	webhook.KeyID = keys[0].ID
+}
+
+// ownedWebhook loads a webhook the caller manages on this route, answering
+// 404 when there is none.
+func ownedWebhook(c *gin.Context, webhookID string, ownerID string, serverName string) (*models.PublisherWebhook, bool) {
+	webhook, err := getWebhook(c.Request.Context(), webhookID)
+	if err != nil {
+		respondError(c, internalError("Failed to load webhook", err))
+		return nil, false
+	}
+	if webhook == nil || !ownsWebhook(webhook, requestTenant(c).ID, ownerID, serverName) {
+		respondError(c, newAPIError(http.StatusNotFound, "Webhook '"+webhookID+"' not found"))
+		return nil, false
+	}
+	return webhook, true
+}
+
+func redactedWebhook(webhook *models.PublisherWebhook) models.PublisherWebhook {
+	copy := *webhook
+	copy.Secret = ""
+	copy.SigningKeys = nil
+	return copy
+}
+
+func rotateWebhookSecret(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	var req models.RotateWebhookSecretRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+	}
+	grace := webhookKeyGracePeriod
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+
+	webhookID := c.Param("webhook_id")
+	if _, ok := ownedWebhook(c, webhookID, ownerID, serverName); !ok {
+		return
+	}
+	webhook, err := updateWebhook(c.Request.Context(), webhookID, func(webhook *models.PublisherWebhook) error {
+		addSigningKey(webhook, models.Now(), grace)
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to rotate webhook secret", err))
+		return
+	}
+	if webhook == nil {
+		respondError(c, newAPIError(http.StatusNotFound, "Webhook '"+webhookID+"' not found"))
+		return
+	}
+	rotated := *webhook
+	rotated.SigningKeys = nil
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"webhook": rotated,
+	})
+}
+
+// previewWebhookSignature signs a payload the way the next delivery would
+// be signed, with every key still signing, so a receiver can be checked
+// against the new secret before the old one expires. Secrets are not shown.
+func previewWebhookSignature(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	var req models.WebhookSignaturePreviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, invalidRequest(err))
+			return
+		}
+	}
+
+	webhookID := c.Param("webhook_id")
+	timestamp := time.Now().Unix()
+	if req.Payload == "" {
+		req.Payload = fmt.Sprintf(`{"id":"evt_preview","event":"webhook.preview","webhook_id":%q,"created_at":%d}`, webhookID, timestamp)
+	}
+
+	webhook, ok := ownedWebhook(c, webhookID, ownerID, serverName)
+	if !ok {
+		return
+	}
+	header := webhookSignature(webhook, timestamp, req.Payload)
+	signatures := []models.WebhookSignature{}
+	for _, key := range signingKeysAt(webhook, timestamp) {
+		signatures = append(signatures, models.WebhookSignature{
+			KeyID:     key.ID,
+			Status:    key.Status,
+			ExpiresAt: key.ExpiresAt,
+			Signature: signWebhookPayload(key.Secret, timestamp, req.Payload),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"timestamp":  timestamp,
+		"payload":    req.Payload,
+		"header":     header,
+		"signatures": signatures,
+	})
+}
+
+// retireWebhookSigningKey ends a rotated-out key's grace period early, once
+// the receiver has switched to the new secret. The active key can only be
+// replaced by rotating.
+func retireWebhookSigningKey(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	webhookID := c.Param("webhook_id")
+	keyID := c.Param("key_id")
+	if _, ok := ownedWebhook(c, webhookID, ownerID, serverName); !ok {
+		return
+	}
+	status := ""
+	webhook, err := updateWebhook(c.Request.Context(), webhookID, func(webhook *models.PublisherWebhook) error {
+		status = ""
+		keys := []models.WebhookSigningKey{}
+		for _, key := range webhook.SigningKeys {
+			if key.ID == keyID {
+				status = key.Status
+				if status != "active" {
+					continue
+				}
+			}
+			keys = append(keys, key)
+		}
+		webhook.SigningKeys = keys
+		return nil
+	})
+	if err != nil {
+		respondError(c, internalError("Failed to retire signing key", err))
+		return
+	}
+
+	switch {
+	case webhook == nil:
+		respondError(c, newAPIError(http.StatusNotFound, "Webhook '"+webhookID+"' not found"))
+		return
+	case status == "":
+		respondError(c, newAPIError(http.StatusNotFound, "Signing key '"+keyID+"' not found"))
+		return
+	case status == "active":
+		respondError(c, newAPIError(http.StatusConflict, "Signing key '"+keyID+"' is active; rotate the secret to replace it"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Signing key retired",
+	})
+}
+
+// listWebhookSigningKeys describes how deliveries are signed and lists the
+// keys currently signing the caller's webhooks, without their secrets.
+func listWebhookSigningKeys(c *gin.Context) {
+	userID, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	now := models.Now()
+
+	webhooks, err := ownerWebhooks(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, internalError("Failed to list signing keys", err))
+		return
+	}
+	keys := []models.WebhookSigningKey{}
+	for _, webhook := range webhooks {
+		for _, key := range webhook.SigningKeys {
+			if key.ExpiresAt.IsZero() || key.ExpiresAt.After(now) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].WebhookID != keys[j].WebhookID {
+			return keys[i].WebhookID < keys[j].WebhookID
+		}
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"scheme": gin.H{
+			"header":                 "X-SuperBox-Signature",
+			"format":                 "t=<unix>,v1=<hex>[,v1=<hex>...]",
+			"algorithm":              "HMAC-SHA256",
+			"signed_payload":         "<t>.<body>",
+			"tolerance_seconds":      int(webhookSignatureTolerance.Seconds()),
+			"rotation_grace_seconds": int(webhookKeyGracePeriod.Seconds()),
+		},
+		"keys": keys,
+	})
+}
+
+func listWebhookDeliveries(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	webhookID := c.Param("webhook_id")
+	if _, ok := ownedWebhook(c, webhookID, ownerID, serverName); !ok {
+		return
+	}
+	result, err := webhookDeliveries.listGroup(c.Request.Context(), webhookID)
+	if err != nil {
+		respondError(c, internalError("Failed to list deliveries", err))
+		return
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"deliveries": result,
+	})
+}
+
+func replayWebhookDelivery(c *gin.Context) {
+	ownerID, serverName, ok := webhookOwner(c)
+	if !ok {
+		return
+	}
+
+	webhookID := c.Param("webhook_id")
+	deliveryID := c.Param("delivery_id")
+	ctx := c.Request.Context()
+	webhook, err := getWebhook(ctx, webhookID)
+	if err != nil {
+		respondError(c, internalError("Failed to load webhook", err))
+		return
+	}
+	delivery, err := getDelivery(ctx, deliveryID)
+	if err != nil {
+		respondError(c, internalError("Failed to load delivery", err))
+		return
+	}
+	if webhook == nil || !ownsWebhook(webhook, requestTenant(c).ID, ownerID, serverName) || delivery == nil || delivery.WebhookID != webhookID {
+		respondError(c, newAPIError(http.StatusNotFound, "Delivery '"+deliveryID+"' not found"))
+		return
+	}
+
+	replay, ok := replayOrRespond(c, deliveryID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":   "success",
+		"delivery": replay,
+	})
+}
+
+func adminListWebhookDeliveries(c *gin.Context) {
+	status := c.Query("status")
+
+	deliveries, err := webhookDeliveries.list(c.Request.Context())
+	if err != nil {
+		respondError(c, internalError("Failed to list deliveries", err))
+		return
+	}
+	result := []models.WebhookDelivery{}
+	for _, delivery := range deliveries {
+		if status == "" || delivery.Status == status {
+			result = append(result, delivery)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"deliveries": result,
+	})
+}
+
+func adminReplayWebhookDelivery(c *gin.Context) {
+	replay, ok := replayOrRespond(c, c.Param("delivery_id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":   "success",
+		"delivery": replay,
+	})
+}
+
+func replayOrRespond(c *gin.Context, deliveryID string) (*models.WebhookDelivery, bool) {
+	replay, err := replayDelivery(c.Request.Context(), deliveryID)
+	var gone *deliveryGoneError
+	if errors.As(err, &gone) {
+		respondError(c, newAPIError(http.StatusNotFound, gone.Error()))
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, internalError("Failed to replay delivery", err))
+		return nil, false
+	}
+	return replay, true
+}
@@ -1,5 +1,6 @@
 import sys
 import json
+import platform
 from pathlib import Path
 
 sys.path.insert(0, str(Path(__file__).parent.parent.parent))
@@ -7,31 +8,156 @@ sys.path.insert(0, str(Path(__file__).parent.parent.parent))
 from superbox.shared.s3 import (
     get_server,
     list_servers,
+    list_server_names,
     upsert_server,
     delete_server,
+    head_bucket,
+    put_object,
+    presign_url,
+    presign_upload,
+    create_multipart_upload,
+    presign_upload_part,
+    list_parts,
+    complete_multipart_upload,
+    abort_multipart_upload,
+    head_object,
+    delete_object,
+    set_storage_class,
+    create_invalidation,
+    get_invalidation,
+    get_state,
+    put_state,
+    delete_state,
+    describe_state_table,
+    using_region,
 )
 
+
+def dispatch(function, args):
+    with using_region(args.get("region")):
+        return _dispatch(function, args)
+
+
+def _dispatch(function, args):
+    prefix = args.get("prefix", "")
+
+    if function == "ping":
+        return {"data": "pong", "python": platform.python_version()}
+    if function == "get_server":
+        return {"data": get_server(args["bucket_name"], args["server_name"], prefix)}
+    if function == "list_servers":
+        return {"data": list_servers(args["bucket_name"], prefix)}
+    if function == "list_server_names":
+        return {"data": list_server_names(args["bucket_name"], prefix)}
+    if function == "upsert_server":
+        result = upsert_server(
+            args["bucket_name"], args["server_name"], args["server_data"], prefix
+        )
+        return {"success": result}
+    if function == "delete_server":
+        return {"success": delete_server(args["bucket_name"], args["server_name"], prefix)}
+    if function == "put_object":
+        result = put_object(args["bucket_name"], args["key"], args["body"], args["content_type"])
+        return {"success": result}
+    if function == "head_bucket":
+        return {"success": head_bucket(args["bucket_name"])}
+    if function == "presign_url":
+        return {"data": presign_url(args["bucket_name"], args["key"], args.get("expires_in", 3600))}
+    if function == "presign_upload":
+        return {
+            "data": presign_upload(
+                args["bucket_name"],
+                args["key"],
+                args["content_type"],
+                args["max_bytes"],
+                args.get("expires_in", 900),
+            )
+        }
+    if function == "create_multipart_upload":
+        return {
+            "data": create_multipart_upload(args["bucket_name"], args["key"], args["content_type"])
+        }
+    if function == "presign_upload_part":
+        return {
+            "data": presign_upload_part(
+                args["bucket_name"],
+                args["key"],
+                args["upload_id"],
+                args["part_number"],
+                args.get("expires_in", 900),
+            )
+        }
+    if function == "list_parts":
+        return {"data": list_parts(args["bucket_name"], args["key"], args["upload_id"])}
+    if function == "complete_multipart_upload":
+        result = complete_multipart_upload(
+            args["bucket_name"], args["key"], args["upload_id"], args["parts"]
+        )
+        return {"success": result}
+    if function == "abort_multipart_upload":
+        result = abort_multipart_upload(args["bucket_name"], args["key"], args["upload_id"])
+        return {"success": result}
+    if function == "head_object":
+        return {"data": head_object(args["bucket_name"], args["key"])}
+    if function == "delete_object":
+        return {"success": delete_object(args["bucket_name"], args["key"])}
+    if function == "set_storage_class":
+        return {"success": set_storage_class(args["bucket_name"], args["key"], args["storage_class"])}
+    if function == "create_invalidation":
+        result = create_invalidation(
+            args["distribution_id"], args["paths"], args["caller_reference"]
+        )
+        return {"data": result}
+    if function == "get_invalidation":
+        return {"data": get_invalidation(args["distribution_id"], args["invalidation_id"])}
+    if function == "get_state":
+        return {"data": get_state(args["table_name"], args["key"])}
+    if function == "put_state":
+        result = put_state(
+            args["table_name"],
+            args["key"],
+            args["value"],
+            args["expires_at"],
+            args["version"],
+            args.get("condition", ""),
+            args.get("expected", ""),
+            args.get("now", 0),
+        )
+        return {"success": result}
+    if function == "delete_state":
+        return {"success": delete_state(args["table_name"], args["keys"])}
+    if function == "describe_state_table":
+        return {"data": describe_state_table(args["table_name"])}
+    return {"error": f"Unknown function: {function}"}
+
+
+def serve():
+    """Answer newline-delimited JSON-RPC 2.0 requests on stdin until it closes."""
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        request_id = None
+        try:
+            request = json.loads(line)
+            request_id = request.get("id")
+            result = dispatch(request["method"], request.get("params") or {})
+            response = {"jsonrpc": "2.0", "id": request_id, "result": result}
+        except Exception as e:
+            error = {"code": -32000, "message": str(e)}
+            response = {"jsonrpc": "2.0", "id": request_id, "error": error}
+        sys.stdout.write(json.dumps(response) + "\n")
+        sys.stdout.flush()
+
+
 if __name__ == "__main__":
-    try:
-        input_data = json.loads(sys.argv[1])
-        function = input_data["function"]
-        args = input_data["args"]
-
-        if function == "get_server":
-            result = get_server(args["bucket_name"], args["server_name"])
-            output = {"data": result}
-        elif function == "list_servers":
-            result = list_servers(args["bucket_name"])
-            output = {"data": result}
-        elif function == "upsert_server":
-            result = upsert_server(args["bucket_name"], args["server_name"], args["server_data"])
-            output = {"success": result}
-        elif function == "delete_server":
-            result = delete_server(args["bucket_name"], args["server_name"])
-            output = {"success": result}
-        else:
-            output = {"error": f"Unknown function: {function}"}
+    if len(sys.argv) > 1 and sys.argv[1] == "--serve":
+        serve()
+        sys.exit(0)
 
+    try:
+        raw = sys.argv[1] if len(sys.argv) > 1 else sys.stdin.read()
+        input_data = json.loads(raw)
+        output = dispatch(input_data["function"], input_data["args"])
         print(json.dumps(output))
     except Exception as e:
         print(json.dumps({"error": str(e)}))
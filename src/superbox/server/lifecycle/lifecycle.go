@@ -0,0 +1,82 @@
+// Package lifecycle starts the parts of a process in order and stops them in
+// reverse, so a component is never stopped while something started after it,
+// and possibly depending on it, is still running.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// Component is one part of the process. Start must return once the component
+// is running; long-running work belongs in goroutines it owns, which report
+// unexpected exits through Manager.Fail. Either function may be nil.
+type Component struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+type Manager struct {
+	components []Component
+	started    int
+	failures   chan error
+}
+
+func New() *Manager {
+	return &Manager{failures: make(chan error, 1)}
+}
+
+// Add appends a component. Components start in the order they are added.
+func (m *Manager) Add(component Component) {
+	m.components = append(m.components, component)
+}
+
+// Start starts every component in order. If one fails, those already started
+// are stopped again before the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	for _, component := range m.components {
+		if component.Start != nil {
+			if err := component.Start(ctx); err != nil {
+				m.Stop(ctx)
+				return fmt.Errorf("failed to start %s: %w", component.Name, err)
+			}
+		}
+		m.started++
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order, all within ctx. A
+// component that fails to stop is logged and the rest are still stopped; the
+// failures are returned together.
+func (m *Manager) Stop(ctx context.Context) error {
+	var errs []error
+	for ; m.started > 0; m.started-- {
+		component := m.components[m.started-1]
+		if component.Stop == nil {
+			continue
+		}
+		if err := component.Stop(ctx); err != nil {
+			slog.Error("failed to stop component", "component", component.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", component.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fail reports that a component stopped on its own, such as a listener that
+// could not bind. Only the first failure is kept.
+func (m *Manager) Fail(name string, err error) {
+	select {
+	case m.failures <- fmt.Errorf("%s: %w", name, err):
+	default:
+	}
+}
+
+// Failed delivers the first failure reported through Fail.
+func (m *Manager) Failed() <-chan error {
+	return m.failures
+}
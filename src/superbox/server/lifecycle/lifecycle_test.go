@@ -0,0 +1,60 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func recorder(events *[]string, name string, startErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(context.Context) error {
+			*events = append(*events, "start "+name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManagerStopsInReverseOrder(t *testing.T) {
+	var events []string
+	manager := New()
+	manager.Add(recorder(&events, "store", nil))
+	manager.Add(recorder(&events, "jobs", nil))
+	manager.Add(recorder(&events, "http", nil))
+
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if err := manager.Stop(context.Background()); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	// A second Stop has nothing left to stop.
+	manager.Stop(context.Background())
+
+	want := []string{"start store", "start jobs", "start http", "stop http", "stop jobs", "stop store"}
+	if !slices.Equal(events, want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+}
+
+func TestManagerUnwindsFailedStart(t *testing.T) {
+	var events []string
+	manager := New()
+	manager.Add(recorder(&events, "store", nil))
+	manager.Add(recorder(&events, "jobs", errors.New("boom")))
+	manager.Add(recorder(&events, "http", nil))
+
+	if err := manager.Start(context.Background()); err == nil {
+		t.Fatal("start succeeded despite a failing component")
+	}
+	want := []string{"start store", "start jobs", "stop store"}
+	if !slices.Equal(events, want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+}
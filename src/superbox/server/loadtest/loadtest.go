@@ -0,0 +1,252 @@
+// Package loadtest drives a running server at a fixed request rate, one
+// scenario at a time, and checks the latencies it sees against a budget.
+// Requests are sent open-loop like vegeta: a slow server does not slow the
+// attack down, so queueing shows up in the tail latencies.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed budget.json
+var DefaultBudget []byte
+
+// Options configures a run.
+type Options struct {
+	BaseURL  string
+	Rate     int
+	Duration time.Duration
+
+	// ServerName is an existing server for the get scenario, and a paid one
+	// for create-order.
+	ServerName string
+	// Plan is the plan create-order buys, for servers that define plans.
+	Plan string
+	// Token is a Firebase ID token, needed only by create-order.
+	Token string
+}
+
+// Budget is the latency and error allowance for one scenario.
+type Budget struct {
+	P95MS        float64 `json:"p95_ms"`
+	P99MS        float64 `json:"p99_ms"`
+	MaxErrorRate float64 `json:"max_error_rate"`
+}
+
+// Result summarises one scenario. Any non-2xx response or transport failure
+// counts as an error.
+type Result struct {
+	Scenario string
+	Requests int
+	Errors   int
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+func (r Result) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// Violations lists how the result breaks its budget, if at all.
+func (r Result) Violations(budget Budget) []string {
+	var problems []string
+	if budget.P95MS > 0 && ms(r.P95) > budget.P95MS {
+		problems = append(problems, fmt.Sprintf("p95 %.1fms exceeds %.1fms", ms(r.P95), budget.P95MS))
+	}
+	if budget.P99MS > 0 && ms(r.P99) > budget.P99MS {
+		problems = append(problems, fmt.Sprintf("p99 %.1fms exceeds %.1fms", ms(r.P99), budget.P99MS))
+	}
+	if r.ErrorRate() > budget.MaxErrorRate {
+		problems = append(problems, fmt.Sprintf("error rate %.2f%% exceeds %.2f%%", r.ErrorRate()*100, budget.MaxErrorRate*100))
+	}
+	return problems
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// request is one call a scenario makes, built fresh for every hit.
+type request struct {
+	method string
+	path   string
+	token  string
+	body   interface{}
+}
+
+// scenario returns a function producing the scenario's request. Scenarios
+// that need state, such as a device code, set it up once here.
+type scenario func(ctx context.Context, client *http.Client, opts Options) (func() request, error)
+
+var scenarios = map[string]scenario{
+	"list": func(context.Context, *http.Client, Options) (func() request, error) {
+		return func() request { return request{method: http.MethodGet, path: "/api/v1/servers"} }, nil
+	},
+	"search": func(context.Context, *http.Client, Options) (func() request, error) {
+		return func() request { return request{method: http.MethodGet, path: "/api/v2/servers?limit=20"} }, nil
+	},
+	"get": func(_ context.Context, _ *http.Client, opts Options) (func() request, error) {
+		if opts.ServerName == "" {
+			return nil, fmt.Errorf("the get scenario needs a server name")
+		}
+		return func() request { return request{method: http.MethodGet, path: "/api/v1/servers/" + opts.ServerName} }, nil
+	},
+	"device-poll": func(ctx context.Context, client *http.Client, opts Options) (func() request, error) {
+		var started struct {
+			DeviceCode string `json:"device_code"`
+		}
+		start := request{method: http.MethodPost, path: "/api/v1/auth/device/start", body: map[string]string{"provider": "google", "client_id": "superbox-cli"}}
+		if err := send(ctx, client, opts.BaseURL, start, &started); err != nil {
+			return nil, fmt.Errorf("failed to start a device session: %w", err)
+		}
+		body := map[string]string{"device_code": started.DeviceCode}
+		return func() request { return request{method: http.MethodPost, path: "/api/v1/auth/device/poll", body: body} }, nil
+	},
+	"create-order": func(_ context.Context, _ *http.Client, opts Options) (func() request, error) {
+		if opts.ServerName == "" || opts.Token == "" {
+			return nil, fmt.Errorf("the create-order scenario needs a paid server name and a token")
+		}
+		body := map[string]string{"server_name": opts.ServerName}
+		if opts.Plan != "" {
+			body["plan"] = opts.Plan
+		}
+		return func() request {
+			return request{method: http.MethodPost, path: "/api/v1/payment/create-order", token: opts.Token, body: body}
+		}, nil
+	},
+}
+
+// Scenarios lists the scenarios Run knows, in their default order.
+func Scenarios() []string {
+	return []string{"list", "search", "get", "device-poll", "create-order"}
+}
+
+// Run attacks each named scenario in turn for opts.Duration at opts.Rate
+// requests per second.
+func Run(ctx context.Context, client *http.Client, opts Options, names []string) ([]Result, error) {
+	if opts.Rate <= 0 || opts.Duration <= 0 {
+		return nil, fmt.Errorf("rate and duration must be positive")
+	}
+	results := make([]Result, 0, len(names))
+	for _, name := range names {
+		setup, ok := scenarios[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown scenario %q (want one of %s)", name, strings.Join(Scenarios(), ", "))
+		}
+		next, err := setup(ctx, client, opts)
+		if err != nil {
+			return nil, err
+		}
+		result := attack(ctx, client, opts, next)
+		result.Scenario = name
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func attack(ctx context.Context, client *http.Client, opts Options, next func() request) Result {
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Second / time.Duration(opts.Rate))
+	defer ticker.Stop()
+
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		latencies []time.Duration
+		failures  int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return summarise(latencies, failures)
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				started := time.Now()
+				// The attack's own deadline must not cut off requests already
+				// in flight, or the slowest ones would go unrecorded.
+				err := send(context.WithoutCancel(ctx), client, opts.BaseURL, next(), nil)
+				elapsed := time.Since(started)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					failures++
+				}
+			}()
+		}
+	}
+}
+
+func summarise(latencies []time.Duration, failures int) Result {
+	result := Result{Requests: len(latencies), Errors: failures}
+	if len(latencies) == 0 {
+		return result
+	}
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	result.P50 = percentile(0.50)
+	result.P95 = percentile(0.95)
+	result.P99 = percentile(0.99)
+	result.Max = latencies[len(latencies)-1]
+	return result
+}
+
+// send performs one request, decoding a 2xx JSON body into out when it is
+// not nil.
+func send(ctx context.Context, client *http.Client, baseURL string, r request, out interface{}) error {
+	var body io.Reader
+	if r.body != nil {
+		data, err := json.Marshal(r.body)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, strings.TrimSuffix(baseURL, "/")+r.path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s %s returned %d", r.method, r.path, resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -1,43 +1,332 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/acme/autocert"
 
+	"superbox/server/config"
+	"superbox/server/events"
 	"superbox/server/handlers"
+	"superbox/server/lifecycle"
+	"superbox/server/store"
 )
 
+var processEnv = make(map[string]bool)
+
 func main() {
+	slog.SetDefault(handlers.NewLogger())
+
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		processEnv[key] = true
+	}
+
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+		slog.Info("no .env file found, using environment variables")
 	}
 
-	router := gin.Default()
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// reloadEnvFile applies .env edits on reload. Variables that came from the
+// process environment at startup still take precedence, as they do at boot.
+func reloadEnvFile() {
+	values, err := godotenv.Read()
+	if err != nil {
+		return
+	}
+	for key, value := range values {
+		if !processEnv[key] {
+			os.Setenv(key, value)
+		}
+	}
+}
 
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"*"}
-	config.AllowCredentials = true
-	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"*"}
-	router.Use(cors.New(config))
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, stateStore, ok := bootstrap()
+	if !ok {
+		return 1
+	}
+	if cfg.SentryDSN != "" {
+		reporter, err := handlers.NewSentryReporter(cfg.SentryDSN, cfg.SentryEnvironment)
+		if err != nil {
+			slog.Error("failed to configure error reporting", "error", err)
+			return 1
+		}
+		handlers.SetErrorReporter(reporter, cfg.ErrorSampleRate)
+	}
+	if cfg.SMTPURL != "" {
+		mailer, err := handlers.NewSMTPMailer(cfg.SMTPURL, cfg.MailFrom)
+		if err != nil {
+			slog.Error("failed to configure email", "error", err)
+			return 1
+		}
+		handlers.SetMailer(mailer)
+	}
+	if cfg.EventBus != "memory" {
+		bus, err := events.New(cfg.EventBus, cfg.RedisURL)
+		if err != nil {
+			slog.Error("failed to configure the event bus", "error", err)
+			return 1
+		}
+		handlers.SetEventBus(bus)
+	}
+	if cfg.SessionStoreURL != "" {
+		sessions, err := store.NewDurable(context.Background(), cfg.SessionStoreURL, handlers.CallS3Helper)
+		if err != nil {
+			slog.Error("failed to open the session store", "error", err)
+			return 1
+		}
+		handlers.SetSessionStore(sessions)
+		if cfg.RecordStoreURL == cfg.SessionStoreURL {
+			handlers.SetRecordStore(sessions)
+		}
+	}
+	if cfg.RecordStoreURL != "" && cfg.RecordStoreURL != cfg.SessionStoreURL {
+		records, err := store.NewDurable(context.Background(), cfg.RecordStoreURL, handlers.CallS3Helper)
+		if err != nil {
+			slog.Error("failed to open the record store", "error", err)
+			return 1
+		}
+		handlers.SetRecordStore(records)
+	}
+	if !cfg.SkipStartupChecks {
+		if problems := handlers.CheckDependencies(context.Background()); len(problems) > 0 {
+			slog.Error("configured dependencies are unreachable", "problems", problems)
+			return 1
+		}
+	}
+	if loaded, err := handlers.LoadSeededOrders(context.Background()); err != nil {
+		slog.Warn("failed to load seeded sandbox orders", "error", err)
+	} else if loaded > 0 {
+		slog.Info("loaded seeded sandbox orders", "orders", loaded)
+	}
 
-	api := router.Group("/api/v1")
+	router := gin.New()
+	// ClientIP, which rate limits and the audit trail key on, only reads
+	// X-Forwarded-For from TRUSTED_PROXIES; other peers are taken as is.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		slog.Error("invalid TRUSTED_PROXIES", "error", err)
+		return 1
+	}
+	router.Use(handlers.RequestLogger(), handlers.Audit(), handlers.ErrorHandler(), handlers.Recovery(), handlers.HSTS(), handlers.BodyLimit(), handlers.Compression())
+	router.Use(handlers.Deadline(), handlers.CORS(), handlers.Tenancy(), handlers.Authorize(), handlers.Idempotency())
+
+	api := router.Group("/api/v1", handlers.APIVersion(1))
 	handlers.RegisterAuth(api)
 	handlers.RegisterServers(api)
 	handlers.RegisterPayment(api)
+	handlers.RegisterAdmin(api)
+	handlers.RegisterPublisher(api)
+	handlers.RegisterBlobs(api)
+	handlers.RegisterMe(api)
+	handlers.RegisterTenant(api)
+	handlers.RegisterWebhooks(api)
+	handlers.RegisterAdvisories(api)
+	handlers.RegisterOperations(api)
+	handlers.RegisterCompatibility(api)
+
+	v2 := router.Group("/api/v2", handlers.APIVersion(2))
+	handlers.RegisterV2(v2)
+	handlers.RegisterCompatibility(v2)
 
 	handlers.RegisterHealth(router)
+	handlers.RegisterDashboard(router)
+	handlers.RegisterDocs(router)
+
+	components := newLifecycle(cfg, stateStore, handlers.NegotiateVersion(router))
+	if err := components.Start(context.Background()); err != nil {
+		slog.Error("failed to start server", "error", err)
+		return 1
+	}
+
+	handlers.SetConfigSource(func() (*config.Config, error) {
+		reloadEnvFile()
+		return config.Load()
+	})
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			changed, restartRequired, err := handlers.ReloadConfig()
+			if err != nil {
+				slog.Error("configuration reload rejected; keeping running settings", "error", err)
+				continue
+			}
+			slog.Info("configuration reloaded", "changed", changed, "restart_required", restartRequired)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	exitCode := 0
+	select {
+	case err := <-components.Failed():
+		slog.Error("server stopped unexpectedly", "error", err)
+		exitCode = 1
+	case sig := <-signals:
+		slog.Info("shutdown requested", "signal", sig.String(), "timeout", cfg.ShutdownTimeout.String())
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := components.Stop(ctx); err != nil {
+		exitCode = 1
 	}
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	slog.Info("server stopped")
+	return exitCode
+}
+
+// newLifecycle assembles the server's components. They stop in reverse, so
+// the listeners drain before the schedulers and job workers stop, those stop
+// before the python workers they call, and the event bus and the state and
+// session stores close last.
+func newLifecycle(cfg *config.Config, stateStore store.StateStore, handler http.Handler) *lifecycle.Manager {
+	components := lifecycle.New()
+	components.Add(lifecycle.Component{
+		Name: "state store",
+		Stop: func(context.Context) error { return stateStore.Close() },
+	})
+	components.Add(lifecycle.Component{
+		Name: "session store",
+		Stop: func(context.Context) error { return handlers.CloseSessionStore() },
+	})
+	components.Add(lifecycle.Component{
+		Name: "record store",
+		Stop: func(context.Context) error { return handlers.CloseRecordStore() },
+	})
+	components.Add(lifecycle.Component{
+		Name: "event bus",
+		Stop: func(context.Context) error { return handlers.CloseEventBus() },
+	})
+	components.Add(lifecycle.Component{
+		Name: "audit log",
+		Start: func(context.Context) error {
+			if cfg.AuditLogFile == "" {
+				return nil
+			}
+			return handlers.OpenAuditLog(cfg.AuditLogFile)
+		},
+		Stop: func(context.Context) error { return handlers.CloseAuditLog() },
+	})
+	components.Add(lifecycle.Component{
+		Name: "error reporter",
+		Stop: handlers.FlushErrorReports,
+	})
+	components.Add(lifecycle.Component{
+		Name: "python workers",
+		Stop: func(context.Context) error {
+			handlers.StopPythonWorkers()
+			return nil
+		},
+	})
+	components.Add(lifecycle.Component{
+		Name:  "cache refreshers",
+		Start: func(context.Context) error { handlers.StartRefreshers(); return nil },
+		Stop:  handlers.StopRefreshers,
+	})
+	components.Add(lifecycle.Component{
+		Name:  "job workers",
+		Start: func(context.Context) error { handlers.StartJobs(); return nil },
+		Stop:  handlers.StopJobs,
+	})
+	components.Add(lifecycle.Component{
+		Name:  "schedulers",
+		Start: func(context.Context) error { handlers.StartSchedulers(); return nil },
+		Stop:  handlers.StopSchedulers,
+	})
+	addHTTPServers(components, cfg, handler)
+	return components
+}
+
+func addHTTPServers(components *lifecycle.Manager, cfg *config.Config, handler http.Handler) {
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	var redirectServer *http.Server
+	if cfg.HTTPRedirectPort != "" {
+		redirectServer = &http.Server{
+			Addr:              ":" + cfg.HTTPRedirectPort,
+			Handler:           handlers.HTTPSRedirect(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	if len(cfg.TLSAutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		if redirectServer != nil {
+			redirectServer.Handler = manager.HTTPHandler(redirectServer.Handler)
+		}
+	}
+
+	if redirectServer != nil {
+		components.Add(lifecycle.Component{
+			Name: "http redirect server",
+			Start: func(context.Context) error {
+				go func() {
+					slog.Info("redirecting http to https", "port", cfg.HTTPRedirectPort)
+					if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+						components.Fail("http redirect server", err)
+					}
+				}()
+				return nil
+			},
+			Stop: redirectServer.Shutdown,
+		})
 	}
+	components.Add(lifecycle.Component{
+		Name: "http server",
+		Start: func(context.Context) error {
+			go func() {
+				var err error
+				switch {
+				case cfg.TLSCertFile != "":
+					slog.Info("server starting", "port", cfg.Port, "tls", "certificate")
+					err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+				case server.TLSConfig != nil:
+					slog.Info("server starting", "port", cfg.Port, "tls", "autocert", "domains", cfg.TLSAutocertDomains)
+					err = server.ListenAndServeTLS("", "")
+				default:
+					slog.Info("server starting", "port", cfg.Port)
+					err = server.ListenAndServe()
+				}
+				if !errors.Is(err, http.ErrServerClosed) {
+					components.Fail("http server", err)
+				}
+			}()
+			return nil
+		},
+		// Draining first fails readiness and releases held device polls, so
+		// Shutdown is not left waiting on them.
+		Stop: func(ctx context.Context) error {
+			handlers.SetDraining()
+			return server.Shutdown(ctx)
+		},
+	})
 }
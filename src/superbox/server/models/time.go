@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Timestamp puts t in the one form every time.Time in these models takes:
+// UTC, truncated to whole seconds. It marshals as RFC 3339 with a Z suffix
+// and compares equal to the same instant read back from JSON or from an
+// HTTP date such as If-Unmodified-Since.
+func Timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// Now is Timestamp(time.Now()).
+func Now() time.Time {
+	return Timestamp(time.Now())
+}
+
+// Canonicalize puts the server's times in Timestamp form. Records written
+// before it, by the Python helper, carry microseconds and a +00:00 offset.
+func (s *Server) Canonicalize() {
+	s.Meta.CreatedAt = Timestamp(s.Meta.CreatedAt)
+	s.Meta.UpdatedAt = Timestamp(s.Meta.UpdatedAt)
+	for i := range s.Versions {
+		s.Versions[i].PublishedAt = Timestamp(s.Versions[i].PublishedAt)
+	}
+}
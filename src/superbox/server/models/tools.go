@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// UnmarshalJSON reads the current list of definitions as well as the shapes
+// older records and clients use: a list of tool names (CLI push), a
+// {"count", "names"} summary (seed data), and an object keyed by tool name.
+func (t *Tools) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	tools := Tools{}
+	switch value := raw.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []interface{}:
+		for _, item := range value {
+			switch entry := item.(type) {
+			case string:
+				tools = append(tools, ToolDefinition{Name: entry})
+			case map[string]interface{}:
+				tool, err := toolDefinition(entry)
+				if err != nil {
+					return err
+				}
+				tools = append(tools, tool)
+			default:
+				return fmt.Errorf("tools: unsupported entry %v", item)
+			}
+		}
+	case map[string]interface{}:
+		if names, ok := value["names"].([]interface{}); ok {
+			for _, name := range names {
+				if name, ok := name.(string); ok {
+					tools = append(tools, ToolDefinition{Name: name})
+				}
+			}
+			break
+		}
+		for name, entry := range value {
+			tool := ToolDefinition{}
+			if fields, ok := entry.(map[string]interface{}); ok {
+				var err error
+				if tool, err = toolDefinition(fields); err != nil {
+					return err
+				}
+			}
+			tool.Name = name
+			tools = append(tools, tool)
+		}
+	default:
+		return fmt.Errorf("tools must be a list or an object")
+	}
+
+	sort.SliceStable(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	*t = tools
+	return nil
+}
+
+func toolDefinition(fields map[string]interface{}) (ToolDefinition, error) {
+	raw, _ := json.Marshal(fields)
+	var tool ToolDefinition
+	if err := json.Unmarshal(raw, &tool); err != nil {
+		return ToolDefinition{}, fmt.Errorf("tools: %w", err)
+	}
+	return tool, nil
+}
@@ -1,38 +1,47 @@
 package models
 
+import "time"
+
 // Authentication Request Types
 type AuthDeviceStartRequest struct {
-	Provider string `json:"provider"`
+	Provider string `json:"provider" binding:"required"`
+	// ClientID names the registered tool starting the login. Scope is a
+	// space-separated subset of the client's scopes, all of them when empty.
+	ClientID string `json:"client_id" binding:"required"`
+	Scope    string `json:"scope,omitempty"`
 }
 
 type AuthDevicePollRequest struct {
-	DeviceCode string `json:"device_code"`
+	DeviceCode string `json:"device_code" binding:"required"`
+	// Wait is how many seconds to hold the request while the login is still
+	// pending. Zero answers straight away.
+	Wait int `json:"wait,omitempty" binding:"omitempty,min=0"`
 }
 
 type AuthRegisterRequest struct {
-	Email       string  `json:"email"`
-	Password    string  `json:"password"`
-	DisplayName *string `json:"display_name,omitempty"`
+	Email       string  `json:"email" binding:"required,email"`
+	Password    string  `json:"password" binding:"required,password,max=128"`
+	DisplayName *string `json:"display_name,omitempty" binding:"omitempty,min=1,max=100"`
 }
 
 type AuthLoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
 }
 
 type AuthProviderRequest struct {
-	Provider    string  `json:"provider"`
+	Provider    string  `json:"provider" binding:"required"`
 	IDToken     *string `json:"id_token,omitempty"`
 	AccessToken *string `json:"access_token,omitempty"`
 }
 
 type AuthRefreshRequest struct {
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 type AuthUpdateRequest struct {
-	DisplayName *string `json:"display_name,omitempty"`
-	Password    *string `json:"password,omitempty"`
+	DisplayName *string `json:"display_name,omitempty" binding:"omitempty,min=1,max=100"`
+	Password    *string `json:"password,omitempty" binding:"omitempty,password,max=128"`
 }
 
 // Authentication Response Types
@@ -52,87 +61,294 @@ type AuthUserProfile struct {
 	Disabled      bool    `json:"disabled"`
 }
 
+// TokenExchangeRequest is an OAuth 2.0 token exchange (RFC 8693) by a
+// partner acting for a user. It is accepted as JSON or as a form, and the
+// client credentials may be sent with HTTP Basic instead.
+type TokenExchangeRequest struct {
+	GrantType        string `json:"grant_type" form:"grant_type" binding:"required"`
+	SubjectToken     string `json:"subject_token" form:"subject_token" binding:"required"`
+	SubjectTokenType string `json:"subject_token_type,omitempty" form:"subject_token_type"`
+	// Scope is a space-separated list; empty asks for every scope the user
+	// consented to.
+	Scope        string `json:"scope,omitempty" form:"scope"`
+	ClientID     string `json:"client_id,omitempty" form:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty" form:"client_secret"`
+}
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	Scope           string `json:"scope"`
+}
+
+type GrantConsentRequest struct {
+	ClientID string   `json:"client_id" binding:"required"`
+	Scopes   []string `json:"scopes" binding:"required,min=1"`
+}
+
+// PartnerConsent lets a partner exchange the user's token for one limited
+// to Scopes.
+type PartnerConsent struct {
+	ClientID    string    `json:"client_id"`
+	PartnerName string    `json:"partner_name"`
+	Scopes      []string  `json:"scopes"`
+	GrantedAt   time.Time `json:"granted_at"`
+}
+
+// RequestSigningKey lets a machine caller, such as a publisher's CI, sign
+// requests with HMAC instead of sending an ID token. Secret is only returned
+// when the key is created.
+type RequestSigningKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+}
+
+type CreateSigningKeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// APIKey is a long-lived credential for CI, sent as "Authorization: ApiKey
+// <key>". Only a hash of the key is stored, so Key is only returned when the
+// key is created; Prefix is kept to tell keys apart.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Key    string   `json:"key,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Servers limits the key to these servers; empty allows all of the
+	// owner's.
+	Servers    []string  `json:"servers,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+}
+
+// CreateAPIKeyRequest names a new key. Scopes default to read and publish,
+// and a key without ExpiresInDays does not expire.
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes,omitempty" binding:"omitempty,dive,oneof=read publish admin payments"`
+	Servers       []string `json:"servers,omitempty" binding:"omitempty,max=20,dive,required,max=100"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=365"`
+}
+
+// DeviceClient is a tool registered to start device logins. A client with
+// the "full" scope, like the official CLI, receives the user's own tokens;
+// any other receives an hour-long token limited to the login's scopes.
+type DeviceClient struct {
+	ClientID  string    `json:"client_id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Official  bool      `json:"official,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+type RegisterDeviceClientRequest struct {
+	ClientID string   `json:"client_id" binding:"required"`
+	Name     string   `json:"name" binding:"required,max=100"`
+	Scopes   []string `json:"scopes" binding:"required,min=1"`
+}
+
 // Device Session Type
 type DeviceSession struct {
 	DeviceCode         string
 	UserCode           string
 	NormalizedUserCode string
 	Provider           string
+	ClientID           string
+	Scopes             []string
+	TenantID           string
 	State              string
 	Status             string
-	CreatedAt          float64
-	ExpiresAt          float64
-	CompletedAt        float64
+	CreatedAt          time.Time
+	ExpiresAt          time.Time
+	CompletedAt        time.Time
 	Tokens             map[string]interface{}
-	Error              string
-	LastTouched        float64
+	// SealedTokens holds Tokens encrypted while the session is in the state
+	// store; it is empty in memory.
+	SealedTokens string `json:",omitempty"`
+	Error        string
+	// LastTouched is when the client last polled while the login was
+	// pending.
+	LastTouched time.Time
+	// PollInterval is how many seconds the client must leave between polls;
+	// zero means the advertised default. Polling sooner raises it.
+	PollInterval int `json:",omitempty"`
 }
 
 // Server Types
 type Repository struct {
-	Type string `json:"type"`
-	URL  string `json:"url"`
+	Type string `json:"type" binding:"omitempty,oneof=git"`
+	URL  string `json:"url" binding:"omitempty,http_url"`
+}
+
+type PricingPlan struct {
+	Name     string   `json:"name" binding:"required,max=50"`
+	Amount   float64  `json:"amount" binding:"gte=0"`
+	Period   string   `json:"period" binding:"required,oneof=one_time monthly yearly"`
+	Features []string `json:"features,omitempty" binding:"omitempty,dive,max=200"`
 }
 
 type Pricing struct {
-	Currency string  `json:"currency"`
-	Amount   float64 `json:"amount"`
+	Currency      string        `json:"currency" binding:"omitempty,currency"`
+	Amount        float64       `json:"amount" binding:"gte=0"`
+	Mode          string        `json:"mode,omitempty" binding:"omitempty,oneof=fixed donation"`
+	MinimumAmount float64       `json:"minimum_amount,omitempty" binding:"gte=0"`
+	Plans         []PricingPlan `json:"plans,omitempty" binding:"omitempty,dive"`
+}
+
+// ToolDefinition describes one tool an MCP server exposes.
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+// Tools is always written as a list of definitions sorted by name. See
+// UnmarshalJSON for the older shapes it also reads.
+type Tools []ToolDefinition
+
+// Meta is maintained by the server; clients cannot set it.
+type Meta struct {
+	OwnerID   string    `json:"owner_id,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// Version records when a version of a server was published.
+type Version struct {
+	Version     string    `json:"version"`
+	PublishedAt time.Time `json:"published_at,omitzero"`
+	// BundleID is the bundle blob the version was published with.
+	BundleID string `json:"bundle_id,omitempty"`
+}
+
+// Server is the registry record as stored in <prefix><name>.json and
+// returned by the server endpoints. Name is the URL-safe slug used in
+// routes; DisplayName is what people read. PreviousNames are the slugs it
+// was renamed from, each of which redirects to Name.
+type Server struct {
+	Name           string                 `json:"name"`
+	DisplayName    string                 `json:"display_name"`
+	PreviousNames  []string               `json:"previous_names,omitempty"`
+	Version        string                 `json:"version"`
+	Description    string                 `json:"description"`
+	Author         string                 `json:"author"`
+	Lang           string                 `json:"lang"`
+	License        string                 `json:"license"`
+	Entrypoint     string                 `json:"entrypoint"`
+	Repository     Repository             `json:"repository"`
+	Pricing        Pricing                `json:"pricing"`
+	Tools          Tools                  `json:"tools,omitempty"`
+	SecurityReport map[string]interface{} `json:"security_report,omitempty"`
+	Versions       []Version              `json:"versions,omitempty"`
+	Meta           Meta                   `json:"meta"`
+}
+
+// ServerSummary is the listing view of a Server.
+type ServerSummary struct {
+	Name           string                 `json:"name"`
+	DisplayName    string                 `json:"display_name"`
+	Version        string                 `json:"version"`
+	Description    string                 `json:"description"`
+	Author         string                 `json:"author"`
+	Lang           string                 `json:"lang"`
+	License        string                 `json:"license"`
+	Entrypoint     string                 `json:"entrypoint"`
+	Repository     Repository             `json:"repository"`
+	Pricing        Pricing                `json:"pricing"`
+	Tools          Tools                  `json:"tools,omitempty"`
+	SecurityReport map[string]interface{} `json:"security_report,omitempty"`
 }
 
 type CreateServerRequest struct {
-	Name        string                  `json:"name"`
-	Version     string                  `json:"version"`
-	Description string                  `json:"description"`
-	Author      string                  `json:"author"`
-	Lang        string                  `json:"lang"`
-	License     string                  `json:"license"`
-	Entrypoint  string                  `json:"entrypoint"`
-	Repository  Repository              `json:"repository"`
-	Pricing     Pricing                 `json:"pricing"`
-	Tools       *map[string]interface{} `json:"tools,omitempty"`
+	Name        string     `json:"name" binding:"required,max=100"`
+	DisplayName string     `json:"display_name,omitempty" binding:"max=100"`
+	Version     string     `json:"version" binding:"required,semver"`
+	Description string     `json:"description" binding:"max=2000"`
+	Author      string     `json:"author" binding:"max=100"`
+	Lang        string     `json:"lang" binding:"max=50"`
+	License     string     `json:"license" binding:"max=100"`
+	Entrypoint  string     `json:"entrypoint" binding:"max=255"`
+	Repository  Repository `json:"repository"`
+	Pricing     Pricing    `json:"pricing"`
+	Tools       Tools      `json:"tools,omitempty"`
+	BundleID    string     `json:"bundle_id,omitempty" binding:"max=64"`
 }
 
 type UpdateServerRequest struct {
-	Name           *string                 `json:"name,omitempty"`
-	Version        *string                 `json:"version,omitempty"`
-	Description    *string                 `json:"description,omitempty"`
-	Author         *string                 `json:"author,omitempty"`
-	Lang           *string                 `json:"lang,omitempty"`
-	License        *string                 `json:"license,omitempty"`
-	Entrypoint     *string                 `json:"entrypoint,omitempty"`
+	Name           *string                 `json:"name,omitempty" binding:"omitempty,min=1,max=100"`
+	DisplayName    *string                 `json:"display_name,omitempty" binding:"omitempty,min=1,max=100"`
+	Version        *string                 `json:"version,omitempty" binding:"omitempty,semver"`
+	Description    *string                 `json:"description,omitempty" binding:"omitempty,max=2000"`
+	Author         *string                 `json:"author,omitempty" binding:"omitempty,max=100"`
+	Lang           *string                 `json:"lang,omitempty" binding:"omitempty,max=50"`
+	License        *string                 `json:"license,omitempty" binding:"omitempty,max=100"`
+	Entrypoint     *string                 `json:"entrypoint,omitempty" binding:"omitempty,max=255"`
 	Repository     *Repository             `json:"repository,omitempty"`
 	Pricing        *Pricing                `json:"pricing,omitempty"`
-	Tools          *map[string]interface{} `json:"tools,omitempty"`
+	Tools          *Tools                  `json:"tools,omitempty"`
 	SecurityReport *map[string]interface{} `json:"security_report,omitempty"`
+	// BundleID attaches a bundle to the version the update leaves current.
+	BundleID *string `json:"bundle_id,omitempty" binding:"omitempty,min=1,max=64"`
 }
 
 type ServerResponse struct {
-	Status  string        `json:"status"`
-	Message string        `json:"message,omitempty"`
-	Server  interface{}   `json:"server,omitempty"`
-	Total   int           `json:"total,omitempty"`
-	Servers []interface{} `json:"servers,omitempty"`
+	Status        string          `json:"status"`
+	Message       string          `json:"message,omitempty"`
+	Server        *Server         `json:"server,omitempty"`
+	Total         int             `json:"total,omitempty"`
+	Servers       []ServerSummary `json:"servers,omitempty"`
+	PricingReview *PriceChange    `json:"pricing_review,omitempty"`
+	Moderation    *ModerationHold `json:"moderation,omitempty"`
+}
+
+// LintIssue is one problem found by POST /servers/lint. Rule names the check
+// that failed, such as "semver" or "duplicate_tool".
+type LintIssue struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// LintResponse reports what publishing a payload would run into. Valid is
+// false when there are errors; warnings do not block a publish.
+type LintResponse struct {
+	Status   string      `json:"status"`
+	Valid    bool        `json:"valid"`
+	Errors   []LintIssue `json:"errors"`
+	Warnings []LintIssue `json:"warnings"`
 }
 
 // Payment Types
 type CreateOrderRequest struct {
-	ServerName string  `json:"server_name"`
-	Amount     float64 `json:"amount"`
-	Currency   string  `json:"currency"`
+	ServerName string  `json:"server_name" binding:"required"`
+	Plan       string  `json:"plan,omitempty"`
+	Amount     float64 `json:"amount" binding:"gte=0"`
 }
 
 type VerifyPaymentRequest struct {
+	Provider          string `json:"provider,omitempty"`
 	RazorpayOrderID   string `json:"razorpay_order_id"`
 	RazorpayPaymentID string `json:"razorpay_payment_id"`
 	RazorpaySignature string `json:"razorpay_signature"`
-	ServerName        string `json:"server_name"`
+	PaymentIntentID   string `json:"payment_intent_id,omitempty"`
+	ServerName        string `json:"server_name" binding:"required"`
 }
 
 type OrderResponse struct {
-	Status string      `json:"status"`
-	Order  interface{} `json:"order,omitempty"`
-	KeyID  string      `json:"key_id,omitempty"`
-	Detail string      `json:"detail,omitempty"`
+	Status   string      `json:"status"`
+	Order    interface{} `json:"order,omitempty"`
+	KeyID    string      `json:"key_id,omitempty"`
+	Provider string      `json:"provider,omitempty"`
+	Detail   string      `json:"detail,omitempty"`
 }
 
 type PaymentResponse struct {
@@ -141,3 +357,821 @@ type PaymentResponse struct {
 	Payment interface{} `json:"payment,omitempty"`
 	Detail  string      `json:"detail,omitempty"`
 }
+
+// PaymentLink is a shareable checkout page a publisher creates for one of
+// their servers, hosted by the payment provider. A fixed link charges Amount;
+// a custom-amount link lets the payer choose between MinimumAmount and
+// Amount. Each payment through it becomes a "payment_link" order.
+type PaymentLink struct {
+	ID             string    `json:"id"`
+	TenantID       string    `json:"tenant_id,omitempty"`
+	ServerName     string    `json:"server_name"`
+	Plan           string    `json:"plan"`
+	Period         string    `json:"period"`
+	Description    string    `json:"description,omitempty"`
+	Amount         float64   `json:"amount"`
+	CustomAmount   bool      `json:"custom_amount,omitempty"`
+	MinimumAmount  float64   `json:"minimum_amount,omitempty"`
+	Currency       string    `json:"currency"`
+	Provider       string    `json:"provider"`
+	ProviderLinkID string    `json:"provider_link_id"`
+	URL            string    `json:"url"`
+	CallbackURL    string    `json:"callback_url,omitempty"`
+	UsageLimit     int       `json:"usage_limit"`
+	UsageCount     int       `json:"usage_count"`
+	OrderIDs       []string  `json:"order_ids"`
+	Status         string    `json:"status"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+	DeactivatedAt  time.Time `json:"deactivated_at,omitzero"`
+}
+
+// CreatePaymentLinkRequest describes a payment link. Amount defaults to the
+// plan's price; set it to invoice a different amount, or with CustomAmount
+// as the most the payer may choose. Razorpay links take one payment, so
+// UsageLimit above 1 needs a Stripe-settled currency.
+type CreatePaymentLinkRequest struct {
+	Plan          string  `json:"plan,omitempty"`
+	Description   string  `json:"description,omitempty" binding:"max=500"`
+	Amount        float64 `json:"amount,omitempty" binding:"gte=0"`
+	CustomAmount  bool    `json:"custom_amount,omitempty"`
+	MinimumAmount float64 `json:"minimum_amount,omitempty" binding:"gte=0"`
+	UsageLimit    int     `json:"usage_limit,omitempty" binding:"omitempty,min=1,max=1000"`
+	ExpiresInDays int     `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=365"`
+	// CallbackURL is where the provider sends the payer after paying, with
+	// the fields VerifyPaymentLinkRequest takes in the query string.
+	CallbackURL string `json:"callback_url,omitempty" binding:"omitempty,url"`
+}
+
+// VerifyPaymentLinkRequest carries what the provider appends to the link's
+// callback URL after a payment: Razorpay's payment and signature, or
+// Stripe's checkout session.
+type VerifyPaymentLinkRequest struct {
+	RazorpayPaymentID   string `json:"razorpay_payment_id,omitempty"`
+	RazorpayReferenceID string `json:"razorpay_payment_link_reference_id,omitempty"`
+	RazorpayLinkStatus  string `json:"razorpay_payment_link_status,omitempty"`
+	RazorpaySignature   string `json:"razorpay_signature,omitempty"`
+	CheckoutSessionID   string `json:"checkout_session_id,omitempty"`
+}
+
+// Purchase Record Types
+type Order struct {
+	ID         string  `json:"id"`
+	TenantID   string  `json:"tenant_id,omitempty"`
+	Kind       string  `json:"kind"`
+	UserID     string  `json:"user_id"`
+	ServerName string  `json:"server_name"`
+	Plan       string  `json:"plan"`
+	Period     string  `json:"period"`
+	Amount     float64 `json:"amount"`
+	// PlanAmount is the recurring price of Plan on a plan_change order,
+	// whose Amount is only the prorated difference charged for the switch.
+	PlanAmount     float64         `json:"plan_amount,omitempty"`
+	Currency       string          `json:"currency"`
+	Provider       string          `json:"provider"`
+	RoutingReason  string          `json:"routing_reason,omitempty"`
+	Publisher      string          `json:"publisher,omitempty"`
+	OwnerID        string          `json:"owner_id,omitempty"`
+	CommissionPct  float64         `json:"commission_pct"`
+	Status         string          `json:"status"`
+	PaymentID      string          `json:"payment_id,omitempty"`
+	Risk           *RiskAssessment `json:"risk,omitempty"`
+	Billing        *BillingProfile `json:"billing,omitempty"`
+	SubscriptionID string          `json:"subscription_id,omitempty"`
+	ResetCycle     bool            `json:"reset_cycle,omitempty"`
+	PaymentLinkID  string          `json:"payment_link_id,omitempty"`
+	// RefundedAmount is how much of Amount has been given back, such as
+	// the credit for switching to a cheaper plan.
+	RefundedAmount float64   `json:"refunded_amount,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	PaidAt         time.Time `json:"paid_at,omitzero"`
+	// Transitions records each step the order took through the order
+	// state machine, oldest first.
+	Transitions []OrderTransition `json:"transitions,omitempty"`
+}
+
+type OrderTransition struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type Entitlement struct {
+	ID              string        `json:"id"`
+	TenantID        string        `json:"tenant_id,omitempty"`
+	UserID          string        `json:"user_id"`
+	ServerName      string        `json:"server_name"`
+	Plan            string        `json:"plan"`
+	Period          string        `json:"period"`
+	Amount          float64       `json:"amount"`
+	Currency        string        `json:"currency"`
+	Provider        string        `json:"provider"`
+	OrderID         string        `json:"order_id"`
+	PaymentID       string        `json:"payment_id"`
+	GrantedAt       time.Time     `json:"granted_at"`
+	ExpiresAt       time.Time     `json:"expires_at,omitzero"`
+	Status          string        `json:"status"`
+	CustomerID      string        `json:"-"`
+	PaymentMethodID string        `json:"-"`
+	Dunning         *DunningState `json:"dunning,omitempty"`
+}
+
+// CheckEntitlementsRequest asks for the caller's access to several servers
+// at once, such as every server an agent runtime has installed.
+type CheckEntitlementsRequest struct {
+	Servers []string `json:"servers" binding:"required,min=1,max=500,dive,required,max=100"`
+}
+
+// EntitlementCheck is the caller's access to one server: "free" when it
+// needs no purchase, "entitled" with an active entitlement, "grace" while a
+// past-due subscription is in its grace period, "purchase_required", or
+// "not_found". MovedTo is set for a name the server was renamed from.
+type EntitlementCheck struct {
+	ServerName  string       `json:"server_name"`
+	MovedTo     string       `json:"moved_to,omitempty"`
+	Access      string       `json:"access"`
+	Entitlement *Entitlement `json:"entitlement,omitempty"`
+}
+
+type DunningState struct {
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextRetryAt time.Time `json:"next_retry_at,omitzero"`
+	GraceUntil  time.Time `json:"grace_until"`
+	PayOrderID  string    `json:"pay_order_id,omitempty"`
+}
+
+type ChangePlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+type Proration struct {
+	OldPlan        string    `json:"old_plan"`
+	NewPlan        string    `json:"new_plan"`
+	RemainingRatio float64   `json:"remaining_ratio"`
+	Credit         float64   `json:"credit"`
+	Charge         float64   `json:"charge"`
+	Difference     float64   `json:"difference"`
+	Currency       string    `json:"currency"`
+	ResetCycle     bool      `json:"reset_cycle"`
+	EffectiveAt    time.Time `json:"effective_at"`
+	NextRenewalAt  time.Time `json:"next_renewal_at"`
+}
+
+// Billing Types
+type BillingProfile struct {
+	UserID       string    `json:"user_id"`
+	Name         string    `json:"name"`
+	Company      string    `json:"company,omitempty"`
+	AddressLine1 string    `json:"address_line1"`
+	AddressLine2 string    `json:"address_line2,omitempty"`
+	City         string    `json:"city"`
+	State        string    `json:"state,omitempty"`
+	PostalCode   string    `json:"postal_code"`
+	Country      string    `json:"country"`
+	TaxID        string    `json:"tax_id,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type BillingProfileRequest struct {
+	Name         string `json:"name" binding:"max=200"`
+	Company      string `json:"company,omitempty" binding:"max=200"`
+	AddressLine1 string `json:"address_line1" binding:"max=200"`
+	AddressLine2 string `json:"address_line2,omitempty" binding:"max=200"`
+	City         string `json:"city" binding:"max=100"`
+	State        string `json:"state,omitempty" binding:"max=100"`
+	PostalCode   string `json:"postal_code" binding:"max=20"`
+	Country      string `json:"country"`
+	TaxID        string `json:"tax_id,omitempty" binding:"max=50"`
+}
+
+// BillingManager lets ManagerID look after AccountID's billing: its
+// invoices, subscriptions, and billing profile, but nothing it publishes.
+type BillingManager struct {
+	TenantID  string    `json:"tenant_id,omitempty"`
+	AccountID string    `json:"account_id"`
+	ManagerID string    `json:"manager_id"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// Invoice is a paid order as its buyer sees it. Amount is what was charged,
+// less RefundedAmount once credits or refunds are given back.
+type Invoice struct {
+	OrderID        string          `json:"order_id"`
+	Kind           string          `json:"kind"`
+	ServerName     string          `json:"server_name"`
+	Plan           string          `json:"plan"`
+	Period         string          `json:"period"`
+	Amount         float64         `json:"amount"`
+	RefundedAmount float64         `json:"refunded_amount,omitempty"`
+	Currency       string          `json:"currency"`
+	Provider       string          `json:"provider"`
+	Status         string          `json:"status"`
+	SubscriptionID string          `json:"subscription_id,omitempty"`
+	Billing        *BillingProfile `json:"billing,omitempty"`
+	PaidAt         time.Time       `json:"paid_at"`
+}
+
+// Revenue Types
+type CommissionTierRequest struct {
+	Percent float64 `json:"percent" binding:"gte=0,lte=100"`
+}
+
+type PublisherTierRequest struct {
+	Tier string `json:"tier" binding:"required"`
+}
+
+type PriceChange struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	ServerName  string    `json:"server_name"`
+	Publisher   string    `json:"publisher,omitempty"`
+	OldPricing  Pricing   `json:"old_pricing"`
+	NewPricing  Pricing   `json:"new_pricing"`
+	Status      string    `json:"status"`
+	RequestedAt time.Time `json:"requested_at"`
+	EffectiveAt time.Time `json:"effective_at,omitzero"`
+	ReviewedBy  string    `json:"reviewed_by,omitempty"`
+}
+
+// ModerationFlag is one reason content was held: the field it was found in
+// (description, readme, or image), and whether a keyword or the external
+// moderation service flagged it.
+type ModerationFlag struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// ModerationHold is a publish, update, or image upload held for an admin
+// to review. Server is the listing as it will be saved if approved.
+type ModerationHold struct {
+	ID          string           `json:"id"`
+	TenantID    string           `json:"tenant_id,omitempty"`
+	Kind        string           `json:"kind"`
+	ServerName  string           `json:"server_name,omitempty"`
+	BlobID      string           `json:"blob_id,omitempty"`
+	OwnerID     string           `json:"owner_id,omitempty"`
+	Server      *Server          `json:"server,omitempty"`
+	Flags       []ModerationFlag `json:"flags"`
+	Status      string           `json:"status"`
+	Reason      string           `json:"reason,omitempty"`
+	RequestedAt time.Time        `json:"requested_at"`
+	ReviewedAt  time.Time        `json:"reviewed_at,omitzero"`
+	ReviewedBy  string           `json:"reviewed_by,omitempty"`
+	// BaseUpdatedAt is when the live listing was last changed before an
+	// update was held, so approving a stale update can be refused.
+	BaseUpdatedAt time.Time `json:"-"`
+}
+
+type RejectModerationRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// Report Types
+type RevenueTotals struct {
+	Gross         float64 `json:"gross"`
+	PlatformFees  float64 `json:"platform_fees"`
+	ProcessorFees float64 `json:"processor_fees"`
+	Taxes         float64 `json:"taxes"`
+	Net           float64 `json:"net"`
+}
+
+type RevenueReport struct {
+	ID          string                   `json:"id"`
+	TenantID    string                   `json:"tenant_id,omitempty"`
+	OwnerID     string                   `json:"owner_id"`
+	Period      string                   `json:"period"`
+	Format      string                   `json:"format"`
+	Status      string                   `json:"status"`
+	BlobID      string                   `json:"-"`
+	Rows        int                      `json:"rows"`
+	Totals      map[string]RevenueTotals `json:"totals,omitempty"`
+	Error       string                   `json:"error,omitempty"`
+	DownloadURL string                   `json:"download_url,omitempty"`
+	CreatedAt   time.Time                `json:"created_at"`
+	CompletedAt time.Time                `json:"completed_at,omitzero"`
+}
+
+// Blob Types
+type Blob struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	Class       string    `json:"class"`
+	OwnerID     string    `json:"owner_id"`
+	Key         string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Status      string    `json:"status"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	UploadID    string    `json:"-"`
+	PartSize    int64     `json:"part_size,omitempty"`
+	PartCount   int       `json:"part_count,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	// StorageClass is where a bundle has been moved by its retention
+	// transitions; empty is standard storage.
+	StorageClass string `json:"storage_class,omitempty"`
+	// ServerName and Version name the published version a bundle belongs
+	// to. DetachedAt is when it stopped belonging to one, because the
+	// server was deleted or the version's record was replaced.
+	ServerName string    `json:"server_name,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	DetachedAt time.Time `json:"detached_at,omitzero"`
+}
+
+type CreateUploadRequest struct {
+	Class       string `json:"class" binding:"required"`
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+	Multipart   bool   `json:"multipart"`
+}
+
+// BlobUpload is where to send an upload: a presigned POST (URL and Fields),
+// or for a multipart upload, a presigned PUT for each requested part.
+type BlobUpload struct {
+	URL       string            `json:"url,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Parts     []BlobPart        `json:"parts,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// BlobPart is one part of a multipart upload: its upload URL when presigned,
+// or its size and ETag once storage has received it.
+type BlobPart struct {
+	PartNumber int    `json:"part_number"`
+	URL        string `json:"url,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	ETag       string `json:"etag,omitempty"`
+}
+
+type PresignPartsRequest struct {
+	PartNumbers []int `json:"part_numbers" binding:"required,min=1,max=100,dive,min=1"`
+}
+
+// StorageTransition moves a bundle to StorageClass once its version has
+// been superseded for AfterDays.
+type StorageTransition struct {
+	AfterDays    int    `json:"after_days"`
+	StorageClass string `json:"storage_class"`
+}
+
+// RetentionPolicy is the artifact retention applied to a server's bundles.
+// Custom is false when the deployment's ARTIFACT_TRANSITIONS apply.
+type RetentionPolicy struct {
+	ServerName         string              `json:"server_name"`
+	Custom             bool                `json:"custom"`
+	Transitions        []StorageTransition `json:"transitions"`
+	DraftRetentionDays int                 `json:"draft_retention_days"`
+	UpdatedBy          string              `json:"updated_by,omitempty"`
+	UpdatedAt          time.Time           `json:"updated_at,omitzero"`
+}
+
+type UpdateRetentionRequest struct {
+	Transitions []StorageTransition `json:"transitions" binding:"required,max=4,dive"`
+}
+
+// Risk Types
+type RiskAssessment struct {
+	Score    int      `json:"score"`
+	Decision string   `json:"decision"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+// Publisher Webhook Types
+type PublisherWebhook struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	ServerName string    `json:"server_name"`
+	OwnerID    string    `json:"owner_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Events     []string  `json:"events"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// SigningKeys holds the active secret first, then rotated-out secrets
+	// that keep signing deliveries until they expire.
+	SigningKeys []WebhookSigningKey `json:"-"`
+}
+
+type WebhookSigningKey struct {
+	ID        string    `json:"id"`
+	WebhookID string    `json:"webhook_id"`
+	Secret    string    `json:"-"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url"`
+	Events []string `json:"events,omitempty" binding:"omitempty,dive,required"`
+}
+
+// RotateWebhookSecretRequest sets how long the previous secret keeps
+// signing deliveries, up to a week. Zero retires it at once, for a secret
+// that has leaked; omitted uses the default grace period.
+type RotateWebhookSecretRequest struct {
+	GraceSeconds *int `json:"grace_seconds,omitempty" binding:"omitempty,min=0,max=604800"`
+}
+
+type WebhookSignaturePreviewRequest struct {
+	Payload string `json:"payload" binding:"max=65536"`
+}
+
+// WebhookSignature is one key's v1 signature of a previewed payload.
+type WebhookSignature struct {
+	KeyID     string    `json:"key_id,omitempty"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Signature string    `json:"signature"`
+}
+
+type WebhookDelivery struct {
+	ID            string    `json:"id"`
+	WebhookID     string    `json:"webhook_id"`
+	Event         string    `json:"event"`
+	EventID       string    `json:"event_id"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	ResponseCode  int       `json:"response_code,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	ReplayOf      string    `json:"replay_of,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at,omitzero"`
+	DeliveredAt   time.Time `json:"delivered_at,omitzero"`
+}
+
+// CDNInvalidation is one batch of CDN paths purged after server writes.
+// Writes made before the batch is sent join it, so Servers may list several.
+type CDNInvalidation struct {
+	ID             string    `json:"id"`
+	DistributionID string    `json:"distribution_id"`
+	Paths          []string  `json:"paths"`
+	Servers        []string  `json:"servers"`
+	Status         string    `json:"status"`
+	CloudFrontID   string    `json:"cloudfront_id,omitempty"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	SubmittedAt    time.Time `json:"submitted_at,omitzero"`
+	CompletedAt    time.Time `json:"completed_at,omitzero"`
+}
+
+// Job Types
+type Job struct {
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Payload   map[string]string `json:"payload"`
+	RunAt     time.Time         `json:"run_at"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Operation tracks a slow request that runs in the background. Clients
+// poll it until Status is "succeeded" or "failed".
+type Operation struct {
+	ID          string                 `json:"id"`
+	TenantID    string                 `json:"tenant_id,omitempty"`
+	OwnerID     string                 `json:"owner_id"`
+	Kind        string                 `json:"kind"`
+	Status      string                 `json:"status"`
+	Progress    int                    `json:"progress"`
+	Message     string                 `json:"message,omitempty"`
+	Input       []byte                 `json:"-"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	CompletedAt time.Time              `json:"completed_at,omitzero"`
+}
+
+type OperationResponse struct {
+	Status    string    `json:"status"`
+	Operation Operation `json:"operation"`
+}
+
+type ImportServersRequest struct {
+	Servers   []CreateServerRequest `json:"servers" binding:"required,min=1,max=100,dive"`
+	Overwrite bool                  `json:"overwrite,omitempty"`
+}
+
+// RegistryCheckRequest starts a scan of every stored server record. Without
+// Apply it only reports what it would repair.
+type RegistryCheckRequest struct {
+	Apply bool `json:"apply,omitempty"`
+}
+
+// RegistryIssue is a problem found in a stored server record. Fixable issues
+// come from older record shapes and are repaired when the check is applied;
+// the rest need the publisher or an admin to correct the server.
+type RegistryIssue struct {
+	Server  string `json:"server"`
+	Region  string `json:"region"`
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Fixable bool   `json:"fixable"`
+}
+
+// Reconciliation Types
+type RepairTask struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Provider   string    `json:"provider,omitempty"`
+	PaymentID  string    `json:"payment_id"`
+	OrderID    string    `json:"order_id"`
+	ServerName string    `json:"server_name,omitempty"`
+	UserID     string    `json:"user_id,omitempty"`
+	Amount     float64   `json:"amount"`
+	Currency   string    `json:"currency"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitzero"`
+}
+
+type ReconciliationRun struct {
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	PaymentsChecked int       `json:"payments_checked"`
+	OrphansFound    int       `json:"orphans_found"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// API v2 Types
+type ServerV2 struct {
+	Name           string                 `json:"name"`
+	Version        string                 `json:"version"`
+	Description    string                 `json:"description"`
+	Author         string                 `json:"author"`
+	Lang           string                 `json:"lang"`
+	License        string                 `json:"license"`
+	Entrypoint     string                 `json:"entrypoint"`
+	Repository     Repository             `json:"repository"`
+	Pricing        Pricing                `json:"pricing"`
+	Tools          Tools                  `json:"tools,omitempty"`
+	SecurityReport map[string]interface{} `json:"security_report,omitempty"`
+	CreatedAt      time.Time              `json:"created_at,omitzero"`
+	UpdatedAt      time.Time              `json:"updated_at,omitzero"`
+}
+
+type PageV2 struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// LinksV2 are ready-to-follow URLs for the current, next, and previous pages
+// of a v2 list.
+type LinksV2 struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type ServerListV2 struct {
+	Items []ServerV2 `json:"items"`
+	Total int        `json:"total"`
+	Page  PageV2     `json:"page"`
+	Links LinksV2    `json:"links"`
+}
+
+type ServerV2Response struct {
+	Data ServerV2 `json:"data"`
+}
+
+type EntitlementListV2 struct {
+	Items []Entitlement `json:"items"`
+	Total int           `json:"total"`
+	Page  PageV2        `json:"page"`
+	Links LinksV2       `json:"links"`
+}
+
+type BlobListV2 struct {
+	Items []Blob  `json:"items"`
+	Total int     `json:"total"`
+	Page  PageV2  `json:"page"`
+	Links LinksV2 `json:"links"`
+}
+
+type NotificationListV2 struct {
+	Items  []Notification `json:"items"`
+	Total  int            `json:"total"`
+	Unread int            `json:"unread"`
+	Page   PageV2         `json:"page"`
+	Links  LinksV2        `json:"links"`
+}
+
+// Audit Types
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type AuditEntry struct {
+	ID        string `json:"id"`
+	RequestID string `json:"request_id"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	ActorID   string `json:"actor_id,omitempty"`
+	// PartnerID is the partner that acted for ActorID with an exchanged
+	// token.
+	PartnerID string                 `json:"partner_id,omitempty"`
+	ClientIP  string                 `json:"client_ip"`
+	Method    string                 `json:"method"`
+	Route     string                 `json:"route"`
+	Action    string                 `json:"action,omitempty"`
+	Resource  string                 `json:"resource"`
+	Params    map[string]string      `json:"params,omitempty"`
+	Status    int                    `json:"status"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Notification Types
+type Notification struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"user_id"`
+	Kind      string                 `json:"kind"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	ReadAt    time.Time              `json:"read_at,omitzero"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type NotificationPreferences struct {
+	Channels  map[string]string `json:"channels"`
+	Email     string            `json:"email,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at,omitzero"`
+	// TenantID is where the preferences were last set, whose branding the
+	// user's notification emails carry.
+	TenantID string `json:"-"`
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	Channels map[string]string `json:"channels" binding:"required"`
+}
+
+// DataResidency is the storage region holding a user's servers and blobs.
+type DataResidency struct {
+	Region    string    `json:"region"`
+	Available []string  `json:"available"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+type UpdateDataResidencyRequest struct {
+	Region string `json:"region" binding:"required"`
+}
+
+// InstalledServer is a server a user's CLI reports as installed. Notified
+// records the newest version the user has already been told about.
+type InstalledServer struct {
+	Name        string    `json:"name" binding:"required,max=100"`
+	Version     string    `json:"version" binding:"required,semver"`
+	Notified    string    `json:"notified_version,omitempty"`
+	InstalledAt time.Time `json:"installed_at,omitzero"`
+}
+
+type UpdateInstalledServersRequest struct {
+	Servers []InstalledServer `json:"servers" binding:"required,max=500,dive"`
+}
+
+// ServerUpdate is one entry in GET /me/updates: a newer version of an
+// installed server, a failing security report on it, advisories filed
+// against the installed version, or any of those together. MovedTo is set
+// when the server was installed under a name it has since been renamed from.
+type ServerUpdate struct {
+	Name             string                 `json:"name"`
+	MovedTo          string                 `json:"moved_to,omitempty"`
+	InstalledVersion string                 `json:"installed_version"`
+	LatestVersion    string                 `json:"latest_version"`
+	UpdateAvailable  bool                   `json:"update_available"`
+	SecurityAlert    bool                   `json:"security_alert"`
+	SecuritySummary  map[string]interface{} `json:"security_summary,omitempty"`
+	Advisories       []Advisory             `json:"advisories,omitempty"`
+	PublishedAt      time.Time              `json:"published_at,omitzero"`
+}
+
+// AdvisoryRange is one span of affected versions, from Introduced up to but
+// not including Fixed. An empty Introduced starts at the first version and
+// an empty Fixed means no release fixes it yet.
+type AdvisoryRange struct {
+	Introduced string `json:"introduced,omitempty" binding:"omitempty,semver"`
+	Fixed      string `json:"fixed,omitempty" binding:"omitempty,semver"`
+}
+
+// Advisory is a vulnerability filed against versions of a server.
+// AffectedRange and AffectedVersions are computed from Ranges and the
+// versions published when the advisory is read.
+type Advisory struct {
+	ID               string          `json:"id"`
+	TenantID         string          `json:"tenant_id,omitempty"`
+	ServerName       string          `json:"server_name"`
+	Summary          string          `json:"summary"`
+	Details          string          `json:"details,omitempty"`
+	Severity         string          `json:"severity"`
+	Aliases          []string        `json:"aliases,omitempty"`
+	References       []string        `json:"references,omitempty"`
+	Ranges           []AdvisoryRange `json:"ranges"`
+	AffectedRange    string          `json:"affected_range"`
+	AffectedVersions []string        `json:"affected_versions"`
+	ReportedBy       string          `json:"reported_by"`
+	PublishedAt      time.Time       `json:"published_at"`
+}
+
+type CreateAdvisoryRequest struct {
+	Summary    string          `json:"summary" binding:"required,max=200"`
+	Details    string          `json:"details,omitempty" binding:"max=10000"`
+	Severity   string          `json:"severity" binding:"required,oneof=low moderate high critical"`
+	Aliases    []string        `json:"aliases,omitempty" binding:"omitempty,max=10,dive,required"`
+	References []string        `json:"references,omitempty" binding:"omitempty,max=20,dive,url"`
+	Affected   []AdvisoryRange `json:"affected" binding:"required,min=1,max=20,dive"`
+}
+
+// ServerQuestion is a question asked about a server, with its publisher's
+// answer once there is one. HelpfulCount is how many users marked it helpful.
+type ServerQuestion struct {
+	ID           string    `json:"id"`
+	TenantID     string    `json:"tenant_id,omitempty"`
+	ServerName   string    `json:"server_name"`
+	Question     string    `json:"question"`
+	AskedBy      string    `json:"asked_by"`
+	AskedAt      time.Time `json:"asked_at"`
+	Answer       string    `json:"answer,omitempty"`
+	AnsweredBy   string    `json:"answered_by,omitempty"`
+	AnsweredAt   time.Time `json:"answered_at,omitzero"`
+	HelpfulCount int       `json:"helpful_count"`
+}
+
+type AskQuestionRequest struct {
+	Question string `json:"question" binding:"required,min=10,max=2000"`
+}
+
+type AnswerQuestionRequest struct {
+	Answer string `json:"answer" binding:"required,max=5000"`
+}
+
+// ServerReview is a user's rating of a server, with the publisher's official
+// response once there is one. Each user reviews a server at most once.
+type ServerReview struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	ServerName  string    `json:"server_name"`
+	Rating      int       `json:"rating"`
+	Body        string    `json:"body,omitempty"`
+	ReviewedBy  string    `json:"reviewed_by"`
+	ReviewedAt  time.Time `json:"reviewed_at"`
+	Response    string    `json:"response,omitempty"`
+	RespondedBy string    `json:"responded_by,omitempty"`
+	RespondedAt time.Time `json:"responded_at,omitzero"`
+}
+
+type CreateReviewRequest struct {
+	Rating int    `json:"rating" binding:"required,min=1,max=5"`
+	Body   string `json:"body" binding:"max=5000"`
+}
+
+type RespondToReviewRequest struct {
+	Response string `json:"response" binding:"required,max=5000"`
+}
+
+// ServerClaim is a user's request to take ownership of a server published
+// before owners were recorded. It is verified by Method: "file" finds Token
+// in the repository, "github" checks the user's GitHub access to it.
+type ServerClaim struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	ServerName string    `json:"server_name"`
+	UserID     string    `json:"user_id"`
+	Method     string    `json:"method"`
+	Repository string    `json:"repository"`
+	Token      string    `json:"token,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Status     string    `json:"status"`
+	LastError  string    `json:"last_error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	VerifiedAt time.Time `json:"verified_at,omitzero"`
+}
+
+type CreateServerClaimRequest struct {
+	Method string `json:"method" binding:"required,oneof=file github"`
+}
+
+// VerifyServerClaimRequest carries the GitHub OAuth access token for the
+// "github" method; the "file" method needs no body.
+type VerifyServerClaimRequest struct {
+	GithubToken string `json:"github_token"`
+}
+
+type SetServerOwnerRequest struct {
+	OwnerID string `json:"owner_id" binding:"required,max=128"`
+}
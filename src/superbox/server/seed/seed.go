@@ -0,0 +1,103 @@
+// Package seed holds the fixtures `server seed` loads into a fresh
+// deployment: demo users, sample servers, and sandbox orders.
+package seed
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"superbox/server/models"
+)
+
+// Default is the bundled staging and demo fixture set.
+//
+//go:embed fixtures.json
+var Default []byte
+
+// Fixtures is a seed file. Servers and orders refer to users by email, so
+// one file seeds any deployment whatever IDs the identity provider assigns.
+type Fixtures struct {
+	Users   []User   `json:"users"`
+	Servers []Server `json:"servers"`
+	Orders  []Order  `json:"orders"`
+}
+
+// User is a demo account, created with a password sign-up or reused when
+// the email already exists with the same password.
+type User struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Admin users are listed after seeding so their IDs can be added to
+	// ADMIN_UIDS; seeding does not change configuration.
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Server is a registry record, optionally owned by a fixture user.
+type Server struct {
+	models.Server
+	Owner string `json:"owner,omitempty"`
+}
+
+// Order is a sandbox purchase of a server plan by a fixture user. A "paid"
+// order (the default) grants its entitlement; a "created" one is left
+// awaiting payment.
+type Order struct {
+	User   string `json:"user"`
+	Server string `json:"server"`
+	Plan   string `json:"plan,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// Parse reads a seed file and checks that its references resolve. A bare
+// JSON array is read as a list of servers, the format seed files used
+// before users and orders were added.
+func Parse(data []byte) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &fixtures.Servers); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, fixtures); err != nil {
+		return nil, err
+	}
+
+	users := map[string]bool{}
+	for i, user := range fixtures.Users {
+		if user.Email == "" || user.Password == "" {
+			return nil, fmt.Errorf("user %d needs an email and a password", i)
+		}
+		if users[user.Email] {
+			return nil, fmt.Errorf("user %s is listed twice", user.Email)
+		}
+		users[user.Email] = true
+	}
+	servers := map[string]bool{}
+	for i, server := range fixtures.Servers {
+		if server.Name == "" {
+			return nil, fmt.Errorf("server %d has no name", i)
+		}
+		if server.Owner != "" && !users[server.Owner] {
+			return nil, fmt.Errorf("server %s is owned by %s, who is not a fixture user", server.Name, server.Owner)
+		}
+		servers[server.Name] = true
+	}
+	for i := range fixtures.Orders {
+		order := &fixtures.Orders[i]
+		if !users[order.User] {
+			return nil, fmt.Errorf("order %d is for %s, who is not a fixture user", i, order.User)
+		}
+		if !servers[order.Server] {
+			return nil, fmt.Errorf("order %d is for server %s, which is not a fixture server", i, order.Server)
+		}
+		if order.Status == "" {
+			order.Status = "paid"
+		}
+		if order.Status != "paid" && order.Status != "created" {
+			return nil, fmt.Errorf("order %d has status %q; use paid or created", i, order.Status)
+		}
+	}
+	return fixtures, nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Caller runs one function of the server's AWS helper.
+type Caller func(ctx context.Context, function string, args map[string]interface{}) (map[string]interface{}, error)
+
+// DynamoDB keeps state in a table whose partition key is the string
+// attribute "key", through the server's AWS helper. Turn on TTL for the
+// table's expires_at attribute so expired items are removed; DynamoDB does
+// that lazily, so reads and SetNX treat them as gone in the meantime.
+type DynamoDB struct {
+	table string
+	call  Caller
+}
+
+func NewDynamoDB(table string, call Caller) *DynamoDB {
+	return &DynamoDB{table: table, call: call}
+}
+
+type dynamoItem struct {
+	value   []byte
+	version string
+}
+
+// expiresAt is the expires_at attribute for ttl: Unix seconds, rounded up,
+// or zero to keep the item until it is deleted.
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl + time.Second - 1).Unix()
+}
+
+// newVersion tags each write, so Update can tell whether the item changed
+// since it was read, even if it was deleted and written again.
+func newVersion() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (d *DynamoDB) get(ctx context.Context, key string) (*dynamoItem, error) {
+	result, err := d.call(ctx, "get_state", map[string]interface{}{
+		"table_name": d.table,
+		"key":        key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, _ := result["data"].(map[string]interface{})
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	if expires, _ := data["expires_at"].(float64); expires > 0 && int64(expires) <= time.Now().Unix() {
+		return nil, ErrNotFound
+	}
+	encoded, _ := data["value"].(string)
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("state item %s: %w", key, err)
+	}
+	version, _ := data["version"].(string)
+	return &dynamoItem{value: value, version: version}, nil
+}
+
+// put writes an item. condition is "" to always write, "absent" to write
+// only over a missing or expired item, or "version" to write only while the
+// item is still at expected. It reports whether the write happened.
+func (d *DynamoDB) put(ctx context.Context, key string, value []byte, ttl time.Duration, condition string, expected string) (bool, error) {
+	result, err := d.call(ctx, "put_state", map[string]interface{}{
+		"table_name": d.table,
+		"key":        key,
+		"value":      base64.StdEncoding.EncodeToString(value),
+		"expires_at": expiresAt(ttl),
+		"version":    newVersion(),
+		"condition":  condition,
+		"expected":   expected,
+		"now":        time.Now().Unix(),
+	})
+	if err != nil {
+		return false, err
+	}
+	written, _ := result["success"].(bool)
+	return written, nil
+}
+
+func (d *DynamoDB) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := d.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.value, nil
+}
+
+func (d *DynamoDB) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := d.put(ctx, key, value, ttl, "", "")
+	return err
+}
+
+func (d *DynamoDB) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return d.put(ctx, key, value, ttl, "absent", "")
+}
+
+func (d *DynamoDB) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := d.call(ctx, "delete_state", map[string]interface{}{
+		"table_name": d.table,
+		"keys":       keys,
+	})
+	return err
+}
+
+func (d *DynamoDB) Update(ctx context.Context, key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		item, err := d.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		updated, err := fn(item.value)
+		if err != nil {
+			return err
+		}
+		written, err := d.put(ctx, key, updated, ttl, "version", item.version)
+		if err != nil || written {
+			return err
+		}
+	}
+	return errors.New("too much contention updating " + key)
+}
+
+func (d *DynamoDB) Ping(ctx context.Context) error {
+	_, err := d.call(ctx, "describe_state_table", map[string]interface{}{
+		"table_name": d.table,
+	})
+	return err
+}
+
+func (d *DynamoDB) Close() error {
+	return nil
+}
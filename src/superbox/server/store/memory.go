@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type Memory struct {
+	entries map[string]memoryEntry
+	mutex   sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry)}
+}
+
+func (m *Memory) lookup(key string) ([]byte, bool) {
+	entry, exists := m.entries[key]
+	if !exists {
+		return nil, false
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (m *Memory) store(key string, value []byte, ttl time.Duration) {
+	entry := memoryEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	m.entries[key] = entry
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, exists := m.lookup(key)
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.store(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.lookup(key); exists {
+		return false, nil
+	}
+	m.store(key, value, ttl)
+	return true, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *Memory) Update(ctx context.Context, key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, exists := m.lookup(key)
+	if !exists {
+		return ErrNotFound
+	}
+	updated, err := fn(append([]byte(nil), current...))
+	if err != nil {
+		return err
+	}
+	m.store(key, updated, ttl)
+	return nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
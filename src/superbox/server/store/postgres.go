@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresSweepInterval is how often expired rows are deleted. Reads skip
+// them in the meantime.
+const postgresSweepInterval = 10 * time.Minute
+
+// Postgres keeps state in the superbox_state table, for deployments with a
+// database but no Redis. Expiry is computed by the database clock, so
+// replicas with skewed clocks agree on it.
+type Postgres struct {
+	pool *pgxpool.Pool
+	stop chan struct{}
+}
+
+func NewPostgres(ctx context.Context, databaseURL string) (*Postgres, error) {
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	_, err = pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS superbox_state (
+		key        text PRIMARY KEY,
+		value      bytea NOT NULL,
+		expires_at timestamptz
+	)`)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	p := &Postgres{pool: pool, stop: make(chan struct{})}
+	go p.sweep()
+	return p, nil
+}
+
+// ttlSeconds is the ttl as the query parameter for expires_at, nil to keep
+// the row until it is deleted.
+func ttlSeconds(ttl time.Duration) *float64 {
+	if ttl <= 0 {
+		return nil
+	}
+	seconds := ttl.Seconds()
+	return &seconds
+}
+
+func (p *Postgres) sweep() {
+	ticker := time.NewTicker(postgresSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if _, err := p.pool.Exec(context.Background(), `DELETE FROM superbox_state WHERE expires_at <= now()`); err != nil {
+				slog.Warn("failed to sweep expired state", "error", err)
+			}
+		}
+	}
+}
+
+func (p *Postgres) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := p.pool.QueryRow(ctx, `SELECT value FROM superbox_state WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())`, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (p *Postgres) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO superbox_state (key, value, expires_at)
+		VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, value, ttlSeconds(ttl))
+	return err
+}
+
+// SetNX replaces a row only when it has expired, so a key whose expired
+// row has not been swept yet can still be taken.
+func (p *Postgres) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `INSERT INTO superbox_state (key, value, expires_at)
+		VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE superbox_state.expires_at <= now()`,
+		key, value, ttlSeconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *Postgres) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := p.pool.Exec(ctx, `DELETE FROM superbox_state WHERE key = ANY($1)`, keys)
+	return err
+}
+
+// Update holds a row lock from the read to the write, so concurrent
+// updates to a key run one after another instead of retrying.
+func (p *Postgres) Update(ctx context.Context, key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var current []byte
+	err = tx.QueryRow(ctx, `SELECT value FROM superbox_state WHERE key = $1 AND (expires_at IS NULL OR expires_at > now()) FOR UPDATE`, key).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, err := fn(current)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE superbox_state SET value = $2, expires_at = now() + make_interval(secs => $3) WHERE key = $1`, key, updated, ttlSeconds(ttl))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+func (p *Postgres) Close() error {
+	close(p.stop)
+	p.pool.Close()
+	return nil
+}
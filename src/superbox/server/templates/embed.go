@@ -0,0 +1,9 @@
+package templates
+
+import "embed"
+
+// FS holds the static pages at the top level and the rendered ones: layouts,
+// partials, pages, and emails.
+//
+//go:embed *.html layouts partials pages emails
+var FS embed.FS
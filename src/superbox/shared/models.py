@@ -11,11 +11,23 @@ class Repository(BaseModel):
     url: str
 
 
+class PricingPlan(BaseModel):
+    """Named pricing plan for an MCP server"""
+
+    name: str
+    amount: float
+    period: str = "one_time"
+    features: list[str] = []
+
+
 class Pricing(BaseModel):
     """Pricing information for MCP servers"""
 
     currency: str
     amount: float
+    mode: Optional[str] = None
+    minimum_amount: Optional[float] = None
+    plans: Optional[list[PricingPlan]] = None
 
 
 class ToolInfo(BaseModel):
@@ -36,6 +48,7 @@ class MCPServer(BaseModel):
     """Complete MCP Server definition"""
 
     name: str
+    display_name: Optional[str] = None
     version: str
     description: str
     author: str
@@ -54,6 +67,7 @@ class CreateServerRequest(BaseModel):
     """Request payload for creating an MCP server"""
 
     name: str
+    display_name: Optional[str] = None
     version: str
     description: str
     author: str
@@ -69,6 +83,7 @@ class UpdateServerRequest(BaseModel):
     """Request payload for updating an MCP server"""
 
     name: Optional[str] = None
+    display_name: Optional[str] = None
     version: Optional[str] = None
     description: Optional[str] = None
     author: Optional[str] = None
@@ -155,6 +170,7 @@ class CreateOrderRequest(BaseModel):
     """Request payload for creating a Razorpay order"""
 
     server_name: str
+    plan: Optional[str] = None
     amount: float
     currency: str
 
@@ -162,7 +178,9 @@ class CreateOrderRequest(BaseModel):
 class VerifyPaymentRequest(BaseModel):
     """Request payload for verifying a Razorpay payment"""
 
-    razorpay_order_id: str
-    razorpay_payment_id: str
-    razorpay_signature: str
+    provider: Optional[str] = None
+    razorpay_order_id: Optional[str] = None
+    razorpay_payment_id: Optional[str] = None
+    razorpay_signature: Optional[str] = None
+    payment_intent_id: Optional[str] = None
     server_name: str
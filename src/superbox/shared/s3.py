@@ -1,31 +1,74 @@
+import base64
 import json
+from concurrent.futures import ThreadPoolExecutor
+from contextlib import contextmanager
+from contextvars import ContextVar
 from datetime import datetime, timezone
-from typing import Any, Dict, Optional, Tuple
+from typing import Any, Dict, Iterator, List, Optional, Tuple
 
 import boto3
 
 from superbox.shared.config import Config
 
+# Concurrent GETs when reading every server in list_servers
+LIST_CONCURRENCY = 8
+
+# AWS region of the bucket the current call targets, when it is not AWS_REGION
+_call_region: ContextVar[Optional[str]] = ContextVar("call_region", default=None)
+
+
+@contextmanager
+def using_region(region: Optional[str]) -> Iterator[None]:
+    """Create clients inside the block for region instead of AWS_REGION."""
+    token = _call_region.set(region or None)
+    try:
+        yield
+    finally:
+        _call_region.reset(token)
+
 
 def s3_client() -> Any:
     """Create and return S3 client using shared Config values"""
     cfg = Config()
     return boto3.client(
         "s3",
+        region_name=_call_region.get() or cfg.AWS_REGION,
+        aws_access_key_id=cfg.AWS_ACCESS_KEY_ID,
+        aws_secret_access_key=cfg.AWS_SECRET_ACCESS_KEY,
+    )
+
+
+def cloudfront_client() -> Any:
+    """Create and return CloudFront client; CloudFront is global, so no region"""
+    cfg = Config()
+    return boto3.client(
+        "cloudfront",
+        aws_access_key_id=cfg.AWS_ACCESS_KEY_ID,
+        aws_secret_access_key=cfg.AWS_SECRET_ACCESS_KEY,
+    )
+
+
+def dynamodb_client() -> Any:
+    """Create and return DynamoDB client using shared Config values"""
+    cfg = Config()
+    return boto3.client(
+        "dynamodb",
         region_name=cfg.AWS_REGION,
         aws_access_key_id=cfg.AWS_ACCESS_KEY_ID,
         aws_secret_access_key=cfg.AWS_SECRET_ACCESS_KEY,
     )
 
 
-def _server_key(server_name: str) -> str:
-    return f"{server_name}.json"
+def _timestamp() -> str:
+    """Current time in the API's canonical form: UTC, whole seconds, Z suffix."""
+    return datetime.now(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
 
 
-def get_server(bucket_name: str, server_name: str) -> Optional[Dict[str, Any]]:
-    """Fetch a single MCP server JSON: <name>.json"""
-    s3 = s3_client()
-    key = _server_key(server_name)
+def _server_key(server_name: str, prefix: str = "") -> str:
+    return f"{prefix}{server_name}.json"
+
+
+def _read_server(s3: Any, bucket_name: str, key: str) -> Optional[Dict[str, Any]]:
     try:
         response = s3.get_object(Bucket=bucket_name, Key=key)
         content = response["Body"].read().decode("utf-8")
@@ -34,10 +77,15 @@ def get_server(bucket_name: str, server_name: str) -> Optional[Dict[str, Any]]:
         return None
 
 
-def save_server(bucket_name: str, server_name: str, data: Dict[str, Any]) -> bool:
-    """Write a single MCP server JSON: <name>.json"""
+def get_server(bucket_name: str, server_name: str, prefix: str = "") -> Optional[Dict[str, Any]]:
+    """Fetch a single MCP server JSON: <prefix><name>.json"""
+    return _read_server(s3_client(), bucket_name, _server_key(server_name, prefix))
+
+
+def save_server(bucket_name: str, server_name: str, data: Dict[str, Any], prefix: str = "") -> bool:
+    """Write a single MCP server JSON: <prefix><name>.json"""
     s3 = s3_client()
-    key = _server_key(server_name)
+    key = _server_key(server_name, prefix)
     payload = dict(data)
     if "name" not in payload:
         payload["name"] = server_name
@@ -50,36 +98,43 @@ def save_server(bucket_name: str, server_name: str, data: Dict[str, Any]) -> boo
     return True
 
 
-def list_servers(bucket_name: str) -> Dict[str, Dict[str, Any]]:
-    """List all MCP servers by enumerating *.json objects in the bucket.
-
-    Returns a mapping {name: data}.
-    """
+def list_server_names(bucket_name: str, prefix: str = "") -> List[str]:
+    """List the names of all MCP servers (*.json directly under prefix), sorted."""
     s3 = s3_client()
-    servers: Dict[str, Dict[str, Any]] = {}
+    names: List[str] = []
     continuation_token: Optional[str] = None
     while True:
         kwargs: Dict[str, Any] = {"Bucket": bucket_name}
+        if prefix:
+            kwargs["Prefix"] = prefix
         if continuation_token:
             kwargs["ContinuationToken"] = continuation_token
         resp = s3.list_objects_v2(**kwargs)
         for obj in resp.get("Contents", []):
-            key = obj.get("Key", "")
-            if not key.lower().endswith(".json"):
+            key = obj.get("Key", "")[len(prefix) :]
+            if not key.lower().endswith(".json") or "/" in key:
                 continue
-            name = key[:-5]  # strip .json
-            try:
-                data = get_server(bucket_name, name)
-                if data is not None:
-                    servers[name] = data
-            except Exception:
-                # Skip unreadable entries
-                pass
+            names.append(key[:-5])  # strip .json
         if resp.get("IsTruncated"):
             continuation_token = resp.get("NextContinuationToken")
         else:
             break
-    return servers
+    return sorted(names)
+
+
+def list_servers(bucket_name: str, prefix: str = "") -> Dict[str, Dict[str, Any]]:
+    """List all MCP servers by enumerating *.json objects under prefix.
+
+    Returns a mapping {name: data}.
+    """
+    names = list_server_names(bucket_name, prefix)
+    # boto3 clients are thread-safe once created, so the reads share one
+    s3 = s3_client()
+    keys = [_server_key(name, prefix) for name in names]
+    with ThreadPoolExecutor(max_workers=LIST_CONCURRENCY) as pool:
+        records = pool.map(lambda key: _read_server(s3, bucket_name, key), keys)
+        # Unreadable entries come back as None and are skipped
+        return {name: data for name, data in zip(names, records) if data is not None}
 
 
 def check_server(bucket_name: str, server_name: str) -> Tuple[bool, Dict[str, Any]]:
@@ -93,9 +148,9 @@ def find_server(bucket_name: str, server_name: str) -> Optional[Dict[str, Any]]:
     return get_server(bucket_name, server_name)
 
 
-def upsert_server(bucket_name: str, server_name: str, server_data: Dict[str, Any]) -> bool:
-    """Create or update a single MCP server file <name>.json, preserving created_at if present."""
-    existing = get_server(bucket_name, server_name)
+def upsert_server(bucket_name: str, server_name: str, server_data: Dict[str, Any], prefix: str = "") -> bool:
+    """Create or update a single MCP server file <prefix><name>.json, preserving created_at if present."""
+    existing = get_server(bucket_name, server_name, prefix)
     server_data = dict(server_data)
 
     if "meta" not in server_data:
@@ -106,18 +161,237 @@ def upsert_server(bucket_name: str, server_name: str, server_data: Dict[str, Any
             server_data["meta"]["created_at"] = existing["meta"]["created_at"]
     else:
         if "created_at" not in server_data["meta"]:
-            server_data["meta"]["created_at"] = datetime.now(timezone.utc).isoformat()
+            server_data["meta"]["created_at"] = _timestamp()
 
-    server_data["meta"]["updated_at"] = datetime.now(timezone.utc).isoformat()
-    return save_server(bucket_name, server_name, server_data)
+    server_data["meta"]["updated_at"] = _timestamp()
+    return save_server(bucket_name, server_name, server_data, prefix)
 
 
-def delete_server(bucket_name: str, server_name: str) -> bool:
-    """Delete a single MCP server JSON file: <name>.json"""
+def delete_server(bucket_name: str, server_name: str, prefix: str = "") -> bool:
+    """Delete a single MCP server JSON file: <prefix><name>.json"""
     s3 = s3_client()
-    key = _server_key(server_name)
+    key = _server_key(server_name, prefix)
     try:
         s3.delete_object(Bucket=bucket_name, Key=key)
         return True
     except Exception:
         return False
+
+
+def head_bucket(bucket_name: str) -> bool:
+    """Confirm the bucket exists and the configured credentials can reach it"""
+    s3 = s3_client()
+    s3.head_bucket(Bucket=bucket_name)
+    return True
+
+
+def put_object(bucket_name: str, key: str, body: str, content_type: str) -> bool:
+    """Write an arbitrary object (reports, exports) outside the registry namespace"""
+    s3 = s3_client()
+    s3.put_object(Bucket=bucket_name, Key=key, Body=body.encode("utf-8"), ContentType=content_type)
+    return True
+
+
+def presign_url(bucket_name: str, key: str, expires_in: int = 3600) -> str:
+    """Create a presigned GET URL for an object"""
+    s3 = s3_client()
+    return s3.generate_presigned_url(
+        "get_object",
+        Params={"Bucket": bucket_name, "Key": key},
+        ExpiresIn=expires_in,
+    )
+
+
+def presign_upload(
+    bucket_name: str, key: str, content_type: str, max_bytes: int, expires_in: int = 900
+) -> Dict[str, Any]:
+    """Create a presigned POST that only accepts the given content type and size"""
+    s3 = s3_client()
+    return s3.generate_presigned_post(
+        Bucket=bucket_name,
+        Key=key,
+        Fields={"Content-Type": content_type},
+        Conditions=[
+            {"Content-Type": content_type},
+            ["content-length-range", 1, max_bytes],
+        ],
+        ExpiresIn=expires_in,
+    )
+
+
+def create_multipart_upload(bucket_name: str, key: str, content_type: str) -> str:
+    """Start a multipart upload and return its upload id"""
+    s3 = s3_client()
+    response = s3.create_multipart_upload(Bucket=bucket_name, Key=key, ContentType=content_type)
+    return response["UploadId"]
+
+
+def presign_upload_part(
+    bucket_name: str, key: str, upload_id: str, part_number: int, expires_in: int = 900
+) -> str:
+    """Create a presigned PUT URL for one part of a multipart upload"""
+    s3 = s3_client()
+    return s3.generate_presigned_url(
+        "upload_part",
+        Params={
+            "Bucket": bucket_name,
+            "Key": key,
+            "UploadId": upload_id,
+            "PartNumber": part_number,
+        },
+        ExpiresIn=expires_in,
+    )
+
+
+def list_parts(bucket_name: str, key: str, upload_id: str) -> List[Dict[str, Any]]:
+    """Return the parts received so far for a multipart upload"""
+    s3 = s3_client()
+    parts = []
+    paginator = s3.get_paginator("list_parts")
+    for page in paginator.paginate(Bucket=bucket_name, Key=key, UploadId=upload_id):
+        for part in page.get("Parts", []):
+            parts.append(
+                {"part_number": part["PartNumber"], "etag": part["ETag"], "size": part["Size"]}
+            )
+    return parts
+
+
+def complete_multipart_upload(
+    bucket_name: str, key: str, upload_id: str, parts: List[Dict[str, Any]]
+) -> bool:
+    """Assemble the uploaded parts into the final object"""
+    s3 = s3_client()
+    s3.complete_multipart_upload(
+        Bucket=bucket_name,
+        Key=key,
+        UploadId=upload_id,
+        MultipartUpload={
+            "Parts": [{"PartNumber": p["part_number"], "ETag": p["etag"]} for p in parts]
+        },
+    )
+    return True
+
+
+def abort_multipart_upload(bucket_name: str, key: str, upload_id: str) -> bool:
+    """Discard a multipart upload and the parts stored for it"""
+    s3 = s3_client()
+    s3.abort_multipart_upload(Bucket=bucket_name, Key=key, UploadId=upload_id)
+    return True
+
+
+def head_object(bucket_name: str, key: str) -> Optional[Dict[str, Any]]:
+    """Return the size and content type of an object, or None if it is missing"""
+    s3 = s3_client()
+    try:
+        response = s3.head_object(Bucket=bucket_name, Key=key)
+    except Exception:
+        return None
+    return {"size": response["ContentLength"], "content_type": response.get("ContentType", "")}
+
+
+def delete_object(bucket_name: str, key: str) -> bool:
+    """Delete an arbitrary object outside the registry namespace"""
+    s3 = s3_client()
+    s3.delete_object(Bucket=bucket_name, Key=key)
+    return True
+
+
+def set_storage_class(bucket_name: str, key: str, storage_class: str) -> bool:
+    """Rewrite an object in place under another storage class, keeping its metadata"""
+    s3 = s3_client()
+    s3.copy(
+        {"Bucket": bucket_name, "Key": key},
+        bucket_name,
+        key,
+        ExtraArgs={"StorageClass": storage_class, "MetadataDirective": "COPY"},
+    )
+    return True
+
+
+def create_invalidation(
+    distribution_id: str, paths: List[str], caller_reference: str
+) -> Dict[str, Any]:
+    """Invalidate CDN paths; repeating a caller reference returns the first invalidation"""
+    cloudfront = cloudfront_client()
+    response = cloudfront.create_invalidation(
+        DistributionId=distribution_id,
+        InvalidationBatch={
+            "Paths": {"Quantity": len(paths), "Items": paths},
+            "CallerReference": caller_reference,
+        },
+    )
+    invalidation = response["Invalidation"]
+    return {"id": invalidation["Id"], "status": invalidation["Status"]}
+
+
+def get_invalidation(distribution_id: str, invalidation_id: str) -> Dict[str, Any]:
+    """Return the status of a CDN invalidation, InProgress or Completed"""
+    cloudfront = cloudfront_client()
+    response = cloudfront.get_invalidation(DistributionId=distribution_id, Id=invalidation_id)
+    invalidation = response["Invalidation"]
+    return {"id": invalidation["Id"], "status": invalidation["Status"]}
+
+
+def get_state(table_name: str, key: str) -> Optional[Dict[str, Any]]:
+    """Read a state item with a consistent read; the caller checks expires_at"""
+    dynamodb = dynamodb_client()
+    response = dynamodb.get_item(
+        TableName=table_name, Key={"key": {"S": key}}, ConsistentRead=True
+    )
+    item = response.get("Item")
+    if not item:
+        return None
+    return {
+        "value": base64.b64encode(item["value"]["B"]).decode("ascii"),
+        "expires_at": int(item["expires_at"]["N"]) if "expires_at" in item else 0,
+        "version": item["version"]["S"],
+    }
+
+
+def put_state(
+    table_name: str,
+    key: str,
+    value: str,
+    expires_at: int,
+    version: str,
+    condition: str = "",
+    expected: str = "",
+    now: int = 0,
+) -> bool:
+    """Write a state item; False when condition ("absent" or "version") does not hold"""
+    dynamodb = dynamodb_client()
+    item = {
+        "key": {"S": key},
+        "value": {"B": base64.b64decode(value)},
+        "version": {"S": version},
+    }
+    if expires_at:
+        item["expires_at"] = {"N": str(expires_at)}
+    options: Dict[str, Any] = {}
+    if condition == "absent":
+        options["ConditionExpression"] = "attribute_not_exists(#k) OR #e <= :now"
+        options["ExpressionAttributeNames"] = {"#k": "key", "#e": "expires_at"}
+        options["ExpressionAttributeValues"] = {":now": {"N": str(now)}}
+    elif condition == "version":
+        options["ConditionExpression"] = "#v = :expected"
+        options["ExpressionAttributeNames"] = {"#v": "version"}
+        options["ExpressionAttributeValues"] = {":expected": {"S": expected}}
+    try:
+        dynamodb.put_item(TableName=table_name, Item=item, **options)
+    except dynamodb.exceptions.ConditionalCheckFailedException:
+        return False
+    return True
+
+
+def delete_state(table_name: str, keys: List[str]) -> bool:
+    """Delete state items; missing keys are ignored"""
+    dynamodb = dynamodb_client()
+    for key in keys:
+        dynamodb.delete_item(TableName=table_name, Key={"key": {"S": key}})
+    return True
+
+
+def describe_state_table(table_name: str) -> str:
+    """Return the state table's status, failing when it does not exist"""
+    dynamodb = dynamodb_client()
+    return dynamodb.describe_table(TableName=table_name)["Table"]["TableStatus"]